@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// perIPListener caps the number of concurrently open connections from each
+// remote IP. Connections over the cap are closed straight after Accept, before
+// any HTTP parsing, so slow clients cannot tie up more than their share.
+type perIPListener struct {
+	net.Listener
+	limit int
+
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+// limitConnsPerIP wraps l; a limit of zero or less returns l unchanged
+func limitConnsPerIP(l net.Listener, limit int) net.Listener {
+	if limit <= 0 {
+		return l
+	}
+	return &perIPListener{Listener: l, limit: limit, conns: make(map[string]int)}
+}
+
+func (l *perIPListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := remoteIP(conn)
+		if !l.acquire(ip) {
+			conn.Close()
+			continue
+		}
+		return &trackedConn{Conn: conn, release: func() { l.release(ip) }}, nil
+	}
+}
+
+func (l *perIPListener) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip] >= l.limit {
+		return false
+	}
+	l.conns[ip]++
+	return true
+}
+
+func (l *perIPListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip] <= 1 {
+		delete(l.conns, ip)
+		return
+	}
+	l.conns[ip]--
+}
+
+// trackedConn gives its slot back exactly once, however many times Close is called
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// acceptLoop accepts connections and keeps them open, reporting each one
+func acceptLoop(l net.Listener) <-chan net.Conn {
+	accepted := make(chan net.Conn, 16)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	return accepted
+}
+
+// refused reports whether the server closed conn without it being accepted
+func refused(t *testing.T, conn net.Conn) bool {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err := conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false
+	}
+	return err != nil
+}
+
+func TestLimitConnsPerIP(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l := limitConnsPerIP(inner, 3)
+	defer l.Close()
+	accepted := acceptLoop(l)
+
+	var clients []net.Conn
+	for i := 0; i < 6; i++ {
+		c, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		defer c.Close()
+		clients = append(clients, c)
+	}
+
+	var serverSide []net.Conn
+	for i := 0; i < 3; i++ {
+		select {
+		case c := <-accepted:
+			serverSide = append(serverSide, c)
+		case <-time.After(time.Second):
+			t.Fatal("expected connection within the cap to be accepted")
+		}
+	}
+
+	// Connections beyond the cap are closed by the listener
+	for _, c := range clients[3:] {
+		assert.True(t, refused(t, c), "excess connection should be refused")
+	}
+	for _, c := range clients[:3] {
+		assert.False(t, refused(t, c), "connection within the cap should stay open")
+	}
+	select {
+	case <-accepted:
+		t.Fatal("excess connection must not reach the server")
+	default:
+	}
+
+	// Closing one frees a slot for a new connection
+	serverSide[0].Close()
+	serverSide[0].Close() // double close must not release twice
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("freed slot should admit a new connection")
+	}
+
+	extra, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer extra.Close()
+	assert.True(t, refused(t, extra), "cap must still hold after a slot is reused")
+}
+
+func TestLimitConnsPerIPDisabled(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer inner.Close()
+
+	assert.Equal(t, inner, limitConnsPerIP(inner, 0))
+}
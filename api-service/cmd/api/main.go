@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,6 +16,14 @@ import (
 
 	"azlo-goboiler/internal/config"
 	"azlo-goboiler/internal/database"
+	"azlo-goboiler/internal/dbschema"
+	"azlo-goboiler/internal/handlers"
+	"azlo-goboiler/internal/httpclient"
+	"azlo-goboiler/internal/jwtkeys"
+	"azlo-goboiler/internal/logging"
+	"azlo-goboiler/internal/middleware"
+	"azlo-goboiler/internal/readiness"
+	"azlo-goboiler/internal/repository"
 	"azlo-goboiler/internal/router"
 	"azlo-goboiler/internal/telemetry"
 
@@ -27,13 +36,13 @@ import (
 
 var (
 	// Version information (set during build)
-	version   = "1.0.1"
+	version   = "2.0.0"
 	buildTime = "unknown"
 	gitCommit = "unknown"
 )
 
 // @title           Azlo Go Boilerplate API
-// @version         1.0.1
+// @version         2.0.0
 // @description     Production-ready SaaS starter kit API.
 // @termsOfService  http://swagger.io/terms/
 
@@ -83,13 +92,31 @@ func main() {
 		}
 	}
 
-	// Set log level based on environment
-	if cfg.App_Env == "development" {
-		logger = logger.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	} else {
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	// Switch to the configured log destinations
+	loggers, err := logging.New(&cfg)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to initialize loggers")
+	}
+	defer loggers.Close()
+	logger = loggers.App
+
+	// Tracks database reachability after startup for the readiness probe
+	dbMonitor := readiness.NewMonitor(cfg.GetDBReconnectInterval(), logger)
+
+	// Schema names must be set before the first query
+	if err := dbschema.Configure(dbschema.Names{Auth: cfg.DbAuthSchema, App: cfg.DbAppSchema}); err != nil {
+		logger.Fatal().Err(err).Msg("Invalid database schema configuration")
+	}
+
+	if err := middleware.ConfigureErrorPage(cfg.ErrorPageTemplate); err != nil {
+		logger.Fatal().Err(err).Msg("Invalid ERROR_PAGE_TEMPLATE")
+	}
+
+	// Signing keys must be loaded before the first token is issued
+	if err := jwtkeys.Configure(jwtkeys.Config{PrivateKeyPath: cfg.JWTPrivateKeyPath, VerifyKeyPaths: cfg.JWTVerifyKeyPaths}); err != nil {
+		logger.Fatal().Err(err).Msg("Invalid JWT signing keys")
 	}
+	logger.Info().Str("algorithm", jwtkeys.Algorithm()).Msg("JWT signing configured")
 
 	// Database Connection with retry logic
 	var db *pgxpool.Pool
@@ -102,6 +129,9 @@ func main() {
 			logger.Info().Msg("Constructing database DSN from individual environment variables")
 			dsn = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 				cfg.DbHost, cfg.DbPort, cfg.DbUser, cfg.DbPassword, cfg.DbName, cfg.DbSslMode)
+			if cfg.DbSslRootCert != "" {
+				dsn += " sslrootcert=" + cfg.DbSslRootCert
+			}
 		}
 
 		dbConfig := &database.DatabaseConfig{
@@ -110,6 +140,7 @@ func main() {
 			MaxConnLifetime:   time.Duration(getEnvInt("DB_MAX_CONN_LIFETIME_MINUTES", 60)) * time.Minute,
 			MaxConnIdleTime:   time.Duration(getEnvInt("DB_MAX_CONN_IDLE_MINUTES", 30)) * time.Minute,
 			HealthCheckPeriod: time.Duration(getEnvInt("DB_HEALTH_CHECK_MINUTES", 5)) * time.Minute,
+			Monitor:           dbMonitor,
 		}
 
 		db, err = database.ConnectDBWithConfig(dsn, dbConfig)
@@ -129,8 +160,36 @@ func main() {
 	}
 	defer db.Close()
 
+	// Prime MinConns now rather than on the first requests. A partial warmup
+	// is only logged: the pool keeps connecting on demand.
+	if cfg.DBPoolWarmup {
+		minConns := int(db.Config().MinConns)
+		warmCtx, warmCancel := context.WithTimeout(context.Background(), cfg.GetDBPoolWarmupTimeout())
+		start := time.Now()
+		warmed, err := database.WarmupPool(warmCtx, db, minConns)
+		warmCancel()
+		event := logger.Info()
+		if err != nil {
+			event = logger.Warn().Err(err)
+		}
+		event.
+			Int("connections", warmed).
+			Int("min_conns", minConns).
+			Dur("duration", time.Since(start)).
+			Msg("Database pool warmup finished")
+	}
+
 	// Initialize OpenTelemetry Tracer
-	tp, err := telemetry.InitTracerProvider(cfg.OtelEndpoint)
+	tp, err := telemetry.InitTracerProvider(telemetry.ExporterConfig{
+		Endpoint: cfg.OtelEndpoint,
+		Protocol: cfg.GetOtelProtocol(),
+		Insecure: cfg.OtelInsecure,
+		Headers:  cfg.GetOtelHeaders(),
+	}, telemetry.SamplingConfig{
+		Ratio:       cfg.TraceSampleRatio,
+		HealthRatio: cfg.TraceHealthRatio,
+		SlowAfter:   cfg.GetTraceSlowThreshold(),
+	})
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to initialize TracerProvider")
 	}
@@ -139,82 +198,132 @@ func main() {
 	app := &config.Application{
 		Config:         cfg,
 		Logger:         logger,
+		AccessLogger:   loggers.Access,
 		DB:             db,
 		TracerProvider: tp,
+		Readiness:      dbMonitor,
+		HTTPClient:     httpclient.New(cfg.GetHTTPClientTimeout(), cfg.PropagateRequestID),
+		Metrics:        router.NewMetricsRegistry(),
+		Build:          config.BuildInfo{Version: version, Commit: gitCommit, BuildTime: buildTime},
 	}
 
-	// Initialize database schema
-	if err := database.InitializeSchema(db); err != nil {
-		logger.Fatal().Err(err).Msg("Failed to initialize database schema")
+	// Apply the schema, or with RUN_MIGRATIONS=false check that the migrate
+	// job already has
+	if cfg.RunMigrations {
+		if _, err := database.MigrateUp(db); err != nil {
+			logger.Fatal().Err(err).Msg("Failed to initialize database schema")
+		}
+	} else {
+		checkCtx, checkCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := database.CheckSchemaVersion(checkCtx, db, database.RequiredSchemaVersion())
+		checkCancel()
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Refusing to start against an unmigrated database")
+		}
+		logger.Info().Int("schema_version", database.RequiredSchemaVersion()).Msg("Skipping migrations, schema is up to date")
 	}
 
 	// Seed default user in development
 	database.SeedDefaultUser(app)
 
+	// Bootstrap the first admin when INITIAL_ADMIN_* is configured
+	seedCtx, seedCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err = database.SeedInitialAdmin(seedCtx, repository.NewUserRepository(db), &cfg, logger)
+	seedCancel()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to seed initial admin")
+	}
+
 	// Start database connection monitoring
 	database.StartConnectionMonitoring(db)
 
-	// Redis Connection with retry logic
-	var redisClient *redis.Client
-	for attempts := 0; attempts < 5; attempts++ {
-		redisAddr := fmt.Sprintf("%s:%d", cfg.RedisHost, cfg.RedisPort)
-		redisClient = redis.NewClient(&redis.Options{
-			Addr:         redisAddr,
-			Password:     cfg.RedisPassword,
-			DB:           0,
-			MaxRetries:   3,
-			DialTimeout:  5 * time.Second,
-			ReadTimeout:  3 * time.Second,
-			WriteTimeout: 3 * time.Second,
-			PoolSize:     10,
-			MinIdleConns: 5,
-		})
-		redisClient.AddHook(redisotel.NewTracingHook())
-
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		_, err := redisClient.Ping(ctx).Result()
-		cancel()
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	go dbMonitor.Run(monitorCtx, db)
 
+	// Off-hours pool shrinking; disabled unless DB_REAP_SCHEDULE is set
+	if cfg.DBReapSchedule != "" {
+		schedule, err := database.ParseReapSchedule(cfg.DBReapSchedule, cfg.DBReapIdleConns)
 		if err != nil {
-			logger.Warn().
-				Err(err).
-				Int("attempt", attempts+1).
-				Msg("Redis connection failed, retrying...")
+			logger.Fatal().Err(err).Msg("Invalid DB_REAP_SCHEDULE")
+		}
+		go database.RunReaper(monitorCtx, db, schedule, logger)
+	}
 
-			if attempts < 8 {
-				time.Sleep(time.Duration(attempts+1) * 2 * time.Second)
-				continue
+	// Redis Connection with retry logic. Without REDIS_HOST, sessions, rate
+	// limits and the other shared state stay in this process
+	if cfg.RedisHost == "" {
+		logger.Warn().Msg("REDIS_HOST is empty: using in-memory stores, which are not shared between instances")
+	} else {
+		var redisClient *redis.Client
+		for attempts := 0; attempts < 5; attempts++ {
+			redisAddr := fmt.Sprintf("%s:%d", cfg.RedisHost, cfg.RedisPort)
+			redisClient = redis.NewClient(&redis.Options{
+				Addr:         redisAddr,
+				Password:     cfg.RedisPassword,
+				DB:           0,
+				MaxRetries:   3,
+				DialTimeout:  5 * time.Second,
+				ReadTimeout:  3 * time.Second,
+				WriteTimeout: 3 * time.Second,
+				PoolSize:     10,
+				MinIdleConns: 5,
+			})
+			redisClient.AddHook(redisotel.NewTracingHook())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_, err := redisClient.Ping(ctx).Result()
+			cancel()
+
+			if err != nil {
+				logger.Warn().
+					Err(err).
+					Int("attempt", attempts+1).
+					Msg("Redis connection failed, retrying...")
+
+				if attempts < 8 {
+					time.Sleep(time.Duration(attempts+1) * 2 * time.Second)
+					continue
+				}
+				logger.Fatal().Err(err).Msg("Redis connection failed after all retries")
 			}
-			logger.Fatal().Err(err).Msg("Redis connection failed after all retries")
+			break
 		}
-		break
-	}
-	defer redisClient.Close()
-	logger.Info().Msg("Redis client initialized")
+		defer redisClient.Close()
+		logger.Info().Msg("Redis client initialized")
 
-	// Update Application Context with Redis client
-	app.Redis = redisClient
+		// Update Application Context with Redis client
+		app.Redis = redisClient
+	}
 
 	// Server Setup with production-ready timeouts
+	handler, background := router.Setup(app)
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.Port),
-		Handler:      router.Setup(app),
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
+		Handler:      handler,
+		ReadTimeout:  cfg.GetServerReadTimeout(),
+		WriteTimeout: cfg.GetServerWriteTimeout(), // outlasts the request timeout; see Config.Validate
 		IdleTimeout:  60 * time.Second,
 		// Add additional security headers
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to listen")
+	}
+	listener = limitConnsPerIP(listener, cfg.MaxConnsPerIP)
+
 	// Start server in a goroutine
 	serverErrors := make(chan error, 1)
 	go func() {
 		logger.Info().
 			Int("port", cfg.Port).
 			Str("env", cfg.App_Env).
+			Int("max_conns_per_ip", cfg.MaxConnsPerIP).
 			Msg("Starting HTTP server")
 
-		serverErrors <- srv.ListenAndServe()
+		serverErrors <- srv.Serve(listener)
 	}()
 
 	// Enhanced Graceful Shutdown
@@ -231,13 +340,13 @@ func main() {
 			Str("signal", sig.String()).
 			Msg("Received shutdown signal, starting graceful shutdown...")
 
-		gracefulShutdown(srv, app, logger)
+		gracefulShutdown(srv, app, background, logger)
 	}
 
 	logger.Info().Msg("Server stopped gracefully")
 }
 
-// initLogger initializes the global logger
+// initLogger initializes the bootstrap logger used until configuration is loaded
 func initLogger() zerolog.Logger {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	logger := log.With().
@@ -248,15 +357,52 @@ func initLogger() zerolog.Logger {
 	return logger
 }
 
-// gracefulShutdown handles the graceful shutdown process
-func gracefulShutdown(srv *http.Server, app *config.Application, logger zerolog.Logger) {
-	// Create shutdown context with timeout
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// gracefulShutdown handles the graceful shutdown process. The instance stops
+// taking new requests first, then drains in-flight ones, and only then closes
+// the connections those requests, and the work handlers left running after
+// them, may still be using.
+func gracefulShutdown(srv *http.Server, app *config.Application, background *handlers.Handlers, logger zerolog.Logger) {
+	// Flip readiness and close the shutdown gate: new requests other than
+	// probes get a 503
+	app.Readiness.Drain()
 
 	// Disable keep-alives to force existing connections to close
 	srv.SetKeepAlivesEnabled(false)
 
+	maxWait := app.Config.GetShutdownTimeout()
+	start := time.Now()
+
+	// Keep answering probes with "Shutting down" for SHUTDOWN_DRAIN_DELAY_SECONDS,
+	// so load balancers take the instance out of rotation before it goes away
+	if delay := app.Config.GetShutdownDrainDelay(); delay > 0 {
+		logger.Info().Dur("delay", delay).Msg("Waiting for load balancers to see the instance not ready...")
+		time.Sleep(delay)
+	}
+
+	// Move on as soon as in-flight requests finish, or at SHUTDOWN_TIMEOUT_SECONDS
+	drained := drainRequests(app.Readiness, maxWait-time.Since(start))
+	event := logger.Info()
+	if !drained {
+		event = logger.Warn()
+	}
+	event.
+		Bool("drained", drained).
+		Int64("in_flight", app.Readiness.InFlight()).
+		Dur("duration", time.Since(start)).
+		Msg("Request drain finished")
+
+	// What is left of the budget, with a floor so connections and the
+	// tracer still get a chance to close after a drain that ran out
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), max(maxWait-time.Since(start), 5*time.Second))
+	defer cancel()
+
+	logger.Info().Msg("Shutting down HTTP server...")
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error().Err(err).Msg("HTTP server shutdown error")
+	} else {
+		logger.Info().Msg("HTTP server shutdown complete")
+	}
+
 	// Shutdown OpenTelemetry TracerProvider
 	logger.Info().Msg("Shutting down OpenTelemetry TracerProvider...")
 	if err := app.TracerProvider.Shutdown(shutdownCtx); err != nil {
@@ -265,24 +411,27 @@ func gracefulShutdown(srv *http.Server, app *config.Application, logger zerolog.
 		logger.Info().Msg("TracerProvider shutdown complete")
 	}
 
+	// Let password reset emails and the like finish with the connections
+	logger.Info().Msg("Waiting for background work...")
+	if err := background.Wait(shutdownCtx); err != nil {
+		logger.Warn().Err(err).Msg("Background work still running at shutdown; it may fail")
+	} else {
+		logger.Info().Msg("Background work finished")
+	}
+
 	// Close database connections
 	logger.Info().Msg("Closing database connections...")
 	app.DB.Close()
 	logger.Info().Msg("Database connections closed")
 
 	// Close Redis connections
-	logger.Info().Msg("Closing Redis connections...")
-	if err := app.Redis.Close(); err != nil {
-		logger.Error().Err(err).Msg("Redis shutdown error")
-	} else {
-		logger.Info().Msg("Redis connections closed")
-	}
-
-	logger.Info().Msg("Shutting down HTTP server...")
-	if err := srv.Shutdown(shutdownCtx); err != nil {
-		logger.Error().Err(err).Msg("HTTP server shutdown error")
-	} else {
-		logger.Info().Msg("HTTP server shutdown complete")
+	if app.Redis != nil {
+		logger.Info().Msg("Closing Redis connections...")
+		if err := app.Redis.Close(); err != nil {
+			logger.Error().Err(err).Msg("Redis shutdown error")
+		} else {
+			logger.Info().Msg("Redis connections closed")
+		}
 	}
 
 	logger.Info().Msg("Graceful shutdown completed")
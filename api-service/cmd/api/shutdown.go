@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"azlo-goboiler/internal/readiness"
+)
+
+// drainPollInterval is how often shutdown checks for in-flight requests
+const drainPollInterval = 50 * time.Millisecond
+
+// drainRequests waits until monitor counts no requests in flight or maxWait
+// has passed, whichever comes first, and reports whether they all finished.
+// An idle instance returns at once instead of sitting out the timeout.
+func drainRequests(monitor *readiness.Monitor, maxWait time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), maxWait)
+	defer cancel()
+	return monitor.WaitIdle(ctx, drainPollInterval)
+}
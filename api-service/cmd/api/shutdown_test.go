@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"azlo-goboiler/internal/readiness"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainRequests(t *testing.T) {
+	t.Run("IdleReturnsAtOnce", func(t *testing.T) {
+		m := readiness.NewMonitor(time.Second, zerolog.Nop())
+
+		start := time.Now()
+		assert.True(t, drainRequests(m, 5*time.Second))
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("WaitsForInFlight", func(t *testing.T) {
+		m := readiness.NewMonitor(time.Second, zerolog.Nop())
+		m.RequestStarted()
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			m.RequestDone()
+		}()
+
+		start := time.Now()
+		assert.True(t, drainRequests(m, 5*time.Second))
+		elapsed := time.Since(start)
+		assert.GreaterOrEqual(t, elapsed, 200*time.Millisecond)
+		assert.Less(t, elapsed, 5*time.Second)
+	})
+
+	t.Run("StopsAtCap", func(t *testing.T) {
+		m := readiness.NewMonitor(time.Second, zerolog.Nop())
+		m.RequestStarted() // never finishes
+
+		start := time.Now()
+		assert.False(t, drainRequests(m, 300*time.Millisecond))
+		assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+		assert.Equal(t, int64(1), m.InFlight())
+	})
+}
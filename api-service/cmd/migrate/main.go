@@ -0,0 +1,85 @@
+// File: cmd/migrate/main.go
+//
+// migrate applies or reverts database migrations and exits. Run it as a
+// separate job (an init container, a release step) and start the API with
+// RUN_MIGRATIONS=false.
+//
+//	migrate up       apply all pending migrations
+//	migrate down     revert the latest migration
+//	migrate status   print the applied and required versions
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/database"
+	"azlo-goboiler/internal/dbschema"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	log.Logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
+
+	if len(os.Args) != 2 {
+		usage()
+	}
+	command := os.Args[1]
+	if command != "up" && command != "down" && command != "status" {
+		usage()
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+	if err := dbschema.Configure(dbschema.Names{Auth: cfg.DbAuthSchema, App: cfg.DbAppSchema}); err != nil {
+		log.Fatal().Err(err).Msg("Invalid database schema configuration")
+	}
+
+	db, err := database.ConnectDB(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Database connection failed")
+	}
+	defer db.Close()
+
+	switch command {
+	case "up":
+		version, err := database.MigrateUp(db)
+		if err != nil {
+			log.Fatal().Err(err).Int("version", version).Msg("Migration failed")
+		}
+		log.Info().Int("version", version).Msg("Database is up to date")
+
+	case "down":
+		version, err := database.MigrateDown(db)
+		if err != nil {
+			log.Fatal().Err(err).Int("version", version).Msg("Revert failed")
+		}
+		log.Info().Int("version", version).Msg("Reverted latest migration")
+
+	case "status":
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		version, err := database.SchemaVersion(ctx, db)
+		cancel()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to read schema version")
+		}
+		fmt.Printf("applied: %d\nrequired: %d\n", version, database.RequiredSchemaVersion())
+		if version < database.RequiredSchemaVersion() {
+			// Non-zero so scripts can gate a rollout on it
+			db.Close()
+			os.Exit(3)
+		}
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate up|down|status")
+	os.Exit(2)
+}
@@ -2,13 +2,24 @@ package config
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"reflect"
 	"strings"
 	"time"
 
+	"azlo-goboiler/internal/dbschema"
+	"azlo-goboiler/internal/readiness"
+
 	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 	"github.com/spf13/viper"
 	"go.opentelemetry.io/otel/sdk/trace"
@@ -18,43 +29,224 @@ import (
 type Application struct {
 	Config         Config
 	Logger         zerolog.Logger
+	AccessLogger   zerolog.Logger
 	DB             *pgxpool.Pool
 	Redis          *redis.Client
 	TracerProvider *trace.TracerProvider
+	Readiness      *readiness.Monitor
+	HTTPClient     *http.Client         // outbound calls; see internal/httpclient
+	Metrics        *prometheus.Registry // served on /metrics; see router.NewMetricsRegistry
+	Build          BuildInfo
+}
+
+// BuildInfo identifies the running binary. main sets it from build flags.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+}
+
+// ETag returns a strong ETag for a response that only changes between
+// builds. parts distinguish responses that also vary with, say, APP_ENV.
+func (b BuildInfo) ETag(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(append([]string{b.Version, b.Commit, b.BuildTime}, parts...), "\n")))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
 }
 
 // Config holds all the configuration variables for the application.
 type Config struct {
 	Port                 int      `mapstructure:"PORT"`
 	App_Env              string   `mapstructure:"APP_ENV"`
-	App_Secret           string   `mapstructure:"APP_SECRET"`
+	App_Secret           string   `mapstructure:"APP_SECRET" config:"required,secret"`
 	CORS_Allowed_Origins []string `mapstructure:"CORS_ALLOWED_ORIGINS"`
-	DatabaseURL          string   `mapstructure:"DATABASE_URL"`
+	CORS_Exposed_Headers []string `mapstructure:"CORS_EXPOSED_HEADERS"` // replaces the built-in list when set
+	DatabaseURL          string   `mapstructure:"DATABASE_URL" config:"secret"`
 	DbHost               string   `mapstructure:"DB_HOST"`
 	DbPort               int      `mapstructure:"DB_PORT"`
-	DbUser               string   `mapstructure:"DB_USER"`
-	DbPassword           string   `mapstructure:"DB_PASSWORD"`
-	DbName               string   `mapstructure:"DB_NAME"`
+	DbUser               string   `mapstructure:"DB_USER" config:"required"`
+	DbPassword           string   `mapstructure:"DB_PASSWORD" config:"required,secret"`
+	DbName               string   `mapstructure:"DB_NAME" config:"required"`
 	DbSslMode            string   `mapstructure:"DB_SSL_MODE"`
+	DbSslRootCert        string   `mapstructure:"DB_SSL_ROOT_CERT"`
+	DbAuthSchema         string   `mapstructure:"DB_AUTH_SCHEMA"`
+	DbAppSchema          string   `mapstructure:"DB_APP_SCHEMA"`
+	RunMigrations        bool     `mapstructure:"RUN_MIGRATIONS"`
+	ShutdownTimeout      int      `mapstructure:"SHUTDOWN_TIMEOUT_SECONDS"`
+	ShutdownDrainDelay   int      `mapstructure:"SHUTDOWN_DRAIN_DELAY_SECONDS"` // keep answering /ready this long before closing
+	TokenBytes           int      `mapstructure:"TOKEN_BYTES"`
 	OtelEndpoint         string   `mapstructure:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	OtelProtocol         string   `mapstructure:"OTEL_EXPORTER_OTLP_PROTOCOL"`
+	OtelInsecure         bool     `mapstructure:"OTEL_EXPORTER_OTLP_INSECURE"`
+	OtelHeaders          []string `mapstructure:"OTEL_EXPORTER_OTLP_HEADERS" config:"secret"`
+	TraceSampleRatio     float64  `mapstructure:"TRACE_SAMPLE_RATIO"`        // share of requests traced
+	TraceHealthRatio     float64  `mapstructure:"TRACE_HEALTH_SAMPLE_RATIO"` // share of health checks and /metrics traced
+	TraceSlowMS          int      `mapstructure:"TRACE_SLOW_MS"`             // slower requests are always traced; 0 disables
 	RedisHost            string   `mapstructure:"REDIS_HOST"`
 	RedisPort            int      `mapstructure:"REDIS_PORT"`
-	RedisPassword        string   `mapstructure:"REDIS_PASSWORD"`
+	RedisPassword        string   `mapstructure:"REDIS_PASSWORD" config:"secret"`
 	RateLimit            int      `mapstructure:"RATE_LIMIT"`
+	RateLimitWindow      int      `mapstructure:"RATE_LIMIT_WINDOW_SECONDS"`
+	RateLimitLocalCache  int      `mapstructure:"RATE_LIMIT_LOCAL_CACHE_MS"` // milliseconds; 0 checks Redis on every request
+	RateLimitKey         string   `mapstructure:"RATE_LIMIT_KEY"`            // ip, user or user_ip; see GetRateLimitKey
+	AbuseLimit           int      `mapstructure:"ABUSE_LIMIT"`               // failed (4xx) responses per client before it is refused; 0 disables
+	AbuseWindow          int      `mapstructure:"ABUSE_WINDOW_SECONDS"`
+	UserConcurrencyLimit int      `mapstructure:"USER_CONCURRENCY_LIMIT"`   // requests one user may have in progress at once; 0 disables
+	IdempotencyTTL       int      `mapstructure:"IDEMPOTENCY_TTL_SECONDS"`  // how long responses to Idempotency-Key requests are replayed
+	StaleIfError         int      `mapstructure:"STALE_IF_ERROR_SECONDS"`   // how old a cached read may be when served during a database outage; 0 disables
+	IPDenylist           []string `mapstructure:"IP_DENYLIST"`              // IPs or CIDRs refused on every route
+	AdminIPAllowlist     []string `mapstructure:"ADMIN_IP_ALLOWLIST"`       // IPs or CIDRs allowed on /api/v1/admin and /api/v1/users; empty allows any
+	MaintenanceAllowlist []string `mapstructure:"MAINTENANCE_IP_ALLOWLIST"` // IPs or CIDRs still served in maintenance mode
 	LogLevel             string   `mapstructure:"LOG_LEVEL"`
 	RequestTimeout       int      `mapstructure:"REQUEST_TIMEOUT_SECONDS"`
+	ServerReadTimeout    int      `mapstructure:"SERVER_READ_TIMEOUT_SECONDS"`  // 0 uses REQUEST_TIMEOUT_SECONDS
+	ServerWriteTimeout   int      `mapstructure:"SERVER_WRITE_TIMEOUT_SECONDS"` // 0 allows REQUEST_TIMEOUT_SECONDS plus a margin
 	JWTExpirationHours   int      `mapstructure:"JWT_EXPIRATION_HOURS"`
+	AccessTokenMinutes   int      `mapstructure:"ACCESS_TOKEN_MINUTES"`
+	JWTClockSkewSeconds  int      `mapstructure:"JWT_CLOCK_SKEW_SECONDS"`
+	JWTPrivateKeyPath    string   `mapstructure:"JWT_PRIVATE_KEY_PATH"` // PEM RSA key; set to sign with RS256 instead of HS256
+	JWTVerifyKeyPaths    []string `mapstructure:"JWT_VERIFY_KEY_PATHS"` // PEM public keys of retired signing keys, still accepted
+	AutoRefresh          bool     `mapstructure:"AUTO_REFRESH"`
+	RefreshExpiration    int      `mapstructure:"REFRESH_EXPIRATION_HOURS"`
 	DefaultUserUsername  string   `mapstructure:"DEFAULT_USER_USERNAME"`
-	DefaultUserPassword  string   `mapstructure:"DEFAULT_USER_PASSWORD"`
+	DefaultUserPassword  string   `mapstructure:"DEFAULT_USER_PASSWORD" config:"secret"`
+	InitialAdminUsername string   `mapstructure:"INITIAL_ADMIN_USERNAME"`
+	InitialAdminEmail    string   `mapstructure:"INITIAL_ADMIN_EMAIL"`
+	InitialAdminPassword string   `mapstructure:"INITIAL_ADMIN_PASSWORD" config:"secret"`
+	AuditMaxPageSize     int      `mapstructure:"AUDIT_MAX_PAGE_SIZE"`
+	HealthPingTimeoutMS  int      `mapstructure:"HEALTH_PING_TIMEOUT_MS"`
+	HealthCheckTimeoutMS int      `mapstructure:"HEALTH_CHECK_TIMEOUT_MS"`
+	HealthCheckReadOnly  bool     `mapstructure:"HEALTH_CHECK_READ_ONLY"`
+	DBReconnectInterval  int      `mapstructure:"DB_RECONNECT_INTERVAL_SECONDS"`
+	DBPoolWarmup         bool     `mapstructure:"DB_POOL_WARMUP"`
+	DBPoolWarmupTimeout  int      `mapstructure:"DB_POOL_WARMUP_TIMEOUT_SECONDS"`
+	DBReapSchedule       string   `mapstructure:"DB_REAP_SCHEDULE"`
+	DBReapIdleConns      int      `mapstructure:"DB_REAP_IDLE_CONNS"`
+	UsernameConfusables  bool     `mapstructure:"USERNAME_CONFUSABLE_CHECK"`
+	PropagateRequestID   bool     `mapstructure:"PROPAGATE_REQUEST_ID"`
+	HTTPClientTimeout    int      `mapstructure:"HTTP_CLIENT_TIMEOUT_SECONDS"`
+	SingleSession        bool     `mapstructure:"SINGLE_SESSION"`
+	RevocationFailOpen   bool     `mapstructure:"REVOCATION_FAIL_OPEN"`
+	OpenAPIEnabled       bool     `mapstructure:"OPENAPI_ENABLED"`
+	MetricsPathLabels    bool     `mapstructure:"METRICS_PATH_LABELS"`
+	APIFormat            string   `mapstructure:"API_FORMAT"`          // "envelope" (default) or "jsonapi"
+	ErrorFormat          string   `mapstructure:"ERROR_FORMAT"`        // "envelope" (default) or "problem" for RFC 7807
+	ErrorPageTemplate    string   `mapstructure:"ERROR_PAGE_TEMPLATE"` // html/template file for browser error pages; empty uses the built-in page
+	MaxConnsPerIP        int      `mapstructure:"MAX_CONNS_PER_IP"`    // 0 disables; behind a proxy all connections share its IP
+	PublicURL            string   `mapstructure:"PUBLIC_URL"`          // base URL used in links sent by email
+	CookieSameSite       string   `mapstructure:"COOKIE_SAMESITE"`     // lax (default), strict or none
+	RejectGETBody        bool     `mapstructure:"REJECT_GET_BODY"`     // 400 on bodies sent with GET/HEAD/DELETE/OPTIONS instead of ignoring them
+	MaxBodyBytes         int      `mapstructure:"MAX_BODY_BYTES"`      // largest request body; routes may override it
+	CompressionEnabled   bool     `mapstructure:"COMPRESSION_ENABLED"` // gzip/deflate responses for clients that accept them
+	CompressMinBytes     int      `mapstructure:"COMPRESS_MIN_BYTES"`  // smaller responses are sent uncompressed
+	SMTPHost             string   `mapstructure:"SMTP_HOST"`
+	SMTPPort             int      `mapstructure:"SMTP_PORT"`
+	SMTPUser             string   `mapstructure:"SMTP_USER"`
+	SMTPPassword         string   `mapstructure:"SMTP_PASSWORD" config:"secret"`
+	SMTPFrom             string   `mapstructure:"SMTP_FROM"`
+	// Google sign-in is enabled when the client ID and secret are both set
+	GoogleClientID     string `mapstructure:"GOOGLE_CLIENT_ID"`
+	GoogleClientSecret string `mapstructure:"GOOGLE_CLIENT_SECRET" config:"secret"`
+	GoogleRedirectURL  string `mapstructure:"GOOGLE_REDIRECT_URL"` // defaults to PUBLIC_URL + /auth/oauth/google/callback
+	// Trusted header authentication is enabled when the header is set. Only
+	// requests connecting from the proxy CIDRs may use it, and only their
+	// X-Forwarded-For and X-Real-IP headers are believed.
+	TrustedAuthHeader      string   `mapstructure:"TRUSTED_AUTH_HEADER"`       // e.g. X-Auth-Request-User
+	TrustedAuthEmailHeader string   `mapstructure:"TRUSTED_AUTH_EMAIL_HEADER"` // e.g. X-Auth-Request-Email, for new users
+	TrustedAuthCreateUsers bool     `mapstructure:"TRUSTED_AUTH_CREATE_USERS"`
+	TrustedProxyCIDRs      []string `mapstructure:"TRUSTED_PROXY_CIDRS"`
+	// Bcrypt pool: workers (0 uses GOMAXPROCS), how many may queue for one,
+	// and how long each may wait before the request is shed with a 429
+	BcryptWorkers   int `mapstructure:"BCRYPT_WORKERS"`
+	BcryptQueueSize int `mapstructure:"BCRYPT_QUEUE_SIZE"`
+	BcryptMaxWaitMS int `mapstructure:"BCRYPT_MAX_WAIT_MS"`
+	// How many recent passwords, the current one included, a new password
+	// may not match; 0 allows reuse. Each costs a bcrypt comparison.
+	PasswordHistoryCount int `mapstructure:"PASSWORD_HISTORY_COUNT"`
+
+	Security SecurityHeadersConfig `mapstructure:",squash"`
+	Log      LogConfig             `mapstructure:",squash"`
 }
 
+// LogConfig selects where the application and access logs are written.
+// Output is one of stderr, stdout or file; file output is rotated by size.
+type LogConfig struct {
+	Output         string `mapstructure:"LOG_OUTPUT"`
+	FilePath       string `mapstructure:"LOG_FILE_PATH"`
+	AccessOutput   string `mapstructure:"ACCESS_LOG_OUTPUT"` // empty shares the app log destination
+	AccessFilePath string `mapstructure:"ACCESS_LOG_FILE_PATH"`
+	MaxSizeMB      int    `mapstructure:"LOG_FILE_MAX_SIZE_MB"`
+	MaxAgeDays     int    `mapstructure:"LOG_FILE_MAX_AGE_DAYS"`
+	MaxBackups     int    `mapstructure:"LOG_FILE_MAX_BACKUPS"`
+	Compress       bool   `mapstructure:"LOG_FILE_COMPRESS"`
+	// RedactQueryParams names query parameters whose values are masked in access logs
+	RedactQueryParams []string `mapstructure:"LOG_REDACT_QUERY_PARAMS"`
+}
+
+// DefaultRedactedQueryParams are masked in access logs unless overridden
+var DefaultRedactedQueryParams = []string{"token", "code", "secret", "key", "password"}
+
+// SecurityHeadersConfig controls the response headers set by the Security middleware.
+// The defaults are strict; deployments that embed the app or load assets from a CDN
+// can relax them per environment.
+type SecurityHeadersConfig struct {
+	CSP                   string `mapstructure:"SECURITY_CSP"`
+	SwaggerCSP            string `mapstructure:"SECURITY_SWAGGER_CSP"`
+	HSTSMaxAge            int    `mapstructure:"SECURITY_HSTS_MAX_AGE"` // 0 omits the header
+	HSTSIncludeSubdomains bool   `mapstructure:"SECURITY_HSTS_INCLUDE_SUBDOMAINS"`
+	HSTSPreload           bool   `mapstructure:"SECURITY_HSTS_PRELOAD"`
+	FrameOptions          string `mapstructure:"SECURITY_FRAME_OPTIONS"` // empty omits the header
+	PermissionsPolicy     string `mapstructure:"SECURITY_PERMISSIONS_POLICY"`
+	CSPReportURI          string `mapstructure:"SECURITY_CSP_REPORT_URI"` // where browsers report violations; empty adds no reporting
+}
+
+const (
+	// DefaultCSP blocks inline scripts for the API and app
+	DefaultCSP = "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline' https://fonts.googleapis.com https://cdn.jsdelivr.net; font-src 'self' https://fonts.gstatic.com https://cdn.jsdelivr.net; img-src 'self' data:; connect-src 'self'; frame-ancestors 'none'"
+	// DefaultCSPReportURI is the API's own violation report endpoint
+	DefaultCSPReportURI = "/csp-report"
+	// DefaultSwaggerCSP additionally allows the inline scripts Swagger UI needs
+	DefaultSwaggerCSP = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline' https://fonts.googleapis.com https://cdn.jsdelivr.net; font-src 'self' https://fonts.gstatic.com https://cdn.jsdelivr.net; img-src 'self' data:; connect-src 'self'; frame-ancestors 'none'"
+)
+
 type ContextKey string
 
 const (
 	UserIDKey    = ContextKey("userID")
 	RequestIDKey = ContextKey("request_id")
+	// UserRoleKey holds the role carried by the session token; see middleware.RequireRole
+	UserRoleKey = ContextKey("user_role")
+	// MustChangePasswordKey is true for a session whose password must be changed first
+	MustChangePasswordKey = ContextKey("must_change_password")
+	// APIKeyScopesKey holds the scopes of the API key that authenticated the request
+	APIKeyScopesKey = ContextKey("api_key_scopes")
+	// APIVersionKey holds the response version negotiated from the Accept header
+	APIVersionKey = ContextKey("api_version")
+	// ClientIPKey holds the client IP resolved by middleware.RealIP
+	ClientIPKey = ContextKey("client_ip")
+)
+
+// Auth cookies and token claims shared by the login handler and JWT middleware
+const (
+	AuthCookieName    = "jwt_token"
+	RefreshCookieName = "refresh_token"
+	TokenIssuer       = "go-api-boilerplate"
 )
 
+// DBApplicationName is the application_name set on every pool connection.
+// The admin session endpoints only see and cancel connections carrying it.
+const DBApplicationName = "go-api-boilerplate"
+
+// AccessClaims are the claims of a session access token. Role is copied from
+// the user when the token is issued, so a role change reaches the session at
+// its next refresh.
+type AccessClaims struct {
+	jwt.RegisteredClaims
+	Role string `json:"role,omitempty"`
+	// MustChangePassword marks a session that may only change the password;
+	// see middleware.PasswordChangeGate
+	MustChangePassword bool `json:"must_change_password,omitempty"`
+}
+
 // Load reads configuration from secrets, environment variables, or defaults.
 func Load() (config Config, err error) {
 	// 1. Determine Environment First
@@ -66,30 +258,7 @@ func Load() (config Config, err error) {
 	viper.Set("APP_ENV", env)
 
 	// 2. Set Defaults based on Environment
-	if env == "production" {
-		viper.SetDefault("PORT", 8080)
-		viper.SetDefault("RATE_LIMIT", 1000)
-		viper.SetDefault("LOG_LEVEL", "info")
-		viper.SetDefault("REQUEST_TIMEOUT_SECONDS", 30)
-		viper.SetDefault("JWT_EXPIRATION_HOURS", 24)
-	} else {
-		viper.SetDefault("PORT", 8080)
-		viper.SetDefault("RATE_LIMIT", 100)
-		viper.SetDefault("LOG_LEVEL", "debug")
-		viper.SetDefault("REQUEST_TIMEOUT_SECONDS", 60)
-		viper.SetDefault("JWT_EXPIRATION_HOURS", 168)
-		viper.SetDefault("DEFAULT_USER_USERNAME", "admin")
-		viper.SetDefault("DEFAULT_USER_PASSWORD", "admin123!")
-	}
-
-	// Universal Defaults
-	viper.SetDefault("CORS_ALLOWED_ORIGINS", []string{"https://localhost", "https://localhost:443"})
-	viper.SetDefault("DB_HOST", "localhost")
-	viper.SetDefault("DB_PORT", 5432)
-	viper.SetDefault("DB_SSL_MODE", "disable")
-	viper.SetDefault("REDIS_HOST", "localhost")
-	viper.SetDefault("REDIS_PORT", 6379)
-	viper.SetDefault("OTEL_EXPORTER_OTLP_ENDPOINT", "tempo:4318")
+	setDefaults(viper.GetViper(), env)
 
 	// 3. Conditional Loading Logic
 	if env == "development" {
@@ -109,10 +278,13 @@ func Load() (config Config, err error) {
 		loadSecret("REDIS_HOST", "redis_host")
 		loadSecret("REDIS_PORT", "redis_port")
 		loadSecret("REDIS_PASSWORD", "redis_password")
+		loadSecret("SMTP_PASSWORD", "smtp_password")
+		loadSecret("INITIAL_ADMIN_PASSWORD", "initial_admin_password")
 	}
 
 	// 4. AutomaticEnv (System Env Vars override everything loaded so far)
 	viper.AutomaticEnv()
+	bindEnvs(viper.GetViper())
 
 	// 5. Unmarshal
 	err = viper.Unmarshal(&config)
@@ -121,15 +293,149 @@ func Load() (config Config, err error) {
 	}
 
 	// 7. Post-Load Logic
+	// An empty OTEL_EXPORTER_OTLP_ENDPOINT turns tracing off; Viper reads an
+	// empty variable as unset and would fall back to the default
+	if endpoint, ok := os.LookupEnv("OTEL_EXPORTER_OTLP_ENDPOINT"); ok && strings.TrimSpace(endpoint) == "" {
+		config.OtelEndpoint = ""
+	}
+	// Likewise an empty REDIS_HOST runs without Redis, on in-memory stores
+	if host, ok := os.LookupEnv("REDIS_HOST"); ok && strings.TrimSpace(host) == "" {
+		config.RedisHost = ""
+	}
 	if config.DatabaseURL == "" {
 		config.DatabaseURL = fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
 			config.DbUser, config.DbPassword, config.DbHost, config.DbPort, config.DbName, config.DbSslMode,
 		)
+		if config.DbSslRootCert != "" {
+			config.DatabaseURL += "&sslrootcert=" + url.QueryEscape(config.DbSslRootCert)
+		}
 	}
 
 	return
 }
 
+// setDefaults registers every default on v. Load applies them to the global
+// Viper; Schema reads them back from a private instance.
+func setDefaults(v *viper.Viper, env string) {
+	if env == "production" {
+		v.SetDefault("PORT", 8080)
+		v.SetDefault("RATE_LIMIT", 1000)
+		v.SetDefault("LOG_LEVEL", "info")
+		v.SetDefault("REQUEST_TIMEOUT_SECONDS", 30)
+		v.SetDefault("JWT_EXPIRATION_HOURS", 24)
+	} else {
+		v.SetDefault("PORT", 8080)
+		v.SetDefault("RATE_LIMIT", 100)
+		v.SetDefault("LOG_LEVEL", "debug")
+		v.SetDefault("REQUEST_TIMEOUT_SECONDS", 60)
+		v.SetDefault("JWT_EXPIRATION_HOURS", 168)
+		v.SetDefault("DEFAULT_USER_USERNAME", "admin")
+		v.SetDefault("DEFAULT_USER_PASSWORD", "admin123!")
+	}
+
+	// Universal Defaults
+	v.SetDefault("CORS_ALLOWED_ORIGINS", []string{"https://localhost", "https://localhost:443"})
+	v.SetDefault("DB_HOST", "localhost")
+	v.SetDefault("DB_PORT", 5432)
+	v.SetDefault("DB_SSL_MODE", "disable")
+	v.SetDefault("REDIS_HOST", "localhost")
+	v.SetDefault("REDIS_PORT", 6379)
+	v.SetDefault("OTEL_EXPORTER_OTLP_ENDPOINT", "tempo:4318")
+	v.SetDefault("OTEL_EXPORTER_OTLP_PROTOCOL", OtelProtocolHTTP)
+	v.SetDefault("OTEL_EXPORTER_OTLP_INSECURE", true)
+	v.SetDefault("TRACE_SAMPLE_RATIO", 1.0)
+	v.SetDefault("TRACE_HEALTH_SAMPLE_RATIO", 0.01)
+	v.SetDefault("TRACE_SLOW_MS", 1000)
+	v.SetDefault("JWT_CLOCK_SKEW_SECONDS", 30)
+	v.SetDefault("ACCESS_TOKEN_MINUTES", 15)
+	v.SetDefault("AUTO_REFRESH", false)
+	v.SetDefault("REFRESH_EXPIRATION_HOURS", 720)
+	v.SetDefault("AUDIT_MAX_PAGE_SIZE", 100)
+	v.SetDefault("HEALTH_PING_TIMEOUT_MS", 2000)
+	v.SetDefault("HEALTH_CHECK_TIMEOUT_MS", 5000)
+	v.SetDefault("HEALTH_CHECK_READ_ONLY", false)
+	v.SetDefault("DB_RECONNECT_INTERVAL_SECONDS", 2)
+	v.SetDefault("DB_POOL_WARMUP", true)
+	v.SetDefault("DB_POOL_WARMUP_TIMEOUT_SECONDS", 10)
+	v.SetDefault("DB_REAP_SCHEDULE", "")
+	v.SetDefault("DB_REAP_IDLE_CONNS", 0)
+	v.SetDefault("API_FORMAT", "envelope")
+	v.SetDefault("ERROR_FORMAT", ErrorFormatEnvelope)
+	v.SetDefault("MAX_CONNS_PER_IP", 0)
+	v.SetDefault("USERNAME_CONFUSABLE_CHECK", true)
+	v.SetDefault("PROPAGATE_REQUEST_ID", true)
+	v.SetDefault("HTTP_CLIENT_TIMEOUT_SECONDS", 10)
+	v.SetDefault("SINGLE_SESSION", false)
+	v.SetDefault("REVOCATION_FAIL_OPEN", false)
+	v.SetDefault("OPENAPI_ENABLED", true)
+	v.SetDefault("METRICS_PATH_LABELS", true)
+	v.SetDefault("DB_AUTH_SCHEMA", dbschema.DefaultAuth)
+	v.SetDefault("DB_APP_SCHEMA", dbschema.DefaultApp)
+	v.SetDefault("RUN_MIGRATIONS", true)
+	v.SetDefault("SHUTDOWN_TIMEOUT_SECONDS", 30)
+	v.SetDefault("SHUTDOWN_DRAIN_DELAY_SECONDS", 0)
+	v.SetDefault("TOKEN_BYTES", 32)
+	v.SetDefault("SERVER_READ_TIMEOUT_SECONDS", 0)
+	v.SetDefault("SERVER_WRITE_TIMEOUT_SECONDS", 0)
+	v.SetDefault("RATE_LIMIT_WINDOW_SECONDS", 60)
+	v.SetDefault("RATE_LIMIT_LOCAL_CACHE_MS", 0)
+	v.SetDefault("RATE_LIMIT_KEY", RateLimitKeyIP)
+	v.SetDefault("ABUSE_LIMIT", 0)
+	v.SetDefault("ABUSE_WINDOW_SECONDS", 600)
+	v.SetDefault("USER_CONCURRENCY_LIMIT", 0)
+	v.SetDefault("IDEMPOTENCY_TTL_SECONDS", 86400)
+	v.SetDefault("STALE_IF_ERROR_SECONDS", 0)
+	v.SetDefault("PUBLIC_URL", "https://localhost")
+	v.SetDefault("COOKIE_SAMESITE", "lax")
+	v.SetDefault("REJECT_GET_BODY", false)
+	v.SetDefault("MAX_BODY_BYTES", DefaultMaxBodyBytes)
+	v.SetDefault("COMPRESSION_ENABLED", true)
+	v.SetDefault("COMPRESS_MIN_BYTES", 1024)
+	v.SetDefault("BCRYPT_WORKERS", 0)
+	v.SetDefault("BCRYPT_QUEUE_SIZE", 64)
+	v.SetDefault("BCRYPT_MAX_WAIT_MS", 2000)
+	v.SetDefault("PASSWORD_HISTORY_COUNT", 0)
+	v.SetDefault("SMTP_PORT", 587)
+	v.SetDefault("SECURITY_CSP", DefaultCSP)
+	v.SetDefault("SECURITY_SWAGGER_CSP", DefaultSwaggerCSP)
+	v.SetDefault("SECURITY_CSP_REPORT_URI", DefaultCSPReportURI)
+	v.SetDefault("SECURITY_HSTS_MAX_AGE", 63072000)
+	v.SetDefault("SECURITY_HSTS_INCLUDE_SUBDOMAINS", true)
+	v.SetDefault("SECURITY_HSTS_PRELOAD", true)
+	v.SetDefault("SECURITY_FRAME_OPTIONS", "DENY")
+	v.SetDefault("SECURITY_PERMISSIONS_POLICY", "camera=(), microphone=(), geolocation=()")
+	v.SetDefault("LOG_OUTPUT", "stderr")
+	v.SetDefault("LOG_FILE_MAX_SIZE_MB", 100)
+	v.SetDefault("LOG_FILE_MAX_AGE_DAYS", 28)
+	v.SetDefault("LOG_FILE_MAX_BACKUPS", 5)
+	v.SetDefault("LOG_REDACT_QUERY_PARAMS", DefaultRedactedQueryParams)
+}
+
+// bindEnvs binds every Config key to the environment variable of the same
+// name. AutomaticEnv only resolves keys Viper already knows about, so without
+// this Unmarshal would skip any key that has no default.
+func bindEnvs(v *viper.Viper) {
+	for _, key := range configKeys(reflect.TypeOf(Config{})) {
+		_ = v.BindEnv(key)
+	}
+}
+
+// configKeys returns the mapstructure keys of t, descending into squashed structs
+func configKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		switch key := f.Tag.Get("mapstructure"); key {
+		case ",squash":
+			keys = append(keys, configKeys(f.Type)...)
+		case "":
+		default:
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
 // loadSecret reads a file from /run/secrets and sets it in Viper
 func loadSecret(key, name string) {
 	candidates := []string{name, strings.ToUpper(name), strings.ToLower(name)}
@@ -205,6 +511,136 @@ func (c *Config) Validate() error {
 	if c.DbName == "" {
 		errors = append(errors, "DB_NAME is required")
 	}
+	if !dbschema.Valid(c.DbAuthSchema) {
+		errors = append(errors, fmt.Sprintf("DB_AUTH_SCHEMA is not a valid schema name (got %q)", c.DbAuthSchema))
+	}
+	if !dbschema.Valid(c.DbAppSchema) {
+		errors = append(errors, fmt.Sprintf("DB_APP_SCHEMA is not a valid schema name (got %q)", c.DbAppSchema))
+	}
+	if c.DbAuthSchema == c.DbAppSchema {
+		errors = append(errors, "DB_AUTH_SCHEMA and DB_APP_SCHEMA must differ")
+	}
+
+	if c.IsProduction() && strings.TrimSpace(c.Security.CSP) == "" {
+		errors = append(errors, "SECURITY_CSP must not be empty in production")
+	}
+	// The disable default suits the local database; in production the
+	// connection must be encrypted, and prefer would quietly fall back
+	if mode := c.GetDBSSLMode(); c.IsProduction() && !secureSSLModes[mode] {
+		errors = append(errors, fmt.Sprintf("DB_SSL_MODE must be require, verify-ca or verify-full in production (got %q); set it, or sslmode in DATABASE_URL", mode))
+	}
+	if c.DbSslRootCert != "" {
+		if _, err := os.Stat(c.DbSslRootCert); err != nil {
+			errors = append(errors, "DB_SSL_ROOT_CERT: "+err.Error())
+		}
+	}
+
+	if c.TokenBytes != 0 && c.TokenBytes < 16 {
+		errors = append(errors, fmt.Sprintf("TOKEN_BYTES must be at least 16 (got %d)", c.TokenBytes))
+	}
+
+	switch c.GetOtelProtocol() {
+	case OtelProtocolHTTP, OtelProtocolGRPC:
+	default:
+		errors = append(errors, fmt.Sprintf("OTEL_EXPORTER_OTLP_PROTOCOL must be http or grpc (got %q)", c.OtelProtocol))
+	}
+	if _, err := parseOtelHeaders(c.OtelHeaders); err != nil {
+		errors = append(errors, "OTEL_EXPORTER_OTLP_HEADERS: "+err.Error())
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		errors = append(errors, fmt.Sprintf("TRACE_SAMPLE_RATIO must be between 0 and 1 (got %g)", c.TraceSampleRatio))
+	}
+	if c.TraceHealthRatio < 0 || c.TraceHealthRatio > 1 {
+		errors = append(errors, fmt.Sprintf("TRACE_HEALTH_SAMPLE_RATIO must be between 0 and 1 (got %g)", c.TraceHealthRatio))
+	}
+	if c.TraceSlowMS < 0 {
+		errors = append(errors, fmt.Sprintf("TRACE_SLOW_MS must not be negative (got %d)", c.TraceSlowMS))
+	}
+	if c.ShutdownDrainDelay < 0 || c.ShutdownDrainDelay > 0 && c.GetShutdownDrainDelay() >= c.GetShutdownTimeout() {
+		errors = append(errors, fmt.Sprintf("SHUTDOWN_DRAIN_DELAY_SECONDS must be less than SHUTDOWN_TIMEOUT_SECONDS (%s)", c.GetShutdownTimeout()))
+	}
+	// The request timeout answers with an error; the server's write deadline
+	// just cuts the connection, so it must not fire first
+	if c.RequestTimeout < 0 {
+		errors = append(errors, "REQUEST_TIMEOUT_SECONDS cannot be negative")
+	}
+	if c.ServerReadTimeout < 0 || c.ServerReadTimeout > 0 && c.GetServerReadTimeout() < c.GetRequestTimeout() {
+		errors = append(errors, fmt.Sprintf("SERVER_READ_TIMEOUT_SECONDS must be at least REQUEST_TIMEOUT_SECONDS (%s)", c.GetRequestTimeout()))
+	}
+	if c.ServerWriteTimeout < 0 || c.ServerWriteTimeout > 0 && c.GetServerWriteTimeout() <= c.GetRequestTimeout() {
+		errors = append(errors, fmt.Sprintf("SERVER_WRITE_TIMEOUT_SECONDS must exceed REQUEST_TIMEOUT_SECONDS (%s)", c.GetRequestTimeout()))
+	}
+	// The readiness monitor ticks at this interval once the database is lost
+	if c.DBReconnectInterval <= 0 {
+		errors = append(errors, "DB_RECONNECT_INTERVAL_SECONDS must be positive")
+	}
+	if c.CompressMinBytes < 0 {
+		errors = append(errors, "COMPRESS_MIN_BYTES cannot be negative")
+	}
+	if c.MaxBodyBytes < 0 {
+		errors = append(errors, "MAX_BODY_BYTES cannot be negative")
+	}
+	if c.PasswordHistoryCount < 0 || c.PasswordHistoryCount > MaxPasswordHistory {
+		errors = append(errors, fmt.Sprintf("PASSWORD_HISTORY_COUNT must be between 0 and %d", MaxPasswordHistory))
+	}
+	if len(c.JWTVerifyKeyPaths) > 0 && c.JWTPrivateKeyPath == "" {
+		errors = append(errors, "JWT_VERIFY_KEY_PATHS requires JWT_PRIVATE_KEY_PATH")
+	}
+
+	switch c.GetRateLimitKey() {
+	case RateLimitKeyIP, RateLimitKeyUser, RateLimitKeyUserIP:
+	default:
+		errors = append(errors, fmt.Sprintf("RATE_LIMIT_KEY must be ip, user or user_ip (got %q)", c.RateLimitKey))
+	}
+
+	if _, err := parseIPPrefixes(c.IPDenylist); err != nil {
+		errors = append(errors, "IP_DENYLIST: "+err.Error())
+	}
+	if _, err := parseIPPrefixes(c.AdminIPAllowlist); err != nil {
+		errors = append(errors, "ADMIN_IP_ALLOWLIST: "+err.Error())
+	}
+	if _, err := parseIPPrefixes(c.MaintenanceAllowlist); err != nil {
+		errors = append(errors, "MAINTENANCE_IP_ALLOWLIST: "+err.Error())
+	}
+
+	// Trusted proxies vouch for X-Forwarded-For as well as TRUSTED_AUTH_HEADER,
+	// so trusting every address is refused with or without the header
+	proxies, err := parseIPPrefixes(c.TrustedProxyCIDRs)
+	switch {
+	case err != nil:
+		errors = append(errors, "TRUSTED_PROXY_CIDRS: "+err.Error())
+	case len(proxies) == 0 && c.TrustedAuthHeader != "":
+		errors = append(errors, "TRUSTED_AUTH_HEADER requires TRUSTED_PROXY_CIDRS")
+	default:
+		for _, proxy := range proxies {
+			if proxy.Bits() == 0 {
+				errors = append(errors, fmt.Sprintf("TRUSTED_PROXY_CIDRS must not trust every address (got %s)", proxy))
+			}
+		}
+	}
+
+	switch c.APIFormat {
+	case "", "envelope", "jsonapi":
+	default:
+		errors = append(errors, fmt.Sprintf("API_FORMAT must be envelope or jsonapi (got %q)", c.APIFormat))
+	}
+
+	switch c.ErrorFormat {
+	case "", ErrorFormatEnvelope, ErrorFormatProblem:
+	default:
+		errors = append(errors, fmt.Sprintf("ERROR_FORMAT must be envelope or problem (got %q)", c.ErrorFormat))
+	}
+
+	switch strings.ToLower(c.CookieSameSite) {
+	case "", "lax", "strict", "none":
+	default:
+		errors = append(errors, fmt.Sprintf("COOKIE_SAMESITE must be lax, strict or none (got %q)", c.CookieSameSite))
+	}
+
+	errors = append(errors, validateLogOutput("LOG_OUTPUT", c.Log.Output, "LOG_FILE_PATH", c.Log.FilePath)...)
+	if c.Log.AccessOutput != "" {
+		errors = append(errors, validateLogOutput("ACCESS_LOG_OUTPUT", c.Log.AccessOutput, "ACCESS_LOG_FILE_PATH", c.Log.AccessFilePath)...)
+	}
 
 	if len(errors) > 0 {
 		return fmt.Errorf("configuration validation failed: %s", strings.Join(errors, "; "))
@@ -213,22 +649,376 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+func validateLogOutput(name, output, pathName, path string) []string {
+	switch output {
+	case "", "stderr", "stdout":
+		return nil
+	case "file":
+		if path == "" {
+			return []string{fmt.Sprintf("%s is required when %s=file", pathName, name)}
+		}
+		return nil
+	default:
+		return []string{fmt.Sprintf("%s must be one of stderr, stdout, file (got %q)", name, output)}
+	}
+}
+
 // IsDevelopment returns true if the application is running in development mode
 func (c *Config) IsDevelopment() bool {
 	return c.App_Env == "development"
 }
 
+// secureSSLModes are the sslmode values that refuse an unencrypted connection
+var secureSSLModes = map[string]bool{"require": true, "verify-ca": true, "verify-full": true}
+
+// GetDBSSLMode returns the sslmode the database connection uses: the one in
+// DATABASE_URL when set, DB_SSL_MODE otherwise. A connection string without
+// one gets the driver's default, prefer.
+func (c *Config) GetDBSSLMode() string {
+	if c.DatabaseURL == "" {
+		if c.DbSslMode == "" {
+			return "prefer"
+		}
+		return c.DbSslMode
+	}
+	mode := ""
+	if u, err := url.Parse(c.DatabaseURL); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		mode = u.Query().Get("sslmode")
+	} else {
+		// keyword=value form
+		for _, field := range strings.Fields(c.DatabaseURL) {
+			if value, ok := strings.CutPrefix(field, "sslmode="); ok {
+				mode = value
+			}
+		}
+	}
+	if mode == "" {
+		return "prefer"
+	}
+	return mode
+}
+
 // IsProduction returns true if the application is running in production mode
 func (c *Config) IsProduction() bool {
 	return c.App_Env == "production"
 }
 
-// GetJWTExpiration returns the JWT expiration duration
+// GetJWTExpiration returns the access token lifetime: ACCESS_TOKEN_MINUTES,
+// or JWT_EXPIRATION_HOURS when that is set to 0
 func (c *Config) GetJWTExpiration() time.Duration {
+	if c.AccessTokenMinutes > 0 {
+		return time.Duration(c.AccessTokenMinutes) * time.Minute
+	}
 	return time.Duration(c.JWTExpirationHours) * time.Hour
 }
 
-// GetRequestTimeout returns the request timeout duration
+// GetJWTClockSkew returns the leeway applied to exp/nbf/iat checks
+func (c *Config) GetJWTClockSkew() time.Duration {
+	return time.Duration(c.JWTClockSkewSeconds) * time.Second
+}
+
+// GetRefreshExpiration returns how long a refresh token stays valid
+func (c *Config) GetRefreshExpiration() time.Duration {
+	return time.Duration(c.RefreshExpiration) * time.Hour
+}
+
+// GetHealthPingTimeout bounds the dependency pings in the basic health check
+func (c *Config) GetHealthPingTimeout() time.Duration {
+	return time.Duration(c.HealthPingTimeoutMS) * time.Millisecond
+}
+
+// GetHealthCheckTimeout bounds the detailed health check
+func (c *Config) GetHealthCheckTimeout() time.Duration {
+	return time.Duration(c.HealthCheckTimeoutMS) * time.Millisecond
+}
+
+// GetDBPoolWarmupTimeout bounds the startup pool warmup
+func (c *Config) GetDBPoolWarmupTimeout() time.Duration {
+	return time.Duration(c.DBPoolWarmupTimeout) * time.Second
+}
+
+// GetShutdownTimeout caps how long shutdown waits for in-flight requests
+func (c *Config) GetShutdownTimeout() time.Duration {
+	if c.ShutdownTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(c.ShutdownTimeout) * time.Second
+}
+
+// GetShutdownDrainDelay is how long shutdown keeps the listener open after
+// /ready starts failing, so load balancers see it fail instead of finding
+// the port closed. It counts towards GetShutdownTimeout.
+func (c *Config) GetShutdownDrainDelay() time.Duration {
+	return time.Duration(c.ShutdownDrainDelay) * time.Second
+}
+
+// GetDBReconnectInterval is how often the readiness monitor pings a lost database
+func (c *Config) GetDBReconnectInterval() time.Duration {
+	return time.Duration(c.DBReconnectInterval) * time.Second
+}
+
+// GetBcryptMaxWait bounds how long a password hash waits for a free worker
+func (c *Config) GetBcryptMaxWait() time.Duration {
+	return time.Duration(c.BcryptMaxWaitMS) * time.Millisecond
+}
+
+// GetCookieSameSite maps COOKIE_SAMESITE to the auth cookie's SameSite mode.
+// "none" lets a cross-site SPA send the cookie; the cookie is always Secure,
+// which browsers require for SameSite=None.
+func (c *Config) GetCookieSameSite() http.SameSite {
+	switch strings.ToLower(c.CookieSameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// GetHTTPClientTimeout bounds outbound HTTP calls
+func (c *Config) GetHTTPClientTimeout() time.Duration {
+	return time.Duration(c.HTTPClientTimeout) * time.Second
+}
+
+// Error response bodies selectable with ERROR_FORMAT
+const (
+	ErrorFormatEnvelope = "envelope"
+	ErrorFormatProblem  = "problem"
+)
+
+// Session modes reported to clients on login
+const (
+	SessionModeMulti  = "multi"
+	SessionModeSingle = "single"
+)
+
+// SessionMode reports whether a login ends the user's other sessions
+// (SINGLE_SESSION) or leaves them valid, the default
+func (c *Config) SessionMode() string {
+	if c.SingleSession {
+		return SessionModeSingle
+	}
+	return SessionModeMulti
+}
+
+// GetRateLimitWindow is the window RATE_LIMIT is counted over, a minute
+// unless RATE_LIMIT_WINDOW_SECONDS is set
+func (c *Config) GetRateLimitWindow() time.Duration {
+	if c.RateLimitWindow <= 0 {
+		return time.Minute
+	}
+	return time.Duration(c.RateLimitWindow) * time.Second
+}
+
+// What the global rate limiter counts authenticated requests by, selectable
+// with RATE_LIMIT_KEY. Unauthenticated requests are always counted by IP.
+const (
+	RateLimitKeyIP     = "ip"      // the client IP, the default
+	RateLimitKeyUser   = "user"    // the user, wherever their requests come from
+	RateLimitKeyUserIP = "user_ip" // the user on each IP separately
+)
+
+// GetRateLimitKey is RATE_LIMIT_KEY, ip when unset
+func (c *Config) GetRateLimitKey() string {
+	if c.RateLimitKey == "" {
+		return RateLimitKeyIP
+	}
+	return strings.ToLower(c.RateLimitKey)
+}
+
+// GetIPDenylist is IP_DENYLIST as prefixes, a bare IP as a single address
+func (c *Config) GetIPDenylist() []netip.Prefix {
+	prefixes, _ := parseIPPrefixes(c.IPDenylist)
+	return prefixes
+}
+
+// GetAdminIPAllowlist is ADMIN_IP_ALLOWLIST as prefixes
+func (c *Config) GetAdminIPAllowlist() []netip.Prefix {
+	prefixes, _ := parseIPPrefixes(c.AdminIPAllowlist)
+	return prefixes
+}
+
+// GetMaintenanceAllowlist is MAINTENANCE_IP_ALLOWLIST as prefixes
+func (c *Config) GetMaintenanceAllowlist() []netip.Prefix {
+	prefixes, _ := parseIPPrefixes(c.MaintenanceAllowlist)
+	return prefixes
+}
+
+// parseIPPrefixes reads a list of CIDRs and bare IPs
+func parseIPPrefixes(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an IP or CIDR", entry)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP or CIDR", entry)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// GetTrustedProxyCIDRs is TRUSTED_PROXY_CIDRS as prefixes
+func (c *Config) GetTrustedProxyCIDRs() []netip.Prefix {
+	prefixes, _ := parseIPPrefixes(c.TrustedProxyCIDRs)
+	return prefixes
+}
+
+// GetIdempotencyTTL is how long a response to a request with an
+// Idempotency-Key is replayed, a day unless IDEMPOTENCY_TTL_SECONDS is set
+func (c *Config) GetIdempotencyTTL() time.Duration {
+	if c.IdempotencyTTL <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(c.IdempotencyTTL) * time.Second
+}
+
+// GetStaleIfError is the oldest a kept read may be when it stands in for a
+// response the database could not produce; zero when STALE_IF_ERROR_SECONDS
+// is unset or negative
+func (c *Config) GetStaleIfError() time.Duration {
+	if c.StaleIfError <= 0 {
+		return 0
+	}
+	return time.Duration(c.StaleIfError) * time.Second
+}
+
+// GetAbuseWindow is the window ABUSE_LIMIT is counted over, ten minutes
+// unless ABUSE_WINDOW_SECONDS is set
+func (c *Config) GetAbuseWindow() time.Duration {
+	if c.AbuseWindow <= 0 {
+		return 10 * time.Minute
+	}
+	return time.Duration(c.AbuseWindow) * time.Second
+}
+
+// DefaultMaxBodyBytes is the request body limit when MAX_BODY_BYTES is unset
+const DefaultMaxBodyBytes = 1 << 20
+
+// GetMaxBodyBytes is MAX_BODY_BYTES, 1MB when unset
+func (c *Config) GetMaxBodyBytes() int64 {
+	if c.MaxBodyBytes <= 0 {
+		return DefaultMaxBodyBytes
+	}
+	return int64(c.MaxBodyBytes)
+}
+
+// MaxPasswordHistory caps PASSWORD_HISTORY_COUNT. Every remembered password
+// is one more bcrypt comparison on each password change.
+const MaxPasswordHistory = 24
+
+// GetRequestTimeout is how long a handler may run before the request is
+// answered with a timeout error, 30 seconds unless REQUEST_TIMEOUT_SECONDS is set
 func (c *Config) GetRequestTimeout() time.Duration {
+	if c.RequestTimeout <= 0 {
+		return 30 * time.Second
+	}
 	return time.Duration(c.RequestTimeout) * time.Second
 }
+
+// serverWriteMargin is how much longer than the request timeout the server
+// gives a response by default, enough to write the timeout error itself
+const serverWriteMargin = 5 * time.Second
+
+// GetServerReadTimeout bounds reading a whole request, body included. Handlers
+// read the body, so it defaults to the request timeout.
+func (c *Config) GetServerReadTimeout() time.Duration {
+	if c.ServerReadTimeout <= 0 {
+		return c.GetRequestTimeout()
+	}
+	return time.Duration(c.ServerReadTimeout) * time.Second
+}
+
+// GetServerWriteTimeout bounds a response from the end of the request headers.
+// It defaults to the request timeout plus a margin, so a slow handler gets
+// the timeout error rather than a dropped connection.
+func (c *Config) GetServerWriteTimeout() time.Duration {
+	if c.ServerWriteTimeout <= 0 {
+		return c.GetRequestTimeout() + serverWriteMargin
+	}
+	return time.Duration(c.ServerWriteTimeout) * time.Second
+}
+
+// EmailConfigured reports whether an SMTP relay is set, without which no
+// email can be sent
+func (c *Config) EmailConfigured() bool {
+	return c.SMTPHost != ""
+}
+
+// GoogleOAuthEnabled reports whether Google sign-in is configured
+func (c *Config) GoogleOAuthEnabled() bool {
+	return c.GoogleClientID != "" && c.GoogleClientSecret != ""
+}
+
+// GetGoogleRedirectURL is the callback Google sends users back to. It must
+// match a redirect URI registered for the client.
+func (c *Config) GetGoogleRedirectURL() string {
+	if c.GoogleRedirectURL != "" {
+		return c.GoogleRedirectURL
+	}
+	return strings.TrimRight(c.PublicURL, "/") + "/auth/oauth/google/callback"
+}
+
+// OTLP exporter protocols selectable with OTEL_EXPORTER_OTLP_PROTOCOL
+const (
+	OtelProtocolHTTP = "http"
+	OtelProtocolGRPC = "grpc"
+)
+
+// GetOtelProtocol is the OTLP exporter protocol. "http/protobuf", the name
+// the OpenTelemetry spec uses, is accepted for http.
+func (c *Config) GetOtelProtocol() string {
+	switch p := strings.ToLower(strings.TrimSpace(c.OtelProtocol)); p {
+	case "", "http/protobuf":
+		return OtelProtocolHTTP
+	default:
+		return p
+	}
+}
+
+// GetTraceSlowThreshold is TRACE_SLOW_MS as a duration, 0 when disabled
+func (c *Config) GetTraceSlowThreshold() time.Duration {
+	return time.Duration(c.TraceSlowMS) * time.Millisecond
+}
+
+// GetOtelHeaders returns OTEL_EXPORTER_OTLP_HEADERS as a map. Validate has
+// already rejected malformed entries.
+func (c *Config) GetOtelHeaders() map[string]string {
+	headers, _ := parseOtelHeaders(c.OtelHeaders)
+	return headers
+}
+
+// parseOtelHeaders reads the spec's "key=value" list. Values may be
+// percent-encoded so they can contain commas.
+func parseOtelHeaders(entries []string) (map[string]string, error) {
+	headers := make(map[string]string, len(entries))
+	// Errors name the entry, never its value, which is usually a credential
+	for i, entry := range entries {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("entry %d is not key=value", i+1)
+		}
+		decoded, err := url.PathUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("header %s has an invalid percent-encoding", key)
+		}
+		headers[key] = decoded
+	}
+	return headers, nil
+}
@@ -0,0 +1,443 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// validConfig returns a configuration that passes Validate in any environment
+func validConfig(env string) Config {
+	return Config{
+		App_Env:      env,
+		App_Secret:   "a-secret-that-is-definitely-32-chars-long",
+		DbUser:       "user",
+		DbPassword:   "password",
+		DbName:       "db",
+		DbSslMode:    "require",
+		DbAuthSchema: "auth",
+		DbAppSchema:  "app_data",
+		Security:     SecurityHeadersConfig{CSP: DefaultCSP},
+
+		DBReconnectInterval: 2,
+	}
+}
+
+func TestLoadSecurityHeaders(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		cfg, err := Load()
+		require.NoError(t, err)
+
+		assert.Equal(t, DefaultCSP, cfg.Security.CSP)
+		assert.Equal(t, 63072000, cfg.Security.HSTSMaxAge)
+		assert.True(t, cfg.Security.HSTSPreload)
+		assert.Equal(t, "DENY", cfg.Security.FrameOptions)
+	})
+
+	t.Run("EnvOverrides", func(t *testing.T) {
+		t.Setenv("SECURITY_CSP", "default-src 'self' https://cdn.example.com")
+		t.Setenv("SECURITY_HSTS_PRELOAD", "false")
+		t.Setenv("SECURITY_FRAME_OPTIONS", "SAMEORIGIN")
+
+		cfg, err := Load()
+		require.NoError(t, err)
+
+		assert.Equal(t, "default-src 'self' https://cdn.example.com", cfg.Security.CSP)
+		assert.False(t, cfg.Security.HSTSPreload)
+		assert.Equal(t, "SAMEORIGIN", cfg.Security.FrameOptions)
+	})
+}
+
+func TestLoadBindsEveryEnvVar(t *testing.T) {
+	defaults := map[string]interface{}{}
+	for _, f := range Schema("production") {
+		defaults[f.Key] = f.Default
+	}
+
+	// Give every key a value that differs from its default
+	want := map[string]interface{}{}
+	for i, key := range configKeys(reflect.TypeOf(Config{})) {
+		switch field, _ := fieldByKey(reflect.TypeOf(Config{}), key); field.Type.Kind() {
+		case reflect.String:
+			want[key] = "env-" + strings.ToLower(key)
+		case reflect.Int:
+			want[key] = 7000 + i
+		case reflect.Bool:
+			want[key] = defaults[key] != true
+		case reflect.Float64:
+			want[key] = float64(i%9+1) / 10 // a ratio, never a default
+		case reflect.Slice:
+			want[key] = []string{"env-" + strings.ToLower(key), "second"}
+		default:
+			t.Fatalf("%s: no test value for %s", key, field.Type)
+		}
+	}
+	want["APP_ENV"] = "production" // anything else loads .env and development defaults
+
+	for key, value := range want {
+		switch v := value.(type) {
+		case []string:
+			t.Setenv(key, strings.Join(v, ","))
+		default:
+			t.Setenv(key, fmt.Sprint(v))
+		}
+	}
+
+	cfg, err := Load()
+	require.NoError(t, err)
+
+	got := reflect.ValueOf(cfg)
+	for key, value := range want {
+		_, index := fieldByKey(reflect.TypeOf(Config{}), key)
+		assert.Equal(t, value, got.FieldByIndex(index).Interface(), key)
+	}
+}
+
+// fieldByKey finds the Config field carrying mapstructure tag key
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, []int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("mapstructure")
+		if tag == ",squash" {
+			if inner, index := fieldByKey(f.Type, key); index != nil {
+				return inner, append([]int{i}, index...)
+			}
+			continue
+		}
+		if tag == key {
+			return f, []int{i}
+		}
+	}
+	return reflect.StructField{}, nil
+}
+
+func TestValidateCSP(t *testing.T) {
+	t.Run("ProductionRequiresCSP", func(t *testing.T) {
+		cfg := validConfig("production")
+		cfg.Security.CSP = "  "
+
+		err := cfg.Validate()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "SECURITY_CSP")
+	})
+
+	t.Run("DevelopmentAllowsEmptyCSP", func(t *testing.T) {
+		cfg := validConfig("development")
+		cfg.Security.CSP = ""
+
+		assert.NoError(t, cfg.Validate())
+	})
+}
+
+func TestValidateDBSSLMode(t *testing.T) {
+	t.Run("ProductionRejectsInsecureModes", func(t *testing.T) {
+		for _, mode := range []string{"disable", "allow", "prefer", ""} {
+			cfg := validConfig("production")
+			cfg.DbSslMode = mode
+
+			err := cfg.Validate()
+			require.Error(t, err, mode)
+			assert.Contains(t, err.Error(), "DB_SSL_MODE", mode)
+		}
+	})
+
+	t.Run("ProductionChecksDatabaseURL", func(t *testing.T) {
+		for dsn, ok := range map[string]bool{
+			"postgres://u:p@db:5432/app?sslmode=disable":     false,
+			"postgres://u:p@db:5432/app":                     false,
+			"postgresql://u:p@db:5432/app?sslmode=verify-ca": true,
+			"host=db user=u dbname=app sslmode=require":      true,
+			"host=db user=u dbname=app":                      false,
+		} {
+			cfg := validConfig("production")
+			cfg.DatabaseURL = dsn
+
+			if ok {
+				assert.NoError(t, cfg.Validate(), dsn)
+			} else {
+				assert.Error(t, cfg.Validate(), dsn)
+			}
+		}
+	})
+
+	t.Run("VerifyFullWithRootCert", func(t *testing.T) {
+		ca := filepath.Join(t.TempDir(), "root.crt")
+		require.NoError(t, os.WriteFile(ca, []byte("-----BEGIN CERTIFICATE-----"), 0o600))
+
+		cfg := validConfig("production")
+		cfg.DbSslMode = "verify-full"
+		cfg.DbSslRootCert = ca
+		assert.NoError(t, cfg.Validate())
+
+		cfg.DbSslRootCert = filepath.Join(t.TempDir(), "missing.crt")
+		err := cfg.Validate()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "DB_SSL_ROOT_CERT")
+	})
+
+	t.Run("DevelopmentAllowsDisable", func(t *testing.T) {
+		cfg := validConfig("development")
+		cfg.DbSslMode = "disable"
+
+		assert.NoError(t, cfg.Validate())
+	})
+
+	t.Run("RootCertAddedToDatabaseURL", func(t *testing.T) {
+		t.Setenv("DB_SSL_MODE", "verify-full")
+		t.Setenv("DB_SSL_ROOT_CERT", "/run/secrets/db ca.crt")
+
+		cfg, err := Load()
+		require.NoError(t, err)
+		assert.Contains(t, cfg.DatabaseURL, "sslmode=verify-full&sslrootcert=%2Frun%2Fsecrets%2Fdb+ca.crt")
+		assert.Equal(t, "verify-full", cfg.GetDBSSLMode())
+	})
+}
+
+func TestValidateRateLimitKey(t *testing.T) {
+	for key, ok := range map[string]bool{"": true, "ip": true, "user": true, "USER_IP": true, "session": false} {
+		cfg := validConfig("development")
+		cfg.RateLimitKey = key
+
+		if ok {
+			assert.NoError(t, cfg.Validate(), key)
+		} else {
+			assert.ErrorContains(t, cfg.Validate(), "RATE_LIMIT_KEY", key)
+		}
+	}
+}
+
+func TestIPLists(t *testing.T) {
+	cfg := validConfig("development")
+	cfg.IPDenylist = []string{"203.0.113.7", " 198.51.100.0/24 ", "2001:db8::/32", "10.1.2.3/8"}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("203.0.113.7/32"),
+		netip.MustParsePrefix("198.51.100.0/24"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+	}, cfg.GetIPDenylist())
+	assert.Empty(t, cfg.GetAdminIPAllowlist())
+	assert.Empty(t, cfg.GetMaintenanceAllowlist())
+
+	for _, entry := range []string{"not-an-ip", "10.0.0.0/33", "10.0.0"} {
+		cfg := validConfig("development")
+		cfg.AdminIPAllowlist = []string{"10.0.0.1", entry}
+		assert.ErrorContains(t, cfg.Validate(), "ADMIN_IP_ALLOWLIST", entry)
+	}
+	cfg.MaintenanceAllowlist = []string{"10.0.0.0/33"}
+	assert.ErrorContains(t, cfg.Validate(), "MAINTENANCE_IP_ALLOWLIST")
+}
+
+func TestValidateTrustedAuthHeader(t *testing.T) {
+	cfg := validConfig("production")
+	cfg.TrustedAuthHeader = "X-Auth-Request-User"
+	assert.ErrorContains(t, cfg.Validate(), "TRUSTED_AUTH_HEADER requires TRUSTED_PROXY_CIDRS")
+
+	cfg.TrustedProxyCIDRs = []string{"0.0.0.0/0"}
+	assert.ErrorContains(t, cfg.Validate(), "must not trust every address")
+
+	cfg.TrustedProxyCIDRs = []string{"172.18.0.0/16", "10.0.0.5"}
+	assert.NoError(t, cfg.Validate())
+	assert.Len(t, cfg.GetTrustedProxyCIDRs(), 2)
+
+	// Forwarding headers are believed from trusted proxies even without the header
+	cfg = validConfig("production")
+	cfg.TrustedProxyCIDRs = []string{"::/0"}
+	assert.ErrorContains(t, cfg.Validate(), "must not trust every address")
+}
+
+func TestValidateSchemaNames(t *testing.T) {
+	for _, name := range []string{"", "Auth", "auth; DROP TABLE x", `"auth"`, "pg_temp", "1auth"} {
+		cfg := validConfig("development")
+		cfg.DbAuthSchema = name
+
+		err := cfg.Validate()
+		require.Error(t, err, name)
+		assert.Contains(t, err.Error(), "DB_AUTH_SCHEMA")
+	}
+
+	cfg := validConfig("development")
+	cfg.DbAuthSchema, cfg.DbAppSchema = "tenant_a_auth", "tenant_a_app"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.DbAppSchema = cfg.DbAuthSchema
+	assert.Error(t, cfg.Validate())
+}
+
+func TestServerTimeouts(t *testing.T) {
+	cfg := validConfig("development")
+	assert.Equal(t, 30*time.Second, cfg.GetRequestTimeout(), "unset falls back to the default")
+
+	// Derived from the request timeout, with room to write the timeout error
+	cfg.RequestTimeout = 60
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, 60*time.Second, cfg.GetServerReadTimeout())
+	assert.Greater(t, cfg.GetServerWriteTimeout(), cfg.GetRequestTimeout())
+
+	cfg.ServerWriteTimeout = 90
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, 90*time.Second, cfg.GetServerWriteTimeout())
+
+	// A write deadline at or before the request timeout would drop the
+	// connection before the handler timeout can answer
+	cfg.ServerWriteTimeout = 60
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SERVER_WRITE_TIMEOUT_SECONDS")
+
+	cfg.ServerWriteTimeout = 0
+	cfg.ServerReadTimeout = 15
+	err = cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SERVER_READ_TIMEOUT_SECONDS")
+}
+
+func TestOtelExporterConfig(t *testing.T) {
+	cfg := validConfig("development")
+	for protocol, want := range map[string]string{"": OtelProtocolHTTP, "http/protobuf": OtelProtocolHTTP, "GRPC": OtelProtocolGRPC} {
+		cfg.OtelProtocol = protocol
+		assert.Equal(t, want, cfg.GetOtelProtocol(), protocol)
+		assert.NoError(t, cfg.Validate(), protocol)
+	}
+
+	cfg.OtelProtocol = "thrift"
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "OTEL_EXPORTER_OTLP_PROTOCOL")
+
+	cfg = validConfig("development")
+	cfg.OtelHeaders = []string{"x-api-key=abc%2C123", " Authorization = Bearer t "}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, map[string]string{"x-api-key": "abc,123", "Authorization": "Bearer t"}, cfg.GetOtelHeaders())
+
+	cfg.OtelHeaders = []string{"Bearer hunter2"}
+	err = cfg.Validate()
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "hunter2", "header values are credentials")
+}
+
+func TestSchema(t *testing.T) {
+	schema := Schema("development")
+	byKey := make(map[string]SchemaField, len(schema))
+	for _, f := range schema {
+		_, dup := byKey[f.Key]
+		require.False(t, dup, "duplicate key %s", f.Key)
+		byKey[f.Key] = f
+	}
+
+	t.Run("EveryFieldListed", func(t *testing.T) {
+		var walk func(reflect.Type)
+		walk = func(typ reflect.Type) {
+			for i := 0; i < typ.NumField(); i++ {
+				f := typ.Field(i)
+				tag := f.Tag.Get("mapstructure")
+				if tag == ",squash" {
+					walk(f.Type)
+					continue
+				}
+				got, ok := byKey[tag]
+				if assert.True(t, ok, "%s (%s) missing from schema", f.Name, tag) {
+					assert.Equal(t, f.Type.String(), got.Type, tag)
+				}
+			}
+		}
+		walk(reflect.TypeOf(Config{}))
+	})
+
+	t.Run("Metadata", func(t *testing.T) {
+		assert.Equal(t, SchemaField{Key: "APP_SECRET", Type: "string", Required: true, Secret: true}, byKey["APP_SECRET"])
+		assert.Equal(t, SchemaField{Key: "DB_USER", Type: "string", Required: true}, byKey["DB_USER"])
+		assert.Equal(t, SchemaField{Key: "PORT", Type: "int", Default: 8080}, byKey["PORT"])
+		assert.Equal(t, SchemaField{Key: "LOG_FILE_PATH", Type: "string"}, byKey["LOG_FILE_PATH"])
+		assert.Equal(t, DefaultCSP, byKey["SECURITY_CSP"].Default)
+		assert.Equal(t, "[]string", byKey["CORS_ALLOWED_ORIGINS"].Type)
+		assert.Equal(t, 100, byKey["RATE_LIMIT"].Default)
+	})
+
+	t.Run("SecretDefaultsWithheld", func(t *testing.T) {
+		f := byKey["DEFAULT_USER_PASSWORD"]
+		assert.True(t, f.Secret)
+		assert.Nil(t, f.Default, "the development password default must not leak")
+		for _, f := range schema {
+			if f.Secret {
+				assert.Nil(t, f.Default, f.Key)
+			}
+		}
+	})
+
+	t.Run("EnvironmentDefaults", func(t *testing.T) {
+		for _, f := range Schema("production") {
+			if f.Key == "RATE_LIMIT" {
+				assert.Equal(t, 1000, f.Default)
+			}
+		}
+	})
+}
+
+func TestValidateTraceSampling(t *testing.T) {
+	cfg := validConfig("production")
+	cfg.TraceSampleRatio = 1.5
+	assert.ErrorContains(t, cfg.Validate(), "TRACE_SAMPLE_RATIO")
+
+	cfg = validConfig("production")
+	cfg.TraceHealthRatio = -0.1
+	assert.ErrorContains(t, cfg.Validate(), "TRACE_HEALTH_SAMPLE_RATIO")
+
+	cfg = validConfig("production")
+	cfg.TraceSlowMS = 250
+	assert.NoError(t, cfg.Validate())
+	assert.Equal(t, 250*time.Millisecond, cfg.GetTraceSlowThreshold())
+}
+
+func TestValidateDBReconnectInterval(t *testing.T) {
+	for _, seconds := range []int{0, -1} {
+		cfg := validConfig("production")
+		cfg.DBReconnectInterval = seconds
+		assert.ErrorContains(t, cfg.Validate(), "DB_RECONNECT_INTERVAL_SECONDS must be positive", seconds)
+	}
+}
+
+func TestLoadOtelEndpoint(t *testing.T) {
+	cfg, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, "tempo:4318", cfg.OtelEndpoint)
+
+	// Set but empty turns tracing off rather than meaning the default
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.OtelEndpoint)
+}
+
+func TestLoadRedisHost(t *testing.T) {
+	cfg, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, "localhost", cfg.RedisHost)
+
+	// Set but empty runs without Redis rather than meaning the default
+	t.Setenv("REDIS_HOST", "")
+	cfg, err = Load()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.RedisHost)
+}
+
+func TestShutdownDrainDelay(t *testing.T) {
+	cfg := validConfig("development")
+	cfg.ShutdownDrainDelay = 10
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, 10*time.Second, cfg.GetShutdownDrainDelay())
+
+	// The delay comes out of the shutdown budget, so it must leave some
+	cfg.ShutdownTimeout = 10
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SHUTDOWN_DRAIN_DELAY_SECONDS")
+}
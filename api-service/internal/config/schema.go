@@ -0,0 +1,58 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// SchemaField describes one recognised configuration key. It never carries
+// the running value; Default is the built-in default, withheld for secrets.
+type SchemaField struct {
+	Key      string      `json:"key"`
+	Type     string      `json:"type"`
+	Default  interface{} `json:"default"`
+	Required bool        `json:"required"`
+	Secret   bool        `json:"secret"`
+}
+
+// Schema lists every key Config recognises, in declaration order, with the
+// defaults that apply in env. Keys come from the mapstructure tags; the
+// config tag marks keys as required and/or secret.
+func Schema(env string) []SchemaField {
+	v := viper.New()
+	setDefaults(v, env)
+
+	var fields []SchemaField
+	collectSchema(reflect.TypeOf(Config{}), v, &fields)
+	return fields
+}
+
+func collectSchema(t reflect.Type, v *viper.Viper, fields *[]SchemaField) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := f.Tag.Get("mapstructure")
+		if key == ",squash" {
+			collectSchema(f.Type, v, fields)
+			continue
+		}
+		if key == "" {
+			continue
+		}
+
+		field := SchemaField{Key: key, Type: f.Type.String()}
+		for _, opt := range strings.Split(f.Tag.Get("config"), ",") {
+			switch opt {
+			case "required":
+				field.Required = true
+			case "secret":
+				field.Secret = true
+			}
+		}
+		if !field.Secret && v.IsSet(key) {
+			field.Default = v.Get(key)
+		}
+		*fields = append(*fields, field)
+	}
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrAPIKeyNotFound is returned when an API key does not exist or belongs to another user
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	// ErrAPIKeyInvalid is returned when a presented API key is malformed, unknown or revoked
+	ErrAPIKeyInvalid = errors.New("invalid api key")
+	// ErrVerificationTokenInvalid is returned when an email verification token is unknown or expired
+	ErrVerificationTokenInvalid = errors.New("invalid or expired verification token")
+	// ErrTokenExpired is returned when a single-use token exists but has expired
+	ErrTokenExpired = errors.New("token has expired")
+	// ErrTokenUsed is returned when a single-use token has already been consumed
+	ErrTokenUsed = errors.New("token has already been used")
+	// ErrUserExists is returned when a registration's email or username, or a lookalike of it, is taken
+	ErrUserExists = errors.New("user with this email or username already exists")
+	// ErrUserNotFound is returned when a user does not exist or is inactive
+	ErrUserNotFound = errors.New("user not found")
+	// ErrMergeSameUser is returned when a merge names the same account twice
+	ErrMergeSameUser = errors.New("cannot merge a user into itself")
+	// ErrKeyNotFound is returned by KVStore.Get for a missing or expired key
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrUsernameTaken is returned when a username matches or looks like an existing one
+	ErrUsernameTaken = errors.New("username already taken")
+	// ErrUnknownTable is returned when maintenance names a table outside the application's own
+	ErrUnknownTable = errors.New("unknown table")
+	// ErrMaintenanceConfirmation is returned when a maintenance confirmation token is missing, expired or for another request
+	ErrMaintenanceConfirmation = errors.New("invalid or expired confirmation token")
+	// ErrMaintenanceRunning is returned when a maintenance job is already in progress
+	ErrMaintenanceRunning = errors.New("maintenance already running")
+	// ErrMaintenanceJobNotFound is returned for an unknown or expired job ID
+	ErrMaintenanceJobNotFound = errors.New("maintenance job not found")
+	// ErrDBSessionNotFound is returned when a PID is not one of the application's own database sessions
+	ErrDBSessionNotFound = errors.New("database session not found")
+	// ErrPasswordReused is returned when a new password matches one in the user's password history
+	ErrPasswordReused = errors.New("password was used recently")
+	// ErrPasswordIncorrect is returned when a change is confirmed with the wrong current password
+	ErrPasswordIncorrect = errors.New("current password is incorrect")
+	// ErrChannelUnavailable is returned when a user turns on a notification channel the server has no configuration for
+	ErrChannelUnavailable = errors.New("notification channel is not available")
+	// ErrHasherBusy is returned when the password hashing queue is full
+	ErrHasherBusy = errors.New("password hasher busy")
+	// ErrSessionUnavailable is returned when a new login's session or refresh token cannot be recorded
+	ErrSessionUnavailable = errors.New("session store unavailable")
+	// ErrRefreshTokenInvalid is returned for a refresh token that is unknown, expired, already used or revoked
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	// ErrSessionRevoked is an ErrRefreshTokenInvalid for a stored token whose session has since been revoked
+	ErrSessionRevoked = fmt.Errorf("%w: session revoked", ErrRefreshTokenInvalid)
+	// ErrIdentityEmailUnverified is returned when a provider has not verified the email it reports
+	ErrIdentityEmailUnverified = errors.New("provider email is not verified")
+	// ErrIdentityLinkUnverified is returned when an external sign-in matches an account whose own email is unverified
+	ErrIdentityLinkUnverified = errors.New("an account with this email exists but its email is not verified")
+	// ErrTrustedUserUnknown is returned when the user an auth proxy names has no account and none may be created
+	ErrTrustedUserUnknown = errors.New("no account for the user named by the auth proxy")
+)
@@ -2,7 +2,9 @@ package core
 
 import (
 	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/pagination"
 	"context"
+	"time"
 )
 
 // UserRepository defines direct database operations.
@@ -11,13 +13,236 @@ type UserRepository interface {
 	Create(ctx context.Context, user *models.User) error
 	GetByID(ctx context.Context, id string) (*models.User, error)
 	GetByEmailOrUsername(ctx context.Context, email, username string) (*models.User, error)
+	// UsernameSkeletonTaken reports whether another user's name looks like one with this skeleton
+	UsernameSkeletonTaken(ctx context.Context, skeleton, excludeID string) (bool, error)
 
 	// User Management
 	Update(ctx context.Context, user *models.User) error
-	UpdatePassword(ctx context.Context, userID, hash string) error
+	// UpdatePassword sets the password hash and records it in the password
+	// history, keeping only the newest historySize entries; 0 clears it
+	UpdatePassword(ctx context.Context, userID, hash string, historySize int) error
+	// RecentPasswordHashes returns up to n of the user's password history, newest first
+	RecentPasswordHashes(ctx context.Context, userID string, n int) ([]string, error)
 	UpdateLastLogin(ctx context.Context, userID string) error
-	List(ctx context.Context, limit, offset int) ([]models.User, error)
+	List(ctx context.Context, limit, offset int) ([]models.UserListItem, error)
 	Count(ctx context.Context) (int, error)
+	CountByRole(ctx context.Context, role string) (int, error)
+	// CollectionVersion returns the active user count and latest updated_at in one query
+	CollectionVersion(ctx context.Context) (int, time.Time, error)
+	// StreamAll calls fn with every user, oldest first, as rows are read
+	// rather than all at once. An error from fn stops it and is returned.
+	StreamAll(ctx context.Context, fn func(models.UserExportRow) error) error
+
+	// Preferences
+	GetPreferences(ctx context.Context, userID string) (*models.UserPreferences, error)
+	UpsertPreferences(ctx context.Context, prefs *models.UserPreferences) error
+	SetPendingNotificationEmail(ctx context.Context, userID, email, tokenHash string, expiresAt time.Time) error
+	VerifyNotificationEmail(ctx context.Context, tokenHash string) (bool, error)
+
+	// Recovery contacts
+	// GetRecoveryContact returns nil if the user has no contact of kind.
+	GetRecoveryContact(ctx context.Context, userID, kind string) (*models.RecoveryContact, error)
+	SetPendingRecoveryContact(ctx context.Context, userID, kind, value, tokenHash string, expiresAt time.Time) error
+	VerifyRecoveryContact(ctx context.Context, kind, tokenHash string) (bool, error)
+
+	SetEmailVerified(ctx context.Context, userID string) error
+
+	// Identities
+	// GetUserIDByIdentity returns the active user linked to the provider
+	// account, or "" if there is none.
+	GetUserIDByIdentity(ctx context.Context, provider, providerUserID string) (string, error)
+	// LinkIdentity links a provider account to a user. Linking an account
+	// that is already linked is a no-op.
+	LinkIdentity(ctx context.Context, identity *models.UserIdentity) error
+
+	// Merge moves the source user's data to the target and deactivates the
+	// source in a single transaction. It returns ErrUserNotFound if either
+	// user is missing or inactive.
+	Merge(ctx context.Context, sourceID, targetID string) (*models.MergeResult, error)
+}
+
+// AuditRepository defines storage for the audit log and login history.
+// List methods return up to p.FetchLimit() rows ordered by created_at DESC, id DESC.
+type AuditRepository interface {
+	RecordEvent(ctx context.Context, event *models.AuditEvent) error
+	ListEvents(ctx context.Context, p pagination.Params) ([]models.AuditEvent, error)
+	RecordLogin(ctx context.Context, entry *models.LoginEvent) error
+	ListLogins(ctx context.Context, userID string, p pagination.Params) ([]models.LoginEvent, error)
+}
+
+// APIKeyRepository defines storage for API keys. Lookups ignore revoked keys.
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	ListByUser(ctx context.Context, userID string) ([]models.APIKey, error)
+	GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	// Revoke marks the key revoked and reports whether a matching active key was found
+	Revoke(ctx context.Context, id, userID string) (bool, error)
+	TouchLastUsed(ctx context.Context, id string) error
+}
+
+// SessionStore holds server-side session state used to invalidate issued tokens.
+type SessionStore interface {
+	// GlobalEpoch returns the unix time before which all tokens are rejected (0 if never set).
+	GlobalEpoch(ctx context.Context) (int64, error)
+	// BumpGlobalEpoch moves the global epoch to now and returns it.
+	BumpGlobalEpoch(ctx context.Context) (int64, error)
+	// EffectiveEpoch returns the later of the global epoch and userID's own epoch.
+	EffectiveEpoch(ctx context.Context, userID string) (int64, error)
+	// BumpUserEpoch moves userID's epoch to now, revoking only that user's tokens.
+	BumpUserEpoch(ctx context.Context, userID string) (int64, error)
+	// SetActiveSession records sessionID as userID's only valid session for ttl.
+	SetActiveSession(ctx context.Context, userID, sessionID string, ttl time.Duration) error
+	// ActiveSession returns userID's recorded session ("" if none).
+	ActiveSession(ctx context.Context, userID string) (string, error)
+	// RecordFailedLogin notes a wrong password for userID at at.
+	RecordFailedLogin(ctx context.Context, userID string, at time.Time) error
+	// LastFailedLogin returns userID's latest failed login (zero if none is recorded).
+	LastFailedLogin(ctx context.Context, userID string) (time.Time, error)
+	// RevokeToken rejects the access token with ID tokenID for ttl, its remaining lifetime.
+	RevokeToken(ctx context.Context, tokenID string, ttl time.Duration) error
+	// TokenRevoked reports whether tokenID has been revoked.
+	TokenRevoked(ctx context.Context, tokenID string) (bool, error)
+	// SaveRefreshToken stores refresh token tokenID for sessionID, issued now, for ttl.
+	SaveRefreshToken(ctx context.Context, userID, tokenID, sessionID string, ttl time.Duration) error
+	// ConsumeRefreshToken atomically deletes a refresh token and returns its
+	// session ID and issue time, or ErrRefreshTokenInvalid if it is not stored.
+	ConsumeRefreshToken(ctx context.Context, userID, tokenID string) (sessionID string, issuedAt int64, err error)
+}
+
+// TokenRepository stores single-use tokens (password reset, email verification) by hash.
+type TokenRepository interface {
+	Create(ctx context.Context, token *models.UserToken) error
+	// Get returns the token whether or not it is expired or used, or nil if unknown.
+	Get(ctx context.Context, tokenHash, purpose string) (*models.UserToken, error)
+	// Consume atomically marks an unexpired, unused token as used and returns its
+	// user ID. It returns ErrVerificationTokenInvalid if no such token exists.
+	Consume(ctx context.Context, tokenHash, purpose string) (string, error)
+}
+
+// AccountService handles the emailed single-use token flows.
+type AccountService interface {
+	// RequestPasswordReset returns the token and the user to email it to, or
+	// an empty token and nil user if no active account uses that email.
+	RequestPasswordReset(ctx context.Context, email string) (string, *models.User, error)
+	// RequestRecoveryPasswordReset is RequestPasswordReset for delivery to
+	// the account's verified recovery email, returned as to. It returns no
+	// user when there is no account or it has no verified recovery email.
+	RequestRecoveryPasswordReset(ctx context.Context, email string) (token, to string, user *models.User, err error)
+	ResetPassword(ctx context.Context, req models.ResetPasswordRequest) (string, error)
+	IssueEmailVerification(ctx context.Context, userID string) (string, error)
+	VerifyEmail(ctx context.Context, token string) (string, error)
+	// ValidateToken checks a token without consuming it.
+	ValidateToken(ctx context.Context, purpose, token string) (*models.TokenValidation, error)
+}
+
+// MaintenanceRepository runs database housekeeping on application tables.
+type MaintenanceRepository interface {
+	// Tables lists the schema-qualified tables maintenance may touch.
+	Tables() []string
+	// Run analyzes (and optionally vacuums) tables, which must come from Tables.
+	// It returns ErrMaintenanceRunning if another run holds the lock.
+	Run(ctx context.Context, tables []string, vacuum bool) error
+	// Sessions lists the application's connections from pg_stat_activity,
+	// other than the one running the query.
+	Sessions(ctx context.Context) ([]models.DBSession, error)
+	// CancelSession cancels the query running on pid and returns its text.
+	// It returns ErrDBSessionNotFound unless pid is one of Sessions.
+	CancelSession(ctx context.Context, pid int32) (string, error)
+}
+
+// MaintenanceService guards and tracks admin-triggered database maintenance.
+type MaintenanceService interface {
+	// Confirm validates req and returns the token that must accompany Start.
+	Confirm(ctx context.Context, userID string, req models.MaintenanceRequest) (*models.MaintenanceConfirmation, error)
+	// Start checks the confirmation token and runs the job in the background.
+	Start(ctx context.Context, userID string, req models.MaintenanceRequest) (*models.MaintenanceJob, error)
+	// Job returns a job by ID, or ErrMaintenanceJobNotFound.
+	Job(ctx context.Context, id string) (*models.MaintenanceJob, error)
+	// Sessions lists the application's own database sessions.
+	Sessions(ctx context.Context) ([]models.DBSession, error)
+	// CancelSession cancels the query on one of them; see MaintenanceRepository.
+	CancelSession(ctx context.Context, pid int32) (string, error)
+	// Mode returns the maintenance mode switch; it is off until first set.
+	Mode(ctx context.Context) (*models.MaintenanceMode, error)
+	// SetMode turns maintenance mode on or off for every instance.
+	SetMode(ctx context.Context, userID string, req models.MaintenanceModeRequest) (*models.MaintenanceMode, error)
+}
+
+// OAuthProvider runs the authorization code flow against an external
+// sign-in provider.
+type OAuthProvider interface {
+	// AuthCodeURL returns the provider's consent page for state, bound to the
+	// PKCE verifier.
+	AuthCodeURL(state, verifier string) string
+	// Exchange trades an authorization code for the signed-in user's profile.
+	Exchange(ctx context.Context, code, verifier string) (*models.OAuthProfile, error)
+}
+
+// TokenGenerator mints the random secrets handed to clients: reset and
+// verification tokens, refresh tokens and API keys.
+type TokenGenerator interface {
+	// Token returns a URL-safe base64 token of the configured length.
+	Token() (string, error)
+	// Bytes returns n random bytes.
+	Bytes(n int) ([]byte, error)
+}
+
+// PasswordHasher runs bcrypt hashing and verification. Implementations bound
+// how many run at once so a login flood can't starve the rest of the API.
+type PasswordHasher interface {
+	// Hash returns the bcrypt hash of password.
+	Hash(ctx context.Context, password string) (string, error)
+	// Compare returns nil when password matches hash.
+	Compare(ctx context.Context, hash, password string) error
+}
+
+// KVStore is the key-value state shared by rate limiting and similar
+// short-lived counters. Redis is the production backend; an in-memory
+// implementation serves tests and single-instance deployments.
+type KVStore interface {
+	// Get returns ErrKeyNotFound when key is unset or expired.
+	Get(ctx context.Context, key string) (string, error)
+	// Set stores value under key; a zero ttl keeps it until overwritten.
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	// Incr adds one to the integer at key, starting from zero, and returns the result.
+	Incr(ctx context.Context, key string) (int64, error)
+	// Expire sets a ttl on an existing key.
+	Expire(ctx context.Context, key string, ttl time.Duration) error
+	// SlidingWindow records hits at now against key, forgets anything older
+	// than window and returns how many hits remain and when the oldest of
+	// them was recorded (zero if none), all atomically. When maxHits is
+	// positive only the newest maxHits hits are kept, bounding the key's size.
+	// The key expires after two windows without hits.
+	SlidingWindow(ctx context.Context, key string, now time.Time, window time.Duration, hits, maxHits int) (int64, time.Time, error)
+	// SlidingWindowCount returns how many hits at key fall within window
+	// before now and the oldest of them, without recording or trimming anything.
+	SlidingWindowCount(ctx context.Context, key string, now time.Time, window time.Duration) (int64, time.Time, error)
+	// Acquire takes one of limit slots at key for holder and reports whether
+	// one was free, atomically. A slot is freed by Release, or after ttl so
+	// that a holder which never releases can't keep it forever.
+	Acquire(ctx context.Context, key, holder string, limit int, ttl time.Duration) (bool, error)
+	// Release frees holder's slot at key, if it still has one.
+	Release(ctx context.Context, key, holder string) error
+}
+
+// LimitsService reports a caller's own throttling state.
+type LimitsService interface {
+	// Status reads the rate-limit window for ip and userID's last failed
+	// login. It only reads, so checking does not add to the caller's count.
+	Status(ctx context.Context, userID, ip string) (*models.LimitStatus, error)
+}
+
+// SessionRefresher exchanges a refresh token for a new session; the JWT
+// middleware uses it for AUTO_REFRESH. UserService satisfies it.
+type SessionRefresher interface {
+	Refresh(ctx context.Context, refreshToken string) (*models.LoginResponse, error)
+}
+
+// TrustedUserResolver maps the user an authenticating proxy names in
+// TRUSTED_AUTH_HEADER to an account; the TrustedHeader middleware uses it.
+// UserService satisfies it.
+type TrustedUserResolver interface {
+	TrustedUser(ctx context.Context, name, email string) (*models.User, error)
 }
 
 // UserService defines the business logic.
@@ -25,10 +250,63 @@ type UserService interface {
 	// Auth
 	Register(ctx context.Context, req models.RegisterRequest) (*models.RegisterResponse, error)
 	Login(ctx context.Context, req models.LoginRequest) (*models.LoginResponse, error)
+	// Refresh exchanges a refresh token for a new access token and a new
+	// refresh token; the presented one stops working.
+	Refresh(ctx context.Context, refreshToken string) (*models.LoginResponse, error)
+	// RevokeRefreshToken discards a refresh token, as on logout.
+	RevokeRefreshToken(ctx context.Context, refreshToken string) error
+	// Logout revokes an access token for the rest of its lifetime. Tokens
+	// that don't verify or have already expired are ignored.
+	Logout(ctx context.Context, accessToken string) error
+	// LogoutAllDevices revokes every token issued to userID so far.
+	LogoutAllDevices(ctx context.Context, userID string) error
+	// LoginWithIdentity signs in the user linked to an external account. An
+	// unlinked account is first linked to the user with the same verified
+	// email, or to a new user.
+	LoginWithIdentity(ctx context.Context, profile models.OAuthProfile) (*models.IdentityLogin, error)
+	// TrustedUser returns the user an authenticating proxy vouches for,
+	// linking or creating the account on first sight.
+	TrustedUser(ctx context.Context, name, email string) (*models.User, error)
 
 	// User Management
 	GetProfile(ctx context.Context, userID string) (*models.User, error)
 	UpdateProfile(ctx context.Context, userID string, req models.UpdateUserRequest) error
-	ChangePassword(ctx context.Context, userID string, req models.ChangePasswordRequest) error
-	GetUsers(ctx context.Context, page, limit int) ([]models.User, *models.PaginationMetadata, error)
+	ChangePassword(ctx context.Context, userID string, req models.ChangePasswordRequest) (*models.LoginResponse, error)
+	GetUsers(ctx context.Context, page, limit int) ([]models.UserListItem, *models.PaginationMetadata, error)
+	UsersETag(ctx context.Context) (string, error)
+
+	// Preferences
+	GetPreferences(ctx context.Context, userID string) (*models.PreferencesResponse, error)
+	UpdatePreferences(ctx context.Context, userID string, req models.UpdatePreferencesRequest) (*models.PreferencesResponse, error)
+	NotificationChannels(ctx context.Context, userID string) ([]models.NotificationChannel, error)
+	RequestNotificationEmail(ctx context.Context, userID, email string) (string, error)
+	VerifyNotificationEmail(ctx context.Context, token string) error
+
+	// Recovery
+	RecoveryEmail(ctx context.Context, userID string) (*models.RecoveryContact, error)
+	RequestRecoveryEmail(ctx context.Context, userID, currentPassword, email string) (string, error)
+	VerifyRecoveryEmail(ctx context.Context, token string) error
+
+	// Admin
+	MergeUsers(ctx context.Context, req models.MergeUsersRequest) (*models.MergeResult, error)
+	ExportUsers(ctx context.Context, fn func(models.UserExportRow) error) error
+
+	// Sessions
+	RevokeAllSessions(ctx context.Context) (time.Time, error)
+}
+
+// APIKeyService manages a user's API keys and authenticates presented keys.
+type APIKeyService interface {
+	Create(ctx context.Context, userID string, req models.CreateAPIKeyRequest) (*models.CreateAPIKeyResponse, error)
+	List(ctx context.Context, userID string) ([]models.APIKeySummary, error)
+	Revoke(ctx context.Context, userID, id string) error
+	Authenticate(ctx context.Context, key string) (*models.APIKey, error)
+}
+
+// AuditService defines recording and browsing of security events.
+type AuditService interface {
+	Record(ctx context.Context, event models.AuditEvent) error
+	RecordLogin(ctx context.Context, userID, ipAddress, userAgent string) error
+	ListEvents(ctx context.Context, before string, limit int) ([]models.AuditEvent, *models.KeysetMetadata, error)
+	ListLoginHistory(ctx context.Context, userID, before string, limit int) ([]models.LoginEvent, *models.KeysetMetadata, error)
 }
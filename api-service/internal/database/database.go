@@ -4,10 +4,18 @@ package database
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/dbschema"
+	"azlo-goboiler/internal/readiness"
+	"azlo-goboiler/internal/username"
+
 	"github.com/exaring/otelpgx"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog/log"
 )
@@ -19,6 +27,8 @@ type DatabaseConfig struct {
 	MaxConnLifetime   time.Duration
 	MaxConnIdleTime   time.Duration
 	HealthCheckPeriod time.Duration
+	// Monitor, when set, observes every query for connection-level failures
+	Monitor *readiness.Monitor
 }
 
 // DefaultDatabaseConfig returns production-ready database configuration
@@ -32,6 +42,9 @@ func DefaultDatabaseConfig() *DatabaseConfig {
 	}
 }
 
+// applicationName tags the pool's connections in pg_stat_activity
+const applicationName = config.DBApplicationName
+
 // ConnectDB creates an optimized database connection pool
 func ConnectDB(dsn string) (*pgxpool.Pool, error) {
 	return ConnectDBWithConfig(dsn, DefaultDatabaseConfig())
@@ -48,6 +61,9 @@ func ConnectDBWithConfig(dsn string, dbConfig *DatabaseConfig) (*pgxpool.Pool, e
 		return nil, fmt.Errorf("failed to parse database DSN: %v", err)
 	}
 	config.ConnConfig.Tracer = otelpgx.NewTracer()
+	if dbConfig.Monitor != nil {
+		config.ConnConfig.Tracer = multitracer.New(otelpgx.NewTracer(), dbConfig.Monitor)
+	}
 
 	// Apply production-ready pool settings
 	config.MaxConns = dbConfig.MaxConns
@@ -59,7 +75,7 @@ func ConnectDBWithConfig(dsn string, dbConfig *DatabaseConfig) (*pgxpool.Pool, e
 	// Set up connection hooks for monitoring and initialization
 	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
 		// Set up any per-connection configuration
-		_, err := conn.Exec(ctx, "SET application_name = 'go-api-boilerplate'")
+		_, err := conn.Exec(ctx, "SET application_name = '"+applicationName+"'")
 		if err != nil {
 			log.Warn().Err(err).Msg("Failed to set application name")
 		}
@@ -96,26 +112,101 @@ func ConnectDBWithConfig(dsn string, dbConfig *DatabaseConfig) (*pgxpool.Pool, e
 	return dbpool, nil
 }
 
-// InitializeSchema creates the necessary database tables
-func InitializeSchema(db *pgxpool.Pool) error {
+// WarmupPool opens n connections before the server takes traffic. pgxpool
+// only fills MinConns in the background, so without this the first burst
+// after startup pays for connection setup. The connections are held together
+// so each one is distinct, then released to sit idle in the pool. It returns
+// how many were acquired, which is less than n if ctx ends first.
+func WarmupPool(ctx context.Context, pool *pgxpool.Pool, n int) (int, error) {
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		conns []*pgxpool.Conn
+		errs  []error
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := pool.Acquire(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			conns = append(conns, conn)
+		}()
+	}
+	wg.Wait()
+
+	for _, conn := range conns {
+		conn.Release()
+	}
+	if len(errs) > 0 {
+		return len(conns), fmt.Errorf("pool warmup acquired %d of %d connections: %w", len(conns), n, errs[0])
+	}
+	return len(conns), nil
+}
+
+// schemaLockID is the pg_advisory_lock key serializing schema initialization
+// and migrations across instances that start at the same time.
+const schemaLockID int64 = 727274001
+
+// schemaConn is what schema changes run on: a pinned connection, or the
+// transaction a migration is applied in
+type schemaConn interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// InitializeSchema creates the necessary database tables.
+// It holds a session-level advisory lock for the whole run, so concurrently
+// booting instances apply the DDL one after another instead of racing on it.
+func InitializeSchema(pool *pgxpool.Pool) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
+	// Advisory locks belong to a session, so pin a single connection
+	db, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection for schema init: %v", err)
+	}
+	defer db.Release()
+
+	if _, err := db.Exec(ctx, "SELECT pg_advisory_lock($1)", schemaLockID); err != nil {
+		return fmt.Errorf("failed to acquire schema lock: %v", err)
+	}
+	defer func() {
+		// Use a fresh context so the lock is released even if ctx timed out
+		unlockCtx, unlockCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer unlockCancel()
+		if _, err := db.Exec(unlockCtx, "SELECT pg_advisory_unlock($1)", schemaLockID); err != nil {
+			log.Warn().Err(err).Msg("Failed to release schema lock")
+		}
+	}()
+
+	return createInitialSchema(ctx, db)
+}
+
+// createInitialSchema runs the DDL of the initial schema on db
+func createInitialSchema(ctx context.Context, db schemaConn) error {
 	// --- Create Schemas ---
 	schemas := []string{
-		"CREATE SCHEMA IF NOT EXISTS auth;",     // For users and auth tables
-		"CREATE SCHEMA IF NOT EXISTS app_data;", // For shared app data (scrapes, alerts)
+		"CREATE SCHEMA IF NOT EXISTS {auth};", // For users and auth tables
+		"CREATE SCHEMA IF NOT EXISTS {app};",  // For shared app data (scrapes, alerts)
 	}
 
 	for _, schemaSQL := range schemas {
-		if _, err := db.Exec(ctx, schemaSQL); err != nil {
+		if _, err := db.Exec(ctx, dbschema.SQL(schemaSQL)); err != nil {
 			return fmt.Errorf("failed to create schema: %v", err)
 		}
 	}
 
 	// --- Auth Schema (Users) ---
-	createUsersTable := `
-	CREATE TABLE IF NOT EXISTS auth.users (
+	createUsersTable := dbschema.SQL(`
+	CREATE TABLE IF NOT EXISTS {auth}.users (
 		id UUID PRIMARY KEY,
 		username VARCHAR(50) UNIQUE NOT NULL,
 		email VARCHAR(100) UNIQUE NOT NULL,
@@ -124,27 +215,143 @@ func InitializeSchema(db *pgxpool.Pool) error {
 		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
 		updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
 		last_login TIMESTAMP WITH TIME ZONE
-	);`
+	);`)
 
 	_, err := db.Exec(ctx, createUsersTable)
 	if err != nil {
 		return fmt.Errorf("failed to create users table: %v", err)
 	}
 
+	// Columns added after the initial release
+	userColumns := []string{
+		"ALTER TABLE {auth}.users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user';",
+		"ALTER TABLE {auth}.users ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN NOT NULL DEFAULT false;",
+		"ALTER TABLE {auth}.users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT false;",
+		"ALTER TABLE {auth}.users ADD COLUMN IF NOT EXISTS username_normalized VARCHAR(200);",
+		"ALTER TABLE {auth}.users ADD COLUMN IF NOT EXISTS username_skeleton VARCHAR(200);",
+	}
+	for _, columnSQL := range userColumns {
+		if _, err := db.Exec(ctx, dbschema.SQL(columnSQL)); err != nil {
+			return fmt.Errorf("failed to add users column: %v", err)
+		}
+	}
+	if err := backfillUsernameForms(ctx, db); err != nil {
+		return fmt.Errorf("failed to backfill normalized usernames: %v", err)
+	}
+
 	// Create indexes for users table
 	userIndexes := []string{
-		"CREATE INDEX IF NOT EXISTS idx_users_email ON auth.users(email);",
-		"CREATE INDEX IF NOT EXISTS idx_users_username ON auth.users(username);",
+		"CREATE INDEX IF NOT EXISTS idx_users_email ON {auth}.users(email);",
+		"CREATE INDEX IF NOT EXISTS idx_users_username ON {auth}.users(username);",
+		"CREATE INDEX IF NOT EXISTS idx_users_role ON {auth}.users(role);",
+		// Fails (and is logged) if existing names differ only by case or
+		// Unicode form; resolve those by hand and restart to enforce it
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_normalized ON {auth}.users(username_normalized);",
+		"CREATE INDEX IF NOT EXISTS idx_users_username_skeleton ON {auth}.users(username_skeleton);",
 	}
 	for _, indexSQL := range userIndexes {
-		if _, err := db.Exec(ctx, indexSQL); err != nil {
+		if _, err := db.Exec(ctx, dbschema.SQL(indexSQL)); err != nil {
 			log.Warn().Err(err).Str("sql", indexSQL).Msg("Failed to create user index")
 		}
 	}
 
-	// Create update trigger for users table
-	updateTrigger := `
-	CREATE OR REPLACE FUNCTION auth.update_updated_at_column()
+	// --- Auth Schema (Audit Log & Login History) ---
+	auditTables := []string{
+		`CREATE TABLE IF NOT EXISTS {auth}.audit_log (
+			id UUID PRIMARY KEY,
+			actor_id UUID,
+			action VARCHAR(100) NOT NULL,
+			target_id VARCHAR(255),
+			ip_address VARCHAR(45),
+			metadata JSONB,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);`,
+		`CREATE TABLE IF NOT EXISTS {auth}.login_history (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES {auth}.users(id) ON DELETE CASCADE,
+			ip_address VARCHAR(45),
+			user_agent TEXT,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);`,
+	}
+	for _, tableSQL := range auditTables {
+		if _, err := db.Exec(ctx, dbschema.SQL(tableSQL)); err != nil {
+			return fmt.Errorf("failed to create audit table: %v", err)
+		}
+	}
+
+	// --- User Preferences ---
+	createPreferencesTable := dbschema.SQL(`
+	CREATE TABLE IF NOT EXISTS {auth}.user_preferences (
+		user_id UUID PRIMARY KEY REFERENCES {auth}.users(id) ON DELETE CASCADE,
+		email_enabled BOOLEAN NOT NULL DEFAULT true,
+		frequency VARCHAR(20) NOT NULL DEFAULT 'immediate',
+		notification_email VARCHAR(255),
+		notification_email_verified BOOLEAN NOT NULL DEFAULT false,
+		notification_email_token_hash CHAR(64),
+		notification_email_token_expires_at TIMESTAMP WITH TIME ZONE,
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);`)
+	if _, err := db.Exec(ctx, createPreferencesTable); err != nil {
+		return fmt.Errorf("failed to create user_preferences table: %v", err)
+	}
+
+	createPreferencesIndexes := dbschema.SQL(`
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_user_preferences_token ON {auth}.user_preferences(notification_email_token_hash);`)
+	if _, err := db.Exec(ctx, createPreferencesIndexes); err != nil {
+		return fmt.Errorf("failed to create user_preferences indexes: %v", err)
+	}
+
+	// --- API Keys ---
+	createAPIKeysTable := dbschema.SQL(`
+	CREATE TABLE IF NOT EXISTS {auth}.api_keys (
+		id UUID PRIMARY KEY,
+		user_id UUID NOT NULL REFERENCES {auth}.users(id) ON DELETE CASCADE,
+		name VARCHAR(100) NOT NULL,
+		prefix VARCHAR(32) UNIQUE NOT NULL,
+		key_hash CHAR(64) NOT NULL,
+		scopes TEXT[] NOT NULL DEFAULT '{}',
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		last_used_at TIMESTAMP WITH TIME ZONE,
+		revoked_at TIMESTAMP WITH TIME ZONE
+	);`)
+	if _, err := db.Exec(ctx, createAPIKeysTable); err != nil {
+		return fmt.Errorf("failed to create api_keys table: %v", err)
+	}
+	if _, err := db.Exec(ctx, dbschema.SQL("CREATE INDEX IF NOT EXISTS idx_api_keys_user ON {auth}.api_keys(user_id) WHERE revoked_at IS NULL;")); err != nil {
+		log.Warn().Err(err).Msg("Failed to create api_keys index")
+	}
+
+	// --- Single-use tokens (password reset, email verification) ---
+	createTokensTable := dbschema.SQL(`
+	CREATE TABLE IF NOT EXISTS {auth}.user_tokens (
+		token_hash CHAR(64) PRIMARY KEY,
+		user_id UUID NOT NULL REFERENCES {auth}.users(id) ON DELETE CASCADE,
+		purpose VARCHAR(32) NOT NULL,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		used_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);`)
+	if _, err := db.Exec(ctx, createTokensTable); err != nil {
+		return fmt.Errorf("failed to create user_tokens table: %v", err)
+	}
+
+	// Keyset pagination indexes (created_at DESC, id DESC)
+	auditIndexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_audit_log_keyset ON {auth}.audit_log(created_at DESC, id DESC);",
+		"CREATE INDEX IF NOT EXISTS idx_login_history_keyset ON {auth}.login_history(user_id, created_at DESC, id DESC);",
+	}
+	for _, indexSQL := range auditIndexes {
+		if _, err := db.Exec(ctx, dbschema.SQL(indexSQL)); err != nil {
+			log.Warn().Err(err).Str("sql", indexSQL).Msg("Failed to create audit index")
+		}
+	}
+
+	// Create update trigger for users table.
+	// CREATE OR REPLACE TRIGGER (PostgreSQL 14+) swaps the definition in place,
+	// so there is no window where the trigger is missing between a DROP and CREATE.
+	updateTrigger := dbschema.SQL(`
+	CREATE OR REPLACE FUNCTION {auth}.update_updated_at_column()
 	RETURNS TRIGGER AS $$
 	BEGIN
 		NEW.updated_at = NOW();
@@ -152,13 +359,17 @@ func InitializeSchema(db *pgxpool.Pool) error {
 	END;
 	$$ language 'plpgsql';
 
-	DROP TRIGGER IF EXISTS update_users_updated_at ON auth.users;
-	CREATE TRIGGER update_users_updated_at
-		BEFORE UPDATE ON auth.users
+	CREATE OR REPLACE TRIGGER update_users_updated_at
+		BEFORE UPDATE ON {auth}.users
 		FOR EACH ROW
-		EXECUTE FUNCTION auth.update_updated_at_column();`
-
-	if _, err = db.Exec(ctx, updateTrigger); err != nil {
+		EXECUTE FUNCTION {auth}.update_updated_at_column();`)
+
+	// Run apart, as a savepoint inside a migration, so a failure here does not
+	// abort the rest of the schema
+	if err := pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, updateTrigger)
+		return err
+	}); err != nil {
 		log.Warn().Err(err).Msg("Failed to create update trigger")
 	}
 
@@ -188,10 +399,28 @@ func StartConnectionMonitoring(db *pgxpool.Pool) {
 	}()
 }
 
-// HealthCheck performs a comprehensive database health check
-func HealthCheck(db *pgxpool.Pool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// HealthCheckDB is the subset of *pgxpool.Pool used by HealthCheck
+type HealthCheckDB interface {
+	Ping(ctx context.Context) error
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// HealthCheckOptions tunes HealthCheck. ReadOnly skips the transaction
+// round-trip for checks that run against a read replica.
+type HealthCheckOptions struct {
+	Timeout  time.Duration
+	ReadOnly bool
+}
+
+// HealthCheck performs a comprehensive database health check. It runs under
+// ctx so a cancelled request aborts the check; opts.Timeout further bounds it.
+func HealthCheck(ctx context.Context, db HealthCheckDB, opts HealthCheckOptions) error {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
 
 	// Test basic connectivity
 	if err := db.Ping(ctx); err != nil {
@@ -205,6 +434,10 @@ func HealthCheck(db *pgxpool.Pool) error {
 		return fmt.Errorf("query test failed: %v", err)
 	}
 
+	if opts.ReadOnly {
+		return nil
+	}
+
 	// Test transaction
 	tx, err := db.Begin(ctx)
 	if err != nil {
@@ -242,3 +475,36 @@ func GetConnectionStats(db *pgxpool.Pool) map[string]interface{} {
 		"max_idle_destroy_count":     stats.MaxIdleDestroyCount(),
 	}
 }
+
+// backfillUsernameForms fills the normalized and skeleton columns for rows
+// written before they existed. The forms are computed in Go because Postgres
+// has no equivalent of the confusable mapping.
+func backfillUsernameForms(ctx context.Context, db schemaConn) error {
+	rows, err := db.Query(ctx, dbschema.SQL("SELECT id, username FROM {auth}.users WHERE username_normalized IS NULL OR username_skeleton IS NULL"))
+	if err != nil {
+		return err
+	}
+	type pending struct{ id, name string }
+	var users []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.name); err != nil {
+			rows.Close()
+			return err
+		}
+		users = append(users, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		if _, err := db.Exec(ctx,
+			dbschema.SQL("UPDATE {auth}.users SET username_normalized = $1, username_skeleton = $2 WHERE id = $3"),
+			username.Normalize(u.name), username.Skeleton(u.name), u.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/dbschema"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/repository"
+	"azlo-goboiler/internal/service"
+	"azlo-goboiler/internal/username"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPool connects to TEST_DATABASE_URL, skipping integration tests when it is unset
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping database integration test")
+	}
+
+	db, err := ConnectDB(dsn)
+	require.NoError(t, err)
+	t.Cleanup(db.Close)
+	return db
+}
+
+func TestInitializeSchemaConcurrent(t *testing.T) {
+	// Two pools simulate two API instances booting at the same time
+	instances := []*pgxpool.Pool{testPool(t), testPool(t)}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(instances))
+	for i, db := range instances {
+		wg.Add(1)
+		go func(i int, db *pgxpool.Pool) {
+			defer wg.Done()
+			errs[i] = InitializeSchema(db)
+		}(i, db)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	var triggers int
+	err := instances[0].QueryRow(context.Background(), `
+		SELECT COUNT(*) FROM pg_trigger
+		WHERE tgname = 'update_users_updated_at'
+		  AND tgrelid = 'auth.users'::regclass
+		  AND NOT tgisinternal`).Scan(&triggers)
+	require.NoError(t, err)
+	assert.Equal(t, 1, triggers)
+}
+
+func TestInitializeSchemaCustomNames(t *testing.T) {
+	db := testPool(t)
+	ctx := context.Background()
+
+	suffix := uuid.NewString()[:8]
+	names := dbschema.Names{Auth: "test_auth_" + suffix, App: "test_app_" + suffix}
+	require.NoError(t, dbschema.Configure(names))
+	t.Cleanup(func() {
+		_, _ = db.Exec(ctx, "DROP SCHEMA IF EXISTS "+names.Auth+", "+names.App+" CASCADE")
+		_ = dbschema.Configure(dbschema.Names{Auth: dbschema.DefaultAuth, App: dbschema.DefaultApp})
+	})
+
+	require.NoError(t, InitializeSchema(db))
+
+	var usersTable *string
+	require.NoError(t, db.QueryRow(ctx, "SELECT to_regclass($1)::text", names.Auth+".users").Scan(&usersTable))
+	require.NotNil(t, usersTable)
+
+	cfg := &config.Config{App_Secret: "a-secret-that-is-definitely-32-chars-long", JWTExpirationHours: 1}
+	users := service.NewUserService(repository.NewUserRepository(db), nil, cfg, nil, nil)
+
+	registered, err := users.Register(ctx, models.RegisterRequest{
+		Username: "schema_" + suffix, Email: "schema_" + suffix + "@example.com", Password: "Password123!",
+	})
+	require.NoError(t, err)
+
+	login, err := users.Login(ctx, models.LoginRequest{Username: "schema_" + suffix, Password: "Password123!"})
+	require.NoError(t, err)
+	assert.Equal(t, registered.UserID, login.User.ID)
+	assert.NotEmpty(t, login.Token)
+
+	var count int
+	require.NoError(t, db.QueryRow(ctx, "SELECT COUNT(*) FROM "+names.Auth+".users WHERE id = $1", registered.UserID).Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+func TestInitializeSchemaBackfillsUsernameForms(t *testing.T) {
+	db := testPool(t)
+	ctx := context.Background()
+
+	// A custom schema name catches queries that skip dbschema.SQL
+	suffix := uuid.NewString()[:8]
+	names := dbschema.Names{Auth: "test_auth_" + suffix, App: "test_app_" + suffix}
+	require.NoError(t, dbschema.Configure(names))
+	t.Cleanup(func() {
+		_, _ = db.Exec(ctx, "DROP SCHEMA IF EXISTS "+names.Auth+", "+names.App+" CASCADE")
+		_ = dbschema.Configure(dbschema.Names{Auth: dbschema.DefaultAuth, App: dbschema.DefaultApp})
+	})
+	require.NoError(t, InitializeSchema(db))
+
+	// A row written before the normalized columns existed
+	id := uuid.NewString()
+	_, err := db.Exec(ctx, "INSERT INTO "+names.Auth+".users (id, username, email, password_hash) VALUES ($1, $2, $3, 'x')",
+		id, "Ｂob_"+suffix, "bob_"+suffix+"@example.com")
+	require.NoError(t, err)
+
+	require.NoError(t, InitializeSchema(db))
+
+	var normalized, skeleton *string
+	require.NoError(t, db.QueryRow(ctx, "SELECT username_normalized, username_skeleton FROM "+names.Auth+".users WHERE id = $1", id).
+		Scan(&normalized, &skeleton))
+	require.NotNil(t, normalized)
+	require.NotNil(t, skeleton)
+	assert.Equal(t, username.Normalize("Ｂob_"+suffix), *normalized)
+	assert.Equal(t, username.Skeleton("Ｂob_"+suffix), *skeleton)
+}
+
+func TestWarmupPool(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping database integration test")
+	}
+
+	cfg := DefaultDatabaseConfig()
+	cfg.MinConns = 4
+	// Keep the background health check from filling MinConns itself
+	cfg.HealthCheckPeriod = time.Hour
+	db, err := ConnectDBWithConfig(dsn, cfg)
+	require.NoError(t, err)
+	t.Cleanup(db.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	warmed, err := WarmupPool(ctx, db, int(cfg.MinConns))
+	require.NoError(t, err)
+	assert.Equal(t, int(cfg.MinConns), warmed)
+	assert.GreaterOrEqual(t, db.Stat().IdleConns(), cfg.MinConns)
+}
+
+func TestDBSessions(t *testing.T) {
+	db := testPool(t)
+	repo := repository.NewMaintenanceRepository(db)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	// Another application's connection runs a long query too
+	outsider, err := pgx.Connect(ctx, os.Getenv("TEST_DATABASE_URL"))
+	require.NoError(t, err)
+	defer outsider.Close(context.Background())
+	_, err = outsider.Exec(ctx, "SET application_name = 'someone-else'")
+	require.NoError(t, err)
+	outsiderCtx, stopOutsider := context.WithCancel(ctx)
+	defer stopOutsider()
+	outsiderDone := make(chan error, 1)
+	go func() {
+		_, err := outsider.Exec(outsiderCtx, "SELECT pg_sleep(30) /* outsider */")
+		outsiderDone <- err
+	}()
+
+	stuckDone := make(chan error, 1)
+	go func() {
+		_, err := db.Exec(ctx, "SELECT pg_sleep(30) /* stuck */")
+		stuckDone <- err
+	}()
+
+	var stuck models.DBSession
+	require.Eventually(t, func() bool {
+		sessions, err := repo.Sessions(ctx)
+		if err != nil {
+			return false
+		}
+		for _, s := range sessions {
+			if s.State == "active" && strings.Contains(s.Query, "stuck") {
+				stuck = s
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.GreaterOrEqual(t, stuck.DurationMs, int64(0))
+	require.NotNil(t, stuck.QueryStart)
+
+	t.Run("OtherApplicationsHidden", func(t *testing.T) {
+		var outsiderPID int32
+		require.Eventually(t, func() bool {
+			err := db.QueryRow(ctx, `SELECT pid FROM pg_stat_activity
+				WHERE application_name = 'someone-else' AND state = 'active'`).Scan(&outsiderPID)
+			return err == nil
+		}, 5*time.Second, 50*time.Millisecond)
+
+		sessions, err := repo.Sessions(ctx)
+		require.NoError(t, err)
+		for _, s := range sessions {
+			assert.NotEqual(t, outsiderPID, s.PID)
+			assert.NotContains(t, s.Query, "outsider")
+		}
+
+		_, err = repo.CancelSession(ctx, outsiderPID)
+		assert.ErrorIs(t, err, core.ErrDBSessionNotFound)
+		select {
+		case err := <-outsiderDone:
+			t.Fatalf("outsider query ended: %v", err)
+		case <-time.After(200 * time.Millisecond):
+		}
+	})
+
+	t.Run("CancelStuckQuery", func(t *testing.T) {
+		query, err := repo.CancelSession(ctx, stuck.PID)
+		require.NoError(t, err)
+		assert.Contains(t, query, "stuck")
+
+		select {
+		case err := <-stuckDone:
+			var pgErr *pgconn.PgError
+			require.ErrorAs(t, err, &pgErr)
+			assert.Equal(t, "57014", pgErr.Code, "query_canceled")
+		case <-time.After(5 * time.Second):
+			t.Fatal("query was not cancelled")
+		}
+	})
+
+	stopOutsider()
+	select {
+	case <-outsiderDone:
+	case <-time.After(5 * time.Second):
+	}
+}
+
+// fakeHealthDB records which checks ran. A zero pingDelay answers immediately;
+// otherwise Ping waits for the delay or the context, whichever comes first.
+type fakeHealthDB struct {
+	pingDelay time.Duration
+	began     bool
+}
+
+func (f *fakeHealthDB) Ping(ctx context.Context) error {
+	select {
+	case <-time.After(f.pingDelay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *fakeHealthDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return fakeRow{}
+}
+
+func (f *fakeHealthDB) Begin(ctx context.Context) (pgx.Tx, error) {
+	f.began = true
+	return nil, errors.New("begin not available")
+}
+
+type fakeRow struct{}
+
+func (fakeRow) Scan(dest ...any) error {
+	*(dest[0].(*string)) = "PostgreSQL 16"
+	return nil
+}
+
+func TestHealthCheck(t *testing.T) {
+	t.Run("ReadOnlySkipsTransaction", func(t *testing.T) {
+		db := &fakeHealthDB{}
+		err := HealthCheck(context.Background(), db, HealthCheckOptions{ReadOnly: true})
+		assert.NoError(t, err)
+		assert.False(t, db.began)
+	})
+
+	t.Run("DefaultRunsTransaction", func(t *testing.T) {
+		db := &fakeHealthDB{}
+		err := HealthCheck(context.Background(), db, HealthCheckOptions{})
+		assert.ErrorContains(t, err, "transaction begin failed")
+		assert.True(t, db.began)
+	})
+
+	t.Run("TimeoutBoundsCheck", func(t *testing.T) {
+		db := &fakeHealthDB{pingDelay: time.Second}
+		start := time.Now()
+		err := HealthCheck(context.Background(), db, HealthCheckOptions{Timeout: 20 * time.Millisecond})
+		assert.ErrorContains(t, err, "ping failed")
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+
+	t.Run("CallerCancellationAborts", func(t *testing.T) {
+		db := &fakeHealthDB{pingDelay: time.Second}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := HealthCheck(ctx, db, HealthCheckOptions{Timeout: time.Minute})
+		assert.ErrorContains(t, err, "context canceled")
+	})
+}
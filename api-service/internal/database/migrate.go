@@ -0,0 +1,299 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"azlo-goboiler/internal/dbschema"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrMigrationsPending is returned at startup when the database is behind
+// the schema version this build expects
+var ErrMigrationsPending = errors.New("database migrations pending")
+
+// Migration is one versioned schema change. Down undoes Up. Each runs in
+// the transaction that records it in schema_migrations.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, tx pgx.Tx) error
+	Down    func(ctx context.Context, tx pgx.Tx) error
+}
+
+// migrations lists every schema change in order. Version 1 is the schema
+// InitializeSchema builds; later changes are appended here rather than added
+// to it, so deployments that migrate separately can tell what is applied.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up:      func(ctx context.Context, tx pgx.Tx) error { return createInitialSchema(ctx, tx) },
+		Down:    dropInitialSchema,
+	},
+	{
+		Version: 2,
+		Name:    "user identities",
+		Up: execMigration(`
+		CREATE TABLE IF NOT EXISTS {auth}.user_identities (
+			provider VARCHAR(32) NOT NULL,
+			provider_user_id VARCHAR(255) NOT NULL,
+			user_id UUID NOT NULL REFERENCES {auth}.users(id) ON DELETE CASCADE,
+			email VARCHAR(255),
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (provider, provider_user_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_identities_user ON {auth}.user_identities(user_id);`),
+		Down: execMigration(`DROP TABLE IF EXISTS {auth}.user_identities;`),
+	},
+	{
+		Version: 3,
+		Name:    "password history",
+		Up: execMigration(`
+		CREATE TABLE IF NOT EXISTS {auth}.password_history (
+			id BIGSERIAL PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES {auth}.users(id) ON DELETE CASCADE,
+			password_hash VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_password_history_user ON {auth}.password_history(user_id, created_at DESC);`),
+		Down: execMigration(`DROP TABLE IF EXISTS {auth}.password_history;`),
+	},
+	{
+		Version: 4,
+		Name:    "preference length caps",
+		Up:      execMigration(preferenceLengthCapsSQL),
+		Down: execMigration(`
+		ALTER TABLE {auth}.user_preferences
+			DROP CONSTRAINT IF EXISTS user_preferences_frequency_length,
+			DROP CONSTRAINT IF EXISTS user_preferences_notification_email_length,
+			ALTER COLUMN frequency TYPE VARCHAR(20),
+			ALTER COLUMN notification_email TYPE VARCHAR(255);`),
+	},
+	{
+		Version: 5,
+		Name:    "recovery contacts",
+		Up: execMigration(`
+		CREATE TABLE IF NOT EXISTS {auth}.recovery_contacts (
+			user_id UUID NOT NULL REFERENCES {auth}.users(id) ON DELETE CASCADE,
+			kind VARCHAR(16) NOT NULL,
+			value VARCHAR(255) NOT NULL,
+			verified BOOLEAN NOT NULL DEFAULT false,
+			token_hash CHAR(64),
+			token_expires_at TIMESTAMP WITH TIME ZONE,
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (user_id, kind)
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_recovery_contacts_token ON {auth}.recovery_contacts(token_hash);`),
+		Down: execMigration(`DROP TABLE IF EXISTS {auth}.recovery_contacts;`),
+	},
+}
+
+// preferenceLengthCapsSQL is migration 4. It is frozen like every applied
+// migration, so its caps are literals; TestPreferenceLengthCaps checks they
+// still match models.MaxFrequencyLength and models.MaxNotificationEmailLength.
+const preferenceLengthCapsSQL = `
+		ALTER TABLE {auth}.user_preferences
+			DROP CONSTRAINT IF EXISTS user_preferences_frequency_length,
+			ALTER COLUMN frequency TYPE TEXT,
+			ADD CONSTRAINT user_preferences_frequency_length CHECK (char_length(frequency) <= 20),
+			DROP CONSTRAINT IF EXISTS user_preferences_notification_email_length,
+			ALTER COLUMN notification_email TYPE TEXT,
+			ADD CONSTRAINT user_preferences_notification_email_length CHECK (char_length(notification_email) <= 255);`
+
+// execMigration returns a migration step that runs sql, with schema names
+// substituted, as a single batch
+func execMigration(sql string) func(ctx context.Context, tx pgx.Tx) error {
+	return func(ctx context.Context, tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, dbschema.SQL(sql))
+		return err
+	}
+}
+
+// RequiredSchemaVersion is the schema version this build needs to run
+func RequiredSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// VersionQuerier is the subset of *pgxpool.Pool used to read the schema version
+type VersionQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// SchemaVersion returns the latest applied migration, or 0 for a database
+// that has never been migrated
+func SchemaVersion(ctx context.Context, db VersionQuerier) (int, error) {
+	var version int
+	err := db.QueryRow(ctx, dbschema.SQL("SELECT COALESCE(MAX(version), 0) FROM {auth}.schema_migrations")).Scan(&version)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (pgErr.Code == "42P01" || pgErr.Code == "3F000") {
+		// undefined_table / invalid_schema_name: nothing has been applied
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	return version, nil
+}
+
+// CheckSchemaVersion refuses to start against a database that is missing
+// migrations this build depends on. A newer schema is accepted, so an older
+// build keeps serving while a rollout that migrated first is in progress.
+func CheckSchemaVersion(ctx context.Context, db VersionQuerier, required int) error {
+	current, err := SchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if current < required {
+		return fmt.Errorf("%w: schema is at version %d, this build requires %d; run the migrate command first",
+			ErrMigrationsPending, current, required)
+	}
+	return nil
+}
+
+// MigrateUp applies every pending migration and returns the resulting version.
+// It holds the schema advisory lock throughout, so instances starting together
+// apply each migration once, and commits each migration together with its
+// schema_migrations row, so a failed one leaves nothing half applied.
+func MigrateUp(pool *pgxpool.Pool) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	conn, unlock, err := lockSchema(ctx, pool)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if err := ensureMigrationsTable(ctx, conn); err != nil {
+		return 0, err
+	}
+	current, err := SchemaVersion(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if err := m.Up(ctx, tx); err != nil {
+				return fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Name, err)
+			}
+			if _, err := tx.Exec(ctx, dbschema.SQL("INSERT INTO {auth}.schema_migrations (version, name) VALUES ($1, $2) ON CONFLICT (version) DO NOTHING"), m.Version, m.Name); err != nil {
+				return fmt.Errorf("failed to record migration %d: %v", m.Version, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return current, err
+		}
+		current = m.Version
+		log.Info().Int("version", m.Version).Str("name", m.Name).Msg("Applied migration")
+	}
+	return current, nil
+}
+
+// MigrateDown reverts the latest applied migration and returns the resulting
+// version. It is never run by the API, only by the migrate command.
+func MigrateDown(pool *pgxpool.Pool) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	conn, unlock, err := lockSchema(ctx, pool)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	current, err := SchemaVersion(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	if current == 0 {
+		return 0, nil
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version != current {
+			continue
+		}
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			// Deleted first, as the first migration's Down drops the version table
+			if _, err := tx.Exec(ctx, dbschema.SQL("DELETE FROM {auth}.schema_migrations WHERE version = $1"), m.Version); err != nil {
+				return fmt.Errorf("failed to record revert of migration %d: %v", m.Version, err)
+			}
+			if err := m.Down(ctx, tx); err != nil {
+				return fmt.Errorf("reverting migration %d (%s) failed: %v", m.Version, m.Name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return current, err
+		}
+		log.Info().Int("version", m.Version).Str("name", m.Name).Msg("Reverted migration")
+		return SchemaVersion(ctx, conn)
+	}
+	return current, fmt.Errorf("schema version %d is not known to this build", current)
+}
+
+// lockSchema pins a connection and takes the schema advisory lock on it.
+// unlock releases both.
+func lockSchema(ctx context.Context, pool *pgxpool.Pool) (*pgxpool.Conn, func(), error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to acquire connection for migrations: %v", err)
+	}
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", schemaLockID); err != nil {
+		conn.Release()
+		return nil, nil, fmt.Errorf("failed to acquire schema lock: %v", err)
+	}
+	unlock := func() {
+		// Use a fresh context so the lock is released even if ctx timed out
+		unlockCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := conn.Exec(unlockCtx, "SELECT pg_advisory_unlock($1)", schemaLockID); err != nil {
+			log.Warn().Err(err).Msg("Failed to release schema lock")
+		}
+		conn.Release()
+	}
+	return conn, unlock, nil
+}
+
+// ensureMigrationsTable creates the table recording applied migrations
+func ensureMigrationsTable(ctx context.Context, conn *pgxpool.Conn) error {
+	stmts := []string{
+		"CREATE SCHEMA IF NOT EXISTS {auth};",
+		`CREATE TABLE IF NOT EXISTS {auth}.schema_migrations (
+			version INT PRIMARY KEY,
+			name VARCHAR(100) NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := conn.Exec(ctx, dbschema.SQL(stmt)); err != nil {
+			return fmt.Errorf("failed to create schema_migrations table: %v", err)
+		}
+	}
+	return nil
+}
+
+// dropInitialSchema removes everything InitializeSchema creates. The schemas
+// themselves are left in place in case they hold tables of other apps.
+func dropInitialSchema(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, dbschema.SQL(`
+	DROP TABLE IF EXISTS
+		{auth}.user_tokens, {auth}.api_keys, {auth}.user_preferences,
+		{auth}.login_history, {auth}.audit_log, {auth}.users,
+		{auth}.schema_migrations CASCADE;
+	DROP FUNCTION IF EXISTS {auth}.update_updated_at_column() CASCADE;`))
+	return err
+}
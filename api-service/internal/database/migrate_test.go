@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"azlo-goboiler/internal/dbschema"
+	"azlo-goboiler/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVersionDB answers the schema version query with version, or err
+type fakeVersionDB struct {
+	version int
+	err     error
+}
+
+func (f fakeVersionDB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return versionRow(f)
+}
+
+type versionRow fakeVersionDB
+
+func (r versionRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	*(dest[0].(*int)) = r.version
+	return nil
+}
+
+func TestCheckSchemaVersion(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("RefusesOlderSchema", func(t *testing.T) {
+		err := CheckSchemaVersion(ctx, fakeVersionDB{version: 1}, 2)
+		require.ErrorIs(t, err, ErrMigrationsPending)
+		assert.ErrorContains(t, err, "version 1, this build requires 2")
+	})
+
+	t.Run("RefusesUnmigratedDatabase", func(t *testing.T) {
+		db := fakeVersionDB{err: &pgconn.PgError{Code: "42P01"}}
+		err := CheckSchemaVersion(ctx, db, RequiredSchemaVersion())
+		require.ErrorIs(t, err, ErrMigrationsPending)
+		assert.ErrorContains(t, err, "version 0")
+	})
+
+	t.Run("AcceptsCurrentSchema", func(t *testing.T) {
+		assert.NoError(t, CheckSchemaVersion(ctx, fakeVersionDB{version: 2}, 2))
+	})
+
+	t.Run("AcceptsNewerSchema", func(t *testing.T) {
+		assert.NoError(t, CheckSchemaVersion(ctx, fakeVersionDB{version: 3}, 2))
+	})
+
+	t.Run("QueryErrorIsNotPending", func(t *testing.T) {
+		err := CheckSchemaVersion(ctx, fakeVersionDB{err: errors.New("connection reset")}, 2)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrMigrationsPending)
+	})
+}
+
+func TestMigrateUpIsIdempotent(t *testing.T) {
+	db := testPool(t)
+	ctx := context.Background()
+
+	version, err := MigrateUp(db)
+	require.NoError(t, err)
+	assert.Equal(t, RequiredSchemaVersion(), version)
+	assert.NoError(t, CheckSchemaVersion(ctx, db, RequiredSchemaVersion()))
+
+	// Applying again is a no-op
+	version, err = MigrateUp(db)
+	require.NoError(t, err)
+	assert.Equal(t, RequiredSchemaVersion(), version)
+}
+
+func TestMigrateUpConcurrent(t *testing.T) {
+	// Two pools simulate two instances migrating at the same time
+	instances := []*pgxpool.Pool{testPool(t), testPool(t)}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(instances))
+	for i, db := range instances {
+		wg.Add(1)
+		go func(i int, db *pgxpool.Pool) {
+			defer wg.Done()
+			_, errs[i] = MigrateUp(db)
+		}(i, db)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, CheckSchemaVersion(context.Background(), instances[0], RequiredSchemaVersion()))
+}
+
+func TestMigrateUpRollsBackFailedMigration(t *testing.T) {
+	db := testPool(t)
+	ctx := context.Background()
+
+	version, err := MigrateUp(db)
+	require.NoError(t, err)
+
+	applied := migrations
+	t.Cleanup(func() { migrations = applied })
+	migrations = append(slices.Clip(applied), Migration{
+		Version: version + 1,
+		Name:    "half applied",
+		Up: func(ctx context.Context, tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, dbschema.SQL("CREATE TABLE {auth}.half_applied (id INT)")); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "SELECT 1/0")
+			return err
+		},
+	})
+
+	got, err := MigrateUp(db)
+	require.Error(t, err)
+	assert.Equal(t, version, got)
+
+	current, err := SchemaVersion(ctx, db)
+	require.NoError(t, err)
+	assert.Equal(t, version, current)
+
+	var exists bool
+	require.NoError(t, db.QueryRow(ctx, dbschema.SQL("SELECT to_regclass('{auth}.half_applied') IS NOT NULL")).Scan(&exists))
+	assert.False(t, exists, "table created by the failed migration was kept")
+}
+
+func TestPreferenceLengthCaps(t *testing.T) {
+	tagMax := regexp.MustCompile(`(?:^|,)max=(\d+)`)
+	for _, tc := range []struct {
+		column string
+		model  any
+		field  string
+		cap    int
+	}{
+		{"frequency", models.UpdatePreferencesRequest{}, "Frequency", models.MaxFrequencyLength},
+		{"notification_email", models.UpdateNotificationEmailRequest{}, "Email", models.MaxNotificationEmailLength},
+	} {
+		t.Run(tc.column, func(t *testing.T) {
+			field, ok := reflect.TypeOf(tc.model).FieldByName(tc.field)
+			require.True(t, ok)
+			m := tagMax.FindStringSubmatch(field.Tag.Get("validate"))
+			require.NotNil(t, m, "%s has no max= in its validate tag", tc.field)
+			assert.Equal(t, strconv.Itoa(tc.cap), m[1], "validate tag")
+
+			check := regexp.MustCompile(`CHECK \(char_length\(` + tc.column + `\) <= (\d+)\)`)
+			m = check.FindStringSubmatch(preferenceLengthCapsSQL)
+			require.NotNil(t, m, "migration 4 has no CHECK on %s", tc.column)
+			assert.Equal(t, strconv.Itoa(tc.cap), m[1], "migration CHECK")
+		})
+	}
+}
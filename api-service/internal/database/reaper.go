@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+)
+
+// reapInterval is how often the reaper checks the schedule and the pool
+const reapInterval = time.Minute
+
+// ReapSchedule is a daily window, in server local time, during which idle
+// pool connections beyond IdleConns are closed instead of waiting out
+// MaxConnIdleTime. Start and End are offsets from midnight; a window whose
+// End is not after Start wraps past midnight, so "22:00-06:00" is overnight.
+type ReapSchedule struct {
+	Start     time.Duration
+	End       time.Duration
+	IdleConns int32
+}
+
+// ParseReapSchedule parses a "HH:MM-HH:MM" window
+func ParseReapSchedule(window string, idleConns int) (ReapSchedule, error) {
+	from, to, ok := strings.Cut(window, "-")
+	if !ok {
+		return ReapSchedule{}, fmt.Errorf("reap schedule must be HH:MM-HH:MM (got %q)", window)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return ReapSchedule{}, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return ReapSchedule{}, err
+	}
+	if start == end {
+		return ReapSchedule{}, fmt.Errorf("reap schedule %q is empty", window)
+	}
+	if idleConns < 0 {
+		return ReapSchedule{}, fmt.Errorf("reap idle connections must not be negative (got %d)", idleConns)
+	}
+	return ReapSchedule{Start: start, End: end, IdleConns: int32(idleConns)}, nil
+}
+
+// parseClock turns "HH:MM" into an offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, want HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Active reports whether t falls inside the window
+func (s ReapSchedule) Active(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if s.Start < s.End {
+		return offset >= s.Start && offset < s.End
+	}
+	return offset >= s.Start || offset < s.End
+}
+
+// IdleTarget returns how many idle connections the pool may keep at t, or -1
+// outside the window when the pool is left to its own settings. The target
+// never drops below minConns: the pool's health check reopens connections
+// up to MinConns, so closing them would only churn.
+func (s ReapSchedule) IdleTarget(t time.Time, minConns int32) int32 {
+	if !s.Active(t) {
+		return -1
+	}
+	return max(s.IdleConns, minConns)
+}
+
+// RunReaper applies schedule to pool until ctx is done. Inside the window it
+// closes idle connections above the target; when the window ends it opens
+// MinConns again so the first requests of the day don't pay for connecting.
+func RunReaper(ctx context.Context, pool *pgxpool.Pool, schedule ReapSchedule, logger zerolog.Logger) {
+	minConns := pool.Config().MinConns
+	if schedule.IdleConns < minConns {
+		logger.Warn().
+			Int32("idle_conns", schedule.IdleConns).
+			Int32("min_conns", minConns).
+			Msg("Reap target is below DB_MIN_CONNS; keeping DB_MIN_CONNS idle connections")
+	}
+
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+
+	active := false
+	for {
+		target := schedule.IdleTarget(time.Now(), minConns)
+		switch {
+		case target >= 0:
+			if !active {
+				logger.Info().Int32("idle_target", target).Msg("Entering connection reap window")
+				active = true
+			}
+			if closed := reapIdle(ctx, pool, target); closed > 0 {
+				logger.Info().Int("closed", closed).Int32("idle_target", target).Msg("Reaped idle database connections")
+			}
+		case active:
+			active = false
+			warmCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			warmed, err := WarmupPool(warmCtx, pool, int(minConns))
+			cancel()
+			event := logger.Info()
+			if err != nil {
+				event = logger.Warn().Err(err)
+			}
+			event.Int("connections", warmed).Msg("Leaving connection reap window")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reapIdle closes idle connections beyond target and returns how many it closed
+func reapIdle(ctx context.Context, pool *pgxpool.Pool, target int32) int {
+	if pool.Stat().IdleConns() <= target {
+		return 0
+	}
+	closed := 0
+	for i, conn := range pool.AcquireAllIdle(ctx) {
+		if int32(i) < target {
+			conn.Release()
+			continue
+		}
+		// Hijacked connections leave the pool; closing them frees the backend
+		_ = conn.Hijack().Close(ctx)
+		closed++
+	}
+	return closed
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReapSchedule(t *testing.T) {
+	s, err := ParseReapSchedule("22:00-06:30", 2)
+	require.NoError(t, err)
+	assert.Equal(t, 22*time.Hour, s.Start)
+	assert.Equal(t, 6*time.Hour+30*time.Minute, s.End)
+	assert.Equal(t, int32(2), s.IdleConns)
+
+	for _, bad := range []string{"22:00", "25:00-06:00", "22:00-6pm", "08:00-08:00"} {
+		_, err := ParseReapSchedule(bad, 0)
+		assert.Error(t, err, bad)
+	}
+	_, err = ParseReapSchedule("22:00-06:00", -1)
+	assert.Error(t, err)
+}
+
+func TestReapScheduleIdleTarget(t *testing.T) {
+	at := func(clock string) time.Time {
+		tm, err := time.Parse("15:04", clock)
+		require.NoError(t, err)
+		return time.Date(2024, 3, 1, tm.Hour(), tm.Minute(), 0, 0, time.Local)
+	}
+
+	overnight, err := ParseReapSchedule("22:00-06:00", 1)
+	require.NoError(t, err)
+	daytime, err := ParseReapSchedule("12:00-13:00", 0)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		schedule ReapSchedule
+		clock    string
+		minConns int32
+		want     int32
+	}{
+		{"OvernightBeforeStart", overnight, "21:59", 0, -1},
+		{"OvernightAtStart", overnight, "22:00", 0, 1},
+		{"OvernightPastMidnight", overnight, "03:00", 0, 1},
+		{"OvernightAtEnd", overnight, "06:00", 0, -1},
+		{"BusinessHours", overnight, "10:00", 0, -1},
+		{"ClampedToMinConns", overnight, "23:00", 5, 5},
+		{"DaytimeInside", daytime, "12:30", 0, 0},
+		{"DaytimeOutside", daytime, "13:00", 0, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.schedule.IdleTarget(at(tt.clock), tt.minConns))
+		})
+	}
+}
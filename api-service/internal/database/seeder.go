@@ -3,14 +3,29 @@ package database
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/dbschema"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/username"
+	"azlo-goboiler/internal/validation"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// devDefaultPassword is the well-known development seed password; it must never guard a real admin
+const devDefaultPassword = "admin123!"
+
+// defaultUserEmail is the address given to the development default user
+const defaultUserEmail = "defaultuser@example.com"
+
 // SeedDefaultUser creates a default user for development environments.
 func SeedDefaultUser(app *config.Application) {
 	// Only seed in development environment
@@ -21,39 +36,101 @@ func SeedDefaultUser(app *config.Application) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Check if the default user already exists
-	var exists bool
-	err := app.DB.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM auth.users WHERE username = $1)", app.Config.DefaultUserUsername).Scan(&exists)
+	created, err := seedDefaultUser(ctx, app.DB, &app.Config)
 	if err != nil {
-		app.Logger.Error().Err(err).Msg("Failed to check for default user")
+		app.Logger.Error().Err(err).Msg("Failed to create default user")
 		return
 	}
-
-	if exists {
+	if !created {
 		app.Logger.Info().Str("username", app.Config.DefaultUserUsername).Msg("Default user already exists")
 		return
 	}
+	app.Logger.Info().Str("username", app.Config.DefaultUserUsername).Msg("Default user created successfully")
+}
+
+// Execer is the subset of *pgxpool.Pool the default user seeder writes through
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
 
-	// Hash the default password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(app.Config.DefaultUserPassword), bcrypt.DefaultCost)
+// seedDefaultUser inserts the default user unless a user with the same
+// username, normalized username or email already exists, and reports whether
+// it inserted. There is no separate existence check: the insert itself skips
+// on conflict, so a registration of the same name racing the seeder leaves
+// one row and no error.
+func seedDefaultUser(ctx context.Context, db Execer, cfg *config.Config) (bool, error) {
+	// Hashed up front even if the user exists; this only runs in development
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(cfg.DefaultUserPassword), bcrypt.DefaultCost)
 	if err != nil {
-		app.Logger.Error().Err(err).Msg("Failed to hash default user password")
-		return
+		return false, fmt.Errorf("failed to hash default user password: %w", err)
 	}
 
-	// Create the default user
-	userID := uuid.New().String()
 	now := time.Now()
+	tag, err := db.Exec(ctx, dbschema.SQL(`
+		INSERT INTO {auth}.users (id, username, email, password_hash, created_at, updated_at, is_active,
+			username_normalized, username_skeleton)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT DO NOTHING`),
+		uuid.New().String(), cfg.DefaultUserUsername, defaultUserEmail, string(hashedPassword), now, now, true,
+		username.Normalize(cfg.DefaultUserUsername), username.Skeleton(cfg.DefaultUserUsername))
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// SeedInitialAdmin creates the first admin account from INITIAL_ADMIN_* settings
+// when no active admin exists yet. It runs in every environment but only when
+// explicitly configured, and is a no-op once any admin is present. The account
+// is flagged so the user has to change the password after first signing in.
+func SeedInitialAdmin(ctx context.Context, repo core.UserRepository, cfg *config.Config, logger zerolog.Logger) error {
+	if cfg.InitialAdminUsername == "" && cfg.InitialAdminEmail == "" && cfg.InitialAdminPassword == "" {
+		return nil
+	}
 
-	_, err = app.DB.Exec(ctx, `
-		INSERT INTO auth.users (id, username, email, password_hash, created_at, updated_at, is_active)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-		userID, app.Config.DefaultUserUsername, "defaultuser@example.com", string(hashedPassword), now, now, true)
+	admin := models.InitialAdmin{
+		Username: cfg.InitialAdminUsername,
+		Email:    cfg.InitialAdminEmail,
+		Password: cfg.InitialAdminPassword,
+	}
+	if err := validation.ValidateStruct(admin); err != nil {
+		return fmt.Errorf("invalid initial admin configuration: %w", err)
+	}
+	if admin.Password == devDefaultPassword ||
+		(cfg.DefaultUserPassword != "" && admin.Password == cfg.DefaultUserPassword) {
+		return errors.New("invalid initial admin configuration: password must not reuse the development default")
+	}
 
+	count, err := repo.CountByRole(ctx, models.RoleAdmin)
 	if err != nil {
-		app.Logger.Error().Err(err).Msg("Failed to create default user")
-		return
+		return fmt.Errorf("failed to check for existing admin: %w", err)
+	}
+	if count > 0 {
+		logger.Info().Msg("Admin account already exists, skipping initial admin seed")
+		return nil
 	}
 
-	app.Logger.Info().Str("username", app.Config.DefaultUserUsername).Msg("Default user created successfully")
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(admin.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash initial admin password: %w", err)
+	}
+
+	now := time.Now()
+	user := &models.User{
+		ID:                 uuid.New().String(),
+		Username:           admin.Username,
+		Email:              admin.Email,
+		PasswordHash:       string(hashedPassword),
+		IsActive:           true,
+		Role:               models.RoleAdmin,
+		MustChangePassword: true,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+	if err := repo.Create(ctx, user); err != nil {
+		return fmt.Errorf("failed to create initial admin: %w", err)
+	}
+
+	logger.Warn().Str("username", user.Username).Msg("Initial admin account created; password change required on first login")
+	return nil
 }
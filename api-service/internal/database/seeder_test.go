@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/dbschema"
+	"azlo-goboiler/internal/mocks"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/repository"
+	"azlo-goboiler/internal/service"
+	"azlo-goboiler/internal/username"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func adminConfig() *config.Config {
+	return &config.Config{
+		App_Env:              "production",
+		InitialAdminUsername: "rootadmin",
+		InitialAdminEmail:    "root@example.com",
+		InitialAdminPassword: "Sup3r-Secret-Passphrase!",
+	}
+}
+
+func TestSeedInitialAdmin(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("AdminExists_Skip", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("CountByRole", mock.Anything, models.RoleAdmin).Return(1, nil)
+
+		err := SeedInitialAdmin(ctx, repo, adminConfig(), zerolog.Nop())
+
+		assert.NoError(t, err)
+		repo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+	})
+
+	t.Run("NoAdmin_Create", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("CountByRole", mock.Anything, models.RoleAdmin).Return(0, nil)
+		repo.On("Create", mock.Anything, mock.AnythingOfType("*models.User")).Return(nil)
+
+		err := SeedInitialAdmin(ctx, repo, adminConfig(), zerolog.Nop())
+		require.NoError(t, err)
+
+		created := repo.Calls[1].Arguments.Get(1).(*models.User)
+		assert.Equal(t, "rootadmin", created.Username)
+		assert.Equal(t, models.RoleAdmin, created.Role)
+		assert.True(t, created.MustChangePassword)
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(created.PasswordHash), []byte("Sup3r-Secret-Passphrase!")))
+	})
+
+	t.Run("NotConfigured_NoOp", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+
+		err := SeedInitialAdmin(ctx, repo, &config.Config{App_Env: "production"}, zerolog.Nop())
+
+		assert.NoError(t, err)
+		repo.AssertNotCalled(t, "CountByRole", mock.Anything, mock.Anything)
+	})
+
+	t.Run("WeakPassword_Rejected", func(t *testing.T) {
+		for _, password := range []string{"admin123!", "Short1!"} {
+			repo := new(mocks.MockUserRepository)
+			cfg := adminConfig()
+			cfg.InitialAdminPassword = password
+
+			err := SeedInitialAdmin(ctx, repo, cfg, zerolog.Nop())
+
+			assert.Error(t, err, password)
+			repo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+		}
+	})
+}
+
+// skippingExecer answers inserts as if another writer got there first
+type skippingExecer struct {
+	sql  string
+	args []any
+}
+
+func (e *skippingExecer) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	e.sql, e.args = sql, args
+	return pgconn.NewCommandTag("INSERT 0 0"), nil
+}
+
+func TestSeedDefaultUserSkipsOnConflict(t *testing.T) {
+	cfg := &config.Config{DefaultUserUsername: "Admin", DefaultUserPassword: "admin123!"}
+	db := &skippingExecer{}
+
+	created, err := seedDefaultUser(context.Background(), db, cfg)
+
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Contains(t, db.sql, "ON CONFLICT DO NOTHING")
+	assert.NotContains(t, db.sql, "SELECT")
+	// Stored in the same forms registration uses
+	assert.Equal(t, username.Normalize("Admin"), db.args[7])
+	assert.Equal(t, username.Skeleton("Admin"), db.args[8])
+}
+
+func TestSeedDefaultUserConcurrentRegistration(t *testing.T) {
+	db := testPool(t)
+	ctx := context.Background()
+
+	suffix := uuid.NewString()[:8]
+	names := dbschema.Names{Auth: "test_auth_" + suffix, App: "test_app_" + suffix}
+	require.NoError(t, dbschema.Configure(names))
+	t.Cleanup(func() {
+		_, _ = db.Exec(ctx, "DROP SCHEMA IF EXISTS "+names.Auth+", "+names.App+" CASCADE")
+		_ = dbschema.Configure(dbschema.Names{Auth: dbschema.DefaultAuth, App: dbschema.DefaultApp})
+	})
+	require.NoError(t, InitializeSchema(db))
+
+	cfg := &config.Config{App_Secret: "a-secret-that-is-definitely-32-chars-long", JWTExpirationHours: 1,
+		DefaultUserUsername: "admin", DefaultUserPassword: "admin123!"}
+	users := service.NewUserService(repository.NewUserRepository(db), nil, cfg, nil, nil)
+
+	// Several seeders and a registration of the same name start together
+	const seeders = 4
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make([]error, seeders)
+	for i := 0; i < seeders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			_, errs[i] = seedDefaultUser(ctx, db, cfg)
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-start
+		// Losing the race to the seeder is the expected failure here
+		_, _ = users.Register(ctx, models.RegisterRequest{Username: "Admin", Email: "admin_" + suffix + "@example.com", Password: "Password123!"})
+	}()
+	close(start)
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	var count int
+	require.NoError(t, db.QueryRow(ctx, "SELECT COUNT(*) FROM "+names.Auth+".users WHERE username_normalized = $1",
+		username.Normalize("admin")).Scan(&count))
+	assert.Equal(t, 1, count)
+}
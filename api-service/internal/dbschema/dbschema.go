@@ -0,0 +1,72 @@
+// Package dbschema holds the PostgreSQL schema names the application's tables
+// live in. Queries name them with the {auth} and {app} placeholders and pass
+// through SQL, so a deployment can rename the schemas with DB_AUTH_SCHEMA and
+// DB_APP_SCHEMA.
+//
+// Names are checked against a strict identifier pattern before use; that
+// check is what makes splicing them into SQL safe.
+package dbschema
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Default schema names
+const (
+	DefaultAuth = "auth"
+	DefaultApp  = "app_data"
+)
+
+// Names are the schemas in use
+type Names struct {
+	Auth string // users, sessions and everything else the API owns
+	App  string // shared application data
+}
+
+// identifier allows lowercase unquoted PostgreSQL identifiers only, so a name
+// never needs quoting and means the same quoted or not
+var identifier = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+type state struct {
+	names    Names
+	replacer *strings.Replacer
+}
+
+var current atomic.Pointer[state]
+
+func init() {
+	current.Store(newState(Names{Auth: DefaultAuth, App: DefaultApp}))
+}
+
+func newState(n Names) *state {
+	return &state{names: n, replacer: strings.NewReplacer("{auth}", n.Auth, "{app}", n.App)}
+}
+
+// Valid reports whether name is an acceptable schema name
+func Valid(name string) bool {
+	return identifier.MatchString(name) && !strings.HasPrefix(name, "pg_")
+}
+
+// Configure switches to n. Call it at startup, before the first query.
+func Configure(n Names) error {
+	for _, name := range []string{n.Auth, n.App} {
+		if !Valid(name) {
+			return fmt.Errorf("invalid schema name %q: use lowercase letters, digits and underscores, not starting with a digit or pg_", name)
+		}
+	}
+	current.Store(newState(n))
+	return nil
+}
+
+// Current returns the schema names in use
+func Current() Names {
+	return current.Load().names
+}
+
+// SQL expands the {auth} and {app} placeholders in query
+func SQL(query string) string {
+	return current.Load().replacer.Replace(query)
+}
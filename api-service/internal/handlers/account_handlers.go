@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/middleware"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/notification"
+	"azlo-goboiler/internal/notification/templates"
+	"azlo-goboiler/internal/validation"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ForgotPassword handles POST /auth/forgot-password
+// @Summary      Request a password reset
+// @Description  Emails a single-use reset link if an account uses this address. With use_recovery_email the link goes to the account's verified recovery email instead, and nowhere if it has none. The response is the same either way, and is sent before the account is even looked up, so neither its content nor its timing can be used to discover accounts.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body models.ForgotPasswordRequest true "Account email"
+// @Success      202  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string "Invalid request"
+// @Router       /auth/forgot-password [post]
+func (h *Handlers) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	requestID := getRequestID(r.Context())
+
+	var req models.ForgotPasswordRequest
+	if !decodeJSON(w, r, h.app, &req) {
+		return
+	}
+
+	if err := validation.ValidateStruct(&req); err != nil {
+		writeError(w, r, h.app, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The lookup, token and email all happen after the response, so how
+	// long it takes can't tell whether the account exists
+	ctx := context.WithoutCancel(r.Context())
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		ctx, cancel := context.WithTimeout(ctx, passwordResetTimeout)
+		defer cancel()
+		h.sendPasswordReset(ctx, requestID, req)
+	}()
+
+	writeResponse(w, r, h.app, http.StatusAccepted, true, nil,
+		"If an account uses that email, a reset link has been sent")
+}
+
+// passwordResetTimeout bounds the work ForgotPassword leaves running
+const passwordResetTimeout = time.Minute
+
+// sendPasswordReset issues a reset token for req and emails the link, if
+// an account matches. Failures are only logged; the caller has already been
+// answered.
+func (h *Handlers) sendPasswordReset(ctx context.Context, requestID string, req models.ForgotPasswordRequest) {
+	var token, to string
+	var user *models.User
+	var err error
+	if req.UseRecoveryEmail {
+		token, to, user, err = h.accounts.RequestRecoveryPasswordReset(ctx, req.Email)
+	} else {
+		token, user, err = h.accounts.RequestPasswordReset(ctx, req.Email)
+		if user != nil {
+			to = user.Email
+		}
+	}
+	if err != nil {
+		h.app.Logger.Error().Str("request_id", requestID).Err(err).Msg("Failed to issue password reset token")
+		return
+	}
+	if user == nil {
+		return
+	}
+
+	err = h.sendTemplate(ctx, to, templates.PasswordReset, templates.LinkData{
+		Username: user.Username,
+		Link:     h.publicLink("/reset-password", token),
+	})
+	if err != nil {
+		h.app.Logger.Warn().Str("request_id", requestID).Err(err).Msg("Failed to send password reset email")
+	}
+}
+
+// ResetPassword handles POST /auth/reset-password
+// @Summary      Reset password
+// @Description  Sets a new password using the emailed token. The token is checked again and consumed here, and the user's existing sessions are revoked. With PASSWORD_HISTORY_COUNT set, a recently used password is refused and the token is left unused.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body models.ResetPasswordRequest true "Token and new password"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string "Invalid or expired token, or new password was used recently"
+// @Router       /auth/reset-password [post]
+func (h *Handlers) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ResetPasswordRequest
+	if !decodeJSON(w, r, h.app, &req) {
+		return
+	}
+
+	if err := validation.ValidateStruct(&req); err != nil {
+		writeError(w, r, h.app, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, err := h.accounts.ResetPassword(r.Context(), req)
+	if err != nil {
+		h.writeTokenError(w, r, err, "Failed to reset password")
+		return
+	}
+
+	h.recordAudit(r, userID, models.AuditActionPasswordReset, userID, nil)
+
+	writeSuccess(w, r, h.app, nil, "Password reset successfully")
+}
+
+// VerifyEmail handles GET /auth/verify-email
+// @Summary      Verify email address
+// @Description  Confirms the account's email address using the emailed token
+// @Tags         auth
+// @Produce      json
+// @Param        token query string true "Verification token"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string "Invalid or expired token"
+// @Router       /auth/verify-email [get]
+func (h *Handlers) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.accounts.VerifyEmail(r.Context(), r.URL.Query().Get("token"))
+	if err != nil {
+		h.writeTokenError(w, r, err, "Failed to verify email")
+		return
+	}
+
+	h.recordAudit(r, userID, models.AuditActionEmailVerify, userID, nil)
+
+	writeSuccess(w, r, h.app, map[string]bool{"verified": true}, "Email verified")
+}
+
+// ValidateResetToken handles GET /auth/reset-password/validate
+// @Summary      Check a password reset token
+// @Description  Reports whether a reset token is valid without consuming it, so the frontend can decide whether to show the form
+// @Tags         auth
+// @Produce      json
+// @Param        token query string true "Reset token"
+// @Success      200  {object}  models.TokenValidation
+// @Failure      429  {object}  map[string]string "Rate limit exceeded"
+// @Router       /auth/reset-password/validate [get]
+func (h *Handlers) ValidateResetToken(w http.ResponseWriter, r *http.Request) {
+	h.validateToken(w, r, models.TokenPurposePasswordReset)
+}
+
+// ValidateVerifyEmailToken handles GET /auth/verify-email/validate
+// @Summary      Check an email verification token
+// @Description  Reports whether a verification token is valid without consuming it
+// @Tags         auth
+// @Produce      json
+// @Param        token query string true "Verification token"
+// @Success      200  {object}  models.TokenValidation
+// @Failure      429  {object}  map[string]string "Rate limit exceeded"
+// @Router       /auth/verify-email/validate [get]
+func (h *Handlers) ValidateVerifyEmailToken(w http.ResponseWriter, r *http.Request) {
+	h.validateToken(w, r, models.TokenPurposeEmailVerify)
+}
+
+func (h *Handlers) validateToken(w http.ResponseWriter, r *http.Request, purpose string) {
+	result, err := h.accounts.ValidateToken(r.Context(), purpose, r.URL.Query().Get("token"))
+	if err != nil {
+		h.app.Logger.Error().Str("request_id", getRequestID(r.Context())).Err(err).Msg("Token validation failed")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Failed to validate token")
+		return
+	}
+	writeSuccess(w, r, h.app, result, "Token checked")
+}
+
+// sendEmailVerification emails a verification link on a best-effort basis;
+// the user can ask for another one later
+func (h *Handlers) sendEmailVerification(r *http.Request, userID, username, email string) {
+	requestID := getRequestID(r.Context())
+
+	token, err := h.accounts.IssueEmailVerification(r.Context(), userID)
+	if err != nil {
+		h.app.Logger.Error().Str("request_id", requestID).Err(err).Msg("Failed to issue email verification token")
+		return
+	}
+
+	err = h.sendTemplate(r.Context(), email, templates.Verification, templates.LinkData{
+		Username: username,
+		Link:     h.publicLink("/verify-email", token),
+	})
+	if err != nil {
+		h.app.Logger.Warn().Str("request_id", requestID).Err(err).Msg("Failed to send verification email")
+	}
+}
+
+// sendTemplate renders the named email template and sends it to to
+func (h *Handlers) sendTemplate(ctx context.Context, to, name string, data interface{}) error {
+	email, err := templates.Render(name, data)
+	if err != nil {
+		return err
+	}
+	return h.mailer.Send(ctx, notification.Message{
+		To:       to,
+		Subject:  email.Subject,
+		TextBody: email.Text,
+		HTMLBody: email.HTML,
+	})
+}
+
+// notify tells the user about an event on the channels they have enabled.
+// Like the other emails it is best-effort and never fails the request.
+func (h *Handlers) notify(r *http.Request, userID, eventType string) {
+	if h.notifier == nil {
+		return
+	}
+	err := h.notifier.Notify(r.Context(), userID, notification.Event{
+		Type:      eventType,
+		IPAddress: middleware.ClientIP(r),
+		At:        time.Now(),
+	})
+	if err != nil {
+		h.app.Logger.Warn().
+			Str("request_id", getRequestID(r.Context())).
+			Str("event", eventType).
+			Err(err).
+			Msg("Failed to deliver notification")
+	}
+}
+
+// publicLink builds a frontend URL carrying a token
+func (h *Handlers) publicLink(path, token string) string {
+	return strings.TrimRight(h.app.Config.PublicURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// passwordReusedMessage answers a password change or reset that repeats one
+// of the user's recent passwords
+const passwordReusedMessage = "New password must differ from your recent passwords"
+
+func (h *Handlers) writeTokenError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	if errors.Is(err, core.ErrVerificationTokenInvalid) {
+		writeError(w, r, h.app, http.StatusBadRequest, "Invalid or expired token")
+		return
+	}
+	if errors.Is(err, core.ErrPasswordReused) {
+		writeError(w, r, h.app, http.StatusBadRequest, passwordReusedMessage)
+		return
+	}
+	if errors.Is(err, core.ErrHasherBusy) {
+		writeBusy(w, r, h.app)
+		return
+	}
+	h.app.Logger.Error().Str("request_id", getRequestID(r.Context())).Err(err).Msg(msg)
+	writeError(w, r, h.app, http.StatusInternalServerError, msg)
+}
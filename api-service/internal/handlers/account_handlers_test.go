@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/mocks"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/service"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestForgotPasswordRecoveryEmail(t *testing.T) {
+	user := &models.User{ID: "user-1", Username: "alice", Email: "alice@example.com", IsActive: true}
+
+	forgot := func(t *testing.T, contact *models.RecoveryContact, body string) (*httptest.ResponseRecorder, *stubSender, *mocks.MockTokenRepository) {
+		users := new(mocks.MockUserRepository)
+		users.On("GetByEmailOrUsername", mock.Anything, "alice@example.com", "").Return(user, nil)
+		users.On("GetRecoveryContact", mock.Anything, "user-1", models.RecoveryContactEmail).Return(contact, nil)
+		tokens := new(mocks.MockTokenRepository)
+		tokens.On("Create", mock.Anything, mock.AnythingOfType("*models.UserToken")).Return(nil)
+		accounts := service.NewAccountService(users, tokens, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+
+		sender := &stubSender{}
+		h := New(newTestApp(), nil, nil, nil, accounts, sender, nil, nil, nil, nil, nil)
+		rec := httptest.NewRecorder()
+		h.ForgotPassword(rec, httptest.NewRequest(http.MethodPost, "/auth/forgot-password", strings.NewReader(body)))
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		h.background.Wait()
+		return rec, sender, tokens
+	}
+	const viaRecovery = `{"email":"alice@example.com","use_recovery_email":true}`
+
+	t.Run("VerifiedRecoveryEmailReceivesReset", func(t *testing.T) {
+		_, sender, tokens := forgot(t, &models.RecoveryContact{Kind: models.RecoveryContactEmail, Value: "backup@example.net", Verified: true}, viaRecovery)
+		require.Len(t, sender.sent, 1)
+		assert.Equal(t, "backup@example.net", sender.sent[0].To)
+		assert.Contains(t, sender.sent[0].TextBody, "/reset-password?token=")
+		tokens.AssertNumberOfCalls(t, "Create", 1)
+	})
+
+	t.Run("UnverifiedRecoveryEmailGetsNothing", func(t *testing.T) {
+		rec, sender, tokens := forgot(t, &models.RecoveryContact{Kind: models.RecoveryContactEmail, Value: "backup@example.net"}, viaRecovery)
+		assert.Empty(t, sender.sent)
+		tokens.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+		assert.Equal(t, "If an account uses that email, a reset link has been sent", decodeBody(t, rec)["message"])
+	})
+
+	t.Run("NoRecoveryEmailGetsNothing", func(t *testing.T) {
+		_, sender, tokens := forgot(t, nil, viaRecovery)
+		assert.Empty(t, sender.sent)
+		tokens.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+	})
+
+	t.Run("LoginEmailByDefault", func(t *testing.T) {
+		_, sender, _ := forgot(t, &models.RecoveryContact{Kind: models.RecoveryContactEmail, Value: "backup@example.net", Verified: true},
+			`{"email":"alice@example.com"}`)
+		require.Len(t, sender.sent, 1)
+		assert.Equal(t, "alice@example.com", sender.sent[0].To)
+	})
+}
+
+func TestForgotPasswordRespondsBeforeLookup(t *testing.T) {
+	// The account lookup blocks until released; the response must not wait
+	// for it, or its timing would tell whether the account exists
+	release := make(chan struct{})
+	users := new(mocks.MockUserRepository)
+	users.On("GetByEmailOrUsername", mock.Anything, "alice@example.com", "").
+		Run(func(mock.Arguments) { <-release }).
+		Return(&models.User{ID: "user-1", Username: "alice", Email: "alice@example.com", IsActive: true}, nil)
+	tokens := new(mocks.MockTokenRepository)
+	tokens.On("Create", mock.Anything, mock.AnythingOfType("*models.UserToken")).Return(nil)
+	accounts := service.NewAccountService(users, tokens, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+
+	sender := &stubSender{}
+	h := New(newTestApp(), nil, nil, nil, accounts, sender, nil, nil, nil, nil, nil)
+	rec := httptest.NewRecorder()
+	h.ForgotPassword(rec, httptest.NewRequest(http.MethodPost, "/auth/forgot-password", strings.NewReader(`{"email":"alice@example.com"}`)))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	close(release)
+	h.background.Wait()
+	require.Len(t, sender.sent, 1)
+	assert.Equal(t, "alice@example.com", sender.sent[0].To)
+}
+
+func TestWaitForBackgroundWork(t *testing.T) {
+	release := make(chan struct{})
+	users := new(mocks.MockUserRepository)
+	users.On("GetByEmailOrUsername", mock.Anything, "alice@example.com", "").
+		Run(func(mock.Arguments) { <-release }).
+		Return(nil, nil)
+	accounts := service.NewAccountService(users, new(mocks.MockTokenRepository), new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+
+	h := New(newTestApp(), nil, nil, nil, accounts, &stubSender{}, nil, nil, nil, nil, nil)
+	rec := httptest.NewRecorder()
+	h.ForgotPassword(rec, httptest.NewRequest(http.MethodPost, "/auth/forgot-password", strings.NewReader(`{"email":"alice@example.com"}`)))
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, h.Wait(ctx), context.DeadlineExceeded, "the lookup is still running")
+
+	close(release)
+	assert.NoError(t, h.Wait(context.Background()))
+}
+
+func TestUpdateRecoveryEmail(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("Current123!"), bcrypt.MinCost)
+	require.NoError(t, err)
+	user := &models.User{ID: "user-1", Username: "alice", Email: "alice@example.com", PasswordHash: string(hash)}
+
+	update := func(t *testing.T, body string) (*httptest.ResponseRecorder, *stubSender, *mocks.MockUserRepository) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByID", mock.Anything, "user-1").Return(user, nil)
+		repo.On("SetPendingRecoveryContact", mock.Anything, "user-1", models.RecoveryContactEmail, "backup@example.net", mock.Anything, mock.Anything).Return(nil)
+		users := service.NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+
+		audit := new(mocks.MockAuditService)
+		audit.On("Record", mock.Anything, mock.Anything).Return(nil)
+
+		sender := &stubSender{}
+		h := New(newTestApp(), users, audit, nil, nil, sender, nil, nil, nil, nil, nil)
+		rec := httptest.NewRecorder()
+		h.UpdateRecoveryEmail(rec, authedRequest(http.MethodPut, "/api/v1/profile/recovery-email", body, "user-1"))
+		return rec, sender, repo
+	}
+
+	t.Run("AlertsLoginEmail", func(t *testing.T) {
+		rec, sender, _ := update(t, `{"email":"backup@example.net","current_password":"Current123!"}`)
+		require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+		require.Len(t, sender.sent, 2)
+		assert.Equal(t, "backup@example.net", sender.sent[0].To, "verification link")
+		assert.Equal(t, "alice@example.com", sender.sent[1].To, "change alert")
+		assert.Equal(t, "Your recovery email was changed", sender.sent[1].Subject)
+		assert.Contains(t, sender.sent[1].TextBody, "backup@example.net")
+	})
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		rec, sender, repo := update(t, `{"email":"backup@example.net","current_password":"Wrong123!"}`)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Empty(t, sender.sent)
+		repo.AssertNotCalled(t, "SetPendingRecoveryContact", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("PasswordRequired", func(t *testing.T) {
+		rec, _, _ := update(t, `{"email":"backup@example.net"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
@@ -0,0 +1,492 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/middleware"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/notification"
+	"azlo-goboiler/internal/notification/templates"
+	"azlo-goboiler/internal/validation"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RevokeAllSessions handles POST /api/v1/admin/security/revoke-all-sessions
+// @Summary      Revoke all sessions (break-glass)
+// @Description  Invalidates every issued token for every user. All users, including the caller, must log in again. Requires the admin role.
+// @Tags         admin
+// @Security     Bearer
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]string "Admin role required"
+// @Failure      500  {object}  map[string]string "Internal server error"
+// @Router       /api/v1/admin/security/revoke-all-sessions [post]
+func (h *Handlers) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	requestID := getRequestID(r.Context())
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	epoch, err := h.service.RevokeAllSessions(r.Context())
+	if err != nil {
+		h.app.Logger.Error().
+			Str("request_id", requestID).
+			Str("user_id", userID).
+			Err(err).
+			Msg("Global session revocation failed")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	h.recordAudit(r, userID, models.AuditActionRevokeAllSessions, "", map[string]interface{}{
+		"epoch": epoch.Format(time.RFC3339),
+	})
+
+	h.app.Logger.Warn().
+		Str("request_id", requestID).
+		Str("user_id", userID).
+		Time("epoch", epoch).
+		Msg("BREAK-GLASS: all sessions revoked globally")
+
+	writeSuccess(w, r, h.app, map[string]interface{}{
+		"revoked_before": epoch,
+	}, "All sessions revoked")
+}
+
+// SendTestNotification handles POST /api/v1/admin/notifications/test
+// @Summary      Send a test email
+// @Description  Sends a smoke-test email through the configured SMTP relay and reports the outcome. Requires the admin role.
+// @Tags         admin
+// @Security     Bearer
+// @Accept       json
+// @Produce      json
+// @Param        request body models.TestNotificationRequest true "Recipient"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string "Invalid request"
+// @Failure      403  {object}  map[string]string "Admin role required"
+// @Failure      429  {object}  map[string]string "Rate limit exceeded"
+// @Failure      502  {object}  map[string]string "SMTP delivery failed"
+// @Failure      503  {object}  map[string]string "Email delivery not configured"
+// @Router       /api/v1/admin/notifications/test [post]
+func (h *Handlers) SendTestNotification(w http.ResponseWriter, r *http.Request) {
+	requestID := getRequestID(r.Context())
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	var req models.TestNotificationRequest
+	if !decodeJSON(w, r, h.app, &req) {
+		return
+	}
+
+	if err := validation.ValidateStruct(&req); err != nil {
+		writeError(w, r, h.app, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sentAt := time.Now().UTC()
+	err := h.sendTemplate(r.Context(), req.Recipient, templates.TestMessage, templates.TestMessageData{
+		SentAt: sentAt.Format(time.RFC1123),
+	})
+
+	h.recordAudit(r, userID, models.AuditActionTestNotification, "", map[string]interface{}{
+		"recipient": req.Recipient,
+		"success":   err == nil,
+	})
+
+	if err != nil {
+		h.app.Logger.Warn().
+			Str("request_id", requestID).
+			Str("recipient", req.Recipient).
+			Err(err).
+			Msg("Test notification failed")
+
+		var sendErr *notification.SendError
+		switch {
+		case errors.Is(err, notification.ErrNotConfigured):
+			writeError(w, r, h.app, http.StatusServiceUnavailable, "Email delivery is not configured")
+		case errors.As(err, &sendErr):
+			writeError(w, r, h.app, http.StatusBadGateway, "Failed to send test email: "+sendErr.Error())
+		default:
+			writeError(w, r, h.app, http.StatusBadGateway, "Failed to send test email")
+		}
+		return
+	}
+
+	writeSuccess(w, r, h.app, map[string]interface{}{
+		"recipient": req.Recipient,
+		"sent_at":   sentAt,
+	}, "Test email sent")
+}
+
+// MergeUsers handles POST /api/v1/admin/users/merge
+// @Summary      Merge duplicate accounts
+// @Description  Moves the source user's preferences, login history and audit log entries to the target, revokes the source's API keys and sessions, and deactivates the source. The target's preferences win when both have them. Requires the admin role.
+// @Tags         admin
+// @Security     Bearer
+// @Accept       json
+// @Produce      json
+// @Param        request body models.MergeUsersRequest true "Accounts to merge"
+// @Success      200  {object}  models.MergeResult
+// @Failure      400  {object}  map[string]string "Invalid request"
+// @Failure      403  {object}  map[string]string "Admin role required"
+// @Failure      404  {object}  map[string]string "User not found"
+// @Router       /api/v1/admin/users/merge [post]
+func (h *Handlers) MergeUsers(w http.ResponseWriter, r *http.Request) {
+	requestID := getRequestID(r.Context())
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	if !h.requireAdmin(w, r, userID) {
+		return
+	}
+
+	var req models.MergeUsersRequest
+	if !decodeJSON(w, r, h.app, &req) {
+		return
+	}
+
+	if err := validation.ValidateStruct(&req); err != nil {
+		writeError(w, r, h.app, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := h.service.MergeUsers(r.Context(), req)
+	if err != nil {
+		h.recordAudit(r, userID, models.AuditActionMergeUsers, req.SourceUserID, map[string]interface{}{
+			"source_user_id": req.SourceUserID,
+			"target_user_id": req.TargetUserID,
+			"success":        false,
+			"error":          err.Error(),
+		})
+
+		switch {
+		case errors.Is(err, core.ErrMergeSameUser):
+			writeError(w, r, h.app, http.StatusBadRequest, "Source and target must be different users")
+		case errors.Is(err, core.ErrUserNotFound):
+			writeError(w, r, h.app, http.StatusNotFound, "Source or target user not found")
+		default:
+			h.app.Logger.Error().
+				Str("request_id", requestID).
+				Str("source_user_id", req.SourceUserID).
+				Str("target_user_id", req.TargetUserID).
+				Err(err).
+				Msg("User merge failed and was rolled back")
+			writeError(w, r, h.app, http.StatusInternalServerError, "Failed to merge users")
+		}
+		return
+	}
+
+	h.recordAudit(r, userID, models.AuditActionMergeUsers, req.SourceUserID, map[string]interface{}{
+		"source_user_id":      result.SourceUserID,
+		"target_user_id":      result.TargetUserID,
+		"success":             true,
+		"preferences_moved":   result.PreferencesMoved,
+		"login_history_moved": result.LoginHistoryMoved,
+		"audit_rows_moved":    result.AuditRowsMoved,
+		"api_keys_revoked":    result.APIKeysRevoked,
+		"sessions_revoked":    result.SessionsRevoked,
+	})
+
+	if !result.SessionsRevoked {
+		h.app.Logger.Warn().
+			Str("request_id", requestID).
+			Str("source_user_id", result.SourceUserID).
+			Msg("Users merged but source sessions could not be revoked")
+	}
+
+	h.app.Logger.Warn().
+		Str("request_id", requestID).
+		Str("user_id", userID).
+		Str("source_user_id", result.SourceUserID).
+		Str("target_user_id", result.TargetUserID).
+		Msg("Users merged")
+
+	writeSuccess(w, r, h.app, result, "Users merged successfully")
+}
+
+// ImportUsers handles POST /api/v1/admin/users/import
+// @Summary      Import users
+// @Description  Creates up to 100 accounts in one call. Each item is validated and created on its own, so one bad item does not fail the batch. The response is always 207 Multi-Status with a result per item: 201 created, 400 invalid, 409 already exists (including earlier in the same batch), 429 server busy. Imported users are not sent verification emails. Requires the admin role.
+// @Tags         admin
+// @Security     Bearer
+// @Accept       json
+// @Produce      json
+// @Param        request body models.ImportUsersRequest true "Accounts to create"
+// @Success      207  {object}  models.BulkResult
+// @Failure      400  {object}  map[string]string "Invalid request"
+// @Failure      403  {object}  map[string]string "Admin role required"
+// @Router       /api/v1/admin/users/import [post]
+func (h *Handlers) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	requestID := getRequestID(r.Context())
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	if !h.requireAdmin(w, r, userID) {
+		return
+	}
+
+	var req models.ImportUsersRequest
+	if !decodeJSON(w, r, h.app, &req) {
+		return
+	}
+
+	if err := validation.ValidateStruct(&req); err != nil {
+		writeError(w, r, h.app, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Users) > models.MaxBulkItems {
+		writeError(w, r, h.app, http.StatusBadRequest, fmt.Sprintf("At most %d users per import", models.MaxBulkItems))
+		return
+	}
+
+	results := make([]models.BulkItemResult, len(req.Users))
+	var created []string
+	for i, item := range req.Users {
+		results[i] = models.BulkItemResult{Index: i}
+
+		if err := validation.ValidateStruct(&item); err != nil {
+			results[i].Status, results[i].Error = http.StatusBadRequest, err.Error()
+			continue
+		}
+
+		resp, err := h.service.Register(r.Context(), item)
+		switch {
+		case err == nil:
+			results[i].Status, results[i].ID = http.StatusCreated, resp.UserID
+			created = append(created, resp.UserID)
+		case errors.Is(err, core.ErrUserExists):
+			results[i].Status, results[i].Error = http.StatusConflict, err.Error()
+		case errors.Is(err, core.ErrHasherBusy):
+			results[i].Status, results[i].Error = http.StatusTooManyRequests, "Server busy, please retry"
+		default:
+			h.app.Logger.Error().
+				Str("request_id", requestID).
+				Int("index", i).
+				Err(err).
+				Msg("User import item failed")
+			results[i].Status, results[i].Error = http.StatusInternalServerError, "Registration failed"
+		}
+	}
+
+	h.recordAudit(r, userID, models.AuditActionImportUsers, "", map[string]interface{}{
+		"requested":   len(req.Users),
+		"created_ids": created,
+	})
+
+	h.app.Logger.Info().
+		Str("request_id", requestID).
+		Str("user_id", userID).
+		Int("requested", len(req.Users)).
+		Int("created", len(created)).
+		Msg("Users imported")
+
+	writeMultiStatus(w, r, h.app, results, "Users import processed")
+}
+
+// ExportUsers handles GET /api/v1/admin/users/export
+// @Summary      Export users
+// @Description  Downloads every user, active or not, oldest first: id, username, email, created_at, last_login and status. CSV with a header row for format=csv or an Accept of text/csv, otherwise a JSON array. Rows are streamed as they are read, so a failure partway through ends the download early. Requires the admin role.
+// @Tags         admin
+// @Security     Bearer
+// @Produce      text/csv
+// @Produce      json
+// @Param        format query     string  false  "csv or json"
+// @Success      200  {array}   models.UserExportRow
+// @Failure      400  {object}  map[string]string "Unknown format"
+// @Failure      403  {object}  map[string]string "Admin role required"
+// @Router       /api/v1/admin/users/export [get]
+func (h *Handlers) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	requestID := getRequestID(r.Context())
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	if !h.requireAdmin(w, r, userID) {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportJSON
+		if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			format = exportCSV
+		}
+	}
+	var out userExporter
+	switch format {
+	case exportCSV:
+		out = &csvUserExporter{}
+	case exportJSON:
+		out = &jsonUserExporter{}
+	default:
+		writeError(w, r, h.app, http.StatusBadRequest, "format must be csv or json")
+		return
+	}
+
+	// Headers go out with the first row, so a query that fails straight
+	// away still gets a proper error
+	started := false
+	start := func() {
+		started = true
+		w.Header().Set("Content-Type", out.contentType())
+		w.Header().Set(contentDispositionHeader, `attachment; filename="users.`+format+`"`)
+		w.WriteHeader(http.StatusOK)
+		out.begin(w)
+	}
+	count := 0
+	err := h.service.ExportUsers(r.Context(), func(row models.UserExportRow) error {
+		if !started {
+			start()
+		}
+		count++
+		return out.write(row)
+	})
+	if err != nil {
+		h.app.Logger.Error().
+			Str("request_id", requestID).
+			Int("exported", count).
+			Err(err).
+			Msg("User export failed")
+		if !started {
+			writeError(w, r, h.app, http.StatusInternalServerError, "Failed to export users")
+		}
+		// Otherwise the download just ends; a JSON export is left unclosed
+		return
+	}
+	if !started {
+		start()
+	}
+	if err := out.end(); err != nil {
+		h.app.Logger.Error().Str("request_id", requestID).Err(err).Msg("Failed to write user export")
+		return
+	}
+
+	h.recordAudit(r, userID, models.AuditActionExportUsers, "", map[string]interface{}{
+		"format": format,
+		"count":  count,
+	})
+}
+
+// Export formats, as named in the format parameter
+const (
+	exportCSV  = "csv"
+	exportJSON = "json"
+)
+
+// userExporter writes an export in one format as rows arrive
+type userExporter interface {
+	contentType() string
+	begin(w io.Writer)
+	write(row models.UserExportRow) error
+	end() error
+}
+
+// userExportColumns is the CSV header row
+var userExportColumns = []string{"id", "username", "email", "created_at", "last_login", "status"}
+
+type csvUserExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvUserExporter) contentType() string { return "text/csv; charset=utf-8" }
+
+func (e *csvUserExporter) begin(w io.Writer) {
+	e.w = csv.NewWriter(w)
+	e.w.Write(userExportColumns)
+}
+
+func (e *csvUserExporter) write(row models.UserExportRow) error {
+	lastLogin := ""
+	if row.LastLogin != nil {
+		lastLogin = row.LastLogin.UTC().Format(time.RFC3339)
+	}
+	// encoding/csv quotes fields with commas, quotes and newlines
+	return e.w.Write([]string{
+		row.ID,
+		csvText(row.Username),
+		csvText(row.Email),
+		row.CreatedAt.UTC().Format(time.RFC3339),
+		lastLogin,
+		row.Status,
+	})
+}
+
+func (e *csvUserExporter) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvText keeps user-supplied text from being read as a formula when the
+// export is opened in a spreadsheet, by prefixing a quote to cells that
+// start like one
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+type jsonUserExporter struct {
+	w     io.Writer
+	enc   *json.Encoder
+	wrote bool
+}
+
+func (e *jsonUserExporter) contentType() string { return "application/json" }
+
+func (e *jsonUserExporter) begin(w io.Writer) {
+	e.w, e.enc = w, json.NewEncoder(w)
+	io.WriteString(w, "[")
+}
+
+func (e *jsonUserExporter) write(row models.UserExportRow) error {
+	if e.wrote {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.wrote = true
+	return e.enc.Encode(row)
+}
+
+func (e *jsonUserExporter) end() error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+// GetConfigSchema handles GET /api/v1/admin/config/schema
+// @Summary      Configuration schema
+// @Description  Lists every recognised configuration key with its type, default, and whether it is required or secret. Running values are never included, and defaults of secret keys are withheld. Requires the admin role.
+// @Tags         admin
+// @Security     Bearer
+// @Produce      json
+// @Param        If-None-Match header string false "ETag from a previous response"
+// @Success      200  {array}   config.SchemaField
+// @Success      304  "Schema unchanged"
+// @Failure      403  {object}  map[string]string "Admin role required"
+// @Router       /api/v1/admin/config/schema [get]
+func (h *Handlers) GetConfigSchema(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	if !h.requireAdmin(w, r, userID) {
+		return
+	}
+
+	// The schema only changes between builds and environments. It stays
+	// private: shared caches must not serve it past the admin check.
+	if middleware.NotModified(w, r, "private, max-age=3600", h.app.Build.ETag("config-schema", h.app.Config.App_Env)) {
+		return
+	}
+
+	writeSuccess(w, r, h.app, config.Schema(h.app.Config.App_Env), "Configuration schema retrieved")
+}
+
+// requireAdmin writes a 403 and returns false unless userID has the admin role
+func (h *Handlers) requireAdmin(w http.ResponseWriter, r *http.Request, userID string) bool {
+	user, err := middleware.LoadUser(r.Context(), userID, h.service.GetProfile)
+	if err != nil || user.Role != models.RoleAdmin {
+		writeError(w, r, h.app, http.StatusForbidden, "Admin role required")
+		return false
+	}
+	return true
+}
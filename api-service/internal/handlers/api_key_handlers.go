@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/middleware"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/validation"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateAPIKey handles POST /api/v1/api-keys
+// @Summary      Create an API key
+// @Description  Issues a new API key for the caller. The plaintext key is returned only in this response.
+// @Tags         api-keys
+// @Security     Bearer
+// @Accept       json
+// @Produce      json
+// @Param        request body models.CreateAPIKeyRequest true "Key name and scopes"
+// @Success      201  {object}  models.CreateAPIKeyResponse
+// @Failure      400  {object}  map[string]string "Invalid request"
+// @Failure      403  {object}  map[string]string "Called with an API key"
+// @Router       /api/v1/api-keys [post]
+func (h *Handlers) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	// A key that could mint keys could grant itself scopes it lacks
+	if _, ok := r.Context().Value(config.APIKeyScopesKey).([]string); ok {
+		writeError(w, r, h.app, http.StatusForbidden, "API keys can only be created from a signed-in session")
+		return
+	}
+
+	var req models.CreateAPIKeyRequest
+	if !decodeJSON(w, r, h.app, &req) {
+		return
+	}
+
+	if err := validation.ValidateStruct(&req); err != nil {
+		writeError(w, r, h.app, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp, err := h.apiKeys.Create(r.Context(), userID, req)
+	if err != nil {
+		h.app.Logger.Error().Err(err).Msg("Failed to create API key")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	h.recordAudit(r, userID, models.AuditActionAPIKeyCreate, resp.ID, map[string]interface{}{
+		"name":   resp.Name,
+		"scopes": resp.Scopes,
+	})
+
+	middleware.NoReplay(r)
+	writeResponse(w, r, h.app, http.StatusCreated, true, resp, "API key created; store it now, it will not be shown again")
+}
+
+// ListAPIKeys handles GET /api/v1/api-keys
+// @Summary      List API keys
+// @Description  Lists the caller's active API keys. Secrets are never returned, only a masked prefix.
+// @Tags         api-keys
+// @Security     Bearer
+// @Produce      json
+// @Success      200  {object}  []models.APIKeySummary
+// @Router       /api/v1/api-keys [get]
+func (h *Handlers) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	keys, err := h.apiKeys.List(r.Context(), userID)
+	if err != nil {
+		h.app.Logger.Error().Err(err).Msg("Failed to list API keys")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Failed to list API keys")
+		return
+	}
+
+	writeSuccess(w, r, h.app, map[string]interface{}{
+		"api_keys": keys,
+	}, "API keys retrieved successfully")
+}
+
+// RevokeAPIKey handles DELETE /api/v1/api-keys/{id}
+// @Summary      Revoke an API key
+// @Description  Revokes one of the caller's API keys. It stops authenticating immediately.
+// @Tags         api-keys
+// @Security     Bearer
+// @Param        id   path      string  true  "API key ID"
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]string "API key not found"
+// @Router       /api/v1/api-keys/{id} [delete]
+func (h *Handlers) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.UserIDKey).(string)
+	keyID := mux.Vars(r)["id"]
+
+	if err := h.apiKeys.Revoke(r.Context(), userID, keyID); err != nil {
+		if errors.Is(err, core.ErrAPIKeyNotFound) {
+			writeError(w, r, h.app, http.StatusNotFound, "API key not found")
+			return
+		}
+		h.app.Logger.Error().Err(err).Msg("Failed to revoke API key")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+
+	h.recordAudit(r, userID, models.AuditActionAPIKeyRevoke, keyID, nil)
+
+	writeSuccess(w, r, h.app, map[string]string{"id": keyID}, "API key revoked")
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/middleware"
+	"azlo-goboiler/internal/mocks"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/service"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// memAPIKeyRepo is an in-memory core.APIKeyRepository
+type memAPIKeyRepo struct {
+	mu   sync.Mutex
+	keys map[string]*models.APIKey
+}
+
+func newMemAPIKeyRepo() *memAPIKeyRepo {
+	return &memAPIKeyRepo{keys: map[string]*models.APIKey{}}
+}
+
+func (m *memAPIKeyRepo) Create(ctx context.Context, key *models.APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := *key
+	m.keys[k.ID] = &k
+	return nil
+}
+
+func (m *memAPIKeyRepo) ListByUser(ctx context.Context, userID string) ([]models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []models.APIKey
+	for _, k := range m.keys {
+		if k.UserID == userID && k.RevokedAt == nil {
+			out = append(out, *k)
+		}
+	}
+	return out, nil
+}
+
+func (m *memAPIKeyRepo) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.keys {
+		if k.Prefix == prefix && k.RevokedAt == nil {
+			c := *k
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memAPIKeyRepo) Revoke(ctx context.Context, id, userID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.keys[id]
+	if !ok || k.UserID != userID || k.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	k.RevokedAt = &now
+	return true, nil
+}
+
+func (m *memAPIKeyRepo) TouchLastUsed(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if k, ok := m.keys[id]; ok {
+		now := time.Now()
+		k.LastUsedAt = &now
+	}
+	return nil
+}
+
+func (m *memAPIKeyRepo) lastUsed(id string) *time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.keys[id].LastUsedAt
+}
+
+type apiKeyFixture struct {
+	h    *Handlers
+	mw   *middleware.Middleware
+	repo *memAPIKeyRepo
+}
+
+func newAPIKeyFixture() *apiKeyFixture {
+	app := newTestApp()
+	audit := new(mocks.MockAuditService)
+	audit.On("Record", mock.Anything, mock.Anything).Return(nil)
+
+	repo := newMemAPIKeyRepo()
+	svc := service.NewAPIKeyService(repo, nil)
+	return &apiKeyFixture{
+		h:    New(app, nil, audit, svc, nil, nil, nil, nil, nil, nil, nil),
+		mw:   middleware.New(app, nil, svc, nil, nil),
+		repo: repo,
+	}
+}
+
+func (f *apiKeyFixture) create(t *testing.T, userID string, scopes ...string) models.CreateAPIKeyResponse {
+	t.Helper()
+	if len(scopes) == 0 {
+		scopes = []string{models.APIKeyScopeRead}
+	}
+	req, err := json.Marshal(models.CreateAPIKeyRequest{Name: "ci", Scopes: scopes})
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	f.h.CreateAPIKey(rec, authedRequest(http.MethodPost, "/api/v1/api-keys", string(req), userID))
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var body struct {
+		Data models.CreateAPIKeyResponse `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.NotEmpty(t, body.Data.Key)
+	return body.Data
+}
+
+func (f *apiKeyFixture) revoke(userID, keyID string) int {
+	req := authedRequest(http.MethodDelete, "/api/v1/api-keys/"+keyID, "", userID)
+	req = mux.SetURLVars(req, map[string]string{"id": keyID})
+	rec := httptest.NewRecorder()
+	f.h.RevokeAPIKey(rec, req)
+	return rec.Code
+}
+
+// authenticate runs a request carrying the key through the APIKey middleware
+func (f *apiKeyFixture) authenticate(key string) (int, string) {
+	var seenUser string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenUser, _ = r.Context().Value(config.UserIDKey).(string)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil)
+	req.Header.Set("Authorization", "ApiKey "+key)
+	rec := httptest.NewRecorder()
+	f.mw.APIKey(next).ServeHTTP(rec, req)
+	return rec.Code, seenUser
+}
+
+func TestListAPIKeysMasksSecret(t *testing.T) {
+	f := newAPIKeyFixture()
+	created := f.create(t, "user-a")
+
+	rec := httptest.NewRecorder()
+	f.h.ListAPIKeys(rec, authedRequest(http.MethodGet, "/api/v1/api-keys", "", "user-a"))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// azlo_<id>_<secret>; the base64url secret may itself contain "_"
+	secret := strings.SplitN(created.Key, "_", 3)[2]
+	assert.NotContains(t, rec.Body.String(), created.Key)
+	assert.NotContains(t, rec.Body.String(), secret)
+	for _, k := range f.repo.keys {
+		assert.NotContains(t, rec.Body.String(), k.KeyHash)
+	}
+
+	var body struct {
+		Data struct {
+			APIKeys []map[string]interface{} `json:"api_keys"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Len(t, body.Data.APIKeys, 1)
+	assert.Equal(t, "ci", body.Data.APIKeys[0]["name"])
+	assert.True(t, strings.HasSuffix(body.Data.APIKeys[0]["masked_key"].(string), "_****"))
+	assert.NotContains(t, body.Data.APIKeys[0], "key")
+}
+
+func TestRevokeAPIKeyTakesEffect(t *testing.T) {
+	f := newAPIKeyFixture()
+	created := f.create(t, "user-a")
+
+	status, user := f.authenticate(created.Key)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "user-a", user)
+
+	assert.Equal(t, http.StatusOK, f.revoke("user-a", created.ID))
+
+	status, _ = f.authenticate(created.Key)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// Revoking twice reports the key as gone
+	assert.Equal(t, http.StatusNotFound, f.revoke("user-a", created.ID))
+}
+
+func TestAPIKeysCrossUserForbidden(t *testing.T) {
+	f := newAPIKeyFixture()
+	created := f.create(t, "user-a")
+
+	assert.Equal(t, http.StatusNotFound, f.revoke("user-b", created.ID))
+
+	rec := httptest.NewRecorder()
+	f.h.ListAPIKeys(rec, authedRequest(http.MethodGet, "/api/v1/api-keys", "", "user-b"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), created.ID)
+
+	// The owner's key keeps working
+	status, user := f.authenticate(created.Key)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "user-a", user)
+}
+
+func TestAPIKeyMiddlewareRejectsMalformed(t *testing.T) {
+	f := newAPIKeyFixture()
+	for _, key := range []string{"", "nonsense", "azlo_deadbeef_wrongsecret"} {
+		status, _ := f.authenticate(key)
+		assert.Equal(t, http.StatusUnauthorized, status, key)
+	}
+}
+
+func TestAuthenticateAcceptsAPIKey(t *testing.T) {
+	f := newAPIKeyFixture()
+	readKey := f.create(t, "user-a")
+	writeKey := f.create(t, "user-a", models.APIKeyScopeRead, models.APIKeyScopeWrite)
+
+	call := func(method, key string) (int, string) {
+		var seenUser string
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seenUser, _ = r.Context().Value(config.UserIDKey).(string)
+		})
+		req := httptest.NewRequest(method, "/api/v1/profile", nil)
+		req.Header.Set("Authorization", "ApiKey "+key)
+		rec := httptest.NewRecorder()
+		f.mw.Authenticate(next).ServeHTTP(rec, req)
+		return rec.Code, seenUser
+	}
+
+	status, user := call(http.MethodGet, readKey.Key)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "user-a", user)
+
+	status, _ = call(http.MethodPut, readKey.Key)
+	assert.Equal(t, http.StatusForbidden, status, "a read key cannot write")
+
+	status, user = call(http.MethodPut, writeKey.Key)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "user-a", user)
+
+	status, _ = call(http.MethodGet, "azlo_deadbeef_wrongsecret")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	// last_used_at is recorded in the background
+	assert.Eventually(t, func() bool { return f.repo.lastUsed(readKey.ID) != nil }, time.Second, 10*time.Millisecond)
+}
+
+func TestCreateAPIKeyRefusesAPIKeyCaller(t *testing.T) {
+	f := newAPIKeyFixture()
+	req := authedRequest(http.MethodPost, "/api/v1/api-keys", `{"name":"escalate","scopes":["write"]}`, "user-a")
+	req = req.WithContext(context.WithValue(req.Context(), config.APIKeyScopesKey, []string{models.APIKeyScopeRead}))
+
+	rec := httptest.NewRecorder()
+	f.h.CreateAPIKey(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Empty(t, f.repo.keys)
+}
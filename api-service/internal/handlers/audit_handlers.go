@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/middleware"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/pagination"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// GetLoginHistory handles GET /api/v1/profile/login-history
+// @Summary      Get login history
+// @Description  Cursor-paginated list of the current user's sign-ins, newest first
+// @Tags         profile
+// @Security     Bearer
+// @Param        before query     string  false  "Opaque cursor from a previous page's next_cursor"
+// @Param        limit  query     int     false  "Items per page"
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string "Invalid cursor"
+// @Router       /api/v1/profile/login-history [get]
+func (h *Handlers) GetLoginHistory(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.UserIDKey).(string)
+	before := r.URL.Query().Get("before")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	logins, meta, err := h.audit.ListLoginHistory(r.Context(), userID, before, limit)
+	if err != nil {
+		if errors.Is(err, pagination.ErrInvalidCursor) {
+			writeError(w, r, h.app, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		h.app.Logger.Error().Err(err).Msg("Failed to fetch login history")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Failed to fetch login history")
+		return
+	}
+
+	writeSuccess(w, r, h.app, map[string]interface{}{
+		"logins":     logins,
+		"pagination": meta,
+	}, "Login history retrieved successfully")
+}
+
+// GetAuditLog handles GET /api/v1/admin/audit-log
+// @Summary      Browse the audit log
+// @Description  Cursor-paginated list of audit events, newest first. Requires the admin role.
+// @Tags         admin
+// @Security     Bearer
+// @Param        before query     string  false  "Opaque cursor from a previous page's next_cursor"
+// @Param        limit  query     int     false  "Items per page"
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string "Invalid cursor"
+// @Failure      403  {object}  map[string]string "Admin role required"
+// @Router       /api/v1/admin/audit-log [get]
+func (h *Handlers) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	before := r.URL.Query().Get("before")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	events, meta, err := h.audit.ListEvents(r.Context(), before, limit)
+	if err != nil {
+		if errors.Is(err, pagination.ErrInvalidCursor) {
+			writeError(w, r, h.app, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		h.app.Logger.Error().Err(err).Msg("Failed to fetch audit log")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Failed to fetch audit log")
+		return
+	}
+
+	writeSuccess(w, r, h.app, map[string]interface{}{
+		"events":     events,
+		"pagination": meta,
+	}, "Audit log retrieved successfully")
+}
+
+// recordAudit writes an audit event on a best-effort basis; failures are
+// logged but never fail the request that triggered them.
+func (h *Handlers) recordAudit(r *http.Request, actorID, action, targetID string, metadata map[string]interface{}) {
+	event := models.AuditEvent{
+		ActorID:   actorID,
+		Action:    action,
+		TargetID:  targetID,
+		IPAddress: middleware.ClientIP(r),
+		Metadata:  metadata,
+	}
+	if err := h.audit.Record(r.Context(), event); err != nil {
+		h.app.Logger.Error().
+			Str("request_id", getRequestID(r.Context())).
+			Str("action", action).
+			Err(err).
+			Msg("Failed to record audit event")
+	}
+}
@@ -1,11 +1,18 @@
 package handlers
 
 import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/jwtkeys"
+	"azlo-goboiler/internal/middleware"
 	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/notification"
 	"azlo-goboiler/internal/validation"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
-	"time"
+	"strings"
 )
 
 // Register godoc
@@ -18,6 +25,7 @@ import (
 // @Success      200  {object}  models.RegisterResponse
 // @Failure      400  {object}  map[string]string "Invalid request"
 // @Failure      409  {object}  map[string]string "User already exists"
+// @Failure      429  {object}  map[string]string "Password hashing queue full"
 // @Failure      500  {object}  map[string]string "Internal server error"
 // @Router       /auth/register [post]
 func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
@@ -29,7 +37,7 @@ func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
 			Str("request_id", requestID).
 			Err(err).
 			Msg("Invalid JSON in registration request")
-		writeError(w, h.app, http.StatusBadRequest, "Invalid request format")
+		writeDecodeError(w, r, h.app, err)
 		return
 	}
 
@@ -39,17 +47,19 @@ func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
 			Str("request_id", requestID).
 			Err(err).
 			Msg("Registration validation failed")
-		writeError(w, h.app, http.StatusBadRequest, err.Error())
+		writeError(w, r, h.app, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// Call Service Layer
 	resp, err := h.service.Register(r.Context(), req)
 	if err != nil {
-		// Check for specific error messages to return correct status codes
-		// In a more advanced setup, you would use custom error types here
-		if err.Error() == "user with this email or username already exists" {
-			writeError(w, h.app, http.StatusConflict, err.Error())
+		if errors.Is(err, core.ErrUserExists) {
+			writeError(w, r, h.app, http.StatusConflict, err.Error())
+			return
+		}
+		if errors.Is(err, core.ErrHasherBusy) {
+			writeBusy(w, r, h.app)
 			return
 		}
 
@@ -57,20 +67,39 @@ func (h *Handlers) Register(w http.ResponseWriter, r *http.Request) {
 			Str("request_id", requestID).
 			Err(err).
 			Msg("Registration failed")
-		writeError(w, h.app, http.StatusInternalServerError, "Registration failed")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Registration failed")
 		return
 	}
 
+	h.recordAudit(r, resp.UserID, models.AuditActionRegister, resp.UserID, nil)
+	h.sendEmailVerification(r, resp.UserID, resp.Username, resp.Email)
+	h.notify(r, resp.UserID, notification.EventRegistered)
+
 	h.app.Logger.Info().
 		Str("request_id", requestID).
 		Str("user_id", resp.UserID).
 		Str("username", resp.Username).
 		Msg("User registered successfully")
 
-	writeSuccess(w, h.app, resp, "User registered successfully")
+	writeSuccess(w, r, h.app, resp, "User registered successfully")
 }
 
-// Auth handles user authentication via the Service layer
+// Auth handles user authentication via the Service layer. By default the
+// token is set as an HttpOnly cookie; clients that opt into header mode get
+// it in the body and send it back as "Authorization: Bearer <token>".
+// @Summary      Log in
+// @Description  Authenticates with username or email and password
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request body models.LoginRequest true "Credentials"
+// @Param        X-Auth-Mode header string false "Set to token to receive the token in the body"
+// @Success      200  {object}  models.LoginResponse
+// @Failure      400  {object}  map[string]string "Invalid request"
+// @Failure      401  {object}  map[string]string "Invalid credentials"
+// @Failure      429  {object}  map[string]string "Password hashing queue full"
+// @Failure      503  {object}  map[string]string "Session store unavailable"
+// @Router       /auth/login [post]
 func (h *Handlers) Auth(w http.ResponseWriter, r *http.Request) {
 	requestID := getRequestID(r.Context())
 
@@ -80,7 +109,7 @@ func (h *Handlers) Auth(w http.ResponseWriter, r *http.Request) {
 			Str("request_id", requestID).
 			Err(err).
 			Msg("Invalid JSON in login request")
-		writeError(w, h.app, http.StatusBadRequest, "Invalid request format")
+		writeDecodeError(w, r, h.app, err)
 		return
 	}
 
@@ -90,19 +119,34 @@ func (h *Handlers) Auth(w http.ResponseWriter, r *http.Request) {
 			Str("request_id", requestID).
 			Err(err).
 			Msg("Login validation failed")
-		writeError(w, h.app, http.StatusBadRequest, err.Error())
+		writeError(w, r, h.app, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// Call Service Layer
 	resp, err := h.service.Login(r.Context(), req)
+	if errors.Is(err, core.ErrHasherBusy) {
+		h.app.Logger.Warn().
+			Str("request_id", requestID).
+			Msg("Login shed, password hasher busy")
+		writeBusy(w, r, h.app)
+		return
+	}
+	if errors.Is(err, core.ErrSessionUnavailable) {
+		h.app.Logger.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Login failed, session store unavailable")
+		writeError(w, r, h.app, http.StatusServiceUnavailable, "Login is temporarily unavailable")
+		return
+	}
 	if err != nil {
 		h.app.Logger.Warn().
 			Str("request_id", requestID).
 			Str("username", req.Username).
 			Err(err).
 			Msg("Login failed")
-		writeError(w, h.app, http.StatusUnauthorized, "Invalid credentials")
+		writeError(w, r, h.app, http.StatusUnauthorized, "Invalid credentials")
 		return
 	}
 
@@ -112,36 +156,180 @@ func (h *Handlers) Auth(w http.ResponseWriter, r *http.Request) {
 		Str("username", resp.User.Username).
 		Msg("User authenticated successfully")
 
+	if err := h.audit.RecordLogin(r.Context(), resp.User.ID, middleware.ClientIP(r), r.UserAgent()); err != nil {
+		h.app.Logger.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to record login history")
+	}
+
+	// Header mode: the client keeps the token and sends it as a Bearer
+	// header, for SPAs on another site where cookies aren't sent. No cookie
+	// is set so the two modes never mix.
+	if req.TokenInBody || strings.EqualFold(r.Header.Get(authModeHeader), authModeToken) {
+		writeSuccess(w, r, h.app, map[string]interface{}{
+			"token":         resp.Token,
+			"refresh_token": resp.RefreshToken,
+			"token_type":    "Bearer",
+			"expires_at":    resp.ExpiresAt,
+			"user":          resp.User,
+			"session_mode":  resp.SessionMode,
+		}, "Authentication successful")
+		return
+	}
+
 	// Set the secure, HttpOnly cookie using the token from the service
-	http.SetCookie(w, &http.Cookie{
-		Name:     "jwt_token",
-		Value:    resp.Token,
-		Expires:  time.Unix(resp.ExpiresAt, 0),
-		HttpOnly: true,                 // Prevents JS access
-		Secure:   true,                 // Only send over HTTPS
-		Path:     "/",                  // Available to entire site
-		SameSite: http.SameSiteLaxMode, // Good security default
-	})
+	h.setAuthCookie(w, resp)
 
 	// Return success response without the token (it's in the cookie)
-	writeSuccess(w, h.app, map[string]interface{}{
-		"expires_at": resp.ExpiresAt,
-		"user":       resp.User,
+	writeSuccess(w, r, h.app, map[string]interface{}{
+		"expires_at":   resp.ExpiresAt,
+		"user":         resp.User,
+		"session_mode": resp.SessionMode,
 	}, "Authentication successful")
 }
 
-// Logout handles user logout by clearing the auth cookie
+// setAuthCookie stores the session token in the auth cookie, and the refresh
+// token in the refresh cookie
+func (h *Handlers) setAuthCookie(w http.ResponseWriter, resp *models.LoginResponse) {
+	middleware.SetSessionCookies(w, &h.app.Config, resp)
+}
+
+// Clients send "X-Auth-Mode: token" (or "token_in_body": true) on login to
+// receive the token in the body instead of a cookie
+const (
+	authModeHeader = "X-Auth-Mode"
+	authModeToken  = "token"
+)
+
+// Refresh exchanges a refresh token for a new access token
+// @Summary      Refresh a session
+// @Description  Exchanges the refresh cookie, or a refresh_token in the body for header-mode clients, for a new access token. The refresh token is rotated: the presented one stops working and a new one is returned the same way it came in.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        body  body      models.RefreshRequest  false  "Refresh token, when not sent as a cookie"
+// @Success      200   {object}  map[string]interface{}
+// @Failure      401   {object}  map[string]string "Invalid or expired refresh token"
+// @Failure      503   {object}  map[string]string "Session store unavailable"
+// @Router       /auth/refresh [post]
+func (h *Handlers) Refresh(w http.ResponseWriter, r *http.Request) {
+	requestID := getRequestID(r.Context())
+
+	fromCookie := false
+	var req models.RefreshRequest
+	if cookie, err := r.Cookie(config.RefreshCookieName); err == nil && cookie.Value != "" {
+		req.RefreshToken = cookie.Value
+		fromCookie = true
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeDecodeError(w, r, h.app, err)
+		return
+	}
+	if req.RefreshToken == "" {
+		writeError(w, r, h.app, http.StatusUnauthorized, "Refresh token required")
+		return
+	}
+
+	resp, err := h.service.Refresh(r.Context(), req.RefreshToken)
+	if errors.Is(err, core.ErrSessionUnavailable) {
+		h.app.Logger.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Refresh failed, session store unavailable")
+		writeError(w, r, h.app, http.StatusServiceUnavailable, "Refresh is temporarily unavailable")
+		return
+	}
+	if err != nil {
+		h.app.Logger.Warn().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Refresh token rejected")
+		// A token that isn't stored may have lost a race with another tab's
+		// refresh, whose new cookies this browser now holds; clearing here
+		// would sign that tab out too
+		if fromCookie && errors.Is(err, core.ErrSessionRevoked) {
+			middleware.ClearSessionCookies(w, &h.app.Config)
+		}
+		writeError(w, r, h.app, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	h.app.Logger.Info().
+		Str("request_id", requestID).
+		Str("user_id", resp.User.ID).
+		Msg("Session refreshed")
+
+	if !fromCookie {
+		writeSuccess(w, r, h.app, map[string]interface{}{
+			"token":         resp.Token,
+			"refresh_token": resp.RefreshToken,
+			"token_type":    "Bearer",
+			"expires_at":    resp.ExpiresAt,
+		}, "Session refreshed")
+		return
+	}
+
+	h.setAuthCookie(w, resp)
+	writeSuccess(w, r, h.app, map[string]interface{}{
+		"expires_at":   resp.ExpiresAt,
+		"user":         resp.User,
+		"session_mode": resp.SessionMode,
+	}, "Session refreshed")
+}
+
+// Logout handles user logout by revoking the session and clearing the cookies.
+// It is best-effort: a request without a usable token still logs out.
+// @Summary      Log out
+// @Description  Revokes the access token (from the auth cookie or Bearer header) and the refresh token, and clears both cookies. A missing, expired or invalid token is not an error; the cookies are cleared and the call succeeds.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  map[string]string
+// @Failure      503  {object}  map[string]string "Session store unavailable; the token was not revoked"
+// @Router       /auth/logout [post]
 func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
-	// Set the cookie to expire in the past
-	http.SetCookie(w, &http.Cookie{
-		Name:     "jwt_token",
-		Value:    "",
-		Expires:  time.Now().Add(-time.Hour), // Expire in the past
-		HttpOnly: true,
-		Secure:   true,
-		Path:     "/",
-		SameSite: http.SameSiteLaxMode,
-	})
-
-	writeSuccess(w, h.app, nil, "Logout successful")
+	requestID := getRequestID(r.Context())
+
+	// Clear the cookies even if revocation fails below
+	middleware.ClearSessionCookies(w, &h.app.Config)
+
+	if err := h.service.Logout(r.Context(), middleware.SessionToken(r)); err != nil {
+		h.app.Logger.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to revoke access token on logout")
+		writeError(w, r, h.app, http.StatusServiceUnavailable, "Logout could not revoke the session; try again")
+		return
+	}
+
+	if cookie, err := r.Cookie(config.RefreshCookieName); err == nil && cookie.Value != "" {
+		if err := h.service.RevokeRefreshToken(r.Context(), cookie.Value); err != nil {
+			// The cookie is cleared anyway; the token lapses on its own
+			h.app.Logger.Warn().
+				Str("request_id", requestID).
+				Err(err).
+				Msg("Failed to revoke refresh token on logout")
+		}
+	}
+
+	writeSuccess(w, r, h.app, nil, "Logout successful")
+}
+
+// JWKS godoc
+// @Summary      Token signing keys
+// @Description  Publishes the public keys access tokens are signed with, as a JSON Web Key Set, so other services can verify tokens. Only available when JWT_PRIVATE_KEY_PATH is set; HS256 tokens have no public key.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  jwtkeys.JWKS
+// @Failure      404  {object}  map[string]string "Tokens are not signed with a public key"
+// @Router       /.well-known/jwks.json [get]
+func (h *Handlers) JWKS(w http.ResponseWriter, r *http.Request) {
+	keys, ok := jwtkeys.PublicKeys()
+	if !ok {
+		writeError(w, r, h.app, http.StatusNotFound, "Tokens are not signed with a public key")
+		return
+	}
+	// Verifiers refetch when they meet an unknown key ID, so a rotated key
+	// is picked up without waiting for this to expire
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, h.app, http.StatusOK, keys)
 }
@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/hasher"
+	"azlo-goboiler/internal/mocks"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/repository"
+	"azlo-goboiler/internal/service"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthModes(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("Password123!"), bcrypt.MinCost)
+	require.NoError(t, err)
+	user := &models.User{ID: "user-1", Username: "alice", Email: "alice@example.com", PasswordHash: string(hash)}
+
+	login := func(body string, header string, sameSite string) *httptest.ResponseRecorder {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByEmailOrUsername", mock.Anything, "alice", "alice").Return(user, nil)
+		repo.On("UpdateLastLogin", mock.Anything, "user-1").Return(nil)
+		audit := new(mocks.MockAuditService)
+		audit.On("RecordLogin", mock.Anything, "user-1", mock.Anything, mock.Anything).Return(nil)
+
+		app := newTestApp()
+		app.Config = config.Config{App_Secret: "test-secret-that-is-at-least-32-chars", JWTExpirationHours: 1, CookieSameSite: sameSite}
+		sessions := new(mocks.MockSessionStore)
+		sessions.On("SaveRefreshToken", mock.Anything, "user-1", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		svc := service.NewUserService(repo, sessions, &app.Config, nil, nil)
+		h := New(app, svc, audit, nil, nil, nil, nil, nil, nil, nil, nil)
+
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(body))
+		if header != "" {
+			req.Header.Set("X-Auth-Mode", header)
+		}
+		rec := httptest.NewRecorder()
+		h.Auth(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec
+	}
+
+	dataOf := func(rec *httptest.ResponseRecorder) map[string]interface{} {
+		var body struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body.Data
+	}
+
+	t.Run("CookieModeIsDefault", func(t *testing.T) {
+		rec := login(`{"username":"alice","password":"Password123!"}`, "", "")
+
+		cookies := rec.Result().Cookies()
+		require.Len(t, cookies, 2)
+		assert.Equal(t, "jwt_token", cookies[0].Name)
+		assert.Equal(t, "refresh_token", cookies[1].Name)
+		for _, c := range cookies {
+			assert.True(t, c.HttpOnly)
+			assert.Equal(t, http.SameSiteLaxMode, c.SameSite)
+		}
+		assert.NotContains(t, dataOf(rec), "token")
+		assert.NotContains(t, dataOf(rec), "refresh_token")
+	})
+
+	t.Run("CookieSameSiteNone", func(t *testing.T) {
+		rec := login(`{"username":"alice","password":"Password123!"}`, "", "none")
+
+		cookies := rec.Result().Cookies()
+		require.Len(t, cookies, 2)
+		assert.Equal(t, http.SameSiteNoneMode, cookies[0].SameSite)
+		assert.True(t, cookies[0].Secure)
+	})
+
+	t.Run("TokenModeViaBodyFlag", func(t *testing.T) {
+		rec := login(`{"username":"alice","password":"Password123!","token_in_body":true}`, "", "")
+
+		assert.Empty(t, rec.Result().Cookies())
+		data := dataOf(rec)
+		assert.NotEmpty(t, data["token"])
+		assert.NotEmpty(t, data["refresh_token"])
+		assert.Equal(t, "Bearer", data["token_type"])
+	})
+
+	t.Run("TokenModeViaHeader", func(t *testing.T) {
+		rec := login(`{"username":"alice","password":"Password123!"}`, "token", "")
+
+		assert.Empty(t, rec.Result().Cookies())
+		assert.NotEmpty(t, dataOf(rec)["token"])
+	})
+}
+
+func TestRefresh(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("Password123!"), bcrypt.MinCost)
+	require.NoError(t, err)
+	user := &models.User{ID: "user-1", Username: "alice", Email: "alice@example.com", PasswordHash: string(hash), IsActive: true}
+
+	newHandlers := func(t *testing.T) *Handlers {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { client.Close() })
+
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByEmailOrUsername", mock.Anything, "alice", "alice").Return(user, nil)
+		repo.On("GetByID", mock.Anything, "user-1").Return(user, nil)
+		repo.On("UpdateLastLogin", mock.Anything, "user-1").Return(nil)
+		audit := new(mocks.MockAuditService)
+		audit.On("RecordLogin", mock.Anything, "user-1", mock.Anything, mock.Anything).Return(nil)
+
+		app := newTestApp()
+		app.Config = config.Config{App_Secret: "test-secret-that-is-at-least-32-chars", AccessTokenMinutes: 15}
+		svc := service.NewUserService(repo, repository.NewSessionStore(client), &app.Config, nil, nil)
+		return New(app, svc, audit, nil, nil, nil, nil, nil, nil, nil, nil)
+	}
+
+	serve := func(handler http.HandlerFunc, body string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/refresh", strings.NewReader(body))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	dataOf := func(t *testing.T, rec *httptest.ResponseRecorder) map[string]interface{} {
+		var body struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body.Data
+	}
+	cookieNamed := func(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == name {
+				return c
+			}
+		}
+		return nil
+	}
+
+	t.Run("HeaderModeRotates", func(t *testing.T) {
+		h := newHandlers(t)
+		login := serve(h.Auth, `{"username":"alice","password":"Password123!","token_in_body":true}`)
+		require.Equal(t, http.StatusOK, login.Code)
+		first := dataOf(t, login)["refresh_token"].(string)
+
+		rec := serve(h.Refresh, `{"refresh_token":"`+first+`"}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+		data := dataOf(t, rec)
+		assert.NotEmpty(t, data["token"])
+		assert.Equal(t, "Bearer", data["token_type"])
+		second, _ := data["refresh_token"].(string)
+		assert.NotEmpty(t, second)
+		assert.NotEqual(t, first, second)
+		assert.Empty(t, rec.Result().Cookies())
+
+		// The presented token was spent; its replacement works once
+		assert.Equal(t, http.StatusUnauthorized, serve(h.Refresh, `{"refresh_token":"`+first+`"}`).Code)
+		assert.Equal(t, http.StatusOK, serve(h.Refresh, `{"refresh_token":"`+second+`"}`).Code)
+	})
+
+	t.Run("CookieModeSetsCookies", func(t *testing.T) {
+		h := newHandlers(t)
+		login := serve(h.Auth, `{"username":"alice","password":"Password123!"}`)
+		require.Equal(t, http.StatusOK, login.Code)
+
+		rec := serve(h.Refresh, "", cookieNamed(login, config.RefreshCookieName))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.NotNil(t, cookieNamed(rec, config.AuthCookieName))
+		require.NotNil(t, cookieNamed(rec, config.RefreshCookieName))
+		assert.NotContains(t, dataOf(t, rec), "refresh_token")
+	})
+
+	t.Run("SpentTokenKeepsCookies", func(t *testing.T) {
+		// The loser of two tabs refreshing at once must not clear the
+		// cookies the winner has just set
+		h := newHandlers(t)
+		login := serve(h.Auth, `{"username":"alice","password":"Password123!"}`)
+		require.Equal(t, http.StatusOK, login.Code)
+		refresh := cookieNamed(login, config.RefreshCookieName)
+
+		require.Equal(t, http.StatusOK, serve(h.Refresh, "", refresh).Code)
+		rec := serve(h.Refresh, "", refresh)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Empty(t, rec.Result().Cookies())
+
+		rec = serve(h.Refresh, "", &http.Cookie{Name: config.RefreshCookieName, Value: "user-1.forged"})
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Empty(t, rec.Result().Cookies())
+
+		assert.Equal(t, http.StatusUnauthorized, serve(h.Refresh, "").Code)
+	})
+
+	t.Run("RevokedSessionClearsCookies", func(t *testing.T) {
+		h := newHandlers(t)
+		h.app.Config.SingleSession = true
+		first := serve(h.Auth, `{"username":"alice","password":"Password123!"}`)
+		require.Equal(t, http.StatusOK, first.Code)
+		require.Equal(t, http.StatusOK, serve(h.Auth, `{"username":"alice","password":"Password123!"}`).Code)
+
+		rec := serve(h.Refresh, "", cookieNamed(first, config.RefreshCookieName))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		cleared := cookieNamed(rec, config.RefreshCookieName)
+		require.NotNil(t, cleared)
+		assert.Empty(t, cleared.Value)
+	})
+
+	t.Run("LogoutRevokes", func(t *testing.T) {
+		h := newHandlers(t)
+		login := serve(h.Auth, `{"username":"alice","password":"Password123!"}`)
+		require.Equal(t, http.StatusOK, login.Code)
+		refresh := cookieNamed(login, config.RefreshCookieName)
+
+		require.Equal(t, http.StatusOK, serve(h.Logout, "", refresh).Code)
+		assert.Equal(t, http.StatusUnauthorized, serve(h.Refresh, "", refresh).Code)
+	})
+}
+
+func TestLogout(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("Password123!"), bcrypt.MinCost)
+	require.NoError(t, err)
+	user := &models.User{ID: "user-1", Username: "alice", Email: "alice@example.com", PasswordHash: string(hash), IsActive: true}
+	const secret = "test-secret-that-is-at-least-32-chars"
+
+	newHandlers := func(t *testing.T) (*Handlers, *miniredis.Miniredis) {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { client.Close() })
+
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByEmailOrUsername", mock.Anything, "alice", "alice").Return(user, nil)
+		repo.On("GetByID", mock.Anything, "user-1").Return(user, nil)
+		repo.On("UpdateLastLogin", mock.Anything, "user-1").Return(nil)
+		audit := new(mocks.MockAuditService)
+		audit.On("RecordLogin", mock.Anything, "user-1", mock.Anything, mock.Anything).Return(nil)
+
+		app := newTestApp()
+		app.Config = config.Config{App_Secret: secret, AccessTokenMinutes: 15}
+		svc := service.NewUserService(repo, repository.NewSessionStore(client), &app.Config, nil, nil)
+		return New(app, svc, audit, nil, nil, nil, nil, nil, nil, nil, nil), mr
+	}
+
+	logout := func(h *Handlers, bearer string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		h.Logout(rec, req)
+		return rec
+	}
+	assertCleared := func(t *testing.T, rec *httptest.ResponseRecorder) {
+		cleared := map[string]bool{}
+		for _, c := range rec.Result().Cookies() {
+			if c.Value == "" && c.Expires.Before(time.Now()) {
+				cleared[c.Name] = true
+			}
+		}
+		assert.True(t, cleared[config.AuthCookieName], "auth cookie not cleared")
+		assert.True(t, cleared[config.RefreshCookieName], "refresh cookie not cleared")
+	}
+
+	t.Run("ValidTokenRevokes", func(t *testing.T) {
+		h, mr := newHandlers(t)
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"username":"alice","password":"Password123!"}`))
+		login := httptest.NewRecorder()
+		h.Auth(login, req)
+		require.Equal(t, http.StatusOK, login.Code)
+
+		rec := logout(h, "", login.Result().Cookies()...)
+		require.Equal(t, http.StatusOK, rec.Code)
+		assertCleared(t, rec)
+
+		revoked := 0
+		for _, key := range mr.Keys() {
+			if strings.HasPrefix(key, "auth:revoked:") {
+				revoked++
+			}
+			assert.False(t, strings.HasPrefix(key, "auth:refresh:"), "refresh token still stored: %s", key)
+		}
+		assert.Equal(t, 1, revoked)
+	})
+
+	t.Run("WithoutTokenSucceeds", func(t *testing.T) {
+		h, mr := newHandlers(t)
+
+		rec := logout(h, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assertCleared(t, rec)
+		assert.Empty(t, mr.Keys())
+	})
+
+	t.Run("InvalidTokenSucceeds", func(t *testing.T) {
+		h, mr := newHandlers(t)
+		expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+			ID:        "expired-jti",
+			Subject:   "user-1",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+		}).SignedString([]byte(secret))
+		require.NoError(t, err)
+
+		for _, token := range []string{"not-a-jwt", expired} {
+			rec := logout(h, token, &http.Cookie{Name: config.RefreshCookieName, Value: "user-1.forged"})
+			require.Equal(t, http.StatusOK, rec.Code)
+			assertCleared(t, rec)
+		}
+		rec := logout(h, "", &http.Cookie{Name: config.AuthCookieName, Value: "garbage"})
+		require.Equal(t, http.StatusOK, rec.Code)
+		assertCleared(t, rec)
+		assert.Empty(t, mr.Keys())
+	})
+}
+
+// TestLoginFloodKeepsAPIResponsive floods login with full-cost bcrypt work
+// through a one-worker pool and checks a cheap endpoint stays fast while
+// the excess logins are shed with 429.
+func TestLoginFloodKeepsAPIResponsive(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load test")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("Password123!"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	user := &models.User{ID: "user-1", Username: "alice", Email: "alice@example.com", PasswordHash: string(hash)}
+
+	repo := new(mocks.MockUserRepository)
+	repo.On("GetByEmailOrUsername", mock.Anything, "alice", "alice").Return(user, nil)
+	repo.On("UpdateLastLogin", mock.Anything, "user-1").Return(nil)
+	audit := new(mocks.MockAuditService)
+	audit.On("RecordLogin", mock.Anything, "user-1", mock.Anything, mock.Anything).Return(nil)
+
+	app := newTestApp()
+	app.Config = config.Config{App_Secret: "test-secret-that-is-at-least-32-chars", JWTExpirationHours: 1}
+	sessions := new(mocks.MockSessionStore)
+	sessions.On("SaveRefreshToken", mock.Anything, "user-1", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	svc := service.NewUserService(repo, sessions, &app.Config, hasher.NewPool(1, 4, 500*time.Millisecond), nil)
+	h := New(app, svc, audit, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/login", h.Auth)
+	mux.HandleFunc("/ready", h.Ready)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	const logins = 40
+	var wg sync.WaitGroup
+	var ok, shed atomic.Int32
+	for i := 0; i < logins; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(srv.URL+"/auth/login", "application/json",
+				strings.NewReader(`{"username":"alice","password":"Password123!"}`))
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			switch resp.StatusCode {
+			case http.StatusOK:
+				ok.Add(1)
+			case http.StatusTooManyRequests:
+				shed.Add(1)
+				assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+			}
+		}()
+	}
+
+	var slowest time.Duration
+	for i := 0; i < 20; i++ {
+		start := time.Now()
+		resp, err := http.Get(srv.URL + "/ready")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		if d := time.Since(start); d > slowest {
+			slowest = d
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+
+	assert.Less(t, slowest, 250*time.Millisecond, "non-auth endpoint stalled during the login flood")
+	assert.Positive(t, ok.Load(), "some logins should get through")
+	assert.Positive(t, shed.Load(), "excess logins should be shed")
+	assert.Equal(t, int32(logins), ok.Load()+shed.Load())
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// maxCSPReportBody bounds a report request; a browser's reports are a
+	// few kilobytes at most
+	maxCSPReportBody = 64 << 10
+	// maxCSPReportField bounds each logged value, so a forged report cannot
+	// write arbitrarily long log lines
+	maxCSPReportField = 256
+	// otherDirective labels directives outside cspDirectives
+	otherDirective = "other"
+)
+
+var errEmptyCSPReport = errors.New("report names no directive")
+
+var cspViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "csp_violations_total",
+	Help: "Content Security Policy violations reported by browsers, by directive.",
+}, []string{"directive"})
+
+// Collectors returns the handler metrics, for the application's registry
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{cspViolations}
+}
+
+// cspDirectives bounds the metric's directive label; reports are
+// unauthenticated, so anything else is counted as other
+var cspDirectives = map[string]bool{
+	"default-src": true, "script-src": true, "script-src-elem": true, "script-src-attr": true,
+	"style-src": true, "style-src-elem": true, "style-src-attr": true, "img-src": true,
+	"font-src": true, "connect-src": true, "media-src": true, "object-src": true,
+	"frame-src": true, "child-src": true, "worker-src": true, "manifest-src": true,
+	"frame-ancestors": true, "form-action": true, "base-uri": true,
+	"require-trusted-types-for": true, "trusted-types": true,
+}
+
+// cspViolation is a violation in either report format, reduced to what is logged
+type cspViolation struct {
+	DocumentURI string
+	Directive   string
+	BlockedURI  string
+	SourceFile  string
+	Line        int
+	Column      int
+	Disposition string
+	Sample      string
+}
+
+// cspReportURI is the body browsers send to a report-uri endpoint
+type cspReportURI struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		BlockedURI         string `json:"blocked-uri"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+		Disposition        string `json:"disposition"`
+		ScriptSample       string `json:"script-sample"`
+	} `json:"csp-report"`
+}
+
+// reportingAPIReport is one entry of the list browsers send to a report-to endpoint
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		BlockedURL         string `json:"blockedURL"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		ColumnNumber       int    `json:"columnNumber"`
+		Disposition        string `json:"disposition"`
+		Sample             string `json:"sample"`
+	} `json:"body"`
+}
+
+// CSPReport godoc
+// @Summary      Report CSP violations
+// @Description  Receives the Content Security Policy violation reports browsers send for the report-uri and report-to directives the API adds to its policy. Both the application/csp-report and application/reports+json formats are accepted. Violations are logged and counted in csp_violations_total. Limited to 60 requests per minute per IP.
+// @Tags         security
+// @Accept       json
+// @Success      204
+// @Failure      400  {object}  map[string]string "Malformed report"
+// @Failure      429  {object}  map[string]string "Too many reports"
+// @Router       /csp-report [post]
+func (h *Handlers) CSPReport(w http.ResponseWriter, r *http.Request) {
+	requestID := getRequestID(r.Context())
+
+	violations, err := decodeCSPReport(r, http.MaxBytesReader(w, r.Body, maxCSPReportBody))
+	if err != nil {
+		writeError(w, r, h.app, http.StatusBadRequest, "Malformed report")
+		return
+	}
+
+	for _, v := range violations {
+		directive := v.Directive
+		if !cspDirectives[directive] {
+			directive = otherDirective
+		}
+		cspViolations.WithLabelValues(directive).Inc()
+
+		h.app.Logger.Warn().
+			Str("request_id", requestID).
+			Str("document_uri", clip(v.DocumentURI)).
+			Str("directive", clip(v.Directive)).
+			Str("blocked_uri", clip(v.BlockedURI)).
+			Str("source_file", clip(v.SourceFile)).
+			Int("line", v.Line).
+			Int("column", v.Column).
+			Str("disposition", clip(v.Disposition)).
+			Str("sample", clip(v.Sample)).
+			Msg("CSP violation")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeCSPReport reads either report format: a report-uri object, or a
+// Reporting API list in which only csp-violation entries are kept
+func decodeCSPReport(r *http.Request, body io.Reader) ([]cspViolation, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/reports+json" {
+		var reports []reportingAPIReport
+		if err := json.NewDecoder(body).Decode(&reports); err != nil {
+			return nil, err
+		}
+		var violations []cspViolation
+		for _, report := range reports {
+			if report.Type != "csp-violation" {
+				continue
+			}
+			b := report.Body
+			violations = append(violations, cspViolation{
+				DocumentURI: b.DocumentURL, Directive: b.EffectiveDirective, BlockedURI: b.BlockedURL,
+				SourceFile: b.SourceFile, Line: b.LineNumber, Column: b.ColumnNumber,
+				Disposition: b.Disposition, Sample: b.Sample,
+			})
+		}
+		return violations, nil
+	}
+
+	var report cspReportURI
+	if err := json.NewDecoder(body).Decode(&report); err != nil {
+		return nil, err
+	}
+	rep := report.Report
+	directive := rep.EffectiveDirective
+	if directive == "" {
+		// Older browsers only send the directive as written in the policy,
+		// sources included
+		directive, _, _ = strings.Cut(strings.TrimSpace(rep.ViolatedDirective), " ")
+	}
+	if directive == "" {
+		return nil, errEmptyCSPReport
+	}
+	return []cspViolation{{
+		DocumentURI: rep.DocumentURI, Directive: directive, BlockedURI: rep.BlockedURI,
+		SourceFile: rep.SourceFile, Line: rep.LineNumber, Column: rep.ColumnNumber,
+		Disposition: rep.Disposition, Sample: rep.ScriptSample,
+	}}, nil
+}
+
+// clip cuts s to maxCSPReportField bytes without splitting a character
+func clip(s string) string {
+	if len(s) <= maxCSPReportField {
+		return s
+	}
+	s = s[:maxCSPReportField]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s + "…"
+}
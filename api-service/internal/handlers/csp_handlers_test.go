@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/config"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSPReport(t *testing.T) {
+	var logs bytes.Buffer
+	h := New(&config.Application{Logger: zerolog.New(&logs)}, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	post := func(contentType, body string) int {
+		logs.Reset()
+		req := httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		h.CSPReport(rec, req)
+		return rec.Code
+	}
+	logged := func(t *testing.T) []map[string]interface{} {
+		t.Helper()
+		var entries []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var entry map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	t.Run("ReportURI", func(t *testing.T) {
+		before := testutil.ToFloat64(cspViolations.WithLabelValues("script-src-elem"))
+
+		code := post("application/csp-report", `{"csp-report": {
+			"document-uri": "https://localhost/index.html",
+			"referrer": "",
+			"violated-directive": "script-src-elem",
+			"effective-directive": "script-src-elem",
+			"original-policy": "default-src 'self'; script-src 'self'; report-uri /csp-report",
+			"disposition": "enforce",
+			"blocked-uri": "inline",
+			"line-number": 12,
+			"column-number": 5,
+			"source-file": "https://localhost/index.html",
+			"status-code": 200,
+			"script-sample": "alert(1)"
+		}}`)
+		require.Equal(t, http.StatusNoContent, code)
+
+		entries := logged(t)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "CSP violation", entries[0]["message"])
+		assert.Equal(t, "script-src-elem", entries[0]["directive"])
+		assert.Equal(t, "inline", entries[0]["blocked_uri"])
+		assert.Equal(t, "https://localhost/index.html", entries[0]["document_uri"])
+		assert.EqualValues(t, 12, entries[0]["line"])
+		assert.Equal(t, before+1, testutil.ToFloat64(cspViolations.WithLabelValues("script-src-elem")))
+	})
+
+	t.Run("ReportingAPI", func(t *testing.T) {
+		code := post("application/reports+json", `[
+			{"type": "csp-violation", "age": 10, "url": "https://localhost/", "body": {
+				"documentURL": "https://localhost/", "effectiveDirective": "img-src",
+				"blockedURL": "https://tracker.example.com/pixel.gif", "disposition": "enforce", "statusCode": 200
+			}},
+			{"type": "deprecation", "url": "https://localhost/", "body": {"id": "something"}}
+		]`)
+		require.Equal(t, http.StatusNoContent, code)
+
+		entries := logged(t)
+		require.Len(t, entries, 1, "only CSP reports are logged")
+		assert.Equal(t, "img-src", entries[0]["directive"])
+		assert.Equal(t, "https://tracker.example.com/pixel.gif", entries[0]["blocked_uri"])
+	})
+
+	t.Run("LegacyViolatedDirective", func(t *testing.T) {
+		code := post("application/csp-report", `{"csp-report": {"violated-directive": "style-src 'self'", "blocked-uri": "inline"}}`)
+		require.Equal(t, http.StatusNoContent, code)
+		assert.Equal(t, "style-src", logged(t)[0]["directive"])
+	})
+
+	t.Run("LongFieldsClipped", func(t *testing.T) {
+		code := post("application/csp-report", `{"csp-report": {"effective-directive": "made-up-src", "blocked-uri": "https://x/`+strings.Repeat("a", 10000)+`"}}`)
+		require.Equal(t, http.StatusNoContent, code)
+		assert.Less(t, len(logged(t)[0]["blocked_uri"].(string)), 300)
+		assert.Equal(t, float64(0), testutil.ToFloat64(cspViolations.WithLabelValues("made-up-src")), "unknown directives share the other label")
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		for _, body := range []string{"not json", `{}`, `{"csp-report": {}}`} {
+			assert.Equal(t, http.StatusBadRequest, post("application/csp-report", body), body)
+		}
+		assert.Equal(t, http.StatusBadRequest, post("application/csp-report", `{"csp-report": {"effective-directive": "`+strings.Repeat("a", maxCSPReportBody)+`"}}`), "oversized")
+	})
+}
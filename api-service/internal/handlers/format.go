@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/models"
+	"encoding/json"
+	"net/http"
+)
+
+// responseFormatter renders a single resource in the configured API_FORMAT.
+// Handlers build the resource once and the formatter decides the envelope.
+type responseFormatter interface {
+	contentType() string
+	resource(r *http.Request, res models.Resource, message string) (interface{}, error)
+}
+
+func newFormatter(format string) responseFormatter {
+	if format == "jsonapi" {
+		return jsonAPIFormatter{}
+	}
+	return envelopeFormatter{}
+}
+
+// envelopeFormatter is the default {success, message, data} envelope
+type envelopeFormatter struct{}
+
+func (envelopeFormatter) contentType() string { return "application/json" }
+
+func (envelopeFormatter) resource(r *http.Request, res models.Resource, message string) (interface{}, error) {
+	return map[string]interface{}{
+		"success": true,
+		"message": message,
+		"data":    res,
+	}, nil
+}
+
+// jsonAPIFormatter renders resources as JSON:API documents
+type jsonAPIFormatter struct{}
+
+func (jsonAPIFormatter) contentType() string { return "application/vnd.api+json" }
+
+func (jsonAPIFormatter) resource(r *http.Request, res models.Resource, message string) (interface{}, error) {
+	attributes, err := resourceAttributes(res)
+	if err != nil {
+		return nil, err
+	}
+
+	relationships := map[string]interface{}{}
+	for name, href := range res.RelatedLinks() {
+		relationships[name] = map[string]interface{}{
+			"links": map[string]string{"related": href},
+		}
+	}
+
+	data := map[string]interface{}{
+		"type":       res.ResourceType(),
+		"id":         res.ResourceID(),
+		"attributes": attributes,
+		"links":      map[string]string{"self": r.URL.Path},
+	}
+	if len(relationships) > 0 {
+		data["relationships"] = relationships
+	}
+
+	return map[string]interface{}{
+		"data":  data,
+		"links": map[string]string{"self": r.URL.RequestURI()},
+		"meta":  map[string]string{"message": message},
+	}, nil
+}
+
+// resourceAttributes reuses the resource's JSON field names, minus the id
+// which JSON:API carries at the top level of the resource object.
+func resourceAttributes(res models.Resource) (map[string]interface{}, error) {
+	raw, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	var attributes map[string]interface{}
+	if err := json.Unmarshal(raw, &attributes); err != nil {
+		return nil, err
+	}
+	delete(attributes, "id")
+	return attributes, nil
+}
+
+// writeResource writes a single resource through the configured formatter
+func (h *Handlers) writeResource(w http.ResponseWriter, r *http.Request, res models.Resource, message string) {
+	body, err := h.formatter.resource(r, res, message)
+	if err != nil {
+		h.app.Logger.Error().Err(err).Msg("Failed to format resource")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Failed to format response")
+		return
+	}
+	writeJSONAs(w, h.app, http.StatusOK, h.formatter.contentType(), body)
+}
+
+// writeResourceWithETag is writeResource with conditional GET support; see
+// writeJSONWithETag
+func (h *Handlers) writeResourceWithETag(w http.ResponseWriter, r *http.Request, res models.Resource, message string) {
+	body, err := h.formatter.resource(r, res, message)
+	if err != nil {
+		h.app.Logger.Error().Err(err).Msg("Failed to format resource")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Failed to format response")
+		return
+	}
+	writeJSONWithETag(w, r, h.app, h.formatter.contentType(), body)
+}
@@ -2,21 +2,69 @@
 package handlers
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	"azlo-goboiler/internal/config"
 	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/notification"
+	"azlo-goboiler/internal/signedurl"
 )
 
 type Handlers struct {
-	app     *config.Application
-	service core.UserService
+	app         *config.Application
+	service     core.UserService
+	audit       core.AuditService
+	apiKeys     core.APIKeyService
+	accounts    core.AccountService
+	mailer      notification.Sender
+	maintenance core.MaintenanceService
+	notifier    notification.Notifier
+	limits      core.LimitsService
+	digests     notification.DigestBuffer
+	google      core.OAuthProvider // nil unless Google sign-in is configured
+	links       *signedurl.Signer
+
+	formatter responseFormatter
+	// background tracks work handlers leave running after they respond
+	background sync.WaitGroup
 }
 
-func New(app *config.Application, service core.UserService) *Handlers {
+func New(app *config.Application, service core.UserService, audit core.AuditService, apiKeys core.APIKeyService, accounts core.AccountService, mailer notification.Sender, maintenance core.MaintenanceService, notifier notification.Notifier, limits core.LimitsService, digests notification.DigestBuffer, google core.OAuthProvider) *Handlers {
 	return &Handlers{
-		app:     app,
-		service: service,
+		app:         app,
+		service:     service,
+		audit:       audit,
+		apiKeys:     apiKeys,
+		accounts:    accounts,
+		mailer:      mailer,
+		maintenance: maintenance,
+		notifier:    notifier,
+		limits:      limits,
+		digests:     digests,
+		google:      google,
+		links:       signedurl.New(app.Config.App_Secret),
+
+		formatter: newFormatter(app.Config.APIFormat),
+	}
+}
+
+// Wait blocks until the work handlers left running after responding, such
+// as password reset emails, has finished, or until ctx is done. Call it
+// once the server has stopped taking requests, before closing what that
+// work uses.
+func (h *Handlers) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
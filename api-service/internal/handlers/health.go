@@ -1,16 +1,32 @@
 package handlers
 
 import (
+	"azlo-goboiler/internal/config"
 	"azlo-goboiler/internal/database"
+	"azlo-goboiler/internal/models"
 	"context"
 	"net/http"
+	"runtime"
 	"time"
 )
 
+// Version handles GET /version
+// @Summary      Build information
+// @Description  Returns the version, commit and build time of the running server. Cacheable; the ETag changes with each build.
+// @Tags         health
+// @Produce      json
+// @Param        If-None-Match header string false "ETag from a previous response"
+// @Success      200  {object}  config.BuildInfo
+// @Success      304  "Build unchanged"
+// @Router       /version [get]
+func (h *Handlers) Version(w http.ResponseWriter, r *http.Request) {
+	writeSuccess(w, r, h.app, h.app.Build, "Version retrieved")
+}
+
 // Health handles health check requests with enhanced diagnostics
 func (h *Handlers) Health(w http.ResponseWriter, r *http.Request) {
 	requestID := getRequestID(r.Context())
-	healthCtx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	healthCtx, cancel := context.WithTimeout(r.Context(), h.app.Config.GetHealthPingTimeout())
 	defer cancel()
 
 	dbStatus := "connected"
@@ -29,7 +45,9 @@ func (h *Handlers) Health(w http.ResponseWriter, r *http.Request) {
 	redisStatus := "connected"
 	var redisLatency time.Duration
 	redisStart := time.Now()
-	if _, err := h.app.Redis.Ping(healthCtx).Result(); err != nil {
+	if h.app.Redis == nil {
+		redisStatus = "not configured"
+	} else if _, err := h.app.Redis.Ping(healthCtx).Result(); err != nil {
 		redisStatus = "disconnected"
 		h.app.Logger.Error().
 			Str("request_id", requestID).
@@ -60,17 +78,32 @@ func (h *Handlers) Health(w http.ResponseWriter, r *http.Request) {
 
 	if dbStatus == "disconnected" || redisStatus == "disconnected" {
 		health["status"] = "degraded"
-		writeResponse(w, h.app, http.StatusServiceUnavailable, false, health, "Service is degraded")
+		writeResponse(w, r, h.app, http.StatusServiceUnavailable, false, health, "Service is degraded")
 		return
 	}
 
-	writeSuccess(w, h.app, health, "Service is healthy")
+	writeSuccess(w, r, h.app, health, "Service is healthy")
+}
+
+// Ready handles GET /ready for load balancer readiness probes. It reports
+// not-ready while the database is unreachable or the instance is shutting
+// down so the instance leaves rotation.
+func (h *Handlers) Ready(w http.ResponseWriter, r *http.Request) {
+	if h.app.Readiness != nil && !h.app.Readiness.Ready() {
+		msg := "Database unavailable"
+		if h.app.Readiness.Draining() {
+			msg = "Shutting down"
+		}
+		writeResponse(w, r, h.app, http.StatusServiceUnavailable, false, map[string]bool{"ready": false}, msg)
+		return
+	}
+	writeSuccess(w, r, h.app, map[string]bool{"ready": true}, "Ready")
 }
 
 // HealthDetailed provides detailed health information including database stats
 func (h *Handlers) HealthDetailed(w http.ResponseWriter, r *http.Request) {
 	requestID := getRequestID(r.Context())
-	healthCtx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	healthCtx, cancel := context.WithTimeout(r.Context(), h.app.Config.GetHealthCheckTimeout())
 	defer cancel()
 
 	health := map[string]interface{}{
@@ -85,7 +118,10 @@ func (h *Handlers) HealthDetailed(w http.ResponseWriter, r *http.Request) {
 	// Database health
 	dbHealth := make(map[string]interface{})
 	dbStart := time.Now()
-	if err := database.HealthCheck(h.app.DB); err != nil {
+	err := database.HealthCheck(healthCtx, h.app.DB, database.HealthCheckOptions{
+		ReadOnly: h.app.Config.HealthCheckReadOnly,
+	})
+	if err != nil {
 		dbHealth["status"] = "unhealthy"
 		dbHealth["error"] = err.Error()
 		health["status"] = "degraded"
@@ -99,7 +135,9 @@ func (h *Handlers) HealthDetailed(w http.ResponseWriter, r *http.Request) {
 	// Redis health
 	redisHealth := make(map[string]interface{})
 	redisStart := time.Now()
-	if _, err := h.app.Redis.Ping(healthCtx).Result(); err != nil {
+	if h.app.Redis == nil {
+		redisHealth["status"] = "not configured"
+	} else if _, err := h.app.Redis.Ping(healthCtx).Result(); err != nil {
 		redisHealth["status"] = "unhealthy"
 		redisHealth["error"] = err.Error()
 		health["status"] = "degraded"
@@ -114,19 +152,90 @@ func (h *Handlers) HealthDetailed(w http.ResponseWriter, r *http.Request) {
 		statusCode = http.StatusServiceUnavailable
 	}
 
-	writeResponse(w, h.app, statusCode, health["status"] == "healthy", health, "Detailed health check complete")
+	writeResponse(w, r, h.app, statusCode, health["status"] == "healthy", health, "Detailed health check complete")
 }
 
 // GetDatabaseStats retrieves DB connection info
 // @Summary      Database Statistics
-// @Description  Get internal database connection pool stats
+// @Description  Get internal database connection pool stats. Requires the admin role.
 // @Tags         admin
 // @Security     Bearer
 // @Produce      json
 // @Success      200  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]string "Admin role required"
 // @Router       /api/v1/admin/db-stats [get]
 func (h *Handlers) GetDatabaseStats(w http.ResponseWriter, r *http.Request) {
-	// In production, you might want to add admin role checking here
 	stats := database.GetConnectionStats(h.app.DB)
-	writeSuccess(w, h.app, stats, "Database statistics retrieved")
+	writeSuccess(w, r, h.app, stats, "Database statistics retrieved")
+}
+
+// GetDiagnostics handles GET /api/v1/admin/diagnostics
+// @Summary      Runtime diagnostics
+// @Description  Returns a snapshot of this instance: build, uptime, goroutines, memory and GC statistics, database and Redis connection pools, and requests in progress. Each instance answers for itself. Reading the memory statistics briefly pauses the process. Requires the admin role.
+// @Tags         admin
+// @Security     Bearer
+// @Produce      json
+// @Success      200  {object}  models.Diagnostics
+// @Failure      403  {object}  map[string]string "Admin role required"
+// @Router       /api/v1/admin/diagnostics [get]
+func (h *Handlers) GetDiagnostics(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	if !h.requireAdmin(w, r, userID) {
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	diag := models.Diagnostics{
+		Version:       h.app.Build.Version,
+		Commit:        h.app.Build.Commit,
+		GoVersion:     runtime.Version(),
+		UptimeSeconds: time.Since(startTime).Seconds(),
+		Goroutines:    runtime.NumGoroutine(),
+		CPUs:          runtime.GOMAXPROCS(0),
+		Memory: models.MemoryStats{
+			HeapAllocBytes:  mem.HeapAlloc,
+			HeapInuseBytes:  mem.HeapInuse,
+			HeapObjects:     mem.HeapObjects,
+			StackInuseBytes: mem.StackInuse,
+			SysBytes:        mem.Sys,
+			TotalAllocBytes: mem.TotalAlloc,
+		},
+		GC: models.GCStats{
+			Cycles:       mem.NumGC,
+			PauseTotalMS: float64(mem.PauseTotalNs) / 1e6,
+			CPUFraction:  mem.GCCPUFraction,
+			NextGCBytes:  mem.NextGC,
+		},
+	}
+	if mem.NumGC > 0 {
+		lastRun := time.Unix(0, int64(mem.LastGC)).UTC()
+		diag.GC.LastRunAt = &lastRun
+		diag.GC.LastPauseMS = float64(mem.PauseNs[(mem.NumGC+255)%256]) / 1e6
+	}
+	if h.app.Readiness != nil {
+		diag.ActiveRequests = h.app.Readiness.InFlight()
+	}
+	if h.app.DB != nil {
+		stats := h.app.DB.Stat()
+		diag.Database = &models.ConnectionStats{
+			Total: int(stats.TotalConns()),
+			InUse: int(stats.AcquiredConns()),
+			Idle:  int(stats.IdleConns()),
+			Max:   int(stats.MaxConns()),
+		}
+	}
+	if h.app.Redis != nil {
+		stats := h.app.Redis.PoolStats()
+		diag.Redis = &models.ConnectionStats{
+			Total: int(stats.TotalConns),
+			InUse: int(stats.TotalConns) - int(stats.IdleConns),
+			Idle:  int(stats.IdleConns),
+			Max:   h.app.Redis.Options().PoolSize,
+		}
+	}
+
+	writeSuccess(w, r, h.app, diag, "Diagnostics retrieved")
 }
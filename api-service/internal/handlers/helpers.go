@@ -2,11 +2,39 @@ package handlers
 
 import (
 	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/middleware"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/validation"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"reflect"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Response headers handlers set for clients to read
+const (
+	paginationCappedHeader   = "X-Pagination-Limit-Capped"
+	locationHeader           = "Location"
+	contentDispositionHeader = "Content-Disposition"
 )
 
+// ResponseHeaders lists the headers above and the middleware ones handlers
+// also set, for CORS to expose
+var ResponseHeaders = []string{
+	paginationCappedHeader,
+	locationHeader,
+	contentDispositionHeader,
+	middleware.RetryAfterHeader,
+}
+
 // --- Helper Functions ---
 
 func getRequestID(ctx context.Context) string {
@@ -15,35 +43,197 @@ func getRequestID(ctx context.Context) string {
 	}
 	return "unknown"
 }
+
+// errNoUser means a handler behind JWT found no user ID in the context
+var errNoUser = errors.New("no authenticated user in context")
+
+// currentUser returns the authenticated caller's profile. Behind
+// middleware.UserCache it is loaded once per request however often it is asked for.
+func (h *Handlers) currentUser(r *http.Request) (*models.User, error) {
+	userID, ok := r.Context().Value(config.UserIDKey).(string)
+	if !ok {
+		return nil, errNoUser
+	}
+	return middleware.LoadUser(r.Context(), userID, h.service.GetProfile)
+}
+
+// startSpan starts a handler span carrying the standard request attributes,
+// currently the authenticated user's ID when there is one
+func startSpan(r *http.Request, name string) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer("handlers").Start(r.Context(), name)
+	if userID, ok := ctx.Value(config.UserIDKey).(string); ok {
+		span.SetAttributes(attribute.String("user.id", userID))
+	}
+	return ctx, span
+}
 func writeJSON(w http.ResponseWriter, app *config.Application, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
+	writeJSONAs(w, app, status, "application/json", data)
+}
+
+// writeJSONAs is writeJSON with an explicit media type
+func writeJSONAs(w http.ResponseWriter, app *config.Application, status int, contentType string, data interface{}) {
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(data); err != nil {
 		app.Logger.Error().Err(err).Msg("Failed to write JSON response")
 	}
 }
 
-func writeResponse(w http.ResponseWriter, app *config.Application, status int, success bool, data interface{}, message string) {
+// writeResponse writes the standard envelope. The two shapes never overlap:
+//
+//	success: {"success": true, "message": ..., "data": ...}
+//	error:   {"success": false, "error": ..., "code": ..., "request_id": ...}
+//
+// An error response only carries "data" when the caller supplies diagnostic
+// detail, as the health checks do. With ERROR_FORMAT=problem errors are
+// RFC 7807 problem details instead; see middleware.WriteError.
+func writeResponse(w http.ResponseWriter, r *http.Request, app *config.Application, status int, success bool, data interface{}, message string) {
+	if data != nil {
+		data = normalizeNilSlices(data)
+	}
+
+	if !success {
+		if err := middleware.WriteError(w, r, app.Config.ErrorFormat, status, message, getRequestID(r.Context()), data); err != nil {
+			app.Logger.Error().Err(err).Msg("Failed to write JSON response")
+		}
+		return
+	}
+
 	response := map[string]interface{}{
-		"success": success,
+		"success": true,
 		"message": message,
 	}
-
 	if data != nil {
 		response["data"] = data
 	}
 
-	if !success {
-		response["error"] = message
+	writeJSON(w, app, status, response)
+}
+
+// normalizeNilSlices makes nil slices serialize as [] instead of null, both for
+// the payload itself and for the top-level fields of a map payload, so list
+// responses always carry an array.
+func normalizeNilSlices(data interface{}) interface{} {
+	if m, ok := data.(map[string]interface{}); ok {
+		for k, v := range m {
+			m[k] = emptyIfNilSlice(v)
+		}
+		return m
 	}
+	return emptyIfNilSlice(data)
+}
 
-	writeJSON(w, app, status, response)
+func emptyIfNilSlice(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice && rv.IsNil() {
+		return reflect.MakeSlice(rv.Type(), 0, 0).Interface()
+	}
+	return v
+}
+
+func writeSuccess(w http.ResponseWriter, r *http.Request, app *config.Application, data interface{}, message string) {
+	writeResponse(w, r, app, http.StatusOK, true, data, message)
+}
+
+// writeJSONWithETag writes a 200 tagged with a hash of its body, so the ETag
+// changes whenever anything in the body does, updated_at included. A request
+// whose If-None-Match still matches gets a 304 with no body. The response is
+// private, no-cache: clients keep it but revalidate before each use.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, app *config.Application, contentType string, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		app.Logger.Error().Err(err).Msg("Failed to write JSON response")
+		writeError(w, r, app, http.StatusInternalServerError, "Failed to format response")
+		return
+	}
+	// The newline json.Encoder ends writeJSON's bodies with
+	body = append(body, '\n')
+
+	if middleware.NotModified(w, r, "private, no-cache", bodyETag(body)) {
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(body); err != nil {
+		app.Logger.Error().Err(err).Msg("Failed to write JSON response")
+	}
+}
+
+// bodyETag returns a strong ETag for a response body
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, app *config.Application, status int, message string) {
+	writeResponse(w, r, app, status, false, nil, message)
+}
+
+// decodeJSON decodes the request body into v. When it can't, it writes the
+// error response and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, app *config.Application, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeDecodeError(w, r, app, err)
+		return false
+	}
+	return true
+}
+
+// writeDecodeError answers a body that failed to decode: 413 when it ran
+// past the route's MaxBodyBytes limit, 400 otherwise
+func writeDecodeError(w http.ResponseWriter, r *http.Request, app *config.Application, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		app.Logger.Warn().
+			Str("request_id", getRequestID(r.Context())).
+			Str("path", r.URL.Path).
+			Int64("limit", tooLarge.Limit).
+			Msg("Request body too large")
+		writeError(w, r, app, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	}
+	writeError(w, r, app, http.StatusBadRequest, "Invalid request format")
+}
+
+// writeBusy sheds a request the password hasher had no room for. The queue
+// drains in well under a second, so clients are told to retry shortly.
+func writeBusy(w http.ResponseWriter, r *http.Request, app *config.Application) {
+	w.Header().Set(middleware.RetryAfterHeader, "1")
+	writeError(w, r, app, http.StatusTooManyRequests, "Server busy, please retry")
+}
+
+// writeTooLong rejects a request whose values are over their length caps
+// with 422, naming each field and its cap
+func writeTooLong(w http.ResponseWriter, r *http.Request, app *config.Application, fields []validation.FieldError) {
+	messages := make([]string, len(fields))
+	for i, f := range fields {
+		messages[i] = f.Message
+	}
+	writeResponse(w, r, app, http.StatusUnprocessableEntity, false,
+		map[string]interface{}{"fields": fields}, "validation failed: "+strings.Join(messages, "; "))
+}
+
+// writeMultiStatus answers a bulk request with 207 Multi-Status and the
+// per-item results. It is used whatever the outcome, so clients always read
+// the same shape: an all-successful batch is 207 too, with Failed at zero.
+func writeMultiStatus(w http.ResponseWriter, r *http.Request, app *config.Application, results []models.BulkItemResult, message string) {
+	body := models.BulkResult{Results: results}
+	for _, res := range results {
+		if res.Status >= 200 && res.Status < 300 {
+			body.Succeeded++
+		} else {
+			body.Failed++
+		}
+	}
+	writeResponse(w, r, app, http.StatusMultiStatus, true, body, message)
 }
 
-func writeSuccess(w http.ResponseWriter, app *config.Application, data interface{}, message string) {
-	writeResponse(w, app, http.StatusOK, true, data, message)
+// NotFound and MethodNotAllowed replace the router's plain-text defaults so
+// unmatched requests get the standard error envelope, request ID included
+func (h *Handlers) NotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, h.app, http.StatusNotFound, "Resource not found")
 }
 
-func writeError(w http.ResponseWriter, app *config.Application, status int, message string) {
-	writeResponse(w, app, status, false, nil, message)
+func (h *Handlers) MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, h.app, http.StatusMethodNotAllowed, "Method not allowed")
 }
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/middleware"
+	"azlo-goboiler/internal/mocks"
+	"azlo-goboiler/internal/service"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func responseKeys(t *testing.T, rec *httptest.ResponseRecorder) []string {
+	t.Helper()
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	keys := make([]string, 0, len(body))
+	for k := range body {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestResponseContract(t *testing.T) {
+	app := newTestApp()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil)
+	req = req.WithContext(context.WithValue(req.Context(), config.RequestIDKey, "req-1"))
+
+	t.Run("Success", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		writeSuccess(rec, req, app, map[string]string{"id": "user-1"}, "Profile retrieved successfully")
+		assert.Equal(t, []string{"data", "message", "success"}, responseKeys(t, rec))
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		writeError(rec, req, app, http.StatusNotFound, "User not found")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, []string{"code", "error", "request_id", "success"}, responseKeys(t, rec))
+
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, false, body["success"])
+		assert.Equal(t, "User not found", body["error"])
+		assert.Equal(t, "not_found", body["code"])
+		assert.Equal(t, "req-1", body["request_id"])
+	})
+
+	t.Run("ErrorWithDetail", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		writeResponse(rec, req, app, http.StatusServiceUnavailable, false, map[string]bool{"ready": false}, "Database unavailable")
+		assert.Equal(t, []string{"code", "data", "error", "request_id", "success"}, responseKeys(t, rec))
+	})
+}
+
+func TestRequestIDReachesHandlers(t *testing.T) {
+	app := newTestApp()
+	repo := new(mocks.MockUserRepository)
+	repo.On("GetByID", mock.Anything, "user-1").Return(nil, core.ErrUserNotFound)
+	svc := service.NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+	h := New(app, svc, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	handler := middleware.New(app, nil, nil, nil, nil).RequestID(http.HandlerFunc(h.GetProfile))
+
+	serve := func(requestID string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := authedRequest(http.MethodGet, "/api/v1/profile", "", "user-1")
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusNotFound, rec.Code)
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return rec, body
+	}
+
+	t.Run("FromClient", func(t *testing.T) {
+		rec, body := serve("req-from-client")
+		assert.Equal(t, "req-from-client", rec.Header().Get("X-Request-ID"))
+		assert.Equal(t, "req-from-client", body["request_id"])
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		rec, body := serve("")
+		assert.NotEmpty(t, rec.Header().Get("X-Request-ID"))
+		assert.NotEqual(t, "unknown", body["request_id"])
+		assert.Equal(t, rec.Header().Get("X-Request-ID"), body["request_id"])
+	})
+}
+
+func TestUnmatchedRoutesUseErrorEnvelope(t *testing.T) {
+	h := New(newTestApp(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/nope", nil)
+	req = req.WithContext(context.WithValue(req.Context(), config.RequestIDKey, "req-1"))
+
+	for status, handler := range map[int]http.HandlerFunc{
+		http.StatusNotFound:         h.NotFound,
+		http.StatusMethodNotAllowed: h.MethodNotAllowed,
+	} {
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		assert.Equal(t, status, rec.Code)
+		assert.Equal(t, []string{"code", "error", "request_id", "success"}, responseKeys(t, rec))
+		assert.Contains(t, rec.Body.String(), `"request_id":"req-1"`)
+	}
+}
+
+func TestErrorFormats(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/42", nil)
+	req = req.WithContext(context.WithValue(req.Context(), config.RequestIDKey, "req-1"))
+
+	cases := []struct {
+		status  int
+		message string
+		code    string
+	}{
+		{http.StatusNotFound, "User not found", "not_found"},
+		{http.StatusUnprocessableEntity, "Username is reserved", "unprocessable_entity"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.code, func(t *testing.T) {
+			envelope := httptest.NewRecorder()
+			writeError(envelope, req, newTestApp(), tc.status, tc.message)
+
+			app := newTestApp()
+			app.Config.ErrorFormat = config.ErrorFormatProblem
+			problem := httptest.NewRecorder()
+			writeError(problem, req, app, tc.status, tc.message)
+
+			assert.Equal(t, tc.status, envelope.Code)
+			assert.Equal(t, tc.status, problem.Code)
+			assert.Equal(t, "application/json", envelope.Header().Get("Content-Type"))
+			assert.Equal(t, "application/problem+json", problem.Header().Get("Content-Type"))
+
+			assert.Equal(t, map[string]interface{}{
+				"success":    false,
+				"error":      tc.message,
+				"code":       tc.code,
+				"request_id": "req-1",
+			}, decodeBody(t, envelope))
+			assert.Equal(t, map[string]interface{}{
+				"type":     "urn:go-api-boilerplate:problem:" + tc.code,
+				"title":    http.StatusText(tc.status),
+				"status":   float64(tc.status),
+				"detail":   tc.message,
+				"instance": "req-1",
+			}, decodeBody(t, problem))
+		})
+	}
+}
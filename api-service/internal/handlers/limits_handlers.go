@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/middleware"
+	"net/http"
+)
+
+// GetProfileLimits handles GET /api/v1/profile/limits
+// @Summary      Get my rate-limit status
+// @Description  Reports the caller's use of the rate limit (counted per client IP unless RATE_LIMIT_KEY says otherwise; see rate_limit.scope) and their last failed login. Checking only reads the limiter's state, so it costs one request like any other.
+// @Tags         profile
+// @Produce      json
+// @Security     Bearer
+// @Success      200  {object}  models.LimitStatus
+// @Router       /api/v1/profile/limits [get]
+func (h *Handlers) GetProfileLimits(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	status, err := h.limits.Status(r.Context(), userID, middleware.ClientIP(r))
+	if err != nil {
+		h.app.Logger.Error().
+			Str("request_id", getRequestID(r.Context())).
+			Err(err).
+			Msg("Failed to read rate-limit status")
+		writeError(w, r, h.app, http.StatusServiceUnavailable, "Rate-limit status unavailable")
+		return
+	}
+
+	writeSuccess(w, r, h.app, status, "Rate-limit status retrieved successfully")
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/middleware"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/validation"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// DBMaintenance handles POST /api/v1/admin/db/maintenance
+// @Summary      Run ANALYZE/VACUUM on application tables
+// @Description  Two-step: without confirmation_token the request is validated and a token valid for five minutes is returned; resending the same request with that token starts the job in the background. Only the application's own tables are accepted. Requires the admin role.
+// @Tags         admin
+// @Security     Bearer
+// @Accept       json
+// @Produce      json
+// @Param        request body models.MaintenanceRequest true "Tables and mode"
+// @Success      200  {object}  models.MaintenanceConfirmation
+// @Success      202  {object}  models.MaintenanceJob
+// @Failure      400  {object}  map[string]string "Unknown table or invalid confirmation token"
+// @Failure      403  {object}  map[string]string "Admin role required"
+// @Failure      409  {object}  map[string]string "Maintenance already running"
+// @Router       /api/v1/admin/db/maintenance [post]
+func (h *Handlers) DBMaintenance(w http.ResponseWriter, r *http.Request) {
+	requestID := getRequestID(r.Context())
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	if !h.requireAdmin(w, r, userID) {
+		return
+	}
+
+	var req models.MaintenanceRequest
+	if !decodeJSON(w, r, h.app, &req) {
+		return
+	}
+	if err := validation.ValidateStruct(&req); err != nil {
+		writeError(w, r, h.app, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.ConfirmationToken == "" {
+		confirmation, err := h.maintenance.Confirm(r.Context(), userID, req)
+		if err != nil {
+			h.writeMaintenanceError(w, r, err)
+			return
+		}
+		middleware.NoReplay(r)
+		writeSuccess(w, r, h.app, confirmation, "Resend this request with confirmation_token to start maintenance")
+		return
+	}
+
+	job, err := h.maintenance.Start(r.Context(), userID, req)
+	if err != nil {
+		h.writeMaintenanceError(w, r, err)
+		return
+	}
+
+	h.recordAudit(r, userID, models.AuditActionDBMaintenance, job.ID, map[string]interface{}{
+		"tables": job.Tables,
+		"vacuum": job.Vacuum,
+	})
+	h.app.Logger.Warn().
+		Str("request_id", requestID).
+		Str("user_id", userID).
+		Str("job_id", job.ID).
+		Strs("tables", job.Tables).
+		Bool("vacuum", job.Vacuum).
+		Msg("Database maintenance started")
+
+	w.Header().Set(locationHeader, "/api/v1/admin/db/maintenance/"+job.ID)
+	writeResponse(w, r, h.app, http.StatusAccepted, true, job, "Maintenance started")
+}
+
+// GetMaintenanceJob handles GET /api/v1/admin/db/maintenance/{id}
+// @Summary      Poll a maintenance job
+// @Description  Returns the status of a maintenance job. Jobs are kept for 24 hours. Requires the admin role.
+// @Tags         admin
+// @Security     Bearer
+// @Produce      json
+// @Param        id   path      string  true  "Job ID"
+// @Success      200  {object}  models.MaintenanceJob
+// @Failure      403  {object}  map[string]string "Admin role required"
+// @Failure      404  {object}  map[string]string "Job not found"
+// @Router       /api/v1/admin/db/maintenance/{id} [get]
+func (h *Handlers) GetMaintenanceJob(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	if !h.requireAdmin(w, r, userID) {
+		return
+	}
+
+	job, err := h.maintenance.Job(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		h.writeMaintenanceError(w, r, err)
+		return
+	}
+	writeSuccess(w, r, h.app, job, "Maintenance job retrieved")
+}
+
+// ListDBSessions handles GET /api/v1/admin/db/sessions
+// @Summary      List the application's database sessions
+// @Description  Returns the application's own connections from pg_stat_activity with their state, current or last query, and how long they have been in that state, longest first. Connections of other applications are never listed. Requires the admin role.
+// @Tags         admin
+// @Security     Bearer
+// @Produce      json
+// @Success      200  {object}  []models.DBSession
+// @Failure      403  {object}  map[string]string "Admin role required"
+// @Router       /api/v1/admin/db/sessions [get]
+func (h *Handlers) ListDBSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	if !h.requireAdmin(w, r, userID) {
+		return
+	}
+
+	sessions, err := h.maintenance.Sessions(r.Context())
+	if err != nil {
+		h.writeMaintenanceError(w, r, err)
+		return
+	}
+	writeSuccess(w, r, h.app, sessions, "Database sessions retrieved")
+}
+
+// CancelDBSession handles POST /api/v1/admin/db/sessions/{pid}/cancel
+// @Summary      Cancel a database session's query
+// @Description  Cancels the query running on one of the application's own connections with pg_cancel_backend. The connection itself stays open. PIDs of other applications' connections are reported as not found. Requires the admin role.
+// @Tags         admin
+// @Security     Bearer
+// @Produce      json
+// @Param        pid  path      int  true  "Backend PID from the sessions list"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string "Invalid PID"
+// @Failure      403  {object}  map[string]string "Admin role required"
+// @Failure      404  {object}  map[string]string "Session not found"
+// @Failure      429  {object}  map[string]string "Rate limit exceeded"
+// @Router       /api/v1/admin/db/sessions/{pid}/cancel [post]
+func (h *Handlers) CancelDBSession(w http.ResponseWriter, r *http.Request) {
+	requestID := getRequestID(r.Context())
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	if !h.requireAdmin(w, r, userID) {
+		return
+	}
+
+	pid, err := strconv.ParseInt(mux.Vars(r)["pid"], 10, 32)
+	if err != nil || pid <= 0 {
+		writeError(w, r, h.app, http.StatusBadRequest, "Invalid PID")
+		return
+	}
+
+	query, err := h.maintenance.CancelSession(r.Context(), int32(pid))
+	if err != nil {
+		if errors.Is(err, core.ErrDBSessionNotFound) {
+			h.app.Logger.Warn().
+				Str("request_id", requestID).
+				Str("user_id", userID).
+				Int64("pid", pid).
+				Msg("Refused to cancel a database session outside the application")
+		}
+		h.writeMaintenanceError(w, r, err)
+		return
+	}
+
+	// Postgres already caps the query text at track_activity_query_size
+	h.recordAudit(r, userID, models.AuditActionDBSessionCancel, strconv.FormatInt(pid, 10), map[string]interface{}{
+		"query": query,
+	})
+	h.app.Logger.Warn().
+		Str("request_id", requestID).
+		Str("user_id", userID).
+		Int64("pid", pid).
+		Msg("Database session query cancelled")
+
+	writeSuccess(w, r, h.app, map[string]interface{}{"pid": pid}, "Cancel signal sent")
+}
+
+// GetMaintenanceMode handles GET /api/v1/admin/maintenance
+// @Summary      Get maintenance mode
+// @Description  Returns whether maintenance mode is on, and since when and by whom. Requires the admin role.
+// @Tags         admin
+// @Security     Bearer
+// @Produce      json
+// @Success      200  {object}  models.MaintenanceMode
+// @Failure      403  {object}  map[string]string "Admin role required"
+// @Router       /api/v1/admin/maintenance [get]
+func (h *Handlers) GetMaintenanceMode(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	if !h.requireAdmin(w, r, userID) {
+		return
+	}
+
+	mode, err := h.maintenance.Mode(r.Context())
+	if err != nil {
+		h.app.Logger.Error().Str("request_id", getRequestID(r.Context())).Err(err).Msg("Failed to read maintenance mode")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Failed to read maintenance mode")
+		return
+	}
+	writeSuccess(w, r, h.app, mode, "Maintenance mode retrieved")
+}
+
+// SetMaintenanceMode handles POST /api/v1/admin/maintenance
+// @Summary      Turn maintenance mode on or off
+// @Description  While maintenance mode is on every instance answers 503 with a Retry-After, except for /health, /ready, /metrics, this endpoint and clients in MAINTENANCE_IP_ALLOWLIST. message and retry_after_seconds replace the default error message and Retry-After of 300 seconds. It stays on until turned off. Requires the admin role.
+// @Tags         admin
+// @Security     Bearer
+// @Accept       json
+// @Produce      json
+// @Param        request body models.MaintenanceModeRequest true "Switch"
+// @Success      200  {object}  models.MaintenanceMode
+// @Failure      400  {object}  map[string]string "Invalid request"
+// @Failure      403  {object}  map[string]string "Admin role required"
+// @Router       /api/v1/admin/maintenance [post]
+func (h *Handlers) SetMaintenanceMode(w http.ResponseWriter, r *http.Request) {
+	requestID := getRequestID(r.Context())
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	if !h.requireAdmin(w, r, userID) {
+		return
+	}
+
+	var req models.MaintenanceModeRequest
+	if !decodeJSON(w, r, h.app, &req) {
+		return
+	}
+	if err := validation.ValidateStruct(&req); err != nil {
+		writeError(w, r, h.app, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	mode, err := h.maintenance.SetMode(r.Context(), userID, req)
+	if err != nil {
+		h.app.Logger.Error().Str("request_id", requestID).Err(err).Msg("Failed to switch maintenance mode")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Failed to switch maintenance mode")
+		return
+	}
+
+	h.recordAudit(r, userID, models.AuditActionMaintenanceMode, "", map[string]interface{}{
+		"enabled": mode.Enabled,
+		"message": mode.Message,
+	})
+	h.app.Logger.Warn().
+		Str("request_id", requestID).
+		Str("user_id", userID).
+		Bool("enabled", mode.Enabled).
+		Msg("Maintenance mode switched")
+
+	message := "Maintenance mode disabled"
+	if mode.Enabled {
+		message = "Maintenance mode enabled"
+	}
+	writeSuccess(w, r, h.app, mode, message)
+}
+
+func (h *Handlers) writeMaintenanceError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, core.ErrUnknownTable):
+		writeError(w, r, h.app, http.StatusBadRequest, err.Error())
+	case errors.Is(err, core.ErrMaintenanceConfirmation):
+		writeError(w, r, h.app, http.StatusBadRequest, "Invalid or expired confirmation token")
+	case errors.Is(err, core.ErrMaintenanceRunning):
+		writeError(w, r, h.app, http.StatusConflict, "Maintenance already running")
+	case errors.Is(err, core.ErrMaintenanceJobNotFound):
+		writeError(w, r, h.app, http.StatusNotFound, "Job not found")
+	case errors.Is(err, core.ErrDBSessionNotFound):
+		writeError(w, r, h.app, http.StatusNotFound, "Session not found")
+	default:
+		h.app.Logger.Error().Str("request_id", getRequestID(r.Context())).Err(err).Msg("Database maintenance failed")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Database maintenance failed")
+	}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/middleware"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/oauth"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// googleStateCookie carries the state and PKCE verifier of a Google
+	// sign-in from the redirect to the callback
+	googleStateCookie = "oauth_google"
+	googleStatePath   = "/auth/oauth/google"
+	googleStateTTL    = 10 * time.Minute
+
+	// Where the browser lands after a provider sign-in
+	oauthSuccessPage = "/index.html"
+	oauthFailurePage = "/login.html"
+)
+
+// GoogleLogin godoc
+// @Summary      Sign in with Google
+// @Description  Redirects to Google's consent screen. Google sends the user back to /auth/oauth/google/callback.
+// @Tags         auth
+// @Success      302
+// @Failure      404  {object}  map[string]string "Google sign-in is not configured"
+// @Router       /auth/oauth/google/login [get]
+func (h *Handlers) GoogleLogin(w http.ResponseWriter, r *http.Request) {
+	if h.google == nil {
+		writeError(w, r, h.app, http.StatusNotFound, "Google sign-in is not configured")
+		return
+	}
+
+	state, verifier := oauth.NewState()
+	http.SetCookie(w, &http.Cookie{
+		Name:     googleStateCookie,
+		Value:    state + "." + verifier,
+		MaxAge:   int(googleStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		Path:     googleStatePath,
+		// Lax whatever COOKIE_SAMESITE says: the callback is a cross-site
+		// navigation from Google, which drops Strict cookies
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, h.google.AuthCodeURL(state, verifier), http.StatusFound)
+}
+
+// GoogleCallback godoc
+// @Summary      Google sign-in callback
+// @Description  Completes a Google sign-in. The Google account is matched to a linked user, then to a user with the same verified email, and otherwise a new user is created. On success the session cookies are set exactly as by /auth/login and the browser is sent to the app; on failure it is sent to the login page with an error code.
+// @Tags         auth
+// @Param        state  query  string  true  "State issued by /auth/oauth/google/login"
+// @Param        code   query  string  true  "Authorization code"
+// @Success      302
+// @Failure      404  {object}  map[string]string "Google sign-in is not configured"
+// @Router       /auth/oauth/google/callback [get]
+func (h *Handlers) GoogleCallback(w http.ResponseWriter, r *http.Request) {
+	if h.google == nil {
+		writeError(w, r, h.app, http.StatusNotFound, "Google sign-in is not configured")
+		return
+	}
+	requestID := getRequestID(r.Context())
+
+	// The state is single-use whatever happens next
+	var state, verifier string
+	if cookie, err := r.Cookie(googleStateCookie); err == nil {
+		state, verifier, _ = strings.Cut(cookie.Value, ".")
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name: googleStateCookie, Value: "", MaxAge: -1,
+		HttpOnly: true, Secure: true, Path: googleStatePath, SameSite: http.SameSiteLaxMode,
+	})
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		h.oauthFailed(w, r, "oauth_denied")
+		return
+	}
+	if state == "" || verifier == "" || subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		h.app.Logger.Warn().
+			Str("request_id", requestID).
+			Msg("Google callback with missing or mismatched state")
+		h.oauthFailed(w, r, "oauth_state")
+		return
+	}
+
+	profile, err := h.google.Exchange(r.Context(), query.Get("code"), verifier)
+	if err != nil {
+		h.app.Logger.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Google code exchange failed")
+		h.oauthFailed(w, r, "oauth_failed")
+		return
+	}
+
+	result, err := h.service.LoginWithIdentity(r.Context(), *profile)
+	if err != nil {
+		code := "oauth_failed"
+		switch {
+		case errors.Is(err, core.ErrIdentityEmailUnverified):
+			code = "email_unverified"
+		case errors.Is(err, core.ErrIdentityLinkUnverified):
+			code = "link_unverified"
+		case errors.Is(err, core.ErrSessionUnavailable):
+			code = "unavailable"
+		}
+		h.app.Logger.Warn().
+			Str("request_id", requestID).
+			Str("provider", profile.Provider).
+			Err(err).
+			Msg("Google sign-in failed")
+		h.oauthFailed(w, r, code)
+		return
+	}
+
+	userID := result.User.ID
+	metadata := map[string]interface{}{"provider": profile.Provider}
+	switch {
+	case result.Created:
+		h.recordAudit(r, userID, models.AuditActionRegister, userID, metadata)
+	case result.Linked:
+		h.recordAudit(r, userID, models.AuditActionIdentityLink, userID, metadata)
+	}
+	if err := h.audit.RecordLogin(r.Context(), userID, middleware.ClientIP(r), r.UserAgent()); err != nil {
+		h.app.Logger.Error().
+			Str("request_id", requestID).
+			Err(err).
+			Msg("Failed to record login history")
+	}
+
+	h.app.Logger.Info().
+		Str("request_id", requestID).
+		Str("user_id", userID).
+		Bool("created", result.Created).
+		Msg("User authenticated with Google")
+
+	h.setAuthCookie(w, result.LoginResponse)
+	http.Redirect(w, r, oauthSuccessPage, http.StatusFound)
+}
+
+// oauthFailed sends the browser back to the login page with an error code
+func (h *Handlers) oauthFailed(w http.ResponseWriter, r *http.Request, code string) {
+	http.Redirect(w, r, oauthFailurePage+"?error="+url.QueryEscape(code), http.StatusFound)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/mocks"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/service"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// stubProvider hands back profile for any code exchanged with the expected verifier
+type stubProvider struct {
+	profile  models.OAuthProfile
+	verifier string
+}
+
+func (p *stubProvider) AuthCodeURL(state, verifier string) string {
+	p.verifier = verifier
+	return "https://accounts.example.com/auth?state=" + url.QueryEscape(state)
+}
+
+func (p *stubProvider) Exchange(ctx context.Context, code, verifier string) (*models.OAuthProfile, error) {
+	if verifier != p.verifier {
+		return nil, assert.AnError
+	}
+	return &p.profile, nil
+}
+
+func TestGoogleSignIn(t *testing.T) {
+	provider := &stubProvider{profile: models.OAuthProfile{
+		Provider: models.ProviderGoogle, Subject: "g-123", Email: "alice@example.com", EmailVerified: true,
+	}}
+
+	repo := new(mocks.MockUserRepository)
+	repo.On("GetUserIDByIdentity", mock.Anything, models.ProviderGoogle, "g-123").Return("user-1", nil)
+	repo.On("GetByID", mock.Anything, "user-1").Return(&models.User{ID: "user-1", Username: "alice"}, nil)
+	repo.On("UpdateLastLogin", mock.Anything, "user-1").Return(nil)
+	audit := new(mocks.MockAuditService)
+	audit.On("RecordLogin", mock.Anything, "user-1", mock.Anything, mock.Anything).Return(nil)
+
+	app := newTestApp()
+	app.Config = config.Config{App_Secret: "test-secret-that-is-at-least-32-chars", JWTExpirationHours: 1}
+	svc := service.NewUserService(repo, nil, &app.Config, nil, nil)
+	h := New(app, svc, audit, nil, nil, nil, nil, nil, nil, nil, provider)
+
+	// start begins a sign-in and returns the state and its cookie
+	start := func(t *testing.T) (string, *http.Cookie) {
+		rec := httptest.NewRecorder()
+		h.GoogleLogin(rec, httptest.NewRequest(http.MethodGet, "/auth/oauth/google/login", nil))
+		require.Equal(t, http.StatusFound, rec.Code)
+		u, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		cookies := rec.Result().Cookies()
+		require.Len(t, cookies, 1)
+		return u.Query().Get("state"), cookies[0]
+	}
+
+	callback := func(query string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/auth/oauth/google/callback?"+query, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		h.GoogleCallback(rec, req)
+		return rec
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		state, cookie := start(t)
+		rec := callback("state="+url.QueryEscape(state)+"&code=code-1", cookie)
+
+		require.Equal(t, http.StatusFound, rec.Code)
+		assert.Equal(t, oauthSuccessPage, rec.Header().Get("Location"))
+		var session *http.Cookie
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == config.AuthCookieName {
+				session = c
+			}
+		}
+		require.NotNil(t, session, "the session cookie is set as on password login")
+		assert.True(t, session.HttpOnly)
+		assert.NotEmpty(t, session.Value)
+	})
+
+	t.Run("StateMismatch", func(t *testing.T) {
+		_, cookie := start(t)
+		rec := callback("state=forged&code=code-1", cookie)
+		assert.Equal(t, oauthFailurePage+"?error=oauth_state", rec.Header().Get("Location"))
+	})
+
+	t.Run("MissingStateCookie", func(t *testing.T) {
+		state, _ := start(t)
+		rec := callback("state="+url.QueryEscape(state)+"&code=code-1", nil)
+		assert.Equal(t, oauthFailurePage+"?error=oauth_state", rec.Header().Get("Location"))
+	})
+
+	t.Run("NotConfigured", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		New(app, svc, audit, nil, nil, nil, nil, nil, nil, nil, nil).
+			GoogleLogin(rec, httptest.NewRequest(http.MethodGet, "/auth/oauth/google/login", nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
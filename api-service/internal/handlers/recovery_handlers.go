@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/middleware"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/notification/templates"
+	"azlo-goboiler/internal/validation"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// GetRecoveryEmail handles GET /api/v1/profile/recovery-email
+// @Summary      Get recovery email
+// @Description  Returns the caller's recovery email and whether it is verified. Password resets only go to a verified one.
+// @Tags         profile
+// @Security     Bearer
+// @Produce      json
+// @Success      200  {object}  models.RecoveryContact
+// @Failure      404  {object}  map[string]string "No recovery email set"
+// @Router       /api/v1/profile/recovery-email [get]
+func (h *Handlers) GetRecoveryEmail(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	contact, err := h.service.RecoveryEmail(r.Context(), userID)
+	if err != nil {
+		h.app.Logger.Error().Str("request_id", getRequestID(r.Context())).Err(err).Msg("Failed to fetch recovery email")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Failed to fetch recovery email")
+		return
+	}
+	if contact == nil {
+		writeError(w, r, h.app, http.StatusNotFound, "No recovery email set")
+		return
+	}
+
+	writeSuccess(w, r, h.app, contact, "Recovery email retrieved successfully")
+}
+
+// UpdateRecoveryEmail handles PUT /api/v1/profile/recovery-email
+// @Summary      Set recovery email
+// @Description  Sets an address besides the login email for password resets and emails it a verification link. It replaces any earlier recovery email at once, and is not used until the link is followed. Requires the current password, and the login email is told of the change.
+// @Tags         profile
+// @Security     Bearer
+// @Accept       json
+// @Produce      json
+// @Param        request body models.UpdateRecoveryEmailRequest true "Recovery email"
+// @Success      202  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string "Invalid request"
+// @Failure      401  {object}  map[string]string "Current password is incorrect"
+// @Failure      502  {object}  map[string]string "Verification email could not be sent"
+// @Router       /api/v1/profile/recovery-email [put]
+func (h *Handlers) UpdateRecoveryEmail(w http.ResponseWriter, r *http.Request) {
+	requestID := getRequestID(r.Context())
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	var req models.UpdateRecoveryEmailRequest
+	if !decodeJSON(w, r, h.app, &req) {
+		return
+	}
+
+	if err := validation.ValidateStruct(&req); err != nil {
+		writeError(w, r, h.app, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	token, err := h.service.RequestRecoveryEmail(r.Context(), userID, req.CurrentPassword, req.Email)
+	if err != nil {
+		if errors.Is(err, core.ErrPasswordIncorrect) {
+			writeError(w, r, h.app, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if errors.Is(err, core.ErrHasherBusy) {
+			writeBusy(w, r, h.app)
+			return
+		}
+		h.app.Logger.Error().Str("request_id", requestID).Err(err).Msg("Failed to set recovery email")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Failed to update recovery email")
+		return
+	}
+
+	err = h.sendTemplate(r.Context(), req.Email, templates.RecoveryEmail, templates.LinkData{
+		Link: h.publicLink("/auth/verify-recovery-email", token),
+	})
+	if err != nil {
+		h.app.Logger.Warn().Str("request_id", requestID).Err(err).Msg("Failed to send recovery email verification")
+		writeError(w, r, h.app, http.StatusBadGateway, "Failed to send verification email")
+		return
+	}
+
+	h.recordAudit(r, userID, models.AuditActionRecoveryEmail, userID, nil)
+	h.alertRecoveryChanged(r, req.Email)
+
+	writeResponse(w, r, h.app, http.StatusAccepted, true, map[string]interface{}{
+		"recovery_email": req.Email,
+		"verified":       false,
+	}, "Verification email sent")
+}
+
+// VerifyRecoveryEmail handles GET /auth/verify-recovery-email
+// @Summary      Verify recovery email
+// @Description  Confirms a recovery email address using the token from the verification link
+// @Tags         auth
+// @Produce      json
+// @Param        token query string true "Verification token"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string "Invalid or expired token"
+// @Router       /auth/verify-recovery-email [get]
+func (h *Handlers) VerifyRecoveryEmail(w http.ResponseWriter, r *http.Request) {
+	err := h.service.VerifyRecoveryEmail(r.Context(), r.URL.Query().Get("token"))
+	if err != nil {
+		if errors.Is(err, core.ErrVerificationTokenInvalid) {
+			writeError(w, r, h.app, http.StatusBadRequest, "Invalid or expired verification token")
+			return
+		}
+		h.app.Logger.Error().Str("request_id", getRequestID(r.Context())).Err(err).Msg("Failed to verify recovery email")
+		writeError(w, r, h.app, http.StatusInternalServerError, "Failed to verify recovery email")
+		return
+	}
+
+	writeSuccess(w, r, h.app, map[string]bool{"verified": true}, "Recovery email verified")
+}
+
+// alertRecoveryChanged tells the login email that address is now the
+// recovery email. Whoever controls the recovery email can reset the
+// password, so the owner hears of a change even if they turned
+// notifications off. Like notify it never fails the request.
+func (h *Handlers) alertRecoveryChanged(r *http.Request, address string) {
+	requestID := getRequestID(r.Context())
+	user, err := h.currentUser(r)
+	if err == nil {
+		err = h.sendTemplate(r.Context(), user.Email, templates.RecoveryChanged, templates.RecoveryChangedData{
+			Username:  user.Username,
+			Address:   address,
+			IPAddress: middleware.ClientIP(r),
+			At:        time.Now().UTC().Format("Mon, 02 Jan 2006 15:04 MST"),
+		})
+	}
+	if err != nil {
+		h.app.Logger.Warn().Str("request_id", requestID).Err(err).Msg("Failed to alert login email of recovery email change")
+	}
+}
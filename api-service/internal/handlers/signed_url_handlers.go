@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/middleware"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/validation"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CreateSignedURL handles POST /api/v1/admin/signed-urls
+// @Summary      Create a signed download link
+// @Description  Returns a link to a path under /files/ that works without credentials until it expires (at most 7 days). Anyone holding the link can use it, so share it like a password. Requires the admin role.
+// @Tags         admin
+// @Security     Bearer
+// @Accept       json
+// @Produce      json
+// @Param        request body models.CreateSignedURLRequest true "Path and lifetime"
+// @Success      200  {object}  models.SignedURL
+// @Failure      400  {object}  map[string]string "Invalid request or path"
+// @Failure      403  {object}  map[string]string "Admin role required"
+// @Router       /api/v1/admin/signed-urls [post]
+func (h *Handlers) CreateSignedURL(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.UserIDKey).(string)
+
+	if !h.requireAdmin(w, r, userID) {
+		return
+	}
+
+	var req models.CreateSignedURLRequest
+	if !decodeJSON(w, r, h.app, &req) {
+		return
+	}
+
+	if err := validation.ValidateStruct(&req); err != nil {
+		writeError(w, r, h.app, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	link, expiresAt, err := h.links.GenerateSignedURL(req.Path, time.Duration(req.ExpiresIn)*time.Second)
+	if err != nil {
+		writeError(w, r, h.app, http.StatusBadRequest, "Path must be under /files/")
+		return
+	}
+
+	h.recordAudit(r, userID, models.AuditActionSignedURLCreate, "", map[string]interface{}{
+		"path":       req.Path,
+		"expires_at": expiresAt,
+	})
+
+	middleware.NoReplay(r) // the URL is a credential
+	writeSuccess(w, r, h.app, models.SignedURL{
+		URL:       strings.TrimRight(h.app.Config.PublicURL, "/") + link,
+		ExpiresAt: expiresAt,
+	}, "Signed URL created")
+}
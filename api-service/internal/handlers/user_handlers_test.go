@@ -0,0 +1,606 @@
+package handlers
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/kvstore"
+	"azlo-goboiler/internal/middleware"
+	"azlo-goboiler/internal/mocks"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/notification"
+	"azlo-goboiler/internal/notification/templates"
+	"azlo-goboiler/internal/repository"
+	"azlo-goboiler/internal/service"
+	"azlo-goboiler/internal/validation"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestGetUsersEmptyList(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	// A repository that hands back a nil slice must still produce []
+	repo.On("List", mock.Anything, 10, 0).Return([]models.UserListItem(nil), nil)
+	repo.On("Count", mock.Anything).Return(0, nil)
+	repo.On("CollectionVersion", mock.Anything).Return(0, time.Unix(0, 0), nil)
+
+	svc := service.NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+	h := New(newTestApp(), svc, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	rec := httptest.NewRecorder()
+	h.GetUsers(rec, httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.JSONEq(t, `[]`, string(body.Data["users"]))
+}
+
+func TestGetUsersListItemFields(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	repo.On("List", mock.Anything, 10, 0).Return([]models.UserListItem{{
+		ID: "u1", Username: "alice", Email: "alice@example.com", CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}}, nil)
+	repo.On("Count", mock.Anything).Return(1, nil)
+	repo.On("CollectionVersion", mock.Anything).Return(1, time.Unix(0, 0), nil)
+
+	svc := service.NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+	h := New(newTestApp(), svc, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	rec := httptest.NewRecorder()
+	h.GetUsers(rec, httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var body struct {
+		Data struct {
+			Users []map[string]interface{} `json:"users"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Len(t, body.Data.Users, 1)
+
+	item := body.Data.Users[0]
+	assert.Equal(t, "alice", item["username"])
+	assert.Equal(t, "2025-01-02T03:04:05Z", item["created_at"])
+	// Columns the list query never reads must not appear as zero values
+	for _, field := range []string{"is_active", "role", "must_change_password", "updated_at", "last_login"} {
+		assert.NotContains(t, item, field)
+	}
+}
+
+func TestGetUsersETag(t *testing.T) {
+	updatedAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// newHandlers serves a collection with the given count and latest update time
+	newHandlers := func(count int, latest time.Time) *Handlers {
+		repo := new(mocks.MockUserRepository)
+		repo.On("CollectionVersion", mock.Anything).Return(count, latest, nil)
+		repo.On("List", mock.Anything, 10, 0).Return([]models.UserListItem{{ID: "u1", Username: "alice"}}, nil)
+		repo.On("Count", mock.Anything).Return(count, nil)
+		svc := service.NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+		return New(newTestApp(), svc, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	}
+
+	get := func(h *Handlers, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.GetUsers(rec, req)
+		return rec
+	}
+
+	first := get(newHandlers(1, updatedAt), "")
+	require.Equal(t, http.StatusOK, first.Code)
+	etag := first.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	assert.True(t, strings.HasPrefix(etag, `W/"`))
+
+	t.Run("UnchangedReturns304", func(t *testing.T) {
+		rec := get(newHandlers(1, updatedAt), etag)
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Empty(t, rec.Body.String())
+		assert.Equal(t, etag, rec.Header().Get("ETag"))
+	})
+
+	t.Run("InsertChangesETag", func(t *testing.T) {
+		rec := get(newHandlers(2, updatedAt.Add(time.Second)), etag)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+	})
+
+	t.Run("UpdateChangesETag", func(t *testing.T) {
+		rec := get(newHandlers(1, updatedAt.Add(time.Millisecond)), etag)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+	})
+}
+
+func TestProfileStaleIfError(t *testing.T) {
+	user := &models.User{ID: "user-1", Username: "alice", Email: "alice@example.com", IsActive: true, Role: models.RoleUser}
+	dbDown := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	repo := new(mocks.MockUserRepository)
+	repo.On("GetByID", mock.Anything, "user-1").Return(user, nil).Once()
+	repo.On("GetByID", mock.Anything, mock.Anything).Return(nil, dbDown)
+	svc := service.NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+
+	app := newTestApp()
+	app.Config.StaleIfError = 60
+	h := New(app, svc, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	handler := middleware.New(app, nil, nil, kvstore.NewMemory(), nil).StaleIfError(http.HandlerFunc(h.GetProfile))
+	get := func(userID string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, authedRequest(http.MethodGet, "/api/v1/profile", "", userID))
+		return rec
+	}
+
+	fresh := get("user-1")
+	require.Equal(t, http.StatusOK, fresh.Code)
+	assert.Empty(t, fresh.Header().Get("Warning"))
+
+	t.Run("CachedServedStale", func(t *testing.T) {
+		rec := get("user-1")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, middleware.StaleWarning, rec.Header().Get("Warning"))
+		assert.Equal(t, "0", rec.Header().Get("Age"))
+		assert.Equal(t, fresh.Header().Get("ETag"), rec.Header().Get("ETag"))
+		assert.Equal(t, fresh.Body.String(), rec.Body.String())
+	})
+
+	t.Run("UncachedUnavailable", func(t *testing.T) {
+		rec := get("user-2")
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Empty(t, rec.Header().Get("Warning"))
+		assert.Equal(t, "Profile temporarily unavailable", decodeBody(t, rec)["error"])
+	})
+}
+
+func TestGetProfileFormats(t *testing.T) {
+	user := &models.User{
+		ID: "user-1", Username: "alice", Email: "alice@example.com", IsActive: true, Role: models.RoleUser,
+		CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC), UpdatedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	getProfile := func(format string) *httptest.ResponseRecorder {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByID", mock.Anything, "user-1").Return(user, nil)
+		svc := service.NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+
+		app := newTestApp()
+		app.Config.APIFormat = format
+		h := New(app, svc, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+		rec := httptest.NewRecorder()
+		h.GetProfile(rec, authedRequest(http.MethodGet, "/api/v1/profile", "", "user-1"))
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec
+	}
+
+	envelope := getProfile("envelope")
+	jsonAPI := getProfile("jsonapi")
+
+	t.Run("EnvelopeDefault", func(t *testing.T) {
+		assert.Equal(t, "application/json", envelope.Header().Get("Content-Type"))
+
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(envelope.Body.Bytes(), &body))
+		assert.Equal(t, true, body["success"])
+		data := body["data"].(map[string]interface{})
+		assert.Equal(t, "user-1", data["id"])
+		assert.Equal(t, "alice", data["username"])
+	})
+
+	t.Run("JSONAPI", func(t *testing.T) {
+		assert.Equal(t, "application/vnd.api+json", jsonAPI.Header().Get("Content-Type"))
+
+		var doc struct {
+			Data struct {
+				Type          string                            `json:"type"`
+				ID            string                            `json:"id"`
+				Attributes    map[string]interface{}            `json:"attributes"`
+				Links         map[string]string                 `json:"links"`
+				Relationships map[string]map[string]interface{} `json:"relationships"`
+			} `json:"data"`
+			Links map[string]string `json:"links"`
+			Meta  map[string]string `json:"meta"`
+		}
+		require.NoError(t, json.Unmarshal(jsonAPI.Body.Bytes(), &doc))
+
+		assert.Equal(t, "users", doc.Data.Type)
+		assert.Equal(t, "user-1", doc.Data.ID)
+		assert.NotContains(t, doc.Data.Attributes, "id")
+		assert.NotContains(t, doc.Data.Attributes, "password_hash")
+		assert.Equal(t, "/api/v1/profile", doc.Data.Links["self"])
+		assert.Equal(t, "/api/v1/profile", doc.Links["self"])
+		assert.Contains(t, doc.Data.Relationships, "login_history")
+		assert.Equal(t, "Profile retrieved successfully", doc.Meta["message"])
+	})
+
+	t.Run("SameAttributes", func(t *testing.T) {
+		var env struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		var doc struct {
+			Data struct {
+				Attributes map[string]interface{} `json:"attributes"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(envelope.Body.Bytes(), &env))
+		require.NoError(t, json.Unmarshal(jsonAPI.Body.Bytes(), &doc))
+
+		delete(env.Data, "id")
+		assert.Equal(t, env.Data, doc.Data.Attributes)
+	})
+}
+
+func TestProfileETag(t *testing.T) {
+	updatedAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// newHandlers serves alice's profile and preferences as last changed at updated
+	newHandlers := func(updated time.Time) *Handlers {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByID", mock.Anything, "user-1").Return(&models.User{
+			ID: "user-1", Username: "alice", Email: "alice@example.com", IsActive: true, UpdatedAt: updated,
+		}, nil)
+		repo.On("GetPreferences", mock.Anything, "user-1").Return(&models.UserPreferences{
+			UserID: "user-1", Frequency: "daily", UpdatedAt: updated,
+		}, nil)
+		svc := service.NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+		return New(newTestApp(), svc, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	}
+
+	for path, handler := range map[string]func(*Handlers) http.HandlerFunc{
+		"/api/v1/profile":             func(h *Handlers) http.HandlerFunc { return h.GetProfile },
+		"/api/v1/profile/preferences": func(h *Handlers) http.HandlerFunc { return h.GetPreferences },
+	} {
+		t.Run(path, func(t *testing.T) {
+			get := func(h *Handlers, ifNoneMatch string) *httptest.ResponseRecorder {
+				req := authedRequest(http.MethodGet, path, "", "user-1")
+				if ifNoneMatch != "" {
+					req.Header.Set("If-None-Match", ifNoneMatch)
+				}
+				rec := httptest.NewRecorder()
+				handler(h)(rec, req)
+				return rec
+			}
+
+			first := get(newHandlers(updatedAt), "")
+			require.Equal(t, http.StatusOK, first.Code)
+			etag := first.Header().Get("ETag")
+			require.NotEmpty(t, etag)
+			assert.Equal(t, "private, no-cache", first.Header().Get("Cache-Control"))
+			assert.Equal(t, bodyETag(first.Body.Bytes()), etag, "the ETag is the body's")
+
+			rec := get(newHandlers(updatedAt), etag)
+			assert.Equal(t, http.StatusNotModified, rec.Code)
+			assert.Empty(t, rec.Body.String())
+			assert.Equal(t, etag, rec.Header().Get("ETag"))
+
+			rec = get(newHandlers(updatedAt), `"stale", `+etag)
+			assert.Equal(t, http.StatusNotModified, rec.Code, "any listed ETag matches")
+
+			rec = get(newHandlers(updatedAt.Add(time.Second)), etag)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+			assert.NotEmpty(t, rec.Body.String())
+		})
+	}
+}
+
+func TestUpdatePreferencesIgnoresBodyUserID(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	repo.On("UpsertPreferences", mock.Anything, mock.MatchedBy(func(p *models.UserPreferences) bool {
+		return p.UserID == "user-1"
+	})).Return(nil)
+	audit := new(mocks.MockAuditService)
+	audit.On("Record", mock.Anything, mock.Anything).Return(nil)
+	svc := service.NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+	h := New(newTestApp(), svc, audit, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	body := `{"user_id":"attacker","email_enabled":false,"frequency":"daily"}`
+	rec := httptest.NewRecorder()
+	h.UpdatePreferences(rec, authedRequest(http.MethodPut, "/api/v1/profile/preferences", body, "user-1"))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp struct {
+		Data models.PreferencesResponse `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "user-1", resp.Data.UserID)
+	assert.False(t, resp.Data.EmailEnabled)
+	assert.Equal(t, "daily", resp.Data.Frequency)
+	repo.AssertExpectations(t)
+}
+
+func TestPreferenceLengthCaps(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	repo.On("UpsertPreferences", mock.Anything, mock.Anything).Return(nil)
+	audit := new(mocks.MockAuditService)
+	audit.On("Record", mock.Anything, mock.Anything).Return(nil)
+	svc := service.NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+	h := New(newTestApp(), svc, audit, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	rejected := func(t *testing.T, rec *httptest.ResponseRecorder, field string, max int) {
+		t.Helper()
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+		var resp struct {
+			Error string `json:"error"`
+			Data  struct {
+				Fields []validation.FieldError `json:"fields"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Len(t, resp.Data.Fields, 1)
+		assert.Equal(t, field, resp.Data.Fields[0].Field)
+		assert.Equal(t, max, resp.Data.Fields[0].Max)
+		assert.Contains(t, resp.Error, field+" must not exceed")
+	}
+
+	t.Run("Frequency", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		body := `{"email_enabled":false,"frequency":"` + strings.Repeat("d", models.MaxFrequencyLength+1) + `"}`
+		h.UpdatePreferences(rec, authedRequest(http.MethodPut, "/api/v1/profile/preferences", body, "user-1"))
+		rejected(t, rec, "frequency", models.MaxFrequencyLength)
+		repo.AssertNotCalled(t, "UpsertPreferences", mock.Anything, mock.Anything)
+
+		rec = httptest.NewRecorder()
+		h.UpdatePreferences(rec, authedRequest(http.MethodPut, "/api/v1/profile/preferences", `{"email_enabled":false,"frequency":"weekly"}`, "user-1"))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("NotificationEmail", func(t *testing.T) {
+		// Caps count characters, as the column does, not bytes
+		local := strings.Repeat("é", models.MaxNotificationEmailLength-len("@example.com")+1)
+		rec := httptest.NewRecorder()
+		h.UpdateNotificationEmail(rec, authedRequest(http.MethodPut, "/api/v1/preferences/notification-email", `{"email":"`+local+`@example.com"}`, "user-1"))
+		rejected(t, rec, "email", models.MaxNotificationEmailLength)
+	})
+}
+
+func TestNotificationChannels(t *testing.T) {
+	setup := func(cfg *config.Config, emailVerified bool, prefs *models.UserPreferences) (*Handlers, *mocks.MockUserRepository) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByID", mock.Anything, "user-1").
+			Return(&models.User{ID: "user-1", Email: "alice@example.com", EmailVerified: emailVerified}, nil)
+		repo.On("GetPreferences", mock.Anything, "user-1").Return(prefs, nil)
+		repo.On("UpsertPreferences", mock.Anything, mock.Anything).Return(nil)
+		audit := new(mocks.MockAuditService)
+		audit.On("Record", mock.Anything, mock.Anything).Return(nil)
+		svc := service.NewUserService(repo, new(mocks.MockSessionStore), cfg, nil, nil)
+		return New(newTestApp(), svc, audit, nil, nil, nil, nil, nil, nil, nil, nil), repo
+	}
+	channels := func(t *testing.T, h *Handlers) []models.NotificationChannel {
+		rec := httptest.NewRecorder()
+		h.GetNotificationChannels(rec, authedRequest(http.MethodGet, "/api/v1/preferences/channels", "", "user-1"))
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var resp struct {
+			Data []models.NotificationChannel `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Len(t, resp.Data, 1)
+		return resp.Data
+	}
+
+	t.Run("Configured", func(t *testing.T) {
+		h, _ := setup(&config.Config{SMTPHost: "smtp.example.com"}, false, &models.UserPreferences{
+			UserID: "user-1", EmailEnabled: true, Frequency: "daily",
+			NotificationEmail: "alerts@example.com", NotificationEmailVerified: true,
+		})
+
+		email := channels(t, h)[0]
+		assert.Equal(t, models.ChannelEmail, email.Name)
+		assert.True(t, email.Available)
+		assert.True(t, email.Enabled)
+		assert.Equal(t, "alerts@example.com", email.Address)
+		assert.True(t, email.Verified, "a notification email is only used once verified")
+	})
+
+	t.Run("UnverifiedLoginEmail", func(t *testing.T) {
+		h, _ := setup(&config.Config{SMTPHost: "smtp.example.com"}, false, nil)
+
+		email := channels(t, h)[0]
+		assert.True(t, email.Enabled, "email is on by default")
+		assert.Equal(t, "alice@example.com", email.Address)
+		assert.False(t, email.Verified)
+	})
+
+	t.Run("NotConfigured", func(t *testing.T) {
+		h, repo := setup(&config.Config{}, true, models.DefaultPreferences("user-1"))
+
+		email := channels(t, h)[0]
+		assert.False(t, email.Available)
+		assert.False(t, email.Enabled, "an unavailable channel is never reported enabled")
+
+		// Changing only the frequency sends back email_enabled as read,
+		// which is on by default
+		rec := httptest.NewRecorder()
+		h.UpdatePreferences(rec, authedRequest(http.MethodPut, "/api/v1/profile/preferences", `{"email_enabled":true,"frequency":"weekly"}`, "user-1"))
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		repo.AssertCalled(t, "UpsertPreferences", mock.Anything, mock.Anything)
+	})
+
+	t.Run("NotConfiguredCannotTurnOn", func(t *testing.T) {
+		h, repo := setup(&config.Config{}, true, &models.UserPreferences{UserID: "user-1", EmailEnabled: false, Frequency: "daily"})
+
+		rec := httptest.NewRecorder()
+		h.UpdatePreferences(rec, authedRequest(http.MethodPut, "/api/v1/profile/preferences", `{"email_enabled":true,"frequency":"daily"}`, "user-1"))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Email notifications are not available")
+		repo.AssertNotCalled(t, "UpsertPreferences", mock.Anything, mock.Anything)
+	})
+}
+
+func TestChangePasswordSessions(t *testing.T) {
+	const secret = "test-secret-that-is-at-least-32-chars"
+	hash, err := bcrypt.GenerateFromPassword([]byte("Password123!"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	// Both sessions were opened a minute ago, one per device
+	oldToken := func(t *testing.T) string {
+		iat := time.Now().Add(-time.Minute)
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+			Subject: "user-1", IssuedAt: jwt.NewNumericDate(iat), ExpiresAt: jwt.NewNumericDate(iat.Add(time.Hour)),
+		}).SignedString([]byte(secret))
+		require.NoError(t, err)
+		return token
+	}
+
+	setup := func(t *testing.T) (http.Handler, func(token string) int) {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { client.Close() })
+		sessions := repository.NewSessionStore(client)
+
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByID", mock.Anything, "user-1").Return(&models.User{ID: "user-1", Username: "alice", PasswordHash: string(hash)}, nil)
+		repo.On("UpdatePassword", mock.Anything, "user-1", mock.Anything, 0).Return(nil)
+		audit := new(mocks.MockAuditService)
+		audit.On("Record", mock.Anything, mock.Anything).Return(nil)
+
+		app := newTestApp()
+		app.Config = config.Config{App_Secret: secret, JWTExpirationHours: 1}
+		svc := service.NewUserService(repo, sessions, &app.Config, nil, nil)
+		h := New(app, svc, audit, nil, nil, nil, nil, nil, nil, nil, nil)
+		mw := middleware.New(app, sessions, nil, nil, nil)
+
+		// authorized reports how the JWT middleware treats token now
+		authorized := func(token string) int {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil)
+			req.AddCookie(&http.Cookie{Name: "jwt_token", Value: token})
+			rec := httptest.NewRecorder()
+			mw.JWT(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+			return rec.Code
+		}
+		return mw.JWT(http.HandlerFunc(h.ChangePassword)), authorized
+	}
+
+	change := func(handler http.Handler, current, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/password", strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "jwt_token", Value: current})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("LogoutOtherSessionsByDefault", func(t *testing.T) {
+		handler, authorized := setup(t)
+		current, other := oldToken(t), oldToken(t)
+
+		rec := change(handler, current, `{"current_password":"Password123!","new_password":"NewPassword456!"}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var fresh string
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == "jwt_token" {
+				fresh = c.Value
+			}
+		}
+		require.NotEmpty(t, fresh, "the current session gets a fresh cookie")
+		assert.Equal(t, http.StatusOK, authorized(fresh))
+		assert.Equal(t, http.StatusUnauthorized, authorized(other))
+		assert.Equal(t, http.StatusUnauthorized, authorized(current))
+	})
+
+	t.Run("KeepOtherSessions", func(t *testing.T) {
+		handler, authorized := setup(t)
+		current, other := oldToken(t), oldToken(t)
+
+		rec := change(handler, current, `{"current_password":"Password123!","new_password":"NewPassword456!","logout_other_sessions":false}`)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		assert.Empty(t, rec.Result().Cookies())
+		assert.Equal(t, http.StatusOK, authorized(current))
+		assert.Equal(t, http.StatusOK, authorized(other))
+	})
+}
+
+func TestProtectedUsesCachedUser(t *testing.T) {
+	repo := new(mocks.MockUserRepository)
+	repo.On("GetByID", mock.Anything, "user-1").
+		Return(&models.User{ID: "user-1", Username: "alice", Role: models.RoleAdmin}, nil)
+	svc := service.NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+	h := New(newTestApp(), svc, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	// An admin check ahead of the handler loads the profile first, as
+	// requireAdmin does on admin routes
+	handler := middleware.UserCache(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.requireAdmin(w, r, "user-1") {
+			h.Protected(w, r)
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, authedRequest(http.MethodGet, "/api/v1/protected", "", "user-1"))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	var body struct {
+		Data struct {
+			User models.User `json:"user"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "alice", body.Data.User.Username)
+	repo.AssertNumberOfCalls(t, "GetByID", 1)
+}
+
+func TestGetDigestPreview(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	digests := repository.NewDigestBuffer(client)
+
+	repo := new(mocks.MockUserRepository)
+	repo.On("GetByID", mock.Anything, "user-1").Return(&models.User{ID: "user-1", Username: "alice"}, nil)
+	repo.On("GetPreferences", mock.Anything, "user-1").
+		Return(&models.UserPreferences{UserID: "user-1", EmailEnabled: true, Frequency: "daily"}, nil)
+	svc := service.NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+	h := New(newTestApp(), svc, nil, nil, nil, nil, nil, nil, nil, digests, nil)
+
+	ctx := context.Background()
+	require.NoError(t, digests.Add(ctx, "user-1", templates.DigestItem{Title: "New login", Summary: "From Firefox"}))
+	require.NoError(t, digests.Add(ctx, "user-1", templates.DigestItem{Title: "Password changed", Link: "https://example.com/security"}))
+
+	preview := func() notification.DigestPreview {
+		rec := httptest.NewRecorder()
+		h.GetDigestPreview(rec, authedRequest(http.MethodGet, "/api/v1/preferences/digest/preview", "", "user-1"))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var body struct {
+			Data notification.DigestPreview `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body.Data
+	}
+
+	first := preview()
+	assert.Equal(t, "Your digest for today", first.Subject)
+	require.Len(t, first.Items, 2)
+	assert.Equal(t, "New login", first.Items[0].Title)
+	assert.Contains(t, first.HTML, "From Firefox")
+	assert.Contains(t, first.HTML, `<a href="https://example.com/security">Password changed</a>`)
+	assert.Contains(t, first.Text, "- New login")
+
+	// Previewing reads the buffer without draining it
+	pending, err := digests.Pending(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Len(t, pending, 2)
+	assert.Equal(t, first, preview())
+}
@@ -0,0 +1,143 @@
+package hasher
+
+import (
+	"azlo-goboiler/internal/core"
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Defaults used when the configured values are zero
+const (
+	DefaultQueueSize = 64
+	DefaultMaxWait   = 2 * time.Second
+)
+
+var (
+	queueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "bcrypt_queue_depth",
+		Help: "Password hashing operations waiting for a worker.",
+	})
+	inFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "bcrypt_in_flight",
+		Help: "Password hashing operations currently running.",
+	})
+	waitSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "bcrypt_queue_wait_seconds",
+		Help:    "Time password hashing operations spent waiting for a worker.",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	})
+	shed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "bcrypt_shed_total",
+		Help: "Password hashing operations rejected because the queue was full or the wait timed out.",
+	})
+)
+
+// Collectors returns the pool metrics, for the application's registry
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{queueDepth, inFlight, waitSeconds, shed}
+}
+
+// Pool is a core.PasswordHasher that runs at most a fixed number of bcrypt
+// operations at once. Callers beyond that wait in a bounded queue; once the
+// queue is full, or a caller has waited longer than maxWait, the operation
+// fails with core.ErrHasherBusy instead of piling more work onto the CPU.
+type Pool struct {
+	slots    chan struct{}
+	queued   atomic.Int64
+	maxQueue int64
+	maxWait  time.Duration
+	cost     int
+}
+
+// NewPool returns a pool running up to workers operations concurrently.
+// Zero values select GOMAXPROCS workers, DefaultQueueSize and DefaultMaxWait.
+func NewPool(workers, queueSize int, maxWait time.Duration) *Pool {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	if maxWait <= 0 {
+		maxWait = DefaultMaxWait
+	}
+	return &Pool{
+		slots:    make(chan struct{}, workers),
+		maxQueue: int64(queueSize),
+		maxWait:  maxWait,
+		cost:     bcrypt.DefaultCost,
+	}
+}
+
+func (p *Pool) Hash(ctx context.Context, password string) (string, error) {
+	release, err := p.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), p.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (p *Pool) Compare(ctx context.Context, hash, password string) error {
+	release, err := p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
+
+// acquire takes a worker slot, queueing if none is free
+func (p *Pool) acquire(ctx context.Context) (func(), error) {
+	select {
+	case p.slots <- struct{}{}:
+		waitSeconds.Observe(0)
+		return p.started(), nil
+	default:
+	}
+
+	if p.queued.Add(1) > p.maxQueue {
+		p.queued.Add(-1)
+		shed.Inc()
+		return nil, core.ErrHasherBusy
+	}
+	queueDepth.Inc()
+	defer func() {
+		p.queued.Add(-1)
+		queueDepth.Dec()
+	}()
+
+	start := time.Now()
+	timer := time.NewTimer(p.maxWait)
+	defer timer.Stop()
+
+	select {
+	case p.slots <- struct{}{}:
+		waitSeconds.Observe(time.Since(start).Seconds())
+		return p.started(), nil
+	case <-timer.C:
+		shed.Inc()
+		return nil, core.ErrHasherBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *Pool) started() func() {
+	inFlight.Inc()
+	return func() {
+		inFlight.Dec()
+		<-p.slots
+	}
+}
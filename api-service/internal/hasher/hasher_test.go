@@ -0,0 +1,78 @@
+package hasher
+
+import (
+	"azlo-goboiler/internal/core"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestPool(workers, queueSize int, maxWait time.Duration) *Pool {
+	p := NewPool(workers, queueSize, maxWait)
+	p.cost = bcrypt.MinCost
+	return p
+}
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("HashAndCompare", func(t *testing.T) {
+		p := newTestPool(2, 4, time.Second)
+		hash, err := p.Hash(ctx, "Password123!")
+		require.NoError(t, err)
+
+		assert.NoError(t, p.Compare(ctx, hash, "Password123!"))
+		assert.ErrorIs(t, p.Compare(ctx, hash, "wrong"), bcrypt.ErrMismatchedHashAndPassword)
+	})
+
+	t.Run("ShedsWhenQueueFull", func(t *testing.T) {
+		p := newTestPool(1, 1, time.Second)
+		release, err := p.acquire(ctx) // the only worker
+		require.NoError(t, err)
+
+		queued := make(chan error, 1)
+		go func() {
+			r, err := p.acquire(ctx)
+			if err == nil {
+				r()
+			}
+			queued <- err
+		}()
+		require.Eventually(t, func() bool { return p.queued.Load() == 1 }, time.Second, time.Millisecond)
+
+		_, err = p.acquire(ctx)
+		assert.ErrorIs(t, err, core.ErrHasherBusy)
+
+		release()
+		assert.NoError(t, <-queued, "queued caller runs once the worker frees up")
+	})
+
+	t.Run("ShedsAfterMaxWait", func(t *testing.T) {
+		p := newTestPool(1, 4, 20*time.Millisecond)
+		release, err := p.acquire(ctx)
+		require.NoError(t, err)
+		defer release()
+
+		start := time.Now()
+		err = p.Compare(ctx, "$2a$04$invalid", "x")
+		assert.ErrorIs(t, err, core.ErrHasherBusy)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Zero(t, p.queued.Load())
+	})
+
+	t.Run("HonoursContext", func(t *testing.T) {
+		p := newTestPool(1, 4, time.Minute)
+		release, err := p.acquire(ctx)
+		require.NoError(t, err)
+		defer release()
+
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err = p.Hash(cctx, "Password123!")
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
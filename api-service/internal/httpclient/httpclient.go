@@ -0,0 +1,41 @@
+// Package httpclient builds the HTTP client used for outbound calls to other
+// services (OAuth providers, webhooks), so they share timeouts and tracing.
+package httpclient
+
+import (
+	"net/http"
+	"time"
+
+	"azlo-goboiler/internal/config"
+)
+
+// RequestIDHeader carries the caller's request ID to downstream services
+const RequestIDHeader = "X-Request-ID"
+
+// New returns a client with the given timeout. When propagateRequestID is
+// set, requests built with a context from an inbound request carry its ID in
+// RequestIDHeader so logs and traces correlate across services. Callers that
+// set the header themselves are left alone.
+func New(timeout time.Duration, propagateRequestID bool) *http.Client {
+	var transport http.RoundTripper = http.DefaultTransport.(*http.Transport).Clone()
+	if propagateRequestID {
+		transport = &requestIDTransport{base: transport}
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+type requestIDTransport struct {
+	base http.RoundTripper
+}
+
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id, _ := req.Context().Value(config.RequestIDKey).(string)
+	if id == "" || req.Header.Get(RequestIDHeader) != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	// A RoundTripper must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set(RequestIDHeader, id)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,57 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"azlo-goboiler/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestIDPropagation(t *testing.T) {
+	var got string
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(RequestIDHeader)
+	}))
+	defer downstream.Close()
+
+	call := func(t *testing.T, client *http.Client, ctx context.Context, header string) *http.Request {
+		got = ""
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, downstream.URL+"/webhook", nil)
+		require.NoError(t, err)
+		if header != "" {
+			req.Header.Set(RequestIDHeader, header)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return req
+	}
+	withID := context.WithValue(context.Background(), config.RequestIDKey, "req-123")
+
+	t.Run("CarriesInboundID", func(t *testing.T) {
+		req := call(t, New(time.Second, true), withID, "")
+		assert.Equal(t, "req-123", got)
+		assert.Empty(t, req.Header.Get(RequestIDHeader), "caller's request must not be modified")
+	})
+
+	t.Run("CallerHeaderWins", func(t *testing.T) {
+		call(t, New(time.Second, true), withID, "explicit")
+		assert.Equal(t, "explicit", got)
+	})
+
+	t.Run("NoIDInContext", func(t *testing.T) {
+		call(t, New(time.Second, true), context.Background(), "")
+		assert.Empty(t, got)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		call(t, New(time.Second, false), withID, "")
+		assert.Empty(t, got)
+	})
+}
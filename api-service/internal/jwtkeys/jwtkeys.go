@@ -0,0 +1,239 @@
+// Package jwtkeys signs and verifies the API's access tokens. By default they
+// are HS256 tokens keyed with the app secret, which only this service can
+// check. With an RSA private key configured they are RS256 instead, and the
+// public keys are published as a JWKS so other services can verify tokens
+// without sharing a secret.
+//
+// Retired public keys can stay configured for verification, so the signing
+// key can be rotated without logging everyone out: sign with the new key,
+// keep the old one's public half until the last token it signed has expired.
+package jwtkeys
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync/atomic"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+)
+
+// minRSABits is the smallest RSA key accepted for signing or verification
+const minRSABits = 2048
+
+// Config selects the keys. Both fields are PEM file paths.
+type Config struct {
+	PrivateKeyPath string   // RSA private key to sign with; empty keeps HS256
+	VerifyKeyPaths []string // public keys of retired signing keys, still accepted and published
+}
+
+// JWKS is a JSON Web Key Set (RFC 7517)
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK is an RSA public key as a JSON Web Key
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// keySet is the RS256 configuration; nil means HS256
+type keySet struct {
+	signer *rsa.PrivateKey
+	kid    string
+	verify map[string]*rsa.PublicKey
+	jwks   JWKS
+}
+
+var current atomic.Pointer[keySet]
+
+// Configure loads the keys in cfg. Call it at startup, before the first
+// token is issued. With no private key it keeps HS256.
+func Configure(cfg Config) error {
+	if cfg.PrivateKeyPath == "" {
+		if len(cfg.VerifyKeyPaths) > 0 {
+			return errors.New("verification keys need a private key to sign with")
+		}
+		current.Store(nil)
+		return nil
+	}
+
+	signer, err := loadPrivateKey(cfg.PrivateKeyPath)
+	if err != nil {
+		return err
+	}
+	keys := &keySet{signer: signer, kid: thumbprint(&signer.PublicKey), verify: make(map[string]*rsa.PublicKey)}
+	keys.add(&signer.PublicKey)
+	for _, path := range cfg.VerifyKeyPaths {
+		pub, err := loadPublicKey(path)
+		if err != nil {
+			return err
+		}
+		keys.add(pub)
+	}
+	current.Store(keys)
+	return nil
+}
+
+func (k *keySet) add(pub *rsa.PublicKey) {
+	kid := thumbprint(pub)
+	if _, ok := k.verify[kid]; ok {
+		return
+	}
+	k.verify[kid] = pub
+	k.jwks.Keys = append(k.jwks.Keys, JWK{
+		Kty: "RSA", Use: "sig", Alg: RS256, Kid: kid,
+		N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes()),
+	})
+}
+
+// Algorithm returns the algorithm tokens are signed with
+func Algorithm() string {
+	if current.Load() == nil {
+		return HS256
+	}
+	return RS256
+}
+
+// Sign signs claims with the configured key, or with secret under HS256
+func Sign(claims jwt.Claims, secret string) (string, error) {
+	keys := current.Load()
+	if keys == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keys.kid
+	return token.SignedString(keys.signer)
+}
+
+// Parse verifies tokenString into claims. Only the configured algorithm is
+// accepted: under RS256 an HS256 token is rejected rather than checked
+// against a public key, and the other way round.
+func Parse(tokenString string, claims jwt.Claims, secret string, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	keys := current.Load()
+	opts = append(opts, jwt.WithValidMethods([]string{Algorithm()}))
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if keys == nil {
+				return []byte(secret), nil
+			}
+		case *jwt.SigningMethodRSA:
+			if keys != nil {
+				kid, _ := token.Header["kid"].(string)
+				if pub, ok := keys.verify[kid]; ok {
+					return pub, nil
+				}
+				return nil, fmt.Errorf("unknown signing key %q", kid)
+			}
+		}
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}, opts...)
+}
+
+// PublicKeys returns the keys tokens are verified with, and false under
+// HS256, which has none to publish
+func PublicKeys() (JWKS, bool) {
+	keys := current.Load()
+	if keys == nil {
+		return JWKS{}, false
+	}
+	return keys.jwks, true
+}
+
+func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var key *rsa.PrivateKey
+	if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		parsed, err8 := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err8 != nil {
+			return nil, fmt.Errorf("%s: not a PKCS#1 or PKCS#8 private key", path)
+		}
+		var ok bool
+		if key, ok = parsed.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("%s: not an RSA private key", path)
+		}
+	}
+	if key.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("%s: RSA key must be at least %d bits", path, minRSABits)
+	}
+	return key, nil
+}
+
+func loadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	var key *rsa.PublicKey
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		key, _ = cert.PublicKey.(*rsa.PublicKey)
+	case "RSA PUBLIC KEY":
+		if key, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		key, _ = parsed.(*rsa.PublicKey)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("%s: not an RSA public key", path)
+	}
+	if key.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("%s: RSA key must be at least %d bits", path, minRSABits)
+	}
+	return key, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return block, nil
+}
+
+// thumbprint is the key's RFC 7638 thumbprint, used as its key ID so every
+// instance derives the same ID for the same key
+func thumbprint(pub *rsa.PublicKey) string {
+	// Members in lexicographic order, no whitespace, as the RFC requires
+	canonical := fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, b64(big.NewInt(int64(pub.E)).Bytes()), b64(pub.N.Bytes()))
+	sum := sha256.Sum256([]byte(canonical))
+	return b64(sum[:])
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
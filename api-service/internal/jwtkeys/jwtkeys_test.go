@@ -0,0 +1,185 @@
+package jwtkeys
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "test-secret-key-that-is-at-least-32-chars"
+
+// writeKey saves key as PEM in a temp file, the private key as PKCS#8 and
+// the public half as PKIX
+func writeKey(t *testing.T, key *rsa.PrivateKey, public bool) string {
+	t.Helper()
+	var block *pem.Block
+	if public {
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		require.NoError(t, err)
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0o600))
+	return path
+}
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+// configure switches to cfg for the rest of the test
+func configure(t *testing.T, cfg Config) {
+	t.Helper()
+	require.NoError(t, Configure(cfg))
+	t.Cleanup(func() { Configure(Config{}) })
+}
+
+func testClaims() *jwt.RegisteredClaims {
+	return &jwt.RegisteredClaims{Subject: "user-1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+}
+
+func TestHS256ByDefault(t *testing.T) {
+	assert.Equal(t, HS256, Algorithm())
+
+	token, err := Sign(testClaims(), testSecret)
+	require.NoError(t, err)
+	claims := &jwt.RegisteredClaims{}
+	_, err = Parse(token, claims, testSecret)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.Subject)
+
+	_, err = Parse(token, &jwt.RegisteredClaims{}, "another-secret-that-is-32-chars-long")
+	assert.Error(t, err)
+
+	_, ok := PublicKeys()
+	assert.False(t, ok, "HS256 has no public keys")
+}
+
+func TestRS256(t *testing.T) {
+	key := newKey(t)
+	configure(t, Config{PrivateKeyPath: writeKey(t, key, false)})
+	assert.Equal(t, RS256, Algorithm())
+
+	token, err := Sign(testClaims(), testSecret)
+	require.NoError(t, err)
+	claims := &jwt.RegisteredClaims{}
+	parsed, err := Parse(token, claims, testSecret)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.Subject)
+
+	// A verifier holding only the JWKS can check the token
+	jwks, ok := PublicKeys()
+	require.True(t, ok)
+	require.Len(t, jwks.Keys, 1)
+	jwk := jwks.Keys[0]
+	assert.Equal(t, parsed.Header["kid"], jwk.Kid)
+	assert.Equal(t, "RSA", jwk.Kty)
+	assert.Equal(t, RS256, jwk.Alg)
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	require.NoError(t, err)
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	require.NoError(t, err)
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	_, err = jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return pub, nil })
+	assert.NoError(t, err)
+}
+
+func TestRS256RejectsOtherAlgorithms(t *testing.T) {
+	key := newKey(t)
+	configure(t, Config{PrivateKeyPath: writeKey(t, key, false)})
+
+	// A token signed with the app secret is no longer accepted
+	hs, err := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).SignedString([]byte(testSecret))
+	require.NoError(t, err)
+	_, err = Parse(hs, &jwt.RegisteredClaims{}, testSecret)
+	assert.Error(t, err)
+
+	// Nor one HMAC-signed with the published public key, the classic
+	// algorithm confusion attack
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).
+		SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	require.NoError(t, err)
+	_, err = Parse(forged, &jwt.RegisteredClaims{}, testSecret)
+	assert.Error(t, err)
+
+	// Nor an RS256 token signed by a key that isn't configured
+	other, err := jwt.NewWithClaims(jwt.SigningMethodRS256, testClaims()).SignedString(newKey(t))
+	require.NoError(t, err)
+	_, err = Parse(other, &jwt.RegisteredClaims{}, testSecret)
+	assert.Error(t, err)
+
+	// And back under HS256, RS256 tokens are refused
+	rs, err := Sign(testClaims(), testSecret)
+	require.NoError(t, err)
+	require.NoError(t, Configure(Config{}))
+	_, err = Parse(rs, &jwt.RegisteredClaims{}, testSecret)
+	assert.Error(t, err)
+}
+
+func TestRS256Rotation(t *testing.T) {
+	old, current := newKey(t), newKey(t)
+	configure(t, Config{PrivateKeyPath: writeKey(t, old, false)})
+	issued, err := Sign(testClaims(), testSecret)
+	require.NoError(t, err)
+
+	// Rotate: sign with the new key, keep verifying the old one
+	configure(t, Config{PrivateKeyPath: writeKey(t, current, false), VerifyKeyPaths: []string{writeKey(t, old, true)}})
+	_, err = Parse(issued, &jwt.RegisteredClaims{}, testSecret)
+	assert.NoError(t, err, "tokens from the retired key stay valid")
+
+	fresh, err := Sign(testClaims(), testSecret)
+	require.NoError(t, err)
+	parsed, err := Parse(fresh, &jwt.RegisteredClaims{}, testSecret)
+	require.NoError(t, err)
+
+	jwks, _ := PublicKeys()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, parsed.Header["kid"], jwks.Keys[0].Kid, "the signing key is listed first")
+
+	// Once the old key is dropped its tokens are refused
+	configure(t, Config{PrivateKeyPath: writeKey(t, current, false)})
+	_, err = Parse(issued, &jwt.RegisteredClaims{}, testSecret)
+	assert.Error(t, err)
+}
+
+func TestConfigureRejectsBadKeys(t *testing.T) {
+	t.Cleanup(func() { Configure(Config{}) })
+
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	garbage := filepath.Join(t.TempDir(), "garbage.pem")
+	require.NoError(t, os.WriteFile(garbage, []byte("not a key"), 0o600))
+
+	for name, cfg := range map[string]Config{
+		"missing file":           {PrivateKeyPath: filepath.Join(t.TempDir(), "missing.pem")},
+		"not PEM":                {PrivateKeyPath: garbage},
+		"public key to sign":     {PrivateKeyPath: writeKey(t, newKey(t), true)},
+		"weak key":               {PrivateKeyPath: writeKey(t, weak, false)},
+		"verify without signing": {VerifyKeyPaths: []string{writeKey(t, newKey(t), true)}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, Configure(cfg))
+			assert.Equal(t, HS256, Algorithm(), "a failed Configure changes nothing")
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package kvstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// RateLimitKey is where the global rate limiter keeps a client's sliding
+// window. Readers such as the self-service limits endpoint share it.
+func RateLimitKey(client string) string {
+	return "rate_limit:" + client
+}
+
+// UserRateLimitClient names a user as a global rate limiter client, for
+// RateLimitKey. With ip set the user is counted on that IP only.
+func UserRateLimitClient(userID, ip string) string {
+	if ip == "" {
+		return "user:" + userID
+	}
+	return "user:" + userID + "@" + ip
+}
+
+// AbuseKey is where the abuse limiter keeps a client's failed responses
+func AbuseKey(ip string) string {
+	return "abuse:" + ip
+}
+
+// ConcurrencyKey is where the concurrency limiter keeps a user's requests in
+// progress
+func ConcurrencyKey(userID string) string {
+	return "concurrency:user:" + userID
+}
+
+// IdempotencyKey is where the response to caller's request to path with an
+// Idempotency-Key is kept. The client's key is hashed, as it can be any string.
+func IdempotencyKey(caller, path, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "idempotency:" + caller + ":" + path + ":" + hex.EncodeToString(sum[:])
+}
+
+// MaintenanceModeKey is where the maintenance mode switch is kept, as a JSON
+// models.MaintenanceMode shared by every instance
+const MaintenanceModeKey = "maintenance:mode"
+
+// StaleResponseKey is where the last good response to a user's read of path
+// is kept, to stand in for it while the database is down. variant holds
+// whatever else picks the representation, such as the Accept header, and is
+// hashed with path.
+func StaleResponseKey(userID, path, variant string) string {
+	sum := sha256.Sum256([]byte(path + "\n" + variant))
+	return "stale:user:" + userID + ":" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,185 @@
+package kvstore
+
+import (
+	"azlo-goboiler/internal/core"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storeCase pairs a store with a way to move its clock forward
+type storeCase struct {
+	name    string
+	store   core.KVStore
+	advance func(d time.Duration)
+}
+
+func stores(t *testing.T) []storeCase {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	clock := time.Now()
+	mem := NewMemory().(*Memory)
+	mem.now = func() time.Time { return clock }
+
+	return []storeCase{
+		{name: "Redis", store: NewRedis(client), advance: mr.FastForward},
+		{name: "Memory", store: mem, advance: func(d time.Duration) { clock = clock.Add(d) }},
+	}
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+
+	for _, sc := range stores(t) {
+		t.Run(sc.name, func(t *testing.T) {
+			_, err := sc.store.Get(ctx, "missing")
+			assert.ErrorIs(t, err, core.ErrKeyNotFound)
+
+			require.NoError(t, sc.store.Set(ctx, "greeting", "hello", 0))
+			val, err := sc.store.Get(ctx, "greeting")
+			require.NoError(t, err)
+			assert.Equal(t, "hello", val)
+
+			n, err := sc.store.Incr(ctx, "counter")
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), n)
+			n, err = sc.store.Incr(ctx, "counter")
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), n)
+
+			require.NoError(t, sc.store.Expire(ctx, "counter", time.Minute))
+			require.NoError(t, sc.store.Set(ctx, "short", "x", time.Second))
+			sc.advance(2 * time.Minute)
+
+			_, err = sc.store.Get(ctx, "counter")
+			assert.ErrorIs(t, err, core.ErrKeyNotFound)
+			_, err = sc.store.Get(ctx, "short")
+			assert.ErrorIs(t, err, core.ErrKeyNotFound)
+			_, err = sc.store.Get(ctx, "greeting")
+			assert.NoError(t, err, "keys without a ttl persist")
+		})
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	ctx := context.Background()
+
+	for _, sc := range stores(t) {
+		t.Run(sc.name, func(t *testing.T) {
+			start := time.Now()
+
+			n, _, err := sc.store.SlidingWindow(ctx, "w", start, time.Minute, 2, 0)
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), n)
+
+			n, oldest, err := sc.store.SlidingWindow(ctx, "w", start.Add(30*time.Second), time.Minute, 1, 0)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), n)
+			assert.WithinDuration(t, start, oldest, time.Millisecond)
+
+			// The first two hits fall out of the window
+			n, oldest, err = sc.store.SlidingWindow(ctx, "w", start.Add(61*time.Second), time.Minute, 0, 0)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), n)
+			assert.WithinDuration(t, start.Add(30*time.Second), oldest, time.Millisecond)
+
+			n, oldest, err = sc.store.SlidingWindow(ctx, "w", start.Add(91*time.Second), time.Minute, 0, 0)
+			require.NoError(t, err)
+			assert.Zero(t, n)
+			assert.True(t, oldest.IsZero())
+		})
+	}
+}
+
+func TestSlidingWindowCap(t *testing.T) {
+	ctx := context.Background()
+
+	for _, sc := range stores(t) {
+		t.Run(sc.name, func(t *testing.T) {
+			start := time.Now()
+
+			n, _, err := sc.store.SlidingWindow(ctx, "capped", start, time.Minute, 5, 3)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), n)
+
+			// The oldest hits are the ones dropped
+			n, _, err = sc.store.SlidingWindow(ctx, "capped", start.Add(30*time.Second), time.Minute, 2, 3)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), n)
+			n, _, err = sc.store.SlidingWindow(ctx, "capped", start.Add(61*time.Second), time.Minute, 0, 3)
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), n)
+		})
+	}
+}
+
+func TestSlidingWindowCount(t *testing.T) {
+	ctx := context.Background()
+
+	for _, sc := range stores(t) {
+		t.Run(sc.name, func(t *testing.T) {
+			start := time.Now()
+
+			n, oldest, err := sc.store.SlidingWindowCount(ctx, "counted", start, time.Minute)
+			require.NoError(t, err)
+			assert.Zero(t, n)
+			assert.True(t, oldest.IsZero())
+
+			_, _, err = sc.store.SlidingWindow(ctx, "counted", start, time.Minute, 2, 0)
+			require.NoError(t, err)
+			_, _, err = sc.store.SlidingWindow(ctx, "counted", start.Add(30*time.Second), time.Minute, 1, 0)
+			require.NoError(t, err)
+
+			// Reading twice records nothing
+			for i := 0; i < 2; i++ {
+				n, oldest, err = sc.store.SlidingWindowCount(ctx, "counted", start.Add(30*time.Second), time.Minute)
+				require.NoError(t, err)
+				assert.Equal(t, int64(3), n)
+				assert.WithinDuration(t, start, oldest, time.Millisecond)
+			}
+
+			n, oldest, err = sc.store.SlidingWindowCount(ctx, "counted", start.Add(61*time.Second), time.Minute)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), n)
+			assert.WithinDuration(t, start.Add(30*time.Second), oldest, time.Millisecond)
+		})
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	ctx := context.Background()
+
+	for _, sc := range stores(t) {
+		t.Run(sc.name, func(t *testing.T) {
+			for _, holder := range []string{"a", "b"} {
+				ok, err := sc.store.Acquire(ctx, "slots", holder, 2, time.Minute)
+				require.NoError(t, err)
+				assert.True(t, ok, holder)
+			}
+			ok, err := sc.store.Acquire(ctx, "slots", "c", 2, time.Minute)
+			require.NoError(t, err)
+			assert.False(t, ok, "both slots are held")
+
+			require.NoError(t, sc.store.Release(ctx, "slots", "a"))
+			ok, err = sc.store.Acquire(ctx, "slots", "c", 2, time.Minute)
+			require.NoError(t, err)
+			assert.True(t, ok, "a released its slot")
+
+			// Slots nobody releases free themselves after the ttl
+			sc.advance(2 * time.Minute)
+			ok, err = sc.store.Acquire(ctx, "slots", "d", 2, time.Minute)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			ok, err = sc.store.Acquire(ctx, "slots", "e", 2, time.Minute)
+			require.NoError(t, err)
+			assert.True(t, ok)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+// File: internal/kvstore/memory.go
+package kvstore
+
+import (
+	"azlo-goboiler/internal/core"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many writes pass between sweeps of expired keys
+const sweepEvery = 1024
+
+// Memory is an in-process core.KVStore. State is lost on restart and not
+// shared between instances, so it suits tests and single-instance deployments.
+type Memory struct {
+	mu     sync.Mutex
+	values map[string]*memoryEntry
+	writes int
+	now    func() time.Time
+}
+
+type memoryEntry struct {
+	value     string
+	hits      []time.Time          // sliding window entries, oldest first
+	leases    map[string]time.Time // Acquire slots by holder, with their expiry
+	expiresAt time.Time            // zero means no expiry
+}
+
+func NewMemory() core.KVStore {
+	return &Memory{values: make(map[string]*memoryEntry), now: time.Now}
+}
+
+func (s *Memory) Get(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entry(key)
+	if e == nil {
+		return "", core.ErrKeyNotFound
+	}
+	return e.value, nil
+}
+
+func (s *Memory) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := &memoryEntry{value: value}
+	if ttl > 0 {
+		e.expiresAt = s.now().Add(ttl)
+	}
+	s.store(key, e)
+	return nil
+}
+
+func (s *Memory) Incr(ctx context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entry(key)
+	if e == nil {
+		e = &memoryEntry{value: "0"}
+		s.store(key, e)
+	}
+	n, err := strconv.ParseInt(e.value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	n++
+	e.value = strconv.FormatInt(n, 10)
+	return n, nil
+}
+
+func (s *Memory) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e := s.entry(key); e != nil {
+		e.expiresAt = s.now().Add(ttl)
+	}
+	return nil
+}
+
+func (s *Memory) SlidingWindow(ctx context.Context, key string, now time.Time, window time.Duration, hits, maxHits int) (int64, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entry(key)
+	if e == nil {
+		e = &memoryEntry{}
+		s.store(key, e)
+	}
+
+	cutoff := now.Add(-window)
+	keep := 0
+	for keep < len(e.hits) && !e.hits[keep].After(cutoff) {
+		keep++
+	}
+	e.hits = e.hits[keep:]
+	for i := 0; i < hits; i++ {
+		e.hits = append(e.hits, now)
+	}
+	if maxHits > 0 && len(e.hits) > maxHits {
+		e.hits = e.hits[len(e.hits)-maxHits:]
+	}
+	e.expiresAt = now.Add(window * 2)
+	if len(e.hits) == 0 {
+		return 0, time.Time{}, nil
+	}
+	return int64(len(e.hits)), e.hits[0], nil
+}
+
+func (s *Memory) SlidingWindowCount(ctx context.Context, key string, now time.Time, window time.Duration) (int64, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entry(key)
+	if e == nil {
+		return 0, time.Time{}, nil
+	}
+	cutoff := now.Add(-window)
+	var n int64
+	var oldest time.Time
+	for _, hit := range e.hits {
+		if hit.After(cutoff) {
+			if n == 0 {
+				oldest = hit
+			}
+			n++
+		}
+	}
+	return n, oldest, nil
+}
+
+func (s *Memory) Acquire(ctx context.Context, key, holder string, limit int, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	e := s.entry(key)
+	if e == nil {
+		e = &memoryEntry{}
+		s.store(key, e)
+	}
+	if e.leases == nil {
+		e.leases = make(map[string]time.Time)
+	}
+	for h, expiresAt := range e.leases {
+		if !now.Before(expiresAt) {
+			delete(e.leases, h)
+		}
+	}
+	if len(e.leases) >= limit {
+		return false, nil
+	}
+	e.leases[holder] = now.Add(ttl)
+	e.expiresAt = now.Add(ttl)
+	return true, nil
+}
+
+func (s *Memory) Release(ctx context.Context, key, holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e := s.entry(key); e != nil {
+		delete(e.leases, holder)
+	}
+	return nil
+}
+
+// entry returns the live entry for key, dropping it if it has expired.
+// Callers hold s.mu.
+func (s *Memory) entry(key string) *memoryEntry {
+	e, ok := s.values[key]
+	if !ok {
+		return nil
+	}
+	if !e.expiresAt.IsZero() && !s.now().Before(e.expiresAt) {
+		delete(s.values, key)
+		return nil
+	}
+	return e
+}
+
+// store saves e and periodically sweeps expired keys so idle clients don't
+// accumulate. Callers hold s.mu.
+func (s *Memory) store(key string, e *memoryEntry) {
+	s.values[key] = e
+	s.writes++
+	if s.writes%sweepEvery != 0 {
+		return
+	}
+	now := s.now()
+	for k, v := range s.values {
+		if !v.expiresAt.IsZero() && !now.Before(v.expiresAt) {
+			delete(s.values, k)
+		}
+	}
+}
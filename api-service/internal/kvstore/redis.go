@@ -0,0 +1,141 @@
+// File: internal/kvstore/redis.go
+package kvstore
+
+import (
+	"azlo-goboiler/internal/core"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// slidingWindowScript trims the window, records the new hits and counts what
+// is left in one atomic round-trip. It returns the count and the oldest
+// hit's score, 0 when empty. ARGV: now (ms), window (ms), hits, member
+// prefix, max members (0 for no cap).
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local hits = tonumber(ARGV[3])
+local max = tonumber(ARGV[5])
+redis.call('ZREMRANGEBYSCORE', key, 0, now - window)
+for i = 1, hits do
+	redis.call('ZADD', key, now, ARGV[4] .. ':' .. i)
+end
+if max > 0 then
+	redis.call('ZREMRANGEBYRANK', key, 0, -(max + 1))
+end
+redis.call('PEXPIRE', key, window * 2)
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+return {redis.call('ZCARD', key), tonumber(oldest[2]) or 0}
+`)
+
+// slidingWindowCountScript counts the hits after ARGV[1] (ms) and returns
+// the count and the oldest of them, like slidingWindowScript, without
+// changing anything
+var slidingWindowCountScript = redis.NewScript(`
+local hits = redis.call('ZRANGEBYSCORE', KEYS[1], '(' .. ARGV[1], '+inf', 'WITHSCORES', 'LIMIT', 0, 1)
+return {redis.call('ZCOUNT', KEYS[1], '(' .. ARGV[1], '+inf'), tonumber(hits[2]) or 0}
+`)
+
+// acquireScript drops expired slots, then takes one for the holder if any
+// are free. Slots are members scored by their expiry. ARGV: now (ms), ttl
+// (ms), limit, holder.
+var acquireScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', key, 0, now)
+if redis.call('ZCARD', key) >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('ZADD', key, now + ttl, ARGV[4])
+redis.call('PEXPIRE', key, ttl)
+return 1
+`)
+
+// Redis is a core.KVStore backed by Redis or any server speaking its
+// protocol with Lua scripting (KeyDB, Dragonfly, ...)
+type Redis struct {
+	client *redis.Client
+	// instance tells this store's window members from other instances'
+	instance string
+	seq      uint64
+}
+
+// NewRedis returns a store on client. Each store gets a random instance ID;
+// should crypto/rand fail, the clock stands in, which still tells apart
+// instances that didn't start in the same nanosecond.
+func NewRedis(client *redis.Client) core.KVStore {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		binary.BigEndian.PutUint64(id, uint64(time.Now().UnixNano()))
+	}
+	return &Redis{client: client, instance: hex.EncodeToString(id)}
+}
+
+func (s *Redis) Get(ctx context.Context, key string) (string, error) {
+	val, err := s.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", core.ErrKeyNotFound
+	}
+	return val, err
+}
+
+func (s *Redis) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return s.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (s *Redis) Incr(ctx context.Context, key string) (int64, error) {
+	return s.client.Incr(ctx, key).Result()
+}
+
+func (s *Redis) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return s.client.Expire(ctx, key, ttl).Err()
+}
+
+func (s *Redis) SlidingWindowCount(ctx context.Context, key string, now time.Time, window time.Duration) (int64, time.Time, error) {
+	// Hits at exactly now-window are trimmed by SlidingWindow, so exclude them
+	return windowResult(slidingWindowCountScript.Run(ctx, s.client, []string{key}, now.Add(-window).UnixMilli()))
+}
+
+func (s *Redis) SlidingWindow(ctx context.Context, key string, now time.Time, window time.Duration, hits, maxHits int) (int64, time.Time, error) {
+	// Members must be unique or hits landing in the same instant collapse,
+	// on this instance or any other sharing the server
+	member := fmt.Sprintf("%d-%s-%d", now.UnixNano(), s.instance, atomic.AddUint64(&s.seq, 1))
+	return windowResult(slidingWindowScript.Run(ctx, s.client, []string{key},
+		now.UnixMilli(), window.Milliseconds(), hits, member, maxHits))
+}
+
+// windowResult reads the count and oldest score (ms) the window scripts return
+func windowResult(cmd *redis.Cmd) (int64, time.Time, error) {
+	values, err := cmd.Int64Slice()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if len(values) != 2 {
+		return 0, time.Time{}, fmt.Errorf("sliding window script returned %d values", len(values))
+	}
+	var oldest time.Time
+	if values[1] > 0 {
+		oldest = time.UnixMilli(values[1])
+	}
+	return values[0], oldest, nil
+}
+
+func (s *Redis) Acquire(ctx context.Context, key, holder string, limit int, ttl time.Duration) (bool, error) {
+	n, err := acquireScript.Run(ctx, s.client, []string{key},
+		time.Now().UnixMilli(), ttl.Milliseconds(), limit, holder).Int64()
+	return n == 1, err
+}
+
+func (s *Redis) Release(ctx context.Context, key, holder string) error {
+	return s.client.ZRem(ctx, key, holder).Err()
+}
@@ -0,0 +1,101 @@
+// File: internal/logging/logging.go
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"azlo-goboiler/internal/config"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Loggers holds the application and access loggers built from configuration,
+// along with any log files they keep open.
+type Loggers struct {
+	App    zerolog.Logger
+	Access zerolog.Logger
+
+	closers []io.Closer
+}
+
+// New builds both loggers from cfg. The access log shares the app log
+// destination unless ACCESS_LOG_OUTPUT is set.
+func New(cfg *config.Config) (*Loggers, error) {
+	if cfg.IsDevelopment() {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	}
+
+	l := &Loggers{}
+
+	appWriter, err := l.writer(cfg, cfg.Log.Output, cfg.Log.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	l.App = newLogger(appWriter)
+	l.Access = l.App
+
+	if cfg.Log.AccessOutput != "" {
+		accessWriter, err := l.writer(cfg, cfg.Log.AccessOutput, cfg.Log.AccessFilePath)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.Access = newLogger(accessWriter)
+	}
+
+	return l, nil
+}
+
+// Close flushes and closes any log files
+func (l *Loggers) Close() error {
+	var errs []error
+	for _, c := range l.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (l *Loggers) writer(cfg *config.Config, output, path string) (io.Writer, error) {
+	switch output {
+	case "", "stderr":
+		return consoleOr(cfg, os.Stderr), nil
+	case "stdout":
+		return consoleOr(cfg, os.Stdout), nil
+	case "file":
+		if path == "" {
+			return nil, errors.New("log file path is required for file output")
+		}
+		f := &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    cfg.Log.MaxSizeMB,
+			MaxAge:     cfg.Log.MaxAgeDays,
+			MaxBackups: cfg.Log.MaxBackups,
+			Compress:   cfg.Log.Compress,
+		}
+		l.closers = append(l.closers, f)
+		return f, nil
+	default:
+		return nil, fmt.Errorf("unknown log output %q", output)
+	}
+}
+
+// consoleOr uses the human-readable console format for terminal output in development
+func consoleOr(cfg *config.Config, out *os.File) io.Writer {
+	if cfg.IsDevelopment() {
+		return zerolog.ConsoleWriter{Out: out}
+	}
+	return out
+}
+
+func newLogger(w io.Writer) zerolog.Logger {
+	return zerolog.New(w).With().
+		Timestamp().
+		Caller().
+		Logger()
+}
@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"azlo-goboiler/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFileOutput(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		App_Env: "production",
+		Log: config.LogConfig{
+			Output:         "file",
+			FilePath:       filepath.Join(dir, "app.log"),
+			AccessOutput:   "file",
+			AccessFilePath: filepath.Join(dir, "access.log"),
+			MaxSizeMB:      1,
+		},
+	}
+
+	loggers, err := New(cfg)
+	require.NoError(t, err)
+
+	loggers.App.Info().Msg("application started")
+	loggers.Access.Info().Str("path", "/health").Msg("Request processed")
+	require.NoError(t, loggers.Close())
+
+	app, err := os.ReadFile(filepath.Join(dir, "app.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(app), "application started")
+	assert.NotContains(t, string(app), "Request processed")
+
+	access, err := os.ReadFile(filepath.Join(dir, "access.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(access), `"path":"/health"`)
+	assert.NotContains(t, string(access), "application started")
+}
+
+func TestNewRejectsUnknownOutput(t *testing.T) {
+	_, err := New(&config.Config{Log: config.LogConfig{Output: "syslog"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/kvstore"
+
+	"github.com/rs/zerolog"
+)
+
+// AbuseLimiter counts a client's failed responses, separately from the
+// request rate, and refuses the client once it has too many in a window. A
+// heavy client whose requests succeed is never held back by it, while one
+// probing with bad credentials or unknown paths is, at any request rate.
+type AbuseLimiter struct {
+	store  core.KVStore
+	logger zerolog.Logger
+	limit  int
+	window time.Duration
+	now    func() time.Time
+}
+
+func NewAbuseLimiter(store core.KVStore, logger zerolog.Logger, limit int, window time.Duration) *AbuseLimiter {
+	return &AbuseLimiter{store: store, logger: logger, limit: limit, window: window, now: time.Now}
+}
+
+// Blocked reports whether ip has used up its failures. It only reads the
+// window; Record adds to it once the response is known. Like the rate
+// limiter it fails open on store errors.
+func (al *AbuseLimiter) Blocked(ip string) bool {
+	count, _, err := al.store.SlidingWindowCount(context.Background(), kvstore.AbuseKey(ip), al.now(), al.window)
+	if err != nil {
+		rateLimitDecisions.WithLabelValues(limiterAbuse, rateLimitFailOpen).Inc()
+		al.logger.Warn().Err(err).Msg("Abuse limiter store failed, allowing request")
+		return false
+	}
+	if count >= int64(al.limit) {
+		rateLimitDecisions.WithLabelValues(limiterAbuse, rateLimitLimited).Inc()
+		return true
+	}
+	rateLimitDecisions.WithLabelValues(limiterAbuse, rateLimitAllowed).Inc()
+	return false
+}
+
+// Record counts a failed response against ip
+func (al *AbuseLimiter) Record(ip string) {
+	// Nothing past the limit is ever read, so keep no more than that
+	if _, _, err := al.store.SlidingWindow(context.Background(), kvstore.AbuseKey(ip), al.now(), al.window, 1, al.limit); err != nil {
+		al.logger.Warn().Err(err).Msg("Abuse limiter store failed, failure not counted")
+	}
+}
+
+// countsAsAbuse reports whether a response status is the client's fault.
+// 5xx are ours, and 429 is the limiters' own answer: counting it would keep
+// a client that merely outran RATE_LIMIT locked out as long as it retries.
+func countsAsAbuse(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusTooManyRequests
+}
+
+// AbuseLimit refuses clients with ABUSE_LIMIT failed responses within
+// ABUSE_WINDOW_SECONDS. Failures are counted after the handler has run, so
+// the request that reaches the limit is still served; the next is refused.
+// With ABUSE_LIMIT unset it is a no-op.
+func (mw *Middleware) AbuseLimit(next http.Handler) http.Handler {
+	if mw.app.Config.AbuseLimit <= 0 {
+		return next
+	}
+	limiter := NewAbuseLimiter(mw.kv, mw.app.Logger, mw.app.Config.AbuseLimit, mw.app.Config.GetAbuseWindow())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := getRequestID(r.Context())
+		ip := getClientIP(r)
+
+		if limiter.Blocked(ip) {
+			mw.app.Logger.Warn().
+				Str("request_id", requestID).
+				Str("ip", ip).
+				Msg("Abuse limit exceeded")
+			mw.writeError(w, r, http.StatusTooManyRequests, "Too many failed requests", requestID)
+			return
+		}
+
+		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(wrapped, r)
+
+		if countsAsAbuse(wrapped.statusCode) {
+			limiter.Record(ip)
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// bodyLimitKey holds the bodyLimit MaxBodyBytes set on a request
+type bodyLimitKey struct{}
+
+// bodyLimit is a request body before and after MaxBodyBytes wrapped it
+type bodyLimit struct {
+	original io.ReadCloser
+	limited  io.ReadCloser
+}
+
+// MaxBodyBytes limits request bodies to limit bytes with http.MaxBytesReader.
+// Applied globally it sets the MAX_BODY_BYTES default; applied again on a
+// route it replaces that limit for the route, larger or smaller, as long as
+// nothing has replaced the body in between. A body over the limit fails to
+// read with an *http.MaxBytesError, which the handlers' JSON decoding answers
+// with 413.
+func (mw *Middleware) MaxBodyBytes(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body := r.Body
+			if outer, ok := r.Context().Value(bodyLimitKey{}).(bodyLimit); ok && outer.limited == r.Body {
+				body = outer.original
+			}
+			limited := http.MaxBytesReader(w, body, limit)
+			r = r.WithContext(context.WithValue(r.Context(), bodyLimitKey{}, bodyLimit{original: body, limited: limited}))
+			r.Body = limited
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Content codings Compression can apply, in order of preference
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+	zlibWriters = sync.Pool{New: func() interface{} { return zlib.NewWriter(io.Discard) }}
+)
+
+// incompressibleTypes are already compressed, or streamed, so encoding them
+// again costs CPU for nothing
+var incompressibleTypes = map[string]bool{
+	"application/gzip":            true,
+	"application/zip":             true,
+	"application/x-gzip":          true,
+	"application/x-7z-compressed": true,
+	"application/pdf":             true,
+	"application/octet-stream":    true,
+	"text/event-stream":           true,
+	"font/woff":                   true,
+	"font/woff2":                  true,
+}
+
+// compressible reports whether a response of contentType is worth encoding
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if incompressibleTypes[mediaType] {
+		return false
+	}
+	for _, prefix := range []string{"image/", "video/", "audio/"} {
+		// SVG is text and compresses well
+		if strings.HasPrefix(mediaType, prefix) && mediaType != "image/svg+xml" {
+			return false
+		}
+	}
+	return true
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header, or
+// "" when the client accepts neither. Higher q wins; gzip wins ties.
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	wildcard := -1.0
+	seen := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				q = parsed
+			}
+		}
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+		seen[name] = q
+	}
+	for _, encoding := range []string{encodingGzip, encodingDeflate} {
+		q, ok := seen[encoding]
+		if !ok {
+			q = max(wildcard, 0)
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressWriter holds the start of a response back until it knows whether
+// to encode it: once minSize bytes are written, or the handler finishes
+type compressWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided || cw.status != 0 {
+		// Superfluous; the status is already set
+		return
+	}
+	if code < http.StatusOK {
+		// Informational responses, such as 103 Early Hints, go out as is
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.status = code
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.minSize {
+			return len(b), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// decide sends the headers, encoded when large says the body is big enough
+// and the response is a kind worth encoding, then writes what was held back
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	status := cw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	if large && cw.r.Method != http.MethodHead && status != http.StatusNoContent && status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" && len(h.Values("Set-Cookie")) == 0 && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		// The encoded body is a different representation
+		if etag := h.Get(ETagHeader); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set(ETagHeader, "W/"+etag)
+		}
+		if cw.encoding == encodingGzip {
+			gz := gzipWriters.Get().(*gzip.Writer)
+			gz.Reset(cw.ResponseWriter)
+			cw.encoder = gz
+		} else {
+			zw := zlibWriters.Get().(*zlib.Writer)
+			zw.Reset(cw.ResponseWriter)
+			cw.encoder = zw
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(status)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends what is held back, encoded if the response qualifies at its
+// current size, so streaming responses keep working
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(len(cw.buf) >= cw.minSize)
+	}
+	if gz, ok := cw.encoder.(*gzip.Writer); ok {
+		gz.Flush()
+	} else if zw, ok := cw.encoder.(*zlib.Writer); ok {
+		zw.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close ends the response: a body that stayed under minSize goes out as is
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			// Nothing was written; leave the implicit 200 to the server
+			return
+		}
+		cw.decide(false)
+	}
+	switch encoder := cw.encoder.(type) {
+	case *gzip.Writer:
+		encoder.Close()
+		gzipWriters.Put(encoder)
+	case *zlib.Writer:
+		encoder.Close()
+		zlibWriters.Put(encoder)
+	}
+}
+
+// Compression encodes responses of at least minSize bytes with gzip or
+// deflate, whichever the client's Accept-Encoding prefers. Smaller bodies,
+// already compressed types, HEAD requests and responses a handler has
+// encoded itself are sent as they are. Every response gets
+// "Vary: Accept-Encoding", since any of them could have been encoded.
+// It buffers at most minSize bytes before deciding.
+//
+// /auth responses and any response setting a cookie carry tokens next to
+// what the request sent, so they are never encoded: the compressed length
+// would let an attacker guess the token a byte at a time (BREACH).
+func Compression(minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/auth" || strings.HasPrefix(r.URL.Path, "/auth/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, r: r, encoding: encoding, minSize: minSize}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
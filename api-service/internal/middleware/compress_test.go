@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                          "",
+		"gzip":                      "gzip",
+		"deflate, gzip":             "gzip",
+		"gzip;q=0.5, deflate":       "deflate",
+		"br, deflate;q=0.8":         "deflate",
+		"gzip;q=0":                  "",
+		"identity":                  "",
+		"*":                         "gzip",
+		"*;q=0.1, gzip;q=0":         "deflate",
+		"GZIP; q=1.0, deflate;q=.9": "gzip",
+	} {
+		assert.Equal(t, want, negotiateEncoding(header), header)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	large := strings.Repeat(`{"id":"user","username":"alice"},`, 100)
+	serve := func(t *testing.T, acceptEncoding string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rec := httptest.NewRecorder()
+		Compression(1024)(handler).ServeHTTP(rec, req)
+		return rec
+	}
+	jsonBody := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", `"v1"`)
+			// Written in pieces, as an encoder would
+			for len(body) > 0 {
+				n := min(len(body), 300)
+				w.Write([]byte(body[:n]))
+				body = body[n:]
+			}
+		}
+	}
+
+	t.Run("Gzip", func(t *testing.T) {
+		rec := serve(t, "gzip, deflate", jsonBody(large))
+		require.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+		assert.Equal(t, `W/"v1"`, rec.Header().Get("ETag"), "the encoded body is a weak match")
+		assert.Less(t, rec.Body.Len(), len(large))
+
+		zr, err := gzip.NewReader(rec.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, large, string(body))
+	})
+
+	t.Run("Deflate", func(t *testing.T) {
+		rec := serve(t, "gzip;q=0.5, deflate", jsonBody(large))
+		require.Equal(t, "deflate", rec.Header().Get("Content-Encoding"))
+
+		zr, err := zlib.NewReader(rec.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, large, string(body))
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		rec := serve(t, "gzip", jsonBody(`{"ok":true}`))
+		assert.Empty(t, rec.Header().Get("Content-Encoding"), "small bodies")
+		assert.Equal(t, `{"ok":true}`, rec.Body.String())
+		assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+
+		rec = serve(t, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(bytes.Repeat([]byte{0}, 4096))
+		})
+		assert.Empty(t, rec.Header().Get("Content-Encoding"), "already compressed types")
+		assert.Equal(t, 4096, rec.Body.Len())
+
+		rec = serve(t, "", jsonBody(large))
+		assert.Empty(t, rec.Header().Get("Content-Encoding"), "clients that don't ask")
+		assert.Equal(t, large, rec.Body.String())
+		assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+	})
+
+	t.Run("SecretsNeverEncoded", func(t *testing.T) {
+		// Token-bearing responses stay uncompressed, so their length can't
+		// leak the token (BREACH)
+		rec := serve(t, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+			jsonBody(large)(w, r)
+		})
+		assert.Empty(t, rec.Header().Get("Content-Encoding"), "responses setting a cookie")
+		assert.Equal(t, large, rec.Body.String())
+
+		req := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec = httptest.NewRecorder()
+		Compression(1024)(jsonBody(large)).ServeHTTP(rec, req)
+		assert.Empty(t, rec.Header().Get("Content-Encoding"), "/auth responses")
+		assert.Equal(t, large, rec.Body.String())
+	})
+
+	t.Run("StatusAndLogging", func(t *testing.T) {
+		app, _ := newTestApp(t)
+		var logs bytes.Buffer
+		app.AccessLogger = zerolog.New(&logs)
+		mw := New(app, nil, nil, nil, nil)
+
+		handler := mw.Logging(Compression(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, large)
+		})))
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+		assert.EqualValues(t, http.StatusCreated, entry["status"])
+		assert.EqualValues(t, rec.Body.Len(), entry["response_size"], "the log counts the bytes sent")
+	})
+}
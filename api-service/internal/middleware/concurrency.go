@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/kvstore"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// ConcurrencyLimiter caps how many requests each user has in progress at
+// once, across every instance sharing its core.KVStore. It catches what a
+// per-minute rate can't: a few slow requests held open at the same time.
+type ConcurrencyLimiter struct {
+	store  core.KVStore
+	logger zerolog.Logger
+	limit  int
+	lease  time.Duration
+}
+
+// NewConcurrencyLimiter allows limit requests per user at a time. A slot
+// whose request never finishes, say because its instance died, is freed
+// after lease.
+func NewConcurrencyLimiter(store core.KVStore, logger zerolog.Logger, limit int, lease time.Duration) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{store: store, logger: logger, limit: limit, lease: lease}
+}
+
+// Acquire takes a slot for one of userID's requests. ok is false when all
+// of the user's slots are taken; otherwise release must be called once the
+// request is done. Like the rate limiter it fails open on store errors.
+func (cl *ConcurrencyLimiter) Acquire(userID string) (release func(), ok bool) {
+	key := kvstore.ConcurrencyKey(userID)
+	holder := uuid.New().String()
+
+	acquired, err := cl.store.Acquire(context.Background(), key, holder, cl.limit, cl.lease)
+	if err != nil {
+		rateLimitDecisions.WithLabelValues(limiterConcurrency, rateLimitFailOpen).Inc()
+		cl.logger.Warn().Err(err).Msg("Concurrency limiter store failed, allowing request")
+		return func() {}, true
+	}
+	if !acquired {
+		rateLimitDecisions.WithLabelValues(limiterConcurrency, rateLimitLimited).Inc()
+		return nil, false
+	}
+	rateLimitDecisions.WithLabelValues(limiterConcurrency, rateLimitAllowed).Inc()
+	return func() {
+		if err := cl.store.Release(context.Background(), key, holder); err != nil {
+			// The lease frees the slot in the end
+			cl.logger.Warn().Err(err).Msg("Concurrency limiter store failed, slot not released")
+		}
+	}, true
+}
+
+// UserConcurrencyLimit refuses an authenticated user's request with 429
+// while USER_CONCURRENCY_LIMIT of their requests are already in progress.
+// It goes after Authenticate; requests without a user pass through. A slot
+// is held until the handler returns, even past REQUEST_TIMEOUT_SECONDS, as
+// the work goes on after the client gets its 408; a slot that is never
+// released lapses after twice the timeout. With USER_CONCURRENCY_LIMIT
+// unset it is a no-op.
+func (mw *Middleware) UserConcurrencyLimit(next http.Handler) http.Handler {
+	if mw.app.Config.UserConcurrencyLimit <= 0 {
+		return next
+	}
+	limiter := NewConcurrencyLimiter(mw.kv, mw.app.Logger, mw.app.Config.UserConcurrencyLimit, 2*mw.app.Config.GetRequestTimeout())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(config.UserIDKey).(string)
+		if userID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		release, ok := limiter.Acquire(userID)
+		if !ok {
+			requestID := getRequestID(r.Context())
+			mw.app.Logger.Warn().
+				Str("request_id", requestID).
+				Str("user_id", userID).
+				Msg("Concurrent request limit exceeded")
+			w.Header().Set(RetryAfterHeader, "1")
+			mw.writeError(w, r, http.StatusTooManyRequests, "Too many concurrent requests", requestID)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/models"
+)
+
+// SetSessionCookies stores the access token from resp in the auth cookie and,
+// when one was issued, the refresh token in the refresh cookie
+func SetSessionCookies(w http.ResponseWriter, cfg *config.Config, resp *models.LoginResponse) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     config.AuthCookieName,
+		Value:    resp.Token,
+		Expires:  time.Unix(resp.ExpiresAt, 0),
+		HttpOnly: true,                    // Prevents JS access
+		Secure:   true,                    // Only send over HTTPS
+		Path:     "/",                     // Available to entire site
+		SameSite: cfg.GetCookieSameSite(), // Lax unless COOKIE_SAMESITE says otherwise
+	})
+
+	if resp.RefreshToken != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     config.RefreshCookieName,
+			Value:    resp.RefreshToken,
+			Expires:  time.Now().Add(cfg.GetRefreshExpiration()),
+			HttpOnly: true,
+			Secure:   true,
+			Path:     "/",
+			SameSite: cfg.GetCookieSameSite(),
+		})
+	}
+}
+
+// ClearSessionCookies expires the auth and refresh cookies
+func ClearSessionCookies(w http.ResponseWriter, cfg *config.Config) {
+	for _, name := range []string{config.AuthCookieName, config.RefreshCookieName} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Expires:  time.Now().Add(-time.Hour), // Expire in the past
+			HttpOnly: true,
+			Secure:   true,
+			Path:     "/",
+			SameSite: cfg.GetCookieSameSite(),
+		})
+	}
+}
+
+// SessionToken returns the access token a request carries: the auth cookie,
+// or a Bearer header for clients using header mode
+func SessionToken(r *http.Request) string {
+	if cookie, err := r.Cookie(config.AuthCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	return bearerToken(r)
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+//go:embed errorpage.html
+var defaultErrorPageSource string
+
+// errorPage renders errors for browsers; see ConfigureErrorPage
+var errorPage atomic.Pointer[template.Template]
+
+func init() {
+	errorPage.Store(template.Must(template.New("error").Parse(defaultErrorPageSource)))
+}
+
+// ErrorPageData is what the error page template is executed with
+type ErrorPageData struct {
+	Status    int
+	Title     string // the status text, e.g. "Service Unavailable"
+	Code      string // as in the JSON body, e.g. "service_unavailable"
+	Message   string
+	RequestID string
+}
+
+// ConfigureErrorPage replaces the built-in error page with the html/template
+// file at path. An empty path keeps the built-in page. Call it at startup.
+func ConfigureErrorPage(path string) error {
+	if path == "" {
+		return nil
+	}
+	page, err := template.ParseFiles(path)
+	if err != nil {
+		return fmt.Errorf("parse error page template: %w", err)
+	}
+	errorPage.Store(page)
+	return nil
+}
+
+// prefersHTML reports whether the request ranks text/html above JSON in its
+// Accept header. Only an explicit text/html or text/* counts for HTML while
+// */* counts for JSON, so fetch and curl defaults keep getting JSON and only
+// browser navigations get the page.
+func prefersHTML(r *http.Request) bool {
+	if r == nil {
+		return false
+	}
+	htmlQ, jsonQ := 0.0, 0.0
+	for _, value := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(value, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if raw, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(raw, 64); err != nil {
+					continue
+				}
+			}
+			switch {
+			case mediaType == "text/html" || mediaType == "text/*":
+				htmlQ = max(htmlQ, q)
+			case mediaType == "application/json" || mediaType == "application/*" || mediaType == "*/*" ||
+				strings.HasSuffix(mediaType, "+json"):
+				jsonQ = max(jsonQ, q)
+			}
+		}
+	}
+	return htmlQ > jsonQ
+}
+
+// writeErrorPage renders the error page. It reports false, having written
+// nothing, if the template fails so the caller can fall back to JSON.
+func writeErrorPage(w http.ResponseWriter, status int, message, requestID string) bool {
+	var buf bytes.Buffer
+	err := errorPage.Load().Execute(&buf, ErrorPageData{
+		Status:    status,
+		Title:     http.StatusText(status),
+		Code:      ErrorCode(status),
+		Message:   message,
+		RequestID: requestID,
+	})
+	if err != nil {
+		return false
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+	return true
+}
+
+// varyAccept marks a response as depending on the Accept header, once
+func varyAccept(h http.Header) {
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), "Accept") {
+				return
+			}
+		}
+	}
+	h.Add("Vary", "Accept")
+}
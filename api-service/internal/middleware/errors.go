@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"azlo-goboiler/internal/config"
+)
+
+// ProblemContentType is the RFC 7807 media type used with ERROR_FORMAT=problem
+const ProblemContentType = "application/problem+json"
+
+// problemTypeBase prefixes the error code to form a problem's type URI. The
+// URIs identify the error class and are stable; they are not meant to resolve.
+const problemTypeBase = "urn:go-api-boilerplate:problem:"
+
+// Problem is an RFC 7807 problem details body. Data carries the same
+// diagnostic detail the envelope's "data" field would.
+type Problem struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Detail   string      `json:"detail"`
+	Instance string      `json:"instance"`
+	Data     interface{} `json:"data,omitempty"`
+}
+
+// ErrorCode is the machine-readable form of an HTTP status, e.g. "not_found"
+func ErrorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// ProblemType is the stable type URI for an HTTP status, e.g.
+// "urn:go-api-boilerplate:problem:not_found"
+func ProblemType(status int) string {
+	return problemTypeBase + ErrorCode(status)
+}
+
+// WriteError writes an error response in the given ERROR_FORMAT. Every error
+// the API returns, from middleware or handlers, goes through here:
+//
+//	envelope: {"success": false, "error": ..., "code": ..., "request_id": ...}
+//	problem:  {"type": ..., "title": ..., "status": ..., "detail": ..., "instance": ...}
+//
+// data is optional diagnostic detail and is omitted when nil. A client that
+// prefers text/html over JSON, such as a browser navigating to the URL, gets
+// the error page instead; see ConfigureErrorPage.
+func WriteError(w http.ResponseWriter, r *http.Request, format string, status int, message, requestID string, data interface{}) error {
+	varyAccept(w.Header())
+	if prefersHTML(r) && writeErrorPage(w, status, message, requestID) {
+		return nil
+	}
+
+	var body interface{}
+	contentType := "application/json"
+	if format == config.ErrorFormatProblem {
+		contentType = ProblemContentType
+		body = Problem{
+			Type:     ProblemType(status),
+			Title:    http.StatusText(status),
+			Status:   status,
+			Detail:   message,
+			Instance: requestID,
+			Data:     data,
+		}
+	} else {
+		envelope := map[string]interface{}{
+			"success":    false,
+			"error":      message,
+			"code":       ErrorCode(status),
+			"request_id": requestID,
+		}
+		if data != nil {
+			envelope["data"] = data
+		}
+		body = envelope
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes an error in the configured format
+func (mw *Middleware) writeError(w http.ResponseWriter, r *http.Request, status int, message, requestID string) {
+	WriteError(w, r, mw.app.Config.ErrorFormat, status, message, requestID, nil)
+}
@@ -0,0 +1,30 @@
+package middleware
+
+// Response headers the middleware sets for clients to read
+const (
+	RequestIDHeader          = "X-Request-ID"
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+	RetryAfterHeader         = "Retry-After"
+	ETagHeader               = "ETag"
+	AgeHeader                = "Age"
+	WarningHeader            = "Warning"
+	// AuthRetryHeader tells a client to resend a request with the cookie
+	// AUTO_REFRESH has just set
+	AuthRetryHeader = "X-Auth-Retry"
+)
+
+// ResponseHeaders lists every header above, for CORS to expose
+var ResponseHeaders = []string{
+	RequestIDHeader,
+	RateLimitLimitHeader,
+	RateLimitRemainingHeader,
+	RateLimitResetHeader,
+	RetryAfterHeader,
+	ETagHeader,
+	AgeHeader,
+	WarningHeader,
+	AuthRetryHeader,
+	IdempotentReplayedHeader,
+}
@@ -0,0 +1,248 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/kvstore"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// IdempotencyKeyHeader names the request a client may retry safely
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks a response replayed from an earlier request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentResponse is the largest response body kept for replay;
+	// a request with a larger one can be repeated
+	maxIdempotentResponse = 1 << 20
+)
+
+// idempotentResponse is a response kept for replay, with the fingerprint of
+// the request that produced it
+type idempotentResponse struct {
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// replayable reports whether a response with status is kept for replay.
+// Server errors, timeouts and 429s are transient, so a retry runs again.
+func replayable(status int) bool {
+	return status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+// unreplayedHeader reports whether a kept response header belongs to the
+// original request alone; the replay keeps its own
+func unreplayedHeader(name string) bool {
+	return name == "X-Request-Id" || strings.HasPrefix(name, "X-Ratelimit-")
+}
+
+// requestFingerprint tells apart two requests sent with the same key to the
+// same path. It is keyed with secret because bodies can hold passwords,
+// and the fingerprint sits in the store next to the response.
+func requestFingerprint(secret string, r *http.Request, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(h, r.Method+"\n"+r.URL.RawQuery+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// noReplayKey is the context key for a request's *atomic.Bool set by NoReplay
+type noReplayKey struct{}
+
+// NoReplay keeps the response to r out of the idempotency store, so a
+// secret it carries (a token, a new API key) is never written to Redis or
+// handed out again. A retry then runs the request again. Handlers call it
+// before writing such a response; responses setting cookies are never kept
+// anyway.
+func NoReplay(r *http.Request) {
+	if skip, ok := r.Context().Value(noReplayKey{}).(*atomic.Bool); ok {
+		skip.Store(true)
+	}
+}
+
+// recordingWriter passes a response through while keeping a copy of it
+type recordingWriter struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if rw.status == 0 && code >= http.StatusOK {
+		rw.status = code
+		rw.header = rw.Header().Clone()
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if !rw.overflow {
+		if rw.body.Len()+len(b) > maxIdempotentResponse {
+			rw.overflow = true
+			rw.body.Reset()
+		} else {
+			rw.body.Write(b)
+		}
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recordingWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Idempotency makes POST and PUT requests carrying an Idempotency-Key safe
+// to retry. The first response for a key is kept for IDEMPOTENCY_TTL_SECONDS
+// and replayed as is, marked Idempotent-Replayed, to later requests from the
+// same caller to the same path with the same key. Callers are users behind
+// Authenticate and client IPs elsewhere. A retry sent while the first
+// request is still running gets a 409, and reusing a key for a request with
+// a different body gets a 422. Transient failures (5xx, 408, 429) are not
+// kept, so their retries run again. While the store is unreachable requests
+// run as if they had no key. Responses that set a cookie or whose handler
+// called NoReplay aren't kept either. Install it only on routes whose
+// responses are safe to store; it is not meant for login or token refresh.
+func (mw *Middleware) Idempotency(next http.Handler) http.Handler {
+	ttl := mw.app.Config.GetIdempotencyTTL()
+	lease := 2 * mw.app.Config.GetRequestTimeout()
+	secret := mw.app.Config.App_Secret
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" || r.Method != http.MethodPost && r.Method != http.MethodPut {
+			next.ServeHTTP(w, r)
+			return
+		}
+		requestID := getRequestID(r.Context())
+		if len(key) > maxIdempotencyKeyLength {
+			mw.writeError(w, r, http.StatusBadRequest, "Idempotency-Key is too long", requestID)
+			return
+		}
+
+		var body []byte
+		var err error
+		if r.Body != nil {
+			body, err = io.ReadAll(r.Body)
+		}
+		if err != nil {
+			// Let the handler fail on the body as it would have, with no key
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+			next.ServeHTTP(w, r)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		caller := getClientIP(r)
+		if userID, ok := r.Context().Value(config.UserIDKey).(string); ok && userID != "" {
+			caller = "user:" + userID
+		}
+		storeKey := kvstore.IdempotencyKey(caller, r.URL.Path, key)
+		fingerprint := requestFingerprint(secret, r, body)
+
+		if mw.replayIdempotent(w, r, storeKey, fingerprint, requestID) {
+			return
+		}
+
+		holder := uuid.New().String()
+		acquired, err := mw.kv.Acquire(r.Context(), storeKey+":lock", holder, 1, lease)
+		if err != nil {
+			mw.app.Logger.Warn().Err(err).Msg("Idempotency store failed, running request without its key")
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !acquired {
+			mw.writeError(w, r, http.StatusConflict, "A request with this Idempotency-Key is in progress", requestID)
+			return
+		}
+		defer mw.kv.Release(context.Background(), storeKey+":lock", holder)
+
+		// The first request may have finished since we looked
+		if mw.replayIdempotent(w, r, storeKey, fingerprint, requestID) {
+			return
+		}
+
+		skip := new(atomic.Bool)
+		rw := &recordingWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), noReplayKey{}, skip)))
+		if rw.status == 0 || !replayable(rw.status) || rw.overflow || skip.Load() || len(rw.header.Values("Set-Cookie")) > 0 {
+			return
+		}
+
+		kept, err := json.Marshal(idempotentResponse{
+			Fingerprint: fingerprint,
+			Status:      rw.status,
+			Header:      rw.header,
+			Body:        rw.body.Bytes(),
+		})
+		if err == nil {
+			err = mw.kv.Set(context.Background(), storeKey, string(kept), ttl)
+		}
+		if err != nil {
+			mw.app.Logger.Warn().
+				Str("request_id", requestID).
+				Err(err).
+				Msg("Failed to keep idempotent response")
+		}
+	})
+}
+
+// replayIdempotent writes the response kept under storeKey, or a 422 when it
+// came from a different request, and reports whether it wrote anything
+func (mw *Middleware) replayIdempotent(w http.ResponseWriter, r *http.Request, storeKey, fingerprint, requestID string) bool {
+	raw, err := mw.kv.Get(r.Context(), storeKey)
+	if err != nil {
+		if !errors.Is(err, core.ErrKeyNotFound) {
+			mw.app.Logger.Warn().Err(err).Msg("Idempotency store failed, running request without its key")
+		}
+		return false
+	}
+	var kept idempotentResponse
+	if err := json.Unmarshal([]byte(raw), &kept); err != nil {
+		mw.app.Logger.Warn().Err(err).Msg("Discarding unreadable idempotent response")
+		return false
+	}
+	if kept.Fingerprint != fingerprint {
+		mw.writeError(w, r, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request", requestID)
+		return true
+	}
+
+	h := w.Header()
+	for name, values := range kept.Header {
+		if !unreplayedHeader(name) {
+			h[name] = values
+		}
+	}
+	h.Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(kept.Status)
+	w.Write(kept.Body)
+	return true
+}
+
+// errReader fails every read with err
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
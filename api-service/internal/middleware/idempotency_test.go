@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotency(t *testing.T) {
+	limiterStores(t, func(t *testing.T, store core.KVStore) {
+		app, _ := newTestApp(t)
+		mw := New(app, nil, nil, store, nil)
+
+		// Each run of the handler creates a user with the next ID; /fail
+		// fails the way a database outage would, /login sets a session
+		// cookie and /api-keys hands out a secret
+		var runs atomic.Int32
+		started, hold := make(chan struct{}, 1), make(chan struct{})
+		close(hold)
+		handler := mw.Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := runs.Add(1)
+			if r.URL.Path == "/slow" {
+				started <- struct{}{}
+				<-hold
+			}
+			if r.URL.Path == "/fail" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Request-ID", getRequestID(r.Context()))
+			switch r.URL.Path {
+			case "/auth/login":
+				http.SetCookie(w, &http.Cookie{Name: config.AuthCookieName, Value: "token"})
+			case "/api/v1/api-keys":
+				NoReplay(r)
+			}
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"user_id":"user-%d"}`, n)
+		}))
+		serve := func(method, path, key, body, ip string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			req.RemoteAddr = ip + ":1234"
+			if key != "" {
+				req.Header.Set(IdempotencyKeyHeader, key)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), config.RequestIDKey, "req-"+key))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec
+		}
+		const signup = `{"username":"alice"}`
+
+		t.Run("Replayed", func(t *testing.T) {
+			runs.Store(0)
+			first := serve(http.MethodPost, "/auth/register", "k1", signup, "10.0.0.1")
+			require.Equal(t, http.StatusCreated, first.Code)
+			assert.Empty(t, first.Header().Get(IdempotentReplayedHeader))
+
+			second := serve(http.MethodPost, "/auth/register", "k1", signup, "10.0.0.1")
+			assert.Equal(t, int32(1), runs.Load(), "the retry did not run the handler")
+			assert.Equal(t, http.StatusCreated, second.Code)
+			assert.Equal(t, first.Body.String(), second.Body.String())
+			assert.Equal(t, "true", second.Header().Get(IdempotentReplayedHeader))
+			assert.Equal(t, "application/json", second.Header().Get("Content-Type"))
+			assert.Empty(t, second.Header().Get("X-Request-ID"), "the first request's ID is not replayed")
+		})
+
+		t.Run("KeyReusedForDifferentRequest", func(t *testing.T) {
+			serve(http.MethodPost, "/auth/register", "k2", signup, "10.0.0.1")
+			rec := serve(http.MethodPost, "/auth/register", "k2", `{"username":"bob"}`, "10.0.0.1")
+			assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		})
+
+		t.Run("ScopedToCallerAndPath", func(t *testing.T) {
+			runs.Store(0)
+			serve(http.MethodPost, "/auth/register", "k3", signup, "10.0.0.1")
+			assert.Empty(t, serve(http.MethodPost, "/auth/register", "k3", signup, "10.0.0.2").Header().Get(IdempotentReplayedHeader))
+			assert.Empty(t, serve(http.MethodPost, "/auth/login", "k3", signup, "10.0.0.1").Header().Get(IdempotentReplayedHeader))
+			assert.Equal(t, int32(3), runs.Load())
+		})
+
+		t.Run("InProgress", func(t *testing.T) {
+			hold = make(chan struct{})
+			done := make(chan int)
+			go func() { done <- serve(http.MethodPost, "/slow", "k4", signup, "10.0.0.1").Code }()
+			<-started
+
+			assert.Equal(t, http.StatusConflict, serve(http.MethodPost, "/slow", "k4", signup, "10.0.0.1").Code)
+			close(hold)
+			assert.Equal(t, http.StatusCreated, <-done)
+
+			rec := serve(http.MethodPost, "/slow", "k4", signup, "10.0.0.1")
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, "true", rec.Header().Get(IdempotentReplayedHeader))
+		})
+
+		t.Run("SecretsAreNotKept", func(t *testing.T) {
+			runs.Store(0)
+			serve(http.MethodPost, "/auth/login", "k7", signup, "10.0.0.1")
+			rec := serve(http.MethodPost, "/auth/login", "k7", signup, "10.0.0.1")
+			assert.Empty(t, rec.Header().Get(IdempotentReplayedHeader), "a response setting a cookie is not replayed")
+			assert.Len(t, rec.Header().Values("Set-Cookie"), 1)
+
+			serve(http.MethodPost, "/api/v1/api-keys", "k7", signup, "10.0.0.1")
+			rec = serve(http.MethodPost, "/api/v1/api-keys", "k7", signup, "10.0.0.1")
+			assert.Empty(t, rec.Header().Get(IdempotentReplayedHeader), "a response marked NoReplay is not replayed")
+			assert.Equal(t, int32(4), runs.Load())
+		})
+
+		t.Run("TransientFailuresRunAgain", func(t *testing.T) {
+			runs.Store(0)
+			assert.Equal(t, http.StatusInternalServerError, serve(http.MethodPost, "/fail", "k5", signup, "10.0.0.1").Code)
+			assert.Equal(t, http.StatusInternalServerError, serve(http.MethodPost, "/fail", "k5", signup, "10.0.0.1").Code)
+			assert.Equal(t, int32(2), runs.Load())
+		})
+
+		t.Run("OnlyPOSTAndPUTWithAKey", func(t *testing.T) {
+			runs.Store(0)
+			serve(http.MethodPost, "/auth/register", "", signup, "10.0.0.1")
+			serve(http.MethodPost, "/auth/register", "", signup, "10.0.0.1")
+			serve(http.MethodDelete, "/api/v1/profile", "k6", "", "10.0.0.1")
+			serve(http.MethodDelete, "/api/v1/profile", "k6", "", "10.0.0.1")
+			assert.Equal(t, int32(4), runs.Load())
+		})
+
+		t.Run("KeyTooLong", func(t *testing.T) {
+			rec := serve(http.MethodPost, "/auth/register", strings.Repeat("k", 256), signup, "10.0.0.1")
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	})
+}
+
+func TestRequestFingerprintIsKeyed(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/auth/register", nil)
+	body := []byte(`{"password":"Password123!"}`)
+
+	fingerprint := requestFingerprint("secret-one", req, body)
+	assert.Equal(t, fingerprint, requestFingerprint("secret-one", req, body))
+	assert.NotEqual(t, fingerprint, requestFingerprint("secret-two", req, body),
+		"without the secret a stored fingerprint can't be matched against guessed passwords")
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/kvstore"
+	"azlo-goboiler/internal/models"
+)
+
+const (
+	// maintenanceCheckInterval is how long an instance trusts the switch it
+	// last read, so maintenance mode reaches every instance within it
+	maintenanceCheckInterval = 2 * time.Second
+	// defaultMaintenanceRetryAfter is sent when the admin gave no retry_after_seconds
+	defaultMaintenanceRetryAfter = 300
+	defaultMaintenanceMessage    = "The API is down for maintenance"
+)
+
+// maintenanceExempt lists the paths served in maintenance mode: probes and
+// metrics, so the deployment doesn't restart or lose sight of the instance,
+// and the switch itself, with the sign-in and refresh it needs, so an admin
+// whose session has lapsed can still turn it off
+var maintenanceExempt = map[string]bool{
+	"/health":                   true,
+	"/health/detailed":          true,
+	"/ready":                    true,
+	"/metrics":                  true,
+	"/auth/login":               true,
+	"/auth/refresh":             true,
+	"/api/v1/admin/maintenance": true,
+}
+
+// maintenanceSwitch caches the maintenance mode switch read from the store
+type maintenanceSwitch struct {
+	kv core.KVStore
+
+	mu        sync.Mutex
+	mode      models.MaintenanceMode
+	checkedAt time.Time
+}
+
+// current returns the switch, reading the store at most once per
+// maintenanceCheckInterval. A store error turns maintenance mode off until
+// the next read, and is returned once.
+func (s *maintenanceSwitch) current(ctx context.Context) (models.MaintenanceMode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.checkedAt) < maintenanceCheckInterval {
+		return s.mode, nil
+	}
+
+	var mode models.MaintenanceMode
+	raw, err := s.kv.Get(ctx, kvstore.MaintenanceModeKey)
+	if err == nil {
+		err = json.Unmarshal([]byte(raw), &mode)
+	}
+	if errors.Is(err, core.ErrKeyNotFound) {
+		err = nil
+	}
+	if err != nil {
+		mode = models.MaintenanceMode{}
+	}
+	s.mode, s.checkedAt = mode, time.Now()
+	return mode, err
+}
+
+// Maintenance refuses requests with 503 and a Retry-After while an admin has
+// maintenance mode on, other than the health probes, /metrics and the
+// switch at /api/v1/admin/maintenance. Clients at an allow address still get
+// through, to check the API before it reopens. The switch is kept in the
+// KV store, so it applies to every instance within a couple of seconds.
+// While the store is unreachable maintenance mode is treated as off.
+func (mw *Middleware) Maintenance(allow []netip.Prefix) func(http.Handler) http.Handler {
+	filter := ipFilter{allow: allow}
+	return func(next http.Handler) http.Handler {
+		sw := &maintenanceSwitch{kv: mw.kv}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maintenanceExempt[strings.TrimSuffix(r.URL.Path, "/")] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			mode, err := sw.current(r.Context())
+			if err != nil {
+				mw.app.Logger.Warn().Err(err).Msg("Maintenance mode store failed, serving request")
+			}
+			if !mode.Enabled || len(allow) > 0 && filter.allows(getClientIP(r)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			retryAfter := mode.RetryAfter
+			if retryAfter <= 0 {
+				retryAfter = defaultMaintenanceRetryAfter
+			}
+			message := mode.Message
+			if message == "" {
+				message = defaultMaintenanceMessage
+			}
+			w.Header().Set(RetryAfterHeader, strconv.Itoa(retryAfter))
+			mw.writeError(w, r, http.StatusServiceUnavailable, message, getRequestID(r.Context()))
+		})
+	}
+}
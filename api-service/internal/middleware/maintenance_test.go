@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"azlo-goboiler/internal/kvstore"
+	"azlo-goboiler/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenance(t *testing.T) {
+	app, mr := newTestApp(t)
+	store := kvstore.NewRedis(app.Redis)
+	mw := New(app, nil, nil, store, nil)
+	allow := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	setMode := func(t *testing.T, mode models.MaintenanceMode) {
+		raw, err := json.Marshal(mode)
+		require.NoError(t, err)
+		require.NoError(t, store.Set(context.Background(), kvstore.MaintenanceModeKey, string(raw), 0))
+	}
+	// Each handler reads the switch afresh on its first request
+	serve := func(path, ip string) *httptest.ResponseRecorder {
+		handler := mw.Maintenance(allow)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("OffUntilSet", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("/api/v1/profile", "203.0.113.7").Code)
+	})
+
+	t.Run("On", func(t *testing.T) {
+		setMode(t, models.MaintenanceMode{Enabled: true, Message: "Back at 02:00 UTC", RetryAfter: 120})
+		rec := serve("/api/v1/profile", "203.0.113.7")
+		require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "120", rec.Header().Get("Retry-After"))
+		assert.Contains(t, rec.Header().Get("Content-Type"), "json")
+		assert.Contains(t, rec.Body.String(), "Back at 02:00 UTC")
+
+		for _, path := range []string{"/health", "/ready", "/metrics", "/auth/login", "/auth/refresh", "/api/v1/admin/maintenance"} {
+			assert.Equal(t, http.StatusOK, serve(path, "203.0.113.7").Code, path)
+		}
+		assert.Equal(t, http.StatusOK, serve("/api/v1/profile", "10.1.2.3").Code, "allowlisted")
+	})
+
+	t.Run("Defaults", func(t *testing.T) {
+		setMode(t, models.MaintenanceMode{Enabled: true})
+		rec := serve("/auth/register", "203.0.113.7")
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "300", rec.Header().Get("Retry-After"))
+	})
+
+	t.Run("Off", func(t *testing.T) {
+		setMode(t, models.MaintenanceMode{Enabled: false})
+		assert.Equal(t, http.StatusOK, serve("/api/v1/profile", "203.0.113.7").Code)
+	})
+
+	t.Run("StoreFailureServes", func(t *testing.T) {
+		setMode(t, models.MaintenanceMode{Enabled: true})
+		mr.SetError("LOADING Redis is loading the dataset in memory")
+		defer mr.SetError("")
+		assert.Equal(t, http.StatusOK, serve("/api/v1/profile", "203.0.113.7").Code)
+	})
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Rate limit decision results. FailOpen is a request let through because the
+// store could not be reached, so it is not known whether it was over the
+// limit; a steady stream of them means limiting is effectively off.
+const (
+	rateLimitAllowed  = "allowed"
+	rateLimitLimited  = "limited"
+	rateLimitFailOpen = "fail_open"
+)
+
+// Limiters, as labelled in rateLimitDecisions
+const (
+	limiterGlobal      = "global"
+	limiterUser        = "user"
+	limiterRoute       = "route"
+	limiterAbuse       = "abuse"
+	limiterConcurrency = "concurrency"
+)
+
+var rateLimitDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "rate_limit_decisions_total",
+	Help: "Requests checked by a rate limiter, by limiter and result (allowed, limited or fail_open).",
+}, []string{"limiter", "result"})
+
+// Collectors returns the middleware metrics, for the application's registry
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{rateLimitDecisions}
+}
@@ -5,28 +5,48 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"net/url"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/jwtkeys"
+	"azlo-goboiler/internal/kvstore"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/signedurl"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/rs/zerolog"
 	"go.opentelemetry.io/otel/trace"
-	"golang.org/x/time/rate"
 )
 
 type Middleware struct {
-	app *config.Application
+	app       *config.Application
+	sessions  core.SessionStore
+	apiKeys   core.APIKeyService
+	kv        core.KVStore
+	refresher core.SessionRefresher
+	links     *signedurl.Signer
 }
 
-func New(app *config.Application) *Middleware {
-	return &Middleware{app: app}
+// New builds the middleware set. A nil kv keeps rate-limit state in memory,
+// which is only shared within this process. A nil refresher disables
+// AUTO_REFRESH.
+func New(app *config.Application, sessions core.SessionStore, apiKeys core.APIKeyService, kv core.KVStore, refresher core.SessionRefresher) *Middleware {
+	if kv == nil {
+		kv = kvstore.NewMemory()
+	}
+	return &Middleware{app: app, sessions: sessions, apiKeys: apiKeys, kv: kv, refresher: refresher, links: signedurl.New(app.Config.App_Secret)}
 }
 
 // --- RESPONSE WRITER for logging ---
@@ -43,21 +63,32 @@ func (rw *responseWriter) WriteHeader(code int) {
 
 func (rw *responseWriter) Write(b []byte) (int, error) {
 	size, err := rw.ResponseWriter.Write(b)
-	rw.size += size
+	// A 304 or 204 carries no body; net/http drops anything written after one
+	if rw.statusCode != http.StatusNotModified && rw.statusCode != http.StatusNoContent {
+		rw.size += size
+	}
 	return size, err
 }
 
+// Flush passes through, so compressed and streamed responses can flush
+// past the access log
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // --- REQUEST ID MIDDLEWARE ---
 func (mw *Middleware) RequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestID := r.Header.Get("X-Request-ID")
+		requestID := r.Header.Get(RequestIDHeader)
 		if requestID == "" {
 			requestID = uuid.New().String()
 		}
 
 		ctx := context.WithValue(r.Context(), config.RequestIDKey, requestID)
 
-		w.Header().Set("X-Request-ID", requestID)
+		w.Header().Set(RequestIDHeader, requestID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -78,14 +109,14 @@ func (mw *Middleware) Logging(next http.Handler) http.Handler {
 		traceID := span.SpanContext().TraceID().String()
 
 		// Log request with detailed information
-		logEvent := mw.app.Logger.Info()
+		logEvent := mw.app.AccessLogger.Info()
 
 		// Add error level for 4xx and 5xx responses
 		if wrapped.statusCode >= 400 {
 			if wrapped.statusCode >= 500 {
-				logEvent = mw.app.Logger.Error()
+				logEvent = mw.app.AccessLogger.Error()
 			} else {
-				logEvent = mw.app.Logger.Warn()
+				logEvent = mw.app.AccessLogger.Warn()
 			}
 		}
 
@@ -94,7 +125,7 @@ func (mw *Middleware) Logging(next http.Handler) http.Handler {
 			Str("trace_id", traceID).
 			Str("method", r.Method).
 			Str("path", r.URL.Path).
-			Str("query", r.URL.RawQuery).
+			Str("query", redactQuery(r.URL.RawQuery, mw.app.Config.Log.RedactQueryParams)).
 			Int("status", wrapped.statusCode).
 			Dur("duration", duration).
 			Str("ip", getClientIP(r)).
@@ -105,6 +136,66 @@ func (mw *Middleware) Logging(next http.Handler) http.Handler {
 	})
 }
 
+// redactQuery masks the values of sensitive query parameters, matching names
+// case-insensitively. Other parameters and their order are left untouched.
+// An empty list falls back to config.DefaultRedactedQueryParams.
+func redactQuery(rawQuery string, names []string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+	if len(names) == 0 {
+		names = config.DefaultRedactedQueryParams
+	}
+
+	parts := strings.Split(rawQuery, "&")
+	for i, part := range parts {
+		rawKey, _, _ := strings.Cut(part, "=")
+		key := rawKey
+		if unescaped, err := url.QueryUnescape(rawKey); err == nil {
+			key = unescaped
+		}
+		for _, name := range names {
+			if strings.EqualFold(key, strings.TrimSpace(name)) {
+				parts[i] = rawKey + "=[REDACTED]"
+				break
+			}
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// --- SHUTDOWN GATE ---
+
+// probePaths are answered while draining: /ready reports the drain and
+// /health keeps reporting liveness
+var probePaths = map[string]bool{
+	"/ready":  true,
+	"/health": true,
+}
+
+// ShutdownGate turns new requests away with 503 and "Connection: close" once
+// app.Readiness is draining, so clients retry on another instance instead of
+// racing the server closing. Requests already past the gate run to
+// completion and are counted, so shutdown can tell when they have drained.
+// The probe paths stay open, so load balancers hear "Shutting down" from
+// /ready until the listener closes.
+func (mw *Middleware) ShutdownGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mw.app.Readiness == nil || probePaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if mw.app.Readiness.Draining() {
+			w.Header().Set("Connection", "close")
+			mw.writeError(w, r, http.StatusServiceUnavailable, "Server is shutting down", getRequestID(r.Context()))
+			return
+		}
+		mw.app.Readiness.RequestStarted()
+		defer mw.app.Readiness.RequestDone()
+		next.ServeHTTP(w, r)
+	})
+}
+
 // --- ENHANCED RECOVERY MIDDLEWARE ---
 func (mw *Middleware) Recovery(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -121,9 +212,7 @@ func (mw *Middleware) Recovery(next http.Handler) http.Handler {
 					Msg("Panic recovered")
 
 				// Return a generic error response
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(`{"success":false,"error":"Internal server error","request_id":"` + requestID + `"}`))
+				mw.writeError(w, r, http.StatusInternalServerError, "Internal server error", requestID)
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -135,223 +224,959 @@ func (mw *Middleware) JWT(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		requestID := getRequestID(r.Context())
 
-		// Read the token from the secure cookie
-		cookie, err := r.Cookie("jwt_token")
-		if err != nil {
+		// Read the token from the secure cookie, or from a Bearer header for
+		// clients using header mode. The cookie wins when both are sent; an
+		// empty one counts as absent, as in SessionToken.
+		tokenString := bearerToken(r)
+		fromCookie := false
+		if cookie, err := r.Cookie(config.AuthCookieName); err == nil && cookie.Value != "" {
+			tokenString = cookie.Value
+			fromCookie = true
+		}
+		if tokenString == "" {
 			mw.app.Logger.Warn().
 				Str("request_id", requestID).
 				Msg("Missing auth cookie")
-			writeJSONError(w, http.StatusUnauthorized, "Auth cookie required", requestID)
+			mw.writeError(w, r, http.StatusUnauthorized, "Auth cookie required", requestID)
 			return
 		}
 
-		tokenString := cookie.Value
-		claims := &jwt.RegisteredClaims{}
-
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		claims, err := mw.verifyToken(tokenString)
+		if err != nil {
+			// A cookie session whose access token has lapsed can be renewed
+			// silently from its refresh cookie
+			var expired *expiredTokenError
+			if errors.As(err, &expired) && fromCookie && mw.app.Config.AutoRefresh && mw.refresher != nil {
+				if resp, ok := mw.refreshAccess(w, r, expired.subject, requestID); ok {
+					if !isSafeMethod(r.Method) {
+						// Clients that don't know about refresh retry on 401, so
+						// only safe requests go through to avoid a double write
+						w.Header().Set(AuthRetryHeader, "true")
+						mw.writeError(w, r, http.StatusUnauthorized, "Token refreshed; retry the request", requestID)
+						return
+					}
+					ctx := context.WithValue(r.Context(), config.UserIDKey, resp.User.ID)
+					ctx = context.WithValue(ctx, config.UserRoleKey, resp.User.Role)
+					ctx = context.WithValue(ctx, config.MustChangePasswordKey, resp.MustChangePassword)
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
 			}
-			return []byte(mw.app.Config.App_Secret), nil
-		})
 
-		if err != nil {
-			status := http.StatusUnauthorized
 			msg := "Invalid token"
-
-			if errors.Is(err, jwt.ErrTokenExpired) {
+			switch {
+			case expired != nil:
 				msg = "Token has expired"
 				mw.app.Logger.Warn().
 					Str("request_id", requestID).
-					Str("user_id", claims.Subject).
+					Str("user_id", expired.subject).
 					Msg("Expired token used")
-			} else {
+			case errors.Is(err, jwt.ErrTokenNotValidYet):
+				msg = "Token is not valid yet"
+				mw.app.Logger.Warn().
+					Str("request_id", requestID).
+					Msg("Token used before its not-before time")
+			default:
 				mw.app.Logger.Warn().
 					Str("request_id", requestID).
 					Err(err).
 					Msg("Token validation failed")
 			}
 
-			writeJSONError(w, status, msg, requestID)
+			mw.writeError(w, r, http.StatusUnauthorized, msg, requestID)
 			return
 		}
 
-		if !token.Valid {
-			mw.app.Logger.Warn().
-				Str("request_id", requestID).
-				Msg("Invalid token used")
-			writeJSONError(w, http.StatusUnauthorized, "Invalid token", requestID)
+		revoked, err := mw.sessionRevoked(r.Context(), &claims.RegisteredClaims, requestID)
+		if err != nil {
+			mw.writeError(w, r, http.StatusServiceUnavailable, "Session store unavailable", requestID)
+			return
+		}
+		if revoked {
+			mw.writeError(w, r, http.StatusUnauthorized, "Session has been revoked", requestID)
+			return
+		}
+
+		replaced, err := mw.sessionReplaced(r.Context(), &claims.RegisteredClaims, requestID)
+		if err != nil {
+			mw.writeError(w, r, http.StatusServiceUnavailable, "Session store unavailable", requestID)
+			return
+		}
+		if replaced {
+			mw.writeError(w, r, http.StatusUnauthorized, "Session ended by a login elsewhere", requestID)
 			return
 		}
 
-		// Add user ID and request ID to context
+		// Add user ID, role and the password change flag to context
 		ctx := context.WithValue(r.Context(), config.UserIDKey, claims.Subject)
+		ctx = context.WithValue(ctx, config.UserRoleKey, claims.Role)
+		ctx = context.WithValue(ctx, config.MustChangePasswordKey, claims.MustChangePassword)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// --- REDIS-BASED RATE LIMITER ---
-type RedisRateLimiter struct {
-	app   *config.Application
-	rate  int
-	burst int
+// parseToken verifies a session token with the configured signing keys
+func (mw *Middleware) parseToken(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwtkeys.Parse(tokenString, claims, mw.app.Config.App_Secret, jwt.WithLeeway(mw.app.Config.GetJWTClockSkew()))
 }
 
-func NewRedisRateLimiter(app *config.Application, rate, burst int) *RedisRateLimiter {
-	return &RedisRateLimiter{
-		app:   app,
-		rate:  rate,
-		burst: burst,
+// expiredTokenError is verifyToken's error for a token that is genuine but
+// past its expiry. It carries the subject, the one claim of such a token
+// the middleware acts on, to match a refresh cookie to its session.
+type expiredTokenError struct {
+	subject string
+	err     error
+}
+
+func (e *expiredTokenError) Error() string { return e.err.Error() }
+func (e *expiredTokenError) Unwrap() error { return e.err }
+
+// verifyToken returns a session token's claims only once the token is fully
+// valid. jwt fills in the claims before it checks the signature, so on any
+// failure they are dropped rather than returned: a forged or tampered token
+// must not get its subject or role into a log line, let alone the context.
+func (mw *Middleware) verifyToken(tokenString string) (*config.AccessClaims, error) {
+	claims := &config.AccessClaims{}
+	token, err := mw.parseToken(tokenString, claims)
+	switch {
+	case err == nil && token.Valid:
+		return claims, nil
+	case err == nil:
+		return nil, jwt.ErrTokenUnverifiable
+	case errors.Is(err, jwt.ErrTokenInvalidClaims) && errors.Is(err, jwt.ErrTokenExpired):
+		// Claims are only validated after the signature verifies, so the
+		// subject of an expired token is the one we issued
+		return nil, &expiredTokenError{subject: claims.Subject, err: err}
+	default:
+		return nil, err
 	}
 }
 
-func (rl *RedisRateLimiter) Allow(ip string) bool {
-	ctx := context.Background()
-	key := fmt.Sprintf("rate_limit:%s", ip)
+// isSafeMethod reports whether a request has no side effects on the server
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// refreshAccess exchanges the refresh cookie that belongs with the expired
+// access token of subject for a new pair of session cookies. The exchange rotates the
+// refresh token exactly as POST /auth/refresh does, so the same revocation
+// checks apply.
+func (mw *Middleware) refreshAccess(w http.ResponseWriter, r *http.Request, subject string, requestID string) (*models.LoginResponse, bool) {
+	cookie, err := r.Cookie(config.RefreshCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, false
+	}
 
-	// Use Redis with sliding window algorithm
-	now := time.Now().Unix()
-	windowStart := now - 60 // 1-minute window
+	resp, err := mw.refresher.Refresh(r.Context(), cookie.Value)
+	if err != nil {
+		mw.app.Logger.Warn().
+			Str("request_id", requestID).
+			Str("user_id", subject).
+			Err(err).
+			Msg("Refresh token rejected")
+		return nil, false
+	}
+	if resp.User.ID != subject {
+		// The refresh token is spent either way; the client has to log in
+		mw.app.Logger.Warn().
+			Str("request_id", requestID).
+			Str("user_id", subject).
+			Msg("Refresh token does not match session")
+		return nil, false
+	}
 
-	pipe := rl.app.Redis.Pipeline()
+	SetSessionCookies(w, &mw.app.Config, resp)
+	mw.app.Logger.Info().
+		Str("request_id", requestID).
+		Str("user_id", resp.User.ID).
+		Msg("Access token refreshed")
+	return resp, true
+}
 
-	// Remove old entries outside the window
-	pipe.ZRemRangeByScore(ctx, key, "0", strconv.FormatInt(windowStart, 10))
+// Authenticate admits a request carrying either a session (cookie or Bearer
+// token, as JWT checks) or an API key in "Authorization: ApiKey <key>".
+func (mw *Middleware) Authenticate(next http.Handler) http.Handler {
+	withSession, withKey := mw.JWT(next), mw.APIKey(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAPIKeyRequest(r) {
+			withKey.ServeHTTP(w, r)
+			return
+		}
+		withSession.ServeHTTP(w, r)
+	})
+}
 
-	// Count current requests in window
-	countCmd := pipe.ZCard(ctx, key)
+// isAPIKeyRequest reports whether the Authorization header uses the ApiKey scheme
+func isAPIKeyRequest(r *http.Request) bool {
+	scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	return strings.EqualFold(scheme, "ApiKey")
+}
 
-	// Add current request
-	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now), Member: now})
+// APIKey authenticates machine callers presenting "Authorization: ApiKey <key>".
+// The key is checked against storage on every request, so revocation is immediate.
+// Keys without the write scope may only make safe (read-only) requests.
+func (mw *Middleware) APIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := getRequestID(r.Context())
 
-	// Set expiration
-	pipe.Expire(ctx, key, time.Minute*2)
+		scheme, key, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "ApiKey") || strings.TrimSpace(key) == "" {
+			mw.writeError(w, r, http.StatusUnauthorized, "API key required", requestID)
+			return
+		}
 
-	_, err := pipe.Exec(ctx)
+		apiKey, err := mw.apiKeys.Authenticate(r.Context(), strings.TrimSpace(key))
+		if err != nil {
+			if !errors.Is(err, core.ErrAPIKeyInvalid) {
+				mw.app.Logger.Error().
+					Str("request_id", requestID).
+					Err(err).
+					Msg("API key lookup failed")
+			}
+			mw.writeError(w, r, http.StatusUnauthorized, "Invalid API key", requestID)
+			return
+		}
+		if !isSafeMethod(r.Method) && !slices.Contains(apiKey.Scopes, models.APIKeyScopeWrite) {
+			mw.writeError(w, r, http.StatusForbidden, "API key lacks the write scope", requestID)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), config.UserIDKey, apiKey.UserID)
+		ctx = context.WithValue(ctx, config.APIKeyScopesKey, apiKey.Scopes)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireRole admits only sessions whose token carries role, as stashed in
+// the context by JWT, so it costs no lookup. It must run after JWT or
+// Authenticate. API keys carry no role and are always refused.
+func (mw *Middleware) RequireRole(role string) func(http.Handler) http.Handler {
+	message := strings.ToUpper(role[:1]) + role[1:] + " role required"
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if got, _ := r.Context().Value(config.UserRoleKey).(string); got != role {
+				userID, _ := r.Context().Value(config.UserIDKey).(string)
+				mw.app.Logger.Warn().
+					Str("request_id", getRequestID(r.Context())).
+					Str("user_id", userID).
+					Str("required_role", role).
+					Msg("Request refused for missing role")
+				mw.writeError(w, r, http.StatusForbidden, message, getRequestID(r.Context()))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// VerifySignedURL admits requests carrying a valid, unexpired signed link
+// in place of credentials. The link grants access to its own path only.
+func (mw *Middleware) VerifySignedURL(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := getRequestID(r.Context())
+
+		switch err := mw.links.Verify(r.URL); {
+		case err == nil:
+			next.ServeHTTP(w, r)
+		case errors.Is(err, signedurl.ErrExpired):
+			mw.writeError(w, r, http.StatusGone, "Link has expired", requestID)
+		default:
+			mw.app.Logger.Warn().
+				Str("request_id", requestID).
+				Str("path", r.URL.Path).
+				Err(err).
+				Msg("Rejected signed link")
+			mw.writeError(w, r, http.StatusForbidden, "Invalid link signature", requestID)
+		}
+	})
+}
+
+// sessionRevoked reports whether the token was revoked on logout, or issued
+// before the global or per-user token epoch set by "revoke all sessions" and
+// "log out all devices".
+func (mw *Middleware) sessionRevoked(ctx context.Context, claims *jwt.RegisteredClaims, requestID string) (bool, error) {
+	if mw.sessions == nil {
+		return false, nil
+	}
+
+	epoch, err := mw.sessions.EffectiveEpoch(ctx, claims.Subject)
 	if err != nil {
-		// If Redis fails, allow the request (fail open)
-		rl.app.Logger.Warn().Err(err).Msg("Redis rate limiter failed, allowing request")
-		return true
+		return false, mw.sessionStoreFailure(err, requestID, "Failed to read token epoch")
+	}
+	if epoch > 0 && (claims.IssuedAt == nil || claims.IssuedAt.Unix() < epoch) {
+		mw.app.Logger.Warn().
+			Str("request_id", requestID).
+			Str("user_id", claims.Subject).
+			Msg("Token issued before revocation epoch")
+		return true, nil
 	}
 
-	// Get the count
-	count := countCmd.Val()
-	return count <= int64(rl.rate)
+	if claims.ID == "" {
+		return false, nil
+	}
+	revoked, err := mw.sessions.TokenRevoked(ctx, claims.ID)
+	if err != nil {
+		return false, mw.sessionStoreFailure(err, requestID, "Failed to read token revocation")
+	}
+	if revoked {
+		mw.app.Logger.Warn().
+			Str("request_id", requestID).
+			Str("user_id", claims.Subject).
+			Msg("Revoked token used")
+	}
+	return revoked, nil
 }
 
-// --- FALLBACK IN-MEMORY RATE LIMITER ---
-type visitor struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
+// sessionReplaced reports whether, in single-session mode, a later login has
+// recorded another session for the token's user. Tokens from before the mode
+// was enabled have no recorded session and stay valid until they expire.
+func (mw *Middleware) sessionReplaced(ctx context.Context, claims *jwt.RegisteredClaims, requestID string) (bool, error) {
+	if mw.sessions == nil || !mw.app.Config.SingleSession {
+		return false, nil
+	}
+
+	active, err := mw.sessions.ActiveSession(ctx, claims.Subject)
+	if err != nil {
+		return false, mw.sessionStoreFailure(err, requestID, "Failed to read active session")
+	}
+
+	if active != "" && active != claims.ID {
+		mw.app.Logger.Info().
+			Str("request_id", requestID).
+			Str("user_id", claims.Subject).
+			Msg("Token replaced by a newer login")
+		return true, nil
+	}
+	return false, nil
+}
+
+// sessionStoreFailure decides what a session store error means for the
+// request. By default the checks fail closed, since a revoked token must not
+// slip through an outage; REVOCATION_FAIL_OPEN skips them instead so an
+// outage doesn't lock every user out. It returns nil when failing open.
+func (mw *Middleware) sessionStoreFailure(err error, requestID, msg string) error {
+	if mw.app.Config.RevocationFailOpen {
+		mw.app.Logger.Warn().
+			Str("request_id", requestID).
+			Err(err).
+			Msg(msg + ", skipping check")
+		return nil
+	}
+	mw.app.Logger.Error().
+		Str("request_id", requestID).
+		Err(err).
+		Msg(msg + ", rejecting request")
+	return err
 }
 
-type MemoryRateLimiter struct {
-	visitors map[string]*visitor
-	mu       sync.RWMutex
-	rate     rate.Limit
-	burst    int
+// --- SLIDING WINDOW RATE LIMITER ---
+
+// rateLimitWindow is the default sliding window requests are counted over
+const rateLimitWindow = time.Minute
+
+// SlidingWindowRateLimiter counts each client's requests over a sliding
+// window in a core.KVStore, so instances sharing a store share limits.
+//
+// Rejected requests are recorded too, so a client flooding the limiter keeps
+// itself limited. burst caps how many hits are stored per client, which
+// bounds each key's memory however hard it is hammered; keys of clients that
+// go quiet, such as rotated-away attacker IPs, expire after two windows.
+type SlidingWindowRateLimiter struct {
+	store  core.KVStore
+	logger zerolog.Logger
+	rate   int
+	burst  int
+	window time.Duration
+	now    func() time.Time
+	name   string // limiter label in rateLimitDecisions
+
+	// localTTL enables the local pre-check cache; zero sends every request to the store
+	localTTL time.Duration
+	mu       sync.Mutex
+	local    map[string]*localWindow
 }
 
-func NewMemoryRateLimiter(rps int, burst int) *MemoryRateLimiter {
-	rl := &MemoryRateLimiter{
-		visitors: make(map[string]*visitor),
-		rate:     rate.Limit(rps),
-		burst:    burst,
+// localWindow is this instance's last view of a client's stored count plus
+// the hits it has allowed since without telling the store
+type localWindow struct {
+	count     int64
+	oldest    time.Time // the store's oldest hit when last checked
+	pending   int
+	checkedAt time.Time
+}
+
+func NewSlidingWindowRateLimiter(store core.KVStore, logger zerolog.Logger, rate, burst int) *SlidingWindowRateLimiter {
+	return &SlidingWindowRateLimiter{
+		store:  store,
+		logger: logger,
+		rate:   rate,
+		burst:  burst,
+		window: rateLimitWindow,
+		now:    time.Now,
+		name:   limiterGlobal,
+		local:  make(map[string]*localWindow),
+	}
+}
+
+// WithWindow counts requests over window instead of a minute. Non-positive
+// values keep the default.
+func (rl *SlidingWindowRateLimiter) WithWindow(window time.Duration) *SlidingWindowRateLimiter {
+	if window > 0 {
+		rl.window = window
 	}
-	go rl.cleanupVisitors()
 	return rl
 }
 
-func (rl *MemoryRateLimiter) getLimiter(ip string) *rate.Limiter {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+// WithLocalCache lets clients well under the limit skip the store for up to ttl.
+//
+// Accuracy tradeoff: a locally allowed hit only reaches the store with the
+// client's next checked request, so other instances don't see it for up to
+// ttl. The cache is only used while the last known count plus pending hits
+// is under half the limit, so across N instances a client can overshoot by
+// at most N*rate/2 within one ttl, and never while it is near the limit.
+func (rl *SlidingWindowRateLimiter) WithLocalCache(ttl time.Duration) *SlidingWindowRateLimiter {
+	rl.localTTL = ttl
+	return rl
+}
 
-	v, exists := rl.visitors[ip]
-	if !exists {
-		limiter := rate.NewLimiter(rl.rate, rl.burst)
-		rl.visitors[ip] = &visitor{limiter, time.Now()}
-		return limiter
+// RateLimitDecision is the limiter's answer for one request
+type RateLimitDecision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int // requests left in the window, after this one
+	// Reset is when the oldest hit in the window leaves it, freeing a slot;
+	// a refused client can retry then
+	Reset time.Time
+	// Unchecked is set when the store failed and the request was let
+	// through uncounted; the other fields are then meaningless
+	Unchecked bool
+}
+
+// Allow records a request from client, an IP or any other bucket name, and
+// decides whether it is within the limit
+func (rl *SlidingWindowRateLimiter) Allow(client string) RateLimitDecision {
+	ctx := context.Background()
+	key := kvstore.RateLimitKey(client)
+	now := rl.now()
+
+	hits := 1
+	if rl.localTTL > 0 {
+		rl.mu.Lock()
+		if w, ok := rl.local[client]; ok {
+			if now.Sub(w.checkedAt) < rl.localTTL && w.count+int64(w.pending)+1 < int64(rl.rate/2) {
+				w.pending++
+				count, oldest := w.count+int64(w.pending), w.oldest
+				rl.mu.Unlock()
+				rateLimitDecisions.WithLabelValues(rl.name, rateLimitAllowed).Inc()
+				return rl.decision(count, oldest, now)
+			}
+			hits += w.pending
+			w.pending = 0
+		}
+		rl.mu.Unlock()
 	}
 
-	v.lastSeen = time.Now()
-	return v.limiter
-}
+	// SlidingWindow records and counts atomically, so a failure never leaves
+	// the window half updated; the request is simply not counted
+	count, oldest, err := rl.store.SlidingWindow(ctx, key, now, rl.window, hits, rl.burst)
+	if err != nil {
+		// If the store fails, allow the request (fail open)
+		rateLimitDecisions.WithLabelValues(rl.name, rateLimitFailOpen).Inc()
+		rl.logger.Warn().Err(err).Msg("Rate limiter store failed, allowing request")
+		return RateLimitDecision{Allowed: true, Unchecked: true}
+	}
 
-func (rl *MemoryRateLimiter) cleanupVisitors() {
-	for {
-		time.Sleep(time.Minute)
+	if rl.localTTL > 0 {
 		rl.mu.Lock()
-		for ip, v := range rl.visitors {
-			if time.Since(v.lastSeen) > 15*time.Minute {
-				delete(rl.visitors, ip)
+		if w, ok := rl.local[client]; ok {
+			w.count, w.oldest, w.checkedAt = count, oldest, now
+		} else {
+			if len(rl.local) >= maxLocalWindows {
+				rl.pruneLocal(now)
 			}
+			rl.local[client] = &localWindow{count: count, oldest: oldest, checkedAt: now}
 		}
 		rl.mu.Unlock()
 	}
+
+	d := rl.decision(count, oldest, now)
+	if d.Allowed {
+		rateLimitDecisions.WithLabelValues(rl.name, rateLimitAllowed).Inc()
+	} else {
+		rateLimitDecisions.WithLabelValues(rl.name, rateLimitLimited).Inc()
+	}
+	return d
+}
+
+// decision describes a window holding count hits, this request's included,
+// the oldest recorded at oldest (zero when only this request counts)
+func (rl *SlidingWindowRateLimiter) decision(count int64, oldest, now time.Time) RateLimitDecision {
+	remaining := int64(rl.rate) - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	if oldest.IsZero() {
+		oldest = now
+	}
+	return RateLimitDecision{
+		// The count includes this request, so the limit itself is still allowed
+		Allowed:   count <= int64(rl.rate),
+		Limit:     rl.rate,
+		Remaining: int(remaining),
+		Reset:     oldest.Add(rl.window),
+	}
+}
+
+// Peek decides whether a request from client would be allowed, without
+// recording it. Record counts it afterwards, for callers that only know
+// once the request has run whether it belongs to client. Like Allow, Peek
+// fails open on store errors.
+func (rl *SlidingWindowRateLimiter) Peek(client string) RateLimitDecision {
+	now := rl.now()
+	count, oldest, err := rl.store.SlidingWindowCount(context.Background(), kvstore.RateLimitKey(client), now, rl.window)
+	if err != nil {
+		rateLimitDecisions.WithLabelValues(rl.name, rateLimitFailOpen).Inc()
+		rl.logger.Warn().Err(err).Msg("Rate limiter store failed, allowing request")
+		return RateLimitDecision{Allowed: true, Unchecked: true}
+	}
+	return rl.decision(count+1, oldest, now)
+}
+
+// Record counts a request from client that Peek let through
+func (rl *SlidingWindowRateLimiter) Record(client string) {
+	if _, _, err := rl.store.SlidingWindow(context.Background(), kvstore.RateLimitKey(client), rl.now(), rl.window, 1, rl.burst); err != nil {
+		rl.logger.Warn().Err(err).Msg("Rate limiter store failed, request not counted")
+	}
+}
+
+// maxLocalWindows bounds the local cache; past it, idle clients are dropped
+const maxLocalWindows = 10000
+
+// pruneLocal drops clients not checked within a window. Their pending hits
+// are lost, which only ever errs towards allowing. Callers hold rl.mu.
+func (rl *SlidingWindowRateLimiter) pruneLocal(now time.Time) {
+	for client, w := range rl.local {
+		if now.Sub(w.checkedAt) > rl.window {
+			delete(rl.local, client)
+		}
+	}
+}
+
+// RateLimitKeyFunc picks the client a request is counted against by the
+// global rate limiter
+type RateLimitKeyFunc func(r *http.Request) string
+
+// KeyByIP counts requests per client IP
+func KeyByIP(r *http.Request) string {
+	return getClientIP(r)
+}
+
+// KeyByUser counts an authenticated user's requests together, whichever
+// address they come from, so users behind a shared NAT don't share a limit
+// and rotating IPs doesn't reset one. Other requests are counted by IP.
+func KeyByUser(r *http.Request) string {
+	if userID, ok := r.Context().Value(config.UserIDKey).(string); ok && userID != "" {
+		return kvstore.UserRateLimitClient(userID, "")
+	}
+	return getClientIP(r)
+}
+
+// KeyByUserAndIP counts an authenticated user separately on each IP. Other
+// requests are counted by IP.
+func KeyByUserAndIP(r *http.Request) string {
+	ip := getClientIP(r)
+	if userID, ok := r.Context().Value(config.UserIDKey).(string); ok && userID != "" {
+		return kvstore.UserRateLimitClient(userID, ip)
+	}
+	return ip
+}
+
+// RateLimitKeyFor returns the key function for a RATE_LIMIT_KEY value,
+// KeyByIP for anything unknown
+func RateLimitKeyFor(mode string) RateLimitKeyFunc {
+	switch mode {
+	case config.RateLimitKeyUser:
+		return KeyByUser
+	case config.RateLimitKeyUserIP:
+		return KeyByUserAndIP
+	default:
+		return KeyByIP
+	}
 }
 
+// RateLimit is the global rate limiter, counting every request by client IP.
+// It runs before authentication, so with RATE_LIMIT_KEY set to user or
+// user_ip the router skips authenticated routes here (see RateLimitExcept)
+// and counts them in UserRateLimit instead.
 func (mw *Middleware) RateLimit(next http.Handler) http.Handler {
-	// Try Redis-based rate limiting first, fallback to memory-based
-	var redisLimiter *RedisRateLimiter
-	var memoryLimiter *MemoryRateLimiter
+	return mw.RateLimitExcept()(next)
+}
 
-	if mw.app.Redis != nil {
-		redisLimiter = NewRedisRateLimiter(mw.app, mw.app.Config.RateLimit, mw.app.Config.RateLimit*2)
-	} else {
-		memoryLimiter = NewMemoryRateLimiter(mw.app.Config.RateLimit, mw.app.Config.RateLimit*2)
+// RateLimitExcept is RateLimit for every path outside prefixes. Paths under
+// prefixes are left to UserRateLimit, except for requests that never reach
+// it because authentication failed: those are still counted and limited by
+// IP, so bad credentials can't be used to get around the limit.
+func (mw *Middleware) RateLimitExcept(prefixes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limit := mw.rateLimit(limiterGlobal, KeyByIP, next)
+		unresolved := mw.unresolvedRateLimit(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(r.URL.Path, prefix) {
+					unresolved.ServeHTTP(w, r)
+					return
+				}
+			}
+			limit.ServeHTTP(w, r)
+		})
 	}
+}
+
+// userCountedKey is the context key for the flag UserRateLimit sets when it
+// counts a request
+type userCountedKey struct{}
+
+// unresolvedRateLimit counts by IP the requests UserRateLimit never sees.
+// Whether it will see one is only known once authentication has run, so
+// each such request is counted afterwards. When the IP's window is already
+// full, the response is held back until authentication decides: a request
+// it admits goes on to UserRateLimit, and one it refuses gets a 429 in
+// place of its 401.
+func (mw *Middleware) unresolvedRateLimit(next http.Handler) http.Handler {
+	limiter := mw.newRateLimiter(limiterGlobal)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestID := getRequestID(r.Context())
 		ip := getClientIP(r)
+		counted := new(atomic.Bool)
+		r = r.WithContext(context.WithValue(r.Context(), userCountedKey{}, counted))
 
-		var allowed bool
-		if redisLimiter != nil {
-			allowed = redisLimiter.Allow(ip)
+		decision := limiter.Peek(ip)
+		if decision.Allowed {
+			next.ServeHTTP(w, r)
 		} else {
-			allowed = memoryLimiter.getLimiter(ip).Allow()
+			held := &unresolvedWriter{ResponseWriter: w, counted: counted}
+			next.ServeHTTP(held, r)
+			if held.refused {
+				rateLimitDecisions.WithLabelValues(limiterGlobal, rateLimitLimited).Inc()
+				mw.rejectRateLimited(w, r, decision, ip)
+			}
+		}
+		if !counted.Load() {
+			limiter.Record(ip)
 		}
+	})
+}
 
-		if !allowed {
-			mw.app.Logger.Warn().
-				Str("request_id", requestID).
-				Str("ip", ip).
-				Msg("Rate limit exceeded")
-			writeJSONError(w, http.StatusTooManyRequests, "Rate limit exceeded", requestID)
+// unresolvedWriter drops the response to a request UserRateLimit has not
+// counted, so unresolvedRateLimit can answer it instead
+type unresolvedWriter struct {
+	http.ResponseWriter
+	counted *atomic.Bool
+	refused bool
+}
+
+func (uw *unresolvedWriter) WriteHeader(code int) {
+	if !uw.counted.Load() {
+		uw.refused = true
+		return
+	}
+	uw.ResponseWriter.WriteHeader(code)
+}
+
+func (uw *unresolvedWriter) Write(b []byte) (int, error) {
+	if !uw.counted.Load() {
+		uw.refused = true
+		return len(b), nil
+	}
+	return uw.ResponseWriter.Write(b)
+}
+
+func (uw *unresolvedWriter) Flush() {
+	if f, ok := uw.ResponseWriter.(http.Flusher); ok && uw.counted.Load() {
+		f.Flush()
+	}
+}
+
+// UserRateLimit applies the global limit to authenticated routes by the
+// RATE_LIMIT_KEY key. It goes after Authenticate, on the routes RateLimit
+// skips. With RATE_LIMIT_KEY=ip it does nothing, as RateLimit already
+// counted the request.
+func (mw *Middleware) UserRateLimit(next http.Handler) http.Handler {
+	mode := mw.app.Config.GetRateLimitKey()
+	if mode == config.RateLimitKeyIP {
+		return next
+	}
+	limit := mw.rateLimit(limiterUser, RateLimitKeyFor(mode), next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if counted, ok := r.Context().Value(userCountedKey{}).(*atomic.Bool); ok {
+			counted.Store(true)
+		}
+		limit.ServeHTTP(w, r)
+	})
+}
+
+// newRateLimiter returns a limiter for RATE_LIMIT, labelled name in
+// rateLimitDecisions
+func (mw *Middleware) newRateLimiter(name string) *SlidingWindowRateLimiter {
+	limiter := NewSlidingWindowRateLimiter(mw.kv, mw.app.Logger, mw.app.Config.RateLimit, mw.app.Config.RateLimit*2).
+		WithWindow(mw.app.Config.GetRateLimitWindow()).
+		WithLocalCache(time.Duration(mw.app.Config.RateLimitLocalCache) * time.Millisecond)
+	limiter.name = name
+	return limiter
+}
+
+// rateLimit counts requests against RATE_LIMIT by key. Each call has its own
+// local cache but shares the store windows.
+func (mw *Middleware) rateLimit(name string, key RateLimitKeyFunc, next http.Handler) http.Handler {
+	limiter := mw.newRateLimiter(name)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := key(r)
+
+		decision := limiter.Allow(client)
+		if !decision.Allowed {
+			mw.rejectRateLimited(w, r, decision, client)
 			return
 		}
+		if !decision.Unchecked {
+			setRateLimitHeaders(w, decision)
+		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
-// --- ENHANCED SECURITY MIDDLEWARE ---
-func Security(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Standard Security Headers (Apply to everything)
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		w.Header().Set("X-Frame-Options", "DENY")
-		w.Header().Set("X-XSS-Protection", "1; mode=block")
-		w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains; preload")
-		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
-		w.Header().Set("Permissions-Policy", "camera=(), microphone=(), geolocation=()")
-		w.Header().Set("Server", "")
-
-		// --- DYNAMIC CONTENT SECURITY POLICY ---
-		// Default Strict Policy (For API and App) - Blocks inline scripts
-		csp := "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline' https://fonts.googleapis.com https://cdn.jsdelivr.net; font-src 'self' https://fonts.gstatic.com https://cdn.jsdelivr.net; img-src 'self' data:; connect-src 'self'; frame-ancestors 'none'"
-
-		// Relaxed Policy (ONLY for Swagger UI) - Allows inline scripts
-		if strings.HasPrefix(r.URL.Path, "/swagger/") {
-			csp = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline' https://fonts.googleapis.com https://cdn.jsdelivr.net; font-src 'self' https://fonts.gstatic.com https://cdn.jsdelivr.net; img-src 'self' data:; connect-src 'self'; frame-ancestors 'none'"
-		}
+// setRateLimitHeaders describes decision in the X-RateLimit-* headers
+func setRateLimitHeaders(w http.ResponseWriter, decision RateLimitDecision) {
+	h := w.Header()
+	h.Set(RateLimitLimitHeader, strconv.Itoa(decision.Limit))
+	h.Set(RateLimitRemainingHeader, strconv.Itoa(decision.Remaining))
+	h.Set(RateLimitResetHeader, strconv.FormatInt(decision.Reset.Unix(), 10))
+}
 
-		w.Header().Set("Content-Security-Policy", csp)
+// rejectRateLimited answers 429 for a request from client that decision
+// refused, with Retry-After for when it frees up
+func (mw *Middleware) rejectRateLimited(w http.ResponseWriter, r *http.Request, decision RateLimitDecision, client string) {
+	requestID := getRequestID(r.Context())
+	setRateLimitHeaders(w, decision)
+	retryAfter := int(math.Ceil(time.Until(decision.Reset).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set(RetryAfterHeader, strconv.Itoa(retryAfter))
+	mw.app.Logger.Warn().
+		Str("request_id", requestID).
+		Str("ip", getClientIP(r)).
+		Str("client", client).
+		Msg("Rate limit exceeded")
+	mw.writeError(w, r, http.StatusTooManyRequests, "Rate limit exceeded", requestID)
+}
 
+// RouteRateLimit applies a tighter sliding-window limit to a single sensitive
+// route, on top of the global limiter. Callers are keyed by user ID when
+// authenticated and by IP otherwise. Like RateLimit it fails open on store errors.
+func (mw *Middleware) RouteRateLimit(name string, limit int, window time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := getRequestID(r.Context())
+			caller := getClientIP(r)
+			if userID, ok := r.Context().Value(config.UserIDKey).(string); ok && userID != "" {
+				caller = "user:" + userID
+			}
+			key := fmt.Sprintf("rate_limit:route:%s:%s", name, caller)
+
+			// Recorded, trimmed and given its expiry in one atomic step. Only
+			// limit+1 hits are kept, enough to tell the caller is over.
+			count, _, err := mw.kv.SlidingWindow(r.Context(), key, time.Now(), window, 1, limit+1)
+			if err != nil {
+				rateLimitDecisions.WithLabelValues(limiterRoute, rateLimitFailOpen).Inc()
+				mw.app.Logger.Warn().Err(err).Str("route", name).Msg("Route rate limiter store failed, allowing request")
+				next.ServeHTTP(w, r)
+				return
+			}
+			if count > int64(limit) {
+				rateLimitDecisions.WithLabelValues(limiterRoute, rateLimitLimited).Inc()
+				mw.app.Logger.Warn().
+					Str("request_id", requestID).
+					Str("route", name).
+					Str("caller", caller).
+					Msg("Route rate limit exceeded")
+				mw.writeError(w, r, http.StatusTooManyRequests, "Rate limit exceeded", requestID)
+				return
+			}
+
+			rateLimitDecisions.WithLabelValues(limiterRoute, rateLimitAllowed).Inc()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// --- CACHE HEADERS ---
+
+// NoStore marks responses as never to be stored. It goes on authenticated
+// routes; a handler that supports revalidation may override it.
+func NoStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
 		next.ServeHTTP(w, r)
 	})
 }
 
+// Cache serves next under cacheControl with a fixed ETag, answering matching
+// conditional requests with 304. The ETag is not derived from the body, so
+// use it only for responses that are fixed for the life of the build.
+func Cache(cacheControl, etag string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if NotModified(w, r, cacheControl, etag) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// NotModified sets the caching headers and, when the request's
+// If-None-Match matches etag, writes a 304 and returns true
+func NotModified(w http.ResponseWriter, r *http.Request, cacheControl, etag string) bool {
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set(ETagHeader, etag)
+	if !ETagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// ETagMatches applies the weak comparison If-None-Match requires against a
+// comma-separated header value.
+func ETagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// cspReportGroup names the Reporting-Endpoints entry report-to points at
+const cspReportGroup = "csp-endpoint"
+
+// withCSPReporting adds report-uri and report-to directives for reportURI to
+// csp. Browsers that know report-to ignore report-uri; older ones only know
+// report-uri. A policy that already says where to report is left alone.
+func withCSPReporting(csp, reportURI string) string {
+	if csp == "" || reportURI == "" || strings.Contains(csp, "report-uri") || strings.Contains(csp, "report-to") {
+		return csp
+	}
+	return fmt.Sprintf("%s; report-uri %s; report-to %s", strings.TrimRight(strings.TrimSpace(csp), ";"), reportURI, cspReportGroup)
+}
+
+// --- ENHANCED SECURITY MIDDLEWARE ---
+func Security(cfg config.SecurityHeadersConfig) func(http.Handler) http.Handler {
+	// Header values are fixed for the life of the process, so build them once
+	hsts := ""
+	if cfg.HSTSMaxAge > 0 {
+		hsts = fmt.Sprintf("max-age=%d", cfg.HSTSMaxAge)
+		if cfg.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if cfg.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+	csp, swaggerCSP := withCSPReporting(cfg.CSP, cfg.CSPReportURI), withCSPReporting(cfg.SwaggerCSP, cfg.CSPReportURI)
+	reportingEndpoints := ""
+	if cfg.CSPReportURI != "" {
+		reportingEndpoints = fmt.Sprintf("%s=%q", cspReportGroup, cfg.CSPReportURI)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Standard Security Headers (Apply to everything)
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.Header().Set("X-XSS-Protection", "1; mode=block")
+			w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
+			w.Header().Set("Server", "")
+
+			if cfg.FrameOptions != "" {
+				w.Header().Set("X-Frame-Options", cfg.FrameOptions)
+			}
+			if hsts != "" {
+				w.Header().Set("Strict-Transport-Security", hsts)
+			}
+			if cfg.PermissionsPolicy != "" {
+				w.Header().Set("Permissions-Policy", cfg.PermissionsPolicy)
+			}
+
+			// --- DYNAMIC CONTENT SECURITY POLICY ---
+			// Relaxed policy ONLY for Swagger UI, which needs inline scripts
+			policy := csp
+			if strings.HasPrefix(r.URL.Path, "/swagger/") && swaggerCSP != "" {
+				policy = swaggerCSP
+			}
+			if policy != "" {
+				w.Header().Set("Content-Security-Policy", policy)
+				if reportingEndpoints != "" {
+					w.Header().Set("Reporting-Endpoints", reportingEndpoints)
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// --- UNEXPECTED BODY MIDDLEWARE ---
+
+// maxDiscardedBody caps how much of an unexpected body is read and thrown
+// away. Anything larger closes the connection instead of tying it up.
+const maxDiscardedBody = 64 << 10
+
+// bodylessMethods never carry a meaningful body in this API
+var bodylessMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// UnexpectedBody handles bodies sent on GET, HEAD, DELETE and OPTIONS. The
+// body is always drained so a keep-alive connection is left ready for the
+// next request; with reject set the request then fails with 400, otherwise
+// the handler runs with an empty body.
+func (mw *Middleware) UnexpectedBody(reject bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// ContentLength is -1 for chunked bodies of unknown length
+			if !bodylessMethods[r.Method] || r.ContentLength == 0 || r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			n, _ := io.Copy(io.Discard, io.LimitReader(r.Body, maxDiscardedBody+1))
+			r.Body.Close()
+			if n > maxDiscardedBody {
+				// Too big to drain cheaply; don't reuse the connection
+				w.Header().Set("Connection", "close")
+			}
+
+			if reject {
+				mw.writeError(w, r, http.StatusBadRequest, "Request body not allowed for "+r.Method, getRequestID(r.Context()))
+				return
+			}
+
+			r.Body = http.NoBody
+			r.ContentLength = 0
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // --- TIMEOUT MIDDLEWARE ---
 func (mw *Middleware) Timeout(timeout time.Duration) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -361,7 +1186,8 @@ func (mw *Middleware) Timeout(timeout time.Duration) func(http.Handler) http.Han
 
 			r = r.WithContext(ctx)
 
-			done := make(chan bool)
+			// Buffered so the handler can finish after a timeout without blocking forever
+			done := make(chan bool, 1)
 			go func() {
 				next.ServeHTTP(w, r)
 				done <- true
@@ -376,7 +1202,7 @@ func (mw *Middleware) Timeout(timeout time.Duration) func(http.Handler) http.Han
 					Str("request_id", requestID).
 					Dur("timeout", timeout).
 					Msg("Request timeout")
-				writeJSONError(w, http.StatusRequestTimeout, "Request timeout", requestID)
+				mw.writeError(w, r, http.StatusRequestTimeout, "Request timeout", requestID)
 				return
 			}
 		})
@@ -392,32 +1218,26 @@ func getRequestID(ctx context.Context) string {
 	return "unknown"
 }
 
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	xff := r.Header.Get("X-Forwarded-For")
-	if xff != "" {
-		// Get the first IP (client IP)
-		ips := strings.Split(xff, ",")
-		return strings.TrimSpace(ips[0])
-	}
-
-	// Check X-Real-IP header
-	xri := r.Header.Get("X-Real-IP")
-	if xri != "" {
-		return strings.TrimSpace(xri)
-	}
+// ClientIP returns the best-effort client address for a request, using the
+// same resolution the rate limiter and access log rely on.
+func ClientIP(r *http.Request) string {
+	return getClientIP(r)
+}
 
-	// Fallback to RemoteAddr
-	ip := r.RemoteAddr
-	if colon := strings.LastIndex(ip, ":"); colon != -1 {
-		ip = ip[:colon]
+// getClientIP returns the client IP RealIP resolved, or the connection's
+// address for a request that didn't go through it
+func getClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(config.ClientIPKey).(string); ok {
+		return ip
 	}
-	return ip
+	return remoteIP(r)
 }
 
-func writeJSONError(w http.ResponseWriter, status int, message, requestID string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	response := fmt.Sprintf(`{"success":false,"error":"%s","request_id":"%s"}`, message, requestID)
-	w.Write([]byte(response))
+// bearerToken returns the token from "Authorization: Bearer <token>", if any
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
 }
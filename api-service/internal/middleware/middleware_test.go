@@ -0,0 +1,1246 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/kvstore"
+	"azlo-goboiler/internal/mocks"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/readiness"
+	"azlo-goboiler/internal/repository"
+	"azlo-goboiler/internal/service"
+	"azlo-goboiler/internal/signedurl"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testSecret = "test-secret-that-is-at-least-32-chars"
+
+// newTestApp returns an Application backed by an in-process Redis
+func newTestApp(t testing.TB) (*config.Application, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return &config.Application{
+		Config: config.Config{App_Secret: testSecret, RateLimit: 100},
+		Logger: zerolog.Nop(),
+		Redis:  client,
+	}, mr
+}
+
+func signToken(t *testing.T, claims jwt.Claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+	require.NoError(t, err)
+	return token
+}
+
+func tokenIssuedAt(t *testing.T, iat time.Time) string {
+	return signToken(t, jwt.RegisteredClaims{
+		Subject:   "user-1",
+		IssuedAt:  jwt.NewNumericDate(iat),
+		ExpiresAt: jwt.NewNumericDate(iat.Add(time.Hour)),
+	})
+}
+
+// serveJWT runs a request carrying the token cookie through the JWT middleware
+func serveJWT(mw *Middleware, token string) *httptest.ResponseRecorder {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil)
+	req.AddCookie(&http.Cookie{Name: "jwt_token", Value: token})
+	rec := httptest.NewRecorder()
+	mw.JWT(next).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestJWTGlobalRevocation(t *testing.T) {
+	app, _ := newTestApp(t)
+	store := repository.NewSessionStore(app.Redis)
+	mw := New(app, store, nil, nil, nil)
+
+	oldToken := tokenIssuedAt(t, time.Now().Add(-time.Minute))
+
+	// Before the revoke the token is accepted
+	assert.Equal(t, http.StatusOK, serveJWT(mw, oldToken).Code)
+
+	_, err := store.BumpGlobalEpoch(context.Background())
+	require.NoError(t, err)
+
+	t.Run("ExistingTokenRejected", func(t *testing.T) {
+		rec := serveJWT(mw, oldToken)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Session has been revoked")
+	})
+
+	t.Run("NewTokenAccepted", func(t *testing.T) {
+		newToken := tokenIssuedAt(t, time.Now())
+		assert.Equal(t, http.StatusOK, serveJWT(mw, newToken).Code)
+	})
+}
+
+func TestJWTRevocationRedisDown(t *testing.T) {
+	serve := func(t *testing.T, failOpen bool) int {
+		app, mr := newTestApp(t)
+		app.Config.RevocationFailOpen = failOpen
+		mw := New(app, repository.NewSessionStore(app.Redis), nil, nil, nil)
+		mr.Close()
+		return serveJWT(mw, tokenIssuedAt(t, time.Now())).Code
+	}
+
+	// By default a revoked token must not get through an outage
+	assert.Equal(t, http.StatusServiceUnavailable, serve(t, false))
+	// REVOCATION_FAIL_OPEN trades that for availability
+	assert.Equal(t, http.StatusOK, serve(t, true))
+}
+
+func TestJWTLogoutRevocation(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.Config.JWTExpirationHours = 1
+	store := repository.NewSessionStore(app.Redis)
+	users := service.NewUserService(new(mocks.MockUserRepository), store, &app.Config, nil, nil)
+	mw := New(app, store, nil, nil, nil)
+
+	session := func(userID, id string) string {
+		iat := time.Now().Add(-time.Minute)
+		return signToken(t, jwt.RegisteredClaims{
+			Subject: userID, ID: id,
+			IssuedAt:  jwt.NewNumericDate(iat),
+			ExpiresAt: jwt.NewNumericDate(iat.Add(time.Hour)),
+		})
+	}
+	laptop, phone, other := session("user-1", "session-a"), session("user-1", "session-b"), session("user-2", "session-c")
+
+	t.Run("LogoutRevokesOnlyThatToken", func(t *testing.T) {
+		require.NoError(t, users.Logout(context.Background(), laptop))
+
+		rec := serveJWT(mw, laptop)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Session has been revoked")
+		assert.Equal(t, http.StatusOK, serveJWT(mw, phone).Code)
+
+		ttl := app.Redis.TTL(context.Background(), "auth:revoked:session-a").Val()
+		assert.InDelta(t, (59*time.Minute + app.Config.GetJWTClockSkew()).Seconds(), ttl.Seconds(), 5)
+	})
+
+	t.Run("ForgedTokenIgnored", func(t *testing.T) {
+		forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "user-1", ID: "session-b"}).
+			SignedString([]byte("some-other-secret-of-at-least-32-chars"))
+		require.NoError(t, err)
+		require.NoError(t, users.Logout(context.Background(), forged))
+		assert.Equal(t, http.StatusOK, serveJWT(mw, phone).Code)
+	})
+
+	t.Run("LogoutAllDevices", func(t *testing.T) {
+		require.NoError(t, users.LogoutAllDevices(context.Background(), "user-1"))
+
+		assert.Equal(t, http.StatusUnauthorized, serveJWT(mw, phone).Code)
+		assert.Equal(t, http.StatusOK, serveJWT(mw, other).Code)
+	})
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	serve := func(cfg config.SecurityHeadersConfig, path string) http.Header {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		rec := httptest.NewRecorder()
+		Security(cfg)(next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Header()
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		h := serve(config.SecurityHeadersConfig{
+			CSP:                   config.DefaultCSP,
+			SwaggerCSP:            config.DefaultSwaggerCSP,
+			HSTSMaxAge:            63072000,
+			HSTSIncludeSubdomains: true,
+			HSTSPreload:           true,
+			FrameOptions:          "DENY",
+			PermissionsPolicy:     "camera=(), microphone=(), geolocation=()",
+		}, "/api/v1/profile")
+
+		assert.Equal(t, config.DefaultCSP, h.Get("Content-Security-Policy"))
+		assert.Equal(t, "max-age=63072000; includeSubDomains; preload", h.Get("Strict-Transport-Security"))
+		assert.Equal(t, "DENY", h.Get("X-Frame-Options"))
+		assert.Equal(t, "nosniff", h.Get("X-Content-Type-Options"))
+	})
+
+	t.Run("Reporting", func(t *testing.T) {
+		cfg := config.SecurityHeadersConfig{
+			CSP:          config.DefaultCSP,
+			SwaggerCSP:   config.DefaultSwaggerCSP,
+			CSPReportURI: config.DefaultCSPReportURI,
+		}
+
+		h := serve(cfg, "/api/v1/profile")
+		assert.Equal(t, config.DefaultCSP+"; report-uri /csp-report; report-to csp-endpoint", h.Get("Content-Security-Policy"))
+		assert.Equal(t, `csp-endpoint="/csp-report"`, h.Get("Reporting-Endpoints"))
+		assert.True(t, strings.HasSuffix(serve(cfg, "/swagger/index.html").Get("Content-Security-Policy"), "; report-to csp-endpoint"))
+
+		// A policy that already reports somewhere keeps its own destination
+		cfg.CSP = "default-src 'self'; report-uri https://reports.example.com/csp"
+		assert.Equal(t, cfg.CSP, serve(cfg, "/").Get("Content-Security-Policy"))
+	})
+
+	t.Run("Overrides", func(t *testing.T) {
+		cfg := config.SecurityHeadersConfig{
+			CSP:               "default-src 'self'; frame-ancestors https://portal.example.com",
+			SwaggerCSP:        "default-src 'self' 'unsafe-inline'",
+			HSTSMaxAge:        300,
+			FrameOptions:      "SAMEORIGIN",
+			PermissionsPolicy: "geolocation=(self)",
+		}
+
+		h := serve(cfg, "/")
+		assert.Equal(t, cfg.CSP, h.Get("Content-Security-Policy"))
+		assert.Equal(t, "max-age=300", h.Get("Strict-Transport-Security"))
+		assert.Equal(t, "SAMEORIGIN", h.Get("X-Frame-Options"))
+		assert.Equal(t, "geolocation=(self)", h.Get("Permissions-Policy"))
+
+		assert.Equal(t, cfg.SwaggerCSP, serve(cfg, "/swagger/index.html").Get("Content-Security-Policy"))
+	})
+
+	t.Run("DisabledHeadersOmitted", func(t *testing.T) {
+		h := serve(config.SecurityHeadersConfig{CSP: "default-src 'self'"}, "/")
+		assert.Empty(t, h.Get("Strict-Transport-Security"))
+		assert.Empty(t, h.Get("X-Frame-Options"))
+		assert.Empty(t, h.Get("Permissions-Policy"))
+	})
+}
+
+func TestRouteRateLimit(t *testing.T) {
+	app, mr := newTestApp(t)
+	mw := New(app, nil, nil, kvstore.NewRedis(app.Redis), nil)
+
+	handler := mw.RouteRateLimit("test", 2, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	call := func(userID string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/notifications/test", nil)
+		req = req.WithContext(context.WithValue(req.Context(), config.UserIDKey, userID))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, call("admin-1"))
+	assert.Equal(t, http.StatusOK, call("admin-1"))
+	assert.Equal(t, http.StatusTooManyRequests, call("admin-1"))
+
+	// Limits are tracked per caller
+	assert.Equal(t, http.StatusOK, call("admin-2"))
+
+	// The window's key always carries its expiry, even from the first hit
+	key := "rate_limit:route:test:user:admin-1"
+	assert.Greater(t, mr.TTL(key), time.Duration(0))
+}
+
+func TestJWTClockSkew(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.Config.JWTClockSkewSeconds = 30
+	mw := New(app, nil, nil, nil, nil)
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		claims     jwt.RegisteredClaims
+		wantStatus int
+		wantMsg    string
+	}{
+		{
+			name: "NotBeforeWithinSkew",
+			claims: jwt.RegisteredClaims{
+				Subject: "user-1", NotBefore: jwt.NewNumericDate(now.Add(10 * time.Second)),
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "NotBeforeBeyondSkew",
+			claims: jwt.RegisteredClaims{
+				Subject: "user-1", NotBefore: jwt.NewNumericDate(now.Add(2 * time.Minute)),
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantMsg:    "Token is not valid yet",
+		},
+		{
+			name: "ExpiredWithinSkew",
+			claims: jwt.RegisteredClaims{
+				Subject: "user-1", ExpiresAt: jwt.NewNumericDate(now.Add(-10 * time.Second)),
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "ExpiredBeyondSkew",
+			claims: jwt.RegisteredClaims{
+				Subject: "user-1", ExpiresAt: jwt.NewNumericDate(now.Add(-2 * time.Minute)),
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantMsg:    "Token has expired",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveJWT(mw, signToken(t, tt.claims))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantMsg != "" {
+				assert.Contains(t, rec.Body.String(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestLoggingRedactsQueryParams(t *testing.T) {
+	app, _ := newTestApp(t)
+	var buf bytes.Buffer
+	app.AccessLogger = zerolog.New(&buf)
+	mw := New(app, nil, nil, nil, nil)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	req := httptest.NewRequest(http.MethodGet, "/auth/verify-email?token=abc&page=2&Password=hunter2", nil)
+	mw.Logging(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "token=[REDACTED]&page=2&Password=[REDACTED]", entry["query"])
+	assert.NotContains(t, buf.String(), "abc")
+	assert.NotContains(t, buf.String(), "hunter2")
+}
+
+func TestLoggingNotModified(t *testing.T) {
+	app, _ := newTestApp(t)
+	var logs bytes.Buffer
+	app.AccessLogger = zerolog.New(&logs)
+	mw := New(app, nil, nil, nil, nil)
+
+	handler := mw.Logging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+		// Dropped by net/http; it must not be counted either
+		w.Write([]byte(`{"success":true}`))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil))
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.EqualValues(t, http.StatusNotModified, entry["status"])
+	assert.EqualValues(t, 0, entry["response_size"])
+}
+
+func TestRedactQueryCustomList(t *testing.T) {
+	assert.Equal(t, "session=[REDACTED]&token=abc", redactQuery("session=xyz&token=abc", []string{"session"}))
+	assert.Equal(t, "", redactQuery("", nil))
+}
+
+func TestJWTUserRevocation(t *testing.T) {
+	app, _ := newTestApp(t)
+	store := repository.NewSessionStore(app.Redis)
+	mw := New(app, store, nil, nil, nil)
+
+	token := tokenIssuedAt(t, time.Now().Add(-time.Minute))
+	_, err := store.BumpUserEpoch(context.Background(), "user-2")
+	require.NoError(t, err)
+
+	// Another user's epoch leaves this token alone
+	assert.Equal(t, http.StatusOK, serveJWT(mw, token).Code)
+
+	_, err = store.BumpUserEpoch(context.Background(), "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, serveJWT(mw, token).Code)
+}
+
+func TestJWTSingleSession(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("Password123!"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	// login runs the real login flow twice against one session store
+	login := func(t *testing.T, single bool) (*Middleware, string, string) {
+		app, _ := newTestApp(t)
+		app.Config.SingleSession = single
+		app.Config.JWTExpirationHours = 1
+		store := repository.NewSessionStore(app.Redis)
+
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByEmailOrUsername", mock.Anything, "alice", "alice").
+			Return(&models.User{ID: "user-1", Username: "alice", PasswordHash: string(hash)}, nil)
+		repo.On("UpdateLastLogin", mock.Anything, "user-1").Return(nil)
+		users := service.NewUserService(repo, store, &app.Config, nil, nil)
+
+		req := models.LoginRequest{Username: "alice", Password: "Password123!"}
+		first, err := users.Login(context.Background(), req)
+		require.NoError(t, err)
+		second, err := users.Login(context.Background(), req)
+		require.NoError(t, err)
+
+		if single {
+			assert.Equal(t, config.SessionModeSingle, second.SessionMode)
+		} else {
+			assert.Equal(t, config.SessionModeMulti, second.SessionMode)
+		}
+		return New(app, store, nil, nil, nil), first.Token, second.Token
+	}
+
+	t.Run("SingleSessionEndsFirstLogin", func(t *testing.T) {
+		mw, first, second := login(t, true)
+
+		rec := serveJWT(mw, first)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Session ended by a login elsewhere")
+		assert.Equal(t, http.StatusOK, serveJWT(mw, second).Code)
+	})
+
+	t.Run("MultiSessionKeepsBoth", func(t *testing.T) {
+		mw, first, second := login(t, false)
+
+		assert.Equal(t, http.StatusOK, serveJWT(mw, first).Code)
+		assert.Equal(t, http.StatusOK, serveJWT(mw, second).Code)
+	})
+}
+
+func TestShutdownGate(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.Readiness = readiness.NewMonitor(time.Second, zerolog.Nop())
+	mw := New(app, nil, nil, nil, nil)
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := mw.ShutdownGate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(entered)
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	inFlight := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		inFlight <- rec
+	}()
+	<-entered
+
+	app.Readiness.Drain()
+	assert.False(t, app.Readiness.Ready())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "close", rec.Header().Get("Connection"))
+	assert.Contains(t, rec.Body.String(), "Server is shutting down")
+
+	// Probes still reach their handlers, so /ready can say why it fails
+	for _, path := range []string{"/ready", "/health"} {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+	}
+
+	close(release)
+	assert.Equal(t, http.StatusOK, (<-inFlight).Code)
+}
+
+func TestJWTBearerHeader(t *testing.T) {
+	app, _ := newTestApp(t)
+	mw := New(app, nil, nil, nil, nil)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "user-1", r.Context().Value(config.UserIDKey))
+	})
+
+	serve := func(authorization string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		mw.JWT(next).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("Bearer "+tokenIssuedAt(t, time.Now())))
+	assert.Equal(t, http.StatusUnauthorized, serve("Bearer not-a-jwt"))
+	assert.Equal(t, http.StatusUnauthorized, serve(""))
+
+	serveWithCookie := func(cookie, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil)
+		req.AddCookie(&http.Cookie{Name: config.AuthCookieName, Value: cookie})
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		mw.JWT(next).ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The cookie takes precedence over the header
+	valid := tokenIssuedAt(t, time.Now())
+	assert.Equal(t, http.StatusOK, serveWithCookie(valid, "Bearer not-a-jwt").Code)
+	assert.Equal(t, http.StatusUnauthorized, serveWithCookie("not-a-jwt", "Bearer "+valid).Code)
+
+	// An empty cookie, as left behind by a cleared session, falls back to the header
+	assert.Equal(t, http.StatusOK, serveWithCookie("", "Bearer "+valid).Code)
+	rec := serveWithCookie("", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Auth cookie required")
+}
+
+func TestRequireRole(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("Password123!"), bcrypt.MinCost)
+	require.NoError(t, err)
+	app, _ := newTestApp(t)
+	app.Config.AccessTokenMinutes = 15
+	mw := New(app, nil, nil, nil, nil)
+
+	// login signs a user in through the real service, which puts the role in the token
+	login := func(t *testing.T, role string) string {
+		user := &models.User{ID: "user-1", Username: "alice", PasswordHash: string(hash), IsActive: true, Role: role}
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByEmailOrUsername", mock.Anything, "alice", "alice").Return(user, nil)
+		repo.On("UpdateLastLogin", mock.Anything, "user-1").Return(nil)
+		resp, err := service.NewUserService(repo, nil, &app.Config, nil, nil).
+			Login(context.Background(), models.LoginRequest{Username: "alice", Password: "Password123!"})
+		require.NoError(t, err)
+		assert.Equal(t, role, resp.User.Role)
+		return resp.Token
+	}
+
+	reached := false
+	admin := mw.RequireRole(models.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	serve := func(handler http.Handler, token string) *httptest.ResponseRecorder {
+		reached = false
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/db-stats", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("AdminAdmitted", func(t *testing.T) {
+		rec := serve(mw.JWT(admin), login(t, models.RoleAdmin))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, reached)
+	})
+
+	t.Run("UserRefused", func(t *testing.T) {
+		rec := serve(mw.JWT(admin), login(t, models.RoleUser))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Admin role required")
+		assert.False(t, reached)
+	})
+
+	t.Run("TokenWithoutRoleRefused", func(t *testing.T) {
+		rec := serve(mw.JWT(admin), tokenIssuedAt(t, time.Now()))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.False(t, reached)
+	})
+
+	t.Run("APIKeyCallerRefused", func(t *testing.T) {
+		// APIKey sets the user ID and scopes but no role
+		asKey := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), config.UserIDKey, "user-1")
+			ctx = context.WithValue(ctx, config.APIKeyScopesKey, []string{models.APIKeyScopeRead})
+			admin.ServeHTTP(w, r.WithContext(ctx))
+		})
+		rec := serve(asKey, "")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.False(t, reached)
+	})
+}
+
+func TestJWTAutoRefresh(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("Password123!"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	// login signs alice in through the real service, then lets the access
+	// token lapse by re-signing it with an expiry in the past
+	login := func(t *testing.T, autoRefresh bool) (*Middleware, *http.Cookie, *http.Cookie) {
+		app, _ := newTestApp(t)
+		app.Config.AutoRefresh = autoRefresh
+		app.Config.AccessTokenMinutes = 15
+		store := repository.NewSessionStore(app.Redis)
+
+		user := &models.User{ID: "user-1", Username: "alice", PasswordHash: string(hash), IsActive: true}
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByEmailOrUsername", mock.Anything, "alice", "alice").Return(user, nil)
+		repo.On("GetByID", mock.Anything, "user-1").Return(user, nil)
+		repo.On("UpdateLastLogin", mock.Anything, "user-1").Return(nil)
+		users := service.NewUserService(repo, store, &app.Config, nil, nil)
+
+		resp, err := users.Login(context.Background(), models.LoginRequest{Username: "alice", Password: "Password123!"})
+		require.NoError(t, err)
+		require.NotEmpty(t, resp.RefreshToken)
+
+		claims := &jwt.RegisteredClaims{}
+		_, err = jwt.ParseWithClaims(resp.Token, claims, func(*jwt.Token) (interface{}, error) { return []byte(testSecret), nil })
+		require.NoError(t, err)
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+		return New(app, store, nil, nil, users),
+			&http.Cookie{Name: config.AuthCookieName, Value: signToken(t, *claims)},
+			&http.Cookie{Name: config.RefreshCookieName, Value: resp.RefreshToken}
+	}
+
+	serve := func(mw *Middleware, method string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "user-1", r.Context().Value(config.UserIDKey))
+		})
+		req := httptest.NewRequest(method, "/api/v1/profile", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		mw.JWT(next).ServeHTTP(rec, req)
+		return rec
+	}
+	cookieNamed := func(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, c := range rec.Result().Cookies() {
+			if c.Name == name {
+				return c
+			}
+		}
+		return nil
+	}
+
+	t.Run("ExpiredAccessWithValidRefresh", func(t *testing.T) {
+		mw, access, refresh := login(t, true)
+		rec := serve(mw, http.MethodGet, access, refresh)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		newAccess := cookieNamed(rec, config.AuthCookieName)
+		newRefresh := cookieNamed(rec, config.RefreshCookieName)
+		require.NotNil(t, newAccess)
+		require.NotNil(t, newRefresh)
+		assert.True(t, newAccess.HttpOnly)
+		assert.NotEqual(t, refresh.Value, newRefresh.Value)
+
+		// The new access cookie works on its own
+		assert.Equal(t, http.StatusOK, serve(mw, http.MethodGet, newAccess).Code)
+	})
+
+	t.Run("RefreshCookieRotated", func(t *testing.T) {
+		mw, access, refresh := login(t, true)
+		require.Equal(t, http.StatusOK, serve(mw, http.MethodGet, access, refresh).Code)
+
+		// The first refresh spent the cookie
+		assert.Equal(t, http.StatusUnauthorized, serve(mw, http.MethodGet, access, refresh).Code)
+	})
+
+	t.Run("NoRefreshCookie", func(t *testing.T) {
+		mw, access, _ := login(t, true)
+		rec := serve(mw, http.MethodGet, access)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Token has expired")
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		mw, access, refresh := login(t, false)
+		rec := serve(mw, http.MethodGet, access, refresh)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Empty(t, rec.Result().Cookies())
+	})
+
+	t.Run("UnsafeMethodAskedToRetry", func(t *testing.T) {
+		mw, access, refresh := login(t, true)
+		rec := serve(mw, http.MethodPost, access, refresh)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, "true", rec.Header().Get("X-Auth-Retry"))
+		assert.NotNil(t, cookieNamed(rec, config.AuthCookieName))
+	})
+
+	t.Run("RevokedRefreshRejected", func(t *testing.T) {
+		mw, access, refresh := login(t, true)
+		// An epoch a second ahead, as a revoke-all issued after the login
+		epoch := time.Now().Add(time.Second).Unix()
+		require.NoError(t, mw.app.Redis.Set(context.Background(), "auth:token_epoch:global", epoch, 0).Err())
+		assert.Equal(t, http.StatusUnauthorized, serve(mw, http.MethodGet, access, refresh).Code)
+	})
+
+	t.Run("RefreshTokenNotAcceptedAsAccess", func(t *testing.T) {
+		mw, _, refresh := login(t, true)
+		rec := serve(mw, http.MethodGet, &http.Cookie{Name: config.AuthCookieName, Value: refresh.Value})
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("ForgedExpiredAccessNotRefreshed", func(t *testing.T) {
+		mw, access, refresh := login(t, true)
+		forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+			Subject:   "user-1",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		}).SignedString([]byte("some-other-secret-of-at-least-32-chars"))
+		require.NoError(t, err)
+
+		rec := serve(mw, http.MethodGet, &http.Cookie{Name: config.AuthCookieName, Value: forged}, refresh)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Invalid token")
+		assert.Empty(t, rec.Result().Cookies())
+
+		// The refresh cookie was never spent on the forged token
+		assert.Equal(t, http.StatusOK, serve(mw, http.MethodGet, access, refresh).Code)
+	})
+}
+
+func TestJWTUnverifiedClaims(t *testing.T) {
+	admin := config.AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "mallory",
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Role: "admin",
+	}
+	wrongSecret, err := jwt.NewWithClaims(jwt.SigningMethodHS256, admin).SignedString([]byte("some-other-secret-of-at-least-32-chars"))
+	require.NoError(t, err)
+	algNone, err := jwt.NewWithClaims(jwt.SigningMethodNone, admin).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+
+	// tampered swaps a genuine user token's payload for the admin claims,
+	// keeping its signature
+	genuine := signToken(t, config.AccessClaims{RegisteredClaims: admin.RegisteredClaims, Role: "user"})
+	payload, err := json.Marshal(admin)
+	require.NoError(t, err)
+	parts := strings.Split(genuine, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+
+	expiredForged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, config.AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "mallory",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+		},
+		Role: "admin",
+	}).SignedString([]byte("some-other-secret-of-at-least-32-chars"))
+	require.NoError(t, err)
+
+	for name, token := range map[string]string{
+		"WrongSecret":   wrongSecret,
+		"AlgNone":       algNone,
+		"Tampered":      tampered,
+		"ExpiredForged": expiredForged,
+	} {
+		t.Run(name, func(t *testing.T) {
+			app, _ := newTestApp(t)
+			var logs bytes.Buffer
+			app.Logger = zerolog.New(&logs)
+			mw := New(app, nil, nil, nil, nil)
+
+			claims, err := mw.verifyToken(token)
+			require.Error(t, err)
+			assert.Nil(t, claims, "unverified claims are never handed back")
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Error("handler reached with an unverified token")
+			})
+			handler := mw.JWT(mw.RequireRole("admin")(next))
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/users", nil)
+			req.AddCookie(&http.Cookie{Name: config.AuthCookieName, Value: token})
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			assert.Contains(t, rec.Body.String(), "Invalid token")
+			assert.NotContains(t, logs.String(), "mallory", "the unverified subject is not logged")
+		})
+	}
+}
+
+func TestUnexpectedBody(t *testing.T) {
+	// serve sends a GET with a body and then a second request on the same
+	// keep-alive connection, returning both status lines
+	app, _ := newTestApp(t)
+	mw := New(app, nil, nil, nil, nil)
+
+	serve := func(t *testing.T, reject bool) (first, second string) {
+		handler := mw.UnexpectedBody(reject)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			assert.Empty(t, body, "handler must not see the unexpected body")
+			w.WriteHeader(http.StatusOK)
+		}))
+		srv := httptest.NewServer(handler)
+		t.Cleanup(srv.Close)
+
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+		payload := `{"filter":"ignored"}`
+		_, err = fmt.Fprintf(conn, "GET /api/v1/users HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(payload), payload)
+		require.NoError(t, err)
+
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		_, err = fmt.Fprint(conn, "GET /health HTTP/1.1\r\nHost: test\r\n\r\n")
+		require.NoError(t, err)
+		resp2, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err, "connection must stay usable after an unexpected body")
+		resp2.Body.Close()
+
+		return resp.Status, resp2.Status
+	}
+
+	t.Run("IgnoredByDefault", func(t *testing.T) {
+		first, second := serve(t, false)
+		assert.Equal(t, "200 OK", first)
+		assert.Equal(t, "200 OK", second)
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		first, second := serve(t, true)
+		assert.Equal(t, "400 Bad Request", first)
+		assert.Equal(t, "200 OK", second)
+	})
+
+	t.Run("BodiesOnPOSTUntouched", func(t *testing.T) {
+		var got string
+		handler := mw.UnexpectedBody(true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			got = string(b)
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"a":1}`)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `{"a":1}`, got)
+	})
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	app, _ := newTestApp(t)
+	mw := New(app, nil, nil, nil, nil)
+
+	// decode stands in for a handler's JSON decoding: a body over the limit
+	// is a 413, any other it can't read a 400
+	decode := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				WriteError(w, r, "", http.StatusRequestEntityTooLarge, "Request body too large", "req-1", nil)
+				return
+			}
+			WriteError(w, r, "", http.StatusBadRequest, "Invalid request format", "req-1", nil)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	payload := func(size int) string {
+		return `{"bio":"` + strings.Repeat("a", size-len(`{"bio":""}`)) + `"}`
+	}
+	serve := func(handler http.Handler, body string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(body))
+		if chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	limited := mw.MaxBodyBytes(1024)(decode)
+
+	t.Run("Exceeded", func(t *testing.T) {
+		for _, chunked := range []bool{false, true} {
+			rec := serve(limited, payload(1025), chunked)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, "chunked=%v", chunked)
+		}
+	})
+
+	t.Run("WithinLimit", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, serve(limited, payload(1024), false).Code)
+		assert.Equal(t, http.StatusCreated, serve(limited, payload(1024), true).Code)
+		assert.Equal(t, http.StatusBadRequest, serve(limited, "not json", false).Code, "other bad bodies stay 400")
+	})
+
+	t.Run("RouteOverride", func(t *testing.T) {
+		// A route wrapped again gets its own limit in place of the global one
+		upload := mw.MaxBodyBytes(1024)(mw.MaxBodyBytes(8192)(decode))
+		assert.Equal(t, http.StatusCreated, serve(upload, payload(4096), false).Code)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, serve(upload, payload(8193), true).Code)
+
+		strict := mw.MaxBodyBytes(1024)(mw.MaxBodyBytes(64)(decode))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, serve(strict, payload(65), false).Code)
+
+		// Through a router, with the global limit as router middleware
+		router := mux.NewRouter()
+		router.Use(mw.MaxBodyBytes(1024))
+		router.Handle("/upload", mw.MaxBodyBytes(8192)(decode))
+		router.Handle("/auth/register", decode)
+		req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(payload(4096)))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, serve(router, payload(4096), false).Code)
+	})
+
+	t.Run("ReplacedBodyKeepsOuterLimit", func(t *testing.T) {
+		// Once something in between has read and replaced the body, it was
+		// read under the global limit, which still applies
+		buffer := func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+				} else {
+					r.Body = io.NopCloser(bytes.NewReader(body))
+				}
+				next.ServeHTTP(w, r)
+			})
+		}
+		upload := mw.MaxBodyBytes(1024)(buffer(mw.MaxBodyBytes(8192)(decode)))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, serve(upload, payload(4096), false).Code)
+		assert.Equal(t, http.StatusCreated, serve(upload, payload(1024), false).Code)
+	})
+}
+
+func TestErrorResponsesCarryRequestID(t *testing.T) {
+	app, _ := newTestApp(t)
+	mw := New(app, nil, nil, nil, nil)
+	panics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	cases := map[string]struct {
+		handler http.Handler
+		req     *http.Request
+		status  int
+	}{
+		"MissingToken":   {mw.JWT(ok), httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil), http.StatusUnauthorized},
+		"Panic":          {mw.Recovery(panics), httptest.NewRequest(http.MethodGet, "/", nil), http.StatusInternalServerError},
+		"UnexpectedBody": {mw.UnexpectedBody(true)(ok), httptest.NewRequest(http.MethodGet, "/", strings.NewReader("x")), http.StatusBadRequest},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			tc.req.Header.Set("X-Request-ID", "req-"+name)
+			rec := httptest.NewRecorder()
+			mw.RequestID(tc.handler).ServeHTTP(rec, tc.req)
+
+			require.Equal(t, tc.status, rec.Code)
+			var body map[string]interface{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, "req-"+name, body["request_id"])
+			assert.Equal(t, false, body["success"])
+			assert.NotEmpty(t, body["code"])
+		})
+	}
+
+	t.Run("MessageIsEscaped", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mw.writeError(rec, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusBadRequest, `bad "input"`, "req-1")
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, `bad "input"`, body["error"])
+	})
+}
+
+func TestErrorPage(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.Readiness = readiness.NewMonitor(time.Second, zerolog.Nop())
+	app.Readiness.Drain()
+	mw := New(app, nil, nil, nil, nil)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	serve := func(handler http.Handler, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil)
+		req.Header.Set("X-Request-ID", "req-page")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		mw.RequestID(handler).ServeHTTP(rec, req)
+		return rec
+	}
+	const browser = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+	t.Run("BrowserGetsHTML", func(t *testing.T) {
+		for _, handler := range []http.Handler{mw.ShutdownGate(ok), mw.JWT(ok)} {
+			rec := serve(handler, browser)
+			assert.Contains(t, rec.Header().Get("Content-Type"), "text/html")
+			assert.Contains(t, rec.Header().Values("Vary"), "Accept")
+			assert.Contains(t, rec.Body.String(), "<!DOCTYPE html>")
+			assert.Contains(t, rec.Body.String(), "req-page")
+		}
+		rec := serve(mw.ShutdownGate(ok), browser)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Contains(t, rec.Body.String(), "503 Service Unavailable")
+		assert.Contains(t, rec.Body.String(), "Server is shutting down")
+	})
+
+	t.Run("JSONClientsGetEnvelope", func(t *testing.T) {
+		for _, accept := range []string{"", "*/*", "application/json", "text/html;q=0.5, application/json", "application/vnd.azlo.v1+json"} {
+			rec := serve(mw.ShutdownGate(ok), accept)
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code, accept)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"), accept)
+			var body map[string]interface{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body), accept)
+			assert.Equal(t, "Server is shutting down", body["error"])
+			assert.Equal(t, "req-page", body["request_id"])
+		}
+	})
+
+	t.Run("MessageIsEscaped", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", "text/html")
+		rec := httptest.NewRecorder()
+		mw.writeError(rec, req, http.StatusBadRequest, "<script>alert(1)</script>", "req-1")
+		assert.NotContains(t, rec.Body.String(), "<script>")
+		assert.Contains(t, rec.Body.String(), "&lt;script&gt;")
+	})
+
+	t.Run("CustomTemplate", func(t *testing.T) {
+		builtIn := errorPage.Load()
+		t.Cleanup(func() { errorPage.Store(builtIn) })
+
+		path := filepath.Join(t.TempDir(), "error.html")
+		require.NoError(t, os.WriteFile(path, []byte(`<p>{{.Code}}: {{.Message}}</p>`), 0o600))
+		require.NoError(t, ConfigureErrorPage(path))
+
+		rec := serve(mw.ShutdownGate(ok), browser)
+		assert.Equal(t, "<p>service_unavailable: Server is shutting down</p>", rec.Body.String())
+
+		require.Error(t, ConfigureErrorPage(filepath.Join(t.TempDir(), "missing.html")))
+		require.NoError(t, ConfigureErrorPage(""))
+	})
+}
+
+func TestVerifySignedURL(t *testing.T) {
+	app, _ := newTestApp(t)
+	mw := New(app, nil, nil, nil, nil)
+	handler := mw.VerifySignedURL(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	get := func(target string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec.Code
+	}
+
+	signer := signedurl.New(testSecret)
+	link, _, err := signer.GenerateSignedURL("/files/avatars/u1.png", time.Hour)
+	require.NoError(t, err)
+
+	t.Run("Valid", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, get(link))
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		past := signer.WithClock(func() time.Time { return time.Now().Add(-2 * time.Hour) })
+		expired, _, err := past.GenerateSignedURL("/files/avatars/u1.png", time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusGone, get(expired))
+	})
+
+	t.Run("TamperedPath", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, get(strings.Replace(link, "u1.png", "u2.png", 1)))
+	})
+
+	t.Run("Unsigned", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, get("/files/avatars/u1.png"))
+	})
+}
+
+func TestAcceptVersion(t *testing.T) {
+	app, _ := newTestApp(t)
+	mw := New(app, nil, nil, nil, nil)
+
+	serve := func(accept ...string) (*httptest.ResponseRecorder, int) {
+		var got int
+		handler := mw.AcceptVersion(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = RequestedVersion(r.Context())
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil)
+		for _, a := range accept {
+			req.Header.Add("Accept", a)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec, got
+	}
+
+	t.Run("Recognized", func(t *testing.T) {
+		rec, version := serve("application/vnd.azlo.v1+json")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, 1, version)
+		assert.Equal(t, "Accept", rec.Header().Get("Vary"))
+	})
+
+	t.Run("RecognizedAmongOthers", func(t *testing.T) {
+		rec, version := serve("text/html, application/vnd.azlo.v1+json;q=0.9")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, 1, version)
+	})
+
+	t.Run("UnspecifiedDefaults", func(t *testing.T) {
+		for _, accept := range [][]string{nil, {"application/json"}, {"*/*"}} {
+			rec, version := serve(accept...)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, DefaultAPIVersion, version)
+		}
+	})
+
+	t.Run("UnsupportedIs406", func(t *testing.T) {
+		for _, accept := range []string{"application/vnd.azlo.v2+json", "application/vnd.azlo.vX+json", "application/vnd.azlo.v1+xml"} {
+			rec, _ := serve(accept)
+			assert.Equal(t, http.StatusNotAcceptable, rec.Code, accept)
+			assert.Contains(t, rec.Body.String(), "application/vnd.azlo.v1+json")
+		}
+	})
+}
+
+func TestIPFilter(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.Config.TrustedProxyCIDRs = []string{"10.0.0.1"}
+	mw := New(app, nil, nil, nil, nil)
+	prefixes := func(cidrs ...string) []netip.Prefix {
+		var out []netip.Prefix
+		for _, cidr := range cidrs {
+			out = append(out, netip.MustParsePrefix(cidr))
+		}
+		return out
+	}
+	serve := func(handler func(http.Handler) http.Handler, remoteAddr, xff string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+		rec := httptest.NewRecorder()
+		mw.RealIP(handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("Deny", func(t *testing.T) {
+		deny := mw.IPFilter(nil, prefixes("203.0.113.0/24", "2001:db8::1/128"))
+		assert.Equal(t, http.StatusForbidden, serve(deny, "203.0.113.9:1234", ""))
+		assert.Equal(t, http.StatusForbidden, serve(deny, "10.0.0.1:1234", "203.0.113.9"), "X-Forwarded-For client")
+		assert.Equal(t, http.StatusOK, serve(deny, "198.51.100.1:1234", "203.0.113.9"), "X-Forwarded-For from an untrusted address")
+		assert.Equal(t, http.StatusForbidden, serve(deny, "[2001:db8::1]:1234", ""))
+		assert.Equal(t, http.StatusOK, serve(deny, "198.51.100.1:1234", ""))
+		assert.Equal(t, http.StatusOK, serve(deny, "garbage", ""), "unreadable IPs are not denied")
+	})
+
+	t.Run("Allow", func(t *testing.T) {
+		allow := mw.IPFilter(prefixes("10.0.0.0/8"), prefixes("10.6.6.6/32"))
+		assert.Equal(t, http.StatusOK, serve(allow, "10.1.2.3:1234", ""))
+		assert.Equal(t, http.StatusOK, serve(allow, "[::ffff:10.1.2.3]:1234", ""), "IPv4-mapped")
+		assert.Equal(t, http.StatusForbidden, serve(allow, "198.51.100.1:1234", ""))
+		assert.Equal(t, http.StatusForbidden, serve(allow, "10.6.6.6:1234", ""), "deny wins")
+		assert.Equal(t, http.StatusForbidden, serve(allow, "garbage", ""))
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(mw.IPFilter(nil, nil), "203.0.113.9:1234", ""))
+	})
+}
+
+// trustedUsers resolves proxy-named users from a map
+type trustedUsers map[string]*models.User
+
+func (u trustedUsers) TrustedUser(_ context.Context, name, _ string) (*models.User, error) {
+	if user, ok := u[name]; ok {
+		return user, nil
+	}
+	return nil, core.ErrTrustedUserUnknown
+}
+
+func TestTrustedHeader(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.Config.TrustedAuthHeader = "X-Auth-Request-User"
+	app.Config.TrustedProxyCIDRs = []string{"10.0.0.0/8"}
+	mw := New(app, nil, nil, nil, nil)
+	users := trustedUsers{"alice": {ID: "user-1", Role: models.RoleAdmin}}
+
+	var gotUser, gotRole string
+	handler := mw.TrustedHeader(users)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, _ = r.Context().Value(config.UserIDKey).(string)
+		gotRole, _ = r.Context().Value(config.UserRoleKey).(string)
+	}))
+	serve := func(remoteAddr, user string) *httptest.ResponseRecorder {
+		gotUser, gotRole = "", ""
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Auth-Request-User", user)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("TrustedProxy", func(t *testing.T) {
+		rec := serve("10.1.2.3:41000", "alice")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "user-1", gotUser)
+		assert.Equal(t, models.RoleAdmin, gotRole)
+
+		rec = serve("10.1.2.3:41000", "mallory")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Empty(t, gotUser)
+	})
+
+	t.Run("UntrustedSourceIgnored", func(t *testing.T) {
+		rec := serve("203.0.113.9:41000", "alice")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "falls through to token auth")
+		assert.Empty(t, gotUser)
+
+		// X-Forwarded-For names the client, not the connection, so it can't
+		// make a request look like it came from the proxy
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil)
+		req.RemoteAddr = "203.0.113.9:41000"
+		req.Header.Set("X-Forwarded-For", "10.1.2.3")
+		req.Header.Set("X-Auth-Request-User", "alice")
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Empty(t, gotUser)
+	})
+
+	t.Run("TokenStillAccepted", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil)
+		req.RemoteAddr = "10.1.2.3:41000"
+		req.Header.Set("Authorization", "Bearer "+tokenIssuedAt(t, time.Now()))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEmpty(t, gotUser)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		app, _ := newTestApp(t)
+		app.Config.TrustedProxyCIDRs = []string{"10.0.0.0/8"}
+		gotUser = ""
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil)
+		req.RemoteAddr = "10.1.2.3:41000"
+		req.Header.Set("X-Auth-Request-User", "alice")
+		New(app, nil, nil, nil, nil).TrustedHeader(users)(handler).ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Empty(t, gotUser)
+	})
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"azlo-goboiler/internal/config"
+)
+
+// passwordChangeExempt lists the requests a session that must change its
+// password may still make: the change itself, and signing out everywhere
+var passwordChangeExempt = map[string]bool{
+	"PUT /api/v1/password":            true,
+	"POST /api/v1/profile/logout-all": true,
+}
+
+// PasswordChangeGate refuses every other request with a 403 while the
+// session's token says the password must be changed, as it does for the
+// seeded admin until the initial password is replaced. The flag comes from
+// the token, so it costs no lookup; the change issues a token without it.
+// It must run after JWT or Authenticate.
+func (mw *Middleware) PasswordChangeGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mustChange, _ := r.Context().Value(config.MustChangePasswordKey).(bool)
+		if !mustChange || passwordChangeExempt[r.Method+" "+strings.TrimSuffix(r.URL.Path, "/")] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID, _ := r.Context().Value(config.UserIDKey).(string)
+		mw.app.Logger.Warn().
+			Str("request_id", getRequestID(r.Context())).
+			Str("user_id", userID).
+			Str("path", r.URL.Path).
+			Msg("Request refused until the password is changed")
+		mw.writeError(w, r, http.StatusForbidden, "Password change required", getRequestID(r.Context()))
+	})
+}
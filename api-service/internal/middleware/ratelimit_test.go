@@ -0,0 +1,454 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/kvstore"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// limiterStores runs a test against every KVStore implementation
+func limiterStores(t *testing.T, run func(t *testing.T, store core.KVStore)) {
+	t.Run("Redis", func(t *testing.T) {
+		app, _ := newTestApp(t)
+		run(t, kvstore.NewRedis(app.Redis))
+	})
+	t.Run("Memory", func(t *testing.T) {
+		run(t, kvstore.NewMemory())
+	})
+}
+
+// windowCount reads a client's stored count without recording a hit
+func windowCount(t *testing.T, store core.KVStore, ip string) int64 {
+	n, _, err := store.SlidingWindow(context.Background(), "rate_limit:"+ip, time.Now(), rateLimitWindow, 0, 0)
+	assert.NoError(t, err)
+	return n
+}
+
+func TestSlidingWindowRateLimiter(t *testing.T) {
+	limiterStores(t, func(t *testing.T, store core.KVStore) {
+		rl := NewSlidingWindowRateLimiter(store, zerolog.Nop(), 3, 6)
+
+		// Requests landing in the same instant are still counted separately
+		for i := 0; i < 3; i++ {
+			assert.True(t, rl.Allow("10.0.0.1").Allowed, "request %d", i+1)
+		}
+		assert.False(t, rl.Allow("10.0.0.1").Allowed)
+		assert.True(t, rl.Allow("10.0.0.2").Allowed)
+		assert.Equal(t, int64(4), windowCount(t, store, "10.0.0.1"))
+	})
+}
+
+func TestSlidingWindowRateLimiterSameInstant(t *testing.T) {
+	const rate = 5
+	clock := time.Now()
+	frozen := func() time.Time { return clock }
+
+	limiterStores(t, func(t *testing.T, store core.KVStore) {
+		rl := NewSlidingWindowRateLimiter(store, zerolog.Nop(), rate, 2*rate)
+		rl.now = frozen
+		for i := 1; i <= rate; i++ {
+			assert.True(t, rl.Allow("10.0.0.1").Allowed, "request %d", i)
+		}
+		assert.False(t, rl.Allow("10.0.0.1").Allowed, "request %d", rate+1)
+	})
+
+	t.Run("InstancesSharingRedis", func(t *testing.T) {
+		app, _ := newTestApp(t)
+		instances := []*SlidingWindowRateLimiter{
+			NewSlidingWindowRateLimiter(kvstore.NewRedis(app.Redis), zerolog.Nop(), rate, 2*rate),
+			NewSlidingWindowRateLimiter(kvstore.NewRedis(app.Redis), zerolog.Nop(), rate, 2*rate),
+		}
+		for _, rl := range instances {
+			rl.now = frozen
+		}
+		for i := 1; i <= rate; i++ {
+			assert.True(t, instances[i%2].Allow("10.0.0.1").Allowed, "request %d", i)
+		}
+		assert.False(t, instances[0].Allow("10.0.0.1").Allowed, "request %d", rate+1)
+	})
+}
+
+func TestSlidingWindowRateLimiterWindowSize(t *testing.T) {
+	// Three requests, then a fourth rejected; how long until the client is
+	// let back in depends only on the window
+	cases := []struct {
+		window  time.Duration
+		wait    time.Duration
+		allowed bool
+	}{
+		{window: 10 * time.Second, wait: 11 * time.Second, allowed: true},
+		{window: time.Minute, wait: 11 * time.Second, allowed: false},
+		{window: time.Minute, wait: 61 * time.Second, allowed: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s_after_%s", tc.window, tc.wait), func(t *testing.T) {
+			limiterStores(t, func(t *testing.T, store core.KVStore) {
+				clock := time.Now()
+				rl := NewSlidingWindowRateLimiter(store, zerolog.Nop(), 3, 6).WithWindow(tc.window)
+				rl.now = func() time.Time { return clock }
+
+				for i := 0; i < 3; i++ {
+					assert.True(t, rl.Allow("10.0.0.1").Allowed, "request %d", i+1)
+				}
+				assert.False(t, rl.Allow("10.0.0.1").Allowed)
+
+				clock = clock.Add(tc.wait)
+				assert.Equal(t, tc.allowed, rl.Allow("10.0.0.1").Allowed)
+			})
+		})
+	}
+}
+
+func TestSlidingWindowRateLimiterReset(t *testing.T) {
+	// A client that used its limit early is told to come back when its
+	// oldest hit leaves the window, not a whole window from now
+	limiterStores(t, func(t *testing.T, store core.KVStore) {
+		start := time.Now().Truncate(time.Millisecond)
+		clock := start
+		rl := NewSlidingWindowRateLimiter(store, zerolog.Nop(), 3, 6)
+		rl.now = func() time.Time { return clock }
+
+		assert.Equal(t, start.Add(rateLimitWindow), rl.Allow("10.0.0.1").Reset, "the first hit is its own oldest")
+		clock = start.Add(20 * time.Second)
+		rl.Allow("10.0.0.1")
+		rl.Allow("10.0.0.1")
+
+		clock = start.Add(40 * time.Second)
+		d := rl.Allow("10.0.0.1")
+		assert.False(t, d.Allowed)
+		assert.Equal(t, start.Add(rateLimitWindow), d.Reset)
+		assert.Equal(t, start.Add(rateLimitWindow), rl.Peek("10.0.0.1").Reset)
+
+		// Once the first hit leaves, the next oldest sets the reset
+		clock = start.Add(rateLimitWindow + time.Second)
+		assert.Equal(t, start.Add(20*time.Second+rateLimitWindow), rl.Peek("10.0.0.1").Reset)
+	})
+}
+
+func TestSlidingWindowRateLimiterCapsStoredHits(t *testing.T) {
+	limiterStores(t, func(t *testing.T, store core.KVStore) {
+		rl := NewSlidingWindowRateLimiter(store, zerolog.Nop(), 3, 6)
+
+		// A flood stays limited but never stores more than burst hits
+		for i := 0; i < 50; i++ {
+			rl.Allow("10.0.0.1")
+		}
+		assert.False(t, rl.Allow("10.0.0.1").Allowed)
+		assert.Equal(t, int64(6), windowCount(t, store, "10.0.0.1"))
+	})
+}
+
+func TestSlidingWindowRateLimiterLocalCache(t *testing.T) {
+	limiterStores(t, func(t *testing.T, store core.KVStore) {
+		rl := NewSlidingWindowRateLimiter(store, zerolog.Nop(), 10, 20).WithLocalCache(time.Hour)
+
+		// The first request always checks the store
+		assert.True(t, rl.Allow("10.0.0.1").Allowed)
+		assert.Equal(t, int64(1), windowCount(t, store, "10.0.0.1"))
+
+		// Well under half the limit, requests are allowed locally
+		for i := 0; i < 3; i++ {
+			assert.True(t, rl.Allow("10.0.0.1").Allowed)
+		}
+		assert.Equal(t, int64(1), windowCount(t, store, "10.0.0.1"))
+
+		// Reaching half the limit flushes the pending hits with this request
+		assert.True(t, rl.Allow("10.0.0.1").Allowed)
+		assert.Equal(t, int64(5), windowCount(t, store, "10.0.0.1"))
+
+		// From here every request goes to the store and the limit holds exactly
+		for i := 0; i < 5; i++ {
+			assert.True(t, rl.Allow("10.0.0.1").Allowed)
+		}
+		assert.False(t, rl.Allow("10.0.0.1").Allowed)
+		assert.Equal(t, int64(11), windowCount(t, store, "10.0.0.1"))
+	})
+}
+
+func TestRateLimitFailsOpen(t *testing.T) {
+	app, mr := newTestApp(t)
+	mw := New(app, nil, nil, kvstore.NewRedis(app.Redis), nil)
+	handler := mw.RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+	decisions := func(result string) float64 {
+		return testutil.ToFloat64(rateLimitDecisions.WithLabelValues(limiterGlobal, result))
+	}
+
+	allowed, failOpen := decisions(rateLimitAllowed), decisions(rateLimitFailOpen)
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, allowed+1, decisions(rateLimitAllowed))
+	assert.Equal(t, failOpen, decisions(rateLimitFailOpen))
+
+	// With Redis failing the request still goes through, but is counted as
+	// unchecked rather than as under the limit
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, allowed+1, decisions(rateLimitAllowed))
+	assert.Equal(t, failOpen+1, decisions(rateLimitFailOpen))
+
+	mr.SetError("")
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, allowed+2, decisions(rateLimitAllowed))
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	app, mr := newTestApp(t)
+	app.Config.RateLimit = 2
+	mw := New(app, nil, nil, kvstore.NewRedis(app.Redis), nil)
+	handler := mw.RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec
+	}
+
+	start := time.Now()
+	for _, remaining := range []string{"1", "0"} {
+		rec := serve()
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, remaining, rec.Header().Get("X-RateLimit-Remaining"))
+		assert.Empty(t, rec.Header().Get("Retry-After"))
+
+		reset, err := strconv.ParseInt(rec.Header().Get("X-RateLimit-Reset"), 10, 64)
+		assert.NoError(t, err)
+		assert.InDelta(t, start.Add(rateLimitWindow).Unix(), reset, 1)
+	}
+
+	rec := serve()
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+	retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	assert.NoError(t, err)
+	assert.InDelta(t, rateLimitWindow.Seconds(), retryAfter, 1)
+
+	// Unchecked requests get no headers rather than made-up figures
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	rec = serve()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("X-RateLimit-Remaining"))
+}
+
+func TestAbuseLimit(t *testing.T) {
+	limiterStores(t, func(t *testing.T, store core.KVStore) {
+		app, _ := newTestApp(t)
+		app.Config.AbuseLimit = 5
+		mw := New(app, nil, nil, store, nil)
+
+		handler := mw.AbuseLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/fail":
+				w.WriteHeader(http.StatusUnauthorized)
+			case "/throttled":
+				w.WriteHeader(http.StatusTooManyRequests)
+			case "/broken":
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}))
+		serve := func(ip, path string) int {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.RemoteAddr = ip + ":1234"
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		// Same volume from each client; only the failing one is refused, and
+		// only once its failures reach the limit
+		for i := 1; i <= 20; i++ {
+			assert.Equal(t, http.StatusOK, serve("10.0.0.1", "/ok"), "request %d", i)
+
+			want := http.StatusUnauthorized
+			if i > 5 {
+				want = http.StatusTooManyRequests
+			}
+			assert.Equal(t, want, serve("10.0.0.2", "/fail"), "request %d", i)
+		}
+
+		// A refused client is refused on every route, successful or not
+		assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.2", "/ok"))
+
+		// Server errors and other limiters' 429s are not the client's abuse
+		for i := 0; i < 20; i++ {
+			assert.Equal(t, http.StatusInternalServerError, serve("10.0.0.3", "/broken"))
+			assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.3", "/throttled"))
+		}
+		assert.Equal(t, http.StatusOK, serve("10.0.0.3", "/ok"))
+	})
+}
+
+func TestAbuseLimitDisabled(t *testing.T) {
+	app, _ := newTestApp(t)
+	mw := New(app, nil, nil, nil, nil)
+
+	handler := mw.AbuseLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	for i := 0; i < 50; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, "request %d", i+1)
+	}
+}
+
+func TestUserConcurrencyLimit(t *testing.T) {
+	const limit = 3
+	limiterStores(t, func(t *testing.T, store core.KVStore) {
+		app, _ := newTestApp(t)
+		app.Config.UserConcurrencyLimit = limit
+		mw := New(app, nil, nil, store, nil)
+
+		// Requests to /slow hold their slot until hold is closed
+		started, hold := make(chan struct{}), make(chan struct{})
+		handler := mw.UserConcurrencyLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				started <- struct{}{}
+				<-hold
+			}
+		}))
+		serve := func(userID, path string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			if userID != "" {
+				req = req.WithContext(context.WithValue(req.Context(), config.UserIDKey, userID))
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec
+		}
+
+		done := make(chan int, limit)
+		for i := 0; i < limit; i++ {
+			go func() { done <- serve("user-1", "/slow").Code }()
+			<-started
+		}
+
+		rec := serve("user-1", "/fast")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code, "request %d", limit+1)
+		assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+		assert.Equal(t, http.StatusOK, serve("user-2", "/fast").Code, "other users are unaffected")
+		assert.Equal(t, http.StatusOK, serve("", "/fast").Code, "requests without a user pass")
+
+		close(hold)
+		for i := 0; i < limit; i++ {
+			assert.Equal(t, http.StatusOK, <-done)
+		}
+		assert.Equal(t, http.StatusOK, serve("user-1", "/fast").Code, "finished requests free their slots")
+	})
+}
+
+// pipelineAllow is the previous four-command pipeline implementation, kept
+// here as the baseline for BenchmarkRedisRateLimiter.
+func pipelineAllow(client *redis.Client, ip string, limit int) bool {
+	ctx := context.Background()
+	key := fmt.Sprintf("rate_limit:%s", ip)
+	now := time.Now().Unix()
+
+	pipe := client.Pipeline()
+	pipe.ZRemRangeByScore(ctx, key, "0", strconv.FormatInt(now-60, 10))
+	countCmd := pipe.ZCard(ctx, key)
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now), Member: now})
+	pipe.Expire(ctx, key, time.Minute*2)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return true
+	}
+	return countCmd.Val() <= int64(limit)
+}
+
+// latencyHook adds a fixed delay per round-trip to model network latency,
+// which an in-process Redis otherwise hides
+type latencyHook struct{ rtt time.Duration }
+
+func (h latencyHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	time.Sleep(h.rtt)
+	return ctx, nil
+}
+
+func (latencyHook) AfterProcess(context.Context, redis.Cmder) error { return nil }
+
+func (h latencyHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	time.Sleep(h.rtt)
+	return ctx, nil
+}
+
+func (latencyHook) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+// benchApp targets BENCH_REDIS_ADDR when set, otherwise an in-process Redis
+// with a simulated 200µs round-trip. miniredis interprets Lua far slower
+// than Redis does, so the Script numbers are only meaningful against a real
+// server; the local cache numbers hold either way.
+func benchApp(b *testing.B) *config.Application {
+	app, _ := newTestApp(b)
+	if addr := os.Getenv("BENCH_REDIS_ADDR"); addr != "" {
+		client := redis.NewClient(&redis.Options{Addr: addr})
+		b.Cleanup(func() { client.Close() })
+		app.Redis = client
+	} else {
+		app.Redis.AddHook(latencyHook{rtt: 200 * time.Microsecond})
+	}
+	return app
+}
+
+// BenchmarkRedisRateLimiter compares the limiter strategies. Run with:
+//
+//	go test -run x -bench RedisRateLimiter -benchtime 3000x ./internal/middleware
+func BenchmarkRedisRateLimiter(b *testing.B) {
+	const clients = 32
+	ips := make([]string, clients)
+	for i := range ips {
+		ips[i] = fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+	}
+
+	b.Run("Pipeline", func(b *testing.B) {
+		app := benchApp(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			pipelineAllow(app.Redis, ips[i%clients], 1<<30)
+		}
+	})
+
+	b.Run("Script", func(b *testing.B) {
+		rl := NewSlidingWindowRateLimiter(kvstore.NewRedis(benchApp(b).Redis), zerolog.Nop(), 1<<30, 1<<30)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rl.Allow(ips[i%clients])
+		}
+	})
+
+	b.Run("Memory", func(b *testing.B) {
+		rl := NewSlidingWindowRateLimiter(kvstore.NewMemory(), zerolog.Nop(), 1<<30, 1<<30)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rl.Allow(ips[i%clients])
+		}
+	})
+
+	b.Run("ScriptLocalCache", func(b *testing.B) {
+		rl := NewSlidingWindowRateLimiter(kvstore.NewRedis(benchApp(b).Redis), zerolog.Nop(), 1<<30, 1<<30).
+			WithLocalCache(250 * time.Millisecond)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rl.Allow(ips[i%clients])
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"azlo-goboiler/internal/config"
+)
+
+// RealIP works out the client IP every later middleware and handler sees
+// through getClientIP and ClientIP. X-Forwarded-For and X-Real-IP are only
+// believed when the connection comes from TRUSTED_PROXY_CIDRS; anyone else
+// could send them to pick their own address. X-Forwarded-For is read from
+// the right, skipping hops that are themselves trusted proxies, so the
+// client is the first address no trusted proxy vouches for. With
+// TRUSTED_PROXY_CIDRS unset the client is the connection's address.
+func (mw *Middleware) RealIP(next http.Handler) http.Handler {
+	proxies := mw.app.Config.GetTrustedProxyCIDRs()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := resolveClientIP(r, proxies)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), config.ClientIPKey, ip)))
+	})
+}
+
+// resolveClientIP returns the client IP for r, believing forwarding headers
+// only from proxies
+func resolveClientIP(r *http.Request, proxies []netip.Prefix) string {
+	remote := remoteIP(r)
+	if !isTrustedProxy(remote, proxies) {
+		return remote
+	}
+
+	// Each proxy appends the address it got the request from, so the list
+	// is only trustworthy from the right until the first untrusted hop
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	if len(hops) > 0 {
+		client := remote
+		for i := len(hops) - 1; i >= 0; i-- {
+			if _, err := netip.ParseAddr(strings.Trim(hops[i], "[]")); err != nil {
+				// A trusted proxy wouldn't write this, so nothing left of it
+				// can be believed either
+				break
+			}
+			client = hops[i]
+			if !isTrustedProxy(client, proxies) {
+				break
+			}
+		}
+		return client
+	}
+
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); xri != "" {
+		if _, err := netip.ParseAddr(strings.Trim(xri, "[]")); err == nil {
+			return xri
+		}
+	}
+	return remote
+}
+
+// remoteIP is the address of the connection itself, without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// isTrustedProxy reports whether ip is in proxies
+func isTrustedProxy(ip string, proxies []netip.Prefix) bool {
+	if len(proxies) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(strings.Trim(ip, "[]"))
+	if err != nil {
+		return false
+	}
+	return containsAddr(proxies, addr.Unmap())
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRealIP(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.Config.TrustedProxyCIDRs = []string{"10.0.0.0/8", "2001:db8::/32"}
+	mw := New(app, nil, nil, nil, nil)
+
+	clientIP := func(remoteAddr string, headers map[string]string) string {
+		var got string
+		handler := mw.RealIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = ClientIP(r)
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return got
+	}
+
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"no headers", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"spoofed from an untrusted address", "203.0.113.7:1234", map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "1.2.3.4"}, "203.0.113.7"},
+		{"proxy", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "203.0.113.7"},
+		{"client prepends a forged hop", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.7"}, "203.0.113.7"},
+		{"trusted hops stripped", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.7, 10.0.0.9, 10.0.0.3"}, "203.0.113.7"},
+		{"only trusted hops", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "10.0.0.4, 10.0.0.3"}, "10.0.0.4"},
+		{"unreadable hop", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "203.0.113.7, junk, 10.0.0.3"}, "10.0.0.3"},
+		{"X-Real-IP", "10.0.0.2:1234", map[string]string{"X-Real-IP": "203.0.113.7"}, "203.0.113.7"},
+		{"unreadable X-Real-IP", "10.0.0.2:1234", map[string]string{"X-Real-IP": "junk"}, "10.0.0.2"},
+		{"IPv6 proxy", "[2001:db8::1]:1234", map[string]string{"X-Forwarded-For": "2001:db9::7"}, "2001:db9::7"},
+		{"IPv4-mapped proxy", "[::ffff:10.0.0.2]:1234", map[string]string{"X-Forwarded-For": "203.0.113.7"}, "203.0.113.7"},
+	} {
+		assert.Equal(t, tc.want, clientIP(tc.remoteAddr, tc.headers), tc.name)
+	}
+
+	t.Run("NoTrustedProxies", func(t *testing.T) {
+		app, _ := newTestApp(t)
+		mw := New(app, nil, nil, nil, nil)
+		var got string
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.2:1234"
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		mw.RealIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = ClientIP(r)
+		})).ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, "10.0.0.2", got)
+	})
+}
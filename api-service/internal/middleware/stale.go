@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/kvstore"
+)
+
+const (
+	// StaleWarning is the Warning header on a kept response served in place
+	// of a fresh one (RFC 7234 warn-code 110)
+	StaleWarning = `110 - "Response is Stale"`
+
+	// maxStaleResponse is the largest response body kept
+	maxStaleResponse = 1 << 20
+)
+
+// staleHeaders are the response headers kept with a stale copy. Cookies and
+// per-request headers belong to the request that produced it.
+var staleHeaders = []string{"Content-Type", "Cache-Control", ETagHeader}
+
+// staleResponse is the last good response to a read, kept for StaleIfError
+type staleResponse struct {
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	StoredAt time.Time   `json:"stored_at"`
+}
+
+// staleWriter holds back a 503 until it is known whether a kept copy can
+// replace it, and keeps a copy of a 200 to replace a later one
+type staleWriter struct {
+	http.ResponseWriter
+	status      int
+	unavailable bool
+	held        bytes.Buffer
+	body        bytes.Buffer
+	overflow    bool
+}
+
+func (sw *staleWriter) WriteHeader(code int) {
+	if sw.status != 0 || code < http.StatusOK {
+		sw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	sw.status = code
+	if code == http.StatusServiceUnavailable {
+		sw.unavailable = true
+		return
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *staleWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.WriteHeader(http.StatusOK)
+	}
+	if sw.unavailable {
+		return sw.held.Write(b)
+	}
+	if sw.status == http.StatusOK && !sw.overflow {
+		if sw.body.Len()+len(b) > maxStaleResponse {
+			sw.overflow = true
+			sw.body.Reset()
+		} else {
+			sw.body.Write(b)
+		}
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *staleWriter) Flush() {
+	if sw.unavailable {
+		return
+	}
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// StaleIfError lets an authenticated GET route ride out a brief database
+// outage. Each 200 it sends is kept per user for STALE_IF_ERROR_SECONDS, and
+// when the handler answers 503, as handlers do when the database can't be
+// reached, the kept copy is sent instead with a Warning header and its Age.
+// Without a copy young enough the 503 goes out as is. Wrap only reads whose
+// handler reports an unreachable database with 503 and whose response
+// depends on nothing but the user, path and Accept header. With
+// STALE_IF_ERROR_SECONDS unset it is a no-op.
+func (mw *Middleware) StaleIfError(next http.Handler) http.Handler {
+	maxStale := mw.app.Config.GetStaleIfError()
+	if maxStale <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(config.UserIDKey).(string)
+		if userID == "" || r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := kvstore.StaleResponseKey(userID, r.URL.Path, r.URL.RawQuery+"\n"+r.Header.Get("Accept"))
+		requestID := getRequestID(r.Context())
+
+		sw := &staleWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		if sw.unavailable {
+			if !mw.serveStale(w, r, key, maxStale, requestID) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write(sw.held.Bytes())
+			}
+			return
+		}
+		if sw.status != http.StatusOK || sw.overflow {
+			return
+		}
+
+		kept := staleResponse{Header: http.Header{}, Body: sw.body.Bytes(), StoredAt: time.Now().UTC()}
+		for _, name := range staleHeaders {
+			if values := w.Header().Values(name); len(values) > 0 {
+				kept.Header[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+		raw, err := json.Marshal(kept)
+		if err == nil {
+			err = mw.kv.Set(context.Background(), key, string(raw), maxStale)
+		}
+		if err != nil {
+			mw.app.Logger.Warn().
+				Str("request_id", requestID).
+				Err(err).
+				Msg("Failed to keep response for stale serving")
+		}
+	})
+}
+
+// serveStale writes the response kept under key, if there is one no older
+// than maxStale, and reports whether it wrote anything
+func (mw *Middleware) serveStale(w http.ResponseWriter, r *http.Request, key string, maxStale time.Duration, requestID string) bool {
+	raw, err := mw.kv.Get(r.Context(), key)
+	if err != nil {
+		if !errors.Is(err, core.ErrKeyNotFound) {
+			mw.app.Logger.Warn().Str("request_id", requestID).Err(err).Msg("Stale response store failed")
+		}
+		return false
+	}
+	var kept staleResponse
+	if err := json.Unmarshal([]byte(raw), &kept); err != nil {
+		mw.app.Logger.Warn().Str("request_id", requestID).Err(err).Msg("Discarding unreadable stale response")
+		return false
+	}
+	// The store's TTL bounds the age too; this covers a clock or TTL change
+	age := time.Since(kept.StoredAt)
+	if age > maxStale {
+		return false
+	}
+
+	h := w.Header()
+	for name, values := range kept.Header {
+		h[name] = values
+	}
+	h.Del(RetryAfterHeader)
+	h.Set(AgeHeader, strconv.Itoa(int(age.Seconds())))
+	h.Set(WarningHeader, StaleWarning)
+	w.WriteHeader(http.StatusOK)
+	w.Write(kept.Body)
+
+	mw.app.Logger.Warn().
+		Str("request_id", requestID).
+		Str("path", r.URL.Path).
+		Dur("age", age).
+		Msg("Served stale response")
+	return true
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/kvstore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaleIfError(t *testing.T) {
+	app, _ := newTestApp(t)
+	app.Config.StaleIfError = 60
+	mw := New(app, nil, nil, kvstore.NewMemory(), nil)
+
+	// The handler answers as the database does: up until down is set
+	down := false
+	handler := mw.StaleIfError(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down {
+			w.Header().Set("Retry-After", "5")
+			mw.writeError(w, r, http.StatusServiceUnavailable, "Database unavailable", getRequestID(r.Context()))
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: config.AuthCookieName, Value: "rotated"})
+		w.Header().Set("Content-Type", "application/vnd.azlo.v1+json")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"user":"` + r.Context().Value(config.UserIDKey).(string) + `"}`))
+	}))
+	serve := func(method, userID, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/profile", nil)
+		req.Header.Set("Accept", accept)
+		if userID != "" {
+			req = req.WithContext(context.WithValue(req.Context(), config.UserIDKey, userID))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusOK, serve(http.MethodGet, "user-1", "application/json").Code)
+	down = true
+
+	t.Run("ServedStale", func(t *testing.T) {
+		rec := serve(http.MethodGet, "user-1", "application/json")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `{"user":"user-1"}`, rec.Body.String())
+		assert.Equal(t, StaleWarning, rec.Header().Get("Warning"))
+		assert.NotEmpty(t, rec.Header().Get("Age"))
+		assert.Equal(t, `"v1"`, rec.Header().Get("ETag"))
+		assert.Equal(t, "application/vnd.azlo.v1+json", rec.Header().Get("Content-Type"))
+		assert.Empty(t, rec.Header().Get("Retry-After"))
+		assert.Empty(t, rec.Header().Values("Set-Cookie"), "cookies are not replayed")
+	})
+
+	t.Run("NoCopy", func(t *testing.T) {
+		for name, rec := range map[string]*httptest.ResponseRecorder{
+			"other user":   serve(http.MethodGet, "user-2", "application/json"),
+			"other accept": serve(http.MethodGet, "user-1", "application/vnd.azlo.v2+json"),
+			"anonymous":    serve(http.MethodGet, "", "application/json"),
+			"not a read":   serve(http.MethodPut, "user-1", "application/json"),
+		} {
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code, name)
+			assert.Contains(t, rec.Body.String(), "Database unavailable", name)
+			assert.Equal(t, "5", rec.Header().Get("Retry-After"), name)
+			assert.Empty(t, rec.Header().Get("Warning"), name)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+)
+
+// TrustedHeader takes Authenticate's place when an authenticating proxy
+// sits in front of the API. A request connecting from TRUSTED_PROXY_CIDRS
+// with TRUSTED_AUTH_HEADER set is signed in as the user the header names,
+// with no token. Every other request goes through Authenticate. The proxy
+// is recognized by the connection's own address, never by X-Forwarded-For,
+// which a client can forge. With TRUSTED_AUTH_HEADER unset it is just
+// Authenticate.
+func (mw *Middleware) TrustedHeader(users core.TrustedUserResolver) func(http.Handler) http.Handler {
+	header := mw.app.Config.TrustedAuthHeader
+	emailHeader := mw.app.Config.TrustedAuthEmailHeader
+	proxies := mw.app.Config.GetTrustedProxyCIDRs()
+
+	return func(next http.Handler) http.Handler {
+		authenticate := mw.Authenticate(next)
+		if header == "" || len(proxies) == 0 {
+			return authenticate
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := strings.TrimSpace(r.Header.Get(header))
+			if name == "" {
+				authenticate.ServeHTTP(w, r)
+				return
+			}
+			requestID := getRequestID(r.Context())
+			if !fromTrustedProxy(r, proxies) {
+				mw.app.Logger.Warn().
+					Str("request_id", requestID).
+					Str("remote_addr", r.RemoteAddr).
+					Msg("Trusted auth header from an untrusted address ignored")
+				authenticate.ServeHTTP(w, r)
+				return
+			}
+
+			var email string
+			if emailHeader != "" {
+				email = strings.TrimSpace(r.Header.Get(emailHeader))
+			}
+			user, err := users.TrustedUser(r.Context(), name, email)
+			if err != nil || user == nil {
+				if err != nil && !errors.Is(err, core.ErrTrustedUserUnknown) {
+					mw.app.Logger.Error().
+						Str("request_id", requestID).
+						Err(err).
+						Msg("Trusted user lookup failed")
+				}
+				mw.writeError(w, r, http.StatusUnauthorized, "Unknown user", requestID)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), config.UserIDKey, user.ID)
+			ctx = context.WithValue(ctx, config.UserRoleKey, user.Role)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// fromTrustedProxy reports whether the request's connection comes from one
+// of proxies
+func fromTrustedProxy(r *http.Request, proxies []netip.Prefix) bool {
+	return isTrustedProxy(remoteIP(r), proxies)
+}
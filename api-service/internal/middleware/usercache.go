@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"azlo-goboiler/internal/models"
+)
+
+// userCacheKey is the context key for the per-request user cache
+type userCacheKey struct{}
+
+// userCache holds the authenticated user once something in the request has
+// loaded it
+type userCache struct {
+	mu   sync.Mutex
+	user *models.User
+}
+
+// UserCache gives each request a slot for the authenticated user, so the
+// handlers and checks that need the profile share one load. It goes after
+// JWT on authenticated routes.
+func UserCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), userCacheKey{}, &userCache{})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// LoadUser returns userID's profile, calling load at most once per request
+// when UserCache is installed and on every call otherwise. Failed loads are
+// not cached.
+func LoadUser(ctx context.Context, userID string, load func(context.Context, string) (*models.User, error)) (*models.User, error) {
+	cache, ok := ctx.Value(userCacheKey{}).(*userCache)
+	if !ok {
+		return load(ctx, userID)
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.user != nil && cache.user.ID == userID {
+		return cache.user, nil
+	}
+	user, err := load(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	cache.user = user
+	return user, nil
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"azlo-goboiler/internal/config"
+)
+
+// DefaultAPIVersion is served when the Accept header does not ask for one
+const DefaultAPIVersion = 1
+
+// supportedAPIVersions lists every version handlers know how to render
+var supportedAPIVersions = map[int]bool{1: true}
+
+// vendorMediaPrefix and vendorMediaSuffix frame the version in a pinned
+// media type, e.g. "application/vnd.azlo.v1+json"
+const (
+	vendorMediaPrefix = "application/vnd.azlo.v"
+	vendorMediaSuffix = "+json"
+)
+
+// AcceptVersion lets clients pin a response version with
+// "Accept: application/vnd.azlo.v<N>+json" and stores it in the request
+// context. Requests without a vendor media type get DefaultAPIVersion, so
+// plain "application/json" and "*/*" clients are unaffected. A pinned version
+// this build doesn't support fails with 406.
+func (mw *Middleware) AcceptVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Responses differ by version, so shared caches must key on Accept
+		w.Header().Add("Vary", "Accept")
+
+		version, pinned, err := acceptedVersion(r.Header.Values("Accept"))
+		if err != nil || (pinned && !supportedAPIVersions[version]) {
+			mw.writeError(w, r, http.StatusNotAcceptable,
+				fmt.Sprintf("Unsupported API version; use %s%d%s", vendorMediaPrefix, DefaultAPIVersion, vendorMediaSuffix),
+				getRequestID(r.Context()))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), config.APIVersionKey, version)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// acceptedVersion finds the first vendor media type in the Accept values.
+// pinned is false when there is none and the default applies.
+func acceptedVersion(accept []string) (version int, pinned bool, err error) {
+	for _, value := range accept {
+		for _, part := range strings.Split(value, ",") {
+			mediaType, _, parseErr := mime.ParseMediaType(strings.TrimSpace(part))
+			if parseErr != nil || !strings.HasPrefix(mediaType, vendorMediaPrefix) {
+				continue
+			}
+			number, ok := strings.CutSuffix(strings.TrimPrefix(mediaType, vendorMediaPrefix), vendorMediaSuffix)
+			if !ok {
+				return 0, true, fmt.Errorf("malformed vendor media type %q", mediaType)
+			}
+			v, convErr := strconv.Atoi(number)
+			if convErr != nil || v < 1 {
+				return 0, true, fmt.Errorf("malformed vendor media type %q", mediaType)
+			}
+			return v, true, nil
+		}
+	}
+	return DefaultAPIVersion, false, nil
+}
+
+// RequestedVersion is the API version negotiated for the request, for
+// handlers that render versions differently
+func RequestedVersion(ctx context.Context) int {
+	if v, ok := ctx.Value(config.APIVersionKey).(int); ok {
+		return v
+	}
+	return DefaultAPIVersion
+}
@@ -0,0 +1,32 @@
+package mocks
+
+import (
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/pagination"
+	"context"
+
+	"github.com/stretchr/testify/mock"
+)
+
+// MockAuditRepository is a mock implementation of core.AuditRepository
+type MockAuditRepository struct {
+	mock.Mock
+}
+
+func (m *MockAuditRepository) RecordEvent(ctx context.Context, event *models.AuditEvent) error {
+	return m.Called(ctx, event).Error(0)
+}
+
+func (m *MockAuditRepository) ListEvents(ctx context.Context, p pagination.Params) ([]models.AuditEvent, error) {
+	args := m.Called(ctx, p)
+	return args.Get(0).([]models.AuditEvent), args.Error(1)
+}
+
+func (m *MockAuditRepository) RecordLogin(ctx context.Context, entry *models.LoginEvent) error {
+	return m.Called(ctx, entry).Error(0)
+}
+
+func (m *MockAuditRepository) ListLogins(ctx context.Context, userID string, p pagination.Params) ([]models.LoginEvent, error) {
+	args := m.Called(ctx, userID, p)
+	return args.Get(0).([]models.LoginEvent), args.Error(1)
+}
@@ -0,0 +1,37 @@
+package mocks
+
+import (
+	"azlo-goboiler/internal/models"
+	"context"
+
+	"github.com/stretchr/testify/mock"
+)
+
+// MockAuditService is a mock implementation of core.AuditService
+type MockAuditService struct {
+	mock.Mock
+}
+
+func (m *MockAuditService) Record(ctx context.Context, event models.AuditEvent) error {
+	return m.Called(ctx, event).Error(0)
+}
+
+func (m *MockAuditService) RecordLogin(ctx context.Context, userID, ipAddress, userAgent string) error {
+	return m.Called(ctx, userID, ipAddress, userAgent).Error(0)
+}
+
+func (m *MockAuditService) ListEvents(ctx context.Context, before string, limit int) ([]models.AuditEvent, *models.KeysetMetadata, error) {
+	args := m.Called(ctx, before, limit)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).([]models.AuditEvent), args.Get(1).(*models.KeysetMetadata), args.Error(2)
+}
+
+func (m *MockAuditService) ListLoginHistory(ctx context.Context, userID, before string, limit int) ([]models.LoginEvent, *models.KeysetMetadata, error) {
+	args := m.Called(ctx, userID, before, limit)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).([]models.LoginEvent), args.Get(1).(*models.KeysetMetadata), args.Error(2)
+}
@@ -0,0 +1,73 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+// MockSessionStore is a mock implementation of core.SessionStore
+type MockSessionStore struct {
+	mock.Mock
+}
+
+func (m *MockSessionStore) GlobalEpoch(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockSessionStore) BumpGlobalEpoch(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockSessionStore) EffectiveEpoch(ctx context.Context, userID string) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockSessionStore) BumpUserEpoch(ctx context.Context, userID string) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockSessionStore) SetActiveSession(ctx context.Context, userID, sessionID string, ttl time.Duration) error {
+	args := m.Called(ctx, userID, sessionID, ttl)
+	return args.Error(0)
+}
+
+func (m *MockSessionStore) ActiveSession(ctx context.Context, userID string) (string, error) {
+	args := m.Called(ctx, userID)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockSessionStore) RecordFailedLogin(ctx context.Context, userID string, at time.Time) error {
+	args := m.Called(ctx, userID, at)
+	return args.Error(0)
+}
+
+func (m *MockSessionStore) LastFailedLogin(ctx context.Context, userID string) (time.Time, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+func (m *MockSessionStore) RevokeToken(ctx context.Context, tokenID string, ttl time.Duration) error {
+	args := m.Called(ctx, tokenID, ttl)
+	return args.Error(0)
+}
+
+func (m *MockSessionStore) TokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	args := m.Called(ctx, tokenID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockSessionStore) SaveRefreshToken(ctx context.Context, userID, tokenID, sessionID string, ttl time.Duration) error {
+	args := m.Called(ctx, userID, tokenID, sessionID, ttl)
+	return args.Error(0)
+}
+
+func (m *MockSessionStore) ConsumeRefreshToken(ctx context.Context, userID, tokenID string) (string, int64, error) {
+	args := m.Called(ctx, userID, tokenID)
+	return args.String(0), args.Get(1).(int64), args.Error(2)
+}
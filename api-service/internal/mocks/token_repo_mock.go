@@ -0,0 +1,30 @@
+package mocks
+
+import (
+	"azlo-goboiler/internal/models"
+	"context"
+
+	"github.com/stretchr/testify/mock"
+)
+
+// MockTokenRepository is a mock implementation of core.TokenRepository
+type MockTokenRepository struct {
+	mock.Mock
+}
+
+func (m *MockTokenRepository) Create(ctx context.Context, token *models.UserToken) error {
+	return m.Called(ctx, token).Error(0)
+}
+
+func (m *MockTokenRepository) Get(ctx context.Context, tokenHash, purpose string) (*models.UserToken, error) {
+	args := m.Called(ctx, tokenHash, purpose)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.UserToken), args.Error(1)
+}
+
+func (m *MockTokenRepository) Consume(ctx context.Context, tokenHash, purpose string) (string, error) {
+	args := m.Called(ctx, tokenHash, purpose)
+	return args.String(0), args.Error(1)
+}
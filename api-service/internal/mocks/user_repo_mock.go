@@ -3,6 +3,7 @@ package mocks
 import (
 	"azlo-goboiler/internal/models"
 	"context"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 )
@@ -41,17 +42,33 @@ func (m *MockUserRepository) Update(ctx context.Context, user *models.User) erro
 	return m.Called(ctx, user).Error(0)
 }
 
-func (m *MockUserRepository) UpdatePassword(ctx context.Context, userID, hash string) error {
-	return m.Called(ctx, userID, hash).Error(0)
+func (m *MockUserRepository) UpdatePassword(ctx context.Context, userID, hash string, historySize int) error {
+	return m.Called(ctx, userID, hash, historySize).Error(0)
+}
+
+func (m *MockUserRepository) RecentPasswordHashes(ctx context.Context, userID string, n int) ([]string, error) {
+	args := m.Called(ctx, userID, n)
+	return args.Get(0).([]string), args.Error(1)
 }
 
 func (m *MockUserRepository) UpdateLastLogin(ctx context.Context, userID string) error {
 	return m.Called(ctx, userID).Error(0)
 }
 
-func (m *MockUserRepository) List(ctx context.Context, limit, offset int) ([]models.User, error) {
+func (m *MockUserRepository) List(ctx context.Context, limit, offset int) ([]models.UserListItem, error) {
 	args := m.Called(ctx, limit, offset)
-	return args.Get(0).([]models.User), args.Error(1)
+	return args.Get(0).([]models.UserListItem), args.Error(1)
+}
+
+// StreamAll passes each of the rows the expectation returns to fn
+func (m *MockUserRepository) StreamAll(ctx context.Context, fn func(models.UserExportRow) error) error {
+	args := m.Called(ctx)
+	for _, row := range args.Get(0).([]models.UserExportRow) {
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
 }
 
 func (m *MockUserRepository) Count(ctx context.Context) (int, error) {
@@ -59,6 +76,21 @@ func (m *MockUserRepository) Count(ctx context.Context) (int, error) {
 	return args.Int(0), args.Error(1)
 }
 
+func (m *MockUserRepository) UsernameSkeletonTaken(ctx context.Context, skeleton, excludeID string) (bool, error) {
+	args := m.Called(ctx, skeleton, excludeID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) CountByRole(ctx context.Context, role string) (int, error) {
+	args := m.Called(ctx, role)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockUserRepository) CollectionVersion(ctx context.Context) (int, time.Time, error) {
+	args := m.Called(ctx)
+	return args.Int(0), args.Get(1).(time.Time), args.Error(2)
+}
+
 func (m *MockUserRepository) GetPreferences(ctx context.Context, userID string) (*models.UserPreferences, error) {
 	args := m.Called(ctx, userID)
 	if args.Get(0) == nil {
@@ -70,3 +102,50 @@ func (m *MockUserRepository) GetPreferences(ctx context.Context, userID string)
 func (m *MockUserRepository) UpsertPreferences(ctx context.Context, prefs *models.UserPreferences) error {
 	return m.Called(ctx, prefs).Error(0)
 }
+
+func (m *MockUserRepository) SetPendingNotificationEmail(ctx context.Context, userID, email, tokenHash string, expiresAt time.Time) error {
+	return m.Called(ctx, userID, email, tokenHash, expiresAt).Error(0)
+}
+
+func (m *MockUserRepository) SetEmailVerified(ctx context.Context, userID string) error {
+	return m.Called(ctx, userID).Error(0)
+}
+
+func (m *MockUserRepository) GetUserIDByIdentity(ctx context.Context, provider, providerUserID string) (string, error) {
+	args := m.Called(ctx, provider, providerUserID)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockUserRepository) LinkIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	return m.Called(ctx, identity).Error(0)
+}
+
+func (m *MockUserRepository) Merge(ctx context.Context, sourceID, targetID string) (*models.MergeResult, error) {
+	args := m.Called(ctx, sourceID, targetID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.MergeResult), args.Error(1)
+}
+
+func (m *MockUserRepository) VerifyNotificationEmail(ctx context.Context, tokenHash string) (bool, error) {
+	args := m.Called(ctx, tokenHash)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) GetRecoveryContact(ctx context.Context, userID, kind string) (*models.RecoveryContact, error) {
+	args := m.Called(ctx, userID, kind)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.RecoveryContact), args.Error(1)
+}
+
+func (m *MockUserRepository) SetPendingRecoveryContact(ctx context.Context, userID, kind, value, tokenHash string, expiresAt time.Time) error {
+	return m.Called(ctx, userID, kind, value, tokenHash, expiresAt).Error(0)
+}
+
+func (m *MockUserRepository) VerifyRecoveryContact(ctx context.Context, kind, tokenHash string) (bool, error) {
+	args := m.Called(ctx, kind, tokenHash)
+	return args.Bool(0), args.Error(1)
+}
@@ -0,0 +1,64 @@
+// File: internal/models/api_key.go
+package models
+
+import (
+	"time"
+)
+
+// API key scopes
+const (
+	APIKeyScopeRead  = "read"
+	APIKeyScopeWrite = "write"
+)
+
+// APIKey is a long-lived credential for machine-to-machine callers. Only a
+// hash of the key is stored; the plaintext is shown once at creation.
+type APIKey struct {
+	ID         string     `db:"id"`
+	UserID     string     `db:"user_id"`
+	Name       string     `db:"name"`
+	Prefix     string     `db:"prefix"`
+	KeyHash    string     `db:"key_hash"`
+	Scopes     []string   `db:"scopes"`
+	CreatedAt  time.Time  `db:"created_at"`
+	LastUsedAt *time.Time `db:"last_used_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+}
+
+// APIKeySummary is the public view of an API key; it never carries the secret
+type APIKeySummary struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	MaskedKey  string     `json:"masked_key"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// CreateAPIKeyRequest represents a request to issue a new API key
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required,min=1,max=100"`
+	Scopes []string `json:"scopes" validate:"omitempty,dive,oneof=read write"`
+}
+
+// CreateAPIKeyResponse includes the plaintext key, returned only once
+type CreateAPIKeyResponse struct {
+	APIKeySummary
+	Key string `json:"key"`
+}
+
+// Summary returns the public view with the secret portion masked
+func (k *APIKey) Summary() APIKeySummary {
+	scopes := k.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return APIKeySummary{
+		ID:         k.ID,
+		Name:       k.Name,
+		MaskedKey:  k.Prefix + "_****",
+		Scopes:     scopes,
+		CreatedAt:  k.CreatedAt,
+		LastUsedAt: k.LastUsedAt,
+	}
+}
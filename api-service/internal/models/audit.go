@@ -0,0 +1,53 @@
+// File: internal/models/audit.go
+package models
+
+import (
+	"time"
+)
+
+// AuditEvent records a security-relevant action taken by or against a user
+type AuditEvent struct {
+	ID        string                 `json:"id" db:"id"`
+	ActorID   string                 `json:"actor_id,omitempty" db:"actor_id"`
+	Action    string                 `json:"action" db:"action"`
+	TargetID  string                 `json:"target_id,omitempty" db:"target_id"`
+	IPAddress string                 `json:"ip_address,omitempty" db:"ip_address"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty" db:"metadata"`
+	CreatedAt time.Time              `json:"created_at" db:"created_at"`
+}
+
+// LoginEvent is a single successful sign-in in a user's login history
+type LoginEvent struct {
+	ID        string    `json:"id" db:"id"`
+	UserID    string    `json:"-" db:"user_id"`
+	IPAddress string    `json:"ip_address,omitempty" db:"ip_address"`
+	UserAgent string    `json:"user_agent,omitempty" db:"user_agent"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Audit actions recorded by the application
+const (
+	AuditActionRegister       = "user.register"
+	AuditActionProfileUpdate  = "user.profile_update"
+	AuditActionPasswordChange = "user.password_change"
+	AuditActionPreferences    = "user.preferences_update"
+	AuditActionNotifyEmail    = "user.notification_email_change"
+	AuditActionPasswordReset  = "user.password_reset"
+	AuditActionEmailVerify    = "user.email_verify"
+	AuditActionLogoutAll      = "user.logout_all"
+	AuditActionIdentityLink   = "user.identity_link"
+	AuditActionRecoveryEmail  = "user.recovery_email_change"
+
+	AuditActionRevokeAllSessions = "security.revoke_all_sessions"
+	AuditActionTestNotification  = "notification.test_sent"
+	AuditActionMergeUsers        = "admin.users_merge"
+	AuditActionImportUsers       = "admin.users_import"
+	AuditActionExportUsers       = "admin.users_export"
+	AuditActionDBMaintenance     = "admin.db_maintenance"
+	AuditActionDBSessionCancel   = "admin.db_session_cancel"
+	AuditActionMaintenanceMode   = "admin.maintenance_mode"
+	AuditActionSignedURLCreate   = "admin.signed_url_create"
+
+	AuditActionAPIKeyCreate = "api_key.create"
+	AuditActionAPIKeyRevoke = "api_key.revoke"
+)
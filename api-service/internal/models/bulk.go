@@ -0,0 +1,27 @@
+package models
+
+// MaxBulkItems caps how many items one bulk request may carry
+const MaxBulkItems = 100
+
+// BulkItemResult is the outcome of one item in a bulk request. Index is the
+// item's position in the request; Status is the HTTP status the item would
+// have got on its own.
+type BulkItemResult struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkResult is the body of every 207 Multi-Status response
+type BulkResult struct {
+	Results   []BulkItemResult `json:"results"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+}
+
+// ImportUsersRequest creates several accounts in one call, at most
+// MaxBulkItems of them
+type ImportUsersRequest struct {
+	Users []RegisterRequest `json:"users" validate:"required,min=1"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// Diagnostics is a snapshot of the running instance for quick triage
+type Diagnostics struct {
+	Version        string           `json:"version"`
+	Commit         string           `json:"commit"`
+	GoVersion      string           `json:"go_version"`
+	UptimeSeconds  float64          `json:"uptime_seconds"`
+	Goroutines     int              `json:"goroutines"`
+	CPUs           int              `json:"cpus"`
+	ActiveRequests int64            `json:"active_requests"`
+	Memory         MemoryStats      `json:"memory"`
+	GC             GCStats          `json:"gc"`
+	Database       *ConnectionStats `json:"database,omitempty"`
+	Redis          *ConnectionStats `json:"redis,omitempty"`
+}
+
+// MemoryStats is the part of runtime.MemStats worth a glance
+type MemoryStats struct {
+	HeapAllocBytes  uint64 `json:"heap_alloc_bytes"`
+	HeapInuseBytes  uint64 `json:"heap_inuse_bytes"`
+	HeapObjects     uint64 `json:"heap_objects"`
+	StackInuseBytes uint64 `json:"stack_inuse_bytes"`
+	SysBytes        uint64 `json:"sys_bytes"`
+	TotalAllocBytes uint64 `json:"total_alloc_bytes"`
+}
+
+// GCStats summarizes the garbage collector's work since the process started
+type GCStats struct {
+	Cycles       uint32     `json:"cycles"`
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	PauseTotalMS float64    `json:"pause_total_ms"`
+	LastPauseMS  float64    `json:"last_pause_ms"`
+	CPUFraction  float64    `json:"cpu_fraction"`
+	NextGCBytes  uint64     `json:"next_gc_bytes"`
+}
+
+// ConnectionStats describes a connection pool
+type ConnectionStats struct {
+	Total int `json:"total_connections"`
+	InUse int `json:"in_use_connections"`
+	Idle  int `json:"idle_connections"`
+	Max   int `json:"max_connections"`
+}
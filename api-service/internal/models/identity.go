@@ -0,0 +1,39 @@
+// File: internal/models/identity.go
+package models
+
+import "time"
+
+// External sign-in providers
+const (
+	ProviderGoogle = "google"
+	// ProviderTrustedHeader links the users an authenticating proxy names in
+	// TRUSTED_AUTH_HEADER
+	ProviderTrustedHeader = "trusted_header"
+)
+
+// UserIdentity links a user to their account at an external provider
+type UserIdentity struct {
+	Provider       string    `db:"provider"`
+	ProviderUserID string    `db:"provider_user_id"`
+	UserID         string    `db:"user_id"`
+	Email          string    `db:"email"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+// IdentityLogin is the outcome of a provider sign-in
+type IdentityLogin struct {
+	*LoginResponse
+	// Linked is set when this sign-in linked the provider account, and
+	// Created when it also created the user
+	Linked  bool
+	Created bool
+}
+
+// OAuthProfile is what a provider tells us about the user who signed in
+type OAuthProfile struct {
+	Provider      string
+	Subject       string // the provider's stable user ID
+	Email         string
+	EmailVerified bool
+	Name          string
+}
@@ -0,0 +1,21 @@
+// File: internal/models/limits.go
+package models
+
+import "time"
+
+// RateLimitUsage is a caller's position in the global rate-limit window.
+// Scope is what the window is counted by, the RATE_LIMIT_KEY: "ip", "user"
+// or "user_ip".
+type RateLimitUsage struct {
+	Scope         string `json:"scope"`
+	Limit         int    `json:"limit"`
+	Used          int64  `json:"used"`
+	Remaining     int64  `json:"remaining"`
+	WindowSeconds int    `json:"window_seconds"`
+}
+
+// LimitStatus is what GET /api/v1/profile/limits reports about the caller
+type LimitStatus struct {
+	RateLimit       RateLimitUsage `json:"rate_limit"`
+	LastFailedLogin *time.Time     `json:"last_failed_login,omitempty"`
+}
@@ -0,0 +1,73 @@
+// File: internal/models/maintenance.go
+package models
+
+import "time"
+
+// Maintenance job states
+const (
+	MaintenanceQueued    = "queued"
+	MaintenanceRunning   = "running"
+	MaintenanceSucceeded = "succeeded"
+	MaintenanceFailed    = "failed"
+)
+
+// MaintenanceRequest asks for ANALYZE (and optionally VACUUM) on application
+// tables. An empty Tables means every application table. Sent without a
+// ConfirmationToken it only returns one; resending it with the token starts
+// the job.
+type MaintenanceRequest struct {
+	Tables            []string `json:"tables" validate:"omitempty,max=20,dive,required,max=100"`
+	Vacuum            bool     `json:"vacuum"`
+	ConfirmationToken string   `json:"confirmation_token,omitempty" validate:"omitempty,max=200"`
+}
+
+// MaintenanceConfirmation must be echoed back to start the job it describes
+type MaintenanceConfirmation struct {
+	ConfirmationToken string    `json:"confirmation_token"`
+	ExpiresAt         time.Time `json:"expires_at"`
+	Tables            []string  `json:"tables"`
+	Vacuum            bool      `json:"vacuum"`
+}
+
+// MaintenanceJob is the pollable record of one maintenance run
+type MaintenanceJob struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Tables      []string   `json:"tables"`
+	Vacuum      bool       `json:"vacuum"`
+	RequestedBy string     `json:"requested_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// DBSession is one of the application's connections as pg_stat_activity
+// reports it. DurationMs is the time since the session entered its state,
+// which for an active session is how long its query has been running.
+type DBSession struct {
+	PID        int32      `json:"pid"`
+	State      string     `json:"state"`
+	WaitEvent  string     `json:"wait_event,omitempty"`
+	Query      string     `json:"query"`
+	QueryStart *time.Time `json:"query_start,omitempty"`
+	DurationMs int64      `json:"duration_ms"`
+}
+
+// MaintenanceMode is whether the API turns requests away for planned
+// maintenance, and what it tells the clients it turns away
+type MaintenanceMode struct {
+	Enabled    bool       `json:"enabled"`
+	Message    string     `json:"message,omitempty"`
+	RetryAfter int        `json:"retry_after_seconds,omitempty"`
+	Since      *time.Time `json:"since,omitempty"`
+	SetBy      string     `json:"set_by,omitempty"`
+}
+
+// MaintenanceModeRequest turns maintenance mode on or off. Message and
+// RetryAfter replace the defaults in the 503 sent while it is on.
+type MaintenanceModeRequest struct {
+	Enabled    bool   `json:"enabled"`
+	Message    string `json:"message" validate:"max=500"`
+	RetryAfter int    `json:"retry_after_seconds" validate:"min=0,max=86400"`
+}
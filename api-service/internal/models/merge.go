@@ -0,0 +1,26 @@
+// File: internal/models/merge.go
+package models
+
+import "time"
+
+// MergeUsersRequest asks to fold a duplicate (source) account into the account
+// that is kept (target)
+type MergeUsersRequest struct {
+	SourceUserID string `json:"source_user_id" validate:"required,uuid"`
+	TargetUserID string `json:"target_user_id" validate:"required,uuid,nefield=SourceUserID"`
+}
+
+// MergeResult reports what a merge moved. Preferences follow the rule
+// "target wins": the source's preferences are only kept when the target
+// has none of its own.
+type MergeResult struct {
+	SourceUserID      string    `json:"source_user_id"`
+	TargetUserID      string    `json:"target_user_id"`
+	PreferencesMoved  bool      `json:"preferences_moved"`
+	LoginHistoryMoved int64     `json:"login_history_moved"`
+	AuditRowsMoved    int64     `json:"audit_rows_moved"`
+	APIKeysRevoked    int64     `json:"api_keys_revoked"`
+	SessionsRevoked   bool      `json:"sessions_revoked"`
+	SourceDeactivated bool      `json:"source_deactivated"`
+	MergedAt          time.Time `json:"merged_at"`
+}
@@ -0,0 +1,26 @@
+// File: internal/models/recovery.go
+package models
+
+import "time"
+
+// Recovery contact kinds. Only email is accepted so far; phone numbers are
+// meant to follow as another kind.
+const (
+	RecoveryContactEmail = "email"
+)
+
+// RecoveryContact is an address besides the login email that account
+// recovery can go to. It is never used until Verified.
+type RecoveryContact struct {
+	UserID    string    `json:"-" db:"user_id"`
+	Kind      string    `json:"kind" db:"kind"`
+	Value     string    `json:"value" db:"value"`
+	Verified  bool      `json:"verified" db:"verified"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// UpdateRecoveryEmailRequest starts verification of a new recovery email
+type UpdateRecoveryEmailRequest struct {
+	Email           string `json:"email" validate:"required,email,max=255"`
+	CurrentPassword string `json:"current_password" validate:"required"`
+}
@@ -0,0 +1,17 @@
+// File: internal/models/signed_url.go
+package models
+
+import "time"
+
+// CreateSignedURLRequest asks for a time-limited link to a path under /files/
+type CreateSignedURLRequest struct {
+	Path string `json:"path" validate:"required,max=2048"`
+	// ExpiresIn is the link lifetime in seconds, at most 7 days
+	ExpiresIn int `json:"expires_in" validate:"required,min=1,max=604800"`
+}
+
+// SignedURL is a link that works without credentials until ExpiresAt
+type SignedURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
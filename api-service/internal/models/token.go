@@ -0,0 +1,40 @@
+// File: internal/models/token.go
+package models
+
+import "time"
+
+// Single-use token purposes
+const (
+	TokenPurposePasswordReset = "password_reset"
+	TokenPurposeEmailVerify   = "email_verify"
+)
+
+// UserToken is a single-use token emailed to a user. Only its hash is stored.
+type UserToken struct {
+	TokenHash string     `db:"token_hash"`
+	UserID    string     `db:"user_id"`
+	Purpose   string     `db:"purpose"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+// ForgotPasswordRequest starts a password reset. With UseRecoveryEmail the
+// link goes to the account's verified recovery email instead of Email.
+type ForgotPasswordRequest struct {
+	Email            string `json:"email" validate:"required,email"`
+	UseRecoveryEmail bool   `json:"use_recovery_email"`
+}
+
+// ResetPasswordRequest completes a password reset with the emailed token
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=8,max=128,password"`
+}
+
+// TokenValidation reports whether a token would be accepted, without using it.
+// Reason is "invalid", "expired" or "used" when Valid is false.
+type TokenValidation struct {
+	Valid  bool   `json:"valid"`
+	Reason string `json:"reason,omitempty"`
+}
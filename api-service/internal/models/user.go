@@ -5,40 +5,118 @@ import (
 	"time"
 )
 
+// Roles a user account can hold
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 // User represents a user in the system
 type User struct {
-	ID           string     `json:"id" db:"id"`
-	Username     string     `json:"username" db:"username"`
-	Email        string     `json:"email" db:"email"`
-	PasswordHash string     `json:"-" db:"password_hash"` // Never serialize to JSON
-	IsActive     bool       `json:"is_active" db:"is_active"`
-	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
-	LastLogin    *time.Time `json:"last_login,omitempty" db:"last_login"`
+	ID           string `json:"id" db:"id"`
+	Username     string `json:"username" db:"username"`
+	Email        string `json:"email" db:"email"`
+	PasswordHash string `json:"-" db:"password_hash"` // Never serialize to JSON
+	IsActive     bool   `json:"is_active" db:"is_active"`
+	Role         string `json:"role" db:"role"`
+	// MustChangePassword is set for seeded accounts until their first password change
+	MustChangePassword bool       `json:"must_change_password" db:"must_change_password"`
+	EmailVerified      bool       `json:"email_verified" db:"email_verified"`
+	CreatedAt          time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at" db:"updated_at"`
+	LastLogin          *time.Time `json:"last_login,omitempty" db:"last_login"`
 }
 
 type UserPreferences struct {
-	UserID       string `json:"-" db:"user_id"`
-	EmailEnabled bool   `json:"email_enabled" db:"email_enabled"`
-	Frequency    string `json:"frequency" db:"frequency"` // e.g., "immediate", "daily"
+	UserID       string    `json:"-" db:"user_id"`
+	EmailEnabled bool      `json:"email_enabled" db:"email_enabled"`
+	Frequency    string    `json:"frequency" db:"frequency"` // e.g., "immediate", "daily"
+	UpdatedAt    time.Time `json:"-" db:"updated_at"`
+
+	// NotificationEmail overrides the login email for notifications, but
+	// only once NotificationEmailVerified is true
+	NotificationEmail         string `json:"-" db:"notification_email"`
+	NotificationEmailVerified bool   `json:"-" db:"notification_email_verified"`
+}
+
+// DefaultPreferences apply to a user who has never saved their own
+func DefaultPreferences(userID string) *UserPreferences {
+	return &UserPreferences{UserID: userID, EmailEnabled: true, Frequency: "immediate"}
+}
+
+// Notification channels
+const (
+	ChannelEmail = "email"
+)
+
+// NotificationChannel is one way the user can be notified and its state
+type NotificationChannel struct {
+	Name string `json:"name"`
+	// Available is false when the server is not configured for the
+	// channel; it cannot be enabled then, and Enabled is always false
+	Available bool `json:"available"`
+	Enabled   bool `json:"enabled"`
+	// Address is where the channel delivers, and Verified whether the user
+	// has confirmed they own it
+	Address  string `json:"address"`
+	Verified bool   `json:"verified"`
+}
+
+// Caps on preference strings, in characters. The max= on each request field
+// and the CHECK constraints on auth.user_preferences must match them; the
+// database package's TestPreferenceLengthCaps compares all three.
+const (
+	MaxFrequencyLength         = 20
+	MaxNotificationEmailLength = 255
+)
+
+// UpdateNotificationEmailRequest starts verification of a new notification address
+type UpdateNotificationEmailRequest struct {
+	Email string `json:"email" validate:"required,max=255,email"`
+}
+
+// UpdatePreferencesRequest represents a notification preferences update
+type UpdatePreferencesRequest struct {
+	EmailEnabled bool   `json:"email_enabled"`
+	Frequency    string `json:"frequency" validate:"required,max=20,oneof=immediate daily weekly"`
+}
+
+// PreferencesResponse is the public view of a user's preferences. UserID is
+// always the authenticated caller, never a value taken from the request body.
+type PreferencesResponse struct {
+	UserID       string    `json:"user_id"`
+	EmailEnabled bool      `json:"email_enabled"`
+	Frequency    string    `json:"frequency"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	NotificationEmail         string `json:"notification_email,omitempty"`
+	NotificationEmailVerified bool   `json:"notification_email_verified"`
 }
 
 // LoginRequest represents a login request
 type LoginRequest struct {
 	Username string `json:"username" validate:"required,min=3,max=50"`
 	Password string `json:"password" validate:"required,min=8,max=128"`
+	// TokenInBody opts into header auth: the token is returned in the
+	// response body instead of being set as a cookie
+	TokenInBody bool `json:"token_in_body,omitempty"`
+}
+
+// RefreshRequest carries a refresh token for clients that don't use cookies
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 // RegisterRequest represents a user registration request
 type RegisterRequest struct {
-	Username string `json:"username" validate:"required,min=3,max=50,alphanum"`
+	Username string `json:"username" validate:"required,min=3,max=50,username"`
 	Email    string `json:"email" validate:"required,email,max=100"`
 	Password string `json:"password" validate:"required,min=8,max=128,password"`
 }
 
 // UpdateUserRequest represents a user update request
 type UpdateUserRequest struct {
-	Username *string `json:"username,omitempty" validate:"omitempty,min=3,max=50,alphanum"`
+	Username *string `json:"username,omitempty" validate:"omitempty,min=3,max=50,username"`
 	Email    *string `json:"email,omitempty" validate:"omitempty,email,max=100"`
 }
 
@@ -46,6 +124,26 @@ type UpdateUserRequest struct {
 type ChangePasswordRequest struct {
 	CurrentPassword string `json:"current_password" validate:"required"`
 	NewPassword     string `json:"new_password" validate:"required,min=8,max=128,password"`
+	// LogoutOtherSessions signs out every other session; omitted means true
+	LogoutOtherSessions *bool `json:"logout_other_sessions,omitempty"`
+}
+
+// LogoutOthers reports whether the change should end the user's other sessions
+func (r ChangePasswordRequest) LogoutOthers() bool {
+	return r.LogoutOtherSessions == nil || *r.LogoutOtherSessions
+}
+
+// TestNotificationRequest asks the server to send an SMTP smoke-test email
+type TestNotificationRequest struct {
+	Recipient string `json:"recipient" validate:"required,email,max=254"`
+}
+
+// InitialAdmin holds the bootstrap admin credentials supplied through configuration.
+// The password rules are stricter than registration since this account is privileged.
+type InitialAdmin struct {
+	Username string `validate:"required,min=3,max=50,username"`
+	Email    string `validate:"required,email,max=100"`
+	Password string `validate:"required,min=12,max=128,password"`
 }
 
 // RegisterResponse is what the service returns on success
@@ -60,21 +158,93 @@ type LoginResponse struct {
 	Token     string      `json:"token"` // Only if you decide to return it in body
 	ExpiresAt int64       `json:"expires_at"`
 	User      UserSummary `json:"user"`
+	// MustChangePassword tells the client to send the user to the password change flow
+	MustChangePassword bool `json:"must_change_password,omitempty"`
+	// SessionMode is "single" when this login ended the user's other sessions
+	SessionMode string `json:"session_mode"`
+	// RefreshToken is set when a session store is configured. Handlers put it
+	// in the refresh cookie, or in the body for header-mode clients.
+	RefreshToken string `json:"-"`
 }
 
 type UserSummary struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
 	Email    string `json:"email"`
+	Role     string `json:"role"`
+}
+
+// UserListItem is one entry in the users list. It carries only the columns
+// the list query reads, so no field is left at a misleading zero value.
+// Every listed user is active.
+type UserListItem struct {
+	ID        string     `json:"id"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastLogin *time.Time `json:"last_login,omitempty"`
+}
+
+// UserExportRow is one user in an admin export. Unlike the users list, an
+// export covers inactive users too.
+type UserExportRow struct {
+	ID        string     `json:"id"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastLogin *time.Time `json:"last_login"`
+	Status    string     `json:"status"` // UserStatusActive or UserStatusInactive
 }
 
+// User statuses, as exported
+const (
+	UserStatusActive   = "active"
+	UserStatusInactive = "inactive"
+)
+
+// PaginationMetadata describes an offset-paginated page. Limit is the
+// effective page size; when the requested limit exceeded MaxLimit,
+// LimitCapped is set and RequestedLimit echoes what the client asked for.
 type PaginationMetadata struct {
-	Page       int  `json:"page"`
-	Limit      int  `json:"limit"`
-	TotalCount int  `json:"total_count"`
-	TotalPages int  `json:"total_pages"`
-	HasNext    bool `json:"has_next"`
-	HasPrev    bool `json:"has_prev"`
+	Page           int  `json:"page"`
+	Limit          int  `json:"limit"`
+	MaxLimit       int  `json:"max_limit"`
+	LimitCapped    bool `json:"limit_capped"`
+	RequestedLimit int  `json:"requested_limit,omitempty"`
+	TotalCount     int  `json:"total_count"`
+	TotalPages     int  `json:"total_pages"`
+	HasNext        bool `json:"has_next"`
+	HasPrev        bool `json:"has_prev"`
+}
+
+// KeysetMetadata describes a cursor-paginated page. NextCursor is empty on the last page.
+type KeysetMetadata struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// Resource is a typed, identifiable object that hypermedia formats can render
+type Resource interface {
+	ResourceType() string
+	ResourceID() string
+	// RelatedLinks maps relationship names to the endpoints serving them
+	RelatedLinks() map[string]string
+}
+
+func (u *User) ResourceType() string { return "users" }
+func (u *User) ResourceID() string   { return u.ID }
+func (u *User) RelatedLinks() map[string]string {
+	return map[string]string{
+		"login_history": "/api/v1/profile/login-history",
+		"preferences":   "/api/v1/profile/preferences",
+	}
+}
+
+func (p *PreferencesResponse) ResourceType() string { return "preferences" }
+func (p *PreferencesResponse) ResourceID() string   { return p.UserID }
+func (p *PreferencesResponse) RelatedLinks() map[string]string {
+	return map[string]string{"user": "/api/v1/profile"}
 }
 
 // IsHealthy returns true if the user account is active.
@@ -0,0 +1,92 @@
+package notification
+
+import (
+	"context"
+
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/notification/templates"
+)
+
+// DigestBuffer holds the items waiting for each user's next digest email
+type DigestBuffer interface {
+	// Add queues item for userID's next digest
+	Add(ctx context.Context, userID string, item templates.DigestItem) error
+	// Pending returns userID's queued items, oldest first, without removing them
+	Pending(ctx context.Context, userID string) ([]templates.DigestItem, error)
+}
+
+// DigestChannel queues events as digest items for users who take their
+// email as a daily or weekly digest. Their immediate emails still go out.
+// Nothing sends digests yet: the buffer keeps a user's newest items, for
+// PreviewDigest, and expires a while after its first item.
+type DigestChannel struct {
+	buffer DigestBuffer
+}
+
+func NewDigestChannel(buffer DigestBuffer) *DigestChannel {
+	return &DigestChannel{buffer: buffer}
+}
+
+func (c *DigestChannel) Name() string {
+	return "digest"
+}
+
+func (c *DigestChannel) Enabled(prefs *models.UserPreferences) bool {
+	return prefs.EmailEnabled && (prefs.Frequency == "daily" || prefs.Frequency == "weekly")
+}
+
+func (c *DigestChannel) Deliver(ctx context.Context, to Target, event Event) error {
+	var item templates.DigestItem
+	switch event.Type {
+	case EventPasswordChanged:
+		item = templates.DigestItem{Title: "Password changed", Summary: "Changed " + event.At.UTC().Format("Mon, 02 Jan 2006 15:04 MST")}
+		if event.IPAddress != "" {
+			item.Summary += " from " + event.IPAddress
+		}
+	default:
+		return nil
+	}
+	return c.buffer.Add(ctx, to.User.ID, item)
+}
+
+// DigestPreview is a digest rendered exactly as it would be sent
+type DigestPreview struct {
+	Subject string                 `json:"subject"`
+	HTML    string                 `json:"html"`
+	Text    string                 `json:"text"`
+	Items   []templates.DigestItem `json:"items"`
+}
+
+// PreviewDigest renders the digest user would get from the items pending in
+// buffer now. It only reads the buffer and leaves the items in it.
+func PreviewDigest(ctx context.Context, buffer DigestBuffer, user *models.User, frequency string) (*DigestPreview, error) {
+	items, err := buffer.Pending(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if items == nil {
+		items = []templates.DigestItem{}
+	}
+
+	email, err := templates.Render(templates.Digest, templates.DigestData{
+		Username: user.Username,
+		Period:   digestPeriod(frequency),
+		Items:    items,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &DigestPreview{Subject: email.Subject, HTML: email.HTML, Text: email.Text, Items: items}, nil
+}
+
+// digestPeriod names the span a digest covers for a delivery frequency
+func digestPeriod(frequency string) string {
+	switch frequency {
+	case "daily":
+		return "today"
+	case "weekly":
+		return "this week"
+	default:
+		return "since your last digest"
+	}
+}
@@ -0,0 +1,141 @@
+// File: internal/notification/notifier.go
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/notification/templates"
+)
+
+// Event types
+const (
+	EventRegistered      = "registered"
+	EventPasswordChanged = "password_changed"
+)
+
+// Event is something that happened to a user. It says nothing about
+// delivery; each channel decides how, and whether, to pass it on.
+type Event struct {
+	Type      string
+	IPAddress string // where the action came from, when known
+	At        time.Time
+}
+
+// Notifier tells a user about an event on every channel they have enabled
+type Notifier interface {
+	Notify(ctx context.Context, userID string, event Event) error
+}
+
+// Target is the user an event is delivered to. Preferences is never nil;
+// users who have not saved any get the defaults.
+type Target struct {
+	User        *models.User
+	Preferences *models.UserPreferences
+}
+
+// Channel delivers events over one medium, such as email
+type Channel interface {
+	Name() string
+	// Enabled reports whether the user has turned this channel on
+	Enabled(prefs *models.UserPreferences) bool
+	// Deliver sends event to to. Events the channel has nothing to say
+	// about are skipped without error.
+	Deliver(ctx context.Context, to Target, event Event) error
+}
+
+// UserDirectory looks up who to notify and how. core.UserRepository
+// satisfies it.
+type UserDirectory interface {
+	GetByID(ctx context.Context, id string) (*models.User, error)
+	GetPreferences(ctx context.Context, userID string) (*models.UserPreferences, error)
+}
+
+// Dispatcher is a Notifier that fans events out to its channels
+type Dispatcher struct {
+	users    UserDirectory
+	channels []Channel
+}
+
+func NewDispatcher(users UserDirectory, channels ...Channel) *Dispatcher {
+	return &Dispatcher{users: users, channels: channels}
+}
+
+// Notify delivers event on each enabled channel. A failing channel does not
+// stop the others; their errors are joined.
+func (d *Dispatcher) Notify(ctx context.Context, userID string, event Event) error {
+	user, err := d.users.GetByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("look up user: %w", err)
+	}
+	prefs, err := d.users.GetPreferences(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("look up preferences: %w", err)
+	}
+	if prefs == nil {
+		prefs = models.DefaultPreferences(userID)
+	}
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+
+	to := Target{User: user, Preferences: prefs}
+	var errs []error
+	for _, channel := range d.channels {
+		if !channel.Enabled(prefs) {
+			continue
+		}
+		if err := channel.Deliver(ctx, to, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", channel.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// EmailChannel delivers events as templated emails through a Sender
+type EmailChannel struct {
+	sender Sender
+}
+
+func NewEmailChannel(sender Sender) *EmailChannel {
+	return &EmailChannel{sender: sender}
+}
+
+func (c *EmailChannel) Name() string {
+	return "email"
+}
+
+func (c *EmailChannel) Enabled(prefs *models.UserPreferences) bool {
+	return prefs.EmailEnabled
+}
+
+func (c *EmailChannel) Deliver(ctx context.Context, to Target, event Event) error {
+	var name string
+	var data interface{}
+	switch event.Type {
+	case EventRegistered:
+		name, data = templates.Welcome, templates.WelcomeData{Username: to.User.Username}
+	case EventPasswordChanged:
+		name, data = templates.PasswordChanged, templates.PasswordChangedData{
+			Username:  to.User.Username,
+			IPAddress: event.IPAddress,
+			At:        event.At.UTC().Format("Mon, 02 Jan 2006 15:04 MST"),
+		}
+	default:
+		return nil
+	}
+
+	email, err := templates.Render(name, data)
+	if err != nil {
+		return err
+	}
+	return c.sender.Send(ctx, Message{
+		To:       Recipient(to.User.Email, to.Preferences),
+		Subject:  email.Subject,
+		TextBody: email.Text,
+		HTMLBody: email.HTML,
+	})
+}
@@ -0,0 +1,176 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/notification/templates"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDirectory struct {
+	user  *models.User
+	prefs *models.UserPreferences
+}
+
+func (d *fakeDirectory) GetByID(ctx context.Context, id string) (*models.User, error) {
+	return d.user, nil
+}
+
+func (d *fakeDirectory) GetPreferences(ctx context.Context, userID string) (*models.UserPreferences, error) {
+	return d.prefs, nil
+}
+
+type recordingSender struct {
+	sent []Message
+	err  error
+}
+
+func (s *recordingSender) Send(ctx context.Context, msg Message) error {
+	s.sent = append(s.sent, msg)
+	return s.err
+}
+
+type fakeBuffer struct {
+	items map[string][]templates.DigestItem
+}
+
+func (b *fakeBuffer) Add(ctx context.Context, userID string, item templates.DigestItem) error {
+	if b.items == nil {
+		b.items = make(map[string][]templates.DigestItem)
+	}
+	b.items[userID] = append(b.items[userID], item)
+	return nil
+}
+
+func (b *fakeBuffer) Pending(ctx context.Context, userID string) ([]templates.DigestItem, error) {
+	return b.items[userID], nil
+}
+
+// fakeChannel stands in for a future channel such as push, switched by
+// the frequency preference so it can be toggled independently of email
+type fakeChannel struct {
+	delivered []Event
+}
+
+func (c *fakeChannel) Name() string { return "fake" }
+
+func (c *fakeChannel) Enabled(prefs *models.UserPreferences) bool {
+	return prefs.Frequency == "immediate"
+}
+
+func (c *fakeChannel) Deliver(ctx context.Context, to Target, event Event) error {
+	c.delivered = append(c.delivered, event)
+	return nil
+}
+
+func TestDispatcherNotify(t *testing.T) {
+	user := &models.User{ID: "user-1", Username: "alice", Email: "alice@example.com"}
+	event := Event{Type: EventPasswordChanged, IPAddress: "203.0.113.7", At: time.Date(2025, 6, 1, 14, 30, 0, 0, time.UTC)}
+
+	t.Run("EmailEnabled", func(t *testing.T) {
+		sender := &recordingSender{}
+		d := NewDispatcher(&fakeDirectory{user: user, prefs: &models.UserPreferences{EmailEnabled: true}}, NewEmailChannel(sender))
+
+		require.NoError(t, d.Notify(context.Background(), user.ID, event))
+		require.Len(t, sender.sent, 1)
+		assert.Equal(t, "alice@example.com", sender.sent[0].To)
+		assert.Equal(t, "Your password was changed", sender.sent[0].Subject)
+		assert.Contains(t, sender.sent[0].TextBody, "from 203.0.113.7")
+	})
+
+	t.Run("DefaultsWhenNoPreferencesSaved", func(t *testing.T) {
+		sender := &recordingSender{}
+		d := NewDispatcher(&fakeDirectory{user: user}, NewEmailChannel(sender))
+
+		require.NoError(t, d.Notify(context.Background(), user.ID, Event{Type: EventRegistered}))
+		require.Len(t, sender.sent, 1)
+		assert.Equal(t, "Welcome to Azlo", sender.sent[0].Subject)
+	})
+
+	t.Run("VerifiedNotificationEmail", func(t *testing.T) {
+		sender := &recordingSender{}
+		prefs := &models.UserPreferences{EmailEnabled: true, NotificationEmail: "alerts@example.com", NotificationEmailVerified: true}
+		d := NewDispatcher(&fakeDirectory{user: user, prefs: prefs}, NewEmailChannel(sender))
+
+		require.NoError(t, d.Notify(context.Background(), user.ID, event))
+		require.Len(t, sender.sent, 1)
+		assert.Equal(t, "alerts@example.com", sender.sent[0].To)
+	})
+
+	t.Run("UnverifiedNotificationEmailIgnored", func(t *testing.T) {
+		sender := &recordingSender{}
+		prefs := &models.UserPreferences{EmailEnabled: true, NotificationEmail: "alerts@example.com"}
+		d := NewDispatcher(&fakeDirectory{user: user, prefs: prefs}, NewEmailChannel(sender))
+
+		require.NoError(t, d.Notify(context.Background(), user.ID, event))
+		require.Len(t, sender.sent, 1)
+		assert.Equal(t, "alice@example.com", sender.sent[0].To)
+	})
+
+	t.Run("DisabledChannelSkipped", func(t *testing.T) {
+		sender := &recordingSender{}
+		other := &fakeChannel{}
+		prefs := &models.UserPreferences{EmailEnabled: false, Frequency: "immediate"}
+		d := NewDispatcher(&fakeDirectory{user: user, prefs: prefs}, NewEmailChannel(sender), other)
+
+		require.NoError(t, d.Notify(context.Background(), user.ID, event))
+		assert.Empty(t, sender.sent)
+		assert.Equal(t, []Event{event}, other.delivered)
+	})
+
+	t.Run("FailingChannelDoesNotStopOthers", func(t *testing.T) {
+		sender := &recordingSender{err: errors.New("relay down")}
+		other := &fakeChannel{}
+		prefs := &models.UserPreferences{EmailEnabled: true, Frequency: "immediate"}
+		d := NewDispatcher(&fakeDirectory{user: user, prefs: prefs}, NewEmailChannel(sender), other)
+
+		err := d.Notify(context.Background(), user.ID, event)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "email: relay down")
+		assert.Len(t, other.delivered, 1)
+	})
+}
+
+func TestDigestChannel(t *testing.T) {
+	user := &models.User{ID: "user-1", Username: "alice", Email: "alice@example.com"}
+	event := Event{Type: EventPasswordChanged, IPAddress: "203.0.113.7", At: time.Date(2025, 6, 1, 14, 30, 0, 0, time.UTC)}
+
+	notify := func(t *testing.T, prefs *models.UserPreferences, event Event) *fakeBuffer {
+		buffer := &fakeBuffer{}
+		d := NewDispatcher(&fakeDirectory{user: user, prefs: prefs}, NewEmailChannel(&recordingSender{}), NewDigestChannel(buffer))
+		require.NoError(t, d.Notify(context.Background(), user.ID, event))
+		return buffer
+	}
+
+	t.Run("QueuedForDigestUsers", func(t *testing.T) {
+		buffer := notify(t, &models.UserPreferences{EmailEnabled: true, Frequency: "daily"}, event)
+		require.Len(t, buffer.items["user-1"], 1)
+		assert.Equal(t, "Password changed", buffer.items["user-1"][0].Title)
+		assert.Contains(t, buffer.items["user-1"][0].Summary, "from 203.0.113.7")
+
+		preview, err := PreviewDigest(context.Background(), buffer, user, "daily")
+		require.NoError(t, err)
+		assert.Contains(t, preview.Text, "Password changed")
+	})
+
+	t.Run("ImmediateUsersGetNoDigest", func(t *testing.T) {
+		buffer := notify(t, &models.UserPreferences{EmailEnabled: true, Frequency: "immediate"}, event)
+		assert.Empty(t, buffer.items)
+	})
+
+	t.Run("EmailDisabled", func(t *testing.T) {
+		buffer := notify(t, &models.UserPreferences{Frequency: "weekly"}, event)
+		assert.Empty(t, buffer.items)
+	})
+
+	t.Run("WelcomeNotQueued", func(t *testing.T) {
+		buffer := notify(t, &models.UserPreferences{EmailEnabled: true, Frequency: "weekly"}, Event{Type: EventRegistered})
+		assert.Empty(t, buffer.items)
+	})
+}
@@ -0,0 +1,195 @@
+// File: internal/notification/sender.go
+package notification
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/models"
+)
+
+// ErrNotConfigured is returned when no SMTP host has been configured
+var ErrNotConfigured = errors.New("email delivery is not configured")
+
+// Message is a single outgoing email
+type Message struct {
+	To       string
+	Subject  string
+	TextBody string
+	HTMLBody string
+}
+
+// Recipient picks the address a user's notifications go to. An unverified
+// notification email is ignored so mail never reaches an address the user
+// hasn't proven they control.
+func Recipient(primaryEmail string, prefs *models.UserPreferences) string {
+	if prefs != nil && prefs.NotificationEmailVerified && prefs.NotificationEmail != "" {
+		return prefs.NotificationEmail
+	}
+	return primaryEmail
+}
+
+// Sender delivers email messages
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SendError wraps a delivery failure with the SMTP stage it happened in.
+// Its Error() text is safe to show to operators: it never includes
+// credentials or the raw server conversation.
+type SendError struct {
+	Stage string // connect, tls, auth, sender, recipient, data
+	Code  int    // SMTP reply code when the server rejected the command
+	Err   error
+}
+
+func (e *SendError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("smtp %s failed (code %d)", e.Stage, e.Code)
+	}
+	return fmt.Sprintf("smtp %s failed", e.Stage)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// SMTPSender delivers email through an SMTP relay. Port 465 uses implicit
+// TLS; any other port requires STARTTLS before authenticating.
+type SMTPSender struct {
+	host     string
+	port     int
+	user     string
+	password string
+	from     string
+	timeout  time.Duration
+}
+
+func NewSMTPSender(cfg *config.Config) *SMTPSender {
+	return &SMTPSender{
+		host:     cfg.SMTPHost,
+		port:     cfg.SMTPPort,
+		user:     cfg.SMTPUser,
+		password: cfg.SMTPPassword,
+		from:     cfg.SMTPFrom,
+		timeout:  15 * time.Second,
+	}
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	if s.host == "" {
+		return ErrNotConfigured
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(s.host, strconv.Itoa(s.port))
+	tlsConfig := &tls.Config{ServerName: s.host, MinVersion: tls.VersionTLS12}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{}
+	if s.port == 465 {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return &SendError{Stage: "connect", Err: err}
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return stageError("connect", err)
+	}
+	defer client.Close()
+
+	if s.port != 465 {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return &SendError{Stage: "tls", Err: errors.New("server does not support STARTTLS")}
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return stageError("tls", err)
+		}
+	}
+
+	if s.user != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.user, s.password, s.host)); err != nil {
+			return stageError("auth", err)
+		}
+	}
+
+	if err := client.Mail(s.from); err != nil {
+		return stageError("sender", err)
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return stageError("recipient", err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return stageError("data", err)
+	}
+	if _, err := w.Write(buildMIME(s.from, msg)); err != nil {
+		return stageError("data", err)
+	}
+	if err := w.Close(); err != nil {
+		return stageError("data", err)
+	}
+
+	return client.Quit()
+}
+
+func stageError(stage string, err error) *SendError {
+	sendErr := &SendError{Stage: stage, Err: err}
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		sendErr.Code = protoErr.Code
+	}
+	return sendErr
+}
+
+// buildMIME renders the message as multipart/alternative when both bodies are set
+func buildMIME(from string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + headerValue(from) + "\r\n")
+	b.WriteString("To: " + headerValue(msg.To) + "\r\n")
+	b.WriteString("Subject: " + headerValue(msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().UTC().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTMLBody == "" {
+		b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+		b.WriteString(msg.TextBody)
+		return []byte(b.String())
+	}
+
+	boundary := fmt.Sprintf("azlo-%d", time.Now().UnixNano())
+	b.WriteString("Content-Type: multipart/alternative; boundary=" + boundary + "\r\n\r\n")
+	b.WriteString("--" + boundary + "\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(msg.TextBody + "\r\n")
+	b.WriteString("--" + boundary + "\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n")
+	b.WriteString(msg.HTMLBody + "\r\n")
+	b.WriteString("--" + boundary + "--\r\n")
+	return []byte(b.String())
+}
+
+// headerValue strips line breaks so user input cannot inject extra headers
+func headerValue(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
@@ -0,0 +1,172 @@
+// Package templates renders notification emails from embedded templates.
+//
+// Every email type has a name.html and a name.txt file under files/. The HTML
+// file fills the "content" block of layout.html and is rendered with
+// html/template, so values such as usernames are escaped. The text file fills
+// layout.txt and also defines the "subject" template. Subjects and text
+// bodies are plain text and are not escaped; notification.Sender strips line
+// breaks from headers.
+package templates
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Email types
+const (
+	Verification      = "verification"
+	NotificationEmail = "notification_email"
+	RecoveryEmail     = "recovery_email"
+	RecoveryChanged   = "recovery_email_changed"
+	PasswordReset     = "password_reset"
+	LockoutAlert      = "lockout_alert"
+	Digest            = "digest"
+	TestMessage       = "test"
+	Welcome           = "welcome"
+	PasswordChanged   = "password_changed"
+)
+
+//go:embed files
+var files embed.FS
+
+var (
+	htmlPages map[string]*htmltemplate.Template
+	textPages map[string]*texttemplate.Template
+)
+
+func init() {
+	htmlPages, textPages = mustParse(files)
+}
+
+// Rendered is one email ready to send
+type Rendered struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// Render executes the named email with data, producing both variants
+func Render(name string, data interface{}) (*Rendered, error) {
+	htmlPage, ok := htmlPages[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown email template %q", name)
+	}
+	textPage := textPages[name]
+
+	var subject, html, text bytes.Buffer
+	if err := textPage.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, fmt.Errorf("render %s subject: %w", name, err)
+	}
+	if err := htmlPage.ExecuteTemplate(&html, "layout", data); err != nil {
+		return nil, fmt.Errorf("render %s html: %w", name, err)
+	}
+	if err := textPage.ExecuteTemplate(&text, "layout", data); err != nil {
+		return nil, fmt.Errorf("render %s text: %w", name, err)
+	}
+
+	return &Rendered{
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    html.String(),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}, nil
+}
+
+// Names lists the available email types
+func Names() []string {
+	names := make([]string, 0, len(htmlPages))
+	for name := range htmlPages {
+		names = append(names, name)
+	}
+	return names
+}
+
+// mustParse builds one template set per email type on top of its layout.
+// The files are embedded, so a parse error is a bug and panics at startup.
+func mustParse(fsys fs.FS) (map[string]*htmltemplate.Template, map[string]*texttemplate.Template) {
+	htmlLayout := htmltemplate.Must(htmltemplate.ParseFS(fsys, "files/layout.html"))
+	textLayout := texttemplate.Must(texttemplate.ParseFS(fsys, "files/layout.txt"))
+
+	pages, err := fs.Glob(fsys, "files/*.html")
+	if err != nil {
+		panic(err)
+	}
+
+	htmlSets := make(map[string]*htmltemplate.Template)
+	textSets := make(map[string]*texttemplate.Template)
+	for _, page := range pages {
+		name := strings.TrimSuffix(path.Base(page), ".html")
+		if name == "layout" {
+			continue
+		}
+		htmlSets[name] = htmltemplate.Must(htmltemplate.Must(htmlLayout.Clone()).ParseFS(fsys, page))
+		textSets[name] = texttemplate.Must(texttemplate.Must(textLayout.Clone()).ParseFS(fsys, "files/"+name+".txt"))
+	}
+	return htmlSets, textSets
+}
+
+// Data types for each email. Username may be empty; the templates then fall
+// back to a generic greeting.
+
+// LinkData is used by Verification, NotificationEmail, RecoveryEmail and
+// PasswordReset
+type LinkData struct {
+	Username string
+	Link     string
+}
+
+// LockoutAlertData is used by LockoutAlert
+type LockoutAlertData struct {
+	Username  string
+	IPAddress string
+	Until     string // when the lock lifts, already formatted for the reader
+	ResetLink string
+}
+
+// DigestData is used by Digest
+type DigestData struct {
+	Username string
+	Period   string // e.g. "this week"
+	Items    []DigestItem
+}
+
+// DigestItem is one entry in a digest
+type DigestItem struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary,omitempty"`
+	Link    string `json:"link,omitempty"`
+}
+
+// TestMessageData is used by TestMessage. Username is usually left empty,
+// as the recipient need not be a user.
+type TestMessageData struct {
+	Username string
+	SentAt   string
+}
+
+// WelcomeData is used by Welcome
+type WelcomeData struct {
+	Username string
+}
+
+// RecoveryChangedData is used by RecoveryChanged, which goes to the login
+// email
+type RecoveryChangedData struct {
+	Username  string
+	Address   string // the new recovery email
+	IPAddress string
+	At        string // when the change happened, already formatted for the reader
+}
+
+// PasswordChangedData is used by PasswordChanged
+type PasswordChangedData struct {
+	Username  string
+	IPAddress string
+	At        string // when the change happened, already formatted for the reader
+}
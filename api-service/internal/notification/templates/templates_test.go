@@ -0,0 +1,121 @@
+package templates
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	cases := []struct {
+		name    string
+		data    interface{}
+		subject string
+		content []string // expected in both variants
+	}{
+		{
+			name:    Verification,
+			data:    LinkData{Username: "alice", Link: "https://example.com/verify-email?token=abc"},
+			subject: "Confirm your email address",
+			content: []string{"Hi alice,", "https://example.com/verify-email?token=abc"},
+		},
+		{
+			name:    NotificationEmail,
+			data:    LinkData{Link: "https://example.com/auth/verify-notification-email?token=abc"},
+			subject: "Confirm your notification email",
+			content: []string{"Hi,", "https://example.com/auth/verify-notification-email?token=abc"},
+		},
+		{
+			name:    RecoveryEmail,
+			data:    LinkData{Link: "https://example.com/auth/verify-recovery-email?token=abc"},
+			subject: "Confirm your recovery email",
+			content: []string{"Hi,", "account recovery", "https://example.com/auth/verify-recovery-email?token=abc"},
+		},
+		{
+			name:    RecoveryChanged,
+			data:    RecoveryChangedData{Username: "alice", Address: "backup@example.net", IPAddress: "203.0.113.7", At: "14:30 UTC"},
+			subject: "Your recovery email was changed",
+			content: []string{"backup@example.net was set", "at 14:30 UTC", "from 203.0.113.7", "change your password"},
+		},
+		{
+			name:    PasswordReset,
+			data:    LinkData{Username: "alice", Link: "https://example.com/reset-password?token=abc"},
+			subject: "Reset your password",
+			content: []string{"within the next hour", "https://example.com/reset-password?token=abc"},
+		},
+		{
+			name:    LockoutAlert,
+			data:    LockoutAlertData{Username: "alice", IPAddress: "203.0.113.7", Until: "14:30 UTC", ResetLink: "https://example.com/forgot"},
+			subject: "Your account has been locked",
+			content: []string{"from 203.0.113.7", "It unlocks at 14:30 UTC", "https://example.com/forgot"},
+		},
+		{
+			name: Digest,
+			data: DigestData{Username: "alice", Period: "this week", Items: []DigestItem{
+				{Title: "New sign-in", Summary: "From a new device", Link: "https://example.com/activity"},
+				{Title: "Password changed"},
+			}},
+			subject: "Your digest for this week",
+			content: []string{"New sign-in", "From a new device", "https://example.com/activity", "Password changed"},
+		},
+		{
+			name:    TestMessage,
+			data:    TestMessageData{SentAt: "Mon, 02 Jan 2006 15:04:05 UTC"},
+			subject: "Test email from Azlo",
+			content: []string{"Mon, 02 Jan 2006 15:04:05 UTC", "configured correctly"},
+		},
+		{
+			name:    Welcome,
+			data:    WelcomeData{Username: "alice"},
+			subject: "Welcome to Azlo",
+			content: []string{"Hi alice,", "notification preferences"},
+		},
+		{
+			name:    PasswordChanged,
+			data:    PasswordChangedData{Username: "alice", IPAddress: "203.0.113.7", At: "14:30 UTC"},
+			subject: "Your password was changed",
+			content: []string{"at 14:30 UTC", "from 203.0.113.7", "reset your password"},
+		},
+	}
+
+	require.Len(t, Names(), len(cases), "every template is covered")
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := Render(tc.name, tc.data)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.subject, out.Subject)
+			assert.True(t, strings.HasPrefix(out.HTML, "<!DOCTYPE html>"), "HTML uses the layout")
+			assert.Contains(t, out.HTML, "automated message from Azlo")
+			assert.Contains(t, out.Text, "automated message from Azlo")
+			for _, want := range tc.content {
+				assert.Contains(t, out.HTML, want)
+				assert.Contains(t, out.Text, want)
+			}
+		})
+	}
+}
+
+func TestRenderEscapesUsername(t *testing.T) {
+	name := `<script>alert("x")</script>&co`
+	out, err := Render(Verification, LinkData{Username: name, Link: "https://example.com/verify-email?token=abc"})
+	require.NoError(t, err)
+
+	assert.NotContains(t, out.HTML, "<script>")
+	assert.Contains(t, out.HTML, "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;&amp;co")
+	// The plaintext variant is not HTML and keeps the name as typed
+	assert.Contains(t, out.Text, name)
+}
+
+func TestRenderEscapesLinks(t *testing.T) {
+	out, err := Render(Verification, LinkData{Link: `javascript:alert(1)`})
+	require.NoError(t, err)
+	assert.NotContains(t, out.HTML, `href="javascript:`)
+}
+
+func TestRenderUnknown(t *testing.T) {
+	_, err := Render("missing", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,60 @@
+package oauth
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/models"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestGoogle(t *testing.T) {
+	cfg := &config.Config{GoogleClientID: "client-id", GoogleClientSecret: "client-secret", PublicURL: "https://app.example.com/"}
+
+	t.Run("AuthCodeURL", func(t *testing.T) {
+		u, err := url.Parse(NewGoogle(cfg, nil).AuthCodeURL("state-1", "verifier-1"))
+		require.NoError(t, err)
+		q := u.Query()
+		assert.Equal(t, "accounts.google.com", u.Host)
+		assert.Equal(t, "state-1", q.Get("state"))
+		assert.Equal(t, "https://app.example.com/auth/oauth/google/callback", q.Get("redirect_uri"))
+		assert.Equal(t, "S256", q.Get("code_challenge_method"))
+		assert.Equal(t, oauth2.S256ChallengeFromVerifier("verifier-1"), q.Get("code_challenge"))
+	})
+
+	t.Run("Exchange", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "code-1", r.PostForm.Get("code"))
+			assert.Equal(t, "verifier-1", r.PostForm.Get("code_verifier"))
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "access-1", "token_type": "Bearer", "expires_in": 3600})
+		})
+		mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "Bearer access-1", r.Header.Get("Authorization"))
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"sub": "g-123", "email": "alice@example.com", "email_verified": true, "name": "Alice",
+			})
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		g := NewGoogle(cfg, server.Client())
+		g.conf.Endpoint = oauth2.Endpoint{AuthURL: server.URL + "/auth", TokenURL: server.URL + "/token"}
+		g.userInfoURL = server.URL + "/userinfo"
+
+		profile, err := g.Exchange(context.Background(), "code-1", "verifier-1")
+		require.NoError(t, err)
+		assert.Equal(t, &models.OAuthProfile{
+			Provider: models.ProviderGoogle, Subject: "g-123", Email: "alice@example.com", EmailVerified: true, Name: "Alice",
+		}, profile)
+	})
+}
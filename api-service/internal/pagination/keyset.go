@@ -0,0 +1,109 @@
+// File: internal/pagination/keyset.go
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultLimit is the page size used when the client does not ask for one
+	DefaultLimit = 20
+	// MaxLimit is the hard upper bound for keyset pages unless configured otherwise
+	MaxLimit = 100
+)
+
+// ErrInvalidCursor is returned when a client-supplied cursor cannot be decoded,
+// or holds an ID that is not a UUID
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks a position in a list ordered by created_at DESC, id DESC.
+// Clients only ever see its opaque encoded form.
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"i"`
+}
+
+// Params holds the normalized keyset pagination input for a repository query
+type Params struct {
+	Before *Cursor
+	Limit  int
+}
+
+// Encode returns the opaque, URL-safe representation of the cursor
+func (c Cursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor parses an opaque cursor. An empty string means "first page"
+// and yields a nil cursor without error.
+func DecodeCursor(encoded string) (*Cursor, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.CreatedAt.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	// Every keyset-paged table has a UUID key; anything else would only
+	// fail later as a database error
+	id, err := uuid.Parse(c.ID)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c.ID = id.String()
+
+	return &c, nil
+}
+
+// NewParams decodes the "before" cursor and clamps the limit to [1, maxLimit]
+func NewParams(before string, limit, maxLimit int) (Params, error) {
+	cursor, err := DecodeCursor(before)
+	if err != nil {
+		return Params{}, err
+	}
+
+	if maxLimit < 1 {
+		maxLimit = MaxLimit
+	}
+	if limit < 1 {
+		limit = min(DefaultLimit, maxLimit)
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	return Params{Before: cursor, Limit: limit}, nil
+}
+
+// FetchLimit is the number of rows a repository should request: one more than
+// the page size, so that the presence of a further page can be detected
+// without a COUNT query.
+func (p Params) FetchLimit() int {
+	return p.Limit + 1
+}
+
+// Page trims rows fetched with FetchLimit down to the page size and returns
+// the cursor for the next page, or an empty string on the last page.
+func Page[T any](rows []T, p Params, cursorOf func(T) Cursor) ([]T, string) {
+	if len(rows) <= p.Limit {
+		return rows, ""
+	}
+
+	rows = rows[:p.Limit]
+	return rows, cursorOf(rows[len(rows)-1]).Encode()
+}
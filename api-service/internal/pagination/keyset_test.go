@@ -0,0 +1,171 @@
+package pagination
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type row struct {
+	ID        string
+	CreatedAt time.Time
+}
+
+func rowCursor(r row) Cursor {
+	return Cursor{CreatedAt: r.CreatedAt, ID: r.ID}
+}
+
+// query mimics the repository SQL: ORDER BY created_at DESC, id DESC with a
+// (created_at, id) < (before) predicate and a LIMIT of p.FetchLimit().
+func query(data []row, p Params) []row {
+	sorted := append([]row(nil), data...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].CreatedAt.Equal(sorted[j].CreatedAt) {
+			return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+		}
+		return sorted[i].ID > sorted[j].ID
+	})
+
+	var out []row
+	for _, r := range sorted {
+		if p.Before != nil {
+			if r.CreatedAt.After(p.Before.CreatedAt) {
+				continue
+			}
+			if r.CreatedAt.Equal(p.Before.CreatedAt) && r.ID >= p.Before.ID {
+				continue
+			}
+		}
+		out = append(out, r)
+		if len(out) == p.FetchLimit() {
+			break
+		}
+	}
+	return out
+}
+
+func TestCursorEncoding(t *testing.T) {
+	t.Run("RoundTrip", func(t *testing.T) {
+		c := Cursor{CreatedAt: time.Date(2025, 3, 1, 12, 0, 0, 123456000, time.UTC), ID: "0f8fad5b-d9cb-469f-a165-70867728950e"}
+
+		decoded, err := DecodeCursor(c.Encode())
+
+		require.NoError(t, err)
+		assert.True(t, c.CreatedAt.Equal(decoded.CreatedAt))
+		assert.Equal(t, c.ID, decoded.ID)
+	})
+
+	t.Run("EncodedFormIsURLSafe", func(t *testing.T) {
+		c := Cursor{CreatedAt: time.Now(), ID: "??>>//++"}
+		assert.NotContains(t, c.Encode(), "+")
+		assert.NotContains(t, c.Encode(), "/")
+		assert.NotContains(t, c.Encode(), "=")
+	})
+
+	t.Run("EmptyMeansFirstPage", func(t *testing.T) {
+		c, err := DecodeCursor("")
+		assert.NoError(t, err)
+		assert.Nil(t, c)
+	})
+
+	t.Run("RejectsGarbage", func(t *testing.T) {
+		for _, bad := range []string{"not base64!", "bm90IGpzb24", "e30"} { // "not json", "{}"
+			_, err := DecodeCursor(bad)
+			assert.ErrorIs(t, err, ErrInvalidCursor, bad)
+		}
+	})
+
+	t.Run("RejectsNonUUIDID", func(t *testing.T) {
+		for _, id := range []string{"", "abc-123", "0f8fad5b-d9cb-469f-a165-70867728950", "1' OR '1'='1"} {
+			_, err := DecodeCursor(Cursor{CreatedAt: time.Now(), ID: id}.Encode())
+			assert.ErrorIs(t, err, ErrInvalidCursor, id)
+		}
+	})
+}
+
+func TestNewParams(t *testing.T) {
+	p, err := NewParams("", 0, 50)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultLimit, p.Limit)
+
+	p, err = NewParams("", 500, 50)
+	require.NoError(t, err)
+	assert.Equal(t, 50, p.Limit)
+
+	p, err = NewParams("", 5, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 5, p.Limit)
+
+	_, err = NewParams("%%%", 5, 50)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestPageIteration(t *testing.T) {
+	// 23 rows where several share the same timestamp, so ordering must fall
+	// back to the id tie-breaker to stay stable across pages.
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var data []row
+	for i := 0; i < 23; i++ {
+		data = append(data, row{
+			ID:        fmt.Sprintf("00000000-0000-0000-0000-%012d", i),
+			CreatedAt: base.Add(time.Duration(i/3) * time.Minute),
+		})
+	}
+
+	seen := map[string]bool{}
+	var ordered []row
+	before := ""
+	pages := 0
+
+	for {
+		p, err := NewParams(before, 5, MaxLimit)
+		require.NoError(t, err)
+
+		items, next := Page(query(data, p), p, rowCursor)
+		pages++
+		for _, r := range items {
+			assert.False(t, seen[r.ID], "row %s returned twice", r.ID)
+			seen[r.ID] = true
+			ordered = append(ordered, r)
+		}
+
+		if next == "" {
+			// Last page: fewer than or exactly the remaining rows, no cursor
+			assert.LessOrEqual(t, len(items), 5)
+			break
+		}
+		assert.Len(t, items, 5)
+		before = next
+		require.Less(t, pages, 10, "pagination did not terminate")
+	}
+
+	assert.Equal(t, 5, pages)
+	assert.Len(t, seen, len(data))
+	assert.Equal(t, query(data, Params{Limit: len(data)})[:len(data)], ordered)
+}
+
+func TestPageExactMultipleTerminates(t *testing.T) {
+	base := time.Now()
+	a, b, c, d := uuid.NewString(), uuid.NewString(), uuid.NewString(), uuid.NewString()
+	data := []row{
+		{ID: a, CreatedAt: base},
+		{ID: b, CreatedAt: base.Add(-time.Second)},
+		{ID: c, CreatedAt: base.Add(-2 * time.Second)},
+		{ID: d, CreatedAt: base.Add(-3 * time.Second)},
+	}
+
+	p, _ := NewParams("", 2, MaxLimit)
+	first, next := Page(query(data, p), p, rowCursor)
+	require.NotEmpty(t, next)
+	assert.Equal(t, []string{a, b}, []string{first[0].ID, first[1].ID})
+
+	p, _ = NewParams(next, 2, MaxLimit)
+	second, next := Page(query(data, p), p, rowCursor)
+	assert.Equal(t, []string{c, d}, []string{second[0].ID, second[1].ID})
+	assert.Empty(t, next, "a full final page must not advertise another page")
+}
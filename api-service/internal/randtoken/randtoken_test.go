@@ -0,0 +1,54 @@
+package randtoken
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenLengthAndUniqueness(t *testing.T) {
+	g := New(0)
+
+	seen := make(map[string]bool)
+	for range 1000 {
+		token, err := g.Token()
+		require.NoError(t, err)
+
+		raw, err := base64.RawURLEncoding.DecodeString(token)
+		require.NoError(t, err, "tokens are unpadded URL-safe base64")
+		assert.Len(t, raw, DefaultBytes)
+
+		assert.False(t, seen[token], "token repeated")
+		seen[token] = true
+	}
+}
+
+func TestConfiguredSize(t *testing.T) {
+	token, err := New(48).Token()
+	require.NoError(t, err)
+	assert.Len(t, token, base64.RawURLEncoding.EncodedLen(48))
+
+	// Too short to be safe, so the default applies
+	token, err = New(8).Token()
+	require.NoError(t, err)
+	assert.Len(t, token, base64.RawURLEncoding.EncodedLen(DefaultBytes))
+}
+
+func TestSeededIsDeterministic(t *testing.T) {
+	a, b := NewSeeded(42, 0), NewSeeded(42, 0)
+	for range 3 {
+		ta, err := a.Token()
+		require.NoError(t, err)
+		tb, err := b.Token()
+		require.NoError(t, err)
+		assert.Equal(t, ta, tb)
+	}
+
+	other, err := NewSeeded(43, 0).Token()
+	require.NoError(t, err)
+	first, err := NewSeeded(42, 0).Token()
+	require.NoError(t, err)
+	assert.NotEqual(t, first, other)
+}
@@ -0,0 +1,185 @@
+// File: internal/readiness/readiness.go
+package readiness
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+)
+
+// Pinger is satisfied by *pgxpool.Pool
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Monitor tracks whether the database is reachable at runtime. Queries that
+// fail with a connection-level error flip it to not-ready; Run then pings in
+// the background and flips it back once the database answers again.
+//
+// Monitor implements pgx.QueryTracer and pgxpool.AcquireTracer so every
+// repository query is observed without changes to the repositories.
+type Monitor struct {
+	ready    atomic.Bool
+	draining atomic.Bool
+	inFlight atomic.Int64
+	lost     chan struct{}
+	interval time.Duration
+	logger   zerolog.Logger
+}
+
+func NewMonitor(interval time.Duration, logger zerolog.Logger) *Monitor {
+	m := &Monitor{
+		lost:     make(chan struct{}, 1),
+		interval: interval,
+		logger:   logger,
+	}
+	m.ready.Store(true)
+	return m
+}
+
+// Ready reports whether the instance should receive traffic
+func (m *Monitor) Ready() bool {
+	return m.ready.Load() && !m.draining.Load()
+}
+
+// Drain marks the instance as shutting down. It stays not-ready from then on,
+// whatever the database does.
+func (m *Monitor) Drain() {
+	if m.draining.CompareAndSwap(false, true) {
+		m.logger.Info().Msg("Shutdown started, marking instance not ready")
+	}
+}
+
+// Draining reports whether Drain has been called
+func (m *Monitor) Draining() bool {
+	return m.draining.Load()
+}
+
+// RequestStarted counts a request that got past the shutdown gate; pair it
+// with RequestDone
+func (m *Monitor) RequestStarted() {
+	m.inFlight.Add(1)
+}
+
+// RequestDone counts a request finished
+func (m *Monitor) RequestDone() {
+	m.inFlight.Add(-1)
+}
+
+// InFlight returns how many requests are being served
+func (m *Monitor) InFlight() int64 {
+	return m.inFlight.Load()
+}
+
+// WaitIdle polls every interval until no requests are in flight, and reports
+// whether that happened before ctx was done
+func (m *Monitor) WaitIdle(ctx context.Context, interval time.Duration) bool {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for m.inFlight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// Observe marks the database unavailable when err is a connection failure
+func (m *Monitor) Observe(err error) {
+	if !IsConnectionError(err) {
+		return
+	}
+	if m.ready.CompareAndSwap(true, false) {
+		m.logger.Error().Err(err).Msg("Database connection lost, marking instance not ready")
+	}
+	select {
+	case m.lost <- struct{}{}:
+	default:
+	}
+}
+
+// Run waits for connection loss and pings db until it recovers. It returns when ctx is done.
+func (m *Monitor) Run(ctx context.Context, db Pinger) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.lost:
+		}
+
+		ticker := time.NewTicker(m.interval)
+		for !m.ready.Load() {
+			select {
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			case <-ticker.C:
+			}
+
+			pingCtx, cancel := context.WithTimeout(ctx, m.interval)
+			err := db.Ping(pingCtx)
+			cancel()
+			if err == nil {
+				m.ready.Store(true)
+				m.logger.Info().Msg("Database connection recovered, marking instance ready")
+			}
+		}
+		ticker.Stop()
+	}
+}
+
+func (m *Monitor) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return ctx
+}
+
+func (m *Monitor) TraceQueryEnd(_ context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	m.Observe(data.Err)
+}
+
+func (m *Monitor) TraceAcquireStart(ctx context.Context, _ *pgxpool.Pool, _ pgxpool.TraceAcquireStartData) context.Context {
+	return ctx
+}
+
+func (m *Monitor) TraceAcquireEnd(_ context.Context, _ *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	m.Observe(data.Err)
+}
+
+// IsConnectionError reports whether err means the database could not be
+// reached, as opposed to a query-level failure such as a constraint violation.
+// A cancelled or timed-out context is the caller giving up, not the database
+// going away, so it never counts; it is checked first because
+// context.DeadlineExceeded also satisfies net.Error.
+func IsConnectionError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08 is connection exception; 57P0x covers server shutdown
+		return strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "57P0")
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed)
+}
@@ -0,0 +1,84 @@
+package readiness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyDB fails Ping until healthy is set
+type flakyDB struct {
+	healthy atomic.Bool
+	pings   atomic.Int32
+}
+
+func (f *flakyDB) Ping(ctx context.Context) error {
+	f.pings.Add(1)
+	if f.healthy.Load() {
+		return nil
+	}
+	return &pgconn.ConnectError{}
+}
+
+func TestMonitorLossAndRecovery(t *testing.T) {
+	m := NewMonitor(5*time.Millisecond, zerolog.Nop())
+	db := &flakyDB{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx, db)
+
+	assert.True(t, m.Ready())
+
+	// A query fails because the connection dropped
+	m.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: io.ErrUnexpectedEOF})
+	assert.False(t, m.Ready())
+
+	// Stays not-ready while pings keep failing
+	assert.Eventually(t, func() bool { return db.pings.Load() >= 2 }, time.Second, time.Millisecond)
+	assert.False(t, m.Ready())
+
+	db.healthy.Store(true)
+	assert.Eventually(t, m.Ready, time.Second, time.Millisecond)
+
+	// A second outage is detected again after recovery
+	m.Observe(&pgconn.ConnectError{})
+	assert.False(t, m.Ready())
+	assert.Eventually(t, m.Ready, time.Second, time.Millisecond)
+}
+
+func TestMonitorIgnoresQueryErrors(t *testing.T) {
+	m := NewMonitor(time.Hour, zerolog.Nop())
+
+	for _, err := range []error{
+		pgx.ErrNoRows,
+		&pgconn.PgError{Code: "23505"}, // unique_violation
+		context.Canceled,
+		errors.New("validation failed"),
+		nil,
+	} {
+		m.Observe(err)
+		assert.True(t, m.Ready(), "%v", err)
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	assert.True(t, IsConnectionError(&pgconn.PgError{Code: "08006"}))
+	assert.True(t, IsConnectionError(&pgconn.PgError{Code: "57P01"}))
+	assert.True(t, IsConnectionError(io.EOF))
+	assert.False(t, IsConnectionError(&pgconn.PgError{Code: "42P01"}))
+
+	// A slow query hitting the request timeout says nothing about the database
+	assert.False(t, IsConnectionError(context.DeadlineExceeded))
+	assert.False(t, IsConnectionError(fmt.Errorf("query users: %w", context.DeadlineExceeded)))
+	assert.False(t, IsConnectionError(context.Canceled))
+}
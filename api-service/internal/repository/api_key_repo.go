@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/dbschema"
+	"azlo-goboiler/internal/models"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PostgresAPIKeyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewAPIKeyRepository(db *pgxpool.Pool) core.APIKeyRepository {
+	return &PostgresAPIKeyRepository{db: db}
+}
+
+func (r *PostgresAPIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	query := dbschema.SQL(`
+		INSERT INTO {auth}.api_keys (id, user_id, name, prefix, key_hash, scopes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`)
+	_, err := r.db.Exec(ctx, query, key.ID, key.UserID, key.Name, key.Prefix, key.KeyHash, key.Scopes, key.CreatedAt)
+	return err
+}
+
+func (r *PostgresAPIKeyRepository) ListByUser(ctx context.Context, userID string) ([]models.APIKey, error) {
+	query := dbschema.SQL(`
+		SELECT id, user_id, name, prefix, scopes, created_at, last_used_at
+		FROM {auth}.api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC`)
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		var k models.APIKey
+		if err := rows.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.Scopes, &k.CreatedAt, &k.LastUsedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (r *PostgresAPIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	var k models.APIKey
+	query := dbschema.SQL(`
+		SELECT id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at
+		FROM {auth}.api_keys
+		WHERE prefix = $1 AND revoked_at IS NULL`)
+	err := r.db.QueryRow(ctx, query, prefix).Scan(
+		&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.KeyHash, &k.Scopes, &k.CreatedAt, &k.LastUsedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (r *PostgresAPIKeyRepository) Revoke(ctx context.Context, id, userID string) (bool, error) {
+	tag, err := r.db.Exec(ctx,
+		dbschema.SQL("UPDATE {auth}.api_keys SET revoked_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL"),
+		time.Now(), id, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *PostgresAPIKeyRepository) TouchLastUsed(ctx context.Context, id string) error {
+	_, err := r.db.Exec(ctx, dbschema.SQL("UPDATE {auth}.api_keys SET last_used_at = $1 WHERE id = $2"), time.Now(), id)
+	return err
+}
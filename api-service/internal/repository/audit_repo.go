@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/dbschema"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/pagination"
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PostgresAuditRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewAuditRepository(db *pgxpool.Pool) core.AuditRepository {
+	return &PostgresAuditRepository{db: db}
+}
+
+func (r *PostgresAuditRepository) RecordEvent(ctx context.Context, event *models.AuditEvent) error {
+	query := dbschema.SQL(`
+		INSERT INTO {auth}.audit_log (id, actor_id, action, target_id, ip_address, metadata, created_at)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7)`)
+	_, err := r.db.Exec(ctx, query,
+		event.ID, event.ActorID, event.Action, event.TargetID, event.IPAddress, event.Metadata, event.CreatedAt)
+	return err
+}
+
+func (r *PostgresAuditRepository) ListEvents(ctx context.Context, p pagination.Params) ([]models.AuditEvent, error) {
+	query := dbschema.SQL(`
+		SELECT id, COALESCE(actor_id::text, ''), action, COALESCE(target_id, ''), COALESCE(ip_address, ''), metadata, created_at
+		FROM {auth}.audit_log
+		WHERE ($1::timestamptz IS NULL OR (created_at, id) < ($1, $2::uuid))
+		ORDER BY created_at DESC, id DESC LIMIT $3`)
+
+	beforeAt, beforeID := keysetArgs(p)
+	rows, err := r.db.Query(ctx, query, beforeAt, beforeID, p.FetchLimit())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var e models.AuditEvent
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.Action, &e.TargetID, &e.IPAddress, &e.Metadata, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (r *PostgresAuditRepository) RecordLogin(ctx context.Context, entry *models.LoginEvent) error {
+	query := dbschema.SQL(`
+		INSERT INTO {auth}.login_history (id, user_id, ip_address, user_agent, created_at)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5)`)
+	_, err := r.db.Exec(ctx, query, entry.ID, entry.UserID, entry.IPAddress, entry.UserAgent, entry.CreatedAt)
+	return err
+}
+
+func (r *PostgresAuditRepository) ListLogins(ctx context.Context, userID string, p pagination.Params) ([]models.LoginEvent, error) {
+	query := dbschema.SQL(`
+		SELECT id, user_id, COALESCE(ip_address, ''), COALESCE(user_agent, ''), created_at
+		FROM {auth}.login_history
+		WHERE user_id = $1 AND ($2::timestamptz IS NULL OR (created_at, id) < ($2, $3::uuid))
+		ORDER BY created_at DESC, id DESC LIMIT $4`)
+
+	beforeAt, beforeID := keysetArgs(p)
+	rows, err := r.db.Query(ctx, query, userID, beforeAt, beforeID, p.FetchLimit())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logins := []models.LoginEvent{}
+	for rows.Next() {
+		var l models.LoginEvent
+		if err := rows.Scan(&l.ID, &l.UserID, &l.IPAddress, &l.UserAgent, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		logins = append(logins, l)
+	}
+	return logins, rows.Err()
+}
+
+// keysetArgs maps the optional cursor onto nullable query arguments
+func keysetArgs(p pagination.Params) (interface{}, interface{}) {
+	if p.Before == nil {
+		return nil, nil
+	}
+	return p.Before.CreatedAt, p.Before.ID
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"azlo-goboiler/internal/notification"
+	"azlo-goboiler/internal/notification/templates"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// digestBufferTTL outlasts the longest digest period. It is set when a
+// buffer is created and not refreshed, so every buffer is dropped in time
+// even while items keep arriving.
+const digestBufferTTL = 8 * 24 * time.Hour
+
+// maxDigestItems caps a buffer; older items are dropped past it
+const maxDigestItems = 100
+
+// digestAddScript appends ARGV[1], sets the expiry (ms, ARGV[2]) only when
+// the push created the list, and keeps the newest ARGV[3] items
+var digestAddScript = redis.NewScript(`
+local key = KEYS[1]
+if redis.call('RPUSH', key, ARGV[1]) == 1 then
+	redis.call('PEXPIRE', key, ARGV[2])
+end
+redis.call('LTRIM', key, -tonumber(ARGV[3]), -1)
+return 1
+`)
+
+// RedisDigestBuffer keeps each user's pending digest items in a Redis list
+type RedisDigestBuffer struct {
+	client *redis.Client
+}
+
+func NewDigestBuffer(client *redis.Client) notification.DigestBuffer {
+	return &RedisDigestBuffer{client: client}
+}
+
+func digestBufferKey(userID string) string {
+	return "notify:digest:" + userID
+}
+
+func (b *RedisDigestBuffer) Add(ctx context.Context, userID string, item templates.DigestItem) error {
+	value, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return digestAddScript.Run(ctx, b.client, []string{digestBufferKey(userID)},
+		value, digestBufferTTL.Milliseconds(), maxDigestItems).Err()
+}
+
+func (b *RedisDigestBuffer) Pending(ctx context.Context, userID string) ([]templates.DigestItem, error) {
+	values, err := b.client.LRange(ctx, digestBufferKey(userID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]templates.DigestItem, 0, len(values))
+	for _, value := range values {
+		var item templates.DigestItem
+		if err := json.Unmarshal([]byte(value), &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// MemoryDigestBuffer keeps pending digest items in process memory, for
+// running without Redis. Items are lost on restart.
+type MemoryDigestBuffer struct {
+	mu    sync.Mutex
+	items map[string][]templates.DigestItem
+}
+
+func NewMemoryDigestBuffer() notification.DigestBuffer {
+	return &MemoryDigestBuffer{items: make(map[string][]templates.DigestItem)}
+}
+
+func (b *MemoryDigestBuffer) Add(ctx context.Context, userID string, item templates.DigestItem) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	items := append(b.items[userID], item)
+	if len(items) > maxDigestItems {
+		items = items[len(items)-maxDigestItems:]
+	}
+	b.items[userID] = items
+	return nil
+}
+
+func (b *MemoryDigestBuffer) Pending(ctx context.Context, userID string) ([]templates.DigestItem, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]templates.DigestItem{}, b.items[userID]...), nil
+}
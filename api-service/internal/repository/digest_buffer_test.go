@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"azlo-goboiler/internal/notification"
+	"azlo-goboiler/internal/notification/templates"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestBufferBounded(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	ctx := context.Background()
+
+	for name, buffer := range map[string]notification.DigestBuffer{"Redis": NewDigestBuffer(client), "Memory": NewMemoryDigestBuffer()} {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < maxDigestItems+5; i++ {
+				require.NoError(t, buffer.Add(ctx, "user-1", templates.DigestItem{Title: strconv.Itoa(i)}))
+			}
+			items, err := buffer.Pending(ctx, "user-1")
+			require.NoError(t, err)
+			require.Len(t, items, maxDigestItems)
+			assert.Equal(t, "5", items[0].Title, "the oldest items are dropped")
+			assert.Equal(t, strconv.Itoa(maxDigestItems+4), items[len(items)-1].Title)
+		})
+	}
+
+	t.Run("ExpiryNotRefreshed", func(t *testing.T) {
+		buffer := NewDigestBuffer(client)
+		require.NoError(t, buffer.Add(ctx, "user-2", templates.DigestItem{Title: "first"}))
+		assert.Equal(t, digestBufferTTL, mr.TTL(digestBufferKey("user-2")))
+
+		mr.FastForward(time.Hour)
+		require.NoError(t, buffer.Add(ctx, "user-2", templates.DigestItem{Title: "second"}))
+		assert.Equal(t, digestBufferTTL-time.Hour, mr.TTL(digestBufferKey("user-2")))
+	})
+}
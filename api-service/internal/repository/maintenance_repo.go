@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/dbschema"
+	"azlo-goboiler/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// maintenanceLockID is the pg_advisory_lock key held while maintenance runs,
+// so only one instance vacuums at a time
+const maintenanceLockID int64 = 727274002
+
+// maintenanceTables are the only tables maintenance may touch, within the
+// configured auth schema. Names are never taken from the request; they are
+// looked up here and quoted.
+var maintenanceTables = []string{
+	"users",
+	"audit_log",
+	"login_history",
+	"user_preferences",
+	"api_keys",
+	"user_tokens",
+	"user_identities",
+	"password_history",
+	"recovery_contacts",
+	"schema_migrations",
+}
+
+// qualifiedMaintenanceTables returns maintenanceTables prefixed with the auth schema
+func qualifiedMaintenanceTables() []string {
+	schema := dbschema.Current().Auth
+	tables := make([]string, len(maintenanceTables))
+	for i, name := range maintenanceTables {
+		tables[i] = schema + "." + name
+	}
+	return tables
+}
+
+type PostgresMaintenanceRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewMaintenanceRepository(db *pgxpool.Pool) core.MaintenanceRepository {
+	return &PostgresMaintenanceRepository{db: db}
+}
+
+func (r *PostgresMaintenanceRepository) Tables() []string {
+	return qualifiedMaintenanceTables()
+}
+
+// Run executes ANALYZE, or VACUUM (ANALYZE), one table at a time on a single
+// pinned connection. VACUUM cannot run inside a transaction, so none is used.
+func (r *PostgresMaintenanceRepository) Run(ctx context.Context, tables []string, vacuum bool) error {
+	statements := make([]string, 0, len(tables))
+	for _, table := range tables {
+		ident, ok := maintenanceIdentifier(table)
+		if !ok {
+			return fmt.Errorf("%w: %s", core.ErrUnknownTable, table)
+		}
+		if vacuum {
+			statements = append(statements, "VACUUM (ANALYZE) "+ident.Sanitize())
+		} else {
+			statements = append(statements, "ANALYZE "+ident.Sanitize())
+		}
+	}
+
+	conn, err := r.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", maintenanceLockID).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return core.ErrMaintenanceRunning
+	}
+	defer func() {
+		// Use a fresh context so the lock is released even if ctx timed out
+		unlockCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, _ = conn.Exec(unlockCtx, "SELECT pg_advisory_unlock($1)", maintenanceLockID)
+	}()
+
+	for i, stmt := range statements {
+		if _, err := conn.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: %w", tables[i], err)
+		}
+	}
+	return nil
+}
+
+// maintenanceIdentifier returns the quoted identifier for an allowed table
+func maintenanceIdentifier(table string) (pgx.Identifier, bool) {
+	for _, allowed := range qualifiedMaintenanceTables() {
+		if table == allowed {
+			schema, name, _ := strings.Cut(allowed, ".")
+			return pgx.Identifier{schema, name}, true
+		}
+	}
+	return nil, false
+}
+
+// appSessionFilter limits pg_stat_activity to the application's own client
+// connections in this database, excluding the connection asking
+const appSessionFilter = `
+	application_name = $1 AND datname = current_database()
+	AND backend_type = 'client backend' AND pid <> pg_backend_pid()`
+
+func (r *PostgresMaintenanceRepository) Sessions(ctx context.Context) ([]models.DBSession, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT pid, COALESCE(state, ''), COALESCE(wait_event, ''), COALESCE(query, ''), query_start,
+			COALESCE((EXTRACT(EPOCH FROM clock_timestamp() - state_change) * 1000)::bigint, 0)
+		FROM pg_stat_activity
+		WHERE`+appSessionFilter+`
+		ORDER BY state_change NULLS LAST`, config.DBApplicationName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.DBSession
+	for rows.Next() {
+		var s models.DBSession
+		if err := rows.Scan(&s.PID, &s.State, &s.WaitEvent, &s.Query, &s.QueryStart, &s.DurationMs); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// CancelSession filters and signals in one statement, so the PID cannot be
+// reused by another process between the check and the cancel
+func (r *PostgresMaintenanceRepository) CancelSession(ctx context.Context, pid int32) (string, error) {
+	var signalled bool
+	var query string
+	err := r.db.QueryRow(ctx, `
+		SELECT pg_cancel_backend(pid), COALESCE(query, '')
+		FROM pg_stat_activity
+		WHERE pid = $2 AND`+appSessionFilter, config.DBApplicationName, pid).Scan(&signalled, &query)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", core.ErrDBSessionNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if !signalled {
+		return "", fmt.Errorf("pg_cancel_backend(%d) failed", pid)
+	}
+	return query, nil
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenanceIdentifier(t *testing.T) {
+	ident, ok := maintenanceIdentifier("auth.users")
+	assert.True(t, ok)
+	assert.Equal(t, `"auth"."users"`, ident.Sanitize())
+
+	for _, table := range []string{"users", "auth.users; DROP TABLE auth.users", `auth."users"`, "pg_catalog.pg_authid", ""} {
+		_, ok := maintenanceIdentifier(table)
+		assert.False(t, ok, table)
+	}
+}
+
+// TestMaintenanceTablesCoverSchema reads the schema and migration sources so a
+// new {auth} table cannot be added without being listed for maintenance
+func TestMaintenanceTablesCoverSchema(t *testing.T) {
+	files, err := filepath.Glob("../database/*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTable := regexp.MustCompile(`CREATE TABLE (?:IF NOT EXISTS )?\{auth\}\.(\w+)`)
+	found := 0
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range createTable.FindAllStringSubmatch(string(src), -1) {
+			found++
+			assert.Contains(t, maintenanceTables, match[1], "%s creates {auth}.%s", filepath.Base(file), match[1])
+		}
+	}
+	assert.NotZero(t, found, "no CREATE TABLE statements found")
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"azlo-goboiler/internal/core"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemorySessionStore is a core.SessionStore kept in process memory, for
+// running without Redis. Revocations, sessions and refresh tokens are lost
+// on restart and not shared between instances, so it suits tests and
+// single-instance deployments only.
+type MemorySessionStore struct {
+	mu          sync.Mutex
+	globalEpoch int64
+	userEpochs  map[string]int64
+	active      map[string]expiring[string]
+	failed      map[string]expiring[time.Time]
+	revoked     map[string]expiring[struct{}]
+	refresh     map[string]expiring[refreshEntry]
+	now         func() time.Time
+}
+
+// expiring is a value that reads as unset once expiresAt has passed
+type expiring[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+type refreshEntry struct {
+	sessionID string
+	issuedAt  int64
+}
+
+func NewMemorySessionStore() core.SessionStore {
+	return &MemorySessionStore{
+		userEpochs: make(map[string]int64),
+		active:     make(map[string]expiring[string]),
+		failed:     make(map[string]expiring[time.Time]),
+		revoked:    make(map[string]expiring[struct{}]),
+		refresh:    make(map[string]expiring[refreshEntry]),
+		now:        time.Now,
+	}
+}
+
+// lookup returns key's value from m unless it has expired, dropping it if so
+func lookup[T any](m map[string]expiring[T], key string, now time.Time) (T, bool) {
+	e, ok := m[key]
+	if ok && now.Before(e.expiresAt) {
+		return e.value, true
+	}
+	delete(m, key)
+	var zero T
+	return zero, false
+}
+
+func (s *MemorySessionStore) GlobalEpoch(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.globalEpoch, nil
+}
+
+func (s *MemorySessionStore) BumpGlobalEpoch(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.globalEpoch = s.now().Unix()
+	return s.globalEpoch, nil
+}
+
+func (s *MemorySessionStore) EffectiveEpoch(ctx context.Context, userID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return max(s.globalEpoch, s.userEpochs[userID]), nil
+}
+
+func (s *MemorySessionStore) BumpUserEpoch(ctx context.Context, userID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	epoch := s.now().Unix()
+	s.userEpochs[userID] = epoch
+	return epoch, nil
+}
+
+func (s *MemorySessionStore) SetActiveSession(ctx context.Context, userID, sessionID string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active[userID] = expiring[string]{value: sessionID, expiresAt: s.now().Add(ttl)}
+	return nil
+}
+
+func (s *MemorySessionStore) ActiveSession(ctx context.Context, userID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessionID, _ := lookup(s.active, userID, s.now())
+	return sessionID, nil
+}
+
+func (s *MemorySessionStore) RecordFailedLogin(ctx context.Context, userID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed[userID] = expiring[time.Time]{value: time.Unix(at.Unix(), 0).UTC(), expiresAt: s.now().Add(failedLoginTTL)}
+	return nil
+}
+
+func (s *MemorySessionStore) LastFailedLogin(ctx context.Context, userID string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	at, _ := lookup(s.failed, userID, s.now())
+	return at, nil
+}
+
+func (s *MemorySessionStore) RevokeToken(ctx context.Context, tokenID string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil // already expired
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revoked[tokenID] = expiring[struct{}]{expiresAt: s.now().Add(ttl)}
+	return nil
+}
+
+func (s *MemorySessionStore) TokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := lookup(s.revoked, tokenID, s.now())
+	return ok, nil
+}
+
+func (s *MemorySessionStore) SaveRefreshToken(ctx context.Context, userID, tokenID, sessionID string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.refresh[refreshTokenKey(userID, tokenID)] = expiring[refreshEntry]{
+		value:     refreshEntry{sessionID: sessionID, issuedAt: now.Unix()},
+		expiresAt: now.Add(ttl),
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) ConsumeRefreshToken(ctx context.Context, userID, tokenID string) (string, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := refreshTokenKey(userID, tokenID)
+	entry, ok := lookup(s.refresh, key, s.now())
+	if !ok {
+		return "", 0, core.ErrRefreshTokenInvalid
+	}
+	delete(s.refresh, key)
+	return entry.sessionID, entry.issuedAt, nil
+}
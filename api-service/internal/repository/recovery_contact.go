@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"azlo-goboiler/internal/dbschema"
+	"azlo-goboiler/internal/models"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// GetRecoveryContact returns the user's recovery contact of kind, verified or
+// not, or nil if there is none
+func (r *PostgresUserRepository) GetRecoveryContact(ctx context.Context, userID, kind string) (*models.RecoveryContact, error) {
+	var contact models.RecoveryContact
+	err := r.db.QueryRow(ctx, dbschema.SQL(`
+		SELECT user_id, kind, value, verified, updated_at
+		FROM {auth}.recovery_contacts WHERE user_id = $1 AND kind = $2`), userID, kind).Scan(
+		&contact.UserID, &contact.Kind, &contact.Value, &contact.Verified, &contact.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// SetPendingRecoveryContact replaces the user's recovery contact of kind with
+// a new, unverified one. The old contact stops being used at once, verified
+// or not, and links sent for it stop working.
+func (r *PostgresUserRepository) SetPendingRecoveryContact(ctx context.Context, userID, kind, value, tokenHash string, expiresAt time.Time) error {
+	_, err := r.db.Exec(ctx, dbschema.SQL(`
+		INSERT INTO {auth}.recovery_contacts (user_id, kind, value, verified, token_hash, token_expires_at, updated_at)
+		VALUES ($1, $2, $3, false, $4, $5, NOW())
+		ON CONFLICT (user_id, kind) DO UPDATE
+		SET value = EXCLUDED.value,
+			verified = false,
+			token_hash = EXCLUDED.token_hash,
+			token_expires_at = EXCLUDED.token_expires_at,
+			updated_at = NOW()`), userID, kind, value, tokenHash, expiresAt)
+	return err
+}
+
+// VerifyRecoveryContact marks the contact of kind matching tokenHash as
+// verified. It reports false when no unexpired token matches.
+func (r *PostgresUserRepository) VerifyRecoveryContact(ctx context.Context, kind, tokenHash string) (bool, error) {
+	tag, err := r.db.Exec(ctx, dbschema.SQL(`
+		UPDATE {auth}.recovery_contacts
+		SET verified = true,
+			token_hash = NULL,
+			token_expires_at = NULL,
+			updated_at = NOW()
+		WHERE kind = $1 AND token_hash = $2 AND token_expires_at > NOW()`), kind, tokenHash)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"azlo-goboiler/internal/core"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const globalEpochKey = "auth:token_epoch:global"
+
+type RedisSessionStore struct {
+	client *redis.Client
+}
+
+func NewSessionStore(client *redis.Client) core.SessionStore {
+	return &RedisSessionStore{client: client}
+}
+
+func (s *RedisSessionStore) GlobalEpoch(ctx context.Context) (int64, error) {
+	epoch, err := s.client.Get(ctx, globalEpochKey).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return epoch, err
+}
+
+func userEpochKey(userID string) string {
+	return "auth:token_epoch:user:" + userID
+}
+
+func (s *RedisSessionStore) EffectiveEpoch(ctx context.Context, userID string) (int64, error) {
+	vals, err := s.client.MGet(ctx, globalEpochKey, userEpochKey(userID)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	var epoch int64
+	for _, v := range vals {
+		str, ok := v.(string)
+		if !ok {
+			continue // key not set
+		}
+		n, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		if n > epoch {
+			epoch = n
+		}
+	}
+	return epoch, nil
+}
+
+func (s *RedisSessionStore) BumpUserEpoch(ctx context.Context, userID string) (int64, error) {
+	epoch := time.Now().Unix()
+	// No TTL, for the same reason as the global epoch
+	if err := s.client.Set(ctx, userEpochKey(userID), epoch, 0).Err(); err != nil {
+		return 0, err
+	}
+	return epoch, nil
+}
+
+func (s *RedisSessionStore) BumpGlobalEpoch(ctx context.Context) (int64, error) {
+	epoch := time.Now().Unix()
+	// No TTL: the epoch must outlive every token issued before it
+	if err := s.client.Set(ctx, globalEpochKey, epoch, 0).Err(); err != nil {
+		return 0, err
+	}
+	return epoch, nil
+}
+
+func activeSessionKey(userID string) string {
+	return "auth:session:active:" + userID
+}
+
+func (s *RedisSessionStore) SetActiveSession(ctx context.Context, userID, sessionID string, ttl time.Duration) error {
+	return s.client.Set(ctx, activeSessionKey(userID), sessionID, ttl).Err()
+}
+
+func (s *RedisSessionStore) ActiveSession(ctx context.Context, userID string) (string, error) {
+	sessionID, err := s.client.Get(ctx, activeSessionKey(userID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return sessionID, err
+}
+
+// failedLoginTTL is how long the last failed login is remembered
+const failedLoginTTL = 30 * 24 * time.Hour
+
+func failedLoginKey(userID string) string {
+	return "auth:failed_login:" + userID
+}
+
+func (s *RedisSessionStore) RecordFailedLogin(ctx context.Context, userID string, at time.Time) error {
+	return s.client.Set(ctx, failedLoginKey(userID), at.Unix(), failedLoginTTL).Err()
+}
+
+func (s *RedisSessionStore) LastFailedLogin(ctx context.Context, userID string) (time.Time, error) {
+	at, err := s.client.Get(ctx, failedLoginKey(userID)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(at, 0).UTC(), nil
+}
+
+func revokedTokenKey(tokenID string) string {
+	return "auth:revoked:" + tokenID
+}
+
+func (s *RedisSessionStore) RevokeToken(ctx context.Context, tokenID string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil // already expired
+	}
+	return s.client.Set(ctx, revokedTokenKey(tokenID), 1, ttl).Err()
+}
+
+func (s *RedisSessionStore) TokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	n, err := s.client.Exists(ctx, revokedTokenKey(tokenID)).Result()
+	return n > 0, err
+}
+
+func refreshTokenKey(userID, tokenID string) string {
+	return "auth:refresh:" + userID + ":" + tokenID
+}
+
+func (s *RedisSessionStore) SaveRefreshToken(ctx context.Context, userID, tokenID, sessionID string, ttl time.Duration) error {
+	value := fmt.Sprintf("%d:%s", time.Now().Unix(), sessionID)
+	return s.client.Set(ctx, refreshTokenKey(userID, tokenID), value, ttl).Err()
+}
+
+func (s *RedisSessionStore) ConsumeRefreshToken(ctx context.Context, userID, tokenID string) (string, int64, error) {
+	// GETDEL makes the token single-use even when two refreshes race
+	value, err := s.client.GetDel(ctx, refreshTokenKey(userID, tokenID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", 0, core.ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	issued, sessionID, ok := strings.Cut(value, ":")
+	issuedAt, convErr := strconv.ParseInt(issued, 10, 64)
+	if !ok || convErr != nil {
+		return "", 0, core.ErrRefreshTokenInvalid
+	}
+	return sessionID, issuedAt, nil
+}
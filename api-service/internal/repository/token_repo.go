@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/dbschema"
+	"azlo-goboiler/internal/models"
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PostgresTokenRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewTokenRepository(db *pgxpool.Pool) core.TokenRepository {
+	return &PostgresTokenRepository{db: db}
+}
+
+func (r *PostgresTokenRepository) Create(ctx context.Context, token *models.UserToken) error {
+	query := dbschema.SQL(`
+		INSERT INTO {auth}.user_tokens (token_hash, user_id, purpose, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)`)
+	_, err := r.db.Exec(ctx, query, token.TokenHash, token.UserID, token.Purpose, token.ExpiresAt, token.CreatedAt)
+	return err
+}
+
+func (r *PostgresTokenRepository) Get(ctx context.Context, tokenHash, purpose string) (*models.UserToken, error) {
+	var t models.UserToken
+	err := r.db.QueryRow(ctx, dbschema.SQL(`
+		SELECT token_hash, user_id, purpose, expires_at, used_at, created_at
+		FROM {auth}.user_tokens WHERE token_hash = $1 AND purpose = $2`), tokenHash, purpose).Scan(
+		&t.TokenHash, &t.UserID, &t.Purpose, &t.ExpiresAt, &t.UsedAt, &t.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Consume checks and marks the token in one statement, so two concurrent
+// uses of the same token can't both succeed
+func (r *PostgresTokenRepository) Consume(ctx context.Context, tokenHash, purpose string) (string, error) {
+	var userID string
+	err := r.db.QueryRow(ctx, dbschema.SQL(`
+		UPDATE {auth}.user_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id`), tokenHash, purpose).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", core.ErrVerificationTokenInvalid
+		}
+		return "", err
+	}
+	return userID, nil
+}
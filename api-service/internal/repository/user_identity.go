@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"azlo-goboiler/internal/dbschema"
+	"azlo-goboiler/internal/models"
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (r *PostgresUserRepository) GetUserIDByIdentity(ctx context.Context, provider, providerUserID string) (string, error) {
+	var userID string
+	err := r.db.QueryRow(ctx, dbschema.SQL(`
+		SELECT i.user_id FROM {auth}.user_identities i
+		JOIN {auth}.users u ON u.id = i.user_id AND u.is_active = true
+		WHERE i.provider = $1 AND i.provider_user_id = $2`), provider, providerUserID).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return userID, err
+}
+
+// LinkIdentity leaves an identity linked to an active user alone. One still
+// linked to a deactivated user, such as the source of a merge, moves over.
+func (r *PostgresUserRepository) LinkIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	_, err := r.db.Exec(ctx, dbschema.SQL(`
+		INSERT INTO {auth}.user_identities (provider, provider_user_id, user_id, email, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (provider, provider_user_id) DO UPDATE
+		SET user_id = EXCLUDED.user_id, email = EXCLUDED.email
+		WHERE NOT EXISTS (
+			SELECT 1 FROM {auth}.users u WHERE u.id = {auth}.user_identities.user_id AND u.is_active = true
+		)`), identity.Provider, identity.ProviderUserID, identity.UserID, identity.Email, identity.CreatedAt)
+	return err
+}
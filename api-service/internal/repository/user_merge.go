@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/dbschema"
+	"azlo-goboiler/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// txBeginner is the subset of *pgxpool.Pool mergeUsers needs
+type txBeginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+func (r *PostgresUserRepository) Merge(ctx context.Context, sourceID, targetID string) (*models.MergeResult, error) {
+	return mergeUsers(ctx, r.db, sourceID, targetID)
+}
+
+// mergeUsers moves everything the source user owns to the target, then
+// deactivates the source. Every step runs in one transaction; any failure
+// rolls the whole merge back.
+//
+// What happens to each kind of data:
+//   - preferences: the target's row wins; the source's row is only moved
+//     when the target has none, and is deleted either way
+//   - login history: reassigned to the target
+//   - API keys: revoked, not transferred, so a credential never silently
+//     gains access to a different account
+//   - audit log: rows the source acted in or was the target of are
+//     reassigned to the target, so its history reads as one account's;
+//     the merge event recorded afterwards links the two IDs
+func mergeUsers(ctx context.Context, db txBeginner, sourceID, targetID string) (*models.MergeResult, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) // no-op after Commit
+
+	// Lock both rows so concurrent merges or updates can't interleave
+	var locked int
+	err = tx.QueryRow(ctx, dbschema.SQL(`
+		SELECT COUNT(*) FROM (
+			SELECT id FROM {auth}.users
+			WHERE id IN ($1, $2) AND is_active = true
+			FOR UPDATE
+		) u`), sourceID, targetID).Scan(&locked)
+	if err != nil {
+		return nil, fmt.Errorf("lock users: %w", err)
+	}
+	if locked != 2 {
+		return nil, core.ErrUserNotFound
+	}
+
+	result := &models.MergeResult{SourceUserID: sourceID, TargetUserID: targetID}
+
+	tag, err := tx.Exec(ctx, dbschema.SQL(`
+		INSERT INTO {auth}.user_preferences (user_id, email_enabled, frequency,
+			notification_email, notification_email_verified, updated_at)
+		SELECT $2, email_enabled, frequency, notification_email, notification_email_verified, NOW()
+		FROM {auth}.user_preferences WHERE user_id = $1
+		ON CONFLICT (user_id) DO NOTHING`), sourceID, targetID)
+	if err != nil {
+		return nil, fmt.Errorf("move preferences: %w", err)
+	}
+	result.PreferencesMoved = tag.RowsAffected() > 0
+
+	if _, err := tx.Exec(ctx, dbschema.SQL(`DELETE FROM {auth}.user_preferences WHERE user_id = $1`), sourceID); err != nil {
+		return nil, fmt.Errorf("delete source preferences: %w", err)
+	}
+
+	tag, err = tx.Exec(ctx, dbschema.SQL(`UPDATE {auth}.login_history SET user_id = $2 WHERE user_id = $1`), sourceID, targetID)
+	if err != nil {
+		return nil, fmt.Errorf("move login history: %w", err)
+	}
+	result.LoginHistoryMoved = tag.RowsAffected()
+
+	// Parameters are cast from text, as target_id is text but actor_id a UUID
+	tag, err = tx.Exec(ctx, dbschema.SQL(`
+		UPDATE {auth}.audit_log SET
+			actor_id = CASE WHEN actor_id = $1::text::uuid THEN $2::text::uuid ELSE actor_id END,
+			target_id = CASE WHEN target_id = $1::text THEN $2::text ELSE target_id END
+		WHERE actor_id = $1::text::uuid OR target_id = $1::text`), sourceID, targetID)
+	if err != nil {
+		return nil, fmt.Errorf("move audit log: %w", err)
+	}
+	result.AuditRowsMoved = tag.RowsAffected()
+
+	tag, err = tx.Exec(ctx, dbschema.SQL(`
+		UPDATE {auth}.api_keys SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL`), sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("revoke api keys: %w", err)
+	}
+	result.APIKeysRevoked = tag.RowsAffected()
+
+	tag, err = tx.Exec(ctx, dbschema.SQL(`UPDATE {auth}.users SET is_active = false WHERE id = $1`), sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("deactivate source: %w", err)
+	}
+	if tag.RowsAffected() != 1 {
+		return nil, errors.New("deactivate source: user row changed during merge")
+	}
+	result.SourceDeactivated = true
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	result.MergedAt = time.Now().UTC()
+	return result, nil
+}
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"azlo-goboiler/internal/core"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTx records the statements run inside a merge. Exec fails on the first
+// statement containing failOn; everything else reports rowsAffected rows.
+type fakeTx struct {
+	pgx.Tx
+
+	lockedUsers  int
+	rowsAffected int64
+	failOn       string
+
+	execs      []string
+	committed  bool
+	rolledBack bool
+}
+
+type fakeRow struct{ n int }
+
+func (r fakeRow) Scan(dest ...any) error {
+	*dest[0].(*int) = r.n
+	return nil
+}
+
+func (tx *fakeTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return fakeRow{n: tx.lockedUsers}
+}
+
+func (tx *fakeTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if tx.failOn != "" && strings.Contains(sql, tx.failOn) {
+		return pgconn.CommandTag{}, errors.New("connection reset")
+	}
+	tx.execs = append(tx.execs, strings.Join(strings.Fields(sql), " "))
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func (tx *fakeTx) Commit(ctx context.Context) error {
+	tx.committed = true
+	return nil
+}
+
+func (tx *fakeTx) Rollback(ctx context.Context) error {
+	if !tx.committed {
+		tx.rolledBack = true
+	}
+	return nil
+}
+
+type fakeBeginner struct{ tx *fakeTx }
+
+func (b fakeBeginner) Begin(ctx context.Context) (pgx.Tx, error) { return b.tx, nil }
+
+func TestMergeUsers(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("MovesDataAndDeactivatesSource", func(t *testing.T) {
+		tx := &fakeTx{lockedUsers: 2}
+		result, err := mergeUsers(ctx, fakeBeginner{tx}, "source", "target")
+		require.NoError(t, err)
+
+		assert.True(t, tx.committed)
+		assert.False(t, tx.rolledBack)
+		assert.True(t, result.PreferencesMoved)
+		assert.Equal(t, int64(1), result.LoginHistoryMoved)
+		assert.Equal(t, int64(1), result.AuditRowsMoved)
+		assert.Equal(t, int64(1), result.APIKeysRevoked)
+		assert.True(t, result.SourceDeactivated)
+
+		// Deactivation is the last statement, after all data has moved
+		require.Len(t, tx.execs, 6)
+		assert.Contains(t, tx.execs[3], "UPDATE auth.audit_log")
+		assert.Contains(t, tx.execs[5], "SET is_active = false")
+	})
+
+	t.Run("MissingUser", func(t *testing.T) {
+		tx := &fakeTx{lockedUsers: 1}
+		_, err := mergeUsers(ctx, fakeBeginner{tx}, "source", "target")
+		assert.ErrorIs(t, err, core.ErrUserNotFound)
+		assert.Empty(t, tx.execs)
+		assert.True(t, tx.rolledBack)
+	})
+
+	// A failure at any step must roll back everything before it
+	for _, step := range []string{"INSERT INTO auth.user_preferences", "login_history", "audit_log", "api_keys", "is_active = false"} {
+		t.Run("RollbackOn "+step, func(t *testing.T) {
+			tx := &fakeTx{lockedUsers: 2, failOn: step}
+			result, err := mergeUsers(ctx, fakeBeginner{tx}, "source", "target")
+			assert.Error(t, err)
+			assert.Nil(t, result)
+			assert.False(t, tx.committed)
+			assert.True(t, tx.rolledBack)
+		})
+	}
+}
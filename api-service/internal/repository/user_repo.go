@@ -2,7 +2,9 @@ package repository
 
 import (
 	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/dbschema"
 	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/username"
 	"context"
 	"errors"
 	"time"
@@ -27,6 +29,9 @@ type dbUser struct {
 	Email        string     `db:"email"`
 	PasswordHash string     `db:"password_hash"`
 	IsActive     bool       `db:"is_active"`
+	Role         string     `db:"role"`
+	MustChange   bool       `db:"must_change_password"`
+	Verified     bool       `db:"email_verified"`
 	CreatedAt    time.Time  `db:"created_at"`
 	UpdatedAt    time.Time  `db:"updated_at"`
 	LastLogin    *time.Time `db:"last_login"`
@@ -35,37 +40,42 @@ type dbUser struct {
 // toDomain converts the database object back into a business entity.
 func (dbu *dbUser) toDomain() *models.User {
 	return &models.User{
-		ID:           dbu.ID,
-		Username:     dbu.Username,
-		Email:        dbu.Email,
-		PasswordHash: dbu.PasswordHash,
-		IsActive:     dbu.IsActive,
-		CreatedAt:    dbu.CreatedAt,
-		UpdatedAt:    dbu.UpdatedAt,
-		LastLogin:    dbu.LastLogin,
+		ID:                 dbu.ID,
+		Username:           dbu.Username,
+		Email:              dbu.Email,
+		PasswordHash:       dbu.PasswordHash,
+		IsActive:           dbu.IsActive,
+		Role:               dbu.Role,
+		MustChangePassword: dbu.MustChange,
+		EmailVerified:      dbu.Verified,
+		CreatedAt:          dbu.CreatedAt,
+		UpdatedAt:          dbu.UpdatedAt,
+		LastLogin:          dbu.LastLogin,
 	}
 }
 
 // --- Auth & Basic ---
 
 func (r *PostgresUserRepository) Create(ctx context.Context, user *models.User) error {
-	query := `
-		INSERT INTO auth.users (id, username, email, password_hash, created_at, updated_at, is_active) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	query := dbschema.SQL(`
+		INSERT INTO {auth}.users (id, username, email, password_hash, created_at, updated_at, is_active, role, must_change_password,
+			username_normalized, username_skeleton) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE(NULLIF($8, ''), 'user'), $9, $10, $11)`)
 	_, err := r.db.Exec(ctx, query,
-		user.ID, user.Username, user.Email, user.PasswordHash, user.CreatedAt, user.UpdatedAt, user.IsActive)
+		user.ID, user.Username, user.Email, user.PasswordHash, user.CreatedAt, user.UpdatedAt, user.IsActive,
+		user.Role, user.MustChangePassword, username.Normalize(user.Username), username.Skeleton(user.Username))
 	return err
 }
 
 func (r *PostgresUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
 	var dbu dbUser // Map into internal DB-tagged struct first
-	query := `
-		SELECT id, username, email, password_hash, is_active, created_at, updated_at, last_login 
-		FROM auth.users WHERE id = $1 AND is_active = true`
+	query := dbschema.SQL(`
+		SELECT id, username, email, password_hash, is_active, role, must_change_password, email_verified, created_at, updated_at, last_login 
+		FROM {auth}.users WHERE id = $1 AND is_active = true`)
 
 	err := r.db.QueryRow(ctx, query, id).Scan(
 		&dbu.ID, &dbu.Username, &dbu.Email, &dbu.PasswordHash,
-		&dbu.IsActive, &dbu.CreatedAt, &dbu.UpdatedAt, &dbu.LastLogin)
+		&dbu.IsActive, &dbu.Role, &dbu.MustChange, &dbu.Verified, &dbu.CreatedAt, &dbu.UpdatedAt, &dbu.LastLogin)
 
 	if err != nil {
 		return nil, err
@@ -74,14 +84,16 @@ func (r *PostgresUserRepository) GetByID(ctx context.Context, id string) (*model
 	return dbu.toDomain(), nil // Return the clean domain model
 }
 
-func (r *PostgresUserRepository) GetByEmailOrUsername(ctx context.Context, email, username string) (*models.User, error) {
+// GetByEmailOrUsername matches name by its normalized form, so lookups are
+// insensitive to case and Unicode composition
+func (r *PostgresUserRepository) GetByEmailOrUsername(ctx context.Context, email, name string) (*models.User, error) {
 	var user models.User
-	query := `
-		SELECT id, username, email, password_hash, is_active, created_at, updated_at 
-		FROM auth.users WHERE (username = $1 OR email = $2) AND is_active = true`
-	err := r.db.QueryRow(ctx, query, username, email).Scan(
+	query := dbschema.SQL(`
+		SELECT id, username, email, password_hash, is_active, role, must_change_password, email_verified, created_at, updated_at 
+		FROM {auth}.users WHERE (username_normalized = $1 OR email = $2) AND is_active = true`)
+	err := r.db.QueryRow(ctx, query, username.Normalize(name), email).Scan(
 		&user.ID, &user.Username, &user.Email, &user.PasswordHash,
-		&user.IsActive, &user.CreatedAt, &user.UpdatedAt)
+		&user.IsActive, &user.Role, &user.MustChangePassword, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
@@ -94,38 +106,91 @@ func (r *PostgresUserRepository) GetByEmailOrUsername(ctx context.Context, email
 // --- User Management ---
 
 func (r *PostgresUserRepository) Update(ctx context.Context, user *models.User) error {
-	query := `
-		UPDATE auth.users 
-		SET username = $1, email = $2, updated_at = $3
-		WHERE id = $4 AND is_active = true`
-	_, err := r.db.Exec(ctx, query, user.Username, user.Email, time.Now(), user.ID)
+	query := dbschema.SQL(`
+		UPDATE {auth}.users 
+		SET username = $1, email = $2, updated_at = $3, username_normalized = $5, username_skeleton = $6
+		WHERE id = $4 AND is_active = true`)
+	_, err := r.db.Exec(ctx, query, user.Username, user.Email, time.Now(), user.ID,
+		username.Normalize(user.Username), username.Skeleton(user.Username))
 	return err
 }
 
-func (r *PostgresUserRepository) UpdatePassword(ctx context.Context, userID, hash string) error {
-	_, err := r.db.Exec(ctx, "UPDATE auth.users SET password_hash = $1, updated_at = $2 WHERE id = $3", hash, time.Now(), userID)
-	return err
+// UsernameSkeletonTaken reports whether any account other than excludeID,
+// active or not, has a username with the given skeleton
+func (r *PostgresUserRepository) UsernameSkeletonTaken(ctx context.Context, skeleton, excludeID string) (bool, error) {
+	var taken bool
+	err := r.db.QueryRow(ctx,
+		dbschema.SQL("SELECT EXISTS(SELECT 1 FROM {auth}.users WHERE username_skeleton = $1 AND id::text <> $2)"),
+		skeleton, excludeID).Scan(&taken)
+	return taken, err
+}
+
+func (r *PostgresUserRepository) UpdatePassword(ctx context.Context, userID, hash string, historySize int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) // no-op after Commit
+
+	now := time.Now()
+	if _, err := tx.Exec(ctx, dbschema.SQL("UPDATE {auth}.users SET password_hash = $1, must_change_password = false, updated_at = $2 WHERE id = $3"), hash, now, userID); err != nil {
+		return err
+	}
+	if historySize > 0 {
+		if _, err := tx.Exec(ctx, dbschema.SQL("INSERT INTO {auth}.password_history (user_id, password_hash, created_at) VALUES ($1, $2, $3)"), userID, hash, now); err != nil {
+			return err
+		}
+	}
+	// Prune past the newest historySize; the id breaks ties between changes
+	// made in the same instant
+	if _, err := tx.Exec(ctx, dbschema.SQL(`
+		DELETE FROM {auth}.password_history WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM {auth}.password_history WHERE user_id = $1
+			ORDER BY created_at DESC, id DESC LIMIT $2)`), userID, historySize); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *PostgresUserRepository) RecentPasswordHashes(ctx context.Context, userID string, n int) ([]string, error) {
+	rows, err := r.db.Query(ctx, dbschema.SQL(`
+		SELECT password_hash FROM {auth}.password_history WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC LIMIT $2`), userID, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
 }
 
 func (r *PostgresUserRepository) UpdateLastLogin(ctx context.Context, userID string) error {
-	_, err := r.db.Exec(ctx, "UPDATE auth.users SET last_login = $1 WHERE id = $2", time.Now(), userID)
+	_, err := r.db.Exec(ctx, dbschema.SQL("UPDATE {auth}.users SET last_login = $1 WHERE id = $2"), time.Now(), userID)
 	return err
 }
 
-func (r *PostgresUserRepository) List(ctx context.Context, limit, offset int) ([]models.User, error) {
-	query := `
-		SELECT id, username, email, created_at, last_login 
-		FROM auth.users WHERE is_active = true 
-		ORDER BY created_at DESC LIMIT $1 OFFSET $2`
+func (r *PostgresUserRepository) List(ctx context.Context, limit, offset int) ([]models.UserListItem, error) {
+	query := dbschema.SQL(`
+		SELECT id, username, email, created_at, last_login
+		FROM {auth}.users WHERE is_active = true 
+		ORDER BY created_at DESC LIMIT $1 OFFSET $2`)
 	rows, err := r.db.Query(ctx, query, limit, offset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var users []models.User
+	users := []models.UserListItem{}
 	for rows.Next() {
-		var user models.User
+		var user models.UserListItem
 		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt, &user.LastLogin); err != nil {
 			return nil, err
 		}
@@ -134,8 +199,121 @@ func (r *PostgresUserRepository) List(ctx context.Context, limit, offset int) ([
 	return users, nil
 }
 
+func (r *PostgresUserRepository) StreamAll(ctx context.Context, fn func(models.UserExportRow) error) error {
+	query := dbschema.SQL(`
+		SELECT id, username, email, created_at, last_login, is_active
+		FROM {auth}.users
+		ORDER BY created_at, id`)
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user models.UserExportRow
+		var active bool
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt, &user.LastLogin, &active); err != nil {
+			return err
+		}
+		user.Status = models.UserStatusInactive
+		if active {
+			user.Status = models.UserStatusActive
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (r *PostgresUserRepository) CountByRole(ctx context.Context, role string) (int, error) {
+	var count int
+	err := r.db.QueryRow(ctx, dbschema.SQL("SELECT COUNT(*) FROM {auth}.users WHERE role = $1 AND is_active = true"), role).Scan(&count)
+	return count, err
+}
+
+func (r *PostgresUserRepository) CollectionVersion(ctx context.Context) (int, time.Time, error) {
+	var count int
+	var updatedAt time.Time
+	err := r.db.QueryRow(ctx, dbschema.SQL(`
+		SELECT COUNT(*), COALESCE(MAX(updated_at), 'epoch'::timestamptz)
+		FROM {auth}.users WHERE is_active = true`)).Scan(&count, &updatedAt)
+	return count, updatedAt, err
+}
+
+func (r *PostgresUserRepository) SetEmailVerified(ctx context.Context, userID string) error {
+	_, err := r.db.Exec(ctx, dbschema.SQL("UPDATE {auth}.users SET email_verified = true WHERE id = $1"), userID)
+	return err
+}
+
+// --- Preferences ---
+
+// GetPreferences returns nil when the user has never saved preferences
+func (r *PostgresUserRepository) GetPreferences(ctx context.Context, userID string) (*models.UserPreferences, error) {
+	var prefs models.UserPreferences
+	err := r.db.QueryRow(ctx, dbschema.SQL(`
+		SELECT user_id, email_enabled, frequency, updated_at,
+			COALESCE(notification_email, ''), notification_email_verified
+		FROM {auth}.user_preferences WHERE user_id = $1`), userID).Scan(
+		&prefs.UserID, &prefs.EmailEnabled, &prefs.Frequency, &prefs.UpdatedAt,
+		&prefs.NotificationEmail, &prefs.NotificationEmailVerified)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &prefs, nil
+}
+
+// UpsertPreferences saves prefs and sets prefs.UpdatedAt from the stored row
+func (r *PostgresUserRepository) UpsertPreferences(ctx context.Context, prefs *models.UserPreferences) error {
+	query := dbschema.SQL(`
+		INSERT INTO {auth}.user_preferences (user_id, email_enabled, frequency, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET email_enabled = EXCLUDED.email_enabled, frequency = EXCLUDED.frequency, updated_at = NOW()
+		RETURNING updated_at`)
+	return r.db.QueryRow(ctx, query, prefs.UserID, prefs.EmailEnabled, prefs.Frequency).Scan(&prefs.UpdatedAt)
+}
+
+// SetPendingNotificationEmail stores a new, unverified notification address.
+// Until it is verified, notifications keep going to the login email.
+func (r *PostgresUserRepository) SetPendingNotificationEmail(ctx context.Context, userID, email, tokenHash string, expiresAt time.Time) error {
+	query := dbschema.SQL(`
+		INSERT INTO {auth}.user_preferences (user_id, notification_email, notification_email_verified,
+			notification_email_token_hash, notification_email_token_expires_at, updated_at)
+		VALUES ($1, $2, false, $3, $4, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET notification_email = EXCLUDED.notification_email,
+			notification_email_verified = false,
+			notification_email_token_hash = EXCLUDED.notification_email_token_hash,
+			notification_email_token_expires_at = EXCLUDED.notification_email_token_expires_at,
+			updated_at = NOW()`)
+	_, err := r.db.Exec(ctx, query, userID, email, tokenHash, expiresAt)
+	return err
+}
+
+// VerifyNotificationEmail marks the address matching tokenHash as verified.
+// It reports false when no unexpired token matches.
+func (r *PostgresUserRepository) VerifyNotificationEmail(ctx context.Context, tokenHash string) (bool, error) {
+	query := dbschema.SQL(`
+		UPDATE {auth}.user_preferences
+		SET notification_email_verified = true,
+			notification_email_token_hash = NULL,
+			notification_email_token_expires_at = NULL,
+			updated_at = NOW()
+		WHERE notification_email_token_hash = $1 AND notification_email_token_expires_at > NOW()`)
+	tag, err := r.db.Exec(ctx, query, tokenHash)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
 func (r *PostgresUserRepository) Count(ctx context.Context) (int, error) {
 	var count int
-	err := r.db.QueryRow(ctx, "SELECT COUNT(*) FROM auth.users WHERE is_active = true").Scan(&count)
+	err := r.db.QueryRow(ctx, dbschema.SQL("SELECT COUNT(*) FROM {auth}.users WHERE is_active = true")).Scan(&count)
 	return count, err
 }
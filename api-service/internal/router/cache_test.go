@@ -0,0 +1,65 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"azlo-goboiler/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildCaching(t *testing.T) {
+	router := testRouter(t)
+
+	get := func(target, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("VersionHeaders", func(t *testing.T) {
+		rec := get("/version", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "public, max-age=300", rec.Header().Get("Cache-Control"))
+		assert.Contains(t, rec.Body.String(), `"commit":"abc1234"`)
+
+		etag := rec.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+		assert.NotEqual(t, "W/", etag[:2], "the ETag is strong")
+
+		other := config.BuildInfo{Version: "2.0.0", Commit: "def5678", BuildTime: "2025-06-01T12:00:00Z"}
+		assert.NotEqual(t, etag, other.ETag("version"), "a new commit changes the ETag")
+	})
+
+	t.Run("VersionNotModified", func(t *testing.T) {
+		etag := get("/version", "").Header().Get("ETag")
+
+		rec := get("/version", etag)
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Empty(t, rec.Body.String())
+		assert.Equal(t, etag, rec.Header().Get("ETag"))
+
+		assert.Equal(t, http.StatusOK, get("/version", `"stale"`).Code)
+	})
+
+	t.Run("OpenAPINotModified", func(t *testing.T) {
+		rec := get("/openapi.json", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "public, max-age=300", rec.Header().Get("Cache-Control"))
+		assert.Equal(t, http.StatusNotModified, get("/openapi.json", rec.Header().Get("ETag")).Code)
+	})
+
+	t.Run("AuthenticatedNoStore", func(t *testing.T) {
+		rec := get("/api/v1/profile", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+		assert.Empty(t, rec.Header().Get("ETag"))
+	})
+}
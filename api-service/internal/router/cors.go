@@ -0,0 +1,63 @@
+package router
+
+import (
+	"net/http"
+	"slices"
+
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/handlers"
+	"azlo-goboiler/internal/middleware"
+
+	"github.com/rs/cors"
+)
+
+// emittedHeaders are the response headers the API sets that browsers hide
+// from scripts on other origins unless CORS exposes them. Each package lists
+// its own next to the constants it sets them with.
+var emittedHeaders = func() []string {
+	all := slices.Concat(middleware.ResponseHeaders, handlers.ResponseHeaders)
+	slices.Sort(all)
+	return slices.Compact(all)
+}()
+
+// exposedHeaders is CORS_EXPOSED_HEADERS, or every emitted header when unset
+func exposedHeaders(cfg *config.Config) []string {
+	if len(cfg.CORS_Exposed_Headers) > 0 {
+		return cfg.CORS_Exposed_Headers
+	}
+	return emittedHeaders
+}
+
+// newCORS builds the CORS handler for the configured origins
+func newCORS(cfg *config.Config) *cors.Cors {
+	return cors.New(cors.Options{
+		AllowedOrigins:   cfg.CORS_Allowed_Origins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", middleware.RequestIDHeader, "X-Auth-Mode", middleware.IdempotencyKeyHeader},
+		ExposedHeaders:   exposedHeaders(cfg),
+		AllowCredentials: true,
+		MaxAge:           300, // 5 minutes
+	})
+}
+
+// unexposedHeaders returns the emitted headers CORS_EXPOSED_HEADERS leaves out
+func unexposedHeaders(cfg *config.Config) []string {
+	exposed := make(map[string]bool)
+	for _, name := range exposedHeaders(cfg) {
+		exposed[http.CanonicalHeaderKey(name)] = true
+	}
+	var missing []string
+	for _, name := range emittedHeaders {
+		if !exposed[http.CanonicalHeaderKey(name)] {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// warnUnexposedHeaders logs every emitted header browser clients can't read
+func warnUnexposedHeaders(app *config.Application) {
+	for _, name := range unexposedHeaders(&app.Config) {
+		app.Logger.Warn().Str("header", name).Msg("Response header is not in CORS_EXPOSED_HEADERS; browser clients can't read it")
+	}
+}
@@ -0,0 +1,142 @@
+package router
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORSExposesHeaders(t *testing.T) {
+	app := testApp(t)
+	app.Config.CORS_Allowed_Origins = []string{"https://app.example.com"}
+	router := newRouter(app, NewServices(app))
+
+	req := httptest.NewRequest(http.MethodGet, "/version", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+
+	exposed := map[string]bool{}
+	for _, name := range strings.Split(rec.Header().Get("Access-Control-Expose-Headers"), ",") {
+		exposed[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+	}
+	for _, name := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", "Content-Disposition", "X-Pagination-Limit-Capped", "ETag", "X-Request-ID"} {
+		assert.True(t, exposed[http.CanonicalHeaderKey(name)], name)
+	}
+	// The headers are there to be read, too
+	assert.NotEmpty(t, rec.Header().Get("X-RateLimit-Remaining"))
+	assert.NotEmpty(t, rec.Header().Get("ETag"))
+}
+
+func TestUnexposedHeaders(t *testing.T) {
+	app := testApp(t)
+	assert.Empty(t, unexposedHeaders(&app.Config), "the built-in list exposes everything")
+
+	app.Config.CORS_Exposed_Headers = []string{"x-request-id", "X-Custom-Header"}
+	missing := unexposedHeaders(&app.Config)
+	assert.Contains(t, missing, "X-RateLimit-Remaining")
+	assert.NotContains(t, missing, "X-Request-ID", "matched case-insensitively")
+	assert.Equal(t, []string{"x-request-id", "X-Custom-Header"}, exposedHeaders(&app.Config))
+}
+
+// unreadHeaders are response headers set in code that scripts have no use
+// for, or that browsers let them read anyway
+var unreadHeaders = []string{
+	"Cache-Control", "Content-Type", "Content-Encoding", "Vary", "Connection", "Server",
+	"Content-Security-Policy", "Permissions-Policy", "Referrer-Policy", "Reporting-Endpoints",
+	"Strict-Transport-Security", "X-Content-Type-Options", "X-Frame-Options", "X-XSS-Protection",
+}
+
+// TestEmittedHeadersCoverSources reads the middleware and handlers sources
+// for headers set on responses, so one added without listing it is caught
+func TestEmittedHeadersCoverSources(t *testing.T) {
+	fset := token.NewFileSet()
+	consts := map[string]string{} // "pkg.Name" for every string constant
+	var files []*ast.File
+	for _, pkg := range []string{"middleware", "handlers"} {
+		paths, err := filepath.Glob(filepath.Join("..", pkg, "*.go"))
+		require.NoError(t, err)
+		for _, path := range paths {
+			if strings.HasSuffix(path, "_test.go") {
+				continue
+			}
+			file, err := parser.ParseFile(fset, path, nil, 0)
+			require.NoError(t, err)
+			files = append(files, file)
+			ast.Inspect(file, func(n ast.Node) bool {
+				if spec, ok := n.(*ast.ValueSpec); ok {
+					for i, name := range spec.Names {
+						if i < len(spec.Values) {
+							if lit, ok := spec.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+								consts[pkg+"."+name.Name], _ = strconv.Unquote(lit.Value)
+							}
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+
+	found := 0
+	for _, file := range files {
+		pkg := file.Name.Name
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || (sel.Sel.Name != "Set" && sel.Sel.Name != "Add") || !isResponseHeader(sel.X) {
+				return true
+			}
+			var name string
+			switch arg := call.Args[0].(type) {
+			case *ast.BasicLit:
+				name, _ = strconv.Unquote(arg.Value)
+			case *ast.Ident:
+				name = consts[pkg+"."+arg.Name]
+			case *ast.SelectorExpr:
+				if x, ok := arg.X.(*ast.Ident); ok {
+					name = consts[x.Name+"."+arg.Sel.Name]
+				}
+			}
+			if name == "" {
+				return true // set from a variable, such as a copied header
+			}
+			found++
+			canonical := http.CanonicalHeaderKey(name)
+			known := slices.ContainsFunc(slices.Concat(emittedHeaders, unreadHeaders), func(h string) bool {
+				return http.CanonicalHeaderKey(h) == canonical
+			})
+			assert.True(t, known, "%s sets %s, which is in neither emittedHeaders nor unreadHeaders", fset.Position(call.Pos()), name)
+			return true
+		})
+	}
+	assert.Greater(t, found, 20, "the scan should find the headers set in code")
+}
+
+// isResponseHeader reports whether x looks like a response's header map:
+// w.Header(), or h as the code names it once fetched
+func isResponseHeader(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.CallExpr:
+		sel, ok := x.Fun.(*ast.SelectorExpr)
+		return ok && sel.Sel.Name == "Header" && len(x.Args) == 0
+	case *ast.Ident:
+		return x.Name == "h"
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"azlo-goboiler/internal/handlers"
+	"azlo-goboiler/internal/hasher"
+	"azlo-goboiler/internal/middleware"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// otherLabel stands in for any path or method outside the known, bounded set
+const otherLabel = "other"
+
+var requestDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "A histogram of request latencies.",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"method", "path", "code"},
+)
+
+// NewMetricsRegistry returns a registry holding every metric the API
+// records, along with the Go runtime and process metrics. /metrics serves
+// app.Metrics rather than the global default registry, so each registry is
+// independent and a process can build as many routers as it likes.
+func NewMetricsRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestDuration,
+	)
+	reg.MustRegister(handlers.Collectors()...)
+	reg.MustRegister(middleware.Collectors()...)
+	reg.MustRegister(hasher.Collectors()...)
+	return reg
+}
+
+// knownMethods bounds the method label; anything else is counted as other
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// instrumentDuration observes every request in hist. The path label is the
+// matched route template, so /api/v1/api-keys/{id} is one series however many
+// IDs are requested; unmatched requests share the other label. With
+// pathLabels off the path label is left empty.
+func instrumentDuration(router *mux.Router, hist *prometheus.HistogramVec, pathLabels bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Resolve the label first: handlers may rewrite the request
+		path := ""
+		if pathLabels {
+			path = routeLabel(router, r)
+		}
+		method := r.Method
+		if !knownMethods[method] {
+			method = otherLabel
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		router.ServeHTTP(rec, r)
+		hist.WithLabelValues(method, path, strconv.Itoa(rec.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// routeLabel returns the path template of the route r matches, or other
+func routeLabel(router *mux.Router, r *http.Request) string {
+	var match mux.RouteMatch
+	if !router.Match(r, &match) || match.MatchErr != nil || match.Route == nil {
+		return otherLabel
+	}
+	tmpl, err := match.Route.GetPathTemplate()
+	if err != nil {
+		return otherLabel
+	}
+	return tmpl
+}
+
+// statusRecorder captures the status code written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming responses working through the recorder
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
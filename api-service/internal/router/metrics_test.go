@@ -0,0 +1,110 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// observedSeries returns the sample count of each series in hist, keyed by
+// "method path code"
+func observedSeries(t *testing.T, hist *prometheus.HistogramVec) map[string]uint64 {
+	t.Helper()
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(hist))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	series := map[string]uint64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, pair := range m.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			series[labels["method"]+" "+labels["path"]+" "+labels["code"]] = m.GetHistogram().GetSampleCount()
+		}
+	}
+	return series
+}
+
+func TestInstrumentDurationPathLabels(t *testing.T) {
+	newHist := func() *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test_duration_seconds", Help: "test"},
+			[]string{"method", "path", "code"})
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v1/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}).Methods("GET")
+
+	serve := func(h http.Handler, method, target string) {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, target, nil))
+	}
+
+	t.Run("TemplateLabel", func(t *testing.T) {
+		hist := newHist()
+		h := instrumentDuration(router, hist, true)
+		for _, id := range []string{"1", "2", "3", "missing"} {
+			serve(h, http.MethodGet, "/api/v1/users/"+id)
+		}
+		serve(h, http.MethodGet, "/does/not/exist/42")
+		serve(h, http.MethodGet, "/does/not/exist/43")
+		serve(h, http.MethodPost, "/api/v1/users/1")
+		serve(h, "PURGE", "/api/v1/users/1")
+
+		assert.Equal(t, map[string]uint64{
+			"GET /api/v1/users/{id} 200": 3,
+			"GET /api/v1/users/{id} 404": 1,
+			"GET other 404":              2,
+			"POST other 405":             1,
+			"other other 405":            1,
+		}, observedSeries(t, hist))
+	})
+
+	t.Run("PathLabelsDisabled", func(t *testing.T) {
+		hist := newHist()
+		h := instrumentDuration(router, hist, false)
+		serve(h, http.MethodGet, "/api/v1/users/1")
+		serve(h, http.MethodGet, "/api/v1/users/2")
+		serve(h, http.MethodGet, "/elsewhere")
+
+		assert.Equal(t, map[string]uint64{
+			"GET  200": 2,
+			"GET  404": 1,
+		}, observedSeries(t, hist))
+	})
+}
+
+func TestSetupTwice(t *testing.T) {
+	app := testApp(t)
+	app.Metrics = NewMetricsRegistry()
+
+	// Metrics are registered on the app's registry once, not per router, so
+	// rebuilding the router in the same process must not panic
+	var first, second http.Handler
+	require.NotPanics(t, func() {
+		first, _ = Setup(app)
+		second, _ = Setup(app)
+	})
+	require.NotPanics(t, func() { Setup(testApp(t)) }, "an app without a registry gets its own")
+
+	first.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	rec := httptest.NewRecorder()
+	second.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	for _, name := range []string{
+		"http_request_duration_seconds", "rate_limit_decisions_total", "bcrypt_queue_depth", "go_goroutines",
+	} {
+		assert.Contains(t, body, name)
+	}
+}
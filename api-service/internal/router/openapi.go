@@ -0,0 +1,117 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"azlo-goboiler/docs"
+	"azlo-goboiler/internal/config"
+
+	"github.com/gorilla/mux"
+)
+
+// documentedPrefixes are the route trees every endpoint of which should carry
+// swag annotations. Health, metrics and the docs themselves are left out.
+var documentedPrefixes = []string{"/api/v1/", "/auth/"}
+
+// serveOpenAPI serves the generated spec, the same document Swagger UI loads
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(docs.SwaggerInfo.ReadDoc()))
+}
+
+// routeDrift compares the routes registered on router with the operations
+// in spec. undocumented lists registered routes under documentedPrefixes with
+// no operation; stale lists documented operations no route serves. Both are
+// sorted "METHOD /path" strings.
+func routeDrift(router *mux.Router, spec []byte) (undocumented, stale []string, err error) {
+	documented, err := specOperations(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	registered := map[string]bool{}
+	err = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil // prefix-less subrouter
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil // catch-all such as the Swagger UI prefix
+		}
+		for _, method := range methods {
+			registered[method+" "+tmpl] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for op := range registered {
+		if !documented[op] && underDocumentedPrefix(op) {
+			undocumented = append(undocumented, op)
+		}
+	}
+	for op := range documented {
+		if !registered[op] {
+			stale = append(stale, op)
+		}
+	}
+	sort.Strings(undocumented)
+	sort.Strings(stale)
+	return undocumented, stale, nil
+}
+
+// specOperations returns the "METHOD /path" operations a Swagger 2.0 spec documents
+func specOperations(spec []byte) (map[string]bool, error) {
+	var doc struct {
+		BasePath string                                `json:"basePath"`
+		Paths    map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, err
+	}
+
+	ops := map[string]bool{}
+	for p, item := range doc.Paths {
+		full := path.Join("/", doc.BasePath, p)
+		for method := range item {
+			if method == "parameters" {
+				continue // shared parameters, not an operation
+			}
+			ops[strings.ToUpper(method)+" "+full] = true
+		}
+	}
+	return ops, nil
+}
+
+func underDocumentedPrefix(op string) bool {
+	_, p, _ := strings.Cut(op, " ")
+	for _, prefix := range documentedPrefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// warnRouteDocDrift logs every route the generated spec is missing and every
+// documented operation the router does not serve
+func warnRouteDocDrift(app *config.Application, router *mux.Router) {
+	undocumented, stale, err := routeDrift(router, []byte(docs.SwaggerInfo.ReadDoc()))
+	if err != nil {
+		app.Logger.Warn().Err(err).Msg("Could not compare routes with the OpenAPI spec")
+		return
+	}
+	for _, op := range undocumented {
+		app.Logger.Warn().Str("route", op).Msg("Route has no OpenAPI documentation; add swag annotations and regenerate docs")
+	}
+	for _, op := range stale {
+		app.Logger.Warn().Str("route", op).Msg("OpenAPI spec documents a route that is not registered")
+	}
+}
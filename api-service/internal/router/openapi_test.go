@@ -0,0 +1,81 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"azlo-goboiler/docs"
+	"azlo-goboiler/internal/config"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testApp returns an Application backed by an in-process Redis and no database
+func testApp(t *testing.T) *config.Application {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	return &config.Application{
+		Logger: zerolog.Nop(),
+		Config: config.Config{OpenAPIEnabled: true, RateLimit: 100},
+		Redis:  redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		Build:  config.BuildInfo{Version: "2.0.0", Commit: "abc1234", BuildTime: "2025-06-01T12:00:00Z"},
+	}
+}
+
+func testRouter(t *testing.T) *mux.Router {
+	t.Helper()
+	app := testApp(t)
+	return newRouter(app, NewServices(app))
+}
+
+func TestRoutesDocumented(t *testing.T) {
+	undocumented, stale, err := routeDrift(testRouter(t), []byte(docs.SwaggerInfo.ReadDoc()))
+	require.NoError(t, err)
+
+	for _, op := range undocumented {
+		if strings.Contains(op, " /api/v1/") {
+			t.Errorf("%s is registered in Setup but has no documented operation", op)
+		}
+	}
+	assert.Empty(t, stale, "documented operations with no registered route")
+}
+
+func TestRouteDrift(t *testing.T) {
+	router := mux.NewRouter()
+	noop := func(http.ResponseWriter, *http.Request) {}
+	router.HandleFunc("/api/v1/widgets", noop).Methods("GET")
+	router.HandleFunc("/api/v1/widgets/{id}", noop).Methods("DELETE")
+	router.HandleFunc("/health", noop).Methods("GET")
+
+	spec := `{"basePath": "/", "paths": {
+		"/api/v1/widgets": {"get": {}, "post": {}},
+		"/api/v1/widgets/{id}": {"parameters": []}
+	}}`
+
+	undocumented, stale, err := routeDrift(router, []byte(spec))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"DELETE /api/v1/widgets/{id}"}, undocumented)
+	assert.Equal(t, []string{"POST /api/v1/widgets"}, stale)
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	rec := httptest.NewRecorder()
+	testRouter(t).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var spec struct {
+		Paths map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &spec))
+	assert.Contains(t, spec.Paths, "/api/v1/users")
+}
@@ -5,94 +5,282 @@ import (
 	"time"
 
 	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
 	"azlo-goboiler/internal/handlers"
+	"azlo-goboiler/internal/hasher"
+	"azlo-goboiler/internal/kvstore"
 	"azlo-goboiler/internal/middleware"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/notification"
+	"azlo-goboiler/internal/oauth"
+	"azlo-goboiler/internal/randtoken"
 	"azlo-goboiler/internal/repository"
 	"azlo-goboiler/internal/service"
 
-	_ "azlo-goboiler/docs"
-
 	"github.com/gorilla/mux"
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/rs/cors"
 	httpSwagger "github.com/swaggo/http-swagger" // Add this import
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 )
 
-func Setup(app *config.Application) http.Handler {
-	router := mux.NewRouter()
+// buildCacheControl lets clients and shared caches keep responses that only
+// change between builds; the build-derived ETag makes revalidation cheap
+const buildCacheControl = "public, max-age=300"
+
+// Setup builds the API's handler. The returned Handlers is for shutdown to
+// wait on the work they leave running; see Handlers.Wait.
+func Setup(app *config.Application) (http.Handler, *handlers.Handlers) {
+	router, h := buildRouter(app, NewServices(app))
+
+	// Catch annotations that have drifted from the routes while developing
+	if app.Config.IsDevelopment() {
+		warnRouteDocDrift(app, router)
+		warnUnexposedHeaders(app)
+	}
+
+	return instrumentDuration(router, requestDuration, app.Config.MetricsPathLabels), h
+}
 
-	// --- Dependency Injection Wiring ---
-	// 1. Create Repository
+// Services holds everything the handlers and middleware depend on. Setup
+// builds them from the app's database and Redis with NewServices; tests can
+// swap any of them before building the router.
+type Services struct {
+	Users       core.UserService
+	Audit       core.AuditService
+	APIKeys     core.APIKeyService
+	Accounts    core.AccountService
+	Maintenance core.MaintenanceService
+	Sessions    core.SessionStore
+	KV          core.KVStore
+	Mailer      notification.Sender
+	Notifier    notification.Notifier
+	Limits      core.LimitsService
+	Digests     notification.DigestBuffer
+	Google      core.OAuthProvider // nil when GOOGLE_CLIENT_ID/SECRET are unset
+}
+
+// NewServices wires the repositories and services for app
+func NewServices(app *config.Application) *Services {
+	// 1. Create Repositories
 	userRepo := repository.NewUserRepository(app.DB)
+	auditRepo := repository.NewAuditRepository(app.DB)
+	apiKeyRepo := repository.NewAPIKeyRepository(app.DB)
+	tokenRepo := repository.NewTokenRepository(app.DB)
+	maintenanceRepo := repository.NewMaintenanceRepository(app.DB)
+	// Without Redis, state lives in this process only: fine for tests and a
+	// single instance, but limits and revocations aren't shared
+	var (
+		sessionStore core.SessionStore
+		kv           core.KVStore
+		digests      notification.DigestBuffer
+	)
+	if app.Redis != nil {
+		sessionStore = repository.NewSessionStore(app.Redis)
+		kv = kvstore.NewRedis(app.Redis)
+		digests = repository.NewDigestBuffer(app.Redis)
+	} else {
+		sessionStore = repository.NewMemorySessionStore()
+		kv = kvstore.NewMemory()
+		digests = repository.NewMemoryDigestBuffer()
+	}
+
+	// 2. Create Services
+	// One bcrypt pool for every password operation caps login CPU process-wide
+	passwords := hasher.NewPool(app.Config.BcryptWorkers, app.Config.BcryptQueueSize, app.Config.GetBcryptMaxWait())
+	mailer := notification.NewSMTPSender(&app.Config)
+	// Every reset, verification, refresh and API-key token comes from one generator
+	random := randtoken.New(app.Config.TokenBytes)
+
+	svc := &Services{
+		Users:       service.NewUserService(userRepo, sessionStore, &app.Config, passwords, random),
+		Audit:       service.NewAuditService(auditRepo, &app.Config),
+		APIKeys:     service.NewAPIKeyService(apiKeyRepo, random),
+		Accounts:    service.NewAccountService(userRepo, tokenRepo, sessionStore, &app.Config, passwords, random),
+		Maintenance: service.NewMaintenanceService(maintenanceRepo, kv, &app.Config),
+		Sessions:    sessionStore,
+		KV:          kv,
+		Mailer:      mailer,
+		Notifier:    notification.NewDispatcher(userRepo, notification.NewEmailChannel(mailer), notification.NewDigestChannel(digests)),
+		Limits:      service.NewLimitsService(kv, sessionStore, &app.Config),
+		Digests:     digests,
+	}
+	if app.Config.GoogleOAuthEnabled() {
+		svc.Google = oauth.NewGoogle(&app.Config, app.HTTPClient)
+	}
+	return svc
+}
+
+// newRouter injects svc into the handlers and middleware and registers every route
+func newRouter(app *config.Application, svc *Services) *mux.Router {
+	router, _ := buildRouter(app, svc)
+	return router
+}
 
-	// 2. Create Service
-	userService := service.NewUserService(userRepo, &app.Config)
+// buildRouter is newRouter, also returning the handlers it routes to
+func buildRouter(app *config.Application, svc *Services) (*mux.Router, *handlers.Handlers) {
+	router := mux.NewRouter()
 
-	// 3. Inject into Handlers
-	h := handlers.New(app, userService)
+	h := handlers.New(app, svc.Users, svc.Audit, svc.APIKeys, svc.Accounts, svc.Mailer, svc.Maintenance, svc.Notifier, svc.Limits, svc.Digests, svc.Google)
+	mw := middleware.New(app, svc.Sessions, svc.APIKeys, svc.KV, svc.Users)
 
-	mw := middleware.New(app)
+	// Unmatched requests skip router.Use middleware, so they need their own
+	// request ID and client IP. They are mostly scanners, so they count towards ABUSE_LIMIT too.
+	router.NotFoundHandler = mw.RequestID(mw.RealIP(mw.AbuseLimit(http.HandlerFunc(h.NotFound))))
+	router.MethodNotAllowedHandler = mw.RequestID(mw.RealIP(mw.AbuseLimit(http.HandlerFunc(h.MethodNotAllowed))))
 
 	// Apply global middleware in order of execution
 	router.Use(mw.RequestID) // First: Add request ID
+	router.Use(mw.RealIP)    // Resolve the client IP behind TRUSTED_PROXY_CIDRS
 	router.Use(otelmux.Middleware("go-api-service"))
-	router.Use(mw.Recovery)                  // Second: Catch panics
-	router.Use(mw.Logging)                   // Third: Log requests
-	router.Use(middleware.Security)          // Fourth: Security headers
-	router.Use(mw.Timeout(30 * time.Second)) // Fifth: Request timeout
-	router.Use(mw.RateLimit)                 // Sixth: Rate limiting
+	router.Use(mw.Recovery)                                // Second: Catch panics
+	router.Use(mw.Logging)                                 // Third: Log requests
+	router.Use(mw.ShutdownGate)                            // Refuse new requests once draining
+	router.Use(middleware.Security(app.Config.Security))   // Fourth: Security headers
+	router.Use(mw.Timeout(app.Config.GetRequestTimeout())) // Fifth: Request timeout
+	if app.Config.CompressionEnabled {
+		// Inside Timeout, so a handler still writing after a timeout writes
+		// to its own compressor
+		router.Use(middleware.Compression(app.Config.CompressMinBytes))
+	}
+	router.Use(mw.IPFilter(nil, app.Config.GetIPDenylist())) // Refuse IP_DENYLIST addresses
+	// 503 while maintenance mode is on, except for MAINTENANCE_IP_ALLOWLIST
+	router.Use(mw.Maintenance(app.Config.GetMaintenanceAllowlist()))
+	// Sixth: Rate limiting. Unless it is counted by IP, /api/v1 is limited
+	// after authentication instead, by UserRateLimit.
+	if app.Config.GetRateLimitKey() == config.RateLimitKeyIP {
+		router.Use(mw.RateLimit)
+	} else {
+		router.Use(mw.RateLimitExcept("/api/v1/"))
+	}
+	router.Use(mw.AbuseLimit) // Refuse clients with too many failed responses
+
+	// Drain (or reject) bodies sent on GET/HEAD/DELETE/OPTIONS
+	router.Use(mw.UnexpectedBody(app.Config.RejectGETBody))
+	// 413 for bodies over MAX_BODY_BYTES; wrap a route in mw.MaxBodyBytes
+	// to give it another limit
+	router.Use(mw.MaxBodyBytes(app.Config.GetMaxBodyBytes()))
+
+	// Resolve the response version pinned with Accept: application/vnd.azlo.vN+json
+	router.Use(mw.AcceptVersion)
 
 	// CORS configuration
-	c := cors.New(cors.Options{
-		AllowedOrigins:   app.Config.CORS_Allowed_Origins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Request-ID"},
-		ExposedHeaders:   []string{"X-Request-ID"},
-		AllowCredentials: true,
-		MaxAge:           300, // 5 minutes
-	})
-	router.Use(c.Handler)
+	router.Use(newCORS(&app.Config).Handler)
 
 	router.PathPrefix("/swagger/").Handler(httpSwagger.Handler(
 		httpSwagger.URL("/swagger/doc.json"),
 	))
+	if app.Config.OpenAPIEnabled {
+		router.Handle("/openapi.json",
+			middleware.Cache(buildCacheControl, app.Build.ETag("openapi"))(http.HandlerFunc(serveOpenAPI))).Methods("GET")
+	}
+	router.Handle("/version",
+		middleware.Cache(buildCacheControl, app.Build.ETag("version"))(http.HandlerFunc(h.Version))).Methods("GET")
 	// Health and monitoring routes (no authentication required)
 	router.HandleFunc("/health", h.Health).Methods("GET")
 	router.HandleFunc("/health/detailed", h.HealthDetailed).Methods("GET")
-	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	router.HandleFunc("/ready", h.Ready).Methods("GET")
+	metrics := app.Metrics
+	if metrics == nil {
+		metrics = NewMetricsRegistry()
+	}
+	router.Handle("/metrics", promhttp.HandlerFor(metrics, promhttp.HandlerOpts{})).Methods("GET")
+	router.HandleFunc("/.well-known/jwks.json", h.JWKS).Methods("GET")
+	// Browsers report CSP violations here unauthenticated; the limit keeps
+	// one client from flooding the logs
+	router.Handle("/csp-report",
+		mw.RouteRateLimit("csp_report", 60, time.Minute)(http.HandlerFunc(h.CSPReport))).Methods("POST")
 
 	// Public authentication routes
 	auth := router.PathPrefix("/auth").Subrouter()
-	auth.HandleFunc("/register", h.Register).Methods("POST")
+	auth.Use(middleware.NoStore) // responses carry tokens
+	// Only registration is idempotent: login, refresh and reset responses
+	// carry tokens that must not be stored for replay
+	auth.Handle("/register", mw.Idempotency(http.HandlerFunc(h.Register))).Methods("POST")
 	auth.HandleFunc("/login", h.Auth).Methods("POST")
+	auth.HandleFunc("/refresh", h.Refresh).Methods("POST")
 	auth.HandleFunc("/logout", h.Logout).Methods("POST")
+	auth.HandleFunc("/verify-notification-email", h.VerifyNotificationEmail).Methods("GET")
+	auth.HandleFunc("/verify-recovery-email", h.VerifyRecoveryEmail).Methods("GET")
+	auth.Handle("/forgot-password",
+		mw.RouteRateLimit("forgot_password", 5, time.Hour)(http.HandlerFunc(h.ForgotPassword))).Methods("POST")
+	auth.HandleFunc("/reset-password", h.ResetPassword).Methods("POST")
+	auth.HandleFunc("/verify-email", h.VerifyEmail).Methods("GET")
+	auth.HandleFunc("/oauth/google/login", h.GoogleLogin).Methods("GET")
+	auth.HandleFunc("/oauth/google/callback", h.GoogleCallback).Methods("GET")
+
+	// Token checks don't consume the token, so they are limited per IP to
+	// stop them being used to guess tokens
+	validateLimit := mw.RouteRateLimit("token_validate", 20, time.Hour)
+	auth.Handle("/reset-password/validate", validateLimit(http.HandlerFunc(h.ValidateResetToken))).Methods("GET")
+	auth.Handle("/verify-email/validate", validateLimit(http.HandlerFunc(h.ValidateVerifyEmailToken))).Methods("GET")
+
+	// Resources reachable through signed links instead of credentials.
+	// Download handlers register here; see internal/signedurl.
+	files := router.PathPrefix("/files").Subrouter()
+	files.Use(mw.VerifySignedURL)
 
 	// Protected API routes
 	api := router.PathPrefix("/api/v1").Subrouter()
-	api.Use(mw.JWT) // JWT authentication required for all /api/v1 routes
+	api.Use(middleware.NoStore)
+	// A session or an API key is required for all /api/v1 routes, or a user
+	// named by the trusted auth proxy when TRUSTED_AUTH_HEADER is set
+	api.Use(mw.TrustedHeader(svc.Users))
+	api.Use(mw.PasswordChangeGate)
+	api.Use(mw.UserRateLimit)
+	api.Use(mw.UserConcurrencyLimit)
+	api.Use(mw.Idempotency)
+	api.Use(middleware.UserCache)
 
 	// User management routes
-	api.HandleFunc("/profile", h.GetProfile).Methods("GET")
+	api.Handle("/profile", mw.StaleIfError(http.HandlerFunc(h.GetProfile))).Methods("GET")
 	api.HandleFunc("/profile", h.UpdateProfile).Methods("PUT")
 	api.HandleFunc("/password", h.ChangePassword).Methods("PUT")
+	api.HandleFunc("/profile/login-history", h.GetLoginHistory).Methods("GET")
+	api.HandleFunc("/profile/limits", h.GetProfileLimits).Methods("GET")
+	api.HandleFunc("/profile/logout-all", h.LogoutAllDevices).Methods("POST")
+	api.Handle("/profile/preferences", mw.StaleIfError(http.HandlerFunc(h.GetPreferences))).Methods("GET")
+	api.HandleFunc("/profile/preferences", h.UpdatePreferences).Methods("PUT")
+	api.HandleFunc("/profile/recovery-email", h.GetRecoveryEmail).Methods("GET")
+	api.HandleFunc("/profile/recovery-email", h.UpdateRecoveryEmail).Methods("PUT")
+	api.HandleFunc("/preferences/notification-email", h.UpdateNotificationEmail).Methods("PUT")
+	api.HandleFunc("/preferences/channels", h.GetNotificationChannels).Methods("GET")
+	api.HandleFunc("/preferences/digest/preview", h.GetDigestPreview).Methods("GET")
+	// Admin-only, so it gets the same gates as the admin routes below
+	adminIPs := mw.IPFilter(app.Config.GetAdminIPAllowlist(), nil)
+	api.Handle("/users", adminIPs(mw.RequireRole(models.RoleAdmin)(http.HandlerFunc(h.GetUsers)))).Methods("GET")
+
+	// API key management (the caller's own keys only)
+	api.HandleFunc("/api-keys", h.CreateAPIKey).Methods("POST")
+	api.HandleFunc("/api-keys", h.ListAPIKeys).Methods("GET")
+	api.HandleFunc("/api-keys/{id}", h.RevokeAPIKey).Methods("DELETE")
 
 	// Example protected route
 	api.HandleFunc("/protected", h.Protected).Methods("GET")
 
-	// Database statistics route (admin only in production)
-	api.HandleFunc("/admin/db-stats", h.GetDatabaseStats).Methods("GET")
-
-	return promhttp.InstrumentHandlerDuration(
-		prometheus.NewHistogramVec(
-			prometheus.HistogramOpts{
-				Name:    "http_request_duration_seconds",
-				Help:    "A histogram of request latencies.",
-				Buckets: prometheus.DefBuckets,
-			},
-			[]string{"method"},
-		),
-		router,
-	)
+	// Admin routes. The role comes from the session token; handlers that
+	// act on other accounts or the database re-check it against the user.
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(adminIPs)
+	admin.Use(mw.RequireRole(models.RoleAdmin))
+	admin.HandleFunc("/db-stats", h.GetDatabaseStats).Methods("GET")
+	admin.HandleFunc("/audit-log", h.GetAuditLog).Methods("GET")
+	admin.HandleFunc("/security/revoke-all-sessions", h.RevokeAllSessions).Methods("POST")
+	admin.HandleFunc("/users/merge", h.MergeUsers).Methods("POST")
+	admin.HandleFunc("/users/import", h.ImportUsers).Methods("POST")
+	admin.HandleFunc("/users/export", h.ExportUsers).Methods("GET")
+	admin.HandleFunc("/config/schema", h.GetConfigSchema).Methods("GET")
+	admin.HandleFunc("/signed-urls", h.CreateSignedURL).Methods("POST")
+	admin.Handle("/db/maintenance",
+		mw.RouteRateLimit("db_maintenance", 10, time.Hour)(http.HandlerFunc(h.DBMaintenance))).Methods("POST")
+	admin.HandleFunc("/db/maintenance/{id}", h.GetMaintenanceJob).Methods("GET")
+	admin.HandleFunc("/maintenance", h.GetMaintenanceMode).Methods("GET")
+	admin.HandleFunc("/maintenance", h.SetMaintenanceMode).Methods("POST")
+	admin.HandleFunc("/diagnostics", h.GetDiagnostics).Methods("GET")
+	admin.HandleFunc("/db/sessions", h.ListDBSessions).Methods("GET")
+	admin.Handle("/db/sessions/{pid}/cancel",
+		mw.RouteRateLimit("db_session_cancel", 30, time.Hour)(http.HandlerFunc(h.CancelDBSession))).Methods("POST")
+	admin.Handle("/notifications/test",
+		mw.RouteRateLimit("notification_test", 5, time.Hour)(http.HandlerFunc(h.SendTestNotification))).Methods("POST")
+
+	return router, h
 }
@@ -0,0 +1,486 @@
+package router
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/jwtkeys"
+	"azlo-goboiler/internal/mocks"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/service"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// authCookie signs a session cookie for userID with app's secret
+func authCookie(t *testing.T, app *config.Application, userID string) *http.Cookie {
+	return roleCookie(t, app, userID, models.RoleUser)
+}
+
+// roleCookie is authCookie for a user holding role
+func roleCookie(t *testing.T, app *config.Application, userID, role string) *http.Cookie {
+	t.Helper()
+	now := time.Now()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, config.AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		Role: role,
+	}).SignedString([]byte(app.Config.App_Secret))
+	require.NoError(t, err)
+	return &http.Cookie{Name: config.AuthCookieName, Value: token}
+}
+
+func TestRouterInjectsServices(t *testing.T) {
+	app := testApp(t)
+	app.Config.App_Secret = "test-secret-that-is-at-least-32-chars"
+
+	repo := new(mocks.MockUserRepository)
+	repo.On("GetByID", mock.Anything, "user-1").
+		Return(&models.User{ID: "user-1", Username: "alice", Email: "alice@example.com"}, nil)
+
+	svc := NewServices(app)
+	svc.Users = service.NewUserService(repo, svc.Sessions, &app.Config, nil, nil)
+	router := newRouter(app, svc)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil)
+	req.AddCookie(authCookie(t, app, "user-1"))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var body struct {
+		Data models.User `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "alice", body.Data.Username)
+	repo.AssertExpectations(t)
+}
+
+func TestNewServicesWiresEverything(t *testing.T) {
+	app := testApp(t)
+	svc := reflect.ValueOf(NewServices(app)).Elem()
+	for i := 0; i < svc.NumField(); i++ {
+		name := svc.Type().Field(i).Name
+		if name == "Google" {
+			continue // nil unless GOOGLE_CLIENT_ID/SECRET are set
+		}
+		assert.False(t, svc.Field(i).IsNil(), "NewServices leaves %s nil", name)
+	}
+
+	app.Config.GoogleClientID, app.Config.GoogleClientSecret = "client-id", "client-secret"
+	assert.NotNil(t, NewServices(app).Google)
+}
+
+func TestRouterWithoutRedis(t *testing.T) {
+	app := testApp(t)
+	app.Redis = nil
+	app.Config.App_Secret = "test-secret-that-is-at-least-32-chars"
+	app.Config.RateLimit = 2
+
+	repo := new(mocks.MockUserRepository)
+	repo.On("GetByID", mock.Anything, "user-1").
+		Return(&models.User{ID: "user-1", Username: "alice", Email: "alice@example.com"}, nil)
+	svc := NewServices(app)
+	svc.Users = service.NewUserService(repo, svc.Sessions, &app.Config, nil, nil)
+	router := newRouter(app, svc)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/profile", nil)
+	req.AddCookie(authCookie(t, app, "user-1"))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// Revocation is enforced from the in-memory session store
+	time.Sleep(time.Second) // epochs have one-second resolution
+	_, err := svc.Sessions.BumpUserEpoch(context.Background(), "user-1")
+	require.NoError(t, err)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "token issued before the epoch bump")
+
+	// And rate limits are counted in memory
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/version", nil)
+		req.RemoteAddr = "203.0.113.9:1234"
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
+
+func TestProfileLimits(t *testing.T) {
+	app := testApp(t)
+	app.Config.App_Secret = "test-secret-that-is-at-least-32-chars"
+	app.Config.RateLimit = 10
+	svc := NewServices(app)
+	router := newRouter(app, svc)
+	cookie := authCookie(t, app, "user-1")
+
+	limits := func(t *testing.T, remoteAddr string) models.LimitStatus {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/profile/limits", nil)
+		req.RemoteAddr = remoteAddr
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var body struct {
+			Data models.LimitStatus `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body.Data
+	}
+
+	t.Run("RemainingTracksRequests", func(t *testing.T) {
+		// Other requests from the same IP count against the same window
+		for i := 0; i < 3; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/version", nil)
+			req.RemoteAddr = "203.0.113.7:1234"
+			router.ServeHTTP(httptest.NewRecorder(), req)
+		}
+
+		status := limits(t, "203.0.113.7:1234")
+		assert.Equal(t, "ip", status.RateLimit.Scope)
+		assert.Equal(t, 10, status.RateLimit.Limit)
+		assert.Equal(t, int64(4), status.RateLimit.Used, "three requests plus this one")
+		assert.Equal(t, int64(6), status.RateLimit.Remaining)
+		assert.Equal(t, 60, status.RateLimit.WindowSeconds)
+
+		// Checking costs one request, nothing more
+		assert.Equal(t, int64(5), limits(t, "203.0.113.7:1234").RateLimit.Remaining)
+		assert.Nil(t, status.LastFailedLogin)
+	})
+
+	t.Run("OtherIPsCountedSeparately", func(t *testing.T) {
+		assert.Equal(t, int64(9), limits(t, "198.51.100.1:1234").RateLimit.Remaining)
+	})
+
+	t.Run("LastFailedLogin", func(t *testing.T) {
+		at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+		require.NoError(t, svc.Sessions.RecordFailedLogin(context.Background(), "user-1", at))
+
+		status := limits(t, "192.0.2.1:1234")
+		require.NotNil(t, status.LastFailedLogin)
+		assert.True(t, at.Equal(*status.LastFailedLogin))
+	})
+}
+
+func TestRateLimitKey(t *testing.T) {
+	setup := func(t *testing.T, key string) func(path, remoteAddr, userID string) int {
+		app := testApp(t)
+		app.Config.App_Secret = "test-secret-that-is-at-least-32-chars"
+		app.Config.RateLimit = 3
+		app.Config.RateLimitKey = key
+		router := newRouter(app, NewServices(app))
+
+		return func(path, remoteAddr, userID string) int {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.RemoteAddr = remoteAddr
+			if userID != "" {
+				req.AddCookie(authCookie(t, app, userID))
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			return rec.Code
+		}
+	}
+	const shared, other = "203.0.113.7:1234", "198.51.100.1:1234"
+
+	t.Run("User", func(t *testing.T) {
+		get := setup(t, config.RateLimitKeyUser)
+
+		// Two users behind one NAT each get the full limit
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusOK, get("/api/v1/profile/limits", shared, "user-1"), "user-1 request %d", i+1)
+			assert.Equal(t, http.StatusOK, get("/api/v1/profile/limits", shared, "user-2"), "user-2 request %d", i+1)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, get("/api/v1/profile/limits", shared, "user-1"))
+		assert.Equal(t, http.StatusTooManyRequests, get("/api/v1/profile/limits", other, "user-1"), "a new IP doesn't reset the user's limit")
+
+		// Unauthenticated routes are still counted by IP, apart from the users
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusOK, get("/version", shared, ""))
+		}
+		assert.Equal(t, http.StatusTooManyRequests, get("/version", shared, ""))
+	})
+
+	t.Run("FailedAuthCountedByIP", func(t *testing.T) {
+		get := setup(t, config.RateLimitKeyUser)
+
+		// Requests authentication turns away never reach UserRateLimit
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusUnauthorized, get("/api/v1/profile/limits", shared, ""), "request %d", i+1)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, get("/api/v1/profile/limits", shared, ""))
+		assert.Equal(t, http.StatusOK, get("/api/v1/profile/limits", shared, "user-1"), "signed-in users are counted by user")
+	})
+
+	t.Run("UserIP", func(t *testing.T) {
+		get := setup(t, config.RateLimitKeyUserIP)
+
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusOK, get("/api/v1/profile/limits", shared, "user-1"))
+		}
+		assert.Equal(t, http.StatusTooManyRequests, get("/api/v1/profile/limits", shared, "user-1"))
+		assert.Equal(t, http.StatusOK, get("/api/v1/profile/limits", other, "user-1"), "each IP is counted separately")
+		assert.Equal(t, http.StatusOK, get("/api/v1/profile/limits", shared, "user-2"))
+	})
+
+	t.Run("IP", func(t *testing.T) {
+		get := setup(t, config.RateLimitKeyIP)
+
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusOK, get("/api/v1/profile/limits", shared, "user-1"))
+		}
+		assert.Equal(t, http.StatusTooManyRequests, get("/api/v1/profile/limits", shared, "user-2"), "users on one IP share its limit")
+	})
+}
+
+func TestAdminRoutesRequireRole(t *testing.T) {
+	app := testApp(t)
+	app.Config.App_Secret = "test-secret-that-is-at-least-32-chars"
+	repo := new(mocks.MockUserRepository)
+	repo.On("GetByID", mock.Anything, "admin-1").
+		Return(&models.User{ID: "admin-1", Username: "root", IsActive: true, Role: models.RoleAdmin}, nil)
+	svc := NewServices(app)
+	svc.Users = service.NewUserService(repo, svc.Sessions, &app.Config, nil, nil)
+	router := newRouter(app, svc)
+
+	checked := 0
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || !(strings.HasPrefix(path, "/api/v1/admin/") || path == "/api/v1/users") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		target := strings.NewReplacer("{id}", "some-id", "{pid}", "123").Replace(path)
+		for _, method := range methods {
+			req := httptest.NewRequest(method, target, nil)
+			req.AddCookie(authCookie(t, app, "user-1"))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusForbidden, rec.Code, "%s %s", method, path)
+			checked++
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, checked, 11, "every admin route plus /users")
+
+	// An admin session gets past the gate
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/config/schema", nil)
+	req.AddCookie(roleCookie(t, app, "admin-1", models.RoleAdmin))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+}
+
+func TestSeededAdminMustChangePassword(t *testing.T) {
+	app := testApp(t)
+	app.Config.App_Secret = "test-secret-that-is-at-least-32-chars"
+	app.Config.AccessTokenMinutes = 15
+	hash, err := bcrypt.GenerateFromPassword([]byte("Initial123!"), bcrypt.MinCost)
+	require.NoError(t, err)
+	admin := &models.User{ID: "admin-1", Username: "root", PasswordHash: string(hash), IsActive: true,
+		Role: models.RoleAdmin, MustChangePassword: true}
+	repo := new(mocks.MockUserRepository)
+	repo.On("GetByID", mock.Anything, "admin-1").Return(admin, nil)
+	repo.On("UpdatePassword", mock.Anything, "admin-1", mock.Anything, 0).Return(nil)
+	svc := NewServices(app)
+	svc.Users = service.NewUserService(repo, svc.Sessions, &app.Config, nil, nil)
+	audit := new(mocks.MockAuditService)
+	audit.On("Record", mock.Anything, mock.Anything).Return(nil)
+	svc.Audit = audit
+	svc.Notifier = nil
+	router := newRouter(app, svc)
+
+	// The session the seeded admin's first login gets
+	now := time.Now()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, config.AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "admin-1",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		Role:               models.RoleAdmin,
+		MustChangePassword: true,
+	}).SignedString([]byte(app.Config.App_Secret))
+	require.NoError(t, err)
+	session := &http.Cookie{Name: config.AuthCookieName, Value: token}
+
+	serve := func(method, path, body string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{"/api/v1/admin/config/schema", "/api/v1/profile", "/api/v1/users"} {
+		rec := serve(http.MethodGet, path, "", session)
+		assert.Equal(t, http.StatusForbidden, rec.Code, path)
+		assert.Contains(t, rec.Body.String(), "Password change required", path)
+	}
+
+	// Keeping other sessions isn't on offer: the change must hand out a token
+	// without the flag
+	rec := serve(http.MethodPut, "/api/v1/password",
+		`{"current_password":"Initial123!","new_password":"Changed456!x","logout_other_sessions":false}`, session)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var renewed *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == config.AuthCookieName {
+			renewed = c
+		}
+	}
+	require.NotNil(t, renewed, "a new session cookie")
+
+	admin.MustChangePassword = false
+	rec = serve(http.MethodGet, "/api/v1/admin/config/schema", "", renewed)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+}
+
+func TestAdminIPAllowlist(t *testing.T) {
+	app := testApp(t)
+	app.Config.App_Secret = "test-secret-that-is-at-least-32-chars"
+	app.Config.AdminIPAllowlist = []string{"10.0.0.0/8"}
+	repo := new(mocks.MockUserRepository)
+	repo.On("GetByID", mock.Anything, "admin-1").
+		Return(&models.User{ID: "admin-1", Username: "root", IsActive: true, Role: models.RoleAdmin}, nil)
+	svc := NewServices(app)
+	svc.Users = service.NewUserService(repo, svc.Sessions, &app.Config, nil, nil)
+	router := newRouter(app, svc)
+
+	serve := func(path, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		req.AddCookie(roleCookie(t, app, "admin-1", models.RoleAdmin))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// /api/v1/users sits outside the admin subrouter but is just as admin-only
+	for _, path := range []string{"/api/v1/admin/config/schema", "/api/v1/users"} {
+		assert.Equal(t, http.StatusForbidden, serve(path, "203.0.113.9:1234"), path)
+	}
+	assert.Equal(t, http.StatusOK, serve("/api/v1/admin/config/schema", "10.1.2.3:1234"))
+}
+
+func TestRS256Tokens(t *testing.T) {
+	app := testApp(t)
+	app.Config.App_Secret = "test-secret-that-is-at-least-32-chars"
+
+	repo := new(mocks.MockUserRepository)
+	repo.On("GetByID", mock.Anything, "user-1").
+		Return(&models.User{ID: "user-1", Username: "alice", Email: "alice@example.com"}, nil)
+	svc := NewServices(app)
+	svc.Users = service.NewUserService(repo, svc.Sessions, &app.Config, nil, nil)
+	router := newRouter(app, svc)
+
+	get := func(path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Under HS256 there is nothing to publish
+	assert.Equal(t, http.StatusNotFound, get("/.well-known/jwks.json", nil).Code)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	require.NoError(t, jwtkeys.Configure(jwtkeys.Config{PrivateKeyPath: path}))
+	t.Cleanup(func() { jwtkeys.Configure(jwtkeys.Config{}) })
+
+	now := time.Now()
+	token, err := jwtkeys.Sign(config.AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: "user-1", IssuedAt: jwt.NewNumericDate(now), ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		Role: models.RoleUser,
+	}, app.Config.App_Secret)
+	require.NoError(t, err)
+
+	rec := get("/api/v1/profile", &http.Cookie{Name: config.AuthCookieName, Value: token})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, get("/api/v1/profile", authCookie(t, app, "user-1")).Code,
+		"HS256 tokens are refused once RS256 is configured")
+
+	rec = get("/.well-known/jwks.json", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var jwks jwtkeys.JWKS
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &jwks))
+	require.Len(t, jwks.Keys, 1)
+	assert.Equal(t, jwtkeys.RS256, jwks.Keys[0].Alg)
+}
+
+func TestRequestTimeoutFromConfig(t *testing.T) {
+	app := testApp(t)
+	app.Config.RequestTimeout = 1
+	router := newRouter(app, NewServices(app))
+
+	var deadline time.Time
+	router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ = r.Context().Deadline()
+		<-r.Context().Done()
+	})
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	assert.Equal(t, http.StatusRequestTimeout, rec.Code)
+	assert.WithinDuration(t, start.Add(time.Second), deadline, 100*time.Millisecond, "REQUEST_TIMEOUT_SECONDS sets the handler's deadline")
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestMaxBodyBytesFromConfig(t *testing.T) {
+	app := testApp(t)
+	app.Config.MaxBodyBytes = 4096
+	router := newRouter(app, NewServices(app))
+
+	body := `{"username":"` + strings.Repeat("a", 5000) + `","email":"a@example.com","password":"Password123!"}`
+	for _, chunked := range []bool{false, true} {
+		req := httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(body))
+		if chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, "chunked=%v", chunked)
+		assert.Contains(t, rec.Body.String(), `"code":"request_entity_too_large"`)
+	}
+}
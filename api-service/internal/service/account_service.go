@@ -0,0 +1,166 @@
+package service
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/hasher"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/randtoken"
+	"context"
+	"time"
+)
+
+// Lifetimes of the emailed single-use tokens
+const (
+	passwordResetTokenTTL = time.Hour
+	emailVerifyTokenTTL   = 24 * time.Hour
+)
+
+type AccountService struct {
+	users     core.UserRepository
+	tokens    core.TokenRepository
+	sessions  core.SessionStore
+	passwords core.PasswordHasher
+	random    core.TokenGenerator
+	config    *config.Config
+}
+
+// NewAccountService wires the account service. A nil passwords falls back to
+// a default hasher.Pool and a nil random to randtoken.DefaultBytes tokens.
+// A reset may not reuse any of the user's last PASSWORD_HISTORY_COUNT
+// passwords, as with a password change in UserService.
+func NewAccountService(users core.UserRepository, tokens core.TokenRepository, sessions core.SessionStore, cfg *config.Config, passwords core.PasswordHasher, random core.TokenGenerator) core.AccountService {
+	if passwords == nil {
+		passwords = hasher.NewPool(0, 0, 0)
+	}
+	if random == nil {
+		random = randtoken.New(randtoken.DefaultBytes)
+	}
+	return &AccountService{users: users, tokens: tokens, sessions: sessions, passwords: passwords, random: random, config: cfg}
+}
+
+func (s *AccountService) RequestPasswordReset(ctx context.Context, email string) (string, *models.User, error) {
+	user, err := s.users.GetByEmailOrUsername(ctx, email, "")
+	if err != nil || user == nil {
+		return "", nil, err
+	}
+
+	token, err := s.issue(ctx, user.ID, models.TokenPurposePasswordReset, passwordResetTokenTTL)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, user, nil
+}
+
+// RequestRecoveryPasswordReset only issues a token when the account has a
+// verified recovery email, so an unverified address never receives one
+func (s *AccountService) RequestRecoveryPasswordReset(ctx context.Context, email string) (string, string, *models.User, error) {
+	user, err := s.users.GetByEmailOrUsername(ctx, email, "")
+	if err != nil || user == nil {
+		return "", "", nil, err
+	}
+	contact, err := s.users.GetRecoveryContact(ctx, user.ID, models.RecoveryContactEmail)
+	if err != nil || contact == nil || !contact.Verified {
+		return "", "", nil, err
+	}
+
+	token, err := s.issue(ctx, user.ID, models.TokenPurposePasswordReset, passwordResetTokenTTL)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return token, contact.Value, user, nil
+}
+
+// ResetPassword consumes the token, sets the new password and revokes the
+// user's existing tokens. It returns the user ID the token belonged to.
+func (s *AccountService) ResetPassword(ctx context.Context, req models.ResetPasswordRequest) (string, error) {
+	// Check the history before consuming, so a reused password doesn't burn
+	// the token either. An unusable token is left for Consume to reject.
+	if s.config.PasswordHistoryCount > 0 {
+		t, err := s.tokens.Get(ctx, hashToken(req.Token), models.TokenPurposePasswordReset)
+		if err != nil {
+			return "", err
+		}
+		if t != nil {
+			user, err := s.users.GetByID(ctx, t.UserID)
+			if err != nil {
+				return "", err
+			}
+			if err := checkPasswordHistory(ctx, s.users, s.passwords, user, req.NewPassword, s.config.PasswordHistoryCount); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	// Hash before consuming so a busy hasher doesn't burn the token
+	hash, err := s.passwords.Hash(ctx, req.NewPassword)
+	if err != nil {
+		return "", err
+	}
+
+	userID, err := s.tokens.Consume(ctx, hashToken(req.Token), models.TokenPurposePasswordReset)
+	if err != nil {
+		return "", err
+	}
+	if err := s.users.UpdatePassword(ctx, userID, hash, s.config.PasswordHistoryCount); err != nil {
+		return "", err
+	}
+
+	// Whoever prompted the reset may hold a live session; end it
+	_, _ = s.sessions.BumpUserEpoch(ctx, userID)
+	return userID, nil
+}
+
+func (s *AccountService) IssueEmailVerification(ctx context.Context, userID string) (string, error) {
+	return s.issue(ctx, userID, models.TokenPurposeEmailVerify, emailVerifyTokenTTL)
+}
+
+func (s *AccountService) VerifyEmail(ctx context.Context, token string) (string, error) {
+	userID, err := s.tokens.Consume(ctx, hashToken(token), models.TokenPurposeEmailVerify)
+	if err != nil {
+		return "", err
+	}
+	return userID, s.users.SetEmailVerified(ctx, userID)
+}
+
+// ValidateToken reports whether token would currently be accepted for
+// purpose. It never consumes the token; the consuming call checks again.
+func (s *AccountService) ValidateToken(ctx context.Context, purpose, token string) (*models.TokenValidation, error) {
+	if token == "" {
+		return &models.TokenValidation{Reason: "invalid"}, nil
+	}
+
+	t, err := s.tokens.Get(ctx, hashToken(token), purpose)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case t == nil:
+		return &models.TokenValidation{Reason: "invalid"}, nil
+	case t.UsedAt != nil:
+		return &models.TokenValidation{Reason: "used"}, nil
+	case !time.Now().Before(t.ExpiresAt):
+		return &models.TokenValidation{Reason: "expired"}, nil
+	}
+	return &models.TokenValidation{Valid: true}, nil
+}
+
+func (s *AccountService) issue(ctx context.Context, userID, purpose string, ttl time.Duration) (string, error) {
+	token, err := s.random.Token()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = s.tokens.Create(ctx, &models.UserToken{
+		TokenHash: hashToken(token),
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
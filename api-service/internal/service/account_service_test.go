@@ -0,0 +1,134 @@
+package service
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/mocks"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/randtoken"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestValidateToken(t *testing.T) {
+	ctx := context.Background()
+	usedAt := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name       string
+		stored     *models.UserToken
+		wantValid  bool
+		wantReason string
+	}{
+		{
+			name:      "Valid",
+			stored:    &models.UserToken{UserID: "user-1", ExpiresAt: time.Now().Add(time.Hour)},
+			wantValid: true,
+		},
+		{
+			name:       "Expired",
+			stored:     &models.UserToken{UserID: "user-1", ExpiresAt: time.Now().Add(-time.Second)},
+			wantReason: "expired",
+		},
+		{
+			name:       "AlreadyUsed",
+			stored:     &models.UserToken{UserID: "user-1", ExpiresAt: time.Now().Add(time.Hour), UsedAt: &usedAt},
+			wantReason: "used",
+		},
+		{name: "Unknown", stored: nil, wantReason: "invalid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := new(mocks.MockTokenRepository)
+			if tt.stored == nil {
+				tokens.On("Get", ctx, hashToken("tok"), models.TokenPurposePasswordReset).Return(nil, nil)
+			} else {
+				tokens.On("Get", ctx, hashToken("tok"), models.TokenPurposePasswordReset).Return(tt.stored, nil)
+			}
+			svc := NewAccountService(new(mocks.MockUserRepository), tokens, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+
+			result, err := svc.ValidateToken(ctx, models.TokenPurposePasswordReset, "tok")
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantValid, result.Valid)
+			assert.Equal(t, tt.wantReason, result.Reason)
+
+			// Checking never consumes the token
+			tokens.AssertNotCalled(t, "Consume", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	ctx := context.Background()
+	req := models.ResetPasswordRequest{Token: "tok", NewPassword: "NewPassword123!"}
+
+	t.Run("ConsumesAndRevokesSessions", func(t *testing.T) {
+		users := new(mocks.MockUserRepository)
+		tokens := new(mocks.MockTokenRepository)
+		sessions := new(mocks.MockSessionStore)
+		tokens.On("Consume", ctx, hashToken("tok"), models.TokenPurposePasswordReset).Return("user-1", nil)
+		users.On("UpdatePassword", ctx, "user-1", mock.AnythingOfType("string"), 0).Return(nil)
+		sessions.On("BumpUserEpoch", ctx, "user-1").Return(int64(1700000000), nil)
+
+		userID, err := NewAccountService(users, tokens, sessions, &config.Config{}, nil, nil).ResetPassword(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", userID)
+		users.AssertExpectations(t)
+		sessions.AssertExpectations(t)
+	})
+
+	// Validation passing earlier doesn't matter: use-time consumption decides
+	t.Run("RejectedAtUseTime", func(t *testing.T) {
+		users := new(mocks.MockUserRepository)
+		tokens := new(mocks.MockTokenRepository)
+		tokens.On("Consume", ctx, hashToken("tok"), models.TokenPurposePasswordReset).
+			Return("", core.ErrVerificationTokenInvalid)
+
+		_, err := NewAccountService(users, tokens, new(mocks.MockSessionStore), &config.Config{}, nil, nil).ResetPassword(ctx, req)
+		assert.ErrorIs(t, err, core.ErrVerificationTokenInvalid)
+		users.AssertNotCalled(t, "UpdatePassword", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	// A reused password is refused before the token is consumed, so the
+	// user can try again with the same link
+	t.Run("ReusedPasswordKeepsToken", func(t *testing.T) {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.MinCost)
+		require.NoError(t, err)
+
+		users := new(mocks.MockUserRepository)
+		tokens := new(mocks.MockTokenRepository)
+		tokens.On("Get", ctx, hashToken("tok"), models.TokenPurposePasswordReset).
+			Return(&models.UserToken{UserID: "user-1", ExpiresAt: time.Now().Add(time.Hour)}, nil)
+		users.On("GetByID", ctx, "user-1").Return(&models.User{ID: "user-1", PasswordHash: "current"}, nil)
+		users.On("RecentPasswordHashes", ctx, "user-1", 5).Return([]string{string(hash)}, nil)
+
+		_, err = NewAccountService(users, tokens, new(mocks.MockSessionStore), &config.Config{PasswordHistoryCount: 5}, nil, nil).ResetPassword(ctx, req)
+		assert.ErrorIs(t, err, core.ErrPasswordReused)
+		tokens.AssertNotCalled(t, "Consume", mock.Anything, mock.Anything, mock.Anything)
+		users.AssertNotCalled(t, "UpdatePassword", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestIssueEmailVerificationUsesGenerator(t *testing.T) {
+	ctx := context.Background()
+	want, err := randtoken.NewSeeded(7, 0).Token()
+	require.NoError(t, err)
+
+	tokens := new(mocks.MockTokenRepository)
+	tokens.On("Create", ctx, mock.MatchedBy(func(tok *models.UserToken) bool {
+		return tok.TokenHash == hashToken(want) && tok.Purpose == models.TokenPurposeEmailVerify
+	})).Return(nil)
+	svc := NewAccountService(new(mocks.MockUserRepository), tokens, new(mocks.MockSessionStore), &config.Config{}, nil, randtoken.NewSeeded(7, 0))
+
+	token, err := svc.IssueEmailVerification(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, want, token)
+	tokens.AssertExpectations(t)
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/randtoken"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// apiKeyPrefix marks keys issued by this service, e.g. azlo_1a2b3c4d_<secret>
+const apiKeyPrefix = "azlo"
+
+// lastUsedResolution is how stale last_used_at may get before a request
+// refreshes it, so a busy key doesn't write on every call
+const lastUsedResolution = time.Minute
+
+// touchTimeout bounds the background last_used_at update
+const touchTimeout = 5 * time.Second
+
+type APIKeyService struct {
+	repo   core.APIKeyRepository
+	random core.TokenGenerator
+}
+
+// NewAPIKeyService wires the API key service. A nil random falls back to
+// randtoken.DefaultBytes tokens.
+func NewAPIKeyService(repo core.APIKeyRepository, random core.TokenGenerator) core.APIKeyService {
+	if random == nil {
+		random = randtoken.New(randtoken.DefaultBytes)
+	}
+	return &APIKeyService{repo: repo, random: random}
+}
+
+func (s *APIKeyService) Create(ctx context.Context, userID string, req models.CreateAPIKeyRequest) (*models.CreateAPIKeyResponse, error) {
+	idBytes, err := s.random.Bytes(4)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := s.random.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := apiKeyPrefix + "_" + hex.EncodeToString(idBytes)
+	plaintext := prefix + "_" + secret
+
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{models.APIKeyScopeRead}
+	}
+
+	key := &models.APIKey{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   hashAPIKey(plaintext),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	if err := s.repo.Create(ctx, key); err != nil {
+		return nil, err
+	}
+
+	return &models.CreateAPIKeyResponse{APIKeySummary: key.Summary(), Key: plaintext}, nil
+}
+
+func (s *APIKeyService) List(ctx context.Context, userID string) ([]models.APIKeySummary, error) {
+	keys, err := s.repo.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]models.APIKeySummary, 0, len(keys))
+	for i := range keys {
+		summaries = append(summaries, keys[i].Summary())
+	}
+	return summaries, nil
+}
+
+// Revoke only affects keys owned by userID; anyone else's key reports ErrAPIKeyNotFound
+func (s *APIKeyService) Revoke(ctx context.Context, userID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return core.ErrAPIKeyNotFound
+	}
+
+	revoked, err := s.repo.Revoke(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return core.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a presented key to its active record. Revoked keys are
+// rejected on the very next request since every call consults the repository.
+func (s *APIKeyService) Authenticate(ctx context.Context, plaintext string) (*models.APIKey, error) {
+	parts := strings.SplitN(plaintext, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return nil, core.ErrAPIKeyInvalid
+	}
+
+	key, err := s.repo.GetByPrefix(ctx, parts[0]+"_"+parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashAPIKey(plaintext))) != 1 {
+		return nil, core.ErrAPIKeyInvalid
+	}
+
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) >= lastUsedResolution {
+		// Recording use must not slow down or fail the request
+		go func(id string) {
+			touchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), touchTimeout)
+			defer cancel()
+			_ = s.repo.TouchLastUsed(touchCtx, id)
+		}(key.ID)
+	}
+	return key, nil
+}
+
+// hashAPIKey uses SHA-256: keys carry 256 bits of entropy, so a slow KDF adds nothing
+func hashAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/pagination"
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditService struct {
+	repo   core.AuditRepository
+	config *config.Config
+}
+
+func NewAuditService(repo core.AuditRepository, cfg *config.Config) core.AuditService {
+	return &AuditService{repo: repo, config: cfg}
+}
+
+func (s *AuditService) Record(ctx context.Context, event models.AuditEvent) error {
+	event.ID = uuid.New().String()
+	event.CreatedAt = time.Now()
+	return s.repo.RecordEvent(ctx, &event)
+}
+
+func (s *AuditService) RecordLogin(ctx context.Context, userID, ipAddress, userAgent string) error {
+	return s.repo.RecordLogin(ctx, &models.LoginEvent{
+		ID: uuid.New().String(), UserID: userID, IPAddress: ipAddress, UserAgent: userAgent, CreatedAt: time.Now(),
+	})
+}
+
+func (s *AuditService) ListEvents(ctx context.Context, before string, limit int) ([]models.AuditEvent, *models.KeysetMetadata, error) {
+	p, err := pagination.NewParams(before, limit, s.config.AuditMaxPageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := s.repo.ListEvents(ctx, p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events, next := pagination.Page(rows, p, func(e models.AuditEvent) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: e.CreatedAt, ID: e.ID}
+	})
+	return events, keysetMetadata(p, next), nil
+}
+
+func (s *AuditService) ListLoginHistory(ctx context.Context, userID, before string, limit int) ([]models.LoginEvent, *models.KeysetMetadata, error) {
+	p, err := pagination.NewParams(before, limit, s.config.AuditMaxPageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := s.repo.ListLogins(ctx, userID, p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logins, next := pagination.Page(rows, p, func(l models.LoginEvent) pagination.Cursor {
+		return pagination.Cursor{CreatedAt: l.CreatedAt, ID: l.ID}
+	})
+	return logins, keysetMetadata(p, next), nil
+}
+
+func keysetMetadata(p pagination.Params, next string) *models.KeysetMetadata {
+	return &models.KeysetMetadata{
+		Limit:      p.Limit,
+		NextCursor: next,
+		HasMore:    next != "",
+	}
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/mocks"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/pagination"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestListLoginHistory(t *testing.T) {
+	mockRepo := new(mocks.MockAuditRepository)
+	cfg := &config.Config{AuditMaxPageSize: 2}
+	service := NewAuditService(mockRepo, cfg)
+	ctx := context.Background()
+	now := time.Now()
+	a, b, c := uuid.NewString(), uuid.NewString(), uuid.NewString()
+
+	t.Run("HasMore", func(t *testing.T) {
+		// Arrange: the repository over-fetches by one row
+		mockRepo.On("ListLogins", ctx, "user-1", mock.MatchedBy(func(p pagination.Params) bool {
+			return p.Limit == 2 && p.Before == nil
+		})).Return([]models.LoginEvent{
+			{ID: c, CreatedAt: now},
+			{ID: b, CreatedAt: now.Add(-time.Minute)},
+			{ID: a, CreatedAt: now.Add(-2 * time.Minute)},
+		}, nil).Once()
+
+		// Act: ask for more than the configured max
+		logins, meta, err := service.ListLoginHistory(ctx, "user-1", "", 50)
+
+		// Assert
+		assert.NoError(t, err)
+		assert.Len(t, logins, 2)
+		assert.Equal(t, 2, meta.Limit)
+		assert.True(t, meta.HasMore)
+
+		cursor, err := pagination.DecodeCursor(meta.NextCursor)
+		assert.NoError(t, err)
+		assert.Equal(t, b, cursor.ID)
+	})
+
+	t.Run("LastPage", func(t *testing.T) {
+		before := pagination.Cursor{CreatedAt: now.Add(-time.Minute), ID: b}
+		mockRepo.On("ListLogins", ctx, "user-1", mock.MatchedBy(func(p pagination.Params) bool {
+			return p.Before != nil && p.Before.ID == b
+		})).Return([]models.LoginEvent{{ID: a, CreatedAt: now.Add(-2 * time.Minute)}}, nil).Once()
+
+		logins, meta, err := service.ListLoginHistory(ctx, "user-1", before.Encode(), 2)
+
+		assert.NoError(t, err)
+		assert.Len(t, logins, 1)
+		assert.False(t, meta.HasMore)
+		assert.Empty(t, meta.NextCursor)
+	})
+
+	t.Run("Fail_InvalidCursor", func(t *testing.T) {
+		_, _, err := service.ListLoginHistory(ctx, "user-1", "garbage!", 2)
+
+		assert.ErrorIs(t, err, pagination.ErrInvalidCursor)
+		mockRepo.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/kvstore"
+	"azlo-goboiler/internal/models"
+	"context"
+	"time"
+)
+
+type LimitsService struct {
+	kv       core.KVStore
+	sessions core.SessionStore
+	config   *config.Config
+	now      func() time.Time
+}
+
+func NewLimitsService(kv core.KVStore, sessions core.SessionStore, cfg *config.Config) core.LimitsService {
+	return &LimitsService{kv: kv, sessions: sessions, config: cfg, now: time.Now}
+}
+
+// Status reads the same window the rate limit middleware writes for an
+// authenticated request: the IP's, the user's or the user's on that IP, per
+// RATE_LIMIT_KEY. With RATE_LIMIT_LOCAL_CACHE set, hits an instance has not
+// yet flushed to the store are missing, so the count can trail the limiter's
+// own view slightly.
+func (s *LimitsService) Status(ctx context.Context, userID, ip string) (*models.LimitStatus, error) {
+	scope := s.config.GetRateLimitKey()
+	client := ip
+	switch scope {
+	case config.RateLimitKeyUser:
+		client = kvstore.UserRateLimitClient(userID, "")
+	case config.RateLimitKeyUserIP:
+		client = kvstore.UserRateLimitClient(userID, ip)
+	}
+
+	window := s.config.GetRateLimitWindow()
+	used, _, err := s.kv.SlidingWindowCount(ctx, kvstore.RateLimitKey(client), s.now(), window)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := int64(s.config.RateLimit) - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	status := &models.LimitStatus{
+		RateLimit: models.RateLimitUsage{
+			Scope:         scope,
+			Limit:         s.config.RateLimit,
+			Used:          used,
+			Remaining:     remaining,
+			WindowSeconds: int(window.Seconds()),
+		},
+	}
+
+	failed, err := s.sessions.LastFailedLogin(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !failed.IsZero() {
+		status.LastFailedLogin = &failed
+	}
+	return status, nil
+}
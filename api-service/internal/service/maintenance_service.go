@@ -0,0 +1,238 @@
+package service
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/kvstore"
+	"azlo-goboiler/internal/models"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maintenanceConfirmTTL is how long a confirmation token can be used
+	maintenanceConfirmTTL = 5 * time.Minute
+	// maintenanceJobTTL is how long a finished job stays pollable
+	maintenanceJobTTL = 24 * time.Hour
+	// maintenanceTimeout caps a single run
+	maintenanceTimeout = 30 * time.Minute
+)
+
+// MaintenanceService runs database maintenance in two steps: Confirm returns
+// a short-lived token bound to the admin and the exact tables and mode, and
+// Start only accepts a request carrying that token. Jobs run in the
+// background and their state is kept in the KV store so any instance can
+// answer a poll.
+type MaintenanceService struct {
+	repo    core.MaintenanceRepository
+	jobs    core.KVStore
+	secret  []byte
+	now     func() time.Time
+	running atomic.Bool
+}
+
+func NewMaintenanceService(repo core.MaintenanceRepository, jobs core.KVStore, cfg *config.Config) core.MaintenanceService {
+	return &MaintenanceService{repo: repo, jobs: jobs, secret: []byte(cfg.App_Secret), now: time.Now}
+}
+
+func (s *MaintenanceService) Confirm(ctx context.Context, userID string, req models.MaintenanceRequest) (*models.MaintenanceConfirmation, error) {
+	tables, err := s.resolveTables(req.Tables)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := s.now().Add(maintenanceConfirmTTL).Truncate(time.Second)
+	return &models.MaintenanceConfirmation{
+		ConfirmationToken: fmt.Sprintf("%d.%s", expiresAt.Unix(), s.sign(userID, tables, req.Vacuum, expiresAt.Unix())),
+		ExpiresAt:         expiresAt,
+		Tables:            tables,
+		Vacuum:            req.Vacuum,
+	}, nil
+}
+
+func (s *MaintenanceService) Start(ctx context.Context, userID string, req models.MaintenanceRequest) (*models.MaintenanceJob, error) {
+	tables, err := s.resolveTables(req.Tables)
+	if err != nil {
+		return nil, err
+	}
+	if !s.confirmed(req.ConfirmationToken, userID, tables, req.Vacuum) {
+		return nil, core.ErrMaintenanceConfirmation
+	}
+	// The repository's advisory lock covers other instances; this stops a
+	// second job from queueing on this one
+	if !s.running.CompareAndSwap(false, true) {
+		return nil, core.ErrMaintenanceRunning
+	}
+
+	job := &models.MaintenanceJob{
+		ID:          uuid.New().String(),
+		Status:      models.MaintenanceQueued,
+		Tables:      tables,
+		Vacuum:      req.Vacuum,
+		RequestedBy: userID,
+		CreatedAt:   s.now().UTC(),
+	}
+	if err := s.save(ctx, job); err != nil {
+		s.running.Store(false)
+		return nil, err
+	}
+
+	snapshot := *job
+	go s.run(job)
+	return &snapshot, nil
+}
+
+func (s *MaintenanceService) Job(ctx context.Context, id string) (*models.MaintenanceJob, error) {
+	raw, err := s.jobs.Get(ctx, maintenanceJobKey(id))
+	if errors.Is(err, core.ErrKeyNotFound) {
+		return nil, core.ErrMaintenanceJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job models.MaintenanceJob
+	if err := json.Unmarshal([]byte(raw), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *MaintenanceService) Sessions(ctx context.Context) ([]models.DBSession, error) {
+	return s.repo.Sessions(ctx)
+}
+
+func (s *MaintenanceService) CancelSession(ctx context.Context, pid int32) (string, error) {
+	return s.repo.CancelSession(ctx, pid)
+}
+
+func (s *MaintenanceService) Mode(ctx context.Context) (*models.MaintenanceMode, error) {
+	raw, err := s.jobs.Get(ctx, kvstore.MaintenanceModeKey)
+	if errors.Is(err, core.ErrKeyNotFound) {
+		return &models.MaintenanceMode{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var mode models.MaintenanceMode
+	if err := json.Unmarshal([]byte(raw), &mode); err != nil {
+		return nil, err
+	}
+	return &mode, nil
+}
+
+// SetMode keeps the switch with no TTL: maintenance mode stays on until an
+// admin turns it off, however long that takes
+func (s *MaintenanceService) SetMode(ctx context.Context, userID string, req models.MaintenanceModeRequest) (*models.MaintenanceMode, error) {
+	mode := &models.MaintenanceMode{Enabled: req.Enabled}
+	if req.Enabled {
+		since := s.now().UTC()
+		mode.Message, mode.RetryAfter, mode.Since, mode.SetBy = req.Message, req.RetryAfter, &since, userID
+	}
+	raw, err := json.Marshal(mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.jobs.Set(ctx, kvstore.MaintenanceModeKey, string(raw), 0); err != nil {
+		return nil, err
+	}
+	return mode, nil
+}
+
+// run executes the job detached from the request that started it. State
+// updates are best-effort; a failed save only leaves the poll result stale.
+func (s *MaintenanceService) run(job *models.MaintenanceJob) {
+	defer s.running.Store(false)
+	ctx, cancel := context.WithTimeout(context.Background(), maintenanceTimeout)
+	defer cancel()
+
+	started := s.now().UTC()
+	job.Status, job.StartedAt = models.MaintenanceRunning, &started
+	_ = s.save(ctx, job)
+
+	err := s.repo.Run(ctx, job.Tables, job.Vacuum)
+
+	finished := s.now().UTC()
+	job.FinishedAt = &finished
+	if err != nil {
+		job.Status, job.Error = models.MaintenanceFailed, err.Error()
+	} else {
+		job.Status = models.MaintenanceSucceeded
+	}
+	// The run may have used up ctx; the final state must still land
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer saveCancel()
+	_ = s.save(saveCtx, job)
+}
+
+// resolveTables maps requested names onto the repository's allowlist,
+// accepting either "schema.table" or a bare table name. It returns the
+// qualified names sorted and deduplicated; an empty request means all.
+func (s *MaintenanceService) resolveTables(requested []string) ([]string, error) {
+	allowed := s.repo.Tables()
+	if len(requested) == 0 {
+		sort.Strings(allowed)
+		return allowed, nil
+	}
+
+	seen := make(map[string]bool, len(requested))
+	tables := make([]string, 0, len(requested))
+	for _, name := range requested {
+		match := ""
+		for _, a := range allowed {
+			if name == a || (!strings.Contains(name, ".") && strings.HasSuffix(a, "."+name)) {
+				match = a
+				break
+			}
+		}
+		if match == "" {
+			return nil, fmt.Errorf("%w: %s", core.ErrUnknownTable, name)
+		}
+		if !seen[match] {
+			seen[match] = true
+			tables = append(tables, match)
+		}
+	}
+	sort.Strings(tables)
+	return tables, nil
+}
+
+func (s *MaintenanceService) confirmed(token, userID string, tables []string, vacuum bool) bool {
+	expiry, mac, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || s.now().Unix() > unix {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(s.sign(userID, tables, vacuum, unix)))
+}
+
+func (s *MaintenanceService) sign(userID string, tables []string, vacuum bool, expiresAt int64) string {
+	m := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(m, "db-maintenance\n%s\n%s\n%t\n%d", userID, strings.Join(tables, ","), vacuum, expiresAt)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func (s *MaintenanceService) save(ctx context.Context, job *models.MaintenanceJob) error {
+	raw, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return s.jobs.Set(ctx, maintenanceJobKey(job.ID), string(raw), maintenanceJobTTL)
+}
+
+func maintenanceJobKey(id string) string {
+	return "maintenance:job:" + id
+}
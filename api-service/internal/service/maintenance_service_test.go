@@ -0,0 +1,196 @@
+package service
+
+import (
+	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/kvstore"
+	"azlo-goboiler/internal/models"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMaintenanceRepo runs whatever run does, recording each call
+type fakeMaintenanceRepo struct {
+	run   func(ctx context.Context, tables []string, vacuum bool) error
+	calls chan []string
+}
+
+func (f *fakeMaintenanceRepo) Tables() []string {
+	return []string{"auth.users", "auth.audit_log", "auth.api_keys"}
+}
+
+func (f *fakeMaintenanceRepo) Run(ctx context.Context, tables []string, vacuum bool) error {
+	f.calls <- tables
+	if f.run == nil {
+		return nil
+	}
+	return f.run(ctx, tables, vacuum)
+}
+
+func (f *fakeMaintenanceRepo) Sessions(ctx context.Context) ([]models.DBSession, error) {
+	return nil, nil
+}
+
+func (f *fakeMaintenanceRepo) CancelSession(ctx context.Context, pid int32) (string, error) {
+	return "", core.ErrDBSessionNotFound
+}
+
+func newTestMaintenance(run func(context.Context, []string, bool) error) (*MaintenanceService, *fakeMaintenanceRepo) {
+	repo := &fakeMaintenanceRepo{run: run, calls: make(chan []string, 4)}
+	svc := NewMaintenanceService(repo, kvstore.NewMemory(), &config.Config{App_Secret: "test-secret-that-is-at-least-32-chars"})
+	return svc.(*MaintenanceService), repo
+}
+
+func TestMaintenanceGuards(t *testing.T) {
+	ctx := context.Background()
+	svc, repo := newTestMaintenance(nil)
+
+	t.Run("UnknownTableRejected", func(t *testing.T) {
+		for _, table := range []string{"pg_catalog.pg_authid", "users; DROP TABLE auth.users", "public.users", "auth.*"} {
+			_, err := svc.Confirm(ctx, "admin-1", models.MaintenanceRequest{Tables: []string{table}})
+			assert.ErrorIs(t, err, core.ErrUnknownTable, table)
+
+			_, err = svc.Start(ctx, "admin-1", models.MaintenanceRequest{Tables: []string{table}, ConfirmationToken: "x"})
+			assert.ErrorIs(t, err, core.ErrUnknownTable, table)
+		}
+		assert.Empty(t, repo.calls)
+	})
+
+	t.Run("BareNamesResolve", func(t *testing.T) {
+		c, err := svc.Confirm(ctx, "admin-1", models.MaintenanceRequest{Tables: []string{"users", "auth.users", "api_keys"}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"auth.api_keys", "auth.users"}, c.Tables)
+	})
+
+	t.Run("EmptyMeansAll", func(t *testing.T) {
+		c, err := svc.Confirm(ctx, "admin-1", models.MaintenanceRequest{})
+		require.NoError(t, err)
+		assert.Len(t, c.Tables, 3)
+	})
+
+	t.Run("TokenBoundToRequest", func(t *testing.T) {
+		c, err := svc.Confirm(ctx, "admin-1", models.MaintenanceRequest{Tables: []string{"users"}})
+		require.NoError(t, err)
+
+		cases := map[string]struct {
+			userID string
+			req    models.MaintenanceRequest
+		}{
+			"OtherTables": {"admin-1", models.MaintenanceRequest{Tables: []string{"audit_log"}, ConfirmationToken: c.ConfirmationToken}},
+			"AddsVacuum":  {"admin-1", models.MaintenanceRequest{Tables: []string{"users"}, Vacuum: true, ConfirmationToken: c.ConfirmationToken}},
+			"OtherAdmin":  {"admin-2", models.MaintenanceRequest{Tables: []string{"users"}, ConfirmationToken: c.ConfirmationToken}},
+			"Garbage":     {"admin-1", models.MaintenanceRequest{Tables: []string{"users"}, ConfirmationToken: "123.abc"}},
+		}
+		for name, tc := range cases {
+			_, err := svc.Start(ctx, tc.userID, tc.req)
+			assert.ErrorIs(t, err, core.ErrMaintenanceConfirmation, name)
+		}
+		assert.Empty(t, repo.calls)
+	})
+
+	t.Run("TokenExpires", func(t *testing.T) {
+		c, err := svc.Confirm(ctx, "admin-1", models.MaintenanceRequest{})
+		require.NoError(t, err)
+
+		svc.now = func() time.Time { return time.Now().Add(maintenanceConfirmTTL + time.Second) }
+		defer func() { svc.now = time.Now }()
+
+		_, err = svc.Start(ctx, "admin-1", models.MaintenanceRequest{ConfirmationToken: c.ConfirmationToken})
+		assert.ErrorIs(t, err, core.ErrMaintenanceConfirmation)
+	})
+}
+
+func TestMaintenanceJobLifecycle(t *testing.T) {
+	ctx := context.Background()
+	start := func(t *testing.T, svc *MaintenanceService, req models.MaintenanceRequest) (*models.MaintenanceJob, error) {
+		c, err := svc.Confirm(ctx, "admin-1", req)
+		require.NoError(t, err)
+		req.ConfirmationToken = c.ConfirmationToken
+		return svc.Start(ctx, "admin-1", req)
+	}
+	status := func(svc *MaintenanceService, id string) func() bool {
+		return func() bool {
+			job, err := svc.Job(ctx, id)
+			return err == nil && job.FinishedAt != nil
+		}
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		release := make(chan struct{})
+		svc, repo := newTestMaintenance(func(ctx context.Context, tables []string, vacuum bool) error {
+			<-release
+			return nil
+		})
+
+		job, err := start(t, svc, models.MaintenanceRequest{Tables: []string{"users"}, Vacuum: true})
+		require.NoError(t, err)
+		assert.Equal(t, models.MaintenanceQueued, job.Status)
+		assert.Equal(t, []string{"auth.users"}, <-repo.calls)
+
+		running, err := svc.Job(ctx, job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.MaintenanceRunning, running.Status)
+		assert.NotNil(t, running.StartedAt)
+
+		_, err = start(t, svc, models.MaintenanceRequest{})
+		assert.ErrorIs(t, err, core.ErrMaintenanceRunning, "one job at a time")
+
+		close(release)
+		require.Eventually(t, status(svc, job.ID), time.Second, time.Millisecond)
+		done, _ := svc.Job(ctx, job.ID)
+		assert.Equal(t, models.MaintenanceSucceeded, done.Status)
+		assert.True(t, done.Vacuum)
+		assert.Empty(t, done.Error)
+
+		_, err = start(t, svc, models.MaintenanceRequest{})
+		assert.NoError(t, err, "a new job may start once the last finished")
+	})
+
+	t.Run("Fails", func(t *testing.T) {
+		svc, _ := newTestMaintenance(func(ctx context.Context, tables []string, vacuum bool) error {
+			return errors.New("auth.users: canceling statement due to lock timeout")
+		})
+
+		job, err := start(t, svc, models.MaintenanceRequest{})
+		require.NoError(t, err)
+		require.Eventually(t, status(svc, job.ID), time.Second, time.Millisecond)
+
+		done, _ := svc.Job(ctx, job.ID)
+		assert.Equal(t, models.MaintenanceFailed, done.Status)
+		assert.Contains(t, done.Error, "lock timeout")
+	})
+
+	t.Run("UnknownJob", func(t *testing.T) {
+		svc, _ := newTestMaintenance(nil)
+		_, err := svc.Job(ctx, "does-not-exist")
+		assert.ErrorIs(t, err, core.ErrMaintenanceJobNotFound)
+	})
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestMaintenance(nil)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+
+	mode, err := svc.Mode(ctx)
+	require.NoError(t, err)
+	assert.False(t, mode.Enabled, "off until first set")
+
+	_, err = svc.SetMode(ctx, "admin-1", models.MaintenanceModeRequest{Enabled: true, Message: "Migrating", RetryAfter: 60})
+	require.NoError(t, err)
+	mode, err = svc.Mode(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &models.MaintenanceMode{Enabled: true, Message: "Migrating", RetryAfter: 60, Since: &now, SetBy: "admin-1"}, mode)
+
+	_, err = svc.SetMode(ctx, "admin-2", models.MaintenanceModeRequest{Enabled: false, Message: "ignored"})
+	require.NoError(t, err)
+	mode, err = svc.Mode(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &models.MaintenanceMode{}, mode)
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/username"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// identityUsernameAttempts bounds the search for a free username for a new
+// user signing in through a provider
+const identityUsernameAttempts = 5
+
+// LoginWithIdentity links by email only when both sides have verified it:
+// the provider must vouch for the address, and an existing account must have
+// confirmed it too. Otherwise whoever registered an address they don't own
+// would get the real owner's provider sign-in linked to their account.
+func (s *UserService) LoginWithIdentity(ctx context.Context, profile models.OAuthProfile) (*models.IdentityLogin, error) {
+	if profile.Subject == "" {
+		return nil, errors.New("provider profile has no subject")
+	}
+
+	userID, err := s.repo.GetUserIDByIdentity(ctx, profile.Provider, profile.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *models.User
+	result := &models.IdentityLogin{}
+	if userID != "" {
+		if user, err = s.repo.GetByID(ctx, userID); err != nil {
+			return nil, err
+		}
+	} else {
+		if !profile.EmailVerified || profile.Email == "" {
+			return nil, core.ErrIdentityEmailUnverified
+		}
+		if user, err = s.repo.GetByEmailOrUsername(ctx, profile.Email, ""); err != nil {
+			return nil, err
+		}
+		switch {
+		case user == nil:
+			if user, err = s.createIdentityUser(ctx, profile); err != nil {
+				return nil, err
+			}
+			result.Created = true
+		case !user.EmailVerified:
+			return nil, core.ErrIdentityLinkUnverified
+		}
+
+		err = s.repo.LinkIdentity(ctx, &models.UserIdentity{
+			Provider: profile.Provider, ProviderUserID: profile.Subject,
+			UserID: user.ID, Email: profile.Email, CreatedAt: time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.Linked = true
+	}
+
+	_ = s.repo.UpdateLastLogin(ctx, user.ID)
+
+	if result.LoginResponse, err = s.issueToken(ctx, user, uuid.New().String()); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// maxTrustedUserName is the longest name TrustedUser links, the width of
+// the identity's provider_user_id column
+const maxTrustedUserName = 255
+
+// TrustedUser trusts the proxy to have authenticated name, so on first sight
+// it is linked to the account with that username or email, unlike
+// LoginWithIdentity's verified-email rule. Without an account one is created
+// only under TRUSTED_AUTH_CREATE_USERS, and only with an email to give it:
+// email, or name itself when it is an address.
+func (s *UserService) TrustedUser(ctx context.Context, name, email string) (*models.User, error) {
+	if name == "" || len(name) > maxTrustedUserName {
+		return nil, core.ErrTrustedUserUnknown
+	}
+
+	userID, err := s.repo.GetUserIDByIdentity(ctx, models.ProviderTrustedHeader, name)
+	if err != nil {
+		return nil, err
+	}
+	if userID != "" {
+		return s.repo.GetByID(ctx, userID)
+	}
+
+	if email == "" && strings.Contains(name, "@") {
+		email = name
+	}
+	user, err := s.repo.GetByEmailOrUsername(ctx, email, name)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		if !s.config.TrustedAuthCreateUsers || email == "" {
+			return nil, core.ErrTrustedUserUnknown
+		}
+		if user, err = s.createIdentityUser(ctx, models.OAuthProfile{Name: name, Email: email}); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.repo.LinkIdentity(ctx, &models.UserIdentity{
+		Provider: models.ProviderTrustedHeader, ProviderUserID: name,
+		UserID: user.ID, Email: email, CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// createIdentityUser creates an account for a provider sign-in. It has no
+// password; the user can set one through the password reset flow.
+func (s *UserService) createIdentityUser(ctx context.Context, profile models.OAuthProfile) (*models.User, error) {
+	name, err := s.identityUsername(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	user := &models.User{
+		ID: uuid.New().String(), Username: name, Email: profile.Email,
+		IsActive: true, EmailVerified: true, CreatedAt: now, UpdatedAt: now,
+	}
+	if err := s.repo.Create(ctx, user); err != nil {
+		return nil, err
+	}
+	// The provider has verified the address
+	if err := s.repo.SetEmailVerified(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// identityUsername picks a free username from the profile's name or email,
+// adding a random number when the plain form is taken
+func (s *UserService) identityUsername(ctx context.Context, profile models.OAuthProfile) (string, error) {
+	base := usernameFrom(profile.Name)
+	if len(base) < 3 {
+		local, _, _ := strings.Cut(profile.Email, "@")
+		base = usernameFrom(local)
+	}
+	if len(base) < 3 {
+		base = "user"
+	}
+
+	for attempt := range identityUsernameAttempts {
+		candidate := base
+		if attempt > 0 {
+			b, err := s.random.Bytes(2)
+			if err != nil {
+				return "", err
+			}
+			candidate = fmt.Sprintf("%s%d", base, binary.BigEndian.Uint16(b)%10000)
+		}
+
+		existing, err := s.repo.GetByEmailOrUsername(ctx, "", candidate)
+		if err != nil {
+			return "", err
+		}
+		if existing != nil {
+			continue
+		}
+		lookalike, err := s.usernameLookalikeTaken(ctx, candidate, "")
+		if err != nil {
+			return "", err
+		}
+		if !lookalike {
+			return candidate, nil
+		}
+	}
+	return "", core.ErrUsernameTaken
+}
+
+// usernameFrom keeps the letters and digits of s, the characters a username
+// may contain, leaving room for a numeric suffix within the 50-byte limit
+func usernameFrom(s string) string {
+	var b strings.Builder
+	for _, r := range username.Display(s) {
+		if !unicode.IsLetter(r) && !unicode.Is(unicode.Nd, r) {
+			continue
+		}
+		if b.Len()+len(string(r)) > 40 {
+			break
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
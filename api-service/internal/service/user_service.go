@@ -3,43 +3,86 @@ package service
 import (
 	"azlo-goboiler/internal/config"
 	"azlo-goboiler/internal/core"
+	"azlo-goboiler/internal/hasher"
+	"azlo-goboiler/internal/jwtkeys"
 	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/notification"
+	"azlo-goboiler/internal/randtoken"
+	"azlo-goboiler/internal/username"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// Page size bounds for the users list
+const (
+	DefaultUsersPageSize = 10
+	MaxUsersPageSize     = 100
+)
+
+// notificationEmailTokenTTL is how long a notification email verification link stays valid
+const notificationEmailTokenTTL = 24 * time.Hour
+
+// recoveryEmailTokenTTL is how long a recovery email verification link stays valid
+const recoveryEmailTokenTTL = 24 * time.Hour
+
 type UserService struct {
-	repo   core.UserRepository
-	config *config.Config
+	repo      core.UserRepository
+	sessions  core.SessionStore
+	passwords core.PasswordHasher
+	random    core.TokenGenerator
+	config    *config.Config
 }
 
-func NewUserService(repo core.UserRepository, cfg *config.Config) core.UserService {
-	return &UserService{repo: repo, config: cfg}
+// NewUserService wires the user service. A nil passwords falls back to a
+// default hasher.Pool; share one pool between services so the cap is global.
+// A nil random falls back to randtoken.DefaultBytes tokens, as in the other
+// services; pass a generator to honour TOKEN_BYTES.
+func NewUserService(repo core.UserRepository, sessions core.SessionStore, cfg *config.Config, passwords core.PasswordHasher, random core.TokenGenerator) core.UserService {
+	if passwords == nil {
+		passwords = hasher.NewPool(0, 0, 0)
+	}
+	if random == nil {
+		random = randtoken.New(randtoken.DefaultBytes)
+	}
+	return &UserService{repo: repo, sessions: sessions, passwords: passwords, random: random, config: cfg}
 }
 
 // --- Auth Methods (Already Implemented) ---
 func (s *UserService) Register(ctx context.Context, req models.RegisterRequest) (*models.RegisterResponse, error) {
+	req.Username = username.Display(req.Username)
+
 	existing, err := s.repo.GetByEmailOrUsername(ctx, req.Email, req.Username)
 	if err != nil {
 		return nil, err
 	}
 	if existing != nil {
-		return nil, errors.New("user with this email or username already exists")
+		return nil, core.ErrUserExists
+	}
+	lookalike, err := s.usernameLookalikeTaken(ctx, req.Username, "")
+	if err != nil {
+		return nil, err
+	}
+	if lookalike {
+		return nil, core.ErrUserExists
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := s.passwords.Hash(ctx, req.Password)
 	if err != nil {
 		return nil, err
 	}
 
 	newUser := &models.User{
 		ID: uuid.New().String(), Username: req.Username, Email: req.Email,
-		PasswordHash: string(hashedPassword), IsActive: true, CreatedAt: time.Now(), UpdatedAt: time.Now(),
+		PasswordHash: hashedPassword, IsActive: true, CreatedAt: time.Now(), UpdatedAt: time.Now(),
 	}
 
 	if err := s.repo.Create(ctx, newUser); err != nil {
@@ -54,27 +97,72 @@ func (s *UserService) Login(ctx context.Context, req models.LoginRequest) (*mode
 		return nil, errors.New("invalid credentials")
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+	if err := s.passwords.Compare(ctx, user.PasswordHash, req.Password); err != nil {
+		if errors.Is(err, core.ErrHasherBusy) {
+			return nil, err
+		}
+		// Best effort: shown to the user on their limits page
+		if s.sessions != nil {
+			_ = s.sessions.RecordFailedLogin(ctx, user.ID, time.Now())
+		}
 		return nil, errors.New("invalid credentials")
 	}
 
 	_ = s.repo.UpdateLastLogin(ctx, user.ID)
 
-	expirationTime := time.Now().Add(s.config.GetJWTExpiration())
-	claims := &jwt.RegisteredClaims{
-		Subject: user.ID, ExpiresAt: jwt.NewNumericDate(expirationTime),
-		IssuedAt: jwt.NewNumericDate(time.Now()), Issuer: "go-api-boilerplate",
+	return s.issueToken(ctx, user, uuid.New().String())
+}
+
+// issueToken signs an access token for user's session sessionID and, when
+// a session store is configured, a refresh token for it. The refresh token
+// is opaque, "<user ID>.<random ID>", and only its hash is stored, so it can
+// be rotated and revoked server-side.
+func (s *UserService) issueToken(ctx context.Context, user *models.User, sessionID string) (*models.LoginResponse, error) {
+	now := time.Now()
+	expirationTime := now.Add(s.config.GetJWTExpiration())
+	claims := &config.AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: user.ID, ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt: jwt.NewNumericDate(now), NotBefore: jwt.NewNumericDate(now),
+			Issuer: config.TokenIssuer, ID: sessionID,
+		},
+		Role:               user.Role,
+		MustChangePassword: user.MustChangePassword,
+	}
+
+	// In single-session mode this token becomes the user's only valid one.
+	// Unlike the revocation check this fails closed: a login that cannot
+	// displace the old session must not leave two valid. The record outlives
+	// the access token so the session's refresh token stays usable.
+	if s.config.SingleSession {
+		ttl := max(s.config.GetJWTExpiration(), s.config.GetRefreshExpiration())
+		if err := s.sessions.SetActiveSession(ctx, user.ID, sessionID, ttl); err != nil {
+			return nil, fmt.Errorf("%w: %v", core.ErrSessionUnavailable, err)
+		}
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(s.config.App_Secret))
+	tokenString, err := jwtkeys.Sign(claims, s.config.App_Secret)
 	if err != nil {
 		return nil, err
 	}
 
-	return &models.LoginResponse{
+	resp := &models.LoginResponse{
 		Token: tokenString, ExpiresAt: expirationTime.Unix(),
-		User: models.UserSummary{ID: user.ID, Username: user.Username, Email: user.Email},
-	}, nil
+		User:               models.UserSummary{ID: user.ID, Username: user.Username, Email: user.Email, Role: user.Role},
+		MustChangePassword: user.MustChangePassword,
+		SessionMode:        s.config.SessionMode(),
+	}
+
+	if s.sessions != nil {
+		tokenID, err := s.random.Token()
+		if err != nil {
+			return nil, err
+		}
+		if err := s.sessions.SaveRefreshToken(ctx, user.ID, hashToken(tokenID), sessionID, s.config.GetRefreshExpiration()); err != nil {
+			return nil, fmt.Errorf("%w: %v", core.ErrSessionUnavailable, err)
+		}
+		resp.RefreshToken = user.ID + "." + tokenID
+	}
+	return resp, nil
 }
 
 // --- User Management Methods ---
@@ -90,8 +178,25 @@ func (s *UserService) UpdateProfile(ctx context.Context, userID string, req mode
 	}
 
 	// Apply updates
-	if req.Username != nil {
-		user.Username = *req.Username
+	if req.Username != nil && username.Display(*req.Username) != user.Username {
+		name := username.Display(*req.Username)
+		if username.Normalize(name) != username.Normalize(user.Username) {
+			existing, err := s.repo.GetByEmailOrUsername(ctx, "", name)
+			if err != nil {
+				return err
+			}
+			if existing != nil && existing.ID != userID {
+				return core.ErrUsernameTaken
+			}
+		}
+		lookalike, err := s.usernameLookalikeTaken(ctx, name, userID)
+		if err != nil {
+			return err
+		}
+		if lookalike {
+			return core.ErrUsernameTaken
+		}
+		user.Username = name
 	}
 	if req.Email != nil {
 		user.Email = *req.Email
@@ -100,32 +205,102 @@ func (s *UserService) UpdateProfile(ctx context.Context, userID string, req mode
 	return s.repo.Update(ctx, user)
 }
 
-func (s *UserService) ChangePassword(ctx context.Context, userID string, req models.ChangePasswordRequest) error {
+// usernameLookalikeTaken applies the confusable policy: when it is on, a name
+// whose skeleton matches another account's is treated as taken
+func (s *UserService) usernameLookalikeTaken(ctx context.Context, name, excludeID string) (bool, error) {
+	if !s.config.UsernameConfusables {
+		return false, nil
+	}
+	return s.repo.UsernameSkeletonTaken(ctx, username.Skeleton(name), excludeID)
+}
+
+// ChangePassword updates the password and, unless the request opts out,
+// signs the user out everywhere else. The caller's own session survives: it
+// gets a fresh token, issued after the revocation, which is returned. When
+// other sessions are kept the current token stays valid and nil is returned.
+func (s *UserService) ChangePassword(ctx context.Context, userID string, req models.ChangePasswordRequest) (*models.LoginResponse, error) {
 	user, err := s.repo.GetByID(ctx, userID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Verify old password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
-		return errors.New("current password is incorrect")
+	if err := s.passwords.Compare(ctx, user.PasswordHash, req.CurrentPassword); err != nil {
+		if errors.Is(err, core.ErrHasherBusy) {
+			return nil, err
+		}
+		return nil, core.ErrPasswordIncorrect
+	}
+
+	if err := checkPasswordHistory(ctx, s.repo, s.passwords, user, req.NewPassword, s.config.PasswordHistoryCount); err != nil {
+		return nil, err
 	}
 
 	// Hash new password
-	newHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	newHash, err := s.passwords.Hash(ctx, req.NewPassword)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return s.repo.UpdatePassword(ctx, userID, string(newHash))
+	if err := s.repo.UpdatePassword(ctx, userID, newHash, s.config.PasswordHistoryCount); err != nil {
+		return nil, err
+	}
+
+	// A forced change always signs out everywhere: the caller's token still
+	// says the password must change, and whoever else knew the initial
+	// password should not keep a session
+	if !req.LogoutOthers() && !user.MustChangePassword {
+		return nil, nil
+	}
+	if _, err := s.sessions.BumpUserEpoch(ctx, userID); err != nil {
+		return nil, fmt.Errorf("%w: %v", core.ErrSessionUnavailable, err)
+	}
+	user.MustChangePassword = false
+	return s.issueToken(ctx, user, uuid.New().String())
+}
+
+// checkPasswordHistory returns core.ErrPasswordReused when password matches
+// user's current password or any of the last historySize recorded. The
+// current hash is checked separately because passwords set before the history
+// was enabled are not in it.
+func checkPasswordHistory(ctx context.Context, repo core.UserRepository, passwords core.PasswordHasher, user *models.User, password string, historySize int) error {
+	if historySize <= 0 {
+		return nil
+	}
+	hashes, err := repo.RecentPasswordHashes(ctx, user.ID, historySize)
+	if err != nil {
+		return err
+	}
+	if user.PasswordHash != "" && !slices.Contains(hashes, user.PasswordHash) {
+		hashes = append(hashes, user.PasswordHash)
+	}
+	for _, hash := range hashes {
+		err := passwords.Compare(ctx, hash, password)
+		if err == nil {
+			return core.ErrPasswordReused
+		}
+		if errors.Is(err, core.ErrHasherBusy) {
+			return err
+		}
+	}
+	return nil
 }
 
-func (s *UserService) GetUsers(ctx context.Context, page, limit int) ([]models.User, *models.PaginationMetadata, error) {
+// GetUsers returns one page of active users. A missing or non-positive limit
+// uses the default; a limit above the cap is clamped to the cap and reported
+// back through the metadata.
+func (s *UserService) GetUsers(ctx context.Context, page, limit int) ([]models.UserListItem, *models.PaginationMetadata, error) {
 	if page < 1 {
 		page = 1
 	}
-	if limit < 1 || limit > 100 {
-		limit = 10
+	requestedLimit := limit
+	limitCapped := false
+	switch {
+	case limit < 1:
+		limit = DefaultUsersPageSize
+	case limit > MaxUsersPageSize:
+		limit = MaxUsersPageSize
+		limitCapped = true
 	}
 	offset := (page - 1) * limit
 
@@ -142,13 +317,320 @@ func (s *UserService) GetUsers(ctx context.Context, page, limit int) ([]models.U
 	totalPages := (totalCount + limit - 1) / limit
 
 	meta := &models.PaginationMetadata{
-		Page:       page,
-		Limit:      limit,
-		TotalCount: totalCount,
-		TotalPages: totalPages,
-		HasNext:    page < totalPages,
-		HasPrev:    page > 1,
+		Page:        page,
+		Limit:       limit,
+		MaxLimit:    MaxUsersPageSize,
+		LimitCapped: limitCapped,
+		TotalCount:  totalCount,
+		TotalPages:  totalPages,
+		HasNext:     page < totalPages,
+		HasPrev:     page > 1,
+	}
+	if limitCapped {
+		meta.RequestedLimit = requestedLimit
 	}
 
 	return users, meta, nil
 }
+
+// UsersETag returns a weak ETag for the active users collection. It changes
+// whenever a user is added, removed or updated.
+func (s *UserService) UsersETag(ctx context.Context) (string, error) {
+	count, updatedAt, err := s.repo.CollectionVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`W/"users-%d-%d"`, count, updatedAt.UnixNano()), nil
+}
+
+// --- Preferences Methods ---
+
+// GetPreferences returns the stored preferences, or the defaults if none were saved
+func (s *UserService) GetPreferences(ctx context.Context, userID string) (*models.PreferencesResponse, error) {
+	prefs, err := s.repo.GetPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if prefs == nil {
+		prefs = models.DefaultPreferences(userID)
+	}
+	return preferencesResponse(userID, prefs), nil
+}
+
+// UpdatePreferences replaces the user's preferences. Email cannot be turned
+// on while the server has no SMTP relay to send it with; leaving it on, as
+// the defaults have it, is still accepted.
+func (s *UserService) UpdatePreferences(ctx context.Context, userID string, req models.UpdatePreferencesRequest) (*models.PreferencesResponse, error) {
+	if req.EmailEnabled && !s.config.EmailConfigured() {
+		current, err := s.repo.GetPreferences(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			current = models.DefaultPreferences(userID)
+		}
+		if !current.EmailEnabled {
+			return nil, core.ErrChannelUnavailable
+		}
+	}
+	prefs := &models.UserPreferences{
+		UserID:       userID,
+		EmailEnabled: req.EmailEnabled,
+		Frequency:    req.Frequency,
+	}
+	if err := s.repo.UpsertPreferences(ctx, prefs); err != nil {
+		return nil, err
+	}
+	return preferencesResponse(userID, prefs), nil
+}
+
+// NotificationChannels lists every channel the user could be notified on.
+// Availability comes from the server's configuration; a channel that is not
+// available is reported disabled, whatever the stored preference says.
+func (s *UserService) NotificationChannels(ctx context.Context, userID string) ([]models.NotificationChannel, error) {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	prefs, err := s.repo.GetPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if prefs == nil {
+		prefs = models.DefaultPreferences(userID)
+	}
+
+	email := models.NotificationChannel{
+		Name:      models.ChannelEmail,
+		Available: s.config.EmailConfigured(),
+		Address:   notification.Recipient(user.Email, prefs),
+		Verified:  user.EmailVerified,
+	}
+	email.Enabled = email.Available && prefs.EmailEnabled
+	if email.Address != user.Email {
+		// Recipient only picks the notification email once it is verified
+		email.Verified = true
+	}
+	return []models.NotificationChannel{email}, nil
+}
+
+func preferencesResponse(userID string, prefs *models.UserPreferences) *models.PreferencesResponse {
+	return &models.PreferencesResponse{
+		UserID:                    userID,
+		EmailEnabled:              prefs.EmailEnabled,
+		Frequency:                 prefs.Frequency,
+		UpdatedAt:                 prefs.UpdatedAt,
+		NotificationEmail:         prefs.NotificationEmail,
+		NotificationEmailVerified: prefs.NotificationEmailVerified,
+	}
+}
+
+// RequestNotificationEmail records email as the user's pending notification
+// address and returns the plaintext verification token to send to it.
+func (s *UserService) RequestNotificationEmail(ctx context.Context, userID, email string) (string, error) {
+	token, err := s.random.Token()
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(notificationEmailTokenTTL)
+	if err := s.repo.SetPendingNotificationEmail(ctx, userID, email, hashToken(token), expiresAt); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *UserService) VerifyNotificationEmail(ctx context.Context, token string) error {
+	if token == "" {
+		return core.ErrVerificationTokenInvalid
+	}
+	ok, err := s.repo.VerifyNotificationEmail(ctx, hashToken(token))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return core.ErrVerificationTokenInvalid
+	}
+	return nil
+}
+
+// RecoveryEmail returns the user's recovery email, verified or not, or nil
+// if none was ever set
+func (s *UserService) RecoveryEmail(ctx context.Context, userID string) (*models.RecoveryContact, error) {
+	return s.repo.GetRecoveryContact(ctx, userID, models.RecoveryContactEmail)
+}
+
+// RequestRecoveryEmail replaces the user's recovery email with an unverified
+// email and returns the plaintext verification token to send to it. The
+// recovery email can take over the account through a password reset, so
+// changing it takes the current password, as ChangePassword does.
+func (s *UserService) RequestRecoveryEmail(ctx context.Context, userID, currentPassword, email string) (string, error) {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if err := s.passwords.Compare(ctx, user.PasswordHash, currentPassword); err != nil {
+		if errors.Is(err, core.ErrHasherBusy) {
+			return "", err
+		}
+		return "", core.ErrPasswordIncorrect
+	}
+
+	token, err := s.random.Token()
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(recoveryEmailTokenTTL)
+	if err := s.repo.SetPendingRecoveryContact(ctx, userID, models.RecoveryContactEmail, email, hashToken(token), expiresAt); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *UserService) VerifyRecoveryEmail(ctx context.Context, token string) error {
+	if token == "" {
+		return core.ErrVerificationTokenInvalid
+	}
+	ok, err := s.repo.VerifyRecoveryContact(ctx, models.RecoveryContactEmail, hashToken(token))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return core.ErrVerificationTokenInvalid
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// --- Session Methods ---
+
+// Refresh rotates a refresh token: the presented token is consumed and a new
+// access and refresh token are issued for the same session. A consumed token
+// is gone from the store, so a stolen copy fails once either side has used
+// it. Unlike the access token checks, the revocation checks here fail closed.
+// A token that is stored but belongs to a revoked session, user or login
+// fails with ErrSessionRevoked; one that is not stored, perhaps because a
+// concurrent refresh has just rotated it, fails with ErrRefreshTokenInvalid.
+func (s *UserService) Refresh(ctx context.Context, refreshToken string) (*models.LoginResponse, error) {
+	userID, tokenID, ok := strings.Cut(refreshToken, ".")
+	if !ok || userID == "" || tokenID == "" || s.sessions == nil {
+		return nil, core.ErrRefreshTokenInvalid
+	}
+
+	sessionID, issuedAt, err := s.sessions.ConsumeRefreshToken(ctx, userID, hashToken(tokenID))
+	if errors.Is(err, core.ErrRefreshTokenInvalid) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", core.ErrSessionUnavailable, err)
+	}
+
+	epoch, err := s.sessions.EffectiveEpoch(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", core.ErrSessionUnavailable, err)
+	}
+	if epoch > 0 && issuedAt < epoch {
+		return nil, core.ErrSessionRevoked
+	}
+	if s.config.SingleSession {
+		active, err := s.sessions.ActiveSession(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", core.ErrSessionUnavailable, err)
+		}
+		if active != "" && active != sessionID {
+			return nil, core.ErrSessionRevoked
+		}
+	}
+
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil || user == nil || !user.IsActive {
+		return nil, core.ErrSessionRevoked
+	}
+	return s.issueToken(ctx, user, sessionID)
+}
+
+// RevokeRefreshToken discards refreshToken. Unknown tokens are not an error,
+// so logging out twice is harmless.
+func (s *UserService) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	userID, tokenID, ok := strings.Cut(refreshToken, ".")
+	if !ok || s.sessions == nil {
+		return nil
+	}
+	_, _, err := s.sessions.ConsumeRefreshToken(ctx, userID, hashToken(tokenID))
+	if errors.Is(err, core.ErrRefreshTokenInvalid) {
+		return nil
+	}
+	return err
+}
+
+// Logout revokes accessToken by its ID until it would have expired anyway.
+// Only tokens this service signed are accepted, so a forged token cannot be
+// used to revoke someone else's session.
+func (s *UserService) Logout(ctx context.Context, accessToken string) error {
+	if s.sessions == nil || accessToken == "" {
+		return nil
+	}
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwtkeys.Parse(accessToken, claims, s.config.App_Secret, jwt.WithLeeway(s.config.GetJWTClockSkew()))
+	if err != nil || claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	// The leeway keeps it rejected for as long as the middleware would accept it
+	ttl := time.Until(claims.ExpiresAt.Time) + s.config.GetJWTClockSkew()
+	return s.sessions.RevokeToken(ctx, claims.ID, ttl)
+}
+
+// LogoutAllDevices moves userID's token epoch to now, which rejects every
+// access and refresh token issued to the user before this call.
+func (s *UserService) LogoutAllDevices(ctx context.Context, userID string) error {
+	if s.sessions == nil {
+		return core.ErrSessionUnavailable
+	}
+	if _, err := s.sessions.BumpUserEpoch(ctx, userID); err != nil {
+		return fmt.Errorf("%w: %v", core.ErrSessionUnavailable, err)
+	}
+	return nil
+}
+
+// RevokeAllSessions invalidates every token issued before now, for all users.
+// Tokens are compared at one-second granularity (the JWT "iat" precision).
+func (s *UserService) RevokeAllSessions(ctx context.Context) (time.Time, error) {
+	epoch, err := s.sessions.BumpGlobalEpoch(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(epoch, 0).UTC(), nil
+}
+
+// --- Admin Methods ---
+
+// ExportUsers streams every user, active or not, to fn
+func (s *UserService) ExportUsers(ctx context.Context, fn func(models.UserExportRow) error) error {
+	return s.repo.StreamAll(ctx, fn)
+}
+
+// MergeUsers folds the source account into the target, audit history
+// included. The data move is transactional; afterwards the source's outstanding tokens are revoked.
+// A revocation failure is reported on the result rather than as an error,
+// since the merge itself has already committed and the source can no longer
+// log in.
+func (s *UserService) MergeUsers(ctx context.Context, req models.MergeUsersRequest) (*models.MergeResult, error) {
+	if req.SourceUserID == req.TargetUserID {
+		return nil, core.ErrMergeSameUser
+	}
+
+	result, err := s.repo.Merge(ctx, req.SourceUserID, req.TargetUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.sessions.BumpUserEpoch(ctx, req.SourceUserID); err == nil {
+		result.SessionsRevoked = true
+	}
+	return result, nil
+}
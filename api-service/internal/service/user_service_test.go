@@ -2,20 +2,25 @@ package service
 
 import (
 	"azlo-goboiler/internal/config"
+	"azlo-goboiler/internal/core"
 	"azlo-goboiler/internal/mocks"
 	"azlo-goboiler/internal/models"
+	"azlo-goboiler/internal/username"
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestRegister(t *testing.T) {
 	// 1. Setup
 	mockRepo := new(mocks.MockUserRepository)
 	cfg := &config.Config{App_Secret: "test-secret"}
-	service := NewUserService(mockRepo, cfg)
+	service := NewUserService(mockRepo, new(mocks.MockSessionStore), cfg, nil, nil)
 	ctx := context.Background()
 
 	t.Run("Success", func(t *testing.T) {
@@ -64,9 +69,414 @@ func TestRegister(t *testing.T) {
 		// Assert
 		assert.Error(t, err)
 		assert.Nil(t, resp)
-		assert.Equal(t, "user with this email or username already exists", err.Error())
+		assert.ErrorIs(t, err, core.ErrUserExists)
 
 		// Ensure Create was NEVER called
 		mockRepo.AssertNotCalled(t, "Create")
 	})
 }
+
+func TestGetUsersLimit(t *testing.T) {
+	cfg := &config.Config{App_Secret: "test-secret"}
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		limit         int
+		wantLimit     int
+		wantCapped    bool
+		wantRequested int
+	}{
+		{name: "Missing_UsesDefault", limit: 0, wantLimit: DefaultUsersPageSize},
+		{name: "Negative_UsesDefault", limit: -5, wantLimit: DefaultUsersPageSize},
+		{name: "WithinRange_Honored", limit: 50, wantLimit: 50},
+		{name: "AtCap_Honored", limit: MaxUsersPageSize, wantLimit: MaxUsersPageSize},
+		{name: "AboveCap_Clamped", limit: 5000, wantLimit: MaxUsersPageSize, wantCapped: true, wantRequested: 5000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(mocks.MockUserRepository)
+			mockRepo.On("List", ctx, tt.wantLimit, 0).Return([]models.UserListItem{}, nil).Once()
+			mockRepo.On("Count", ctx).Return(250, nil).Once()
+			service := NewUserService(mockRepo, new(mocks.MockSessionStore), cfg, nil, nil)
+
+			_, meta, err := service.GetUsers(ctx, 1, tt.limit)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantLimit, meta.Limit)
+			assert.Equal(t, MaxUsersPageSize, meta.MaxLimit)
+			assert.Equal(t, tt.wantCapped, meta.LimitCapped)
+			assert.Equal(t, tt.wantRequested, meta.RequestedLimit)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestNotificationEmailVerification(t *testing.T) {
+	ctx := context.Background()
+	repo := new(mocks.MockUserRepository)
+	svc := NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+
+	var storedHash string
+	repo.On("SetPendingNotificationEmail", ctx, "user-1", "alerts@example.com", mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).
+		Run(func(args mock.Arguments) { storedHash = args.String(3) }).
+		Return(nil).Once()
+
+	token, err := svc.RequestNotificationEmail(ctx, "user-1", "alerts@example.com")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+	assert.NotEqual(t, token, storedHash, "only the token hash is stored")
+
+	repo.On("VerifyNotificationEmail", ctx, storedHash).Return(true, nil).Once()
+	assert.NoError(t, svc.VerifyNotificationEmail(ctx, token))
+
+	repo.On("VerifyNotificationEmail", ctx, hashToken("bogus")).Return(false, nil).Once()
+	assert.ErrorIs(t, svc.VerifyNotificationEmail(ctx, "bogus"), core.ErrVerificationTokenInvalid)
+}
+
+func TestRecoveryEmailVerification(t *testing.T) {
+	ctx := context.Background()
+	repo := new(mocks.MockUserRepository)
+	svc := NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("Current123!"), bcrypt.MinCost)
+	require.NoError(t, err)
+	repo.On("GetByID", ctx, "user-1").Return(&models.User{ID: "user-1", PasswordHash: string(hash)}, nil)
+
+	// The recovery email can reset the password, so setting it takes the current one
+	_, err = svc.RequestRecoveryEmail(ctx, "user-1", "Wrong123!", "backup@example.net")
+	assert.ErrorIs(t, err, core.ErrPasswordIncorrect)
+	repo.AssertNotCalled(t, "SetPendingRecoveryContact", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	var storedHash string
+	repo.On("SetPendingRecoveryContact", ctx, "user-1", models.RecoveryContactEmail, "backup@example.net", mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).
+		Run(func(args mock.Arguments) { storedHash = args.String(4) }).
+		Return(nil).Once()
+
+	token, err := svc.RequestRecoveryEmail(ctx, "user-1", "Current123!", "backup@example.net")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+	assert.NotEqual(t, token, storedHash, "only the token hash is stored")
+
+	repo.On("VerifyRecoveryContact", ctx, models.RecoveryContactEmail, storedHash).Return(true, nil).Once()
+	assert.NoError(t, svc.VerifyRecoveryEmail(ctx, token))
+
+	repo.On("VerifyRecoveryContact", ctx, models.RecoveryContactEmail, hashToken("bogus")).Return(false, nil).Once()
+	assert.ErrorIs(t, svc.VerifyRecoveryEmail(ctx, "bogus"), core.ErrVerificationTokenInvalid)
+}
+
+func TestMergeUsers(t *testing.T) {
+	ctx := context.Background()
+	req := models.MergeUsersRequest{SourceUserID: "source", TargetUserID: "target"}
+
+	t.Run("RevokesSourceSessions", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+		sessions := new(mocks.MockSessionStore)
+		repo.On("Merge", ctx, "source", "target").
+			Return(&models.MergeResult{SourceUserID: "source", TargetUserID: "target", SourceDeactivated: true}, nil)
+		sessions.On("BumpUserEpoch", ctx, "source").Return(int64(1700000000), nil)
+
+		result, err := NewUserService(repo, sessions, &config.Config{}, nil, nil).MergeUsers(ctx, req)
+		assert.NoError(t, err)
+		assert.True(t, result.SourceDeactivated)
+		assert.True(t, result.SessionsRevoked)
+		sessions.AssertExpectations(t)
+	})
+
+	t.Run("FailedMergeLeavesSessions", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+		sessions := new(mocks.MockSessionStore)
+		repo.On("Merge", ctx, "source", "target").Return(nil, errors.New("move login history: connection reset"))
+
+		_, err := NewUserService(repo, sessions, &config.Config{}, nil, nil).MergeUsers(ctx, req)
+		assert.Error(t, err)
+		sessions.AssertNotCalled(t, "BumpUserEpoch", mock.Anything, mock.Anything)
+	})
+
+	t.Run("SameUser", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+		_, err := NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil).
+			MergeUsers(ctx, models.MergeUsersRequest{SourceUserID: "same", TargetUserID: "same"})
+		assert.ErrorIs(t, err, core.ErrMergeSameUser)
+		repo.AssertNotCalled(t, "Merge", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestUsernameLookalikes(t *testing.T) {
+	ctx := context.Background()
+	latin := "paypal"
+	cyrillic := "p\u0430yp\u0430l" // U+0430 CYRILLIC SMALL LETTER A
+	require.NotEqual(t, latin, cyrillic)
+
+	register := func(enabled bool, skeletonTaken bool) (*mocks.MockUserRepository, error) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByEmailOrUsername", ctx, "new@example.com", cyrillic).Return(nil, nil)
+		repo.On("UsernameSkeletonTaken", ctx, username.Skeleton(latin), "").Return(skeletonTaken, nil)
+		repo.On("Create", ctx, mock.AnythingOfType("*models.User")).Return(nil)
+
+		svc := NewUserService(repo, new(mocks.MockSessionStore), &config.Config{UsernameConfusables: enabled}, nil, nil)
+		_, err := svc.Register(ctx, models.RegisterRequest{Username: cyrillic, Email: "new@example.com", Password: "Password123!"})
+		return repo, err
+	}
+
+	t.Run("RegisterCollidesWhenEnabled", func(t *testing.T) {
+		repo, err := register(true, true)
+		assert.ErrorIs(t, err, core.ErrUserExists)
+		repo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+	})
+
+	t.Run("RegisterAllowedWhenDisabled", func(t *testing.T) {
+		repo, err := register(false, true)
+		assert.NoError(t, err)
+		repo.AssertNotCalled(t, "UsernameSkeletonTaken", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("RegisterStoresNFC", func(t *testing.T) {
+		decomposed := "jose\u0301" // e + COMBINING ACUTE ACCENT
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByEmailOrUsername", ctx, "jose@example.com", "josé").Return(nil, nil)
+		repo.On("Create", ctx, mock.MatchedBy(func(u *models.User) bool { return u.Username == "josé" })).Return(nil)
+
+		svc := NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+		_, err := svc.Register(ctx, models.RegisterRequest{Username: decomposed, Email: "jose@example.com", Password: "Password123!"})
+		assert.NoError(t, err)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("RenameCollidesWhenEnabled", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByID", ctx, "user-2").Return(&models.User{ID: "user-2", Username: "someone"}, nil)
+		repo.On("GetByEmailOrUsername", ctx, "", cyrillic).Return(nil, nil)
+		repo.On("UsernameSkeletonTaken", ctx, username.Skeleton(latin), "user-2").Return(true, nil)
+
+		svc := NewUserService(repo, new(mocks.MockSessionStore), &config.Config{UsernameConfusables: true}, nil, nil)
+		err := svc.UpdateProfile(ctx, "user-2", models.UpdateUserRequest{Username: &cyrillic})
+		assert.ErrorIs(t, err, core.ErrUsernameTaken)
+		repo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+	})
+
+	t.Run("RenameCollidesOnCase", func(t *testing.T) {
+		upper := "PayPal"
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByID", ctx, "user-2").Return(&models.User{ID: "user-2", Username: "someone"}, nil)
+		repo.On("GetByEmailOrUsername", ctx, "", upper).Return(&models.User{ID: "user-1", Username: latin}, nil)
+
+		svc := NewUserService(repo, new(mocks.MockSessionStore), &config.Config{}, nil, nil)
+		err := svc.UpdateProfile(ctx, "user-2", models.UpdateUserRequest{Username: &upper})
+		assert.ErrorIs(t, err, core.ErrUsernameTaken)
+	})
+}
+
+func TestLoginRecordsFailedAttempt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("Password123!"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	repo := new(mocks.MockUserRepository)
+	repo.On("GetByEmailOrUsername", mock.Anything, "alice", "alice").
+		Return(&models.User{ID: "user-1", Username: "alice", PasswordHash: string(hash)}, nil)
+	sessions := new(mocks.MockSessionStore)
+	sessions.On("RecordFailedLogin", mock.Anything, "user-1", mock.AnythingOfType("time.Time")).Return(nil).Once()
+
+	service := NewUserService(repo, sessions, &config.Config{App_Secret: "test-secret"}, nil, nil)
+	_, err = service.Login(context.Background(), models.LoginRequest{Username: "alice", Password: "wrong-password"})
+
+	assert.EqualError(t, err, "invalid credentials")
+	sessions.AssertExpectations(t)
+}
+
+func TestLoginWithIdentity(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{App_Secret: "test-secret"}
+	profile := models.OAuthProfile{
+		Provider: models.ProviderGoogle, Subject: "g-123", Email: "alice@example.com", EmailVerified: true, Name: "Alice Smith",
+	}
+
+	t.Run("LinkedIdentity", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetUserIDByIdentity", ctx, models.ProviderGoogle, "g-123").Return("user-1", nil)
+		repo.On("GetByID", ctx, "user-1").Return(&models.User{ID: "user-1", Username: "alice"}, nil)
+		repo.On("UpdateLastLogin", ctx, "user-1").Return(nil)
+
+		result, err := NewUserService(repo, nil, cfg, nil, nil).LoginWithIdentity(ctx, profile)
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", result.User.ID)
+		assert.NotEmpty(t, result.Token)
+		assert.False(t, result.Linked)
+		repo.AssertNotCalled(t, "LinkIdentity", mock.Anything, mock.Anything)
+	})
+
+	t.Run("LinksVerifiedAccount", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetUserIDByIdentity", ctx, models.ProviderGoogle, "g-123").Return("", nil)
+		repo.On("GetByEmailOrUsername", ctx, "alice@example.com", "").
+			Return(&models.User{ID: "user-1", Username: "alice", EmailVerified: true}, nil)
+		repo.On("LinkIdentity", ctx, mock.MatchedBy(func(i *models.UserIdentity) bool {
+			return i.UserID == "user-1" && i.ProviderUserID == "g-123"
+		})).Return(nil)
+		repo.On("UpdateLastLogin", ctx, "user-1").Return(nil)
+
+		result, err := NewUserService(repo, nil, cfg, nil, nil).LoginWithIdentity(ctx, profile)
+		require.NoError(t, err)
+		assert.True(t, result.Linked)
+		assert.False(t, result.Created)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("RefusesUnverifiedAccount", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetUserIDByIdentity", ctx, models.ProviderGoogle, "g-123").Return("", nil)
+		repo.On("GetByEmailOrUsername", ctx, "alice@example.com", "").
+			Return(&models.User{ID: "user-1", Username: "alice"}, nil)
+
+		_, err := NewUserService(repo, nil, cfg, nil, nil).LoginWithIdentity(ctx, profile)
+		assert.ErrorIs(t, err, core.ErrIdentityLinkUnverified)
+		repo.AssertNotCalled(t, "LinkIdentity", mock.Anything, mock.Anything)
+	})
+
+	t.Run("RefusesUnverifiedProviderEmail", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetUserIDByIdentity", ctx, models.ProviderGoogle, "g-123").Return("", nil)
+
+		unverified := profile
+		unverified.EmailVerified = false
+		_, err := NewUserService(repo, nil, cfg, nil, nil).LoginWithIdentity(ctx, unverified)
+		assert.ErrorIs(t, err, core.ErrIdentityEmailUnverified)
+		repo.AssertNotCalled(t, "GetByEmailOrUsername", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("CreatesUser", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetUserIDByIdentity", ctx, models.ProviderGoogle, "g-123").Return("", nil)
+		repo.On("GetByEmailOrUsername", ctx, "alice@example.com", "").Return(nil, nil)
+		// The plain name is taken, so a numbered one is used
+		repo.On("GetByEmailOrUsername", ctx, "", "AliceSmith").Return(&models.User{ID: "user-9"}, nil)
+		repo.On("GetByEmailOrUsername", ctx, "", mock.AnythingOfType("string")).Return(nil, nil)
+		repo.On("Create", ctx, mock.MatchedBy(func(u *models.User) bool {
+			return u.Email == "alice@example.com" && u.PasswordHash == "" &&
+				len(u.Username) > len("AliceSmith") && u.Username[:len("AliceSmith")] == "AliceSmith"
+		})).Return(nil)
+		repo.On("SetEmailVerified", ctx, mock.AnythingOfType("string")).Return(nil)
+		repo.On("LinkIdentity", ctx, mock.AnythingOfType("*models.UserIdentity")).Return(nil)
+		repo.On("UpdateLastLogin", ctx, mock.AnythingOfType("string")).Return(nil)
+
+		result, err := NewUserService(repo, nil, cfg, nil, nil).LoginWithIdentity(ctx, profile)
+		require.NoError(t, err)
+		assert.True(t, result.Created)
+		assert.True(t, result.Linked)
+		assert.True(t, username.Valid(result.User.Username))
+		repo.AssertExpectations(t)
+	})
+}
+
+func TestTrustedUser(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Linked", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetUserIDByIdentity", ctx, models.ProviderTrustedHeader, "alice").Return("user-1", nil)
+		repo.On("GetByID", ctx, "user-1").Return(&models.User{ID: "user-1", Username: "alice"}, nil)
+
+		user, err := NewUserService(repo, nil, &config.Config{}, nil, nil).TrustedUser(ctx, "alice", "")
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", user.ID)
+		repo.AssertNotCalled(t, "LinkIdentity", mock.Anything, mock.Anything)
+	})
+
+	t.Run("LinksExistingAccount", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetUserIDByIdentity", ctx, models.ProviderTrustedHeader, "alice").Return("", nil)
+		repo.On("GetByEmailOrUsername", ctx, "alice@example.com", "alice").Return(&models.User{ID: "user-1"}, nil)
+		repo.On("LinkIdentity", ctx, mock.MatchedBy(func(i *models.UserIdentity) bool {
+			return i.Provider == models.ProviderTrustedHeader && i.ProviderUserID == "alice" && i.UserID == "user-1"
+		})).Return(nil)
+
+		user, err := NewUserService(repo, nil, &config.Config{}, nil, nil).TrustedUser(ctx, "alice", "alice@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", user.ID)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("UnknownWithoutCreate", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetUserIDByIdentity", ctx, models.ProviderTrustedHeader, "bob@example.com").Return("", nil)
+		repo.On("GetByEmailOrUsername", ctx, "bob@example.com", "bob@example.com").Return(nil, nil)
+
+		_, err := NewUserService(repo, nil, &config.Config{}, nil, nil).TrustedUser(ctx, "bob@example.com", "")
+		assert.ErrorIs(t, err, core.ErrTrustedUserUnknown)
+		repo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+	})
+
+	t.Run("CreatesUser", func(t *testing.T) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetUserIDByIdentity", ctx, models.ProviderTrustedHeader, "bob@example.com").Return("", nil)
+		repo.On("GetByEmailOrUsername", ctx, "bob@example.com", "bob@example.com").Return(nil, nil)
+		repo.On("GetByEmailOrUsername", ctx, "", mock.AnythingOfType("string")).Return(nil, nil)
+		repo.On("Create", ctx, mock.MatchedBy(func(u *models.User) bool {
+			return u.Email == "bob@example.com" && u.PasswordHash == ""
+		})).Return(nil)
+		repo.On("SetEmailVerified", ctx, mock.AnythingOfType("string")).Return(nil)
+		repo.On("LinkIdentity", ctx, mock.AnythingOfType("*models.UserIdentity")).Return(nil)
+
+		cfg := &config.Config{TrustedAuthCreateUsers: true}
+		user, err := NewUserService(repo, nil, cfg, nil, nil).TrustedUser(ctx, "bob@example.com", "")
+		require.NoError(t, err)
+		assert.True(t, username.Valid(user.Username))
+		repo.AssertExpectations(t)
+
+		// Without an email there is nothing to create the account with
+		repo = new(mocks.MockUserRepository)
+		repo.On("GetUserIDByIdentity", ctx, models.ProviderTrustedHeader, "carol").Return("", nil)
+		repo.On("GetByEmailOrUsername", ctx, "", "carol").Return(nil, nil)
+		_, err = NewUserService(repo, nil, cfg, nil, nil).TrustedUser(ctx, "carol", "")
+		assert.ErrorIs(t, err, core.ErrTrustedUserUnknown)
+	})
+}
+
+func TestChangePasswordHistory(t *testing.T) {
+	ctx := context.Background()
+	bcryptHash := func(password string) string {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		require.NoError(t, err)
+		return string(hash)
+	}
+	current, previous := bcryptHash("Current123!"), bcryptHash("Previous123!")
+	keep := false
+
+	change := func(historySize int, newPassword string) (*mocks.MockUserRepository, error) {
+		repo := new(mocks.MockUserRepository)
+		repo.On("GetByID", ctx, "user-1").Return(&models.User{ID: "user-1", PasswordHash: current}, nil)
+		repo.On("RecentPasswordHashes", ctx, "user-1", historySize).Return([]string{current, previous}, nil)
+		repo.On("UpdatePassword", ctx, "user-1", mock.AnythingOfType("string"), historySize).Return(nil)
+
+		cfg := &config.Config{App_Secret: "test-secret", PasswordHistoryCount: historySize}
+		service := NewUserService(repo, new(mocks.MockSessionStore), cfg, nil, nil)
+		_, err := service.ChangePassword(ctx, "user-1", models.ChangePasswordRequest{
+			CurrentPassword:     "Current123!",
+			NewPassword:         newPassword,
+			LogoutOtherSessions: &keep,
+		})
+		return repo, err
+	}
+
+	t.Run("RecentPasswordRejected", func(t *testing.T) {
+		for _, password := range []string{"Previous123!", "Current123!"} {
+			repo, err := change(3, password)
+			assert.ErrorIs(t, err, core.ErrPasswordReused, password)
+			repo.AssertNotCalled(t, "UpdatePassword", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		}
+	})
+
+	t.Run("NewPasswordAccepted", func(t *testing.T) {
+		repo, err := change(3, "Brand-New123!")
+		require.NoError(t, err)
+		repo.AssertCalled(t, "UpdatePassword", ctx, "user-1", mock.AnythingOfType("string"), 3)
+	})
+
+	t.Run("DisabledAllowsReuse", func(t *testing.T) {
+		repo, err := change(0, "Current123!")
+		require.NoError(t, err)
+		repo.AssertNotCalled(t, "RecentPasswordHashes", mock.Anything, mock.Anything, mock.Anything)
+		repo.AssertCalled(t, "UpdatePassword", ctx, "user-1", mock.AnythingOfType("string"), 0)
+	})
+}
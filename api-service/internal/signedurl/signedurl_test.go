@@ -0,0 +1,72 @@
+package signedurl
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "a-secret-that-is-definitely-32-chars-long"
+
+func parse(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	return u
+}
+
+func TestSignedURL(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	signer := New(testSecret).WithClock(func() time.Time { return now })
+
+	link, expiresAt, err := signer.GenerateSignedURL("/files/exports/report.csv?format=csv", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour), expiresAt)
+
+	t.Run("Valid", func(t *testing.T) {
+		assert.NoError(t, signer.Verify(parse(t, link)))
+		fresh := New(testSecret).WithClock(func() time.Time { return now })
+		assert.NoError(t, fresh.Verify(parse(t, link)), "another signer from the same secret accepts it")
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		later := signer.WithClock(func() time.Time { return now.Add(time.Hour + time.Second) })
+		assert.ErrorIs(t, later.Verify(parse(t, link)), ErrExpired)
+	})
+
+	t.Run("TamperedPath", func(t *testing.T) {
+		tampered := strings.Replace(link, "report.csv", "payroll.csv", 1)
+		assert.ErrorIs(t, signer.Verify(parse(t, tampered)), ErrInvalidSignature)
+	})
+
+	t.Run("TamperedQuery", func(t *testing.T) {
+		for _, tampered := range []string{
+			strings.Replace(link, "format=csv", "format=xlsx", 1),
+			link + "&extra=1",
+			strings.Replace(link, "expires=", "expires=9", 1),
+		} {
+			assert.ErrorIs(t, signer.Verify(parse(t, tampered)), ErrInvalidSignature, tampered)
+		}
+	})
+
+	t.Run("OtherSecret", func(t *testing.T) {
+		assert.ErrorIs(t, New(testSecret+"x").Verify(parse(t, link)), ErrInvalidSignature)
+	})
+}
+
+func TestGenerateSignedURLRejects(t *testing.T) {
+	signer := New(testSecret)
+
+	for _, path := range []string{"/api/v1/profile", "/files/../api/v1/profile", "https://evil.example/files/x", "files/x", ""} {
+		_, _, err := signer.GenerateSignedURL(path, time.Hour)
+		assert.ErrorIs(t, err, ErrInvalidPath, path)
+	}
+	for _, expiry := range []time.Duration{0, -time.Second, MaxExpiry + time.Second} {
+		_, _, err := signer.GenerateSignedURL("/files/x", expiry)
+		assert.ErrorIs(t, err, ErrInvalidExpiry, expiry.String())
+	}
+}
@@ -0,0 +1,172 @@
+package telemetry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// healthRoutes are polled by probes and Prometheus; a trace of one is rarely
+// worth its cost
+var healthRoutes = map[string]bool{
+	"/health":          true,
+	"/health/detailed": true,
+	"/ready":           true,
+	"/metrics":         true,
+}
+
+const (
+	// maxPendingTraces bounds the traces held waiting for their root span
+	maxPendingTraces = 4096
+	// maxPendingSpans bounds the spans held for one trace
+	maxPendingSpans = 512
+)
+
+// SamplingConfig picks which traces are exported
+type SamplingConfig struct {
+	Ratio       float64       // share of requests traced
+	HealthRatio float64       // share of health check and /metrics requests traced
+	SlowAfter   time.Duration // requests taking at least this long are always traced; 0 disables
+}
+
+// NewSampler decides at the start of a trace: sampled traces are exported
+// whole, as with the SDK's ratio sampler. Every other trace is still
+// recorded, so the processor from newTailProcessor can export it anyway
+// once its root span ends with an error or too slowly. A sampled parent,
+// local or from the caller, is always followed.
+func NewSampler(cfg SamplingConfig) trace.Sampler {
+	return routeSampler{
+		ratio:  trace.TraceIDRatioBased(cfg.Ratio),
+		health: trace.TraceIDRatioBased(cfg.HealthRatio),
+	}
+}
+
+type routeSampler struct {
+	ratio  trace.Sampler
+	health trace.Sampler
+}
+
+func (s routeSampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	parent := oteltrace.SpanContextFromContext(p.ParentContext)
+	result := trace.SamplingResult{Tracestate: parent.TraceState()}
+	switch {
+	case parent.IsSampled():
+		result.Decision = trace.RecordAndSample
+		return result
+	case parent.IsValid() && !parent.IsRemote():
+		// Part of a trace whose root decides it at the end
+		if oteltrace.SpanFromContext(p.ParentContext).IsRecording() {
+			result.Decision = trace.RecordOnly
+		}
+		return result
+	}
+
+	sampler := s.ratio
+	for _, attr := range p.Attributes {
+		if attr.Key == semconv.HTTPRouteKey && healthRoutes[attr.Value.AsString()] {
+			sampler = s.health
+		}
+	}
+	result = sampler.ShouldSample(p)
+	if result.Decision == trace.Drop {
+		result.Decision = trace.RecordOnly
+	}
+	return result
+}
+
+func (s routeSampler) Description() string {
+	return "RouteSampler{" + s.ratio.Description() + "," + s.health.Description() + "}"
+}
+
+// tailProcessor passes sampled spans on to next. Spans of traces the sampler
+// only recorded are held until their local root ends, then passed on as
+// sampled if the root failed or took at least slowAfter, and dropped
+// otherwise. A trace is held from its root's start to its end, so spans
+// ending after their root find nothing to join and are dropped.
+type tailProcessor struct {
+	next      trace.SpanProcessor
+	slowAfter time.Duration
+
+	mu      sync.Mutex
+	pending map[oteltrace.TraceID][]trace.ReadOnlySpan // by local root still running
+}
+
+func newTailProcessor(next trace.SpanProcessor, slowAfter time.Duration) *tailProcessor {
+	return &tailProcessor{next: next, slowAfter: slowAfter, pending: make(map[oteltrace.TraceID][]trace.ReadOnlySpan)}
+}
+
+func (p *tailProcessor) OnStart(parent context.Context, s trace.ReadWriteSpan) {
+	if !s.SpanContext().IsSampled() && isLocalRoot(s) {
+		p.mu.Lock()
+		if len(p.pending) < maxPendingTraces {
+			p.pending[s.SpanContext().TraceID()] = nil
+		}
+		p.mu.Unlock()
+	}
+	p.next.OnStart(parent, s)
+}
+
+func (p *tailProcessor) OnEnd(s trace.ReadOnlySpan) {
+	if s.SpanContext().IsSampled() {
+		p.next.OnEnd(s)
+		return
+	}
+
+	traceID := s.SpanContext().TraceID()
+	p.mu.Lock()
+	if !isLocalRoot(s) {
+		// No entry means the root has ended, or its trace didn't fit
+		if held, ok := p.pending[traceID]; ok && len(held) < maxPendingSpans {
+			p.pending[traceID] = append(held, s)
+		}
+		p.mu.Unlock()
+		return
+	}
+	held := p.pending[traceID]
+	delete(p.pending, traceID)
+	p.mu.Unlock()
+
+	if !p.interesting(s) {
+		return
+	}
+	for _, span := range held {
+		p.next.OnEnd(sampledSpan{span})
+	}
+	p.next.OnEnd(sampledSpan{s})
+}
+
+// isLocalRoot reports whether s starts this process's part of its trace
+func isLocalRoot(s trace.ReadOnlySpan) bool {
+	return !s.Parent().IsValid() || s.Parent().IsRemote()
+}
+
+// interesting reports whether a root span's trace is worth exporting
+func (p *tailProcessor) interesting(root trace.ReadOnlySpan) bool {
+	if root.Status().Code == codes.Error {
+		return true
+	}
+	return p.slowAfter > 0 && root.EndTime().Sub(root.StartTime()) >= p.slowAfter
+}
+
+func (p *tailProcessor) Shutdown(ctx context.Context) error {
+	return p.next.Shutdown(ctx)
+}
+
+func (p *tailProcessor) ForceFlush(ctx context.Context) error {
+	return p.next.ForceFlush(ctx)
+}
+
+// sampledSpan is a recorded span marked sampled, so exporters accept it
+type sampledSpan struct {
+	trace.ReadOnlySpan
+}
+
+func (s sampledSpan) SpanContext() oteltrace.SpanContext {
+	sc := s.ReadOnlySpan.SpanContext()
+	return sc.WithTraceFlags(sc.TraceFlags().WithSampled(true))
+}
@@ -0,0 +1,103 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSampler(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := newTracerProvider(trace.NewSimpleSpanProcessor(exporter), SamplingConfig{
+		Ratio:       0,
+		HealthRatio: 0.01,
+		SlowAfter:   50 * time.Millisecond,
+	})
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	router := mux.NewRouter()
+	router.Use(otelmux.Middleware("test", otelmux.WithTracerProvider(tp)))
+	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+	router.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {})
+	router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(60 * time.Millisecond)
+	})
+	router.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		_, span := tp.Tracer("test").Start(r.Context(), "db.query")
+		span.End()
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	serve := func(path string) []tracetest.SpanStub {
+		exporter.Reset()
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		return exporter.GetSpans()
+	}
+
+	t.Run("ErrorsSampled", func(t *testing.T) {
+		spans := serve("/fail")
+		require.Len(t, spans, 2, "the request and its child")
+		assert.Equal(t, "db.query", spans[0].Name)
+		assert.Equal(t, "GET /fail", spans[1].Name)
+		for _, span := range spans {
+			assert.True(t, span.SpanContext.IsSampled())
+			assert.Equal(t, spans[1].SpanContext.TraceID(), span.SpanContext.TraceID())
+		}
+	})
+
+	t.Run("SlowSampled", func(t *testing.T) {
+		assert.Len(t, serve("/slow"), 1)
+	})
+
+	t.Run("FastNotSampled", func(t *testing.T) {
+		assert.Empty(t, serve("/fast"))
+	})
+
+	t.Run("HealthChecksRarelySampled", func(t *testing.T) {
+		sampled := 0
+		for i := 0; i < 200; i++ {
+			sampled += len(serve("/health"))
+		}
+		// 1% of 200 is 2; 20 or more is practically impossible
+		assert.Less(t, sampled, 20)
+	})
+
+	t.Run("SampledParentFollowed", func(t *testing.T) {
+		parent := newTracerProvider(trace.NewSimpleSpanProcessor(tracetest.NewInMemoryExporter()), SamplingConfig{Ratio: 1})
+		ctx, span := parent.Tracer("test").Start(context.Background(), "caller")
+		defer span.End()
+
+		_, child := tp.Tracer("test").Start(ctx, "fast")
+		assert.True(t, child.SpanContext().IsSampled())
+		child.End()
+	})
+}
+
+func TestTailProcessorSpanAfterRoot(t *testing.T) {
+	// A span outliving its root, such as background work started by a
+	// request, is dropped without leaving its trace held forever
+	exporter := tracetest.NewInMemoryExporter()
+	tail := newTailProcessor(trace.NewSimpleSpanProcessor(exporter), time.Hour)
+	tp := trace.NewTracerProvider(trace.WithSampler(NewSampler(SamplingConfig{})), trace.WithSpanProcessor(tail))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	ctx, root := tp.Tracer("test").Start(context.Background(), "request")
+	_, child := tp.Tracer("test").Start(ctx, "background")
+	require.False(t, root.SpanContext().IsSampled())
+
+	root.End()
+	child.End()
+
+	assert.Empty(t, exporter.GetSpans())
+	tail.mu.Lock()
+	defer tail.mu.Unlock()
+	assert.Empty(t, tail.pending)
+}
@@ -3,24 +3,45 @@ package telemetry
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"time"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.37.0" // Use the latest appropriate version
 )
 
+// Exporter protocols
+const (
+	ProtocolHTTP = "http"
+	ProtocolGRPC = "grpc"
+)
+
+// ExporterConfig selects and configures the OTLP trace exporter
+type ExporterConfig struct {
+	Endpoint string            // host:port of the collector
+	Protocol string            // ProtocolHTTP (the default) or ProtocolGRPC
+	Insecure bool              // plaintext instead of TLS
+	Headers  map[string]string // sent with every export, e.g. collector credentials
+}
+
 // InitTracerProvider initializes and returns a new OpenTelemetry TracerProvider.
-func InitTracerProvider(endpoint string) (*trace.TracerProvider, error) {
+// sampling picks which traces reach the exporter. With no endpoint tracing is
+// off: the provider records nothing and the global one stays a no-op.
+func InitTracerProvider(cfg ExporterConfig, sampling SamplingConfig) (*trace.TracerProvider, error) {
 	ctx := context.Background()
 
-	exporter, err := otlptracehttp.New(ctx,
-		otlptracehttp.WithEndpoint(endpoint), // Now uses the injected variable
-		otlptracehttp.WithInsecure(),
-	)
+	if cfg.Endpoint == "" {
+		log.Printf("OpenTelemetry tracing disabled: no OTLP endpoint configured")
+		return trace.NewTracerProvider(trace.WithSampler(trace.NeverSample())), nil
+	}
+
+	exporter, err := NewExporter(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -40,14 +61,57 @@ func InitTracerProvider(endpoint string) (*trace.TracerProvider, error) {
 	}
 
 	// Create the TracerProvider
-	tp := trace.NewTracerProvider(
-		trace.WithBatcher(exporter, trace.WithBatchTimeout(time.Second)),
+	tp := newTracerProvider(trace.NewBatchSpanProcessor(exporter, trace.WithBatchTimeout(time.Second)), sampling,
 		trace.WithResource(res),
 	)
 
 	// Set the global TracerProvider
 	otel.SetTracerProvider(tp)
 
-	log.Println("OpenTelemetry TracerProvider initialized, sending to http://tempo:4318")
+	log.Printf("OpenTelemetry TracerProvider initialized, sending OTLP/%s to %s (insecure=%t)", protocolName(cfg.Protocol), cfg.Endpoint, cfg.Insecure)
 	return tp, nil
 }
+
+// newTracerProvider builds a provider sending the traces sampling selects
+// to processor
+func newTracerProvider(processor trace.SpanProcessor, sampling SamplingConfig, opts ...trace.TracerProviderOption) *trace.TracerProvider {
+	opts = append(opts,
+		trace.WithSampler(NewSampler(sampling)),
+		trace.WithSpanProcessor(newTailProcessor(processor, sampling.SlowAfter)),
+	)
+	return trace.NewTracerProvider(opts...)
+}
+
+// NewExporter builds the OTLP trace exporter cfg describes. Neither protocol
+// connects here; export failures surface when spans are sent.
+func NewExporter(ctx context.Context, cfg ExporterConfig) (*otlptrace.Exporter, error) {
+	switch protocolName(cfg.Protocol) {
+	case ProtocolGRPC:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracegrpc.WithHeaders(cfg.Headers))
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case ProtocolHTTP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+		}
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q", cfg.Protocol)
+	}
+}
+
+func protocolName(protocol string) string {
+	if protocol == "" {
+		return ProtocolHTTP
+	}
+	return protocol
+}
@@ -0,0 +1,119 @@
+package telemetry
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// grpcCollector is an OTLP/gRPC trace collector on a plaintext listener. It
+// records the metadata of every export it receives.
+type grpcCollector struct {
+	coltracepb.UnimplementedTraceServiceServer
+	received chan metadata.MD
+}
+
+func (c *grpcCollector) Export(ctx context.Context, _ *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	c.received <- md
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+func startGRPCCollector(t *testing.T) (string, *grpcCollector) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	collector := &grpcCollector{received: make(chan metadata.MD, 4)}
+	srv := grpc.NewServer()
+	coltracepb.RegisterTraceServiceServer(srv, collector)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String(), collector
+}
+
+// export sends one span through an exporter built from cfg
+func export(t *testing.T, cfg ExporterConfig) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	exporter, err := NewExporter(ctx, cfg)
+	require.NoError(t, err)
+	defer exporter.Shutdown(context.Background())
+	return exporter.ExportSpans(ctx, tracetest.SpanStubs{{Name: "test-span"}}.Snapshots())
+}
+
+func TestGRPCExporter(t *testing.T) {
+	endpoint, collector := startGRPCCollector(t)
+
+	t.Run("InsecureExports", func(t *testing.T) {
+		err := export(t, ExporterConfig{
+			Endpoint: endpoint, Protocol: ProtocolGRPC, Insecure: true,
+			Headers: map[string]string{"x-api-key": "secret"},
+		})
+		require.NoError(t, err)
+
+		select {
+		case md := <-collector.received:
+			assert.Equal(t, []string{"secret"}, md.Get("x-api-key"))
+		case <-time.After(time.Second):
+			t.Fatal("collector received nothing")
+		}
+	})
+
+	t.Run("TLSRefusesPlaintextCollector", func(t *testing.T) {
+		err := export(t, ExporterConfig{Endpoint: endpoint, Protocol: ProtocolGRPC, Insecure: false})
+		assert.Error(t, err)
+		assert.Empty(t, collector.received)
+	})
+}
+
+func TestHTTPExporter(t *testing.T) {
+	received := make(chan *http.Request, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		received <- r
+	}))
+	defer srv.Close()
+	endpoint := strings.TrimPrefix(srv.URL, "http://")
+
+	t.Run("DefaultProtocolIsHTTP", func(t *testing.T) {
+		require.NoError(t, export(t, ExporterConfig{
+			Endpoint: endpoint, Insecure: true,
+			Headers: map[string]string{"Authorization": "Bearer token"},
+		}))
+		r := <-received
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+	})
+
+	t.Run("TLSRefusesPlaintextCollector", func(t *testing.T) {
+		assert.Error(t, export(t, ExporterConfig{Endpoint: endpoint, Protocol: ProtocolHTTP}))
+		assert.Empty(t, received)
+	})
+}
+
+func TestUnknownProtocol(t *testing.T) {
+	_, err := NewExporter(context.Background(), ExporterConfig{Endpoint: "localhost:4317", Protocol: "thrift"})
+	assert.Error(t, err)
+}
+
+func TestTracingDisabled(t *testing.T) {
+	tp, err := InitTracerProvider(ExporterConfig{}, SamplingConfig{Ratio: 1})
+	require.NoError(t, err)
+	_, span := tp.Tracer("test").Start(context.Background(), "request")
+	assert.False(t, span.IsRecording())
+	span.End()
+	assert.NoError(t, tp.Shutdown(context.Background()))
+}
@@ -0,0 +1,83 @@
+// Package username normalizes usernames so that names a person would read as
+// the same cannot be registered twice.
+//
+// Three forms are used:
+//   - Display: NFC. What the user typed, stored and shown as-is.
+//   - Normalize: NFC plus case folding. Unique in the database and used for
+//     lookups, so "Ａlice", "ALICE" and "alice" are one account.
+//   - Skeleton: Normalize plus compatibility decomposition and a table of
+//     cross-script lookalikes, after Unicode TS #39. Two names with the same
+//     skeleton look alike; registering the second is refused when the
+//     confusable policy is on.
+package username
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+var folder = cases.Fold()
+
+// Display returns the NFC form of name, which is what gets stored and shown
+func Display(name string) string {
+	return norm.NFC.String(name)
+}
+
+// Normalize returns the case-folded NFC form used for uniqueness and lookups
+func Normalize(name string) string {
+	return norm.NFC.String(folder.String(norm.NFC.String(name)))
+}
+
+// Skeleton maps name to a form in which visually confusable names compare equal
+func Skeleton(name string) string {
+	decomposed := norm.NFKD.String(Normalize(name))
+	var b strings.Builder
+	b.Grow(len(decomposed))
+	for _, r := range decomposed {
+		if m, ok := confusables[r]; ok {
+			r = m
+		}
+		b.WriteRune(r)
+	}
+	return norm.NFC.String(b.String())
+}
+
+// Valid reports whether name, once in NFC, is made only of letters, decimal
+// digits and combining marks, and does not start with a mark
+func Valid(name string) bool {
+	name = Display(name)
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r), unicode.Is(unicode.Nd, r):
+		case unicode.IsMark(r) && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// confusables maps lowercase characters to the Latin letter or digit they
+// are commonly mistaken for. Skeleton folds case first, so only lowercase
+// forms are listed. It covers the scripts most used for spoofing Latin names
+// (Cyrillic, Greek, Armenian) plus the digit/letter pairs 0/o and 1/l.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'һ': 'h',
+	'і': 'i', 'ј': 'j', 'к': 'k', 'ӏ': 'l', 'м': 'm', 'н': 'h',
+	'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'т': 't', 'у': 'y', 'ү': 'y',
+	'ԝ': 'w', 'х': 'x', 'ь': 'b',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w', 'ζ': 'z',
+	// Armenian
+	'օ': 'o', 'ս': 'u', 'հ': 'h', 'ց': 'g', 'ք': 'f',
+	// Latin lookalikes and digits
+	'ı': 'i', 'ɩ': 'i', 'ɡ': 'g', 'ł': 'l', '0': 'o', '1': 'l',
+}
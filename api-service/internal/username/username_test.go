@@ -0,0 +1,39 @@
+package username
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForms(t *testing.T) {
+	t.Run("NormalizeFoldsCaseAndComposition", func(t *testing.T) {
+		assert.Equal(t, "josé", Normalize("JOSE\u0301"))
+		assert.Equal(t, Normalize("josé"), Normalize("jose\u0301"))
+		assert.NotEqual(t, Normalize("paypal"), Normalize("p\u0430yp\u0430l"), "cross-script names stay distinct without the confusable policy")
+	})
+
+	t.Run("SkeletonMatchesLookalikes", func(t *testing.T) {
+		cases := map[string]string{
+			"p\u0430yp\u0430l": "paypal", // Cyrillic а
+			"\u0410DMIN":       "admin",  // Cyrillic А
+			"ѕсоре":            "scope",  // all Cyrillic
+			"αpple":            "apple",  // Greek alpha
+			"ｂｏｂ":              "bob",    // fullwidth
+			"adm1n":            "admln",  // digit one
+		}
+		for spoof, real := range cases {
+			assert.Equal(t, Skeleton(real), Skeleton(spoof), spoof)
+		}
+		assert.NotEqual(t, Skeleton("alice"), Skeleton("alicia"))
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		for _, name := range []string{"alice", "Jose\u0301", "иван", "山田太郎", "user42"} {
+			assert.True(t, Valid(name), name)
+		}
+		for _, name := range []string{"", "bob smith", "bob_smith", "\u0301bob", "ali\u200dce", "bob!"} {
+			assert.False(t, Valid(name), name)
+		}
+	})
+}
@@ -2,11 +2,16 @@
 package validation
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 
+	"azlo-goboiler/internal/username"
+
 	"github.com/go-playground/validator/v10"
 	"github.com/microcosm-cc/bluemonday"
 )
@@ -22,6 +27,7 @@ func init() {
 	// Register custom validators
 	validate.RegisterValidation("password", validatePassword)
 	validate.RegisterValidation("alphanum", validateAlphaNum)
+	validate.RegisterValidation("username", validateUsername)
 
 	// Initialize our HTML sanitizer policy
 	// StrictPolicy() strips all HTML tags.
@@ -44,6 +50,44 @@ func ValidateStruct(s interface{}) error {
 	return fmt.Errorf("validation failed: %s", strings.Join(errorMessages, "; "))
 }
 
+// FieldError reports one request field that failed a check
+type FieldError struct {
+	Field   string `json:"field"`
+	Max     int    `json:"max"`
+	Message string `json:"message"`
+}
+
+// LengthErrors validates the struct s points to and returns the fields over
+// their max= length, named as in the JSON body. validator counts characters,
+// as the database does. Other failures are left to ValidateStruct; put max=
+// before checks such as oneof or email so a long value is reported as such.
+func LengthErrors(s interface{}) []FieldError {
+	var invalid validator.ValidationErrors
+	if !errors.As(validate.Struct(s), &invalid) {
+		return nil
+	}
+	t := reflect.Indirect(reflect.ValueOf(s)).Type()
+
+	var fields []FieldError
+	for _, fe := range invalid {
+		if fe.Tag() != "max" {
+			continue
+		}
+		max, _ := strconv.Atoi(fe.Param())
+		name := strings.ToLower(fe.Field())
+		if f, ok := t.FieldByName(fe.StructField()); ok {
+			if tag, _, _ := strings.Cut(f.Tag.Get("json"), ","); tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, FieldError{
+			Field: name, Max: max,
+			Message: fmt.Sprintf("%s must not exceed %d characters", name, max),
+		})
+	}
+	return fields
+}
+
 // getErrorMessage returns a user-friendly error message for validation errors
 func getErrorMessage(fe validator.FieldError) string {
 	field := strings.ToLower(fe.Field())
@@ -57,7 +101,7 @@ func getErrorMessage(fe validator.FieldError) string {
 		return fmt.Sprintf("%s must be at least %s characters long", field, fe.Param())
 	case "max":
 		return fmt.Sprintf("%s must not exceed %s characters", field, fe.Param())
-	case "alphanum":
+	case "alphanum", "username":
 		return fmt.Sprintf("%s must contain only letters and numbers", field)
 	case "password":
 		return fmt.Sprintf("%s must contain at least one uppercase letter, one lowercase letter, one number, and one special character", field)
@@ -104,6 +148,11 @@ func validateAlphaNum(fl validator.FieldLevel) bool {
 	return alphaNumRegex.MatchString(str)
 }
 
+// validateUsername accepts letters and digits from any script
+func validateUsername(fl validator.FieldLevel) bool {
+	return username.Valid(fl.Field().String())
+}
+
 // ValidateEmail validates email format with additional checks
 func ValidateEmail(email string) bool {
 	if len(email) > 254 {
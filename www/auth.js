@@ -69,9 +69,23 @@ function hideError() {
     }
 }
 
+// Messages for the error codes the Google sign-in callback redirects with
+const oauthErrors = {
+    oauth_denied: 'Google sign-in was cancelled.',
+    oauth_state: 'Your Google sign-in expired. Please try again.',
+    email_unverified: 'Your Google account email is not verified.',
+    link_unverified: 'An account with this email exists. Sign in with your password and verify your email first.',
+    unavailable: 'Sign-in is temporarily unavailable. Please try again shortly.',
+};
+
 // Login form handler
 const loginForm = document.getElementById('loginForm');
 if (loginForm) {
+    const oauthError = new URLSearchParams(window.location.search).get('error');
+    if (oauthError) {
+        showError(oauthErrors[oauthError] || 'Google sign-in failed. Please try again.');
+    }
+
     loginForm.addEventListener('submit', async (e) => {
         e.preventDefault();
         hideError();